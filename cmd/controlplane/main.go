@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/julianshen/mycelium/internal/audit"
+	"github.com/julianshen/mycelium/internal/controlplane"
+	"github.com/julianshen/mycelium/internal/function"
+	"github.com/julianshen/mycelium/internal/namespace"
+	"github.com/julianshen/mycelium/internal/naming"
+	"github.com/julianshen/mycelium/internal/trigger"
+
+	"github.com/nats-io/nats.go"
+)
+
+func main() {
+	// Parse command line flags
+	natsURL := flag.String("nats-url", "nats://localhost:4222", "NATS server URL")
+	triggerBucket := flag.String("stream", "config-stream", "KV bucket holding trigger definitions, as for triggerd and triggerctl")
+	functionBucket := flag.String("function-bucket", function.DefaultFunctionBucket, "KV bucket holding function metadata")
+	binaryBucket := flag.String("binary-bucket", function.DefaultBinaryBucket, "Object store holding function binaries")
+	policyFile := flag.String("policy", "", "YAML file with the RBAC policy (default: RBAC disabled, every request is anonymous)")
+	auditBucket := flag.String("audit-bucket", controlplane.DefaultAuditBucket, "KV bucket changes are recorded in")
+	name := flag.String("name", controlplane.DefaultServiceName, "Name of the NATS micro service")
+	subjectPrefix := flag.String("subject-prefix", controlplane.DefaultSubjectPrefix, "Prefix of the endpoint subjects")
+	namespaceBucket := flag.String("namespace-bucket", namespace.DefaultBucket, "KV bucket of provisioned namespaces whose trigger policies are enforced, as for triggerctl (empty disables policies)")
+	changelogStream := flag.String("changelog", trigger.DefaultChangelogStream, "JetStream stream recording trigger changes, as for triggerctl (empty disables it)")
+	auditTrailBucket := flag.String("audit-trail", "", "KV bucket of the tamper-evident audit trail admin actions and registry changes are chained into (empty disables it)")
+	auditSigningKey := flag.String("audit-signing-key", "", "PEM file with the Ed25519 key audit trail checkpoints are signed with (empty disables checkpoints)")
+	checkpointInterval := flag.Duration("audit-checkpoint-interval", audit.DefaultCheckpointInterval, "Interval of signed audit trail checkpoints")
+	runtimeService := flag.String("runtime-service", controlplane.DefaultRuntimeService, "Service name of the runtime instances cordon requests address")
+	lifecycleSubject := flag.String("lifecycle-subject", function.DefaultLifecycleSubject, "NATS subject function deployments, deletions and promotions are announced on (empty disables)")
+	lifecycleWebhooks := flag.String("lifecycle-webhooks", "", "Comma-separated URLs function lifecycle events are posted to")
+	var names naming.Prefix
+	names.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	// Prefix the buckets, the service and its subjects so environments sharing a NATS
+	// cluster are managed separately
+	if err := names.Validate(); err != nil {
+		log.Fatalf("Invalid name prefix: %v", err)
+	}
+	names.Apply(triggerBucket, functionBucket, binaryBucket, auditBucket, name, subjectPrefix,
+		namespaceBucket, changelogStream, auditTrailBucket, runtimeService)
+
+	var policy *controlplane.Policy
+	if *policyFile != "" {
+		var err error
+		policy, err = controlplane.LoadPolicy(*policyFile)
+		if err != nil {
+			log.Fatalf("Failed to load policy: %v", err)
+		}
+		log.Printf("Loaded RBAC policy with %d principals", len(policy.Principals))
+	} else {
+		log.Printf("Warning: no --policy given, RBAC is disabled and every request is allowed")
+	}
+
+	// Connect to NATS
+	nc, err := nats.Connect(*natsURL)
+	if err != nil {
+		log.Fatalf("Failed to connect to NATS: %v", err)
+	}
+	defer nc.Close()
+
+	registry, err := function.NewNATSRegistryWithBuckets(nc, *functionBucket, *binaryBucket)
+	if err != nil {
+		log.Fatalf("Failed to create function registry: %v", err)
+	}
+
+	// Announce registry changes so CI systems and chat notifications need not poll
+	if *lifecycleSubject != "" || *lifecycleWebhooks != "" {
+		config := function.LifecycleConfig{Subject: *lifecycleSubject}
+		if *lifecycleWebhooks != "" {
+			config.Webhooks = strings.Split(*lifecycleWebhooks, ",")
+		}
+		registry.SetLifecycleNotifier(function.NewLifecycleNotifier(nc, config))
+	}
+
+	// Chain admin actions and registry changes into the audit trail, sealed by checkpoints
+	var trail *audit.Trail
+	if *auditTrailBucket != "" {
+		trail, err = audit.Open(nc, *auditTrailBucket)
+		if err != nil {
+			log.Fatalf("Failed to open audit trail: %v", err)
+		}
+		registry.SetAuditTrail(trail)
+		if *auditSigningKey != "" {
+			key, err := audit.LoadSigningKey(*auditSigningKey)
+			if err != nil {
+				log.Fatalf("Failed to load audit signing key: %v", err)
+			}
+			trail.SetSigningKey(key)
+			stop := make(chan struct{})
+			defer close(stop)
+			go trail.RunCheckpoints(*checkpointInterval, stop)
+		}
+	}
+
+	store, err := trigger.NewNATSStore(nc, *triggerBucket)
+	if err != nil {
+		log.Fatalf("Failed to create trigger store: %v", err)
+	}
+	defer store.Close()
+	if *changelogStream != "" {
+		changelog, err := trigger.NewChangelog(nc, *changelogStream, "")
+		if err != nil {
+			log.Fatalf("Failed to open trigger changelog: %v", err)
+		}
+		store.SetChangelog(changelog)
+	}
+	if *namespaceBucket != "" {
+		provisioner, err := namespace.NewProvisioner(nc, namespace.ProvisionerConfig{Bucket: *namespaceBucket, TriggerBucket: *triggerBucket, Prefix: names})
+		if err != nil {
+			log.Fatalf("Failed to open namespace policies: %v", err)
+		}
+		store.SetPolicies(provisioner)
+	}
+
+	// Keep the trigger index current with changes made outside the control plane
+	ctx := context.Background()
+	if err := store.LoadAll(ctx); err != nil {
+		log.Fatalf("Failed to load triggers: %v", err)
+	}
+	if err := store.Watch(ctx); err != nil {
+		log.Fatalf("Failed to watch triggers: %v", err)
+	}
+
+	service, err := controlplane.New(controlplane.Config{
+		Conn:           nc,
+		Registry:       registry,
+		Triggers:       store,
+		Policy:         policy,
+		AuditBucket:    *auditBucket,
+		AuditTrail:     trail,
+		Name:           *name,
+		SubjectPrefix:  *subjectPrefix,
+		RuntimeService: *runtimeService,
+	})
+	if err != nil {
+		log.Fatalf("Failed to start control plane: %v", err)
+	}
+	defer service.Stop()
+
+	log.Printf("Control plane serving on %s.>", *subjectPrefix)
+
+	// Wait for signal
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+	log.Printf("Shutting down...")
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/julianshen/mycelium/internal/function"
+
+	"github.com/nats-io/nats.go"
+)
+
+// defaultPlatforms are the platforms build targets without --platforms
+const defaultPlatforms = "linux/amd64,linux/arm64"
+
+// build cross-compiles a function project into a deployable bundle
+func build(args []string) error {
+	fs := flag.NewFlagSet("build", flag.ContinueOnError)
+	metaFile := fs.String("meta", "function.json", "Function metadata file, relative to the project (optional)")
+	name := fs.String("name", "", "Function name (default: metadata name, or the project directory name)")
+	version := fs.String("version", "", "Function version (default: metadata version)")
+	platforms := fs.String("platforms", defaultPlatforms, "Comma-separated os/arch platforms to build plugins for")
+	wasm := fs.Bool("wasm", false, "Also build a WebAssembly (wasip1) artifact")
+	oci := fs.Bool("oci", false, "Also package the plugins as a multi-platform image in OCI layout")
+	out := fs.String("out", "dist", "Directory the bundle is written to, as <name>-<version>")
+	pluginSecret := fs.String("plugin-secret", os.Getenv("MYCELIUM_PLUGIN_SECRET"), "Handshake value of the deployment the plugins are built for (default: $MYCELIUM_PLUGIN_SECRET)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return fmt.Errorf("usage: functionctl build [options] [project-dir]")
+	}
+	var ldflags string
+	if *pluginSecret != "" {
+		ldflags = "-X github.com/julianshen/mycelium/pkg/function.PluginSecret=" + *pluginSecret
+	}
+	project := "."
+	if fs.NArg() == 1 {
+		project = fs.Arg(0)
+	}
+	project, err := filepath.Abs(project)
+	if err != nil {
+		return err
+	}
+
+	meta := function.FunctionMeta{Type: "hashicorp-plugin"}
+	data, err := os.ReadFile(filepath.Join(project, *metaFile))
+	if err == nil {
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return fmt.Errorf("invalid metadata in %s: %w", *metaFile, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read metadata: %w", err)
+	}
+	if *name != "" {
+		meta.Name = *name
+	}
+	if meta.Name == "" {
+		meta.Name = filepath.Base(project)
+	}
+	if *version != "" {
+		meta.Version = *version
+	}
+	if meta.Version == "" {
+		return fmt.Errorf("no version for %s: set --version or version in %s", meta.Name, *metaFile)
+	}
+
+	bundle, err := function.NewBundle(filepath.Join(*out, meta.Name+"-"+meta.Version), meta)
+	if err != nil {
+		return err
+	}
+
+	for _, platform := range strings.Split(*platforms, ",") {
+		platform = strings.TrimSpace(platform)
+		goos, goarch, ok := strings.Cut(platform, "/")
+		if !ok || goos == "" || goarch == "" {
+			return fmt.Errorf("invalid platform %q (expected os/arch)", platform)
+		}
+		binary := meta.Name
+		if goos == "windows" {
+			binary += ".exe"
+		}
+		if err := buildArtifact(bundle, function.ArtifactPlugin, project, goos, goarch, ldflags, filepath.Join("plugins", goos+"_"+goarch, binary)); err != nil {
+			return err
+		}
+	}
+	if *wasm {
+		if err := buildArtifact(bundle, function.ArtifactWASM, project, "wasip1", "wasm", ldflags, filepath.Join("wasm", meta.Name+".wasm")); err != nil {
+			return err
+		}
+	}
+	if *oci {
+		artifact, err := bundle.WriteOCILayout("oci")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%-8s %-14s sha256:%s\n", artifact.Kind, "", artifact.Digest)
+	}
+
+	if err := bundle.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("\nBundle %s %s written to %s\n", meta.Name, meta.Version, bundle.Dir())
+	return nil
+}
+
+// buildArtifact compiles the project for a platform into path below the bundle
+func buildArtifact(bundle *function.Bundle, kind, project, goos, goarch, ldflags, path string) error {
+	output, err := filepath.Abs(filepath.Join(bundle.Dir(), path))
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("go", "build", "-trimpath", "-ldflags", ldflags, "-o", output, ".")
+	cmd.Dir = project
+	cmd.Env = append(os.Environ(), "GOOS="+goos, "GOARCH="+goarch, "CGO_ENABLED=0")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to build for %s/%s: %w", goos, goarch, err)
+	}
+
+	artifact, err := bundle.AddArtifact(kind, goos+"/"+goarch, path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%-8s %-14s sha256:%s\n", artifact.Kind, artifact.Platform, artifact.Digest)
+	return nil
+}
+
+// deploy stores the plugins of one or more bundles in a registry, all-or-nothing
+func deploy(args []string) error {
+	fs := flag.NewFlagSet("deploy", flag.ContinueOnError)
+	registryURL := fs.String("registry", nats.DefaultURL, "Registry URL")
+	platform := fs.String("platform", "linux/amd64", "Platform of the runtime fleet, whose plugin is deployed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: functionctl deploy [options] <bundle-dir>...")
+	}
+
+	deployments := make([]function.FunctionDeployment, 0, fs.NArg())
+	for _, dir := range fs.Args() {
+		bundle, err := function.LoadBundle(dir)
+		if err != nil {
+			return err
+		}
+		deployment, err := bundle.Deployment(*platform)
+		if err != nil {
+			return err
+		}
+		deployments = append(deployments, deployment)
+	}
+
+	registry, closeRegistry, err := openRegistry(*registryURL)
+	if err != nil {
+		return err
+	}
+	defer closeRegistry()
+
+	if err := registry.DeployFunctions(deployments); err != nil {
+		return err
+	}
+	for _, d := range deployments {
+		fmt.Printf("Deployed %s %s (%s, sha256:%s)\n", d.Meta.Name, d.Meta.Version, *platform, d.Meta.Digest)
+	}
+	return nil
+}
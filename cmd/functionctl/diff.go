@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/julianshen/mycelium/internal/function"
+	"github.com/julianshen/mycelium/internal/plan"
+
+	"github.com/nats-io/nats.go"
+)
+
+// diff prints the changes that deploying a directory of bundles would make to a registry
+func diff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	dir := fs.String("f", "", "Bundle directory, or a directory of bundle directories")
+	registryURL := fs.String("registry", nats.DefaultURL, "Registry URL")
+	platform := fs.String("platform", "linux/amd64", "Platform of the runtime fleet, whose plugin would be deployed")
+	asJSON := fs.Bool("json", false, "Print the plan as JSON")
+	detailedExitCode := fs.Bool("detailed-exitcode", false, "Exit 2 when there are changes, 0 when there are none")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return fmt.Errorf("usage: functionctl diff -f <dir> [options]")
+	}
+
+	bundles, err := function.LoadBundles(*dir)
+	if err != nil {
+		return err
+	}
+	desired := make(map[string]interface{}, len(bundles))
+	for _, b := range bundles {
+		meta := b.Meta
+		if meta.Digest, err = b.PluginDigest(*platform); err != nil {
+			return err
+		}
+		desired[meta.Name] = meta
+	}
+
+	registry, closeRegistry, err := openRegistry(*registryURL)
+	if err != nil {
+		return err
+	}
+	defer closeRegistry()
+
+	live, err := registry.ListFunctions()
+	if err != nil {
+		return err
+	}
+	current := make(map[string]interface{}, len(live))
+	for _, meta := range live {
+		// Registries that do not store binaries by content record no digest
+		if meta.Digest == "" {
+			_, binary, err := registry.GetFunction(meta.Name)
+			if err != nil {
+				return err
+			}
+			meta.Digest = function.BinaryDigest(binary)
+		}
+		current[meta.Name] = meta
+	}
+
+	p, err := plan.Compute("function", desired, current)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(p); err != nil {
+			return err
+		}
+	} else if err := p.WriteText(os.Stdout); err != nil {
+		return err
+	}
+	if *detailedExitCode && p.HasChanges() {
+		os.Exit(2)
+	}
+	return nil
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/function"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+)
+
+// volatileFields change on every invocation and are left out of response diffs
+var volatileFields = []string{"id", "time", "correlationid", "invocationid"}
+
+// invoke invokes a function once, or repeatedly in an interactive session
+func invoke(args []string) error {
+	fs := flag.NewFlagSet("invoke", flag.ContinueOnError)
+	natsURL := fs.String("nats-url", nats.DefaultURL, "NATS server URL")
+	eventType := fs.String("type", "functionctl.invoke", "Event type")
+	source := fs.String("source", "functionctl", "Event source")
+	data := fs.String("data", "{}", "Event data as JSON, or @file to read it from a file")
+	eventFile := fs.String("event", "", "JSON file with the CloudEvent to invoke with, replacing --type, --source and --data")
+	version := fs.String("version", "", "Version of the function to invoke (default: the current version)")
+	timeout := fs.Duration("timeout", 30*time.Second, "Invocation timeout")
+	group := fs.String("group", function.DefaultRuntimeGroup, "Runtime group the function is invoked on")
+	interactive := fs.Bool("interactive", false, "Compose events and invoke repeatedly, diffing responses")
+	local := fs.String("local", "", "Execute the function from this directory in-process instead of on the runtime, without NATS")
+	pluginSecret := fs.String("plugin-secret", os.Getenv("MYCELIUM_PLUGIN_SECRET"), "Handshake value local plugins are started with (default: $MYCELIUM_PLUGIN_SECRET)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: functionctl invoke [--interactive] [options] <function>")
+	}
+	if *local != "" && *version != "" {
+		return fmt.Errorf("--version cannot be used with --local")
+	}
+
+	payload, err := readData(*data)
+	if err != nil {
+		return err
+	}
+	var base *cloudevents.Event
+	if *eventFile != "" {
+		base, err = readEvent(*eventFile)
+		if err != nil {
+			return err
+		}
+		*eventType, *source = base.Type(), base.Source()
+		if payload, err = readData(string(base.Data())); err != nil {
+			return err
+		}
+	}
+
+	var client invoker
+	if *local != "" {
+		runtime, err := function.NewLocalRuntime(*local)
+		if err != nil {
+			return err
+		}
+		runtime.SetOutput(func(functionName, stream string) io.Writer { return os.Stderr })
+		runtime.SetPluginSecret(*pluginSecret)
+		defer runtime.Close()
+		client = runtime
+	} else {
+		natsClient, err := function.NewClient(function.ClientConfig{NATSURL: *natsURL, Timeout: *timeout, Group: namePrefix.Name(*group)})
+		if err != nil {
+			return err
+		}
+		defer natsClient.Close()
+		client = natsClient
+	}
+
+	s := &session{
+		client:    client,
+		name:      function.FunctionRef(fs.Arg(0), *version),
+		base:      base,
+		eventType: *eventType,
+		source:    *source,
+		data:      payload,
+		timeout:   *timeout,
+		out:       os.Stdout,
+	}
+	if !*interactive {
+		_, err := s.invoke()
+		return err
+	}
+	return s.run(os.Stdin)
+}
+
+// readData reads event data given inline or as @file and checks that it is JSON
+func readData(value string) (string, error) {
+	if file, ok := strings.CutPrefix(value, "@"); ok {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read event data: %w", err)
+		}
+		value = string(content)
+	}
+	if !json.Valid([]byte(value)) {
+		return "", fmt.Errorf("event data is not valid JSON")
+	}
+	return value, nil
+}
+
+// readEvent reads a CloudEvent from a JSON file. Events without data get empty data.
+func readEvent(file string) (*cloudevents.Event, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event: %w", err)
+	}
+	event := cloudevents.NewEvent()
+	if err := json.Unmarshal(content, &event); err != nil {
+		return nil, fmt.Errorf("invalid event in %s: %w", file, err)
+	}
+	if len(event.Data()) == 0 {
+		if err := event.SetData(cloudevents.ApplicationJSON, json.RawMessage("{}")); err != nil {
+			return nil, fmt.Errorf("failed to set event data: %w", err)
+		}
+	}
+	return &event, nil
+}
+
+// invoker invokes functions on the runtime or from a local directory
+type invoker interface {
+	InvokeFunction(ctx context.Context, name string, event *cloudevents.Event) ([]*cloudevents.Event, error)
+}
+
+// session is an interactive invocation loop
+type session struct {
+	client invoker
+	name   string
+	// base is the event read with --event, whose other attributes and extensions are kept
+	base      *cloudevents.Event
+	eventType string
+	source    string
+	data      string
+	timeout   time.Duration
+	out       io.Writer
+	previous  string
+}
+
+// run reads commands until quit or end of input
+func (s *session) run(in io.Reader) error {
+	fmt.Fprintf(s.out, "Invoking %s interactively. Commands:\n", s.name)
+	fmt.Fprintln(s.out, "  <enter>, send    Invoke with the current event")
+	fmt.Fprintln(s.out, "  edit             Edit the event data in $EDITOR")
+	fmt.Fprintln(s.out, "  data <json>      Replace the event data")
+	fmt.Fprintln(s.out, "  type <type>      Set the event type")
+	fmt.Fprintln(s.out, "  source <source>  Set the event source")
+	fmt.Fprintln(s.out, "  show             Print the current event")
+	fmt.Fprintln(s.out, "  quit             Leave the session")
+
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprintf(s.out, "%s> ", s.name)
+		if !scanner.Scan() {
+			fmt.Fprintln(s.out)
+			return scanner.Err()
+		}
+
+		command, arg, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		arg = strings.TrimSpace(arg)
+		switch command {
+		case "", "send":
+			response, err := s.invoke()
+			if err != nil {
+				fmt.Fprintf(s.out, "Error: %v\n", err)
+				continue
+			}
+			if s.previous != "" {
+				s.printDiff(s.previous, response)
+			}
+			s.previous = response
+
+		case "edit":
+			data, err := editData(s.data)
+			if err != nil {
+				fmt.Fprintf(s.out, "Error: %v\n", err)
+				continue
+			}
+			s.data = data
+
+		case "data":
+			data, err := readData(arg)
+			if err != nil {
+				fmt.Fprintf(s.out, "Error: %v\n", err)
+				continue
+			}
+			s.data = data
+
+		case "type":
+			s.eventType = arg
+
+		case "source":
+			s.source = arg
+
+		case "show":
+			event, _ := s.event()
+			printJSON(s.out, event)
+
+		case "quit", "exit":
+			return nil
+
+		default:
+			fmt.Fprintf(s.out, "Unknown command: %s\n", command)
+		}
+	}
+}
+
+// event builds the CloudEvent of the next invocation
+func (s *session) event() (*cloudevents.Event, error) {
+	event := cloudevents.NewEvent()
+	if s.base != nil {
+		event = s.base.Clone()
+	}
+	if event.ID() == "" {
+		event.SetID(uuid.NewString())
+	}
+	event.SetType(s.eventType)
+	event.SetSource(s.source)
+	if event.Time().IsZero() {
+		event.SetTime(time.Now())
+	}
+	if err := event.SetData(cloudevents.ApplicationJSON, json.RawMessage(s.data)); err != nil {
+		return nil, fmt.Errorf("failed to set event data: %w", err)
+	}
+	return &event, nil
+}
+
+// invoke sends the current event, prints the response and returns it normalized for diffing
+func (s *session) invoke() (string, error) {
+	event, err := s.event()
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	start := time.Now()
+	events, err := s.client.InvokeFunction(ctx, s.name, event)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(s.out, "%d event(s) in %s\n", len(events), time.Since(start).Round(time.Millisecond))
+	for _, e := range events {
+		printJSON(s.out, e)
+	}
+	return normalizeResponse(events)
+}
+
+// printDiff prints the lines that changed between two responses
+func (s *session) printDiff(previous, current string) {
+	if previous == current {
+		fmt.Fprintln(s.out, "Response unchanged from the previous invocation")
+		return
+	}
+	fmt.Fprintln(s.out, "Changes from the previous invocation:")
+	for _, line := range diffLines(strings.Split(previous, "\n"), strings.Split(current, "\n")) {
+		fmt.Fprintln(s.out, line)
+	}
+}
+
+// normalizeResponse renders response events as indented JSON without volatile fields
+func normalizeResponse(events []*cloudevents.Event) (string, error) {
+	var normalized []map[string]interface{}
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal response: %w", err)
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return "", fmt.Errorf("failed to decode response: %w", err)
+		}
+		for _, field := range volatileFields {
+			delete(fields, field)
+		}
+		normalized = append(normalized, fields)
+	}
+
+	data, err := json.MarshalIndent(normalized, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal response: %w", err)
+	}
+	return string(data), nil
+}
+
+// diffLines returns a line diff of a and b, prefixing removed lines with "-" and
+// added lines with "+"; unchanged lines are left out
+func diffLines(a, b []string) []string {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var diff []string
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, "- "+a[i])
+			i++
+		default:
+			diff = append(diff, "+ "+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, "- "+a[i])
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, "+ "+b[j])
+	}
+	return diff
+}
+
+// editData opens the event data in $EDITOR (default: vi) and returns the edited JSON
+func editData(data string) (string, error) {
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+
+	file, err := os.CreateTemp("", "functionctl-event-*.json")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	var indented strings.Builder
+	printJSON(&indented, json.RawMessage(data))
+	if _, err := file.WriteString(indented.String()); err != nil {
+		file.Close()
+		return "", fmt.Errorf("failed to write temp file: %w", err)
+	}
+	file.Close()
+
+	// The editor may carry arguments, e.g. "code --wait"
+	parts := strings.Fields(editor)
+	cmd := exec.Command(parts[0], append(parts[1:], file.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("editor failed: %w", err)
+	}
+
+	edited, err := os.ReadFile(file.Name())
+	if err != nil {
+		return "", fmt.Errorf("failed to read edited data: %w", err)
+	}
+	return readData(strings.TrimSpace(string(edited)))
+}
+
+// printJSON prints a value as indented JSON
+func printJSON(w io.Writer, value interface{}) {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		fmt.Fprintf(w, "Error: %v\n", err)
+		return
+	}
+	fmt.Fprintln(w, string(data))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/function"
+
+	"github.com/nats-io/nats.go"
+)
+
+// logs prints the recent output of a function's plugin processes, optionally following it
+func logs(args []string) error {
+	fs := flag.NewFlagSet("logs", flag.ContinueOnError)
+	natsURL := fs.String("nats-url", nats.DefaultURL, "NATS server URL")
+	service := fs.String("service", "function-runtime", "Runtime service name")
+	lines := fs.Int("lines", 100, "Number of recent lines to print (0 prints all buffered lines)")
+	follow := fs.Bool("follow", false, "Keep printing new lines until interrupted")
+	timeout := fs.Duration("timeout", 2*time.Second, "How long to wait for runtime instances to answer")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: functionctl logs [--lines N] [--follow] <function>")
+	}
+	name := fs.Arg(0)
+
+	nc, err := nats.Connect(*natsURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	defer nc.Close()
+
+	// Subscribe before fetching so no line falls between the buffered and the live ones
+	var live *nats.Subscription
+	if *follow {
+		live, err = nc.SubscribeSync(function.FunctionLogSubject(name))
+		if err != nil {
+			return fmt.Errorf("failed to follow logs: %w", err)
+		}
+		defer live.Unsubscribe()
+	}
+
+	recent, err := function.FetchFunctionLogs(nc, namePrefix.Name(*service), name, *lines, *timeout)
+	if err != nil {
+		return err
+	}
+	var last time.Time
+	for _, line := range recent {
+		printLogLine(os.Stdout, line)
+		last = line.Time
+	}
+	if live == nil {
+		return nil
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	for {
+		msg, err := live.NextMsgWithContext(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to receive log line: %w", err)
+		}
+		var line function.LogLine
+		if err := json.Unmarshal(msg.Data, &line); err != nil {
+			continue
+		}
+		// Lines already printed from the buffers
+		if !line.Time.After(last) {
+			continue
+		}
+		printLogLine(os.Stdout, line)
+	}
+}
+
+// printLogLine prints a log line with its time, stream and invocations
+func printLogLine(w io.Writer, line function.LogLine) {
+	invocations := "-"
+	if len(line.InvocationIDs) > 0 {
+		invocations = strings.Join(line.InvocationIDs, ",")
+	}
+	fmt.Fprintf(w, "%s %s %s %s\n", line.Time.Format(time.RFC3339Nano), line.Stream, invocations, line.Message)
+}
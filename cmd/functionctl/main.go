@@ -0,0 +1,271 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+
+	audittrail "github.com/julianshen/mycelium/internal/audit"
+	"github.com/julianshen/mycelium/internal/function"
+	"github.com/julianshen/mycelium/internal/naming"
+
+	"github.com/nats-io/nats.go"
+)
+
+// namePrefix is the tenant and environment prefixed to bucket and service names
+var namePrefix naming.Prefix
+
+// lifecycle configures the events announcing changes to nats registries
+var lifecycle function.LifecycleConfig
+
+func main() {
+	namePrefix.RegisterFlags(flag.CommandLine)
+	flag.StringVar(&lifecycle.Subject, "lifecycle-subject", function.DefaultLifecycleSubject, "NATS subject registry changes are announced on (empty disables)")
+	webhooks := flag.String("lifecycle-webhooks", "", "Comma-separated URLs registry change events are posted to")
+	flag.Parse()
+	if *webhooks != "" {
+		lifecycle.Webhooks = strings.Split(*webhooks, ",")
+	}
+	if err := namePrefix.Validate(); err != nil {
+		log.Fatalf("Invalid name prefix: %v", err)
+	}
+
+	// Get subcommand
+	args := flag.Args()
+	if len(args) == 0 {
+		fmt.Println("Usage: functionctl <command> [options]")
+		fmt.Println("\nCommands:")
+		fmt.Println("  build [--platforms os/arch,...] [--wasm] [--oci] [dir]  Build a function project into a deployable bundle")
+		fmt.Println("  deploy [--registry <registry>] <bundle>... Deploy built bundles, all-or-nothing")
+		fmt.Println("  push --version <v> [--name <n>] <binary>   Store a compiled function binary with its metadata")
+		fmt.Println("  list [--json]                              List the functions of a registry")
+		fmt.Println("  get [--output <file>] <function>           Download a function's binary")
+		fmt.Println("  describe <function>                        Print a function's metadata and binary digest")
+		fmt.Println("  delete <function>                          Delete a function from a registry")
+		fmt.Println("  diff -f <dir> [--registry <registry>]     Show the changes deploying bundles would make")
+		fmt.Println("  migrate --from <registry> --to <registry>  Copy all functions between registry backends")
+		fmt.Println("  schema put <event-type> <file>             Register the JSON Schema of an event type's data")
+		fmt.Println("  schema list                                List event types with a schema")
+		fmt.Println("  codegen [event-type...]                    Generate Go types for event data schemas")
+		fmt.Println("  invoke [--event <file>] [--interactive] [--local dir] <function>  Invoke a function, or open an invocation REPL")
+		fmt.Println("  logs [--lines N] [--follow] <function>     Print the recent output of a function's plugin processes")
+		fmt.Println("  usage <registry> | --namespaces            Report registry storage usage and quotas")
+		fmt.Println("  quota [--max-functions N] [--max-binary-bytes N] <registry>  Set a registry's storage quota")
+		fmt.Println("  verify [--mirror <registry>] <registry>    Re-hash stored binaries, restoring corrupt ones from a mirror")
+		fmt.Println("  versions <function>                        List retained versions and what the fleet serves")
+		fmt.Println("  pin [--instance <id>] <function> <version> Pin the fleet, or one instance, to a version")
+		fmt.Println("  unpin [--instance <id>] <function>         Make a function follow the registry again")
+		fmt.Println("  rollback [--to <version>] <function>       Roll the registry and the fleet back to a version")
+		fmt.Println("  retire <function> <version>                Stop keeping a version and release its binary")
+		fmt.Println("  audit [function]                           Show the version change audit log")
+		fmt.Println("  profile --instance <id> <kind>             Fetch a Go profile or the runtime metrics of an instance")
+		fmt.Println("  secret                                     Generate a plugin handshake value for a deployment")
+		fmt.Println("\nOptions:")
+		fmt.Println("  --tenant, --environment  Prefix of bucket and service names (default: $MYCELIUM_TENANT, $MYCELIUM_ENVIRONMENT)")
+		fmt.Println("  --lifecycle-subject      Subject registry changes are announced on (default: functions.lifecycle, empty disables)")
+		fmt.Println("  --lifecycle-webhooks     Comma-separated URLs registry change events are posted to")
+		fmt.Println("\nRegistries:")
+		fmt.Println("  nats://host:4222[?bucket=functions&binaries=function-binaries&audit=audit-trail]")
+		fmt.Println("  file:///path/to/directory")
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "build":
+		if err := build(args[1:]); err != nil {
+			log.Fatalf("Build failed: %v", err)
+		}
+	case "deploy":
+		if err := deploy(args[1:]); err != nil {
+			log.Fatalf("Deployment failed: %v", err)
+		}
+	case "push":
+		if err := push(args[1:]); err != nil {
+			log.Fatalf("Push failed: %v", err)
+		}
+	case "list":
+		if err := list(args[1:]); err != nil {
+			log.Fatalf("Listing functions failed: %v", err)
+		}
+	case "get":
+		if err := get(args[1:]); err != nil {
+			log.Fatalf("Download failed: %v", err)
+		}
+	case "describe":
+		if err := describe(args[1:]); err != nil {
+			log.Fatalf("Describe failed: %v", err)
+		}
+	case "delete":
+		if err := remove(args[1:]); err != nil {
+			log.Fatalf("Deletion failed: %v", err)
+		}
+	case "diff":
+		if err := diff(args[1:]); err != nil {
+			log.Fatalf("Diff failed: %v", err)
+		}
+	case "migrate":
+		if err := migrate(args[1:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+	case "schema":
+		if err := schema(args[1:]); err != nil {
+			log.Fatalf("Schema command failed: %v", err)
+		}
+	case "codegen":
+		if err := codegen(args[1:]); err != nil {
+			log.Fatalf("Code generation failed: %v", err)
+		}
+	case "invoke":
+		if err := invoke(args[1:]); err != nil {
+			log.Fatalf("Invocation failed: %v", err)
+		}
+	case "usage":
+		if err := usage(args[1:]); err != nil {
+			log.Fatalf("Usage report failed: %v", err)
+		}
+	case "quota":
+		if err := quota(args[1:]); err != nil {
+			log.Fatalf("Setting quota failed: %v", err)
+		}
+	case "verify":
+		if err := verify(args[1:]); err != nil {
+			log.Fatalf("Verification failed: %v", err)
+		}
+	case "versions":
+		if err := versions(args[1:]); err != nil {
+			log.Fatalf("Listing versions failed: %v", err)
+		}
+	case "pin":
+		if err := pin(args[1:]); err != nil {
+			log.Fatalf("Pinning failed: %v", err)
+		}
+	case "unpin":
+		if err := unpin(args[1:]); err != nil {
+			log.Fatalf("Unpinning failed: %v", err)
+		}
+	case "rollback":
+		if err := rollback(args[1:]); err != nil {
+			log.Fatalf("Rollback failed: %v", err)
+		}
+	case "retire":
+		if err := retire(args[1:]); err != nil {
+			log.Fatalf("Retiring failed: %v", err)
+		}
+	case "audit":
+		if err := audit(args[1:]); err != nil {
+			log.Fatalf("Audit failed: %v", err)
+		}
+	case "logs":
+		if err := logs(args[1:]); err != nil {
+			log.Fatalf("Logs failed: %v", err)
+		}
+	case "profile":
+		if err := profile(args[1:]); err != nil {
+			log.Fatalf("Profile failed: %v", err)
+		}
+	case "secret":
+		secret, err := function.NewPluginSecret()
+		if err != nil {
+			log.Fatalf("Secret failed: %v", err)
+		}
+		fmt.Println(secret)
+	default:
+		log.Fatalf("Unknown command: %s", args[0])
+	}
+}
+
+// migrate copies every function from one registry backend to another
+func migrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	from := fs.String("from", "", "Source registry URL")
+	to := fs.String("to", "", "Target registry URL")
+	dryRun := fs.Bool("dry-run", false, "Report what would be copied without writing")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" {
+		return fmt.Errorf("usage: functionctl migrate --from <registry> --to <registry> [--dry-run]")
+	}
+
+	source, closeSource, err := openRegistry(*from)
+	if err != nil {
+		return fmt.Errorf("source: %w", err)
+	}
+	defer closeSource()
+
+	target, closeTarget, err := openRegistry(*to)
+	if err != nil {
+		return fmt.Errorf("target: %w", err)
+	}
+	defer closeTarget()
+
+	report, err := function.MigrateRegistry(source, target, function.MigrateOptions{
+		DryRun: *dryRun,
+		Progress: func(name, digest, status string) {
+			fmt.Printf("%-12s %s sha256:%s\n", status, name, digest)
+		},
+	})
+	if report != nil {
+		fmt.Printf("\n%d copied, %d up to date\n", len(report.Copied), len(report.UpToDate))
+	}
+	return err
+}
+
+// openRegistry opens a registry from its URL
+func openRegistry(rawURL string) (function.Registry, func(), error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid registry URL %q: %w", rawURL, err)
+	}
+
+	switch u.Scheme {
+	case "nats", "tls":
+		query := u.Query()
+		bucket := query.Get("bucket")
+		if bucket == "" {
+			bucket = function.DefaultFunctionBucket
+		}
+		binaries := query.Get("binaries")
+		if binaries == "" {
+			binaries = function.DefaultBinaryBucket
+		}
+		trailBucket := query.Get("audit")
+		namePrefix.Apply(&bucket, &binaries, &trailBucket)
+		u.RawQuery = ""
+
+		nc, err := nats.Connect(u.String())
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to connect to NATS: %w", err)
+		}
+		registry, err := function.NewNATSRegistryWithBuckets(nc, bucket, binaries)
+		if err != nil {
+			nc.Close()
+			return nil, nil, err
+		}
+		if trailBucket != "" {
+			trail, err := audittrail.Open(nc, trailBucket)
+			if err != nil {
+				nc.Close()
+				return nil, nil, err
+			}
+			registry.SetAuditTrail(trail)
+		}
+		if lifecycle.Subject != "" || len(lifecycle.Webhooks) > 0 {
+			registry.SetLifecycleNotifier(function.NewLifecycleNotifier(nc, lifecycle))
+		}
+		return registry, nc.Close, nil
+
+	case "file":
+		registry, err := function.NewFileRegistry(u.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return registry, func() {}, nil
+
+	default:
+		return nil, nil, fmt.Errorf("unsupported registry backend %q (supported: nats, file)", u.Scheme)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/function"
+	"github.com/julianshen/mycelium/internal/profiling"
+
+	"github.com/nats-io/nats.go"
+)
+
+// profile fetches a Go profile or the runtime metrics of a runtime instance
+func profile(args []string) error {
+	fs := flag.NewFlagSet("profile", flag.ContinueOnError)
+	natsURL := fs.String("nats-url", nats.DefaultURL, "NATS server URL")
+	service := fs.String("service", "function-runtime", "Runtime service name")
+	instance := fs.String("instance", "", "ID of the runtime instance to profile, see $SRV.INFO")
+	seconds := fs.Int("seconds", 0, "How long a CPU profile samples (default: 30)")
+	token := fs.String("token", os.Getenv("MYCELIUM_PROFILE_TOKEN"), "Admin token (default: $MYCELIUM_PROFILE_TOKEN)")
+	output := fs.String("o", "", "File the profile is written to (default: <kind>.pprof)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || *instance == "" {
+		return fmt.Errorf("usage: functionctl profile --instance <id> [--seconds N] [-o file] cpu|heap|allocs|goroutine|block|mutex|metrics")
+	}
+
+	nc, err := nats.Connect(*natsURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	defer nc.Close()
+
+	req := profiling.Request{Kind: fs.Arg(0), Seconds: *seconds}
+	return fetchProfile(nc, function.ProfileSubject(namePrefix.Name(*service), *instance), *token, req, *output)
+}
+
+// fetchProfile writes a profile to a file, or prints runtime metrics as JSON
+func fetchProfile(nc *nats.Conn, subject, token string, req profiling.Request, output string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), profiling.RequestTimeout(req, 10*time.Second))
+	defer cancel()
+
+	if req.Kind == profiling.KindMetrics {
+		samples, err := profiling.FetchMetrics(ctx, nc, subject, token)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(samples)
+	}
+
+	if req.Kind == profiling.KindCPU {
+		fmt.Fprintf(os.Stderr, "Sampling CPU for %s...\n", profiling.RequestTimeout(req, 0))
+	}
+	data, err := profiling.Fetch(ctx, nc, subject, token, req)
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		output = req.Kind + ".pprof"
+	}
+	if err := os.WriteFile(output, data, 0644); err != nil {
+		return fmt.Errorf("failed to write profile: %w", err)
+	}
+	fmt.Printf("Wrote %s profile to %s, inspect it with: go tool pprof %s\n", req.Kind, output, output)
+	return nil
+}
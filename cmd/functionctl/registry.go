@@ -0,0 +1,187 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/julianshen/mycelium/internal/function"
+
+	"github.com/nats-io/nats.go"
+)
+
+// push stores a compiled function binary with its metadata in a registry
+func push(args []string) error {
+	fs := flag.NewFlagSet("push", flag.ContinueOnError)
+	registryURL := fs.String("registry", nats.DefaultURL, "Registry URL")
+	name := fs.String("name", "", "Function name (default: the binary's file name)")
+	version := fs.String("version", "", "Function version")
+	functionType := fs.String("type", "hashicorp-plugin", "Function type: hashicorp-plugin, script, javascript or builtin")
+	config := fs.String("config", "", "Comma-separated key=value configuration of the function")
+	eventTypes := fs.String("event-types", "", "Comma-separated event types the function accepts (default: all)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || *version == "" {
+		return fmt.Errorf("usage: functionctl push --version <version> [options] <binary>")
+	}
+
+	binary, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to read binary: %w", err)
+	}
+	meta := function.FunctionMeta{
+		Name:    *name,
+		Type:    *functionType,
+		Version: *version,
+	}
+	if meta.Name == "" {
+		meta.Name = strings.TrimSuffix(filepath.Base(fs.Arg(0)), filepath.Ext(fs.Arg(0)))
+	}
+	if *config != "" {
+		meta.Config = make(map[string]string)
+		for _, entry := range strings.Split(*config, ",") {
+			key, value, ok := strings.Cut(entry, "=")
+			if !ok {
+				return fmt.Errorf("invalid config %q: expected key=value", entry)
+			}
+			meta.Config[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	if *eventTypes != "" {
+		meta.EventTypes = strings.Split(*eventTypes, ",")
+	}
+
+	registry, closeRegistry, err := openRegistry(*registryURL)
+	if err != nil {
+		return err
+	}
+	defer closeRegistry()
+
+	if err := registry.StoreFunction(meta, binary); err != nil {
+		return err
+	}
+	fmt.Printf("Pushed %s %s (%s, sha256:%s)\n", meta.Name, meta.Version, meta.Type, function.BinaryDigest(binary))
+	return nil
+}
+
+// list prints the functions of a registry
+func list(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	registryURL := fs.String("registry", nats.DefaultURL, "Registry URL")
+	asJSON := fs.Bool("json", false, "Print the functions' metadata as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	registry, closeRegistry, err := openRegistry(*registryURL)
+	if err != nil {
+		return err
+	}
+	defer closeRegistry()
+
+	functions, err := registry.ListFunctions()
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		printJSON(os.Stdout, functions)
+		return nil
+	}
+	if len(functions) == 0 {
+		fmt.Println("No functions found")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVERSION\tTYPE\tDIGEST")
+	for _, meta := range functions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", meta.Name, meta.Version, meta.Type, shortDigest(meta.Digest))
+	}
+	return w.Flush()
+}
+
+// get downloads the binary of a function
+func get(args []string) error {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	registryURL := fs.String("registry", nats.DefaultURL, "Registry URL")
+	output := fs.String("output", "", "File the binary is written to (default: the function name)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: functionctl get [--output <file>] <function>")
+	}
+
+	registry, closeRegistry, err := openRegistry(*registryURL)
+	if err != nil {
+		return err
+	}
+	defer closeRegistry()
+
+	meta, binary, err := registry.GetFunction(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	path := *output
+	if path == "" {
+		path = meta.Name
+	}
+	if err := os.WriteFile(path, binary, 0755); err != nil {
+		return fmt.Errorf("failed to write binary: %w", err)
+	}
+	fmt.Printf("Wrote %s %s to %s (%d bytes, sha256:%s)\n", meta.Name, meta.Version, path, len(binary), function.BinaryDigest(binary))
+	return nil
+}
+
+// describe prints the metadata of a function and the size and digest of its binary
+func describe(args []string) error {
+	fs := flag.NewFlagSet("describe", flag.ContinueOnError)
+	registryURL := fs.String("registry", nats.DefaultURL, "Registry URL")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: functionctl describe [--registry <registry>] <function>")
+	}
+
+	registry, closeRegistry, err := openRegistry(*registryURL)
+	if err != nil {
+		return err
+	}
+	defer closeRegistry()
+
+	meta, binary, err := registry.GetFunction(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	printJSON(os.Stdout, meta)
+	fmt.Printf("Binary: %d bytes, sha256:%s\n", len(binary), function.BinaryDigest(binary))
+	return nil
+}
+
+// remove deletes a function from a registry
+func remove(args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ContinueOnError)
+	registryURL := fs.String("registry", nats.DefaultURL, "Registry URL")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: functionctl delete [--registry <registry>] <function>")
+	}
+
+	registry, closeRegistry, err := openRegistry(*registryURL)
+	if err != nil {
+		return err
+	}
+	defer closeRegistry()
+
+	if err := registry.DeleteFunction(fs.Arg(0)); err != nil {
+		return err
+	}
+	fmt.Printf("Deleted function %s\n", fs.Arg(0))
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/julianshen/mycelium/internal/function"
+
+	"github.com/nats-io/nats.go"
+)
+
+// schema registers and lists event data schemas
+func schema(args []string) error {
+	fs := flag.NewFlagSet("schema", flag.ContinueOnError)
+	natsURL := fs.String("nats-url", nats.DefaultURL, "NATS server URL")
+	bucket := fs.String("bucket", function.DefaultSchemaBucket, "Schema KV bucket")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	registry, closeRegistry, err := openSchemaRegistry(*natsURL, namePrefix.Name(*bucket))
+	if err != nil {
+		return err
+	}
+	defer closeRegistry()
+
+	ctx := context.Background()
+	switch fs.Arg(0) {
+	case "put":
+		if fs.NArg() != 3 {
+			return fmt.Errorf("usage: functionctl schema put <event-type> <file>")
+		}
+		data, err := os.ReadFile(fs.Arg(2))
+		if err != nil {
+			return fmt.Errorf("failed to read schema: %w", err)
+		}
+		if err := registry.PutSchema(ctx, fs.Arg(1), data); err != nil {
+			return err
+		}
+		fmt.Printf("Registered schema for %s\n", fs.Arg(1))
+		return nil
+
+	case "list":
+		eventTypes, err := registry.ListSchemas(ctx)
+		if err != nil {
+			return err
+		}
+		for _, eventType := range eventTypes {
+			fmt.Println(eventType)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("usage: functionctl schema <put|list> [options]")
+	}
+}
+
+// codegen generates Go types and DataAs helpers for registered event data schemas
+func codegen(args []string) error {
+	fs := flag.NewFlagSet("codegen", flag.ContinueOnError)
+	natsURL := fs.String("nats-url", nats.DefaultURL, "NATS server URL")
+	bucket := fs.String("bucket", function.DefaultSchemaBucket, "Schema KV bucket")
+	pkg := fs.String("package", "events", "Package name of the generated file")
+	out := fs.String("out", "", "Output file (default: stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	registry, closeRegistry, err := openSchemaRegistry(*natsURL, namePrefix.Name(*bucket))
+	if err != nil {
+		return err
+	}
+	defer closeRegistry()
+
+	// Generate every registered schema unless event types are named
+	ctx := context.Background()
+	eventTypes := fs.Args()
+	if len(eventTypes) == 0 {
+		if eventTypes, err = registry.ListSchemas(ctx); err != nil {
+			return err
+		}
+	}
+
+	schemas := make(map[string]*function.EventSchema, len(eventTypes))
+	for _, eventType := range eventTypes {
+		if schemas[eventType], err = registry.GetSchema(ctx, eventType); err != nil {
+			return err
+		}
+	}
+
+	source, err := function.GenerateGo(*pkg, schemas)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(source)
+		return err
+	}
+	return os.WriteFile(*out, source, 0644)
+}
+
+// openSchemaRegistry connects to the schema registry
+func openSchemaRegistry(natsURL, bucket string) (function.SchemaRegistry, func(), error) {
+	nc, err := nats.Connect(natsURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	registry, err := function.NewNATSSchemaRegistry(nc, bucket)
+	if err != nil {
+		nc.Close()
+		return nil, nil, err
+	}
+	return registry, nc.Close, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/julianshen/mycelium/internal/function"
+	"github.com/julianshen/mycelium/internal/namespace"
+
+	"github.com/nats-io/nats.go"
+)
+
+// usage prints the storage consumed by a registry, or by every namespace's registry
+func usage(args []string) error {
+	fs := flag.NewFlagSet("usage", flag.ContinueOnError)
+	namespaces := fs.Bool("namespaces", false, "Report every provisioned namespace instead of a registry")
+	natsURL := fs.String("nats-url", nats.DefaultURL, "NATS server URL of the namespaces")
+	jsonOutput := fs.Bool("json", false, "Print usage as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	usages := make(map[string]function.StorageUsage)
+	var names []string
+	if *namespaces {
+		nc, err := nats.Connect(*natsURL)
+		if err != nil {
+			return fmt.Errorf("failed to connect to NATS: %w", err)
+		}
+		defer nc.Close()
+
+		provisioner, err := namespace.NewProvisioner(nc, namespace.ProvisionerConfig{
+			Bucket: namePrefix.Name(namespace.DefaultBucket),
+			Prefix: namePrefix,
+		})
+		if err != nil {
+			return err
+		}
+		list, err := provisioner.List(ctx)
+		if err != nil {
+			return err
+		}
+		for _, res := range list {
+			u, err := provisioner.Usage(ctx, res.Namespace)
+			if err != nil {
+				return fmt.Errorf("namespace %s: %w", res.Namespace, err)
+			}
+			usages[res.Namespace] = u
+			names = append(names, res.Namespace)
+		}
+	} else {
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: functionctl usage <registry> | --namespaces [--nats-url <url>]")
+		}
+		registry, closeRegistry, err := openNATSRegistry(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer closeRegistry()
+
+		u, err := registry.Usage(ctx)
+		if err != nil {
+			return err
+		}
+		usages[fs.Arg(0)] = u
+		names = append(names, fs.Arg(0))
+	}
+
+	if *jsonOutput {
+		printJSON(os.Stdout, usages)
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REGISTRY\tFUNCTIONS\tVERSIONS\tBINARIES\tBYTES\tMAX FUNCTIONS\tMAX BYTES")
+	for _, name := range names {
+		u := usages[name]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\t%s\n", name, u.Functions, u.Versions, u.Binaries, u.BinaryBytes,
+			formatLimit(int64(u.Quota.MaxFunctions)), formatLimit(u.Quota.MaxBinaryBytes))
+	}
+	return w.Flush()
+}
+
+// quota sets the storage quota of a registry
+func quota(args []string) error {
+	fs := flag.NewFlagSet("quota", flag.ContinueOnError)
+	maxFunctions := fs.Int("max-functions", 0, "Number of functions the registry may store (0 is unlimited)")
+	maxBinaryBytes := fs.Int64("max-binary-bytes", 0, "Size of the binaries the registry may store (0 is unlimited)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: functionctl quota [--max-functions N] [--max-binary-bytes N] <registry>")
+	}
+
+	registry, closeRegistry, err := openNATSRegistry(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer closeRegistry()
+
+	q := function.StorageQuota{MaxFunctions: *maxFunctions, MaxBinaryBytes: *maxBinaryBytes}
+	if err := registry.SetQuota(context.Background(), q); err != nil {
+		return err
+	}
+	fmt.Printf("Quota of %s: %s functions, %s binary bytes\n", fs.Arg(0), formatLimit(int64(q.MaxFunctions)), formatLimit(q.MaxBinaryBytes))
+	return nil
+}
+
+// openNATSRegistry opens a registry that reports usage; only NATS registries do
+func openNATSRegistry(rawURL string) (*function.NATSRegistry, func(), error) {
+	registry, closeRegistry, err := openRegistry(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	natsRegistry, ok := registry.(*function.NATSRegistry)
+	if !ok {
+		closeRegistry()
+		return nil, nil, fmt.Errorf("registry %s does not support quotas (only nats registries do)", rawURL)
+	}
+	return natsRegistry, closeRegistry, nil
+}
+
+// formatLimit prints a quota limit, where 0 is unlimited
+func formatLimit(limit int64) string {
+	if limit <= 0 {
+		return "unlimited"
+	}
+	return fmt.Sprint(limit)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/julianshen/mycelium/internal/function"
+)
+
+// verify re-hashes the binaries of a registry against their digests and optionally
+// restores corrupt ones from a mirror registry
+func verify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	mirrorURL := fs.String("mirror", "", "Registry binaries failing verification are restored from (default: report only)")
+	jsonOutput := fs.Bool("json", false, "Print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: functionctl verify [--mirror <registry>] [--json] <registry>")
+	}
+
+	registry, closeRegistry, err := openRegistry(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer closeRegistry()
+	verifier, ok := registry.(function.IntegrityVerifier)
+	if !ok {
+		return fmt.Errorf("registry %s cannot be verified (only nats registries can)", fs.Arg(0))
+	}
+
+	var opts function.VerifyOptions
+	if *mirrorURL != "" {
+		mirror, closeMirror, err := openRegistry(*mirrorURL)
+		if err != nil {
+			return fmt.Errorf("mirror: %w", err)
+		}
+		defer closeMirror()
+		opts.Mirror = mirror
+	}
+
+	report, err := verifier.VerifyBinaries(context.Background(), opts)
+	if err != nil {
+		return err
+	}
+	if *jsonOutput {
+		printJSON(os.Stdout, report)
+	} else {
+		if len(report.Issues) > 0 {
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "OBJECT\tPROBLEM\tFUNCTIONS\tREPAIR")
+			for _, issue := range report.Issues {
+				repair := "-"
+				switch {
+				case issue.Repaired:
+					repair = "restored from mirror"
+				case issue.RepairError != "":
+					repair = "failed: " + issue.RepairError
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", issue.Object, issue.Problem, strings.Join(issue.Functions, ", "), repair)
+			}
+			w.Flush()
+			fmt.Println()
+		}
+		fmt.Printf("%d binaries (%d bytes) verified, %d failed verification", report.Verified, report.Bytes, len(report.Issues))
+		if report.Skipped > 0 {
+			fmt.Printf(", %d revisions without digest skipped", report.Skipped)
+		}
+		fmt.Println()
+	}
+
+	if unrepaired := len(report.Unrepaired()); unrepaired > 0 {
+		return fmt.Errorf("%d binaries failed verification", unrepaired)
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/function"
+
+	"github.com/nats-io/nats.go"
+)
+
+// versionFlags are the flags shared by the version management commands
+type versionFlags struct {
+	registry string
+	natsURL  string
+	service  string
+	timeout  time.Duration
+	actor    string
+}
+
+// register adds the shared flags to a flag set
+func (f *versionFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.registry, "registry", nats.DefaultURL, "Registry URL (nats only)")
+	fs.StringVar(&f.natsURL, "nats-url", nats.DefaultURL, "NATS server URL of the runtime service")
+	fs.StringVar(&f.service, "service", "function-runtime", "Runtime service name")
+	fs.DurationVar(&f.timeout, "timeout", 2*time.Second, "How long to wait for runtime instances to answer")
+	fs.StringVar(&f.actor, "actor", os.Getenv("USER"), "Name recorded in the audit log")
+}
+
+// versions prints the retained versions of a function and the versions the fleet serves
+func versions(args []string) error {
+	var f versionFlags
+	fs := flag.NewFlagSet("versions", flag.ContinueOnError)
+	f.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: functionctl versions [options] <function>")
+	}
+	name := fs.Arg(0)
+
+	registry, closeRegistry, err := openNATSRegistry(f.registry)
+	if err != nil {
+		return err
+	}
+	defer closeRegistry()
+
+	revisions, err := registry.FunctionVersions(name)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tDIGEST\tSCHEMA\tCURRENT")
+	for i, meta := range revisions {
+		current := ""
+		if i == 0 {
+			current = "*"
+		}
+		fmt.Fprintf(w, "%s\t%s\tv%d\t%s\n", meta.Version, shortDigest(meta.Digest), meta.SchemaVersion, current)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	nc, err := nats.Connect(f.natsURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	defer nc.Close()
+	instances, err := function.ListLoadedFunctions(nc, namePrefix.Name(f.service), f.timeout)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "INSTANCE\tVERSION\tDIGEST\tPINNED\tABI\tLOADED")
+	for _, instance := range instances {
+		for _, loaded := range instance.Functions {
+			if loaded.Name == name {
+				abi := "-"
+				if loaded.ABIVersion > 0 {
+					abi = fmt.Sprintf("v%d", loaded.ABIVersion)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\t%s\n", instance.InstanceID, loaded.Version, shortDigest(loaded.Digest), loaded.Pinned, abi, loaded.LoadedAt.Format(time.RFC3339))
+			}
+		}
+	}
+	return w.Flush()
+}
+
+// pin pins a function to a version on one instance or the fleet
+func pin(args []string) error {
+	var f versionFlags
+	fs := flag.NewFlagSet("pin", flag.ContinueOnError)
+	f.register(fs)
+	instance := fs.String("instance", "", "Runtime instance ID (default: every instance)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: functionctl pin [--instance <id>] [options] <function> <version>")
+	}
+	return pinFleet(f, *instance, fs.Arg(0), fs.Arg(1), "")
+}
+
+// unpin makes a function follow the registry again on one instance or the fleet
+func unpin(args []string) error {
+	var f versionFlags
+	fs := flag.NewFlagSet("unpin", flag.ContinueOnError)
+	f.register(fs)
+	instance := fs.String("instance", "", "Runtime instance ID (default: every instance)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: functionctl unpin [--instance <id>] [options] <function>")
+	}
+	return pinFleet(f, *instance, fs.Arg(0), "", "")
+}
+
+// rollback points the registry back at a previous version of a function and makes the
+// fleet serve it
+func rollback(args []string) error {
+	var f versionFlags
+	fs := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	f.register(fs)
+	to := fs.String("to", "", "Version to roll back to (default: the previous version)")
+	reason := fs.String("reason", "", "Reason recorded in the audit log")
+	pinned := fs.Bool("pin", false, "Also pin the fleet to the version, so later deploys are not served until unpinned")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: functionctl rollback [--to <version>] [--pin] [options] <function>")
+	}
+	name := fs.Arg(0)
+
+	registry, closeRegistry, err := openNATSRegistry(f.registry)
+	if err != nil {
+		return err
+	}
+	defer closeRegistry()
+
+	meta, err := registry.Rollback(context.Background(), name, *to, f.actor, *reason)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Registry now serves %s@%s\n", name, meta.Version)
+
+	// Unpinning reloads the registry's version on instances that loaded the function
+	version := ""
+	if *pinned {
+		version = meta.Version
+	}
+	return pinFleet(f, "", name, version, *reason)
+}
+
+// retire stops keeping a version of a function in the registry
+func retire(args []string) error {
+	var f versionFlags
+	fs := flag.NewFlagSet("retire", flag.ContinueOnError)
+	f.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: functionctl retire [options] <function> <version>")
+	}
+
+	registry, closeRegistry, err := openNATSRegistry(f.registry)
+	if err != nil {
+		return err
+	}
+	defer closeRegistry()
+
+	if err := registry.RetireVersion(context.Background(), fs.Arg(0), fs.Arg(1), f.actor); err != nil {
+		return err
+	}
+	fmt.Printf("Retired %s@%s\n", fs.Arg(0), fs.Arg(1))
+	return nil
+}
+
+// pinFleet sends a pin request, records it in the audit log and prints the answers
+func pinFleet(f versionFlags, instance, name, version, reason string) error {
+	registry, closeRegistry, err := openNATSRegistry(f.registry)
+	if err != nil {
+		return err
+	}
+	defer closeRegistry()
+
+	nc, err := nats.Connect(f.natsURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	defer nc.Close()
+
+	results, err := function.PinFunction(nc, namePrefix.Name(f.service), instance, function.PinRequest{Function: name, Version: version, Actor: f.actor}, f.timeout)
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		return fmt.Errorf("no runtime instance of %s answered", namePrefix.Name(f.service))
+	}
+
+	failed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "INSTANCE\tVERSION\tPINNED\tERROR")
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%t\t%s\n", result.InstanceID, result.Version, result.Pinned, result.Error)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	action := function.AuditPin
+	if version == "" {
+		action = function.AuditUnpin
+	}
+	if err := registry.RecordAudit(context.Background(), function.AuditEntry{
+		Action:   action,
+		Function: name,
+		Version:  version,
+		Instance: instance,
+		Actor:    f.actor,
+		Reason:   reason,
+	}); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d instances failed", failed, len(results))
+	}
+	return nil
+}
+
+// audit prints the audit log of a function, or of every function
+func audit(args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	registryURL := fs.String("registry", nats.DefaultURL, "Registry URL (nats only)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return fmt.Errorf("usage: functionctl audit [--registry <url>] [function]")
+	}
+
+	registry, closeRegistry, err := openNATSRegistry(*registryURL)
+	if err != nil {
+		return err
+	}
+	defer closeRegistry()
+
+	entries, err := registry.AuditLog(context.Background(), fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tACTION\tFUNCTION\tVERSION\tPREVIOUS\tINSTANCE\tACTOR\tREASON")
+	for _, e := range entries {
+		instance := e.Instance
+		if instance == "" && (e.Action == function.AuditPin || e.Action == function.AuditUnpin) {
+			instance = "fleet"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Time.Format(time.RFC3339), e.Action, e.Function, e.Version, e.Previous, instance, e.Actor, e.Reason)
+	}
+	return w.Flush()
+}
+
+// shortDigest abbreviates a binary digest for tables
+func shortDigest(digest string) string {
+	if len(digest) > 12 {
+		return digest[:12]
+	}
+	return digest
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	mevent "github.com/julianshen/mycelium/internal/event"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+)
+
+// RunExtension tags every generated event with the ID of the load test run
+const RunExtension = "loadgenrun"
+
+// weighted is a weighted choice between values
+type weighted[T any] struct {
+	values  []T
+	weights []int
+	total   int
+}
+
+// parseWeighted parses a comma-separated list of value[=weight] entries; weights default to 1
+func parseWeighted[T any](spec string, parse func(string) (T, error)) (*weighted[T], error) {
+	w := &weighted[T]{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		raw, weightText, hasWeight := strings.Cut(entry, "=")
+		weight := 1
+		if hasWeight {
+			n, err := strconv.Atoi(weightText)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid weight in %q", entry)
+			}
+			weight = n
+		}
+
+		value, err := parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value in %q: %w", entry, err)
+		}
+		w.values = append(w.values, value)
+		w.weights = append(w.weights, weight)
+		w.total += weight
+	}
+	if w.total == 0 {
+		return nil, fmt.Errorf("%q has no entries with a positive weight", spec)
+	}
+	return w, nil
+}
+
+// pick returns a value with probability proportional to its weight
+func (w *weighted[T]) pick(rng *rand.Rand) T {
+	n := rng.Intn(w.total)
+	for i, weight := range w.weights {
+		if n < weight {
+			return w.values[i]
+		}
+		n -= weight
+	}
+	return w.values[len(w.values)-1]
+}
+
+// parseString accepts any non-empty string
+func parseString(s string) (string, error) {
+	if s == "" {
+		return "", fmt.Errorf("empty value")
+	}
+	return s, nil
+}
+
+// parseSize parses a payload size in bytes, with an optional k or m suffix
+func parseSize(s string) (int, error) {
+	multiplier := 1
+	switch {
+	case strings.HasSuffix(strings.ToLower(s), "k"):
+		multiplier, s = 1024, s[:len(s)-1]
+	case strings.HasSuffix(strings.ToLower(s), "m"):
+		multiplier, s = 1024*1024, s[:len(s)-1]
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// generator synthesizes CloudEvents following the configured distributions
+type generator struct {
+	runID      string
+	source     string
+	types      *weighted[string]
+	namespaces *weighted[string]
+	sizes      *weighted[int]
+	rng        *rand.Rand
+}
+
+// next returns the next synthetic event. Its data is a change whose after state is
+// padded to the chosen payload size.
+func (g *generator) next(seq int) (*cloudevents.Event, error) {
+	eventType := g.types.pick(g.rng)
+	if namespace := g.namespaces.pick(g.rng); namespace != "" {
+		eventType = namespace + "." + eventType
+	}
+
+	ce := cloudevents.NewEvent()
+	ce.SetID(uuid.NewString())
+	ce.SetSource(g.source)
+	ce.SetType(eventType)
+	ce.SetTime(time.Now())
+	ce.SetExtension(mevent.ExtActorType, "loadgen")
+	ce.SetExtension(mevent.ExtActorID, g.runID)
+	ce.SetExtension(RunExtension, g.runID)
+
+	if err := ce.SetData(cloudevents.ApplicationJSON, map[string]interface{}{
+		"after": map[string]interface{}{
+			"seq":     seq,
+			"usage":   g.rng.Intn(100),
+			"padding": strings.Repeat("x", g.sizes.pick(g.rng)),
+		},
+	}); err != nil {
+		return nil, fmt.Errorf("failed to set event data: %w", err)
+	}
+	return &ce, nil
+}
+
+// arrivals returns the delay before the next event for a rate in events per second.
+// Poisson arrivals have exponentially distributed gaps with the same mean.
+func arrivals(distribution string, rate float64, rng *rand.Rand) (func() time.Duration, error) {
+	mean := float64(time.Second) / rate
+	switch distribution {
+	case "constant":
+		return func() time.Duration { return time.Duration(mean) }, nil
+	case "poisson":
+		return func() time.Duration { return time.Duration(rng.ExpFloat64() * mean) }, nil
+	}
+	return nil, fmt.Errorf("unknown arrival distribution %q (expected constant or poisson)", distribution)
+}
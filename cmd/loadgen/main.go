@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/action"
+	"github.com/julianshen/mycelium/internal/naming"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+)
+
+// pendingPublish is a JetStream publish waiting for its ack
+type pendingPublish struct {
+	id     string
+	size   int
+	future nats.PubAckFuture
+}
+
+func main() {
+	natsURL := flag.String("nats-url", "nats://localhost:4222", "NATS server URL")
+	subjectPrefix := flag.String("subject-prefix", "config", "Events are published to <prefix>.<type>; triggerd consumes config.> by default")
+	rate := flag.Float64("rate", 100, "Target events per second")
+	duration := flag.Duration("duration", 30*time.Second, "How long to publish events")
+	count := flag.Int("count", 0, "Stop after this many events (0 publishes for --duration)")
+	distribution := flag.String("arrival", "constant", "Arrival distribution: constant or poisson")
+	types := flag.String("types", "resource.updated", "Event types as type[=weight],...")
+	namespaces := flag.String("namespaces", "loadtest", "Namespaces as namespace[=weight],...")
+	sizes := flag.String("payload-sizes", "256", "Payload sizes in bytes as size[=weight],..., e.g. 256=9,64k=1")
+	resultsSubject := flag.String("results-subject", action.DefaultResultSubject, "Subject triggerd publishes action results to (empty disables latency tracking)")
+	drain := flag.Duration("drain", 5*time.Second, "How long to wait for action results after publishing")
+	maxPending := flag.Int("max-pending", 4096, "Maximum unacknowledged JetStream publishes")
+	seed := flag.Int64("seed", 0, "Random seed (0 uses the current time)")
+	stream := flag.String("stream", "", "Create this stream for <prefix>.> if it does not exist, e.g. config-stream")
+	var names naming.Prefix
+	names.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	if err := names.Validate(); err != nil {
+		log.Fatalf("Invalid name prefix: %v", err)
+	}
+	names.Apply(stream)
+
+	if *rate <= 0 {
+		log.Fatalf("--rate must be positive")
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(*seed))
+
+	runID := uuid.NewString()
+	gen := &generator{runID: runID, source: "mycelium/loadgen", rng: rng}
+	var err error
+	if gen.types, err = parseWeighted(*types, parseString); err != nil {
+		log.Fatalf("Invalid --types: %v", err)
+	}
+	if gen.namespaces, err = parseWeighted(*namespaces, parseString); err != nil {
+		log.Fatalf("Invalid --namespaces: %v", err)
+	}
+	if gen.sizes, err = parseWeighted(*sizes, parseSize); err != nil {
+		log.Fatalf("Invalid --payload-sizes: %v", err)
+	}
+	next, err := arrivals(*distribution, *rate, rng)
+	if err != nil {
+		log.Fatalf("Invalid --arrival: %v", err)
+	}
+
+	nc, err := nats.Connect(*natsURL)
+	if err != nil {
+		log.Fatalf("Failed to connect to NATS: %v", err)
+	}
+	defer nc.Close()
+
+	js, err := nc.JetStream(nats.PublishAsyncMaxPending(*maxPending))
+	if err != nil {
+		log.Fatalf("Failed to create JetStream context: %v", err)
+	}
+
+	if *stream != "" {
+		if err := ensureStream(js, *stream, *subjectPrefix+".>"); err != nil {
+			log.Fatalf("Failed to create stream: %v", err)
+		}
+	}
+
+	stats := newStats()
+	if *resultsSubject != "" {
+		if err := stats.subscribeResults(nc, *resultsSubject); err != nil {
+			log.Fatalf("Failed to track results: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		cancel()
+	}()
+
+	// Acks are collected in publish order while publishing continues
+	pending := make(chan pendingPublish, *maxPending)
+	collected := make(chan struct{})
+	giveUp := make(chan struct{})
+	go func() {
+		defer close(collected)
+		for p := range pending {
+			select {
+			case <-p.future.Ok():
+				stats.acked(p.id, p.size, nil)
+			case err := <-p.future.Err():
+				stats.acked(p.id, p.size, err)
+			case <-giveUp:
+				stats.acked(p.id, p.size, nats.ErrTimeout)
+			}
+		}
+	}()
+
+	log.Printf("Load test %s: %.1f events/s (%s) to %s.>", runID, *rate, *distribution, *subjectPrefix)
+
+	// Events are scheduled from the start time, so a slow publish is caught up on
+	// instead of lowering the rate
+	scheduled := time.Now()
+	for seq := 0; *count == 0 || seq < *count; seq++ {
+		if wait := time.Until(scheduled); wait > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(wait):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		scheduled = scheduled.Add(next())
+
+		ce, err := gen.next(seq)
+		if err != nil {
+			log.Fatalf("Failed to generate event: %v", err)
+		}
+		data, err := ce.MarshalJSON()
+		if err != nil {
+			log.Fatalf("Failed to marshal event: %v", err)
+		}
+
+		stats.sending(ce.ID(), time.Now())
+		future, err := js.PublishAsync(*subjectPrefix+"."+ce.Type(), data)
+		if err != nil {
+			stats.acked(ce.ID(), len(data), err)
+			continue
+		}
+		pending <- pendingPublish{id: ce.ID(), size: len(data), future: future}
+	}
+
+	select {
+	case <-js.PublishAsyncComplete():
+	case <-time.After(30 * time.Second):
+		log.Printf("Timed out waiting for %d publish acks", js.PublishAsyncPending())
+		close(giveUp)
+	}
+	close(pending)
+	<-collected
+
+	if *resultsSubject != "" && *drain > 0 {
+		log.Printf("Waiting %s for action results", *drain)
+		time.Sleep(*drain)
+	}
+
+	fmt.Println()
+	stats.report(os.Stdout, *rate)
+}
+
+// ensureStream creates a stream capturing subject unless it already exists
+func ensureStream(js nats.JetStreamContext, name, subject string) error {
+	if _, err := js.StreamInfo(name); err == nil {
+		return nil
+	} else if !errors.Is(err, nats.ErrStreamNotFound) {
+		return err
+	}
+	_, err := js.AddStream(&nats.StreamConfig{Name: name, Subjects: []string{subject}})
+	return err
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/action"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/nats-io/nats.go"
+)
+
+// latencies collects duration samples
+type latencies []time.Duration
+
+// percentile returns the p-th percentile (0-100) of sorted samples
+func (l latencies) percentile(p float64) time.Duration {
+	if len(l) == 0 {
+		return 0
+	}
+	i := int(float64(len(l)-1) * p / 100)
+	return l[i]
+}
+
+// summary renders the count and percentiles of the samples
+func (l latencies) summary() string {
+	sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+	if len(l) == 0 {
+		return "no samples"
+	}
+	return fmt.Sprintf("n=%d p50=%s p90=%s p99=%s max=%s",
+		len(l),
+		l.percentile(50).Round(time.Microsecond),
+		l.percentile(90).Round(time.Microsecond),
+		l.percentile(99).Round(time.Microsecond),
+		l[len(l)-1].Round(time.Microsecond))
+}
+
+// stats tracks published events and the action results triggerd reports for them
+type stats struct {
+	mu         sync.Mutex
+	sent       map[string]time.Time
+	published  int
+	failed     int
+	firstError error
+	bytes      int
+	publish    latencies // Publish to JetStream ack
+	endToEnd   latencies // Publish to action result
+	actions    latencies // Action execution time reported by triggerd
+	statuses   map[string]int
+	firstSent  time.Time
+	lastAcked  time.Time
+	unmatched  int
+	resultsSub *nats.Subscription
+}
+
+func newStats() *stats {
+	return &stats{
+		sent:     make(map[string]time.Time),
+		statuses: make(map[string]int),
+	}
+}
+
+// sending records that an event is about to be published
+func (s *stats) sending(id string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.firstSent.IsZero() {
+		s.firstSent = at
+	}
+	s.sent[id] = at
+}
+
+// acked records the outcome of a JetStream publish
+func (s *stats) acked(id string, size int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.failed++
+		if s.firstError == nil {
+			s.firstError = err
+		}
+		delete(s.sent, id)
+		return
+	}
+	now := time.Now()
+	s.published++
+	s.bytes += size
+	s.publish = append(s.publish, now.Sub(s.sent[id]))
+	s.lastAcked = now
+}
+
+// subscribeResults measures the latency of action results for generated events.
+// Result events carry the ID of the event that caused them as their subject.
+func (s *stats) subscribeResults(nc *nats.Conn, subject string) error {
+	sub, err := nc.Subscribe(subject, func(msg *nats.Msg) {
+		received := time.Now()
+		ce := cloudevents.NewEvent()
+		if err := json.Unmarshal(msg.Data, &ce); err != nil {
+			return
+		}
+		var data struct {
+			After action.Result `json:"after"`
+		}
+		if err := ce.DataAs(&data); err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		sent, ok := s.sent[ce.Subject()]
+		if !ok {
+			s.unmatched++
+			return
+		}
+		s.endToEnd = append(s.endToEnd, received.Sub(sent))
+		s.actions = append(s.actions, time.Duration(data.After.DurationMs)*time.Millisecond)
+		s.statuses[data.After.Status]++
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", subject, err)
+	}
+	s.resultsSub = sub
+	return nil
+}
+
+// report prints throughput and latency figures
+func (s *stats) report(w io.Writer, target float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var elapsed time.Duration
+	throughput := 0.0
+	if s.published > 0 {
+		elapsed = s.lastAcked.Sub(s.firstSent)
+		throughput = float64(s.published) / elapsed.Seconds()
+	}
+
+	fmt.Fprintf(w, "Published:   %d events (%d failed), %.1f MiB\n", s.published, s.failed, float64(s.bytes)/(1024*1024))
+	if s.firstError != nil {
+		fmt.Fprintf(w, "First error: %v\n", s.firstError)
+	}
+	fmt.Fprintf(w, "Throughput:  %.1f events/s (target %.1f) over %s\n", throughput, target, elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "Publish ack: %s\n", s.publish.summary())
+	if s.resultsSub == nil {
+		return
+	}
+
+	fmt.Fprintf(w, "Results:     %d", len(s.endToEnd))
+	statuses := make([]string, 0, len(s.statuses))
+	for status := range s.statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		fmt.Fprintf(w, " %s=%d", status, s.statuses[status])
+	}
+	if s.unmatched > 0 {
+		fmt.Fprintf(w, " (%d from other sources)", s.unmatched)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "End-to-end:  %s\n", s.endToEnd.summary())
+	fmt.Fprintf(w, "Action:      %s\n", s.actions.summary())
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/audit"
+
+	"github.com/nats-io/nats.go"
+)
+
+// manageAuditTrail runs the audit keygen/list/verify subcommands
+func manageAuditTrail(natsURL string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: triggerctl audit <keygen|list|verify> [options]")
+	}
+
+	fs := flag.NewFlagSet("audit "+args[0], flag.ContinueOnError)
+	bucket := fs.String("bucket", audit.DefaultBucket, "KV bucket of the audit trail")
+	limit := fs.Int("limit", 50, "Number of newest records to list (0 lists all)")
+	publicKeys := fs.String("public-key", "", "PEM file with the public keys checkpoints are verified with")
+	jsonOutput := fs.Bool("json", false, "Print JSON")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	// Keys are generated offline
+	if args[0] == "keygen" {
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: triggerctl audit keygen <name>")
+		}
+		return generateAuditKey(fs.Arg(0))
+	}
+
+	nc, err := nats.Connect(natsURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	defer nc.Close()
+
+	trail, err := audit.Open(nc, namePrefix.Name(*bucket))
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "list":
+		records, err := trail.Records(ctx, 0, *limit)
+		if err != nil {
+			return err
+		}
+		if *jsonOutput {
+			return printJSON(records)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SEQ\tTIME\tKIND\tACTOR\tACTION\tRESOURCE\tDETAILS")
+		for _, r := range records {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Seq, r.Time.Format(time.RFC3339), r.Kind, r.Actor, r.Action, r.Resource, formatDetails(r.Details))
+		}
+		return w.Flush()
+
+	case "verify":
+		if *publicKeys == "" {
+			return fmt.Errorf("usage: triggerctl audit verify --public-key <file> [--bucket name] [--json]")
+		}
+		keys, err := audit.LoadPublicKeys(*publicKeys)
+		if err != nil {
+			return err
+		}
+		report, err := trail.Verify(ctx, keys)
+		if err != nil {
+			return err
+		}
+		if *jsonOutput {
+			if err := printJSON(report); err != nil {
+				return err
+			}
+		} else {
+			fmt.Printf("%d records, %d checkpoints, sealed up to record %d (%d unsealed)\n",
+				report.Records, report.Checkpoints, report.LastCheckpoint, report.Unsealed)
+			for _, p := range report.Problems {
+				what := "record"
+				if p.Checkpoint {
+					what = "checkpoint"
+				}
+				line := fmt.Sprintf("  %s %d: %s", what, p.Seq, p.Problem)
+				if p.Detail != "" {
+					line += " (" + p.Detail + ")"
+				}
+				fmt.Println(line)
+			}
+		}
+		if !report.OK() {
+			return fmt.Errorf("audit trail failed verification with %d problems", len(report.Problems))
+		}
+		if !*jsonOutput {
+			fmt.Println("Audit trail is intact")
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown audit command: %s", args[0])
+	}
+}
+
+// generateAuditKey writes a checkpoint signing key to <name>.key and its public key to <name>.pub
+func generateAuditKey(name string) error {
+	private, public, err := audit.GenerateKey()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(name+".key", private, 0600); err != nil {
+		return fmt.Errorf("failed to write signing key: %w", err)
+	}
+	if err := os.WriteFile(name+".pub", public, 0644); err != nil {
+		return fmt.Errorf("failed to write public key: %w", err)
+	}
+	fmt.Printf("Wrote signing key %s.key and public key %s.pub\n", name, name)
+	return nil
+}
+
+// formatDetails renders record details as sorted key=value pairs
+func formatDetails(details map[string]string) string {
+	pairs := make([]string, 0, len(details))
+	for k, v := range details {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
+// printJSON prints a value as indented JSON
+func printJSON(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	fmt.Println(string(data))
+	return nil
+}
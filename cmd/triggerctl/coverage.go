@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/trigger"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/nats-io/nats.go"
+)
+
+// reportCoverage prints which sub-expressions of the triggers' criteria a corpus of
+// recorded events exercised
+func reportCoverage(ctx context.Context, nc *nats.Conn, store *trigger.NATSStore, streamName string, args []string) error {
+	fs := flag.NewFlagSet("coverage", flag.ContinueOnError)
+	eventsFile := fs.String("events", "", "File of recorded CloudEvents, as JSON lines or a JSON array, - for stdin (default: the events stored in the stream)")
+	subject := fs.String("subject", "config.>", "Subject of the stored events to read when --events is not set")
+	since := fs.Duration("since", 0, "Read events stored within this duration, e.g. 24h (default: all stored events)")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	failUnder := fs.Float64("fail-under", 0, "Exit non-zero when the coverage of a trigger is below this percentage")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	triggers, err := store.GetAllTriggers(ctx)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		byID := make(map[string]*trigger.Trigger, len(triggers))
+		for _, t := range triggers {
+			byID[t.ID] = t
+		}
+		triggers = nil
+		for _, id := range fs.Args() {
+			t, ok := byID[id]
+			if !ok {
+				return fmt.Errorf("trigger %s not found", id)
+			}
+			triggers = append(triggers, t)
+		}
+	}
+
+	var events []*cloudevents.Event
+	if *eventsFile != "" {
+		events, err = readEventsFile(*eventsFile)
+	} else {
+		events, err = readStoredEvents(nc, streamName, *subject, *since)
+	}
+	if err != nil {
+		return err
+	}
+
+	coverage, err := trigger.MeasureCriteriaCoverage(triggers, events)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(coverage); err != nil {
+			return err
+		}
+	} else {
+		printCoverage(coverage, len(events))
+	}
+
+	if *failUnder > 0 {
+		for _, c := range coverage {
+			if c.Percent() < *failUnder {
+				os.Exit(1)
+			}
+		}
+	}
+	return nil
+}
+
+// printCoverage prints the conditions of each trigger with their outcome counts
+func printCoverage(coverage []trigger.CriteriaCoverage, events int) {
+	if len(coverage) == 0 {
+		fmt.Println("No triggers with criteria found")
+		return
+	}
+	fmt.Printf("Criteria coverage over %d events\n", events)
+	for _, c := range coverage {
+		fmt.Printf("\nTrigger %s: %.0f%% covered, %d events evaluated, %d matched", c.TriggerID, c.Percent(), c.Evaluated, c.Matched)
+		if c.Errors > 0 {
+			fmt.Printf(", %d errors", c.Errors)
+		}
+		fmt.Println()
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "  TRUE\tFALSE\tSKIPPED\tERRORS\tSTATUS\tCONDITION")
+		for _, cond := range c.Conditions {
+			fmt.Fprintf(w, "  %d\t%d\t%d\t%d\t%s\t%s%s\n", cond.True, cond.False, cond.Skipped, cond.Errors,
+				cond.Status(), strings.Repeat("  ", cond.Depth), cond.Condition)
+		}
+		w.Flush()
+	}
+}
+
+// readEventsFile reads CloudEvents from a file of JSON lines or a JSON array
+func readEventsFile(path string) ([]*cloudevents.Event, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read events: %w", err)
+	}
+
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var events []*cloudevents.Event
+		if err := json.Unmarshal(trimmed, &events); err != nil {
+			return nil, fmt.Errorf("failed to parse events: %w", err)
+		}
+		return events, nil
+	}
+
+	var events []*cloudevents.Event
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		event := cloudevents.NewEvent()
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("failed to parse event on line %d: %w", line, err)
+		}
+		events = append(events, &event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read events: %w", err)
+	}
+	return events, nil
+}
+
+// readStoredEvents reads the CloudEvents stored in a stream with an ordered consumer.
+// Messages that are not CloudEvents are skipped.
+func readStoredEvents(nc *nats.Conn, streamName, subject string, since time.Duration) ([]*cloudevents.Event, error) {
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get JetStream context: %w", err)
+	}
+	info, err := js.StreamInfo(streamName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stream %s: %w", streamName, err)
+	}
+	if info.State.Msgs == 0 {
+		return nil, nil
+	}
+
+	opts := []nats.SubOpt{nats.BindStream(streamName), nats.OrderedConsumer()}
+	if since > 0 {
+		opts = append(opts, nats.StartTime(time.Now().Add(-since)))
+	} else {
+		opts = append(opts, nats.DeliverAll())
+	}
+	sub, err := js.SubscribeSync(subject, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stream %s: %w", streamName, err)
+	}
+	defer sub.Unsubscribe()
+
+	var events []*cloudevents.Event
+	for {
+		msg, err := sub.NextMsg(2 * time.Second)
+		if err == nats.ErrTimeout {
+			// Nothing (more) stored under the subject
+			return events, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stream %s: %w", streamName, err)
+		}
+		event := cloudevents.NewEvent()
+		if err := json.Unmarshal(msg.Data, &event); err == nil {
+			events = append(events, &event)
+		}
+		meta, err := msg.Metadata()
+		if err != nil || meta.NumPending == 0 {
+			return events, nil
+		}
+	}
+}
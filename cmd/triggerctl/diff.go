@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/julianshen/mycelium/internal/plan"
+	"github.com/julianshen/mycelium/internal/trigger"
+)
+
+// diffTriggers prints the changes that would make the stored triggers match a
+// directory of trigger manifests
+func diffTriggers(ctx context.Context, store *trigger.NATSStore, args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	dir := fs.String("f", "", "Directory of YAML trigger manifests")
+	asJSON := fs.Bool("json", false, "Print the plan as JSON")
+	detailedExitCode := fs.Bool("detailed-exitcode", false, "Exit 2 when there are changes, 0 when there are none")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return fmt.Errorf("usage: triggerctl diff -f <dir> [--json] [--detailed-exitcode]")
+	}
+
+	manifests, err := trigger.LoadManifests(ctx, *dir)
+	if err != nil {
+		return err
+	}
+	live, err := store.GetAllTriggers(ctx)
+	if err != nil {
+		return err
+	}
+
+	desired := make(map[string]interface{}, len(manifests))
+	for _, t := range manifests {
+		desired[t.ID] = t
+	}
+	current := make(map[string]interface{}, len(live))
+	for _, t := range live {
+		current[t.ID] = t
+	}
+	p, err := plan.Compute("trigger", desired, current)
+	if err != nil {
+		return err
+	}
+
+	if err := printPlan(p, *asJSON); err != nil {
+		return err
+	}
+	if *detailedExitCode && p.HasChanges() {
+		os.Exit(2)
+	}
+	return nil
+}
+
+// printPlan prints a plan as text or JSON
+func printPlan(p *plan.Plan, asJSON bool) error {
+	if !asJSON {
+		return p.WriteText(os.Stdout)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(p)
+}
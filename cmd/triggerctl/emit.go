@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	mevent "github.com/julianshen/mycelium/internal/event"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	ceevent "github.com/cloudevents/sdk-go/v2/event"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"gopkg.in/yaml.v3"
+)
+
+// extensionFlags collects repeated --ext key=value flags
+type extensionFlags map[string]string
+
+func (e extensionFlags) String() string {
+	return fmt.Sprint(map[string]string(e))
+}
+
+func (e extensionFlags) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("extension must be key=value, got %q", value)
+	}
+	e[key] = val
+	return nil
+}
+
+// emitOptions describes the event crafted by "triggerctl emit"
+type emitOptions struct {
+	eventType  string
+	namespace  string
+	id         string
+	source     string
+	subject    string
+	before     string
+	after      string
+	data       string
+	actorType  string
+	actorID    string
+	requestID  string
+	traceID    string
+	extensions extensionFlags
+	dryRun     bool
+}
+
+// parseEmitFlags parses the flags of the emit command
+func parseEmitFlags(args []string) (*emitOptions, error) {
+	opts := &emitOptions{extensions: extensionFlags{}}
+
+	fs := flag.NewFlagSet("emit", flag.ContinueOnError)
+	fs.StringVar(&opts.eventType, "type", "", "Event type, e.g. user.updated (required)")
+	fs.StringVar(&opts.namespace, "namespace", "", "Namespace prepended to the event type, e.g. prod")
+	fs.StringVar(&opts.id, "id", "", "Event ID (default: random UUID)")
+	fs.StringVar(&opts.source, "source", "mycelium/triggerctl", "Event source")
+	fs.StringVar(&opts.subject, "subject", "", "NATS subject to publish to (default: events.<type>)")
+	fs.StringVar(&opts.before, "before", "", "JSON or YAML file with the object state before the change")
+	fs.StringVar(&opts.after, "after", "", "JSON or YAML file with the object state after the change")
+	fs.StringVar(&opts.data, "data", "", "JSON or YAML file used as the complete event data (excludes --before/--after)")
+	fs.StringVar(&opts.actorType, "actor-type", "user", "Type of the actor causing the event")
+	fs.StringVar(&opts.actorID, "actor-id", "triggerctl", "ID of the actor causing the event")
+	fs.StringVar(&opts.requestID, "request-id", "", "Request ID (default: random UUID)")
+	fs.StringVar(&opts.traceID, "trace-id", "", "Trace ID (default: random UUID)")
+	fs.Var(opts.extensions, "ext", "Additional extension as key=value (repeatable)")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "Print the event instead of publishing it")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if opts.eventType == "" {
+		return nil, fmt.Errorf("--type is required")
+	}
+	if opts.data != "" && (opts.before != "" || opts.after != "") {
+		return nil, fmt.Errorf("--data cannot be combined with --before/--after")
+	}
+	return opts, nil
+}
+
+// buildEvent constructs a spec-compliant CloudEvent from the emit options
+func buildEvent(opts *emitOptions) (*cloudevents.Event, error) {
+	eventType := opts.eventType
+	if opts.namespace != "" && !strings.HasPrefix(eventType, opts.namespace+".") {
+		eventType = opts.namespace + "." + eventType
+	}
+
+	ce := cloudevents.NewEvent()
+	ce.SetID(orRandom(opts.id))
+	ce.SetSource(opts.source)
+	ce.SetType(eventType)
+	ce.SetTime(time.Now())
+	ce.SetExtension(mevent.ExtActorType, opts.actorType)
+	ce.SetExtension(mevent.ExtActorID, opts.actorID)
+	ce.SetExtension(mevent.ExtRequestID, orRandom(opts.requestID))
+	ce.SetExtension(mevent.ExtTraceID, orRandom(opts.traceID))
+	for key, value := range opts.extensions {
+		if !ceevent.IsExtensionNameValid(key) {
+			return nil, fmt.Errorf("invalid extension name %q: only alphanumeric characters are allowed", key)
+		}
+		ce.SetExtension(key, value)
+	}
+
+	var data interface{}
+	if opts.data != "" {
+		v, err := readDataFile(opts.data)
+		if err != nil {
+			return nil, err
+		}
+		data = v
+	} else {
+		change := map[string]interface{}{}
+		for field, file := range map[string]string{"before": opts.before, "after": opts.after} {
+			if file == "" {
+				continue
+			}
+			v, err := readDataFile(file)
+			if err != nil {
+				return nil, err
+			}
+			change[field] = v
+		}
+		data = change
+	}
+
+	if err := ce.SetData(cloudevents.ApplicationJSON, data); err != nil {
+		return nil, fmt.Errorf("failed to set event data: %w", err)
+	}
+	if err := ce.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid CloudEvent: %w", err)
+	}
+	return &ce, nil
+}
+
+// readDataFile decodes a JSON or YAML file, chosen by extension, "-" reads JSON from stdin
+func readDataFile(path string) (interface{}, error) {
+	var raw []byte
+	var err error
+	if path == "-" {
+		raw, err = io.ReadAll(os.Stdin)
+	} else {
+		raw, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var v interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(raw, &v)
+	default:
+		err = json.Unmarshal(raw, &v)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return v, nil
+}
+
+// emitEvent crafts an event from the command line and publishes it to JetStream, or
+// with plain NATS when the server does not offer JetStream
+func emitEvent(natsURL string, args []string) error {
+	opts, err := parseEmitFlags(args)
+	if err != nil {
+		return err
+	}
+
+	ce, err := buildEvent(opts)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(ce, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	if opts.dryRun {
+		fmt.Println(string(data))
+		return nil
+	}
+
+	subject := opts.subject
+	if subject == "" {
+		subject = "events." + ce.Type()
+	}
+
+	nc, err := nats.Connect(natsURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	defer nc.Close()
+
+	// Bare NATS servers have no streams; core-mode triggerd subscribes to the subject directly
+	enabled, err := mevent.JetStreamEnabled(nc)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		if err := nc.Publish(subject, data); err != nil {
+			return fmt.Errorf("failed to publish to %s: %w", subject, err)
+		}
+		if err := nc.Flush(); err != nil {
+			return fmt.Errorf("failed to publish to %s: %w", subject, err)
+		}
+		fmt.Printf("Emitted %s event %s to %s (core NATS)\n", ce.Type(), ce.ID(), subject)
+		return nil
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		return fmt.Errorf("failed to create JetStream context: %w", err)
+	}
+
+	ack, err := js.Publish(subject, data)
+	if err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", subject, err)
+	}
+
+	fmt.Printf("Emitted %s event %s to %s (stream %s, seq %d)\n", ce.Type(), ce.ID(), subject, ack.Stream, ack.Sequence)
+	return nil
+}
+
+func orRandom(value string) string {
+	if value == "" {
+		return uuid.NewString()
+	}
+	return value
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/julianshen/mycelium/internal/trigger"
+)
+
+// printEnvironment prints the criteria expression environment as a table or as JSON
+func printEnvironment(args []string) error {
+	fs := flag.NewFlagSet("env", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "Print the environment as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	env := trigger.ExprEnvironment()
+	if *asJSON {
+		data, err := json.MarshalIndent(env, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal environment: %w", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FIELD\tTYPE\tDESCRIPTION")
+	for _, f := range env.Fields {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", f.Path, f.Type, f.Description)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "FUNCTION\tDESCRIPTION")
+	for _, f := range env.Functions {
+		fmt.Fprintf(w, "%s\t%s\n", f.Signature, f.Description)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/julianshen/mycelium/internal/action"
+	"github.com/julianshen/mycelium/internal/function"
+	"github.com/julianshen/mycelium/internal/trigger"
+
+	"github.com/nats-io/nats.go"
+)
+
+// graphTriggers prints the event flow graph of all triggers
+func graphTriggers(ctx context.Context, nc *nats.Conn, store *trigger.NATSStore, args []string) error {
+	fs := flag.NewFlagSet("graph", flag.ContinueOnError)
+	format := fs.String("format", "dot", "Output format: dot or json")
+	bucket := fs.String("function-bucket", function.DefaultFunctionBucket, "KV bucket of function metadata declaring emitted event types (empty skips functions' outputs)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != "dot" && *format != "json" {
+		return fmt.Errorf("unknown format %q (expected dot or json)", *format)
+	}
+
+	triggers, err := store.GetAllTriggers(ctx)
+	if err != nil {
+		return err
+	}
+	emits, err := functionOutputs(nc, namePrefix.Name(*bucket))
+	if err != nil {
+		return err
+	}
+
+	g := action.BuildGraph(triggers, emits)
+	for _, cycle := range g.Cycles {
+		fmt.Fprintf(os.Stderr, "Warning: cycle through %s\n", strings.Join(cycle, ", "))
+	}
+
+	if *format == "json" {
+		data, err := json.MarshalIndent(g, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	return g.WriteDOT(os.Stdout)
+}
+
+// functionOutputs reads the event types each function declares it emits from the
+// function metadata bucket, without creating the bucket
+func functionOutputs(nc *nats.Conn, bucket string) (map[string][]string, error) {
+	emits := make(map[string][]string)
+	if bucket == "" {
+		return emits, nil
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JetStream context: %w", err)
+	}
+	kv, err := js.KeyValue(bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		return emits, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open function bucket: %w", err)
+	}
+
+	keys, err := kv.Keys()
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return emits, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list functions: %w", err)
+	}
+	for _, key := range keys {
+		entry, err := kv.Get(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get function %s: %w", key, err)
+		}
+		var meta function.FunctionMeta
+		if err := json.Unmarshal(entry.Value(), &meta); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal function %s: %w", key, err)
+		}
+		emits[meta.Name] = meta.Emits
+	}
+	return emits, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/heartbeat"
+
+	"github.com/nats-io/nats.go"
+)
+
+// manageHeartbeats runs the heartbeat register/list/delete/beat subcommands
+func manageHeartbeats(natsURL string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: triggerctl heartbeat <register|list|delete|beat> [options]")
+	}
+
+	fs := flag.NewFlagSet("heartbeat "+args[0], flag.ContinueOnError)
+	bucket := fs.String("bucket", heartbeat.DefaultBucket, "KV bucket heartbeats are registered in")
+	subject := fs.String("subject", heartbeat.DefaultBeatSubject, "NATS subject prefix beats are published under")
+	interval := fs.Duration("interval", 0, "How often the system beats")
+	grace := fs.Duration("grace", 0, "How much later than the interval a beat may arrive before it is missed")
+	description := fs.String("description", "", "Description carried by heartbeat events")
+	labels := fs.String("labels", "", "Comma-separated key=value labels carried by heartbeat events")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if args[0] != "list" && fs.NArg() != 1 {
+		return fmt.Errorf("usage: triggerctl heartbeat %s [options] <name>", args[0])
+	}
+
+	nc, err := nats.Connect(natsURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	defer nc.Close()
+
+	// Beats go straight to the monitors, which reject unregistered names
+	if args[0] == "beat" {
+		if err := heartbeat.Publish(nc, *subject, fs.Arg(0)); err != nil {
+			return err
+		}
+		if err := nc.Flush(); err != nil {
+			return fmt.Errorf("failed to flush heartbeat: %w", err)
+		}
+		fmt.Printf("Heartbeat %s sent\n", fs.Arg(0))
+		return nil
+	}
+
+	registry, err := heartbeat.NewRegistry(nc, namePrefix.Name(*bucket))
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "register":
+		h := heartbeat.Heartbeat{
+			Name:        fs.Arg(0),
+			Interval:    *interval,
+			Grace:       *grace,
+			Description: *description,
+		}
+		if *labels != "" {
+			h.Labels = make(map[string]string)
+			for _, label := range strings.Split(*labels, ",") {
+				key, value, ok := strings.Cut(label, "=")
+				if !ok {
+					return fmt.Errorf("invalid label %q: expected key=value", label)
+				}
+				h.Labels[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			}
+		}
+		registered, err := registry.Register(ctx, h)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Heartbeat %s registered, next beat due by %s\n", registered.Name, registered.Deadline().Format(time.RFC3339))
+
+	case "list":
+		heartbeats, err := registry.List(ctx)
+		if err != nil {
+			return err
+		}
+		if len(heartbeats) == 0 {
+			fmt.Println("No heartbeats registered")
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tINTERVAL\tGRACE\tLAST BEAT\tSTATUS")
+		for _, h := range heartbeats {
+			lastBeat := "never"
+			if !h.LastBeat.IsZero() {
+				lastBeat = h.LastBeat.Format(time.RFC3339)
+			}
+			status := "ok"
+			if h.Missed {
+				status = "missed since " + h.MissedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", h.Name, h.Interval, h.Grace, lastBeat, status)
+		}
+		w.Flush()
+
+	case "delete":
+		if err := registry.Delete(ctx, fs.Arg(0)); err != nil {
+			return err
+		}
+		fmt.Printf("Heartbeat %s deleted\n", fs.Arg(0))
+
+	default:
+		return fmt.Errorf("unknown heartbeat command: %s", args[0])
+	}
+	return nil
+}
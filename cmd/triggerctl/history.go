@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/trigger"
+)
+
+// showHistory prints the changelog records of a trigger, oldest first
+func showHistory(ctx context.Context, store *trigger.NATSStore, storeNamespace string, args []string) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "Print the records as JSON, with the YAML before and after each change")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: triggerctl history <id> [--json]")
+	}
+
+	records, err := store.History(ctx, storeNamespace, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(records)
+	}
+	if len(records) == 0 {
+		fmt.Printf("No history for trigger %s\n", fs.Arg(0))
+		return nil
+	}
+	fmt.Printf("%-10s %-25s %-8s %s\n", "REVISION", "TIME", "CHANGE", "ACTOR")
+	for _, r := range records {
+		change := r.Operation
+		if r.RestoredFrom != 0 {
+			change += fmt.Sprintf(" (restored from %d)", r.RestoredFrom)
+		}
+		actor := r.Actor
+		if actor == "" {
+			actor = "-"
+		}
+		fmt.Printf("%-10d %-25s %-8s %s\n", r.Revision, r.Time.Format(time.RFC3339), change, actor)
+	}
+	return nil
+}
+
+// restoreTrigger brings a trigger back to a changelog revision
+func restoreTrigger(ctx context.Context, store *trigger.NATSStore, storeNamespace string, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: triggerctl restore <id> <revision>")
+	}
+	revision, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil || revision == 0 {
+		return fmt.Errorf("invalid revision %q", args[1])
+	}
+
+	t, err := store.RestoreTrigger(ctx, storeNamespace, args[0], revision)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		fmt.Printf("Trigger %s deleted, as of revision %d\n", args[0], revision)
+		return nil
+	}
+	fmt.Printf("Trigger %s restored to revision %d\n", args[0], revision)
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/action"
+
+	"github.com/nats-io/nats.go"
+)
+
+// manageKillSwitch runs the killswitch on/off/status subcommands
+func manageKillSwitch(natsURL string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: triggerctl killswitch <on|off|status> [options]")
+	}
+
+	fs := flag.NewFlagSet("killswitch "+args[0], flag.ContinueOnError)
+	bucket := fs.String("bucket", action.DefaultControlBucket, "KV bucket holding the kill switch")
+	reason := fs.String("reason", "", "Why actions are paused, shown by status")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	nc, err := nats.Connect(natsURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	defer nc.Close()
+
+	killSwitch, err := action.NewKillSwitch(nc, namePrefix.Name(*bucket))
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "on":
+		if err := killSwitch.Engage(ctx, *reason); err != nil {
+			return err
+		}
+		fmt.Println("Kill switch engaged: actions are paused on every trigger daemon")
+
+	case "off":
+		if err := killSwitch.Release(ctx); err != nil {
+			return err
+		}
+		fmt.Println("Kill switch released: actions resume")
+
+	case "status":
+		state, err := killSwitch.State(ctx)
+		if err != nil {
+			return err
+		}
+		if !state.Engaged {
+			fmt.Println("Kill switch is off")
+			return nil
+		}
+		fmt.Printf("Kill switch is engaged since %s\n", state.Since.Format(time.RFC3339))
+		if state.Reason != "" {
+			fmt.Printf("Reason: %s\n", state.Reason)
+		}
+
+	default:
+		return fmt.Errorf("unknown killswitch command: %s", args[0])
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/event"
+
+	"github.com/nats-io/nats.go"
+)
+
+// showLineage prints the causal chain of an event, the event first
+func showLineage(natsURL, streamName string, args []string) error {
+	fs := flag.NewFlagSet("lineage", flag.ContinueOnError)
+	streams := fs.String("streams", "", "Comma-separated streams searched for the events of the chain (default: --stream)")
+	since := fs.Duration("since", 0, "Search events stored within this duration, e.g. 24h (default: all stored events)")
+	depth := fs.Int("depth", event.DefaultLineageDepth, "Generations of ancestors to follow")
+	asJSON := fs.Bool("json", false, "Print the chain as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: triggerctl lineage [--streams s1,s2] [--since 24h] [--json] <event-id>")
+	}
+
+	cfg := event.LineageConfig{Streams: []string{streamName}, MaxDepth: *depth}
+	if *streams != "" {
+		cfg.Streams = nil
+		for _, stream := range strings.Split(*streams, ",") {
+			cfg.Streams = append(cfg.Streams, namePrefix.Name(stream))
+		}
+	}
+	if *since > 0 {
+		cfg.StartTime = time.Now().Add(-*since)
+	}
+
+	nc, err := nats.Connect(natsURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	defer nc.Close()
+
+	chain, err := event.TraceLineage(context.Background(), nc, cfg, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(chain)
+	}
+	for _, node := range chain {
+		indent := strings.Repeat("  ", node.Depth)
+		if node.Missing {
+			fmt.Printf("%s%s (not found in the searched streams)\n", indent, node.ID)
+			continue
+		}
+		fmt.Printf("%s%s %s from %s at %s", indent, node.ID, node.Type, node.Source, node.Time.Format(time.RFC3339))
+		if node.ProducedBy != "" {
+			fmt.Printf(", produced by %s", node.ProducedBy)
+		}
+		fmt.Println()
+	}
+	return nil
+}
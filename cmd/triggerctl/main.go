@@ -7,16 +7,32 @@ import (
 	"log"
 	"os"
 
-	"mycelium/internal/trigger"
+	"github.com/julianshen/mycelium/internal/namespace"
+	"github.com/julianshen/mycelium/internal/naming"
+	"github.com/julianshen/mycelium/internal/trigger"
 
 	"github.com/nats-io/nats.go"
 )
 
+// namePrefix is the tenant and environment prefixed to stream and bucket names
+var namePrefix naming.Prefix
+
 func main() {
 	// Parse command line flags
 	natsURL := flag.String("nats-url", "nats://localhost:4222", "NATS server URL")
 	streamName := flag.String("stream", "config-stream", "NATS stream name")
+	limit := flag.Int("limit", 0, "Maximum number of triggers to list per page (0 lists all)")
+	page := flag.Int("page", 1, "Page number to list when --limit is set")
+	changelogStream := flag.String("changelog", trigger.DefaultChangelogStream, "JetStream stream recording trigger changes (empty disables history)")
+	actor := flag.String("actor", os.Getenv("USER"), "Name recorded in the changelog for changes")
+	storeNamespace := flag.String("namespace", "default", "Namespace triggers are saved in and deleted from")
+	namespaceBucket := flag.String("namespace-bucket", namespace.DefaultBucket, "KV bucket of provisioned namespaces whose trigger policies are enforced (empty disables policies)")
+	namePrefix.RegisterFlags(flag.CommandLine)
 	flag.Parse()
+	if err := namePrefix.Validate(); err != nil {
+		log.Fatalf("Invalid name prefix: %v", err)
+	}
+	namePrefix.Apply(streamName, changelogStream, namespaceBucket)
 
 	// Get subcommand
 	args := flag.Args()
@@ -26,10 +42,121 @@ func main() {
 		fmt.Println("  add <yaml-file>    Add a trigger from YAML file")
 		fmt.Println("  list               List all triggers")
 		fmt.Println("  delete <id>        Delete a trigger by ID")
+		fmt.Println("  history <id> [--json]  Show the recorded changes of a trigger")
+		fmt.Println("  restore <id> <revision>  Restore a trigger to a revision of its history")
+		fmt.Println("  validate <yaml-file> Validate a trigger YAML file without saving it")
+		fmt.Println("  analyze            Report overlapping and never-matching triggers")
+		fmt.Println("  diff -f <dir> [--json]  Show the changes that would make the stored triggers match a manifest directory")
+		fmt.Println("  coverage [flags] [id...]  Report which criteria conditions recorded events exercised (see coverage -h)")
+		fmt.Println("  template add|list|show|delete  Manage trigger templates")
+		fmt.Println("  instantiate <template> [--param k=v] [--namespace ns]  Create a trigger from a template")
+		fmt.Println("  graph [--format dot|json]  Print the event flow graph and report cycles")
+		fmt.Println("  emit [flags]       Craft a CloudEvent and publish it (see emit -h)")
+		fmt.Println("  replay [flags]     Republish stored events, resuming interrupted replays (see replay -h)")
+		fmt.Println("  redrive --stream <stream>  Publish poison messages back to the subjects they failed on (see redrive -h)")
+		fmt.Println("  lineage <event-id> Print the chain of events and functions an event derives from")
+		fmt.Println("  schema             Print the JSON Schema for trigger definitions")
+		fmt.Println("  env [--json]       Print the fields and functions available to criteria")
+		fmt.Println("  namespace create|list|show|policy  Provision and inspect tenant namespaces")
+		fmt.Println("  killswitch on|off|status    Pause or resume action execution on every daemon")
+		fmt.Println("  maintenance on|off|status   Park matches on every daemon and replay them afterwards")
+		fmt.Println("  heartbeat register|list|delete|beat  Manage the heartbeats external systems are monitored by")
+		fmt.Println("  audit keygen|list|verify    Manage and verify the tamper-evident audit trail")
+		fmt.Println("  profile --instance <id> <kind>  Fetch a Go profile or the runtime metrics of a daemon (see profile -h)")
 		fmt.Println("  examples           Generate example trigger definitions")
 		os.Exit(1)
 	}
 
+	// Commands that don't need the trigger store
+	switch args[0] {
+	case "validate":
+		if len(args) != 2 {
+			log.Fatal("Usage: triggerctl validate <yaml-file>")
+		}
+		if _, err := readTrigger(args[1]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("Trigger is valid")
+		return
+
+	case "schema":
+		fmt.Println(string(trigger.Schema()))
+		return
+
+	case "env":
+		if err := printEnvironment(args[1:]); err != nil {
+			log.Fatalf("Failed to print expression environment: %v", err)
+		}
+		return
+
+	case "namespace":
+		if err := manageNamespaces(*natsURL, *streamName, args[1:]); err != nil {
+			log.Fatalf("Namespace command failed: %v", err)
+		}
+		return
+
+	case "emit":
+		if err := emitEvent(*natsURL, args[1:]); err != nil {
+			log.Fatalf("Failed to emit event: %v", err)
+		}
+		return
+
+	case "replay":
+		if err := replayEvents(*natsURL, *streamName, args[1:]); err != nil {
+			log.Fatalf("Replay failed: %v", err)
+		}
+		return
+
+	case "redrive":
+		if err := redriveEvents(*natsURL, args[1:]); err != nil {
+			log.Fatalf("Redrive failed: %v", err)
+		}
+		return
+
+	case "lineage":
+		if err := showLineage(*natsURL, *streamName, args[1:]); err != nil {
+			log.Fatalf("Failed to trace lineage: %v", err)
+		}
+		return
+
+	case "killswitch":
+		if err := manageKillSwitch(*natsURL, args[1:]); err != nil {
+			log.Fatalf("Kill switch command failed: %v", err)
+		}
+		return
+
+	case "maintenance":
+		if err := manageMaintenance(*natsURL, args[1:]); err != nil {
+			log.Fatalf("Maintenance command failed: %v", err)
+		}
+		return
+
+	case "heartbeat":
+		if err := manageHeartbeats(*natsURL, args[1:]); err != nil {
+			log.Fatalf("Heartbeat command failed: %v", err)
+		}
+		return
+
+	case "audit":
+		if err := manageAuditTrail(*natsURL, args[1:]); err != nil {
+			log.Fatalf("Audit command failed: %v", err)
+		}
+		return
+
+	case "profile":
+		if err := profileDaemon(*natsURL, args[1:]); err != nil {
+			log.Fatalf("Profile failed: %v", err)
+		}
+		return
+
+	case "template":
+		if err := manageTemplates(*natsURL, args[1:]); err != nil {
+			log.Fatalf("Template command failed: %v", err)
+		}
+		return
+	}
+
 	// Connect to NATS
 	nc, err := nats.Connect(*natsURL)
 	if err != nil {
@@ -43,9 +170,23 @@ func main() {
 		log.Fatalf("Failed to create trigger store: %v", err)
 	}
 	defer store.Close()
+	if *changelogStream != "" {
+		changelog, err := trigger.NewChangelog(nc, *changelogStream, "")
+		if err != nil {
+			log.Fatalf("Failed to open trigger changelog: %v", err)
+		}
+		store.SetChangelog(changelog)
+	}
+	if *namespaceBucket != "" {
+		provisioner, err := namespace.NewProvisioner(nc, namespace.ProvisionerConfig{Bucket: *namespaceBucket, TriggerBucket: *streamName, Prefix: namePrefix})
+		if err != nil {
+			log.Fatalf("Failed to open namespace policies: %v", err)
+		}
+		store.SetPolicies(provisioner)
+	}
 
 	// Load existing triggers
-	ctx := context.Background()
+	ctx := trigger.WithActor(context.Background(), *actor)
 	if err := store.LoadAll(ctx); err != nil {
 		log.Fatalf("Failed to load triggers: %v", err)
 	}
@@ -56,37 +197,60 @@ func main() {
 		if len(args) != 2 {
 			log.Fatal("Usage: triggerctl add <yaml-file>")
 		}
-		if err := addTrigger(ctx, store, args[1]); err != nil {
+		if err := addTrigger(ctx, store, *storeNamespace, args[1]); err != nil {
 			log.Fatalf("Failed to add trigger: %v", err)
 		}
 		fmt.Println("Trigger added successfully")
 
 	case "list":
-		triggers := store.GetAllTriggers()
-		if len(triggers) == 0 {
-			fmt.Println("No triggers found")
-			return
-		}
-		for _, t := range triggers {
-			fmt.Printf("\nTrigger: %s\n", t.Name)
-			fmt.Printf("  ID: %s\n", t.ID)
-			fmt.Printf("  Namespaces: %v\n", t.Namespaces)
-			fmt.Printf("  Event Type: %s\n", t.EventType)
-			fmt.Printf("  Object Type: %s\n", t.ObjectType)
-			fmt.Printf("  Criteria: %s\n", t.Criteria)
-			fmt.Printf("  Action: %s\n", t.Action)
-			fmt.Printf("  Enabled: %v\n", t.Enabled)
+		if err := listTriggers(ctx, store, *limit, *page); err != nil {
+			log.Fatalf("Failed to list triggers: %v", err)
 		}
 
 	case "delete":
 		if len(args) != 2 {
 			log.Fatal("Usage: triggerctl delete <id>")
 		}
-		if err := store.DeleteTrigger(ctx, "default", args[1]); err != nil {
+		if err := store.DeleteTrigger(ctx, *storeNamespace, args[1]); err != nil {
 			log.Fatalf("Failed to delete trigger: %v", err)
 		}
 		fmt.Println("Trigger deleted successfully")
 
+	case "history":
+		if err := showHistory(ctx, store, *storeNamespace, args[1:]); err != nil {
+			log.Fatalf("Failed to show trigger history: %v", err)
+		}
+
+	case "restore":
+		if err := restoreTrigger(ctx, store, *storeNamespace, args[1:]); err != nil {
+			log.Fatalf("Failed to restore trigger: %v", err)
+		}
+
+	case "analyze":
+		if err := analyzeTriggers(ctx, store); err != nil {
+			log.Fatalf("Failed to analyze triggers: %v", err)
+		}
+
+	case "diff":
+		if err := diffTriggers(ctx, store, args[1:]); err != nil {
+			log.Fatalf("Failed to diff triggers: %v", err)
+		}
+
+	case "coverage":
+		if err := reportCoverage(ctx, nc, store, *streamName, args[1:]); err != nil {
+			log.Fatalf("Failed to report criteria coverage: %v", err)
+		}
+
+	case "instantiate":
+		if err := instantiateTemplate(ctx, nc, store, *storeNamespace, args[1:]); err != nil {
+			log.Fatalf("Failed to instantiate template: %v", err)
+		}
+
+	case "graph":
+		if err := graphTriggers(ctx, nc, store, args[1:]); err != nil {
+			log.Fatalf("Failed to graph triggers: %v", err)
+		}
+
 	case "examples":
 		generateExamples()
 
@@ -95,21 +259,120 @@ func main() {
 	}
 }
 
-func addTrigger(ctx context.Context, store *trigger.NATSStore, yamlFile string) error {
-	// Read YAML file
-	data, err := os.ReadFile(yamlFile)
+func listTriggers(ctx context.Context, store *trigger.NATSStore, limit, page int) error {
+	if limit < 0 {
+		return fmt.Errorf("limit must not be negative")
+	}
+	if page < 1 {
+		return fmt.Errorf("page must be at least 1")
+	}
+
+	// Without a limit, stream triggers instead of loading them all into a slice
+	if limit == 0 {
+		count := 0
+		err := store.ForEachTrigger(ctx, func(t *trigger.Trigger) bool {
+			printTrigger(t)
+			count++
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			fmt.Println("No triggers found")
+		}
+		return nil
+	}
+
+	triggers, total, err := store.ListTriggers(ctx, trigger.ListOptions{
+		Offset: (page - 1) * limit,
+		Limit:  limit,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to read YAML file: %w", err)
+		return err
+	}
+	if total == 0 {
+		fmt.Println("No triggers found")
+		return nil
 	}
+	for _, t := range triggers {
+		printTrigger(t)
+	}
+
+	pages := (total + limit - 1) / limit
+	fmt.Printf("\nPage %d of %d (%d triggers total)\n", page, pages, total)
+	return nil
+}
 
-	// Parse trigger
-	var t trigger.Trigger
-	if err := t.FromYAML(data); err != nil {
-		return fmt.Errorf("failed to parse trigger: %w", err)
+// analyzeTriggers prints the findings of trigger analysis and exits non-zero when there are any
+func analyzeTriggers(ctx context.Context, store *trigger.NATSStore) error {
+	triggers, err := store.GetAllTriggers(ctx)
+	if err != nil {
+		return err
+	}
+
+	findings := trigger.AnalyzeTriggers(triggers)
+	if len(findings) == 0 {
+		fmt.Printf("No conflicts found in %d triggers\n", len(triggers))
+		return nil
+	}
+	for _, f := range findings {
+		fmt.Println(f)
+	}
+	os.Exit(1)
+	return nil
+}
+
+func printTrigger(t *trigger.Trigger) {
+	fmt.Printf("\nTrigger: %s\n", t.Name)
+	fmt.Printf("  ID: %s\n", t.ID)
+	fmt.Printf("  Namespaces: %v\n", t.Namespaces)
+	fmt.Printf("  Event Type: %s\n", t.EventType)
+	fmt.Printf("  Object Type: %s\n", t.ObjectType)
+	fmt.Printf("  Criteria: %s\n", t.Criteria)
+	if t.Except != "" {
+		fmt.Printf("  Except: %s\n", t.Except)
+	}
+	if len(t.Vars) > 0 {
+		fmt.Printf("  Vars: %v\n", t.Vars)
+	}
+	fmt.Printf("  Action: %s\n", t.Action)
+	fmt.Printf("  Enabled: %v\n", t.Enabled)
+	if len(t.Labels) > 0 {
+		fmt.Printf("  Labels: %v\n", t.Labels)
+	}
+	if t.Timeout != "" {
+		fmt.Printf("  Timeout: %s\n", t.Timeout)
+	}
+	if t.IgnoreReplays {
+		fmt.Printf("  Ignores Replays: true\n")
+	}
+}
+
+func addTrigger(ctx context.Context, store *trigger.NATSStore, storeNamespace, yamlFile string) error {
+	t, err := readTrigger(yamlFile)
+	if err != nil {
+		return err
 	}
 
 	// Save trigger
-	return store.SaveTrigger(ctx, "default", t.ID, &t)
+	return store.SaveTrigger(ctx, storeNamespace, t.ID, t)
+}
+
+// readTrigger reads a YAML trigger definition and validates it against the trigger schema
+func readTrigger(yamlFile string) (*trigger.Trigger, error) {
+	// Read YAML file
+	data, err := os.ReadFile(yamlFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read YAML file: %w", err)
+	}
+
+	// Parse and validate trigger
+	t, err := trigger.ParseYAML(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trigger in %s:\n%w", yamlFile, err)
+	}
+	return t, nil
 }
 
 func generateExamples() {
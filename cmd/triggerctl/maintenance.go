@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/action"
+
+	"github.com/nats-io/nats.go"
+)
+
+// manageMaintenance runs the maintenance on/off/status subcommands
+func manageMaintenance(natsURL string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: triggerctl maintenance <on|off|status> [options]")
+	}
+
+	fs := flag.NewFlagSet("maintenance "+args[0], flag.ContinueOnError)
+	bucket := fs.String("bucket", action.DefaultControlBucket, "KV bucket holding maintenance mode")
+	stream := fs.String("stream", action.DefaultParkingStream, "JetStream stream matches are parked in")
+	reason := fs.String("reason", "", "Why maintenance mode is on, shown by status")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	nc, err := nats.Connect(natsURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	defer nc.Close()
+
+	maintenance, err := action.NewMaintenance(nc, namePrefix.Name(*bucket), namePrefix.Name(*stream))
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "on":
+		if err := maintenance.Enable(ctx, *reason); err != nil {
+			return err
+		}
+		fmt.Println("Maintenance mode on: matches are parked on every trigger daemon")
+
+	case "off":
+		if err := maintenance.Disable(ctx); err != nil {
+			return err
+		}
+		fmt.Println("Maintenance mode off: parked matches are replayed")
+
+	case "status":
+		state, err := maintenance.State(ctx)
+		if err != nil {
+			return err
+		}
+		if state.Enabled {
+			fmt.Printf("Maintenance mode is on since %s\n", state.Since.Format(time.RFC3339))
+			if state.Reason != "" {
+				fmt.Printf("Reason: %s\n", state.Reason)
+			}
+		} else {
+			fmt.Println("Maintenance mode is off")
+		}
+		fmt.Printf("Parked matches: %d\n", state.Parked)
+
+	default:
+		return fmt.Errorf("unknown maintenance command: %s", args[0])
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/julianshen/mycelium/internal/function"
+	"github.com/julianshen/mycelium/internal/namespace"
+	"github.com/julianshen/mycelium/internal/trigger"
+
+	"github.com/nats-io/nats.go"
+)
+
+// manageNamespaces runs the namespace create/list/show/policy subcommands
+func manageNamespaces(natsURL, triggerBucket string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: triggerctl namespace <create|list|show|policy> [options]")
+	}
+
+	nc, err := nats.Connect(natsURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	defer nc.Close()
+
+	provisioner, err := namespace.NewProvisioner(nc, namespace.ProvisionerConfig{
+		Bucket:        namePrefix.Name(namespace.DefaultBucket),
+		TriggerBucket: triggerBucket,
+		Prefix:        namePrefix,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("namespace create", flag.ContinueOnError)
+		maxAge := fs.Duration("max-age", namespace.DefaultMaxAge, "Retention of the namespace event stream")
+		maxBytes := fs.Int64("max-bytes", 0, "Size limit of the namespace event stream (0 is unlimited)")
+		replicas := fs.Int("replicas", namespace.DefaultReplicas, "Replicas of the stream and buckets")
+		maxFunctions := fs.Int("max-functions", 0, "Number of functions the namespace may store (0 is unlimited)")
+		maxBinaryBytes := fs.Int64("max-binary-bytes", 0, "Size of the function binaries the namespace may store (0 is unlimited)")
+		policyFile := fs.String("trigger-policy", "", "YAML file with the policy enforced on the namespace's triggers")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: triggerctl namespace create [options] <name>")
+		}
+		var policy *trigger.Policy
+		if *policyFile != "" {
+			if policy, err = trigger.LoadPolicy(*policyFile); err != nil {
+				return err
+			}
+		}
+
+		res, err := provisioner.Create(ctx, namespace.Config{
+			Name:          fs.Arg(0),
+			MaxAge:        *maxAge,
+			MaxBytes:      *maxBytes,
+			Replicas:      *replicas,
+			Quota:         function.StorageQuota{MaxFunctions: *maxFunctions, MaxBinaryBytes: *maxBinaryBytes},
+			TriggerPolicy: policy,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Namespace %s provisioned\n", res.Namespace)
+		printNamespace(res)
+
+	case "list":
+		namespaces, err := provisioner.List(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAMESPACE\tSTREAM\tSUBJECTS\tRETENTION\tCREATED")
+		for _, res := range namespaces {
+			fmt.Fprintf(w, "%s\t%s\t%v\t%s\t%s\n", res.Namespace, res.Stream, res.Subjects, res.MaxAge, res.CreatedAt.Format("2006-01-02 15:04"))
+		}
+		return w.Flush()
+
+	case "show":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: triggerctl namespace show <name>")
+		}
+		res, err := provisioner.Get(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		printNamespace(res)
+
+	case "policy":
+		fs := flag.NewFlagSet("namespace policy", flag.ContinueOnError)
+		remove := fs.Bool("clear", false, "Remove the namespace's trigger policy")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 2 && !(*remove && fs.NArg() == 1) {
+			return fmt.Errorf("usage: triggerctl namespace policy <name> <policy-file> | --clear <name>")
+		}
+		var policy *trigger.Policy
+		if !*remove {
+			if policy, err = trigger.LoadPolicy(fs.Arg(1)); err != nil {
+				return err
+			}
+		}
+		res, err := provisioner.SetTriggerPolicy(ctx, fs.Arg(0), policy)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Trigger policy of namespace %s updated\n", res.Namespace)
+		printNamespace(res)
+
+	default:
+		return fmt.Errorf("unknown namespace command: %s", args[0])
+	}
+	return nil
+}
+
+// printNamespace prints the resources of a namespace
+func printNamespace(res *namespace.Resources) {
+	fmt.Printf("  Event stream:    %s %v (max age %s)\n", res.Stream, res.Subjects, res.MaxAge)
+	fmt.Printf("  Trigger keys:    %s in bucket %s\n", res.TriggerPrefix+"*", res.TriggerBucket)
+	fmt.Printf("  Function bucket: %s\n", res.FunctionBucket)
+	fmt.Printf("  Binary bucket:   %s\n", res.BinaryBucket)
+	if !res.Quota.Unlimited() {
+		fmt.Printf("  Quota:           %s\n", formatQuota(res.Quota))
+	}
+	if !res.TriggerPolicy.Unrestricted() {
+		fmt.Printf("  Trigger policy:  %s\n", formatPolicy(res.TriggerPolicy))
+	}
+}
+
+// formatPolicy describes the settings of a trigger policy
+func formatPolicy(policy *trigger.Policy) string {
+	var parts []string
+	if policy.DefaultTimeout != "" {
+		parts = append(parts, "default timeout "+policy.DefaultTimeout)
+	}
+	if len(policy.RequiredLabels) > 0 {
+		parts = append(parts, "required labels "+strings.Join(policy.RequiredLabels, ", "))
+	}
+	if len(policy.AllowedActionTypes) > 0 {
+		parts = append(parts, "actions "+strings.Join(policy.AllowedActionTypes, ", "))
+	}
+	if policy.MaxTriggers > 0 {
+		parts = append(parts, fmt.Sprintf("at most %d triggers", policy.MaxTriggers))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// formatQuota describes the limits of a quota
+func formatQuota(quota function.StorageQuota) string {
+	functions, bytes := "unlimited", "unlimited"
+	if quota.MaxFunctions > 0 {
+		functions = fmt.Sprint(quota.MaxFunctions)
+	}
+	if quota.MaxBinaryBytes > 0 {
+		bytes = fmt.Sprint(quota.MaxBinaryBytes)
+	}
+	return fmt.Sprintf("%s functions, %s binary bytes", functions, bytes)
+}
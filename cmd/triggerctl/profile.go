@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/profiling"
+
+	"github.com/nats-io/nats.go"
+)
+
+// profileDaemon fetches a Go profile or the runtime metrics of a trigger daemon
+func profileDaemon(natsURL string, args []string) error {
+	fs := flag.NewFlagSet("profile", flag.ContinueOnError)
+	instance := fs.String("instance", "", "Instance ID of the daemon to profile, as given by its --instance-id")
+	subject := fs.String("subject", "", "Profiling subject of the daemon, when it sets --profile-subject")
+	seconds := fs.Int("seconds", 0, "How long a CPU profile samples (default: 30)")
+	token := fs.String("token", os.Getenv("MYCELIUM_PROFILE_TOKEN"), "Admin token (default: $MYCELIUM_PROFILE_TOKEN)")
+	output := fs.String("o", "", "File the profile is written to (default: <kind>.pprof)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || (*instance == "") == (*subject == "") {
+		return fmt.Errorf("usage: triggerctl profile --instance <id> | --subject <subject> [--seconds N] [-o file] cpu|heap|allocs|goroutine|block|mutex|metrics")
+	}
+	if *subject == "" {
+		*subject = profiling.DefaultSubjectPrefix + "." + *instance
+	}
+
+	nc, err := nats.Connect(natsURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	defer nc.Close()
+
+	req := profiling.Request{Kind: fs.Arg(0), Seconds: *seconds}
+	ctx, cancel := context.WithTimeout(context.Background(), profiling.RequestTimeout(req, 10*time.Second))
+	defer cancel()
+
+	if req.Kind == profiling.KindMetrics {
+		samples, err := profiling.FetchMetrics(ctx, nc, *subject, *token)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(samples)
+	}
+
+	if req.Kind == profiling.KindCPU {
+		fmt.Fprintf(os.Stderr, "Sampling CPU for %s...\n", profiling.RequestTimeout(req, 0))
+	}
+	data, err := profiling.Fetch(ctx, nc, *subject, *token, req)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		*output = req.Kind + ".pprof"
+	}
+	if err := os.WriteFile(*output, data, 0644); err != nil {
+		return fmt.Errorf("failed to write profile: %w", err)
+	}
+	fmt.Printf("Wrote %s profile to %s, inspect it with: go tool pprof %s\n", req.Kind, *output, *output)
+	return nil
+}
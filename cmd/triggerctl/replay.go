@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/event"
+
+	"github.com/nats-io/nats.go"
+)
+
+// replayEvents republishes stored events of the stream, resuming the checkpoint of an
+// interrupted replay with the same name
+func replayEvents(natsURL, streamName string, args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	subject := fs.String("subject", "config.>", "Subject of the events to replay")
+	name := fs.String("name", "", "Checkpoint name; rerunning an interrupted replay with the same name resumes it (default: derived from stream and subject)")
+	since := fs.Duration("since", 0, "Replay events stored within this duration, e.g. 1h (default: all stored events)")
+	fromSeq := fs.Uint64("from-seq", 0, "Replay events from this stream sequence")
+	bucket := fs.String("bucket", event.DefaultReplayBucket, "KV bucket holding replay checkpoints")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg := event.ReplayConfig{
+		Stream:        streamName,
+		Subject:       *subject,
+		Name:          *name,
+		StartSequence: *fromSeq,
+		Bucket:        namePrefix.Name(*bucket),
+	}
+	if cfg.Name == "" {
+		cfg.Name = checkpointName(streamName, *subject)
+	}
+	if *since > 0 {
+		cfg.StartTime = time.Now().Add(-*since)
+	}
+
+	nc, err := nats.Connect(natsURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	defer nc.Close()
+
+	// Interrupting the replay keeps its checkpoint for the next run
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	stats, err := event.Replay(ctx, nc, cfg)
+	if stats.Resumed {
+		fmt.Printf("Resumed replay %s\n", cfg.Name)
+	}
+	if stats.Expired > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %d events expired from the stream before they were replayed\n", stats.Expired)
+	}
+	if stats.Replayed > 0 || stats.Skipped > 0 {
+		fmt.Printf("Replayed %d events (sequences %d-%d, %d skipped)\n", stats.Replayed, stats.FirstSequence, stats.LastSequence, stats.Skipped)
+	} else if err == nil {
+		fmt.Println("No events to replay")
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("interrupted, run again with --name %s to resume", cfg.Name)
+	}
+	return err
+}
+
+// redriveEvents publishes the poison messages kept in a stream back to the subjects
+// they failed on
+func redriveEvents(natsURL string, args []string) error {
+	fs := flag.NewFlagSet("redrive", flag.ContinueOnError)
+	stream := fs.String("stream", "", "Stream keeping the poison messages, e.g. triggerd's --poison-stream")
+	subject := fs.String("subject", "", "Subject of the poison messages to re-drive (default: all subjects of the stream)")
+	keep := fs.Bool("keep", false, "Keep re-driven messages in the stream instead of deleting them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *stream == "" {
+		return fmt.Errorf("usage: triggerctl redrive --stream <stream> [--subject <subject>] [--keep]")
+	}
+
+	nc, err := nats.Connect(natsURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	defer nc.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	stats, err := event.Redrive(ctx, nc, event.RedriveConfig{
+		Stream:  namePrefix.Name(*stream),
+		Subject: *subject,
+		Keep:    *keep,
+	})
+	if stats.Redriven > 0 || stats.Skipped > 0 {
+		fmt.Printf("Re-drove %d messages (sequences %d-%d, %d skipped)\n", stats.Redriven, stats.FirstSequence, stats.LastSequence, stats.Skipped)
+	} else if err == nil {
+		fmt.Println("No messages to re-drive")
+	}
+	return err
+}
+
+// checkpointName derives a KV key from a stream and subject, e.g. config-stream_config_all
+func checkpointName(stream, subject string) string {
+	subject = strings.NewReplacer("*", "any", ">", "all").Replace(subject)
+	return strings.NewReplacer(".", "_").Replace(stream + "_" + subject)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/julianshen/mycelium/internal/trigger"
+
+	"github.com/nats-io/nats.go"
+)
+
+// manageTemplates runs the template add/list/show/delete subcommands
+func manageTemplates(natsURL string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: triggerctl template <add|list|show|delete> [options]")
+	}
+
+	fs := flag.NewFlagSet("template "+args[0], flag.ContinueOnError)
+	bucket := fs.String("bucket", trigger.DefaultTemplateBucket, "KV bucket holding trigger templates")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	nc, err := nats.Connect(natsURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	defer nc.Close()
+
+	templates, err := trigger.NewTemplateStore(nc, namePrefix.Name(*bucket))
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "add":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: triggerctl template add [options] <yaml-file>")
+		}
+		data, err := os.ReadFile(fs.Arg(0))
+		if err != nil {
+			return fmt.Errorf("failed to read YAML file: %w", err)
+		}
+		tmpl, err := trigger.ParseTemplateYAML(data)
+		if err != nil {
+			return fmt.Errorf("invalid template in %s: %w", fs.Arg(0), err)
+		}
+		if err := templates.SaveTemplate(ctx, tmpl); err != nil {
+			return err
+		}
+		fmt.Printf("Template %s saved\n", tmpl.ID)
+
+	case "list":
+		list, err := templates.ListTemplates(ctx)
+		if err != nil {
+			return err
+		}
+		if len(list) == 0 {
+			fmt.Println("No templates found")
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tPARAMS")
+		for _, tmpl := range list {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", tmpl.ID, tmpl.Name, formatParams(tmpl.Params))
+		}
+		return w.Flush()
+
+	case "show":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: triggerctl template show [options] <id>")
+		}
+		tmpl, err := templates.GetTemplate(ctx, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		printTemplate(tmpl)
+
+	case "delete":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: triggerctl template delete [options] <id>")
+		}
+		if err := templates.DeleteTemplate(ctx, fs.Arg(0)); err != nil {
+			return err
+		}
+		fmt.Printf("Template %s deleted\n", fs.Arg(0))
+
+	default:
+		return fmt.Errorf("unknown template command %q", args[0])
+	}
+	return nil
+}
+
+// formatParams lists parameters as name or name=default
+func formatParams(params []trigger.TemplateParam) string {
+	formatted := make([]string, len(params))
+	for i, param := range params {
+		formatted[i] = param.Name
+		if param.Default != nil {
+			formatted[i] += "=" + *param.Default
+		}
+	}
+	return strings.Join(formatted, ", ")
+}
+
+func printTemplate(tmpl *trigger.TriggerTemplate) {
+	fmt.Printf("\nTemplate: %s\n", tmpl.Name)
+	fmt.Printf("  ID: %s\n", tmpl.ID)
+	if tmpl.Description != "" {
+		fmt.Printf("  Description: %s\n", tmpl.Description)
+	}
+	for _, param := range tmpl.Params {
+		required := "required"
+		if param.Default != nil {
+			required = "default " + *param.Default
+		}
+		fmt.Printf("  Param %s (%s)", param.Name, required)
+		if param.Description != "" {
+			fmt.Printf(": %s", param.Description)
+		}
+		fmt.Println()
+	}
+	data, err := tmpl.Trigger.ToYAML()
+	if err == nil {
+		fmt.Printf("  Trigger:\n    %s\n", strings.ReplaceAll(strings.TrimSpace(string(data)), "\n", "\n    "))
+	}
+}
+
+// paramFlags collects repeated --param name=value flags
+type paramFlags map[string]string
+
+func (p paramFlags) String() string {
+	return fmt.Sprint(map[string]string(p))
+}
+
+func (p paramFlags) Set(value string) error {
+	name, val, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected name=value, got %q", value)
+	}
+	p[name] = val
+	return nil
+}
+
+// instantiateTemplate stamps out a trigger from a stored template and saves it
+func instantiateTemplate(ctx context.Context, nc *nats.Conn, store *trigger.NATSStore, storeNamespace string, args []string) error {
+	fs := flag.NewFlagSet("instantiate", flag.ContinueOnError)
+	bucket := fs.String("bucket", trigger.DefaultTemplateBucket, "KV bucket holding trigger templates")
+	namespace := fs.String("namespace", "", "Namespace the trigger applies to, replacing the template's namespaces")
+	id := fs.String("id", "", "ID of the trigger (default: <template>-<namespace>, or the template's trigger ID)")
+	dryRun := fs.Bool("dry-run", false, "Print the trigger without saving it")
+	params := paramFlags{}
+	fs.Var(params, "param", "Template parameter as name=value (repeatable)")
+
+	// Accept the template name before or after the flags
+	var name string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if name == "" && fs.NArg() == 1 {
+		name = fs.Arg(0)
+	} else if name == "" || fs.NArg() != 0 {
+		return fmt.Errorf("usage: triggerctl instantiate <template> [--param name=value]... [--namespace ns]")
+	}
+
+	templates, err := trigger.NewTemplateStore(nc, namePrefix.Name(*bucket))
+	if err != nil {
+		return err
+	}
+	tmpl, err := templates.GetTemplate(ctx, name)
+	if err != nil {
+		return err
+	}
+	if *id != "" {
+		tmpl.Trigger.ID = *id
+	}
+	t, err := tmpl.Instantiate(params, *namespace)
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		data, err := t.ToYAML()
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(data))
+		return nil
+	}
+	if err := store.SaveTrigger(ctx, storeNamespace, t.ID, t); err != nil {
+		return err
+	}
+	fmt.Printf("Trigger %s created from template %s\n", t.ID, tmpl.ID)
+	return nil
+}
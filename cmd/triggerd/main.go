@@ -2,15 +2,27 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
+	"runtime/pprof"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
-	"mycelium/internal/event"
-	"mycelium/internal/trigger"
+	"github.com/julianshen/mycelium/internal/action"
+	"github.com/julianshen/mycelium/internal/audit"
+	"github.com/julianshen/mycelium/internal/event"
+	"github.com/julianshen/mycelium/internal/function"
+	"github.com/julianshen/mycelium/internal/heartbeat"
+	"github.com/julianshen/mycelium/internal/naming"
+	"github.com/julianshen/mycelium/internal/profiling"
+	"github.com/julianshen/mycelium/internal/trigger"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"github.com/nats-io/nats.go"
@@ -23,8 +35,65 @@ func main() {
 	subject := flag.String("subject", "config.>", "NATS subject to subscribe to")
 	queueGroup := flag.String("queue-group", "trigger-processors", "NATS queue group name")
 	durableName := flag.String("durable", "trigger-consumer", "NATS durable consumer name")
+	readOnly := flag.Bool("read-only", false, "Follow the trigger bucket without write access")
+	envSubject := flag.String("env-subject", trigger.DefaultEnvironmentSubject, "NATS subject answering with the criteria expression environment (empty disables)")
+	functionConcurrency := flag.Int("function-concurrency", action.DefaultBindingConcurrency, "Maximum concurrent invocations per function binding")
+	functionGroup := flag.String("function-group", function.DefaultRuntimeGroup, "Runtime group function actions are invoked on")
+	gossipRouting := flag.Bool("function-gossip-routing", false, "Invoke functions on runtime instances that announced having them loaded")
+	functionTimeout := flag.Duration("function-timeout", action.DefaultFunctionTimeout, "Timeout of function binding invocations")
+	localFunctions := flag.String("local-functions", "", "Directory function actions are executed from in-process instead of on the runtime (see function.LocalRuntime)")
+	resultsSubject := flag.String("results-subject", action.DefaultResultSubject, "NATS subject action results are published to (empty disables)")
+	healthSubject := flag.String("health-subject", event.DefaultHealthSubject, "NATS subject health events are published to")
+	slaPolicyFile := flag.String("sla-policy", "", "YAML file with per-event-type latency targets actions are tracked against (empty disables SLA tracking)")
+	slaSubject := flag.String("sla-subject", action.DefaultSLASubject, "NATS subject sla.breach events are published to")
+	healthInterval := flag.Duration("health-interval", event.DefaultHealthInterval, "Interval of health events (0 disables)")
+	redactionFile := flag.String("redaction-policy", "", "YAML file with field redaction rules applied before events are logged")
+	logEvents := flag.Bool("log-events", false, "Log every received event after redaction")
+	maxActions := flag.Int("max-actions-per-minute", 0, "Global budget of actions per minute (0 is unlimited)")
+	maxNamespaceActions := flag.Int("namespace-max-actions-per-minute", 0, "Budget of actions per minute of each event namespace (0 is unlimited)")
+	controlBucket := flag.String("control-bucket", action.DefaultControlBucket, "KV bucket holding the kill switch and maintenance mode (empty disables)")
+	parkingStream := flag.String("parking-stream", action.DefaultParkingStream, "JetStream stream matches are parked in during maintenance mode (empty disables maintenance mode)")
+	mode := flag.String("mode", event.ModeAuto, "Transport mode: auto, jetstream or core (plain NATS without JetStream)")
+	triggerDir := flag.String("trigger-dir", "", "Directory of YAML trigger files, required in core mode")
+	secretsDir := flag.String("secrets-dir", "", "Directory with one file per action secret (default: "+action.DefaultSecretEnvPrefix+"<NAME> environment variables)")
+	webhookTimeout := flag.Duration("webhook-timeout", action.DefaultWebhookTimeout, "Timeout of webhook actions")
+	kafkaBrokers := flag.String("kafka-brokers", "", "Comma-separated Kafka bootstrap brokers kafka: actions publish to (empty disables them)")
+	amqpURL := flag.String("amqp-url", "", "AMQP broker URL amqp: actions publish to (default: the "+action.DefaultAMQPURLSecret+" secret)")
+	partitionBy := flag.String("partition-by", "", "Split trigger evaluation across instances by namespace or object_id (empty disables)")
+	partitions := flag.Int("partitions", trigger.DefaultPartitionCount, "Number of partitions, the same on every instance of the group")
+	partitionBucket := flag.String("partition-bucket", trigger.DefaultPartitionBucket, "KV bucket instances of a partitioned group register in")
+	hostname, _ := os.Hostname()
+	instanceID := flag.String("instance-id", hostname, "Unique ID of the instance in a partitioned group and for profiling")
+	poisonSubject := flag.String("poison-subject", event.DefaultPoisonSubject, "NATS subject events failing every delivery attempt are routed to (empty disables)")
+	poisonStream := flag.String("poison-stream", "", "JetStream stream created to keep poison messages when no stream captures the poison subject (empty disables)")
+	windowBucket := flag.String("window-bucket", trigger.DefaultWindowBucket, "KV bucket the sliding windows of aggregation triggers are kept in")
+	claimCheckBucket := flag.String("claim-check-bucket", event.DefaultClaimCheckBucket, "Object store claim-checked event payloads are resolved from (empty disables)")
+	profileTokenSHA256 := flag.String("profile-token-sha256", "", "Hex SHA-256 of the admin token profiling requests must carry (empty disables profiling)")
+	auditTrailBucket := flag.String("audit-trail", "", "KV bucket of the tamper-evident audit trail trigger matches are chained into (empty disables it)")
+	auditSigningKey := flag.String("audit-signing-key", "", "PEM file with the Ed25519 key audit trail checkpoints are signed with (empty disables checkpoints)")
+	checkpointInterval := flag.Duration("audit-checkpoint-interval", audit.DefaultCheckpointInterval, "Interval of signed audit trail checkpoints")
+	snapshotBucket := flag.String("index-snapshot-bucket", trigger.DefaultSnapshotBucket, "KV bucket trigger index snapshots are kept in for warm starts (empty disables them)")
+	snapshotInterval := flag.Duration("index-snapshot-interval", trigger.DefaultSnapshotInterval, "Interval of trigger index snapshots")
+	heartbeatBucket := flag.String("heartbeat-bucket", heartbeat.DefaultBucket, "KV bucket heartbeats of external systems are registered in (empty disables heartbeat monitoring)")
+	heartbeatSubject := flag.String("heartbeat-subject", heartbeat.DefaultBeatSubject, "NATS subject prefix beats are received under, as <prefix>.<name>")
+	heartbeatEventSubject := flag.String("heartbeat-event-subject", heartbeat.DefaultEventSubject, "NATS subject heartbeat.missed and heartbeat.recovered events are published to")
+	heartbeatCheckInterval := flag.Duration("heartbeat-check-interval", heartbeat.DefaultCheckInterval, "Interval heartbeat deadlines are checked at")
+	profileSubject := flag.String("profile-subject", "", "NATS subject answering profiling requests (default: "+profiling.DefaultSubjectPrefix+".<instance-id>)")
+	var names naming.Prefix
+	names.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
+	// Environments sharing a NATS cluster keep their streams, consumers, buckets and
+	// runtime groups apart by prefixing them
+	if err := names.Validate(); err != nil {
+		log.Fatalf("Invalid name prefix: %v", err)
+	}
+	names.Apply(streamName, queueGroup, durableName, functionGroup, controlBucket, parkingStream,
+		partitionBucket, windowBucket, claimCheckBucket, auditTrailBucket, snapshotBucket, heartbeatBucket, poisonStream)
+	if prefix := names.String(); prefix != "" {
+		log.Printf("Prefixing resource names with %s", prefix)
+	}
+
 	// Connect to NATS
 	nc, err := nats.Connect(*natsURL)
 	if err != nil {
@@ -32,39 +101,439 @@ func main() {
 	}
 	defer nc.Close()
 
-	// Create NATS store for triggers
-	store, err := trigger.NewNATSStore(nc, *streamName)
+	// Without JetStream there are no streams or KV buckets: events arrive over plain
+	// subscriptions and triggers are read from files
+	resolvedMode, err := event.ResolveMode(nc, *mode)
+	if err != nil {
+		log.Fatalf("Failed to resolve mode: %v", err)
+	}
+	core := resolvedMode == event.ModeCore
+	log.Printf("Running in %s mode", resolvedMode)
+
+	var store trigger.TriggerStore
+	if core {
+		if *triggerDir == "" {
+			log.Fatalf("--trigger-dir is required in core mode")
+		}
+		store, err = trigger.NewFileStore(*triggerDir)
+	} else if *readOnly {
+		// Followers only need read access to the bucket
+		store, err = trigger.NewReadOnlyNATSStore(nc, *streamName)
+	} else {
+		store, err = trigger.NewNATSStore(nc, *streamName)
+	}
 	if err != nil {
 		log.Fatalf("Failed to create trigger store: %v", err)
 	}
 	defer store.Close()
 
-	// Load triggers
-	ctx := context.Background()
-	if err := store.LoadAll(ctx); err != nil {
-		log.Fatalf("Failed to load triggers: %v", err)
+	// Watches, watchers and background loops started with ctx stop on shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Warm start: match events from the last index snapshot right away and reconcile
+	// with the bucket in the background. Partitioned instances rebuild their index
+	// whenever partitions change, so they always load the bucket.
+	restored := false
+	if natsStore, ok := store.(*trigger.NATSStore); ok && *snapshotBucket != "" && *partitionBy == "" {
+		snapshots, err := trigger.NewIndexSnapshots(nc, trigger.SnapshotConfig{Bucket: *snapshotBucket, Key: *streamName})
+		if err != nil {
+			log.Fatalf("Failed to open index snapshots: %v", err)
+		}
+		restored, err = natsStore.WarmStart(ctx, snapshots)
+		if err != nil {
+			log.Printf("Error restoring trigger index snapshot, loading triggers: %v", err)
+		}
+		go natsStore.RunSnapshots(ctx, snapshots, *snapshotInterval)
+		defer func() {
+			if err := natsStore.SaveSnapshot(snapshots); err != nil {
+				log.Printf("Error saving trigger index snapshot: %v", err)
+			}
+		}()
+	}
+
+	if restored {
+		go func() {
+			if err := store.Watch(ctx); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				log.Fatalf("Failed to watch triggers: %v", err)
+			}
+			log.Printf("Trigger index reconciled with %s", *streamName)
+		}()
+	} else {
+		// Load triggers
+		if err := store.LoadAll(ctx); err != nil {
+			log.Fatalf("Failed to load triggers: %v", err)
+		}
+
+		// Start watching for trigger changes
+		if err := store.Watch(ctx); err != nil {
+			log.Fatalf("Failed to watch triggers: %v", err)
+		}
+	}
+
+	// Partitioned instances only index the triggers of their partitions and only
+	// evaluate the events of their partitions
+	var partitioner *trigger.Partitioner
+	if *partitionBy != "" {
+		natsStore, ok := store.(*trigger.NATSStore)
+		if !ok {
+			log.Fatalf("--partition-by is unavailable in core mode")
+		}
+		partitioner, err = trigger.NewPartitioner(nc, trigger.PartitionConfig{
+			Bucket:     *partitionBucket,
+			Key:        *partitionBy,
+			Partitions: *partitions,
+			InstanceID: *instanceID,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create partitioner: %v", err)
+		}
+		partitioner.OnChange(func() {
+			if err := natsStore.SetFilter(ctx, partitioner.Relevant); err != nil {
+				log.Printf("Error reindexing triggers for new partitions: %v", err)
+			}
+		})
+		if err := partitioner.Start(ctx); err != nil {
+			log.Fatalf("Failed to join partition group: %v", err)
+		}
+		defer partitioner.Close()
+		log.Printf("Partitioned by %s as %s, owning %d of %d partitions", *partitionBy, *instanceID, len(partitioner.Partitions()), *partitions)
+	}
+
+	// Serve Go profiles and runtime metrics to admins
+	if *profileTokenSHA256 != "" {
+		profiler, err := profiling.NewHandler(*profileTokenSHA256)
+		if err != nil {
+			log.Fatalf("Invalid --profile-token-sha256: %v", err)
+		}
+		subject := *profileSubject
+		if subject == "" {
+			subject = profiling.DefaultSubjectPrefix + "." + *instanceID
+		}
+		if _, err := profiler.Serve(nc, subject); err != nil {
+			log.Fatalf("Failed to serve profiles: %v", err)
+		}
+		log.Printf("Serving profiles on %s", subject)
+	}
+
+	// Describe the criteria environment to editors and UIs
+	if *envSubject != "" {
+		if _, err := trigger.ServeEnvironment(nc, *envSubject); err != nil {
+			log.Fatalf("Failed to serve expression environment: %v", err)
+		}
+	}
+
+	// Invoke "function:<name>" actions on the runtime over the shared connection,
+	// or from a local directory while developing functions
+	var functionClient action.Invoker
+	if *localFunctions != "" {
+		localRuntime, err := function.NewLocalRuntime(*localFunctions)
+		if err != nil {
+			log.Fatalf("Failed to open local functions: %v", err)
+		}
+		localRuntime.SetOutput(func(functionName, stream string) io.Writer { return log.Writer() })
+		defer localRuntime.Close()
+		functionClient = localRuntime
+		log.Printf("Executing function actions from %s", *localFunctions)
+	} else {
+		clientConfig := function.ClientConfig{Conn: nc, Group: *functionGroup, GossipRouting: *gossipRouting}
+		if *claimCheckBucket != "" && !core {
+			clientConfig.ClaimCheck = &event.ClaimCheckConfig{Bucket: *claimCheckBucket}
+		}
+		natsClient, err := function.NewClient(clientConfig)
+		if err != nil {
+			log.Fatalf("Failed to create function client: %v", err)
+		}
+		defer natsClient.Close()
+		functionClient = natsClient
+	}
+
+	// Create action executor and result publisher
+	executor := action.Router{
+		Function: action.NewFunctionExecutor(functionClient, action.FunctionExecutorConfig{
+			MaxConcurrent: *functionConcurrency,
+			Timeout:       *functionTimeout,
+		}),
+		Webhook: action.NewWebhookExecutor(action.WebhookExecutorConfig{Timeout: *webhookTimeout}),
+		Default: action.LogExecutor{},
+	}
+	var brokers []string
+	if *kafkaBrokers != "" {
+		brokers = strings.Split(*kafkaBrokers, ",")
+	}
+	kafkaExecutor := action.NewKafkaExecutor(action.KafkaExecutorConfig{Brokers: brokers})
+	defer kafkaExecutor.Close()
+	amqpExecutor := action.NewAMQPExecutor(action.AMQPExecutorConfig{URL: *amqpURL})
+	defer amqpExecutor.Close()
+	executor.Kafka, executor.AMQP = kafkaExecutor, amqpExecutor
+
+	// Executors resolve their secrets and share long-lived clients once, not per event
+	var secrets action.SecretProvider = action.EnvSecrets{Prefix: action.DefaultSecretEnvPrefix}
+	if *secretsDir != "" {
+		secrets = action.DirSecrets{Dir: *secretsDir}
+	}
+	resources := action.NewResources(nc)
+	defer resources.Close()
+	if err := action.Initialize(ctx, executor, &action.ExecutionContext{Secrets: secrets, Resources: resources}); err != nil {
+		log.Fatalf("Failed to initialize action executors: %v", err)
+	}
+	var results *action.ResultPublisher
+	if *resultsSubject != "" {
+		results = action.NewResultPublisher(nc, *resultsSubject)
+	}
+
+	// Track the latency from events to the completion of their actions against the SLAs
+	var sla *action.SLATracker
+	if *slaPolicyFile != "" {
+		policy, err := action.LoadSLAPolicy(*slaPolicyFile)
+		if err != nil {
+			log.Fatalf("Failed to load SLA policy: %v", err)
+		}
+		sla = action.NewSLATracker(nc, policy, action.SLATrackerConfig{Subject: *slaSubject})
 	}
 
-	// Start watching for trigger changes
-	go store.Watch(ctx)
+	// Contain runaway triggers: budgets cap action rates, the kill switch pauses all
+	// actions and triggers' concurrency limits queue excess executions, while events
+	// keep being consumed and skipped actions are still reported
+	guard := &action.Guard{
+		Budget: action.NewBudget(action.BudgetConfig{
+			Global:       *maxActions,
+			PerNamespace: *maxNamespaceActions,
+		}),
+		Concurrency: action.NewConcurrencyLimiter(),
+	}
+	if *controlBucket != "" && core {
+		log.Printf("Kill switch and maintenance mode are unavailable in core mode")
+	} else if *controlBucket != "" {
+		guard.KillSwitch, err = action.NewKillSwitch(nc, *controlBucket)
+		if err != nil {
+			log.Fatalf("Failed to create kill switch: %v", err)
+		}
+		if err := guard.KillSwitch.Watch(ctx); err != nil {
+			log.Fatalf("Failed to watch kill switch: %v", err)
+		}
+		if guard.KillSwitch.Engaged() {
+			log.Printf("Kill switch is engaged, actions are paused")
+		}
 
-	// Create event handler
+		// Maintenance mode parks matches instead of running their actions and replays
+		// them in order once it ends
+		if *parkingStream != "" {
+			guard.Maintenance, err = action.NewMaintenance(nc, *controlBucket, *parkingStream)
+			if err != nil {
+				log.Fatalf("Failed to create maintenance mode: %v", err)
+			}
+			if err := guard.Maintenance.Watch(ctx); err != nil {
+				log.Fatalf("Failed to watch maintenance mode: %v", err)
+			}
+			if guard.Maintenance.Enabled() {
+				log.Printf("Maintenance mode is on, matches are parked")
+			} else if guard.Maintenance.Parking() {
+				log.Printf("Replaying matches parked in maintenance mode")
+			}
+		}
+	}
+
+	// Chain trigger matches into the audit trail, sealed by checkpoints
+	var trail *audit.Trail
+	if *auditTrailBucket != "" && core {
+		log.Printf("Audit trail is unavailable in core mode")
+	} else if *auditTrailBucket != "" {
+		trail, err = audit.Open(nc, *auditTrailBucket)
+		if err != nil {
+			log.Fatalf("Failed to open audit trail: %v", err)
+		}
+		if *auditSigningKey != "" {
+			key, err := audit.LoadSigningKey(*auditSigningKey)
+			if err != nil {
+				log.Fatalf("Failed to load audit signing key: %v", err)
+			}
+			trail.SetSigningKey(key)
+			stop := make(chan struct{})
+			defer close(stop)
+			go trail.RunCheckpoints(*checkpointInterval, stop)
+		}
+	}
+
+	// Aggregation triggers count matching events in windows shared by every daemon,
+	// or kept in memory without JetStream
+	var aggregator *trigger.Aggregator
+	if core {
+		aggregator = trigger.NewLocalAggregator()
+	} else {
+		aggregator, err = trigger.NewAggregator(nc, *windowBucket)
+		if err != nil {
+			log.Fatalf("Failed to create aggregator: %v", err)
+		}
+	}
+
+	// Load the redaction policy; events are only redacted for logging, matching sees full data
+	var redaction *event.RedactionPolicy
+	if *redactionFile != "" {
+		redaction, err = event.LoadRedactionPolicy(*redactionFile)
+		if err != nil {
+			log.Fatalf("Failed to load redaction policy: %v", err)
+		}
+	}
+
+	// runAction runs a matched trigger's action, or one replayed after maintenance, and
+	// reports its outcome
+	runAction := func(t *trigger.Trigger, e *cloudevents.Event, replayed bool) error {
+		// Label the action so CPU profiles attribute its time to the trigger
+		var result action.Result
+		var stopped error
+		pprof.Do(ctx, pprof.Labels(profiling.LabelTrigger, t.ID), func(ctx context.Context) {
+			if replayed {
+				result = guard.RunParked(ctx, executor, t, e)
+			} else {
+				result, stopped = guard.RunRetrying(ctx, executor, t, e, action.ConcurrencyRetryDelay)
+			}
+		})
+		// Matches still waiting for room in the concurrency queue at shutdown are
+		// redelivered with their event
+		if stopped != nil {
+			return fmt.Errorf("action %s of trigger %s: %w", t.Action, t.Name, stopped)
+		}
+		// Name the JetStream message, so failures can be traced to it
+		ref, stored := event.MessageRefOf(e)
+		var message string
+		if stored {
+			message = fmt.Sprintf(" (message %s)", ref)
+		}
+		switch result.Status {
+		case action.StatusFailed:
+			log.Printf("Action %s of trigger %s failed%s: %s", result.Action, t.Name, message, result.Error)
+		case action.StatusSkipped:
+			log.Printf("Action %s of trigger %s skipped%s: %s", result.Action, t.Name, message, result.Error)
+		case action.StatusParked:
+			log.Printf("Action %s of trigger %s parked for event %s", result.Action, t.Name, e.ID())
+		}
+
+		// Record the match and its outcome for compliance reviews, unless no branch of
+		// the trigger selected an action
+		if trail != nil && result.Action != "" {
+			details := map[string]string{"event_id": e.ID(), "event_type": e.Type(), "status": result.Status}
+			if result.Error != "" {
+				details["error"] = result.Error
+			}
+			if stored {
+				for k, v := range ref.Details() {
+					details[k] = v
+				}
+			}
+			_, err := trail.Append(ctx, audit.Record{
+				Kind:     audit.KindTriggerMatch,
+				Actor:    *instanceID,
+				Action:   result.Action,
+				Resource: t.ID,
+				Details:  details,
+			})
+			if err != nil {
+				log.Printf("Error auditing match of trigger %s: %v", t.Name, err)
+			}
+		}
+
+		// Feed the outcome back into the event stream for dashboards and follow-up triggers
+		if results != nil {
+			if err := results.Publish(result, e); err != nil {
+				log.Printf("Error publishing action result: %v", err)
+			}
+		}
+		if sla != nil {
+			if err := sla.Observe(result, e); err != nil {
+				log.Printf("Error reporting SLA breach: %v", err)
+			}
+		}
+		return nil
+	}
+
+	// Replay parked matches against the triggers as they are now: matches of triggers
+	// deleted or disabled during maintenance are dropped
+	if guard.Maintenance != nil {
+		err := guard.Maintenance.Replay(ctx, func(triggerID string, e *cloudevents.Event) error {
+			triggers, err := store.GetTriggersForEvent(ctx, trigger.EventNamespace(e.Type()), e.Type())
+			if err != nil {
+				return fmt.Errorf("failed to find trigger: %w", err)
+			}
+			for _, t := range triggers {
+				if t.ID == triggerID && t.Enabled {
+					runAction(t, e, true)
+					return nil
+				}
+			}
+			log.Printf("Dropping parked event %s: trigger %s was deleted or disabled", e.ID(), triggerID)
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("Failed to replay parked matches: %v", err)
+		}
+	}
+
+	// Create event handler; it defers finishing events through the watcher created below
+	var watcher *event.Watcher
 	handler := func(e *cloudevents.Event) error {
-		matchedTriggers, err := trigger.FindMatchingTriggers(store, e)
+		if partitioner != nil && !partitioner.Owns(e) {
+			return nil
+		}
+
+		if *logEvents {
+			if data, err := redaction.Redact(e).MarshalJSON(); err == nil {
+				log.Printf("Received event: %s", data)
+			}
+		}
+
+		matchedTriggers, err := trigger.FindMatchingTriggers(ctx, store, e)
 		if err != nil {
 			log.Printf("Error finding matching triggers: %v", err)
 			return err
 		}
 
+		var limited []*trigger.Trigger
 		if len(matchedTriggers) > 0 {
 			log.Printf("Event %s matched %d triggers:", e.ID(), len(matchedTriggers))
 			for _, t := range matchedTriggers {
-				log.Printf("  - Trigger: %s", t.Name)
-				log.Printf("    Action: %s", t.Action)
-				// Here you would execute the actual action
-				// For now, we just print the action
+				fire, err := aggregator.Observe(ctx, t, e)
+				if err != nil {
+					log.Printf("Error counting event %s in the window of trigger %s: %v", e.ID(), t.Name, err)
+					continue
+				}
+				if !fire {
+					continue
+				}
+
+				// Actions with a concurrency limit wait for a slot without holding up the
+				// event stream
+				if t.Concurrency != nil {
+					limited = append(limited, t)
+					continue
+				}
+				runAction(t, e, false)
 			}
 		}
+
+		// The event is acknowledged once its limited actions ran, so matches still
+		// waiting when triggerd stops are redelivered. Matches that find their trigger's
+		// concurrency queue full are retried on their own, so the other actions of the
+		// event run once.
+		if len(limited) > 0 {
+			finish := watcher.Defer(e)
+			go func() {
+				errs := make([]error, len(limited))
+				var wg sync.WaitGroup
+				for i, t := range limited {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						errs[i] = runAction(t, e, false)
+					}()
+				}
+				wg.Wait()
+				finish(errors.Join(errs...))
+			}()
+		}
 		return nil
 	}
 
@@ -77,18 +546,28 @@ func main() {
 		DurableName:   *durableName,
 		AckWait:       30 * time.Second,
 		MaxDeliveries: 5,
+		Core:          core,
+		PoisonSubject: *poisonSubject,
+		PoisonStream:  *poisonStream,
+	}
+
+	// Resolve offloaded payloads before matching, so criteria see the full event data
+	if *claimCheckBucket != "" && !core {
+		config.ClaimCheck = &event.ClaimCheckConfig{Bucket: *claimCheckBucket}
+	}
+
+	// Every member of a partitioned group receives every event on its own consumer
+	if partitioner != nil {
+		config.QueueGroup = ""
+		config.DurableName = *durableName + "-" + *instanceID
 	}
 
 	// Create the watcher
-	watcher, err := event.NewWatcher(config, handler)
+	watcher, err = event.NewWatcher(config, handler)
 	if err != nil {
 		log.Fatalf("Failed to create watcher: %v", err)
 	}
 
-	// Create context with cancellation
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
 	// Handle OS signals
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -98,6 +577,35 @@ func main() {
 		log.Fatalf("Failed to start watcher: %v", err)
 	}
 
+	// Publish health events so triggers can alert on consumer lag and errors
+	if *healthInterval > 0 {
+		reporter := event.NewHealthReporter(nc, watcher, *healthSubject, *healthInterval)
+		reporter.SetConnectionStats(func() interface{} { return resources.Stats() })
+		if sla != nil {
+			reporter.SetSLAStats(func() interface{} { return sla.Stats() })
+		}
+		go reporter.Run(ctx)
+	}
+
+	// Emit heartbeat.missed events when registered external systems stop beating
+	if *heartbeatBucket != "" && core {
+		log.Printf("Heartbeat monitoring is unavailable in core mode")
+	} else if *heartbeatBucket != "" {
+		registry, err := heartbeat.NewRegistry(nc, *heartbeatBucket)
+		if err != nil {
+			log.Fatalf("Failed to open heartbeat registry: %v", err)
+		}
+		monitor := heartbeat.NewMonitor(nc, registry, heartbeat.MonitorConfig{
+			BeatSubject:   *heartbeatSubject,
+			EventSubject:  *heartbeatEventSubject,
+			CheckInterval: *heartbeatCheckInterval,
+		})
+		if err := monitor.Start(ctx); err != nil {
+			log.Fatalf("Failed to start heartbeat monitor: %v", err)
+		}
+		defer monitor.Stop()
+	}
+
 	log.Printf("Trigger daemon started. Watching for events...")
 	log.Printf("Press Ctrl+C to stop")
 
@@ -2,7 +2,7 @@ package main
 
 import (
 	"fmt"
-	"mycelium/internal/function"
+	"github.com/julianshen/mycelium/internal/function"
 )
 
 func main() {
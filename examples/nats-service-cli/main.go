@@ -8,6 +8,8 @@ import (
 	"os"
 	"time"
 
+	"github.com/julianshen/mycelium/internal/function"
+
 	"github.com/nats-io/nats.go"
 )
 
@@ -18,6 +20,11 @@ func main() {
 		fmt.Println("  discover    - Discover all available services")
 		fmt.Println("  info <name> - Get detailed information about a service")
 		fmt.Println("  stats <name>- Get statistics for a service")
+		fmt.Println("  functions <name> - List the functions loaded on every instance of a service")
+		fmt.Println("  mirror <function> - Tail mirrored invocations of a function (\"*\" for all)")
+		fmt.Println("  cordon <name> <instance> - Stop an instance taking new invocations")
+		fmt.Println("  drain <name> <instance> - Cordon an instance and wait for its invocations to finish")
+		fmt.Println("  uncordon <name> <instance> - Put a cordoned instance back into rotation")
 		fmt.Println("  ping        - Ping all services")
 		os.Exit(1)
 	}
@@ -49,6 +56,24 @@ func main() {
 			os.Exit(1)
 		}
 		getServiceStats(nc, ctx, os.Args[2])
+	case "functions":
+		if len(os.Args) < 3 {
+			fmt.Println("Usage: go run main.go functions <service-name>")
+			os.Exit(1)
+		}
+		getLoadedFunctions(nc, os.Args[2])
+	case "mirror":
+		if len(os.Args) < 3 {
+			fmt.Println("Usage: go run main.go mirror <function-name>")
+			os.Exit(1)
+		}
+		tailMirror(nc, os.Args[2])
+	case function.CordonActionCordon, function.CordonActionDrain, function.CordonActionUncordon:
+		if len(os.Args) < 4 {
+			fmt.Printf("Usage: go run main.go %s <service-name> <instance-id>\n", command)
+			os.Exit(1)
+		}
+		cordonInstance(nc, command, os.Args[2], os.Args[3])
 	default:
 		fmt.Printf("Unknown command: %s\n", command)
 		os.Exit(1)
@@ -212,3 +237,78 @@ func getServiceStats(nc *nats.Conn, ctx context.Context, serviceName string) {
 		}
 	}
 }
+
+func getLoadedFunctions(nc *nats.Conn, serviceName string) {
+	fmt.Printf("📦 Getting loaded functions for service: %s\n", serviceName)
+
+	instances, err := function.ListLoadedFunctions(nc, serviceName, 2*time.Second)
+	if err != nil {
+		log.Printf("Error getting loaded functions: %v", err)
+		return
+	}
+
+	if len(instances) == 0 {
+		fmt.Println("❌ No instances responded")
+		return
+	}
+
+	fmt.Printf("✅ %d instance(s) responded:\n", len(instances))
+	for _, instance := range instances {
+		fmt.Printf("   Instance %s (Version: %s):\n", instance.InstanceID, instance.Version)
+		if len(instance.Functions) == 0 {
+			fmt.Printf("     (no functions loaded)\n")
+		}
+		for _, fn := range instance.Functions {
+			fmt.Printf("     • %s %s (%s) loaded %s, digest %.12s\n",
+				fn.Name, fn.Version, fn.Type, fn.LoadedAt.Format(time.RFC3339), fn.Digest)
+		}
+	}
+}
+
+func cordonInstance(nc *nats.Conn, action, serviceName, instanceID string) {
+	fmt.Printf("🚧 Sending %s to instance %s of %s\n", action, instanceID, serviceName)
+
+	status, err := function.CordonInstance(nc, serviceName, instanceID, function.CordonRequest{Action: action}, 2*time.Second)
+	if err != nil {
+		log.Printf("Error sending %s: %v", action, err)
+		return
+	}
+
+	fmt.Printf("✅ Instance %s cordoned: %t, in flight: %d\n", status.InstanceID, status.Cordoned, status.InFlight)
+	if action == function.CordonActionDrain && !status.Drained {
+		fmt.Println("⚠️  Drain timed out with invocations still in flight")
+	}
+}
+
+func tailMirror(nc *nats.Conn, functionName string) {
+	subject := function.MirrorSubject(function.DefaultMirrorSubject, functionName)
+	fmt.Printf("🔍 Tailing mirrored invocations on %s (Ctrl+C to stop)\n", subject)
+
+	msgs := make(chan *nats.Msg, 64)
+	sub, err := nc.ChanSubscribe(subject, msgs)
+	if err != nil {
+		log.Printf("Error subscribing to mirror subject: %v", err)
+		return
+	}
+	defer sub.Unsubscribe()
+
+	for msg := range msgs {
+		var record function.MirroredInvocation
+		if err := json.Unmarshal(msg.Data, &record); err != nil {
+			log.Printf("Error decoding mirrored invocation: %v", err)
+			continue
+		}
+
+		status := fmt.Sprintf("✅ %d event(s)", len(record.Response))
+		if record.ErrorType != "" {
+			status = fmt.Sprintf("❌ %s: %s", record.ErrorType, record.Error)
+		}
+		request, _ := json.Marshal(record.Request)
+		fmt.Printf("%s %s %dms %s\n   request: %s\n",
+			record.Received.Format(time.RFC3339), record.FunctionName, record.DurationMs, status, request)
+		for _, e := range record.Response {
+			response, _ := json.Marshal(e)
+			fmt.Printf("   response: %s\n", response)
+		}
+	}
+}
@@ -9,7 +9,7 @@ import (
 	ce "github.com/cloudevents/sdk-go/v2"
 	"github.com/nats-io/nats.go"
 
-	"mycelium/internal/function"
+	"github.com/julianshen/mycelium/internal/function"
 )
 
 func main() {
@@ -2,7 +2,7 @@ package main
 
 import (
 	"fmt"
-	"mycelium/internal/function"
+	"github.com/julianshen/mycelium/internal/function"
 )
 
 // DemoLogger shows how to implement and use the Logger interface
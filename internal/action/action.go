@@ -0,0 +1,113 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/event"
+	"github.com/julianshen/mycelium/internal/trigger"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// Action result statuses
+const (
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusSkipped   = "skipped" // Not executed, e.g. while the kill switch is engaged
+	StatusParked    = "parked"  // Held back in maintenance mode and replayed afterwards
+)
+
+// maxOutputSummary is the maximum length of the output summary carried in a result
+const maxOutputSummary = 1024
+
+// Result describes the outcome of executing a trigger's action for an event
+type Result struct {
+	TriggerID  string `json:"trigger_id"`
+	EventID    string `json:"event_id"`
+	Action     string `json:"action"`
+	Status     string `json:"status"`
+	Output     string `json:"output,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+	// Message is the JetStream message the event was consumed from, set by
+	// NewResultEvent from the event's extensions
+	Message *event.MessageRef `json:"message,omitempty"`
+}
+
+// Executor executes the action of a matched trigger
+type Executor interface {
+	// Execute runs the trigger's action for the event and returns a short summary of its output
+	Execute(ctx context.Context, t *trigger.Trigger, event *cloudevents.Event) (string, error)
+}
+
+// ExecutorFunc adapts a function to the Executor interface
+type ExecutorFunc func(ctx context.Context, t *trigger.Trigger, event *cloudevents.Event) (string, error)
+
+// Execute calls f(ctx, t, event)
+func (f ExecutorFunc) Execute(ctx context.Context, t *trigger.Trigger, event *cloudevents.Event) (string, error) {
+	return f(ctx, t, event)
+}
+
+// LogExecutor is an executor that only logs the action it was asked to run
+type LogExecutor struct{}
+
+// Execute logs the trigger and action
+func (LogExecutor) Execute(ctx context.Context, t *trigger.Trigger, event *cloudevents.Event) (string, error) {
+	log.Printf("  - Trigger: %s", t.Name)
+	log.Printf("    Action: %s", t.Action)
+	return fmt.Sprintf("logged action %s", t.Action), nil
+}
+
+// Run executes the trigger's action with the executor and records the outcome.
+// The trigger's timeout, if any, bounds the execution. For triggers with branches, the
+// action of the branch selected for the event is executed.
+func Run(ctx context.Context, executor Executor, t *trigger.Trigger, event *cloudevents.Event) Result {
+	start := time.Now()
+	selected, err := t.SelectAction(event)
+	if errors.Is(err, trigger.ErrNoBranch) {
+		return Result{TriggerID: t.ID, EventID: event.ID(), Status: StatusSkipped, Error: err.Error()}
+	}
+	if err == nil {
+		t = selected
+	}
+
+	var output string
+	var timeout time.Duration
+	if err == nil {
+		timeout, err = t.ActionTimeout()
+	}
+	if err == nil {
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		output, err = executor.Execute(ctx, t, event)
+	}
+
+	result := Result{
+		TriggerID:  t.ID,
+		EventID:    event.ID(),
+		Action:     t.Action,
+		Status:     StatusSucceeded,
+		Output:     summarize(output),
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		result.Status = StatusFailed
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// summarize truncates action output to keep result events small
+func summarize(output string) string {
+	if len(output) <= maxOutputSummary {
+		return output
+	}
+	return output[:maxOutputSummary] + "...(truncated)"
+}
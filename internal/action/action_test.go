@@ -0,0 +1,755 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/event"
+	"github.com/julianshen/mycelium/internal/trigger"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/nats-io/nats.go"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestEvent() *cloudevents.Event {
+	ce := cloudevents.NewEvent()
+	ce.SetID("event-1")
+	ce.SetSource("test")
+	ce.SetType("config.updated")
+	return &ce
+}
+
+// TestRunRecordsOutcome tests that Run captures success, failure, and output summaries
+func TestRunRecordsOutcome(t *testing.T) {
+	trig := &trigger.Trigger{ID: "remediate", Action: "restart"}
+
+	result := Run(context.Background(), ExecutorFunc(func(ctx context.Context, t *trigger.Trigger, e *cloudevents.Event) (string, error) {
+		return strings.Repeat("x", maxOutputSummary+10), nil
+	}), trig, newTestEvent())
+	assert.Equal(t, StatusSucceeded, result.Status)
+	assert.Equal(t, "remediate", result.TriggerID)
+	assert.Equal(t, "event-1", result.EventID)
+	assert.True(t, strings.HasSuffix(result.Output, "...(truncated)"))
+
+	result = Run(context.Background(), ExecutorFunc(func(ctx context.Context, t *trigger.Trigger, e *cloudevents.Event) (string, error) {
+		return "", errors.New("restart failed")
+	}), trig, newTestEvent())
+	assert.Equal(t, StatusFailed, result.Status)
+	assert.Equal(t, "restart failed", result.Error)
+}
+
+// TestRunSelectsBranch tests that Run executes the action of the branch selected for the event
+func TestRunSelectsBranch(t *testing.T) {
+	var executed []string
+	executor := ExecutorFunc(func(ctx context.Context, t *trigger.Trigger, e *cloudevents.Event) (string, error) {
+		executed = append(executed, t.Action)
+		return "", nil
+	})
+	trig := &trigger.Trigger{ID: "config", Branches: []trigger.Branch{{When: `event.event_id == "event-1"`, Action: "restart"}}}
+
+	result := Run(context.Background(), executor, trig, newTestEvent())
+	assert.Equal(t, StatusSucceeded, result.Status)
+	assert.Equal(t, "restart", result.Action)
+
+	other := newTestEvent()
+	other.SetID("event-2")
+	result = Run(context.Background(), executor, trig, other)
+	assert.Equal(t, StatusSkipped, result.Status)
+	assert.Equal(t, trigger.ErrNoBranch.Error(), result.Error)
+	assert.Equal(t, []string{"restart"}, executed)
+}
+
+// TestRunAppliesTriggerTimeout tests that the trigger's timeout bounds its action
+func TestRunAppliesTriggerTimeout(t *testing.T) {
+	blocking := ExecutorFunc(func(ctx context.Context, t *trigger.Trigger, e *cloudevents.Event) (string, error) {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(5 * time.Second):
+			return "done", nil
+		}
+	})
+
+	start := time.Now()
+	result := Run(context.Background(), blocking, &trigger.Trigger{ID: "slow", Action: "restart", Timeout: "20ms"}, newTestEvent())
+	assert.Equal(t, StatusFailed, result.Status)
+	assert.Equal(t, context.DeadlineExceeded.Error(), result.Error)
+	assert.Less(t, time.Since(start), time.Second)
+
+	result = Run(context.Background(), blocking, &trigger.Trigger{ID: "broken", Action: "restart", Timeout: "soon"}, newTestEvent())
+	assert.Equal(t, StatusFailed, result.Status)
+	assert.Contains(t, result.Error, "invalid timeout")
+}
+
+// TestResultEventMatchesTriggers tests that result events can drive follow-up triggers
+func TestResultEventMatchesTriggers(t *testing.T) {
+	result := Result{TriggerID: "remediate", EventID: "event-1", Action: "restart", Status: StatusFailed}
+
+	cause := newTestEvent()
+	event.SetMessageRef(cause, event.MessageRef{Stream: "CONFIG", Sequence: 42, Consumer: "trigger-consumer", Delivery: 1})
+	ce, err := NewResultEvent(result, cause)
+	require.NoError(t, err)
+	assert.Equal(t, EventTypeActionFailed, ce.Type())
+
+	// Round trip through JSON as the event would travel over NATS
+	data, err := ce.MarshalJSON()
+	require.NoError(t, err)
+	received := cloudevents.NewEvent()
+	require.NoError(t, received.UnmarshalJSON(data))
+	assert.Equal(t, 1, eventDepth(&received))
+	assert.Equal(t, []string{"event-1"}, event.ParentIDs(&received))
+	assert.Equal(t, "trigger:remediate", event.ProducedBy(&received))
+
+	escalate := &trigger.Trigger{
+		ID:       "escalate",
+		Enabled:  true,
+		Criteria: `event.data.after.status == "failed" && event.data.after.trigger_id == "remediate" && event.data.after.message.sequence == 42`,
+	}
+	matched, err := trigger.MatchTrigger(escalate, &received)
+	require.NoError(t, err)
+	assert.True(t, matched)
+}
+
+// blockingInvoker records invocations and blocks until released
+type blockingInvoker struct {
+	calls   chan string
+	release chan struct{}
+}
+
+func (b *blockingInvoker) InvokeFunction(ctx context.Context, name string, event *cloudevents.Event) ([]*cloudevents.Event, error) {
+	b.calls <- name
+	select {
+	case <-b.release:
+		return []*cloudevents.Event{event}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// TestFunctionBinding tests routing and per-binding concurrency of function actions
+func TestFunctionBinding(t *testing.T) {
+	name, ok := FunctionName("function:resize-image")
+	assert.True(t, ok)
+	assert.Equal(t, "resize-image", name)
+	_, ok = FunctionName("function:")
+	assert.False(t, ok)
+	_, ok = FunctionName("notify")
+	assert.False(t, ok)
+
+	invoker := &blockingInvoker{calls: make(chan string, 2), release: make(chan struct{})}
+	router := Router{
+		Function: NewFunctionExecutor(invoker, FunctionExecutorConfig{MaxConcurrent: 1}),
+		Default:  LogExecutor{},
+	}
+	binding := &trigger.Trigger{ID: "resize", Action: "function:resize-image"}
+
+	// The first invocation holds the binding's only slot
+	done := make(chan Result)
+	go func() { done <- Run(context.Background(), router, binding, newTestEvent()) }()
+	assert.Equal(t, "resize-image", <-invoker.calls)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	result := Run(ctx, router, binding, newTestEvent())
+	assert.Equal(t, StatusFailed, result.Status)
+	assert.Contains(t, result.Error, "saturated")
+
+	close(invoker.release)
+	result = <-done
+	assert.Equal(t, StatusSucceeded, result.Status)
+	assert.Equal(t, "function resize-image returned 1 events", result.Output)
+
+	// Other actions go to the default executor
+	result = Run(context.Background(), router, &trigger.Trigger{ID: "log", Action: "notify"}, newTestEvent())
+	assert.Equal(t, StatusSucceeded, result.Status)
+	assert.Equal(t, "logged action notify", result.Output)
+}
+
+// TestBudget tests global and per-namespace action budgets
+func TestBudget(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	budget := NewBudget(BudgetConfig{Global: 3, PerNamespace: 2, Namespaces: map[string]int{"ops": 0}})
+	budget.now = func() time.Time { return now }
+
+	assert.NoError(t, budget.Allow("config"))
+	assert.NoError(t, budget.Allow("config"))
+	assert.ErrorIs(t, budget.Allow("config"), ErrBudgetExceeded)
+
+	// The namespace override lifts the namespace limit, the global one still applies
+	assert.NoError(t, budget.Allow("ops"))
+	err := budget.Allow("ops")
+	assert.ErrorIs(t, err, ErrBudgetExceeded)
+	assert.Contains(t, err.Error(), "global")
+
+	// Budgets refill continuously: half a minute restores half of each limit
+	now = now.Add(30 * time.Second)
+	assert.NoError(t, budget.Allow("config"))
+	assert.ErrorIs(t, budget.Allow("config"), ErrBudgetExceeded)
+
+	var unlimited *Budget
+	assert.NoError(t, unlimited.Allow("config"))
+}
+
+// TestGuardKillSwitch tests pausing actions with the kill switch
+func TestGuardKillSwitch(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	killSwitch, err := NewKillSwitch(nc, "killswitch-test")
+	require.NoError(t, err)
+	defer func() {
+		js, _ := nc.JetStream()
+		js.DeleteKeyValue("killswitch-test")
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, killSwitch.Watch(ctx))
+
+	executed := 0
+	executor := ExecutorFunc(func(ctx context.Context, t *trigger.Trigger, e *cloudevents.Event) (string, error) {
+		executed++
+		return "done", nil
+	})
+	guard := &Guard{KillSwitch: killSwitch}
+	trig := &trigger.Trigger{ID: "remediate", Action: "restart"}
+
+	require.NoError(t, killSwitch.Engage(ctx, "flooding downstream"))
+	require.Eventually(t, killSwitch.Engaged, time.Second, 10*time.Millisecond)
+
+	result := guard.Run(ctx, executor, trig, newTestEvent())
+	assert.Equal(t, StatusSkipped, result.Status)
+	assert.Equal(t, ErrKillSwitchEngaged.Error(), result.Error)
+	assert.Zero(t, executed)
+
+	state, err := killSwitch.State(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "flooding downstream", state.Reason)
+
+	event, err := NewResultEvent(result, newTestEvent())
+	require.NoError(t, err)
+	assert.Equal(t, EventTypeActionSkipped, event.Type())
+
+	require.NoError(t, killSwitch.Release(ctx))
+	require.Eventually(t, func() bool { return !killSwitch.Engaged() }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, StatusSucceeded, guard.Run(ctx, executor, trig, newTestEvent()).Status)
+	assert.Equal(t, 1, executed)
+}
+
+// TestGuardMaintenance tests parking matches in maintenance mode and replaying them
+// in order afterwards
+func TestGuardMaintenance(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	js.DeleteKeyValue("maintenance-test")
+	js.DeleteStream("MAINTENANCE_TEST")
+	defer func() {
+		js.DeleteKeyValue("maintenance-test")
+		js.DeleteStream("MAINTENANCE_TEST")
+	}()
+
+	maintenance, err := NewMaintenance(nc, "maintenance-test", "MAINTENANCE_TEST")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, maintenance.Watch(ctx))
+	assert.False(t, maintenance.Parking())
+
+	var mu sync.Mutex
+	var executed []string
+	executor := ExecutorFunc(func(ctx context.Context, t *trigger.Trigger, e *cloudevents.Event) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		executed = append(executed, e.ID())
+		return "done", nil
+	})
+	guard := &Guard{Maintenance: maintenance}
+	trig := &trigger.Trigger{ID: "remediate", Action: "restart"}
+	event := func(id string) *cloudevents.Event {
+		e := newTestEvent()
+		e.SetID(id)
+		return e
+	}
+
+	require.NoError(t, maintenance.Enable(ctx, "upgrading the database"))
+	require.Eventually(t, maintenance.Enabled, time.Second, 10*time.Millisecond)
+
+	for _, id := range []string{"1", "2", "3"} {
+		result := guard.Run(ctx, executor, trig, event(id))
+		assert.Equal(t, StatusParked, result.Status)
+		assert.Equal(t, ErrMaintenance.Error(), result.Error)
+	}
+	assert.Empty(t, executed)
+
+	parked, err := NewResultEvent(guard.Run(ctx, executor, trig, event("4")), newTestEvent())
+	require.NoError(t, err)
+	assert.Equal(t, EventTypeActionParked, parked.Type())
+
+	state, err := maintenance.State(ctx)
+	require.NoError(t, err)
+	assert.True(t, state.Enabled)
+	assert.Equal(t, "upgrading the database", state.Reason)
+	assert.Equal(t, uint64(4), state.Parked)
+
+	// Nothing is replayed before maintenance ends
+	require.NoError(t, maintenance.Replay(ctx, func(triggerID string, e *cloudevents.Event) error {
+		assert.Equal(t, trig.ID, triggerID)
+		assert.Equal(t, StatusSucceeded, guard.RunParked(ctx, executor, trig, e).Status)
+		return nil
+	}))
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	assert.Empty(t, executed)
+	mu.Unlock()
+
+	// Matches keep being parked until the backlog is drained, so they run in order
+	require.NoError(t, maintenance.Disable(ctx))
+	require.Eventually(t, func() bool { return !maintenance.Enabled() }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, StatusParked, guard.Run(ctx, executor, trig, event("5")).Status)
+
+	require.Eventually(t, func() bool { return !maintenance.Parking() }, 10*time.Second, 50*time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, []string{"1", "2", "3", "4", "5"}, executed)
+	mu.Unlock()
+	assert.Equal(t, StatusSucceeded, guard.Run(ctx, executor, trig, event("6")).Status)
+}
+
+// TestGuardConcurrency tests that the guard holds actions to their trigger's concurrency limit
+func TestGuardConcurrency(t *testing.T) {
+	started := make(chan string, 10)
+	unblock := make(chan struct{})
+	executor := ExecutorFunc(func(ctx context.Context, t *trigger.Trigger, e *cloudevents.Event) (string, error) {
+		started <- e.ID()
+		<-unblock
+		return "done", nil
+	})
+	event := func(id, user string) *cloudevents.Event {
+		e := newTestEvent()
+		e.SetID(id)
+		e.SetExtension("actorid", user)
+		return e
+	}
+	limiter := NewConcurrencyLimiter()
+	guard := &Guard{Concurrency: limiter}
+	trig := &trigger.Trigger{ID: "remediate", Action: "restart", Concurrency: &trigger.Concurrency{Max: 1, Scope: trigger.ConcurrencyScopeObject, Key: "event.actor.id"}}
+
+	results := make(chan Result, 10)
+	run := func(e *cloudevents.Event) {
+		go func() { results <- guard.Run(context.Background(), executor, trig, e) }()
+	}
+	run(event("1", "alice"))
+	assert.Equal(t, "1", <-started)
+	run(event("2", "alice"))
+	require.Eventually(t, func() bool { return limiter.Waiting() == 1 }, time.Second, 10*time.Millisecond)
+	run(event("3", "bob"))
+	assert.Equal(t, "3", <-started, "other objects have their own slots")
+
+	unblock <- struct{}{}
+	assert.Equal(t, "2", <-started, "waiting matches run once a slot is free")
+	close(unblock)
+	for range 3 {
+		assert.Equal(t, StatusSucceeded, (<-results).Status)
+	}
+	assert.Zero(t, limiter.Waiting())
+
+	// Without a queue, matches beyond the limit are skipped
+	unblock = make(chan struct{})
+	trig.Concurrency = &trigger.Concurrency{Max: 1, Queue: -1}
+	run(event("4", "alice"))
+	assert.Equal(t, "4", <-started)
+	result := guard.Run(context.Background(), executor, trig, event("5", "bob"))
+	assert.Equal(t, StatusSkipped, result.Status)
+	assert.Contains(t, result.Error, ErrConcurrencyQueueFull.Error())
+	_, err := guard.RunQueued(context.Background(), executor, trig, event("5", "bob"))
+	assert.ErrorIs(t, err, ErrConcurrencyQueueFull)
+	close(unblock)
+	assert.Equal(t, StatusSucceeded, (<-results).Status)
+}
+
+// TestGuardRetriesFullQueue tests that when a limited and an unlimited trigger match an
+// event and the limited one finds its queue full, only the limited match is retried:
+// the unlimited action runs once, the budget is taken once per action and the event is
+// acknowledged without being redelivered
+func TestGuardRetriesFullQueue(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+
+	id := fmt.Sprint(time.Now().UnixNano())
+	stream := "queue-full-test-" + id
+	subject := "queuefulltest." + id
+	_, err = js.AddStream(&nats.StreamConfig{Name: stream, Subjects: []string{subject}})
+	require.NoError(t, err)
+	defer js.DeleteStream(stream)
+
+	// The budget only covers one run of each action
+	guard := &Guard{Concurrency: NewConcurrencyLimiter(), Budget: NewBudget(BudgetConfig{Global: 2})}
+	limited := &trigger.Trigger{ID: "remediate", Action: "restart", Concurrency: &trigger.Concurrency{Max: 1, Queue: -1}}
+	unlimited := &trigger.Trigger{ID: "notify", Action: "notify"}
+	// Another match holds the limited trigger's only slot
+	release, err := guard.Concurrency.Acquire(context.Background(), limited, newTestEvent())
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	ran := make(map[string]int)
+	executor := ExecutorFunc(func(ctx context.Context, t *trigger.Trigger, e *cloudevents.Event) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		ran[t.ID]++
+		return "done", nil
+	})
+	runs := func(id string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return ran[id]
+	}
+	results := make(chan Result, 1)
+	var watcher *event.Watcher
+	watcher, err = event.NewWatcher(event.WatcherConfig{
+		URL:           nats.DefaultURL,
+		StreamName:    stream,
+		Subject:       subject,
+		DurableName:   "queue-full-test-" + id,
+		AckWait:       time.Second,
+		MaxDeliveries: 5,
+	}, func(e *cloudevents.Event) error {
+		// Like triggerd, unlimited actions run right away and limited ones run in the
+		// background and finish the event
+		guard.Run(context.Background(), executor, unlimited, e)
+		finish := watcher.Defer(e)
+		go func() {
+			result, err := guard.RunRetrying(context.Background(), executor, limited, e, 100*time.Millisecond)
+			results <- result
+			finish(err)
+		}()
+		return nil
+	})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, watcher.Start(ctx))
+
+	data, err := newTestEvent().MarshalJSON()
+	require.NoError(t, err)
+	_, err = js.Publish(subject, data)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool { return runs(unlimited.ID) == 1 }, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(1500 * time.Millisecond)
+	assert.Zero(t, runs(limited.ID), "a full queue does not run the action")
+	assert.Zero(t, watcher.Stats().Acked, "the event is kept until its limited action ran")
+	release()
+
+	select {
+	case result := <-results:
+		assert.Equal(t, StatusSucceeded, result.Status, result.Error)
+	case <-time.After(5 * time.Second):
+		t.Fatal("match was not retried once the slot was free")
+	}
+	require.Eventually(t, func() bool { return watcher.Stats().Acked == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, runs(unlimited.ID))
+	assert.Equal(t, 1, runs(limited.ID))
+	assert.Zero(t, watcher.Stats().Naked)
+}
+
+// TestWebhookExecutor tests that webhook actions use the secret token and share connections
+func TestWebhookExecutor(t *testing.T) {
+	var auth []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	resources := NewResources(nil)
+	defer resources.Close()
+	executor := Router{
+		Webhook: NewWebhookExecutor(WebhookExecutorConfig{}),
+		Default: LogExecutor{},
+	}
+	trig := &trigger.Trigger{ID: "notify", Action: WebhookActionPrefix + server.URL + "/hook"}
+
+	// Executing before initialization fails instead of building a client per event
+	_, err := executor.Execute(context.Background(), trig, newTestEvent())
+	assert.ErrorContains(t, err, "not initialized")
+
+	require.NoError(t, Initialize(context.Background(), executor, &ExecutionContext{
+		Secrets:   StaticSecrets{DefaultWebhookTokenSecret: "s3cret"},
+		Resources: resources,
+	}))
+	for i := 0; i < 3; i++ {
+		result := Run(context.Background(), executor, trig, newTestEvent())
+		assert.Equal(t, StatusSucceeded, result.Status, result.Error)
+	}
+	assert.Equal(t, []string{"Bearer s3cret", "Bearer s3cret", "Bearer s3cret"}, auth)
+
+	stats := resources.Stats()
+	assert.Equal(t, uint64(3), stats.HTTPRequests)
+	assert.Equal(t, uint64(1), stats.HTTPNewConnections)
+	assert.Equal(t, uint64(2), stats.HTTPReusedConnections)
+
+	result := Run(context.Background(), executor, &trigger.Trigger{ID: "notify", Action: WebhookActionPrefix + server.URL + "/fail"}, newTestEvent())
+	assert.Equal(t, StatusFailed, result.Status)
+	assert.Contains(t, result.Error, "502")
+}
+
+// fakeKafkaWriter fails its first writes and records the rest
+type fakeKafkaWriter struct {
+	failures int
+	messages []kafka.Message
+}
+
+func (w *fakeKafkaWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if w.failures > 0 {
+		w.failures--
+		return errors.New("leader not available")
+	}
+	w.messages = append(w.messages, msgs...)
+	return nil
+}
+
+func (w *fakeKafkaWriter) Close() error { return nil }
+
+// fakeAMQPPublisher nacks its first publishes and records the rest
+type fakeAMQPPublisher struct {
+	nacks     int
+	published []string
+	messages  []amqp.Publishing
+}
+
+func (p *fakeAMQPPublisher) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	if p.nacks > 0 {
+		p.nacks--
+		return ErrPublishNacked
+	}
+	p.published = append(p.published, exchange+"/"+key)
+	p.messages = append(p.messages, msg)
+	return nil
+}
+
+func (p *fakeAMQPPublisher) Close() error { return nil }
+
+// TestBrokerExecutors tests destination templating and retried delivery of Kafka and AMQP actions
+func TestBrokerExecutors(t *testing.T) {
+	ctx := context.Background()
+	publish := PublishConfig{RetryBackoff: time.Millisecond}
+	ce := newTestEvent()
+	require.NoError(t, ce.SetData(cloudevents.ApplicationJSON, map[string]interface{}{"region": "eu"}))
+
+	writer := &fakeKafkaWriter{failures: 2}
+	kafkaExecutor := NewKafkaExecutor(KafkaExecutorConfig{Publish: publish})
+	amqpExecutor := NewAMQPExecutor(AMQPExecutorConfig{Publish: publish})
+	executor := Router{Kafka: kafkaExecutor, AMQP: amqpExecutor, Default: LogExecutor{}}
+
+	// Without brokers the actions fail instead of falling back to the default executor
+	result := Run(ctx, executor, &trigger.Trigger{ID: "stream", Action: KafkaActionPrefix + "orders"}, ce)
+	assert.Equal(t, StatusFailed, result.Status)
+	assert.Contains(t, result.Error, "not configured")
+
+	kafkaExecutor.writer = writer
+	result = Run(ctx, executor, &trigger.Trigger{ID: "stream", Action: KafkaActionPrefix + "orders-{{data.region}}"}, ce)
+	require.Equal(t, StatusSucceeded, result.Status, result.Error)
+	assert.Equal(t, "published to kafka topic orders-eu", result.Output)
+	require.Len(t, writer.messages, 1)
+	assert.Equal(t, "orders-eu", writer.messages[0].Topic)
+	assert.Equal(t, "event-1", string(writer.messages[0].Key))
+
+	// A placeholder the event has no value for fails without publishing
+	result = Run(ctx, executor, &trigger.Trigger{ID: "stream", Action: KafkaActionPrefix + "orders-{{data.country}}"}, ce)
+	assert.Equal(t, StatusFailed, result.Status)
+	assert.Contains(t, result.Error, "data.country")
+	assert.Len(t, writer.messages, 1)
+
+	publisher := &fakeAMQPPublisher{nacks: 1}
+	amqpExecutor.publisher = publisher
+	result = Run(ctx, executor, &trigger.Trigger{ID: "fanout", Action: AMQPActionPrefix + "orders/created.{{data.region}}"}, ce)
+	require.Equal(t, StatusSucceeded, result.Status, result.Error)
+	assert.Equal(t, []string{"orders/created.eu"}, publisher.published)
+	assert.Equal(t, "event-1", publisher.messages[0].MessageId)
+	assert.Equal(t, amqp.Persistent, publisher.messages[0].DeliveryMode)
+
+	// Retries are bounded and the last error is reported
+	publisher.nacks = DefaultPublishRetries + 1
+	result = Run(ctx, executor, &trigger.Trigger{ID: "fanout", Action: AMQPActionPrefix + "/audit"}, ce)
+	assert.Equal(t, StatusFailed, result.Status)
+	assert.Contains(t, result.Error, "failed after 4 attempts")
+	assert.Contains(t, result.Error, ErrPublishNacked.Error())
+	assert.Len(t, publisher.published, 1)
+}
+
+// TestSecretProviders tests resolving secrets from the environment and from files
+func TestSecretProviders(t *testing.T) {
+	ctx := context.Background()
+
+	t.Setenv("TEST_SECRET_SMTP_PASSWORD", "hunter2")
+	value, err := EnvSecrets{Prefix: "TEST_SECRET_"}.Secret(ctx, "smtp-password")
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", value)
+	_, err = EnvSecrets{Prefix: "TEST_SECRET_"}.Secret(ctx, "missing")
+	assert.ErrorIs(t, err, ErrSecretNotFound)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "webhook-token"), []byte("abc\n"), 0600))
+	value, err = DirSecrets{Dir: dir}.Secret(ctx, "webhook-token")
+	require.NoError(t, err)
+	assert.Equal(t, "abc", value)
+	_, err = DirSecrets{Dir: dir}.Secret(ctx, "missing")
+	assert.ErrorIs(t, err, ErrSecretNotFound)
+	_, err = DirSecrets{Dir: dir}.Secret(ctx, "../etc/passwd")
+	assert.Error(t, err)
+}
+
+// slaMetrics records the latencies an SLA tracker reports
+type slaMetrics struct {
+	breached []bool
+}
+
+func (m *slaMetrics) RecordSLALatency(eventType, action string, latency, target time.Duration, breached bool) {
+	m.breached = append(m.breached, breached)
+}
+
+// TestSLATracker tests loading SLA policies and publishing breach events for slow actions
+func TestSLATracker(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sla.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(`rules:
+  - event_types: ["config.*"]
+    target: 1m
+  - target: 5m
+`), 0600))
+	policy, err := LoadSLAPolicy(file)
+	require.NoError(t, err)
+	target, ok := policy.Target("config.updated")
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, target)
+	target, _ = policy.Target("order.created")
+	assert.Equal(t, 5*time.Minute, target)
+
+	require.NoError(t, os.WriteFile(file, []byte("rules:\n  - event_types: [\"config.*\"]\n"), 0600))
+	_, err = LoadSLAPolicy(file)
+	assert.ErrorContains(t, err, "target must be positive")
+
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	sub, err := nc.SubscribeSync("sla-test")
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	metrics := &slaMetrics{}
+	tracker := NewSLATracker(nc, &SLAPolicy{Rules: []SLARule{{EventTypes: []string{"config.*"}, Target: time.Minute}}},
+		SLATrackerConfig{Subject: "sla-test", Metrics: metrics})
+	result := Result{TriggerID: "remediate", EventID: "event-1", Action: "restart", Status: StatusSucceeded}
+
+	fast := newTestEvent()
+	fast.SetTime(time.Now().Add(-time.Second))
+	require.NoError(t, tracker.Observe(result, fast))
+	slow := newTestEvent()
+	slow.SetTime(time.Now().Add(-2 * time.Minute))
+	require.NoError(t, tracker.Observe(result, slow))
+	// Parked actions have not completed yet
+	require.NoError(t, tracker.Observe(Result{Status: StatusParked}, slow))
+	assert.Equal(t, []bool{false, true}, metrics.breached)
+	assert.Equal(t, SLAStats{Observed: 2, Breached: 1}, tracker.Stats())
+
+	msg, err := sub.NextMsg(time.Second)
+	require.NoError(t, err)
+	received := cloudevents.NewEvent()
+	require.NoError(t, received.UnmarshalJSON(msg.Data))
+	assert.Equal(t, EventTypeSLABreach, received.Type())
+	assert.Equal(t, []string{"event-1"}, event.ParentIDs(&received))
+
+	alert := &trigger.Trigger{
+		ID:       "alert",
+		Enabled:  true,
+		Criteria: `event.event_type == "sla.breach" && event.data.after.event_type == "config.updated" && event.data.after.latency_ms > event.data.after.target_ms`,
+	}
+	matched, err := trigger.MatchTrigger(alert, &received)
+	require.NoError(t, err)
+	assert.True(t, matched)
+	_, err = sub.NextMsg(100 * time.Millisecond)
+	assert.ErrorIs(t, err, nats.ErrTimeout)
+}
+
+// TestBuildGraph tests the event flow graph and its cycle detection
+func TestBuildGraph(t *testing.T) {
+	triggers := []*trigger.Trigger{
+		{ID: "enrich-users", EventType: "user.updated", Action: "function:enrich", Enabled: true},
+		{ID: "sync-users", EventType: "user.enriched", Action: "function:sync", Enabled: true},
+		{ID: "page-on-failure", EventType: EventTypeActionFailed, Action: "notify"},
+	}
+	g := BuildGraph(triggers, map[string][]string{
+		"enrich": {"prod.user.enriched"},
+		"sync":   {"prod.user.updated"},
+	})
+
+	assert.Contains(t, g.Edges, GraphEdge{From: "event_type:user.updated", To: "trigger:enrich-users"})
+	assert.Contains(t, g.Edges, GraphEdge{From: "action:function:enrich", To: "function:enrich"})
+	assert.Contains(t, g.Edges, GraphEdge{From: "function:enrich", To: "event_type:prod.user.enriched"})
+	assert.Contains(t, g.Edges, GraphEdge{From: "event_type:prod.user.enriched", To: "trigger:sync-users"})
+	assert.Contains(t, g.Edges, GraphEdge{From: "action:function:sync", To: "event_type:action.failed"})
+	assert.Contains(t, g.Nodes, GraphNode{ID: "trigger:page-on-failure", Kind: NodeTrigger, Label: "page-on-failure", Disabled: true})
+
+	// Users bounce between the two functions, and failing notifications page again
+	assert.Equal(t, [][]string{
+		{
+			"action:function:enrich", "action:function:sync",
+			"event_type:prod.user.enriched", "event_type:prod.user.updated",
+			"function:enrich", "function:sync",
+			"trigger:enrich-users", "trigger:sync-users",
+		},
+		{"action:notify", "event_type:action.failed", "trigger:page-on-failure"},
+	}, g.Cycles)
+
+	var dot strings.Builder
+	require.NoError(t, g.WriteDOT(&dot))
+	assert.Contains(t, dot.String(), `"function:sync" -> "event_type:prod.user.updated" [color=red];`)
+	assert.Contains(t, dot.String(), `"event_type:user.updated" -> "trigger:enrich-users";`)
+	assert.Contains(t, dot.String(), `"trigger:page-on-failure" [label="page-on-failure", shape=box, style=dashed];`)
+
+	// Without declared outputs the flow ends at the functions
+	assert.Empty(t, BuildGraph(triggers[:2], nil).Cycles)
+
+	// Branching triggers lead to every action they may take
+	g = BuildGraph([]*trigger.Trigger{{ID: "alerts", Branches: []trigger.Branch{{When: "true", Action: "function:page"}}, Action: "notify"}}, nil)
+	assert.Contains(t, g.Edges, GraphEdge{From: "trigger:alerts", To: "action:function:page"})
+	assert.Contains(t, g.Edges, GraphEdge{From: "trigger:alerts", To: "action:notify"})
+}
@@ -0,0 +1,86 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/event"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// Broker publishing defaults
+const (
+	DefaultPublishTimeout = 10 * time.Second
+	DefaultPublishRetries = 3
+	DefaultPublishBackoff = 500 * time.Millisecond
+)
+
+// cloudEventsContentType is the content type of structured CloudEvents
+const cloudEventsContentType = "application/cloudevents+json"
+
+// PublishConfig configures the delivery of actions publishing to external brokers
+type PublishConfig struct {
+	// Timeout bounds each attempt, including the broker's confirmation (default: DefaultPublishTimeout)
+	Timeout time.Duration
+	// Retries is the number of attempts after a failed one (default: DefaultPublishRetries,
+	// negative disables retries)
+	Retries int
+	// RetryBackoff is the wait before the first retry, doubling with every further one
+	// (default: DefaultPublishBackoff)
+	RetryBackoff time.Duration
+}
+
+// withDefaults fills in unset publish settings
+func (c PublishConfig) withDefaults() PublishConfig {
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultPublishTimeout
+	}
+	if c.Retries == 0 {
+		c.Retries = DefaultPublishRetries
+	}
+	if c.Retries < 0 {
+		c.Retries = 0
+	}
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = DefaultPublishBackoff
+	}
+	return c
+}
+
+// publishWithRetry calls publish until the broker confirmed the message, the retries
+// are used up or ctx is done
+func publishWithRetry(ctx context.Context, cfg PublishConfig, publish func(ctx context.Context) error) error {
+	backoff := cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+		err := publish(attemptCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if attempt >= cfg.Retries {
+			return fmt.Errorf("failed after %d attempts: %w", attempt+1, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed after %d attempts: %w", attempt+1, err)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// expandAction expands the placeholders of an action's destination with the event's
+// attributes and data
+func expandAction(destination string, e *cloudevents.Event) (string, error) {
+	var data map[string]interface{}
+	if len(e.Data()) > 0 {
+		// Data that is not a JSON object only leaves data placeholders without a value
+		json.Unmarshal(e.Data(), &data)
+	}
+	return event.ExpandTemplate(destination, e, data, nil)
+}
@@ -0,0 +1,120 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/trigger"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// ErrConcurrencyQueueFull is returned when a trigger's action is at its concurrency
+// limit and its queue of waiting matches is full
+var ErrConcurrencyQueueFull = errors.New("trigger concurrency queue full")
+
+// ConcurrencyRetryDelay is how long a match that found its trigger's concurrency queue
+// full waits before it is retried
+const ConcurrencyRetryDelay = 5 * time.Second
+
+// ConcurrencyLimiter enforces the concurrency limits of triggers. Each trigger, or each
+// object of a trigger in object scope, has Concurrency.Max slots; matches beyond them
+// wait in order for a free slot, up to the trigger's queue limit.
+type ConcurrencyLimiter struct {
+	mu    sync.Mutex
+	slots map[string]*concurrencySlots
+}
+
+// concurrencySlots are the slots of a trigger or object. users counts the holders and
+// waiters, so idle slots are forgotten and per-object slots do not accumulate.
+type concurrencySlots struct {
+	running chan struct{}
+	waiting int
+	users   int
+}
+
+// NewConcurrencyLimiter creates a concurrency limiter
+func NewConcurrencyLimiter() *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{slots: make(map[string]*concurrencySlots)}
+}
+
+// Acquire takes a slot for the execution of the trigger's action for an event, waiting
+// for one until ctx is done. The returned function frees the slot. Triggers without a
+// concurrency limit, and a nil limiter, always get a slot.
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context, t *trigger.Trigger, event *cloudevents.Event) (func(), error) {
+	if l == nil || t.Concurrency == nil {
+		return func() {}, nil
+	}
+	key, err := t.ConcurrencyKey(event)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	slots, ok := l.slots[key]
+	if !ok {
+		slots = &concurrencySlots{running: make(chan struct{}, t.Concurrency.Max)}
+		l.slots[key] = slots
+	}
+	select {
+	case slots.running <- struct{}{}:
+		slots.users++
+		l.mu.Unlock()
+		return func() { l.release(key, slots) }, nil
+	default:
+	}
+	if slots.waiting >= t.Concurrency.QueueLimit() {
+		l.mu.Unlock()
+		return nil, fmt.Errorf("%w: %d waiting", ErrConcurrencyQueueFull, slots.waiting)
+	}
+	slots.waiting++
+	slots.users++
+	l.mu.Unlock()
+
+	select {
+	case slots.running <- struct{}{}:
+		l.mu.Lock()
+		slots.waiting--
+		l.mu.Unlock()
+		return func() { l.release(key, slots) }, nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		slots.waiting--
+		l.forget(key, slots)
+		l.mu.Unlock()
+		return nil, fmt.Errorf("gave up waiting for a concurrency slot: %w", ctx.Err())
+	}
+}
+
+// release frees a slot
+func (l *ConcurrencyLimiter) release(key string, slots *concurrencySlots) {
+	<-slots.running
+	l.mu.Lock()
+	l.forget(key, slots)
+	l.mu.Unlock()
+}
+
+// forget drops a user of the slots and the slots once they have none
+func (l *ConcurrencyLimiter) forget(key string, slots *concurrencySlots) {
+	slots.users--
+	if slots.users == 0 && l.slots[key] == slots {
+		delete(l.slots, key)
+	}
+}
+
+// Waiting returns how many matches wait for a slot across all triggers
+func (l *ConcurrencyLimiter) Waiting() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	waiting := 0
+	for _, slots := range l.slots {
+		waiting += slots.waiting
+	}
+	return waiting
+}
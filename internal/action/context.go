@@ -0,0 +1,184 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// DefaultSecretEnvPrefix prefixes the environment variables EnvSecrets reads
+const DefaultSecretEnvPrefix = "MYCELIUM_SECRET_"
+
+// ErrSecretNotFound is returned by secret providers for unknown secrets
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretProvider resolves secrets such as webhook tokens and SMTP credentials by name
+type SecretProvider interface {
+	Secret(ctx context.Context, name string) (string, error)
+}
+
+// StaticSecrets resolves secrets from a map
+type StaticSecrets map[string]string
+
+// Secret returns the secret stored under name
+func (s StaticSecrets) Secret(ctx context.Context, name string) (string, error) {
+	value, ok := s[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	return value, nil
+}
+
+// EnvSecrets resolves secrets from environment variables. A secret's variable is its
+// name upper-cased with dashes and dots replaced by underscores, after Prefix, e.g.
+// MYCELIUM_SECRET_WEBHOOK_TOKEN for webhook-token.
+type EnvSecrets struct {
+	Prefix string
+}
+
+// Secret returns the value of the secret's environment variable
+func (s EnvSecrets) Secret(ctx context.Context, name string) (string, error) {
+	key := s.Prefix + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	return value, nil
+}
+
+// DirSecrets resolves secrets from a directory holding one file per secret, such as
+// a mounted Kubernetes secret. Trailing newlines are trimmed.
+type DirSecrets struct {
+	Dir string
+}
+
+// Secret returns the contents of the secret's file
+func (s DirSecrets) Secret(ctx context.Context, name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid secret name %q", name)
+	}
+	data, err := os.ReadFile(filepath.Join(s.Dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret %s: %w", name, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// ConnectionStats counts how often the shared resources reused connections
+type ConnectionStats struct {
+	HTTPRequests          uint64 `json:"http_requests"`
+	HTTPNewConnections    uint64 `json:"http_new_connections"`
+	HTTPReusedConnections uint64 `json:"http_reused_connections"`
+	NATSReconnects        uint64 `json:"nats_reconnects"`
+}
+
+// ReuseRatio returns the share of HTTP requests served over a reused connection
+func (s ConnectionStats) ReuseRatio() float64 {
+	total := s.HTTPNewConnections + s.HTTPReusedConnections
+	if total == 0 {
+		return 0
+	}
+	return float64(s.HTTPReusedConnections) / float64(total)
+}
+
+// Resources are long-lived clients shared by all executors, so actions reuse
+// connections instead of dialing per event
+type Resources struct {
+	// HTTP is a pooled client that records connection reuse
+	HTTP *http.Client
+	// NATS is the daemon's connection (optional)
+	NATS *nats.Conn
+
+	requests atomic.Uint64
+	dialed   atomic.Uint64
+	reused   atomic.Uint64
+}
+
+// NewResources creates shared resources around a NATS connection, which may be nil
+func NewResources(nc *nats.Conn) *Resources {
+	r := &Resources{NATS: nc}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 32
+	transport.IdleConnTimeout = 90 * time.Second
+	r.HTTP = &http.Client{Transport: &countingTransport{base: transport, resources: r}}
+	return r
+}
+
+// Stats returns the connection counters since the resources were created
+func (r *Resources) Stats() ConnectionStats {
+	stats := ConnectionStats{
+		HTTPRequests:          r.requests.Load(),
+		HTTPNewConnections:    r.dialed.Load(),
+		HTTPReusedConnections: r.reused.Load(),
+	}
+	if r.NATS != nil {
+		stats.NATSReconnects = r.NATS.Stats().Reconnects
+	}
+	return stats
+}
+
+// Close closes idle HTTP connections; the NATS connection belongs to the caller
+func (r *Resources) Close() {
+	r.HTTP.CloseIdleConnections()
+}
+
+// countingTransport records whether each request got a new or a pooled connection
+type countingTransport struct {
+	base      http.RoundTripper
+	resources *Resources
+}
+
+// RoundTrip traces the connection of the request and delegates to the base transport
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.resources.requests.Add(1)
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				t.resources.reused.Add(1)
+			} else {
+				t.resources.dialed.Add(1)
+			}
+		},
+	}
+	return t.base.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+// CloseIdleConnections closes the idle connections of the base transport
+func (t *countingTransport) CloseIdleConnections() {
+	if closer, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// ExecutionContext is what executors receive when they are initialized
+type ExecutionContext struct {
+	Secrets   SecretProvider
+	Resources *Resources
+}
+
+// Initializer is implemented by executors that need secrets or shared resources.
+// Init is called once before the executor runs any action.
+type Initializer interface {
+	Init(ctx context.Context, ec *ExecutionContext) error
+}
+
+// Initialize initializes the executor if it implements Initializer
+func Initialize(ctx context.Context, executor Executor, ec *ExecutionContext) error {
+	if initializer, ok := executor.(Initializer); ok {
+		return initializer.Init(ctx, ec)
+	}
+	return nil
+}
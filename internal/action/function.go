@@ -0,0 +1,167 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/trigger"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// FunctionActionPrefix marks trigger actions that invoke a function directly, e.g. "function:resize-image"
+const FunctionActionPrefix = "function:"
+
+// Function binding defaults
+const (
+	DefaultBindingConcurrency = 10
+	DefaultFunctionTimeout    = 30 * time.Second
+)
+
+// FunctionName returns the function bound by an action of the form "function:<name>"
+func FunctionName(action string) (string, bool) {
+	name, ok := strings.CutPrefix(action, FunctionActionPrefix)
+	return name, ok && name != ""
+}
+
+// Invoker invokes functions on the runtime; *function.Client implements it
+type Invoker interface {
+	InvokeFunction(ctx context.Context, name string, event *cloudevents.Event) ([]*cloudevents.Event, error)
+}
+
+// FunctionExecutorConfig configures direct function bindings
+type FunctionExecutorConfig struct {
+	// MaxConcurrent limits concurrent invocations per binding (default: DefaultBindingConcurrency)
+	MaxConcurrent int
+	// BindingConcurrency overrides MaxConcurrent for individual bindings, keyed by trigger ID
+	BindingConcurrency map[string]int
+	// Timeout bounds each invocation (default: DefaultFunctionTimeout)
+	Timeout time.Duration
+}
+
+// FunctionExecutor runs "function:<name>" actions by invoking the function runtime.
+// Every trigger is a separate binding with its own concurrency limit; invocations
+// wait for a free slot until the context is done.
+type FunctionExecutor struct {
+	invoker Invoker
+	cfg     FunctionExecutorConfig
+	mu      sync.Mutex
+	slots   map[string]chan struct{}
+}
+
+// NewFunctionExecutor creates a function executor that invokes functions through invoker
+func NewFunctionExecutor(invoker Invoker, cfg FunctionExecutorConfig) *FunctionExecutor {
+	if cfg.MaxConcurrent <= 0 {
+		cfg.MaxConcurrent = DefaultBindingConcurrency
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultFunctionTimeout
+	}
+	return &FunctionExecutor{
+		invoker: invoker,
+		cfg:     cfg,
+		slots:   make(map[string]chan struct{}),
+	}
+}
+
+// Execute invokes the function bound by the trigger's action
+func (e *FunctionExecutor) Execute(ctx context.Context, t *trigger.Trigger, event *cloudevents.Event) (string, error) {
+	name, ok := FunctionName(t.Action)
+	if !ok {
+		return "", fmt.Errorf("action %q is not a function binding", t.Action)
+	}
+
+	// Wait for a free slot in the binding
+	slots := e.bindingSlots(t.ID)
+	select {
+	case slots <- struct{}{}:
+		defer func() { <-slots }()
+	case <-ctx.Done():
+		return "", fmt.Errorf("binding %s saturated: %w", t.ID, ctx.Err())
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.cfg.Timeout)
+	defer cancel()
+
+	events, err := e.invoker.InvokeFunction(ctx, name, event)
+	var partial partialResult
+	if errors.As(err, &partial) {
+		return fmt.Sprintf("function %s returned %d events (partial: %s)", name, len(events), partial.PartialReason()), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("function %s: %w", name, err)
+	}
+	return fmt.Sprintf("function %s returned %d events", name, len(events)), nil
+}
+
+// partialResult is the error of a function that returned a partial result, see
+// function.PartialResultError; the action succeeds with the events produced
+type partialResult interface {
+	error
+	PartialReason() string
+}
+
+// bindingSlots returns the semaphore limiting concurrent invocations of a binding
+func (e *FunctionExecutor) bindingSlots(triggerID string) chan struct{} {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	slots, exists := e.slots[triggerID]
+	if !exists {
+		limit := e.cfg.MaxConcurrent
+		if override, ok := e.cfg.BindingConcurrency[triggerID]; ok && override > 0 {
+			limit = override
+		}
+		slots = make(chan struct{}, limit)
+		e.slots[triggerID] = slots
+	}
+	return slots
+}
+
+// Router sends "function:<name>" actions to the function executor, "webhook:<url>" actions
+// to the webhook executor, "kafka:" and "amqp:" actions to the broker executors and
+// everything else to the default executor
+type Router struct {
+	Function Executor
+	Webhook  Executor
+	Kafka    Executor
+	AMQP     Executor
+	Default  Executor
+}
+
+// Init initializes the routed executors that implement Initializer
+func (r Router) Init(ctx context.Context, ec *ExecutionContext) error {
+	for _, executor := range []Executor{r.Function, r.Webhook, r.Kafka, r.AMQP, r.Default} {
+		if executor == nil {
+			continue
+		}
+		if err := Initialize(ctx, executor, ec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Execute dispatches the trigger's action to the matching executor
+func (r Router) Execute(ctx context.Context, t *trigger.Trigger, event *cloudevents.Event) (string, error) {
+	if strings.HasPrefix(t.Action, FunctionActionPrefix) && r.Function != nil {
+		return r.Function.Execute(ctx, t, event)
+	}
+	if strings.HasPrefix(t.Action, WebhookActionPrefix) && r.Webhook != nil {
+		return r.Webhook.Execute(ctx, t, event)
+	}
+	if strings.HasPrefix(t.Action, KafkaActionPrefix) && r.Kafka != nil {
+		return r.Kafka.Execute(ctx, t, event)
+	}
+	if strings.HasPrefix(t.Action, AMQPActionPrefix) && r.AMQP != nil {
+		return r.AMQP.Execute(ctx, t, event)
+	}
+	if r.Default == nil {
+		return "", fmt.Errorf("no executor for action %q", t.Action)
+	}
+	return r.Default.Execute(ctx, t, event)
+}
@@ -0,0 +1,277 @@
+package action
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/julianshen/mycelium/internal/trigger"
+)
+
+// Graph node kinds
+const (
+	NodeEventType = "event_type"
+	NodeTrigger   = "trigger"
+	NodeAction    = "action"
+	NodeFunction  = "function"
+)
+
+// anyEventType labels the node wildcard triggers hang off
+const anyEventType = "*"
+
+// GraphNode is an event type, trigger, action or function in the event flow
+type GraphNode struct {
+	ID       string `json:"id"`
+	Kind     string `json:"kind"`
+	Label    string `json:"label"`
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+// GraphEdge is a step of the event flow
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Graph is the event flow topology: event types lead to the triggers bound to them,
+// triggers to their actions, function actions to their functions and functions to
+// the event types they declare they emit. Cycles lists the groups of nodes events can
+// loop through, each sorted by ID.
+type Graph struct {
+	Nodes  []GraphNode `json:"nodes"`
+	Edges  []GraphEdge `json:"edges"`
+	Cycles [][]string  `json:"cycles,omitempty"`
+}
+
+// graphBuilder collects nodes and edges without duplicates
+type graphBuilder struct {
+	nodes map[string]GraphNode
+	edges map[GraphEdge]bool
+}
+
+// node adds a node unless it exists and returns its ID
+func (b *graphBuilder) node(kind, label string) string {
+	id := kind + ":" + label
+	if _, ok := b.nodes[id]; !ok {
+		b.nodes[id] = GraphNode{ID: id, Kind: kind, Label: label}
+	}
+	return id
+}
+
+// edge adds an edge between two nodes
+func (b *graphBuilder) edge(from, to string) {
+	b.edges[GraphEdge{From: from, To: to}] = true
+}
+
+// consumes reports whether a trigger's event type applies to an event type, ignoring
+// namespaces: either the full type or the type without its namespace
+func consumes(triggerType, eventType string) bool {
+	if triggerType == eventType {
+		return true
+	}
+	_, local, ok := strings.Cut(eventType, ".")
+	return ok && local == triggerType
+}
+
+// BuildGraph builds the event flow graph of triggers. emits maps function names to the
+// event types they declare (function.FunctionMeta.Emits); functions missing from it
+// have no known outputs. Actions lead to the action result event types when triggers
+// consume them.
+func BuildGraph(triggers []*trigger.Trigger, emits map[string][]string) *Graph {
+	b := &graphBuilder{nodes: make(map[string]GraphNode), edges: make(map[GraphEdge]bool)}
+
+	var actions []string
+	for _, t := range triggers {
+		id := b.node(NodeTrigger, t.ID)
+		if !t.Enabled {
+			node := b.nodes[id]
+			node.Disabled = true
+			b.nodes[id] = node
+		}
+
+		eventType := t.EventType
+		if eventType == "" {
+			eventType = anyEventType
+		}
+		b.edge(b.node(NodeEventType, eventType), id)
+
+		// Branching triggers lead to every action a branch may take
+		taken := make([]string, 0, len(t.Branches)+1)
+		for _, branch := range t.Branches {
+			taken = append(taken, branch.Action)
+		}
+		if t.Action != "" {
+			taken = append(taken, t.Action)
+		}
+		for _, name := range taken {
+			action := b.node(NodeAction, name)
+			b.edge(id, action)
+			actions = append(actions, action)
+
+			if name, ok := FunctionName(name); ok {
+				function := b.node(NodeFunction, name)
+				b.edge(action, function)
+				for _, emitted := range emits[name] {
+					b.edge(function, b.node(NodeEventType, emitted))
+				}
+			}
+		}
+	}
+
+	// Every action publishes a result event
+	for _, resultType := range []string{EventTypeActionSucceeded, EventTypeActionFailed, EventTypeActionSkipped} {
+		for _, t := range triggers {
+			if t.EventType != "" && consumes(t.EventType, resultType) {
+				result := b.node(NodeEventType, resultType)
+				for _, action := range actions {
+					b.edge(action, result)
+				}
+				break
+			}
+		}
+	}
+
+	// Produced event types reach the triggers bound to their un-namespaced form and
+	// the wildcard triggers
+	_, hasWildcard := b.nodes[NodeEventType+":"+anyEventType]
+	for _, edge := range sortedEdges(b.edges) {
+		produced := b.nodes[edge.To]
+		if produced.Kind != NodeEventType || b.nodes[edge.From].Kind == NodeEventType {
+			continue
+		}
+		for _, t := range triggers {
+			if t.EventType != "" && t.EventType != produced.Label && consumes(t.EventType, produced.Label) {
+				b.edge(produced.ID, b.node(NodeTrigger, t.ID))
+			}
+		}
+		if hasWildcard && produced.Label != anyEventType {
+			b.edge(produced.ID, NodeEventType+":"+anyEventType)
+		}
+	}
+
+	g := &Graph{Nodes: make([]GraphNode, 0, len(b.nodes)), Edges: sortedEdges(b.edges)}
+	for _, node := range b.nodes {
+		g.Nodes = append(g.Nodes, node)
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].ID < g.Nodes[j].ID })
+	g.Cycles = findCycles(g)
+	return g
+}
+
+// sortedEdges returns a set of edges ordered by source and target
+func sortedEdges(set map[GraphEdge]bool) []GraphEdge {
+	edges := make([]GraphEdge, 0, len(set))
+	for edge := range set {
+		edges = append(edges, edge)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From != edges[j].From {
+			return edges[i].From < edges[j].From
+		}
+		return edges[i].To < edges[j].To
+	})
+	return edges
+}
+
+// findCycles returns the strongly connected components of the graph that contain a
+// cycle, using Tarjan's algorithm
+func findCycles(g *Graph) [][]string {
+	next := make(map[string][]string)
+	selfLoop := make(map[string]bool)
+	for _, edge := range g.Edges {
+		next[edge.From] = append(next[edge.From], edge.To)
+		if edge.From == edge.To {
+			selfLoop[edge.From] = true
+		}
+	}
+
+	index := make(map[string]int)
+	low := make(map[string]int)
+	onStack := make(map[string]bool)
+	var stack []string
+	var cycles [][]string
+
+	var visit func(id string)
+	visit = func(id string) {
+		index[id] = len(index)
+		low[id] = index[id]
+		stack = append(stack, id)
+		onStack[id] = true
+
+		for _, to := range next[id] {
+			if _, seen := index[to]; !seen {
+				visit(to)
+				low[id] = min(low[id], low[to])
+			} else if onStack[to] {
+				low[id] = min(low[id], index[to])
+			}
+		}
+
+		if low[id] != index[id] {
+			return
+		}
+		var component []string
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			component = append(component, top)
+			if top == id {
+				break
+			}
+		}
+		if len(component) > 1 || selfLoop[id] {
+			sort.Strings(component)
+			cycles = append(cycles, component)
+		}
+	}
+
+	for _, node := range g.Nodes {
+		if _, seen := index[node.ID]; !seen {
+			visit(node.ID)
+		}
+	}
+	sort.Slice(cycles, func(i, j int) bool { return cycles[i][0] < cycles[j][0] })
+	return cycles
+}
+
+// dotShapes are the Graphviz shapes of the node kinds
+var dotShapes = map[string]string{
+	NodeEventType: "ellipse",
+	NodeTrigger:   "box",
+	NodeAction:    "diamond",
+	NodeFunction:  "component",
+}
+
+// WriteDOT renders the graph in the Graphviz DOT language. Edges within cycles are red
+// and disabled triggers dashed.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	inCycle := make(map[string]int)
+	for i, cycle := range g.Cycles {
+		for _, id := range cycle {
+			inCycle[id] = i + 1
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("digraph events {\n\trankdir=LR;\n")
+	for _, node := range g.Nodes {
+		style := ""
+		if node.Disabled {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(&sb, "\t%q [label=%q, shape=%s%s];\n", node.ID, node.Label, dotShapes[node.Kind], style)
+	}
+	for _, edge := range g.Edges {
+		color := ""
+		if c := inCycle[edge.From]; c != 0 && c == inCycle[edge.To] {
+			color = " [color=red]"
+		}
+		fmt.Fprintf(&sb, "\t%q -> %q%s;\n", edge.From, edge.To, color)
+	}
+	sb.WriteString("}\n")
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,354 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/trigger"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/nats-io/nats.go"
+)
+
+// Kill switch storage
+const (
+	DefaultControlBucket = "triggerd-control"
+	KillSwitchKey        = "kill-switch"
+)
+
+var (
+	// ErrKillSwitchEngaged is returned while the kill switch pauses action execution
+	ErrKillSwitchEngaged = errors.New("kill switch engaged")
+	// ErrBudgetExceeded is returned when an action would exceed an execution budget
+	ErrBudgetExceeded = errors.New("action budget exceeded")
+)
+
+// BudgetConfig limits how many actions run per minute. Zero limits are unlimited.
+type BudgetConfig struct {
+	// Global limits the actions of all namespaces together
+	Global int
+	// PerNamespace limits the actions of each event namespace
+	PerNamespace int
+	// Namespaces overrides PerNamespace for individual namespaces
+	Namespaces map[string]int
+}
+
+// Budget enforces per-minute action budgets with token buckets that refill continuously
+type Budget struct {
+	cfg        BudgetConfig
+	global     *tokenBucket
+	namespaces map[string]*tokenBucket
+	now        func() time.Time
+	mu         sync.Mutex
+}
+
+// NewBudget creates an action budget
+func NewBudget(cfg BudgetConfig) *Budget {
+	return &Budget{
+		cfg:        cfg,
+		namespaces: make(map[string]*tokenBucket),
+		now:        time.Now,
+	}
+}
+
+// Allow takes one action from the global budget and the namespace's budget.
+// Nothing is taken when either budget is exhausted.
+func (b *Budget) Allow(namespace string) error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if b.global == nil && b.cfg.Global > 0 {
+		b.global = newTokenBucket(b.cfg.Global, now)
+	}
+	ns := b.namespaceBucket(namespace, now)
+	if b.global != nil && !b.global.available(now) {
+		return fmt.Errorf("%w: global limit of %d actions per minute", ErrBudgetExceeded, b.cfg.Global)
+	}
+	if ns != nil && !ns.available(now) {
+		return fmt.Errorf("%w: namespace %s limit of %d actions per minute", ErrBudgetExceeded, namespace, ns.limit)
+	}
+
+	if b.global != nil {
+		b.global.take()
+	}
+	if ns != nil {
+		ns.take()
+	}
+	return nil
+}
+
+// namespaceBucket returns the bucket of a namespace, nil when it is unlimited
+func (b *Budget) namespaceBucket(namespace string, now time.Time) *tokenBucket {
+	if bucket, ok := b.namespaces[namespace]; ok {
+		return bucket
+	}
+
+	limit := b.cfg.PerNamespace
+	if override, ok := b.cfg.Namespaces[namespace]; ok {
+		limit = override
+	}
+	var bucket *tokenBucket
+	if limit > 0 {
+		bucket = newTokenBucket(limit, now)
+	}
+	b.namespaces[namespace] = bucket
+	return bucket
+}
+
+// tokenBucket holds up to limit tokens and refills limit tokens per minute
+type tokenBucket struct {
+	limit  int
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit int, now time.Time) *tokenBucket {
+	return &tokenBucket{limit: limit, tokens: float64(limit), last: now}
+}
+
+// available refills the bucket and reports whether a token is left
+func (t *tokenBucket) available(now time.Time) bool {
+	if elapsed := now.Sub(t.last); elapsed > 0 {
+		t.tokens = min(float64(t.limit), t.tokens+elapsed.Minutes()*float64(t.limit))
+		t.last = now
+	}
+	return t.tokens >= 1
+}
+
+func (t *tokenBucket) take() {
+	t.tokens--
+}
+
+// KillSwitchState is the value of the kill switch flag
+type KillSwitchState struct {
+	Engaged bool      `json:"engaged"`
+	Reason  string    `json:"reason,omitempty"`
+	Since   time.Time `json:"since,omitempty"`
+}
+
+// KillSwitch is an emergency flag in a KV bucket that pauses action execution on every
+// trigger daemon watching it. Events are still consumed, matched and audited.
+type KillSwitch struct {
+	kv      nats.KeyValue
+	engaged atomic.Bool
+}
+
+// NewKillSwitch binds to the control bucket, creating it if it does not exist
+func NewKillSwitch(nc *nats.Conn, bucket string) (*KillSwitch, error) {
+	if bucket == "" {
+		bucket = DefaultControlBucket
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get JetStream context: %w", err)
+	}
+	kv, err := js.KeyValue(bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{Bucket: bucket})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get control bucket: %w", err)
+	}
+	return &KillSwitch{kv: kv}, nil
+}
+
+// Watch loads the current flag and keeps following changes until ctx is cancelled
+func (k *KillSwitch) Watch(ctx context.Context) error {
+	watcher, err := k.kv.Watch(KillSwitchKey, nats.Context(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to watch kill switch: %w", err)
+	}
+
+	// The watcher delivers the current value followed by a nil marker
+	for update := range watcher.Updates() {
+		if update == nil {
+			break
+		}
+		k.apply(update)
+	}
+
+	go func() {
+		defer watcher.Stop()
+		for update := range watcher.Updates() {
+			if update != nil {
+				k.apply(update)
+			}
+		}
+	}()
+	return nil
+}
+
+// apply updates the flag from a KV entry
+func (k *KillSwitch) apply(entry nats.KeyValueEntry) {
+	if entry.Operation() != nats.KeyValuePut {
+		k.engaged.Store(false)
+		return
+	}
+	var state KillSwitchState
+	k.engaged.Store(json.Unmarshal(entry.Value(), &state) == nil && state.Engaged)
+}
+
+// Engaged reports whether action execution is paused. A nil kill switch is never engaged.
+func (k *KillSwitch) Engaged() bool {
+	return k != nil && k.engaged.Load()
+}
+
+// Engage pauses action execution everywhere
+func (k *KillSwitch) Engage(ctx context.Context, reason string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(KillSwitchState{Engaged: true, Reason: reason, Since: time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to marshal kill switch: %w", err)
+	}
+	if _, err := k.kv.Put(KillSwitchKey, data); err != nil {
+		return fmt.Errorf("failed to engage kill switch: %w", err)
+	}
+	return nil
+}
+
+// Release resumes action execution
+func (k *KillSwitch) Release(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := k.kv.Delete(KillSwitchKey); err != nil && !errors.Is(err, nats.ErrKeyNotFound) {
+		return fmt.Errorf("failed to release kill switch: %w", err)
+	}
+	return nil
+}
+
+// State reads the kill switch flag from the bucket
+func (k *KillSwitch) State(ctx context.Context) (KillSwitchState, error) {
+	if err := ctx.Err(); err != nil {
+		return KillSwitchState{}, err
+	}
+	entry, err := k.kv.Get(KillSwitchKey)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return KillSwitchState{}, nil
+	}
+	if err != nil {
+		return KillSwitchState{}, fmt.Errorf("failed to get kill switch: %w", err)
+	}
+	var state KillSwitchState
+	if err := json.Unmarshal(entry.Value(), &state); err != nil {
+		return KillSwitchState{}, fmt.Errorf("invalid kill switch value: %w", err)
+	}
+	return state, nil
+}
+
+// Guard admits actions through the kill switch and the execution budget, parks them in
+// maintenance mode and holds them to their trigger's concurrency limit. Any of them
+// may be nil.
+type Guard struct {
+	KillSwitch  *KillSwitch
+	Maintenance *Maintenance
+	Budget      *Budget
+	Concurrency *ConcurrencyLimiter
+}
+
+// Admit reports why an action for an event namespace must not run, nil if it may
+func (g *Guard) Admit(namespace string) error {
+	if g == nil {
+		return nil
+	}
+	if g.KillSwitch.Engaged() {
+		return ErrKillSwitchEngaged
+	}
+	return g.Budget.Allow(namespace)
+}
+
+// Run executes the trigger's action when the guard admits it and records a skipped
+// result when it does not, so paused actions still show up in the result stream.
+// In maintenance mode the action is parked instead and a parked result is recorded.
+// Actions at their trigger's concurrency limit wait for a slot; they are skipped when
+// the trigger's queue is full.
+func (g *Guard) Run(ctx context.Context, executor Executor, t *trigger.Trigger, event *cloudevents.Event) Result {
+	result, _ := g.run(ctx, executor, t, event, true)
+	return result
+}
+
+// RunQueued executes the trigger's action like Run, but also returns
+// ErrConcurrencyQueueFull when the trigger's concurrency queue is full, so the caller
+// can retry the match instead of recording it as skipped
+func (g *Guard) RunQueued(ctx context.Context, executor Executor, t *trigger.Trigger, event *cloudevents.Event) (Result, error) {
+	return g.run(ctx, executor, t, event, true)
+}
+
+// RunRetrying executes the trigger's action like Run, but retries the match after
+// delay while the trigger's concurrency queue is full instead of skipping it. Only this
+// match is retried, so the other actions of its event run once. It returns ctx's error
+// when ctx is done before the match got into the queue.
+func (g *Guard) RunRetrying(ctx context.Context, executor Executor, t *trigger.Trigger, event *cloudevents.Event, delay time.Duration) (Result, error) {
+	for {
+		result, err := g.run(ctx, executor, t, event, true)
+		if !errors.Is(err, ErrConcurrencyQueueFull) {
+			return result, nil
+		}
+		select {
+		case <-ctx.Done():
+			return result, fmt.Errorf("%w: %w", err, ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
+
+// RunParked runs the action of a match replayed after maintenance like Run, without
+// parking it again
+func (g *Guard) RunParked(ctx context.Context, executor Executor, t *trigger.Trigger, event *cloudevents.Event) Result {
+	result, _ := g.run(ctx, executor, t, event, false)
+	return result
+}
+
+// run executes the trigger's action; err is only set when the concurrency queue was
+// full, along with a skipped result
+func (g *Guard) run(ctx context.Context, executor Executor, t *trigger.Trigger, event *cloudevents.Event, park bool) (Result, error) {
+	notRun := func(status string, err error) Result {
+		return Result{
+			TriggerID: t.ID,
+			EventID:   event.ID(),
+			Action:    t.Action,
+			Status:    status,
+			Error:     err.Error(),
+		}
+	}
+	// Parked actions are admitted when they are replayed, so they do not use up budget
+	if park && g != nil && !g.KillSwitch.Engaged() && g.Maintenance.Parking() {
+		if err := g.Maintenance.Park(t, event); err != nil {
+			return notRun(StatusFailed, err), nil
+		}
+		return notRun(StatusParked, ErrMaintenance), nil
+	}
+	if g == nil {
+		return Run(ctx, executor, t, event), nil
+	}
+	if g.KillSwitch.Engaged() {
+		return notRun(StatusSkipped, ErrKillSwitchEngaged), nil
+	}
+	// The budget is taken once the action has a slot, so matches that wait for one or
+	// find the queue full do not use it up
+	release, err := g.Concurrency.Acquire(ctx, t, event)
+	if errors.Is(err, ErrConcurrencyQueueFull) {
+		return notRun(StatusSkipped, err), err
+	}
+	if err != nil {
+		return notRun(StatusFailed, err), nil
+	}
+	defer release()
+	if err := g.Admit(trigger.EventNamespace(event.Type())); err != nil {
+		return notRun(StatusSkipped, err), nil
+	}
+	return Run(ctx, executor, t, event), nil
+}
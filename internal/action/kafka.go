@@ -0,0 +1,146 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/trigger"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+// KafkaActionPrefix marks trigger actions that publish the event to a Kafka topic,
+// e.g. "kafka:orders-{{data.region}}"
+const KafkaActionPrefix = "kafka:"
+
+// Kafka secrets; SASL/PLAIN authentication is used when the username secret exists
+const (
+	DefaultKafkaUsernameSecret = "kafka-username"
+	DefaultKafkaPasswordSecret = "kafka-password"
+)
+
+// KafkaExecutorConfig configures Kafka actions
+type KafkaExecutorConfig struct {
+	// Brokers are the bootstrap brokers; without them Kafka actions fail
+	Brokers []string
+	// Key is a template of the message key (default: the event ID)
+	Key string
+	// UsernameSecret and PasswordSecret name the SASL/PLAIN credentials
+	// (default: DefaultKafkaUsernameSecret and DefaultKafkaPasswordSecret)
+	UsernameSecret string
+	PasswordSecret string
+	Publish        PublishConfig
+}
+
+// kafkaWriter writes messages to Kafka; *kafka.Writer implements it
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// KafkaExecutor runs "kafka:<topic>" actions by publishing the event as a structured
+// CloudEvent. Writes wait until every in-sync replica acknowledged the message and are
+// retried with backoff. One writer, created at Init, serves every topic.
+type KafkaExecutor struct {
+	cfg    KafkaExecutorConfig
+	writer kafkaWriter
+}
+
+// NewKafkaExecutor creates a Kafka executor; it must be initialized before use
+func NewKafkaExecutor(cfg KafkaExecutorConfig) *KafkaExecutor {
+	if cfg.UsernameSecret == "" {
+		cfg.UsernameSecret = DefaultKafkaUsernameSecret
+	}
+	if cfg.PasswordSecret == "" {
+		cfg.PasswordSecret = DefaultKafkaPasswordSecret
+	}
+	cfg.Publish = cfg.Publish.withDefaults()
+	return &KafkaExecutor{cfg: cfg}
+}
+
+// Init resolves the credentials and creates the writer. Brokers are connected to on
+// the first write.
+func (e *KafkaExecutor) Init(ctx context.Context, ec *ExecutionContext) error {
+	if len(e.cfg.Brokers) == 0 {
+		return nil
+	}
+
+	transport := &kafka.Transport{}
+	if ec.Secrets != nil {
+		username, err := ec.Secrets.Secret(ctx, e.cfg.UsernameSecret)
+		if err != nil && !errors.Is(err, ErrSecretNotFound) {
+			return fmt.Errorf("failed to resolve Kafka username: %w", err)
+		}
+		if username != "" {
+			password, err := ec.Secrets.Secret(ctx, e.cfg.PasswordSecret)
+			if err != nil {
+				return fmt.Errorf("failed to resolve Kafka password: %w", err)
+			}
+			transport.SASL = plain.Mechanism{Username: username, Password: password}
+		}
+	}
+
+	e.writer = &kafka.Writer{
+		Addr:         kafka.TCP(e.cfg.Brokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		// Retries are ours, so they back off the same way for every broker action
+		MaxAttempts:  1,
+		BatchTimeout: 10 * time.Millisecond,
+		Transport:    transport,
+	}
+	return nil
+}
+
+// Execute publishes the event to the topic of the trigger's action
+func (e *KafkaExecutor) Execute(ctx context.Context, t *trigger.Trigger, event *cloudevents.Event) (string, error) {
+	destination, ok := strings.CutPrefix(t.Action, KafkaActionPrefix)
+	if !ok || destination == "" {
+		return "", fmt.Errorf("action %q is not a Kafka topic", t.Action)
+	}
+	if e.writer == nil {
+		return "", fmt.Errorf("kafka executor not configured with brokers")
+	}
+
+	topic, err := expandAction(destination, event)
+	if err != nil {
+		return "", fmt.Errorf("kafka topic: %w", err)
+	}
+	key := event.ID()
+	if e.cfg.Key != "" {
+		if key, err = expandAction(e.cfg.Key, event); err != nil {
+			return "", fmt.Errorf("kafka key: %w", err)
+		}
+	}
+	value, err := event.MarshalJSON()
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	message := kafka.Message{
+		Topic:   topic,
+		Key:     []byte(key),
+		Value:   value,
+		Headers: []kafka.Header{{Key: "content-type", Value: []byte(cloudEventsContentType)}},
+	}
+	err = publishWithRetry(ctx, e.cfg.Publish, func(ctx context.Context) error {
+		return e.writer.WriteMessages(ctx, message)
+	})
+	if err != nil {
+		return "", fmt.Errorf("kafka topic %s: %w", topic, err)
+	}
+	return fmt.Sprintf("published to kafka topic %s", topic), nil
+}
+
+// Close flushes and closes the writer
+func (e *KafkaExecutor) Close() error {
+	if e.writer == nil {
+		return nil
+	}
+	return e.writer.Close()
+}
@@ -0,0 +1,314 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/trigger"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/nats-io/nats.go"
+)
+
+// Maintenance mode storage
+const (
+	MaintenanceKey       = "maintenance"
+	DefaultParkingStream = "TRIGGERD_PARKED"
+)
+
+// parkingConsumer is the durable consumer every daemon replays parked matches from
+const parkingConsumer = "triggerd-replay"
+
+// parkedAckWait bounds how long a replayed action may run before the match is
+// delivered again
+const parkedAckWait = 5 * time.Minute
+
+// ErrMaintenance is recorded for actions parked while maintenance mode is on
+var ErrMaintenance = errors.New("maintenance mode")
+
+// MaintenanceState is the value of the maintenance flag
+type MaintenanceState struct {
+	Enabled bool      `json:"enabled"`
+	Reason  string    `json:"reason,omitempty"`
+	Since   time.Time `json:"since,omitempty"`
+	// Parked counts the matches waiting to be replayed, set by State
+	Parked uint64 `json:"parked"`
+}
+
+// ParkedMatch is a trigger match whose action was held back in maintenance mode
+type ParkedMatch struct {
+	TriggerID string          `json:"trigger_id"`
+	Event     json.RawMessage `json:"event"`
+	ParkedAt  time.Time       `json:"parked_at"`
+}
+
+// Maintenance is a flag in the control bucket that makes every trigger daemon park
+// matched events in a stream instead of executing their actions. Events are still
+// consumed and matched. When maintenance ends the daemons replay the parked matches
+// one at a time in the order they were parked, and keep parking new matches until
+// the backlog is drained, so no action overtakes an earlier one.
+type Maintenance struct {
+	kv      nats.KeyValue
+	js      nats.JetStreamContext
+	stream  string
+	subject string
+	enabled atomic.Bool
+	// draining is set from the end of maintenance until the parked matches are replayed
+	draining atomic.Bool
+}
+
+// NewMaintenance binds to the control bucket and the parking stream, creating them
+// if they do not exist
+func NewMaintenance(nc *nats.Conn, bucket, stream string) (*Maintenance, error) {
+	if bucket == "" {
+		bucket = DefaultControlBucket
+	}
+	if stream == "" {
+		stream = DefaultParkingStream
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get JetStream context: %w", err)
+	}
+	kv, err := js.KeyValue(bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{Bucket: bucket})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get control bucket: %w", err)
+	}
+
+	// The subject is derived from the stream, so prefixed deployments do not overlap
+	subject := "parked." + stream
+	_, err = js.StreamInfo(stream)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = js.AddStream(&nats.StreamConfig{
+			Name:        stream,
+			Description: "Trigger matches parked in maintenance mode",
+			Subjects:    []string{subject},
+			Storage:     nats.FileStorage,
+			Retention:   nats.WorkQueuePolicy,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get parking stream: %w", err)
+	}
+
+	// One match is replayed at a time across all daemons, in stream order
+	_, err = js.ConsumerInfo(stream, parkingConsumer)
+	if errors.Is(err, nats.ErrConsumerNotFound) {
+		_, err = js.AddConsumer(stream, &nats.ConsumerConfig{
+			Durable:       parkingConsumer,
+			AckPolicy:     nats.AckExplicitPolicy,
+			AckWait:       parkedAckWait,
+			MaxAckPending: 1,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get parking consumer: %w", err)
+	}
+
+	return &Maintenance{kv: kv, js: js, stream: stream, subject: subject}, nil
+}
+
+// Watch loads the current flag and keeps following changes until ctx is cancelled.
+// Matches parked by an earlier maintenance that are not replayed yet are drained first.
+func (m *Maintenance) Watch(ctx context.Context) error {
+	watcher, err := m.kv.Watch(MaintenanceKey, nats.Context(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to watch maintenance mode: %w", err)
+	}
+
+	// The watcher delivers the current value followed by a nil marker
+	for update := range watcher.Updates() {
+		if update == nil {
+			break
+		}
+		m.apply(update)
+	}
+	if !m.enabled.Load() {
+		pending, err := m.pending()
+		if err != nil {
+			watcher.Stop()
+			return err
+		}
+		m.draining.Store(pending > 0)
+	}
+
+	go func() {
+		defer watcher.Stop()
+		for update := range watcher.Updates() {
+			if update != nil {
+				m.apply(update)
+			}
+		}
+	}()
+	return nil
+}
+
+// apply updates the flag from a KV entry, draining the parked matches when it ends
+func (m *Maintenance) apply(entry nats.KeyValueEntry) {
+	var state MaintenanceState
+	enabled := entry.Operation() == nats.KeyValuePut && json.Unmarshal(entry.Value(), &state) == nil && state.Enabled
+	if m.enabled.Swap(enabled) && !enabled {
+		m.draining.Store(true)
+	}
+}
+
+// Enabled reports whether maintenance mode is on. A nil maintenance is never enabled.
+func (m *Maintenance) Enabled() bool {
+	return m != nil && m.enabled.Load()
+}
+
+// Parking reports whether matches are parked: during maintenance and until the
+// matches parked during it are replayed
+func (m *Maintenance) Parking() bool {
+	return m != nil && (m.enabled.Load() || m.draining.Load())
+}
+
+// Enable turns maintenance mode on everywhere
+func (m *Maintenance) Enable(ctx context.Context, reason string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(MaintenanceState{Enabled: true, Reason: reason, Since: time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to marshal maintenance mode: %w", err)
+	}
+	if _, err := m.kv.Put(MaintenanceKey, data); err != nil {
+		return fmt.Errorf("failed to enable maintenance mode: %w", err)
+	}
+	return nil
+}
+
+// Disable turns maintenance mode off, which replays the parked matches
+func (m *Maintenance) Disable(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := m.kv.Delete(MaintenanceKey); err != nil && !errors.Is(err, nats.ErrKeyNotFound) {
+		return fmt.Errorf("failed to disable maintenance mode: %w", err)
+	}
+	return nil
+}
+
+// State reads the maintenance flag from the bucket and counts the parked matches
+func (m *Maintenance) State(ctx context.Context) (MaintenanceState, error) {
+	if err := ctx.Err(); err != nil {
+		return MaintenanceState{}, err
+	}
+	var state MaintenanceState
+	entry, err := m.kv.Get(MaintenanceKey)
+	switch {
+	case errors.Is(err, nats.ErrKeyNotFound):
+	case err != nil:
+		return MaintenanceState{}, fmt.Errorf("failed to get maintenance mode: %w", err)
+	default:
+		if err := json.Unmarshal(entry.Value(), &state); err != nil {
+			return MaintenanceState{}, fmt.Errorf("invalid maintenance mode value: %w", err)
+		}
+	}
+
+	if state.Parked, err = m.pending(); err != nil {
+		return MaintenanceState{}, err
+	}
+	return state, nil
+}
+
+// pending counts the parked matches not replayed yet
+func (m *Maintenance) pending() (uint64, error) {
+	info, err := m.js.ConsumerInfo(m.stream, parkingConsumer)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get parking consumer: %w", err)
+	}
+	return info.NumPending + uint64(info.NumAckPending), nil
+}
+
+// Park holds back the action of a matched trigger until maintenance ends
+func (m *Maintenance) Park(t *trigger.Trigger, event *cloudevents.Event) error {
+	data, err := event.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	match, err := json.Marshal(ParkedMatch{TriggerID: t.ID, Event: data, ParkedAt: time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to marshal parked match: %w", err)
+	}
+	if _, err := m.js.Publish(m.subject, match); err != nil {
+		return fmt.Errorf("failed to park match: %w", err)
+	}
+	return nil
+}
+
+// Replay runs the parked matches with run once maintenance ends, until ctx is
+// cancelled. run is called one match at a time and the match is acknowledged when it
+// returns nil. Matches run fails for, or no daemon acknowledges within five minutes,
+// are delivered again.
+func (m *Maintenance) Replay(ctx context.Context, run func(triggerID string, event *cloudevents.Event) error) error {
+	sub, err := m.js.PullSubscribe(m.subject, parkingConsumer, nats.Bind(m.stream, parkingConsumer))
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to parked matches: %w", err)
+	}
+
+	go func() {
+		defer sub.Unsubscribe()
+		for ctx.Err() == nil {
+			if m.enabled.Load() || !m.draining.Load() {
+				select {
+				case <-ctx.Done():
+				case <-time.After(time.Second):
+				}
+				continue
+			}
+
+			msgs, err := sub.Fetch(1, nats.MaxWait(time.Second))
+			if errors.Is(err, nats.ErrTimeout) {
+				// Other daemons may still be replaying the last matches
+				if pending, err := m.pending(); err == nil && pending == 0 {
+					m.draining.Store(false)
+					log.Printf("Parked matches replayed, leaving maintenance mode")
+				}
+				continue
+			}
+			if err != nil {
+				log.Printf("Error fetching parked matches: %v", err)
+				continue
+			}
+			for _, msg := range msgs {
+				m.replayMatch(msg, run)
+			}
+		}
+	}()
+	return nil
+}
+
+// replayMatch runs and acknowledges one parked match
+func (m *Maintenance) replayMatch(msg *nats.Msg, run func(string, *cloudevents.Event) error) {
+	var match ParkedMatch
+	event := cloudevents.NewEvent()
+	if err := json.Unmarshal(msg.Data, &match); err != nil {
+		log.Printf("Dropping invalid parked match: %v", err)
+		msg.Term()
+		return
+	}
+	if err := json.Unmarshal(match.Event, &event); err != nil {
+		log.Printf("Dropping parked match of trigger %s with an invalid event: %v", match.TriggerID, err)
+		msg.Term()
+		return
+	}
+
+	if err := run(match.TriggerID, &event); err != nil {
+		log.Printf("Error replaying parked match of trigger %s: %v", match.TriggerID, err)
+		msg.NakWithDelay(time.Second)
+		return
+	}
+	if err := msg.Ack(); err != nil {
+		log.Printf("Error acknowledging parked match of trigger %s: %v", match.TriggerID, err)
+	}
+}
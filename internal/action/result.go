@@ -0,0 +1,124 @@
+package action
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/event"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+)
+
+// DefaultResultSubject is the subject action results are published to
+const DefaultResultSubject = "actions.results"
+
+// Action result event types
+const (
+	EventTypeActionSucceeded = "action.succeeded"
+	EventTypeActionFailed    = "action.failed"
+	EventTypeActionSkipped   = "action.skipped"
+	EventTypeActionParked    = "action.parked"
+)
+
+// DepthExtension counts how many actions led to an event.
+// It stops follow-up triggers on action results from looping forever.
+const DepthExtension = "actiondepth"
+
+// DefaultMaxDepth is the maximum action chain length results are published for
+const DefaultMaxDepth = 5
+
+// NewResultEvent builds the CloudEvent describing an action result.
+// The result is carried as data.after so trigger criteria can inspect it,
+// e.g. event.data.after.status == "failed". Results of events a watcher consumed
+// from JetStream name the message in data.after.message.
+func NewResultEvent(result Result, cause *cloudevents.Event) (*cloudevents.Event, error) {
+	if ref, ok := event.MessageRefOf(cause); ok && result.Message == nil {
+		result.Message = &ref
+	}
+	ce := cloudevents.NewEvent()
+	ce.SetID(uuid.NewString())
+	ce.SetSource(fmt.Sprintf("mycelium/triggers/%s", result.TriggerID))
+	ce.SetSubject(result.EventID)
+	ce.SetTime(time.Now())
+	switch result.Status {
+	case StatusFailed:
+		ce.SetType(EventTypeActionFailed)
+	case StatusSkipped:
+		ce.SetType(EventTypeActionSkipped)
+	case StatusParked:
+		ce.SetType(EventTypeActionParked)
+	default:
+		ce.SetType(EventTypeActionSucceeded)
+	}
+	ce.SetExtension(DepthExtension, eventDepth(cause)+1)
+	event.SetLineage(&ce, event.ProducerTrigger+result.TriggerID, cause)
+
+	if err := ce.SetData(cloudevents.ApplicationJSON, map[string]interface{}{
+		"after": result,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to set result data: %w", err)
+	}
+	return &ce, nil
+}
+
+// eventDepth returns the action depth recorded on an event
+func eventDepth(event *cloudevents.Event) int {
+	if event == nil {
+		return 0
+	}
+	switch v := event.Extensions()[DepthExtension].(type) {
+	case int32:
+		return int(v)
+	case int:
+		return v
+	case string:
+		var depth int
+		fmt.Sscanf(v, "%d", &depth)
+		return depth
+	}
+	return 0
+}
+
+// ResultPublisher publishes action results back into the event stream
+type ResultPublisher struct {
+	nc       *nats.Conn
+	subject  string
+	maxDepth int
+}
+
+// NewResultPublisher creates a publisher for action results
+func NewResultPublisher(nc *nats.Conn, subject string) *ResultPublisher {
+	if subject == "" {
+		subject = DefaultResultSubject
+	}
+	return &ResultPublisher{
+		nc:       nc,
+		subject:  subject,
+		maxDepth: DefaultMaxDepth,
+	}
+}
+
+// Publish publishes the result of an action triggered by cause.
+// Results of action chains deeper than the maximum depth are dropped.
+func (p *ResultPublisher) Publish(result Result, cause *cloudevents.Event) error {
+	if eventDepth(cause) >= p.maxDepth {
+		return fmt.Errorf("action chain for event %s exceeds max depth %d", result.EventID, p.maxDepth)
+	}
+
+	ce, err := NewResultEvent(result, cause)
+	if err != nil {
+		return err
+	}
+
+	data, err := ce.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("failed to marshal result event: %w", err)
+	}
+
+	if err := p.nc.Publish(p.subject, data); err != nil {
+		return fmt.Errorf("failed to publish result event: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package action
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sync/atomic"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/event"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultSLASubject is the subject SLA breach events are published to
+const DefaultSLASubject = "actions.sla"
+
+// EventTypeSLABreach is the type of the events reporting an SLA breach
+const EventTypeSLABreach = "sla.breach"
+
+// SLARule sets the latency target of matching event types
+type SLARule struct {
+	// EventTypes are glob patterns of the event types the rule applies to, empty applies to all
+	EventTypes []string `yaml:"event_types,omitempty" json:"event_types,omitempty"`
+	// Target is the longest an action may complete after the event's time, e.g. 30s
+	Target time.Duration `yaml:"target" json:"target"`
+}
+
+// SLAPolicy holds the latency targets of event types; the first matching rule applies
+type SLAPolicy struct {
+	Rules []SLARule `yaml:"rules" json:"rules"`
+}
+
+// LoadSLAPolicy reads an SLA policy from a YAML file
+func LoadSLAPolicy(file string) (*SLAPolicy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SLA policy: %w", err)
+	}
+
+	var policy SLAPolicy
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse SLA policy: %w", err)
+	}
+	for i, rule := range policy.Rules {
+		for _, pattern := range rule.EventTypes {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("rule %d: invalid event type pattern %q: %w", i, pattern, err)
+			}
+		}
+		if rule.Target <= 0 {
+			return nil, fmt.Errorf("rule %d: target must be positive", i)
+		}
+	}
+	return &policy, nil
+}
+
+// Target returns the latency target of an event type; ok is false when no rule applies
+func (p *SLAPolicy) Target(eventType string) (target time.Duration, ok bool) {
+	if p == nil {
+		return 0, false
+	}
+	for _, rule := range p.Rules {
+		if len(rule.EventTypes) == 0 {
+			return rule.Target, true
+		}
+		for _, pattern := range rule.EventTypes {
+			if matched, _ := path.Match(pattern, eventType); matched {
+				return rule.Target, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// SLAMetricsCollector receives the end-to-end latency of every action an SLA applies
+// to, e.g. to export it as a histogram. Methods must not block.
+type SLAMetricsCollector interface {
+	RecordSLALatency(eventType, action string, latency, target time.Duration, breached bool)
+}
+
+// SLABreach describes an action that completed later than its event type's target
+type SLABreach struct {
+	TriggerID string `json:"trigger_id"`
+	EventID   string `json:"event_id"`
+	EventType string `json:"event_type"`
+	Action    string `json:"action"`
+	Status    string `json:"status"`
+	// EventTime is the time of the event, CompletedAt when its action completed
+	EventTime   time.Time `json:"event_time"`
+	CompletedAt time.Time `json:"completed_at"`
+	LatencyMs   int64     `json:"latency_ms"`
+	TargetMs    int64     `json:"target_ms"`
+	// Message is the JetStream message the event was consumed from
+	Message *event.MessageRef `json:"message,omitempty"`
+}
+
+// SLAStats counts the actions an SLA applied to since startup
+type SLAStats struct {
+	Observed uint64 `json:"observed"`
+	Breached uint64 `json:"breached"`
+}
+
+// SLATrackerConfig configures an SLA tracker
+type SLATrackerConfig struct {
+	// Subject is the subject breach events are published to (default: DefaultSLASubject)
+	Subject string
+	// Metrics receives the latency of every tracked action (optional)
+	Metrics SLAMetricsCollector
+}
+
+// SLATracker measures the latency from an event's time to the completion of its
+// action, compares it against the event type's target and publishes sla.breach events
+// into the event stream when it is exceeded, so ordinary triggers alert on a slow
+// pipeline rather than only on unhealthy components, e.g.
+// event.event_type == "sla.breach" && event.data.after.latency_ms > 60000.
+type SLATracker struct {
+	nc       *nats.Conn
+	policy   *SLAPolicy
+	config   SLATrackerConfig
+	maxDepth int
+	now      func() time.Time
+	observed atomic.Uint64
+	breached atomic.Uint64
+}
+
+// NewSLATracker creates an SLA tracker for a policy
+func NewSLATracker(nc *nats.Conn, policy *SLAPolicy, config SLATrackerConfig) *SLATracker {
+	if config.Subject == "" {
+		config.Subject = DefaultSLASubject
+	}
+	return &SLATracker{
+		nc:       nc,
+		policy:   policy,
+		config:   config,
+		maxDepth: DefaultMaxDepth,
+		now:      time.Now,
+	}
+}
+
+// Observe checks the latency of an action's result against the SLA of its event's
+// type. Only completed actions are tracked: skipped ones did not run and parked ones
+// are observed when they are replayed. Events without a time or SLA are ignored.
+func (s *SLATracker) Observe(result Result, cause *cloudevents.Event) error {
+	if result.Status != StatusSucceeded && result.Status != StatusFailed {
+		return nil
+	}
+	target, ok := s.policy.Target(cause.Type())
+	if !ok || cause.Time().IsZero() {
+		return nil
+	}
+
+	completed := s.now()
+	latency := completed.Sub(cause.Time())
+	breached := latency > target
+	s.observed.Add(1)
+	if s.config.Metrics != nil {
+		s.config.Metrics.RecordSLALatency(cause.Type(), result.Action, latency, target, breached)
+	}
+	if !breached {
+		return nil
+	}
+	s.breached.Add(1)
+
+	// Breaches of actions alerting on breaches must not loop forever
+	if eventDepth(cause) >= s.maxDepth {
+		return fmt.Errorf("action chain for event %s exceeds max depth %d", result.EventID, s.maxDepth)
+	}
+	breach := SLABreach{
+		TriggerID:   result.TriggerID,
+		EventID:     result.EventID,
+		EventType:   cause.Type(),
+		Action:      result.Action,
+		Status:      result.Status,
+		EventTime:   cause.Time(),
+		CompletedAt: completed,
+		LatencyMs:   latency.Milliseconds(),
+		TargetMs:    target.Milliseconds(),
+	}
+	if ref, ok := event.MessageRefOf(cause); ok {
+		breach.Message = &ref
+	}
+	ce, err := NewSLABreachEvent(breach, cause)
+	if err != nil {
+		return err
+	}
+	data, err := ce.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("failed to marshal SLA breach event: %w", err)
+	}
+	if err := s.nc.Publish(s.config.Subject, data); err != nil {
+		return fmt.Errorf("failed to publish SLA breach event: %w", err)
+	}
+	return nil
+}
+
+// Stats returns the actions observed and breached since startup
+func (s *SLATracker) Stats() SLAStats {
+	return SLAStats{Observed: s.observed.Load(), Breached: s.breached.Load()}
+}
+
+// NewSLABreachEvent builds the CloudEvent reporting an SLA breach. The breach is
+// carried as data.after like action results, so trigger criteria can inspect it.
+func NewSLABreachEvent(breach SLABreach, cause *cloudevents.Event) (*cloudevents.Event, error) {
+	ce := cloudevents.NewEvent()
+	ce.SetID(uuid.NewString())
+	ce.SetSource(fmt.Sprintf("mycelium/triggers/%s", breach.TriggerID))
+	ce.SetSubject(breach.EventID)
+	ce.SetType(EventTypeSLABreach)
+	ce.SetTime(breach.CompletedAt)
+	ce.SetExtension(DepthExtension, eventDepth(cause)+1)
+	event.SetLineage(&ce, event.ProducerTrigger+breach.TriggerID, cause)
+
+	if err := ce.SetData(cloudevents.ApplicationJSON, map[string]interface{}{
+		"after": breach,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to set SLA breach data: %w", err)
+	}
+	return &ce, nil
+}
@@ -0,0 +1,115 @@
+package action
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/trigger"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// WebhookActionPrefix marks trigger actions that post the event to a URL, e.g. "webhook:https://hooks.example.com/deploy"
+const WebhookActionPrefix = "webhook:"
+
+// Webhook defaults
+const (
+	DefaultWebhookTokenSecret = "webhook-token"
+	DefaultWebhookTimeout     = 10 * time.Second
+)
+
+// WebhookExecutorConfig configures webhook actions
+type WebhookExecutorConfig struct {
+	// TokenSecret names the secret sent as a bearer token (default: DefaultWebhookTokenSecret);
+	// requests are unauthenticated when the secret does not exist
+	TokenSecret string
+	// Timeout bounds each request (default: DefaultWebhookTimeout)
+	Timeout time.Duration
+}
+
+// WebhookExecutor runs "webhook:<url>" actions by posting the event as a structured
+// CloudEvent. Its HTTP client and token come from the execution context, so every
+// action shares one connection pool.
+type WebhookExecutor struct {
+	cfg    WebhookExecutorConfig
+	client *http.Client
+	token  string
+}
+
+// NewWebhookExecutor creates a webhook executor; it must be initialized before use
+func NewWebhookExecutor(cfg WebhookExecutorConfig) *WebhookExecutor {
+	if cfg.TokenSecret == "" {
+		cfg.TokenSecret = DefaultWebhookTokenSecret
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultWebhookTimeout
+	}
+	return &WebhookExecutor{cfg: cfg}
+}
+
+// Init takes the shared HTTP client and resolves the bearer token
+func (e *WebhookExecutor) Init(ctx context.Context, ec *ExecutionContext) error {
+	if ec.Resources == nil {
+		return fmt.Errorf("webhook executor needs shared resources")
+	}
+	e.client = ec.Resources.HTTP
+
+	if ec.Secrets == nil {
+		return nil
+	}
+	token, err := ec.Secrets.Secret(ctx, e.cfg.TokenSecret)
+	if err != nil && !errors.Is(err, ErrSecretNotFound) {
+		return fmt.Errorf("failed to resolve webhook token: %w", err)
+	}
+	e.token = token
+	return nil
+}
+
+// Execute posts the event to the URL of the trigger's action
+func (e *WebhookExecutor) Execute(ctx context.Context, t *trigger.Trigger, event *cloudevents.Event) (string, error) {
+	url, ok := strings.CutPrefix(t.Action, WebhookActionPrefix)
+	if !ok || url == "" {
+		return "", fmt.Errorf("action %q is not a webhook", t.Action)
+	}
+	if e.client == nil {
+		return "", fmt.Errorf("webhook executor not initialized")
+	}
+
+	body, err := event.MarshalJSON()
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("invalid webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+	if e.token != "" {
+		req.Header.Set("Authorization", "Bearer "+e.token)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("webhook %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	// Drain the body so the connection goes back to the pool
+	output, _ := io.ReadAll(io.LimitReader(resp.Body, maxOutputSummary))
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return string(output), fmt.Errorf("webhook %s returned %s", url, resp.Status)
+	}
+	return fmt.Sprintf("webhook %s returned %s", url, resp.Status), nil
+}
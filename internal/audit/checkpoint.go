@@ -0,0 +1,192 @@
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// DefaultCheckpointInterval is how often services holding a signing key seal the trail
+const DefaultCheckpointInterval = 5 * time.Minute
+
+// Checkpoint seals the trail up to a record with a signature over its hash. Records up
+// to the checkpoint cannot be changed or removed without Verify noticing, even by
+// someone able to rewrite the bucket, unless they also hold the signing key.
+type Checkpoint struct {
+	Seq  uint64    `json:"seq"`
+	Hash string    `json:"hash"`
+	Time time.Time `json:"time"`
+	// KeyID identifies the public key verifying the signature, see KeyID
+	KeyID     string `json:"key_id"`
+	Signature string `json:"signature"`
+}
+
+// signedMessage returns the bytes a checkpoint signature covers
+func (c Checkpoint) signedMessage() []byte {
+	return []byte(fmt.Sprintf("mycelium-audit-checkpoint\n%d\n%s\n%s", c.Seq, c.Hash, c.Time.UTC().Format(time.RFC3339Nano)))
+}
+
+// KeyID returns the ID of a public key: the first 8 bytes of its SHA-256, in hex
+func KeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// GenerateKey creates a checkpoint signing key and returns it and its public key as PEM
+func GenerateKey() (private []byte, public []byte, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal public key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), nil
+}
+
+// LoadSigningKey reads an Ed25519 private key from a PEM file in PKCS #8 form, as
+// written by GenerateKey or openssl genpkey -algorithm ed25519
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s is not PEM encoded", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+	signer, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key %s is not an Ed25519 key", path)
+	}
+	return signer, nil
+}
+
+// LoadPublicKeys reads the Ed25519 public keys of a PEM file; a file may hold several
+// keys, e.g. the current and the retired ones after a key rotation
+func LoadPublicKeys(path string) ([]ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public keys: %w", err)
+	}
+	var keys []ed25519.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key: %w", err)
+		}
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("public key in %s is not an Ed25519 key", path)
+		}
+		keys = append(keys, pub)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no public keys in %s", path)
+	}
+	return keys, nil
+}
+
+// Checkpoint seals the trail up to its last record. It returns nil when the last
+// record is sealed already or the trail is empty.
+func (t *Trail) Checkpoint(ctx context.Context) (*Checkpoint, error) {
+	t.mu.Lock()
+	signer := t.signer
+	t.mu.Unlock()
+	if signer == nil {
+		return nil, fmt.Errorf("audit trail has no signing key")
+	}
+
+	seq, hash, err := t.Head(ctx)
+	if err != nil || seq == 0 {
+		return nil, err
+	}
+	c := Checkpoint{
+		Seq:   seq,
+		Hash:  hash,
+		Time:  time.Now().UTC(),
+		KeyID: KeyID(signer.Public().(ed25519.PublicKey)),
+	}
+	c.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(signer, c.signedMessage()))
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal checkpoint: %w", err)
+	}
+
+	// Another signer sealed the record first
+	if _, err := t.kv.Create(checkpointKey(seq), data); errors.Is(err, nats.ErrKeyExists) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to store checkpoint: %w", err)
+	}
+	return &c, nil
+}
+
+// Checkpoints returns the checkpoints of the trail, oldest first
+func (t *Trail) Checkpoints(ctx context.Context) ([]Checkpoint, error) {
+	seqs, err := t.sequences(ctx, checkpointPrefix)
+	if err != nil {
+		return nil, err
+	}
+	checkpoints := make([]Checkpoint, 0, len(seqs))
+	for _, seq := range seqs {
+		entry, err := t.kv.Get(checkpointKey(seq))
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get checkpoint %d: %w", seq, err)
+		}
+		var c Checkpoint
+		if err := json.Unmarshal(entry.Value(), &c); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal checkpoint %d: %w", seq, err)
+		}
+		checkpoints = append(checkpoints, c)
+	}
+	return checkpoints, nil
+}
+
+// RunCheckpoints seals the trail at an interval until stopped
+func (t *Trail) RunCheckpoints(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := t.Checkpoint(context.Background()); err != nil {
+				log.Printf("Failed to checkpoint audit trail: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,282 @@
+// Package audit keeps a tamper-evident trail of trigger matches, admin actions and
+// registry changes. Records are chained by hashes and sealed by signed checkpoints, so
+// modifying, removing or reordering records is detected by Verify.
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// DefaultBucket is the KV bucket the audit trail is kept in
+const DefaultBucket = "audit-trail"
+
+// Kinds of audit records
+const (
+	KindTriggerMatch = "trigger.match"
+	KindAdmin        = "admin"
+	KindRegistry     = "registry"
+)
+
+// Keys of the trail's bucket
+const (
+	recordPrefix     = "record."
+	checkpointPrefix = "checkpoint."
+	headKey          = "head"
+)
+
+// maxAppendAttempts bounds the retries of an append racing other writers
+const maxAppendAttempts = 10
+
+// Record is an entry of the audit trail
+type Record struct {
+	// Seq numbers the records of the trail from 1 without gaps
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+	// Actor is the principal or instance that caused the record
+	Actor string `json:"actor,omitempty"`
+	// Action is what happened, e.g. triggers.put or the action of a matched trigger
+	Action string `json:"action"`
+	// Resource is the trigger or function concerned
+	Resource string            `json:"resource,omitempty"`
+	Details  map[string]string `json:"details,omitempty"`
+	// PrevHash is the hash of the previous record, empty for the first
+	PrevHash string `json:"prev_hash"`
+	// Hash is the SHA-256 of the record with an empty Hash
+	Hash string `json:"hash"`
+}
+
+// computeHash returns the hash of a record, which covers every field but Hash
+func computeHash(r Record) (string, error) {
+	r.Hash = ""
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal audit record: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// head is the last record of the trail
+type head struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// Trail is a hash-chained audit trail in a KV bucket. Several processes may append
+// to one trail; records are created with optimistic concurrency, so they never fork.
+type Trail struct {
+	kv nats.KeyValue
+	// signer signs checkpoints (optional)
+	signer ed25519.PrivateKey
+	mu     sync.Mutex
+}
+
+// Open binds to the audit trail in a bucket, creating the bucket if needed
+func Open(nc *nats.Conn, bucket string) (*Trail, error) {
+	if bucket == "" {
+		bucket = DefaultBucket
+	}
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get JetStream context: %w", err)
+	}
+	kv, err := js.KeyValue(bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket:      bucket,
+			Description: "Tamper-evident audit trail",
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit trail bucket: %w", err)
+	}
+	return &Trail{kv: kv}, nil
+}
+
+// SetSigningKey sets the key checkpoints are signed with
+func (t *Trail) SetSigningKey(key ed25519.PrivateKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.signer = key
+}
+
+// recordKey returns the key of a record; zero-padded so keys sort by sequence
+func recordKey(seq uint64) string {
+	return fmt.Sprintf("%s%020d", recordPrefix, seq)
+}
+
+// checkpointKey returns the key of the checkpoint sealing a record
+func checkpointKey(seq uint64) string {
+	return fmt.Sprintf("%s%020d", checkpointPrefix, seq)
+}
+
+// getRecord reads a record, nil if there is none
+func (t *Trail) getRecord(seq uint64) (*Record, error) {
+	entry, err := t.kv.Get(recordKey(seq))
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit record %d: %w", seq, err)
+	}
+	var r Record
+	if err := json.Unmarshal(entry.Value(), &r); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal audit record %d: %w", seq, err)
+	}
+	return &r, nil
+}
+
+// head returns the last record of the trail and the revision of the head key. The head
+// key is a hint that may lag behind appends, so records after it are followed.
+func (t *Trail) head() (head, uint64, error) {
+	var h head
+	var revision uint64
+	entry, err := t.kv.Get(headKey)
+	switch {
+	case errors.Is(err, nats.ErrKeyNotFound):
+	case err != nil:
+		return h, 0, fmt.Errorf("failed to get audit trail head: %w", err)
+	default:
+		if err := json.Unmarshal(entry.Value(), &h); err != nil {
+			return h, 0, fmt.Errorf("failed to unmarshal audit trail head: %w", err)
+		}
+		revision = entry.Revision()
+	}
+
+	for {
+		next, err := t.getRecord(h.Seq + 1)
+		if err != nil {
+			return h, 0, err
+		}
+		if next == nil {
+			return h, revision, nil
+		}
+		h = head{Seq: next.Seq, Hash: next.Hash}
+	}
+}
+
+// Head returns the sequence and hash of the last record, 0 for an empty trail
+func (t *Trail) Head(ctx context.Context) (uint64, string, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, "", err
+	}
+	h, _, err := t.head()
+	return h.Seq, h.Hash, err
+}
+
+// Append chains a record to the trail and returns it with its sequence and hashes
+func (t *Trail) Append(ctx context.Context, r Record) (Record, error) {
+	if r.Kind == "" || r.Action == "" {
+		return Record{}, fmt.Errorf("audit record requires a kind and an action")
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	r.Time = r.Time.UTC()
+
+	for attempt := 0; attempt < maxAppendAttempts; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return Record{}, err
+		}
+		h, revision, err := t.head()
+		if err != nil {
+			return Record{}, err
+		}
+		r.Seq, r.PrevHash = h.Seq+1, h.Hash
+		if r.Hash, err = computeHash(r); err != nil {
+			return Record{}, err
+		}
+		data, err := json.Marshal(r)
+		if err != nil {
+			return Record{}, fmt.Errorf("failed to marshal audit record: %w", err)
+		}
+
+		// Another writer took the sequence; chain to its record instead
+		if _, err := t.kv.Create(recordKey(r.Seq), data); errors.Is(err, nats.ErrKeyExists) {
+			continue
+		} else if err != nil {
+			return Record{}, fmt.Errorf("failed to append audit record: %w", err)
+		}
+		t.advanceHead(head{Seq: r.Seq, Hash: r.Hash}, revision)
+		return r, nil
+	}
+	return Record{}, fmt.Errorf("failed to append audit record: too many concurrent appends")
+}
+
+// advanceHead moves the head hint to a record unless another writer moved it first
+func (t *Trail) advanceHead(h head, revision uint64) {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return
+	}
+	if revision == 0 {
+		t.kv.Create(headKey, data)
+		return
+	}
+	t.kv.Update(headKey, data, revision)
+}
+
+// sequences returns the sequences of the keys with a prefix, ascending
+func (t *Trail) sequences(ctx context.Context, prefix string) ([]uint64, error) {
+	keys, err := t.kv.Keys(nats.Context(ctx))
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit trail: %w", err)
+	}
+	var seqs []uint64
+	for _, key := range keys {
+		suffix, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		seq, err := strconv.ParseUint(suffix, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid audit trail key %s", key)
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs, nil
+}
+
+// Records returns the records of the trail from a sequence on, oldest first; limit
+// keeps only the newest records when positive
+func (t *Trail) Records(ctx context.Context, from uint64, limit int) ([]Record, error) {
+	seqs, err := t.sequences(ctx, recordPrefix)
+	if err != nil {
+		return nil, err
+	}
+	start := sort.Search(len(seqs), func(i int) bool { return seqs[i] >= from })
+	seqs = seqs[start:]
+	if limit > 0 && len(seqs) > limit {
+		seqs = seqs[len(seqs)-limit:]
+	}
+
+	records := make([]Record, 0, len(seqs))
+	for _, seq := range seqs {
+		r, err := t.getRecord(seq)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil {
+			records = append(records, *r)
+		}
+	}
+	return records, nil
+}
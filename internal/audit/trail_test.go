@@ -0,0 +1,149 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openTestTrail opens an empty trail with a signing key and returns it with its public keys
+func openTestTrail(t *testing.T, nc *nats.Conn, bucket string) (*Trail, string) {
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	js.DeleteKeyValue(bucket)
+	t.Cleanup(func() { js.DeleteKeyValue(bucket) })
+
+	trail, err := Open(nc, bucket)
+	if err != nil {
+		t.Skip("JetStream not available, skipping integration test")
+	}
+
+	private, public, err := GenerateKey()
+	require.NoError(t, err)
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "audit.key"), private, 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "audit.pub"), public, 0644))
+	signer, err := LoadSigningKey(filepath.Join(dir, "audit.key"))
+	require.NoError(t, err)
+	trail.SetSigningKey(signer)
+	return trail, filepath.Join(dir, "audit.pub")
+}
+
+// TestTrail tests chaining concurrent appends, checkpoints and the detection of tampering
+func TestTrail(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	ctx := context.Background()
+	trail, publicKeys := openTestTrail(t, nc, "test-audit-trail")
+	keys, err := LoadPublicKeys(publicKeys)
+	require.NoError(t, err)
+
+	// Writers in several processes share the trail without forking it
+	other, err := Open(nc, "test-audit-trail")
+	require.NoError(t, err)
+	var wg sync.WaitGroup
+	for i, writer := range []*Trail{trail, other} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				_, err := writer.Append(ctx, Record{Kind: KindAdmin, Actor: fmt.Sprintf("writer-%d", i), Action: "triggers.put", Resource: fmt.Sprintf("trigger-%d", j)})
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	records, err := trail.Records(ctx, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, records, 10)
+	for i, r := range records {
+		assert.Equal(t, uint64(i+1), r.Seq)
+		if i > 0 {
+			assert.Equal(t, records[i-1].Hash, r.PrevHash)
+		}
+	}
+	_, err = trail.Append(ctx, Record{Kind: KindAdmin})
+	assert.Error(t, err)
+
+	checkpoint, err := trail.Checkpoint(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, checkpoint)
+	assert.Equal(t, uint64(10), checkpoint.Seq)
+	checkpoint, err = trail.Checkpoint(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, checkpoint, "sealed trails need no new checkpoint")
+	_, err = other.Checkpoint(ctx)
+	assert.Error(t, err, "checkpoints require a signing key")
+
+	_, err = trail.Append(ctx, Record{Kind: KindTriggerMatch, Actor: "triggerd-1", Action: "function:notify", Resource: "orders", Details: map[string]string{"event_id": "e1"}})
+	require.NoError(t, err)
+
+	report, err := trail.Verify(ctx, keys)
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%+v", report.Problems)
+	assert.Equal(t, 11, report.Records)
+	assert.Equal(t, uint64(10), report.LastCheckpoint)
+	assert.Equal(t, 1, report.Unsealed)
+
+	// Checkpoints by unknown keys are not trusted
+	_, otherPublic, err := GenerateKey()
+	require.NoError(t, err)
+	otherKeys := filepath.Join(t.TempDir(), "other.pub")
+	require.NoError(t, os.WriteFile(otherKeys, otherPublic, 0644))
+	unknown, err := LoadPublicKeys(otherKeys)
+	require.NoError(t, err)
+	report, err = trail.Verify(ctx, unknown)
+	require.NoError(t, err)
+	require.Len(t, report.Problems, 1)
+	assert.Equal(t, ProblemBadSignature, report.Problems[0].Problem)
+
+	// Editing a record, even with a recomputed hash, breaks the chain
+	kv := trail.kv
+	edited := records[3]
+	edited.Actor = "someone-else"
+	data, _ := json.Marshal(edited)
+	_, err = kv.Put(recordKey(edited.Seq), data)
+	require.NoError(t, err)
+	report, err = trail.Verify(ctx, keys)
+	require.NoError(t, err)
+	assert.Equal(t, []Problem{{Seq: 4, Problem: ProblemModified}}, report.Problems)
+
+	edited.Hash, err = computeHash(edited)
+	require.NoError(t, err)
+	data, _ = json.Marshal(edited)
+	_, err = kv.Put(recordKey(edited.Seq), data)
+	require.NoError(t, err)
+	report, err = trail.Verify(ctx, keys)
+	require.NoError(t, err)
+	require.Len(t, report.Problems, 1)
+	assert.Equal(t, ProblemBrokenChain, report.Problems[0].Problem)
+	assert.Equal(t, uint64(5), report.Problems[0].Seq)
+
+	// Removing records leaves gaps, and removing sealed records from the end is caught
+	// by the checkpoint
+	require.NoError(t, kv.Delete(recordKey(6)))
+	require.NoError(t, kv.Delete(recordKey(11)))
+	require.NoError(t, kv.Delete(recordKey(10)))
+	report, err = trail.Verify(ctx, keys)
+	require.NoError(t, err)
+	var problems []string
+	for _, p := range report.Problems {
+		problems = append(problems, fmt.Sprintf("%d:%s", p.Seq, p.Problem))
+	}
+	assert.ElementsMatch(t, []string{"5:broken_chain", "6:missing", "10:truncated"}, problems)
+}
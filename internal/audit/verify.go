@@ -0,0 +1,124 @@
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+)
+
+// Problems found by Verify
+const (
+	ProblemMissing      = "missing"       // A record is absent from the sequence
+	ProblemModified     = "modified"      // A record does not hash to its hash
+	ProblemBrokenChain  = "broken_chain"  // A record does not link to the hash of its predecessor
+	ProblemBadSignature = "bad_signature" // A checkpoint signature is invalid or by an unknown key
+	ProblemMismatch     = "mismatch"      // A checkpoint does not seal the record at its sequence
+	ProblemTruncated    = "truncated"     // A checkpoint seals a record past the end of the trail
+)
+
+// Problem is a record or checkpoint that failed verification
+type Problem struct {
+	Seq        uint64 `json:"seq"`
+	Checkpoint bool   `json:"checkpoint,omitempty"`
+	Problem    string `json:"problem"`
+	Detail     string `json:"detail,omitempty"`
+}
+
+// VerifyReport is the result of verifying a trail
+type VerifyReport struct {
+	Records     int    `json:"records"`
+	Checkpoints int    `json:"checkpoints"`
+	LastSeq     uint64 `json:"last_seq"`
+	// LastCheckpoint is the sequence sealed by the last valid checkpoint
+	LastCheckpoint uint64 `json:"last_checkpoint"`
+	// Unsealed counts the records after the last valid checkpoint; removing them from the
+	// end of the trail goes unnoticed until a checkpoint seals them
+	Unsealed int       `json:"unsealed"`
+	Problems []Problem `json:"problems,omitempty"`
+}
+
+// OK reports whether the trail passed verification
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Verify recomputes the hash chain of the trail and checks its checkpoints against
+// the public keys of their signers
+func (t *Trail) Verify(ctx context.Context, keys []ed25519.PublicKey) (*VerifyReport, error) {
+	records, err := t.Records(ctx, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	checkpoints, err := t.Checkpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &VerifyReport{Records: len(records), Checkpoints: len(checkpoints)}
+	hashes := make(map[uint64]string, len(records))
+	var prev *Record
+	for i := range records {
+		r := &records[i]
+		expected := uint64(1)
+		if prev != nil {
+			expected = prev.Seq + 1
+		}
+		for seq := expected; seq < r.Seq; seq++ {
+			report.Problems = append(report.Problems, Problem{Seq: seq, Problem: ProblemMissing})
+		}
+
+		if hash, err := computeHash(*r); err != nil || hash != r.Hash {
+			report.Problems = append(report.Problems, Problem{Seq: r.Seq, Problem: ProblemModified})
+		}
+		// Links across missing records are reported as missing only
+		if prev != nil && prev.Seq+1 == r.Seq && r.PrevHash != prev.Hash {
+			report.Problems = append(report.Problems, Problem{Seq: r.Seq, Problem: ProblemBrokenChain,
+				Detail: fmt.Sprintf("links to %.12s, record %d hashes to %.12s", r.PrevHash, prev.Seq, prev.Hash)})
+		}
+		if prev == nil && r.Seq == 1 && r.PrevHash != "" {
+			report.Problems = append(report.Problems, Problem{Seq: r.Seq, Problem: ProblemBrokenChain, Detail: "first record links to a predecessor"})
+		}
+		hashes[r.Seq] = r.Hash
+		report.LastSeq = r.Seq
+		prev = r
+	}
+
+	byID := make(map[string]ed25519.PublicKey, len(keys))
+	for _, key := range keys {
+		byID[KeyID(key)] = key
+	}
+	for _, c := range checkpoints {
+		key, ok := byID[c.KeyID]
+		signature, err := base64.StdEncoding.DecodeString(c.Signature)
+		switch {
+		case !ok:
+			report.Problems = append(report.Problems, Problem{Seq: c.Seq, Checkpoint: true, Problem: ProblemBadSignature,
+				Detail: fmt.Sprintf("unknown signing key %s", c.KeyID)})
+			continue
+		case err != nil || !ed25519.Verify(key, c.signedMessage(), signature):
+			report.Problems = append(report.Problems, Problem{Seq: c.Seq, Checkpoint: true, Problem: ProblemBadSignature})
+			continue
+		}
+
+		hash, exists := hashes[c.Seq]
+		switch {
+		case c.Seq > report.LastSeq:
+			report.Problems = append(report.Problems, Problem{Seq: c.Seq, Checkpoint: true, Problem: ProblemTruncated,
+				Detail: fmt.Sprintf("trail ends at record %d", report.LastSeq)})
+		case !exists:
+			// Reported as a missing record
+		case hash != c.Hash:
+			report.Problems = append(report.Problems, Problem{Seq: c.Seq, Checkpoint: true, Problem: ProblemMismatch})
+		default:
+			report.LastCheckpoint = max(report.LastCheckpoint, c.Seq)
+		}
+	}
+
+	for _, r := range records {
+		if r.Seq > report.LastCheckpoint {
+			report.Unsealed++
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,113 @@
+package controlplane
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// DefaultAuditBucket is the KV bucket control plane changes are recorded in
+const DefaultAuditBucket = "controlplane-audit"
+
+// Audit outcomes
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+	OutcomeDenied    = "denied"
+)
+
+// AuditEntry records a change made, or refused, through the control plane
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// Actor is the principal that made the request, "anonymous" without RBAC
+	Actor string `json:"actor"`
+	// Action is the endpoint, e.g. triggers.put
+	Action string `json:"action"`
+	// Resource is the ID of the trigger or name of the function changed
+	Resource string `json:"resource,omitempty"`
+	Outcome  string `json:"outcome"`
+	Error    string `json:"error,omitempty"`
+}
+
+// auditLog keeps audit entries in a KV bucket, keyed by time so they list in order
+type auditLog struct {
+	kv nats.KeyValue
+}
+
+// newAuditLog binds to the audit bucket, creating it if needed
+func newAuditLog(nc *nats.Conn, bucket string) (*auditLog, error) {
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get JetStream context: %w", err)
+	}
+	kv, err := js.KeyValue(bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket:      bucket,
+			Description: "Audit log of the control plane",
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit bucket: %w", err)
+	}
+	return &auditLog{kv: kv}, nil
+}
+
+// record appends an entry
+func (a *auditLog) record(entry AuditEntry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+	key := fmt.Sprintf("%019d", entry.Time.UnixNano())
+	if _, err := a.kv.Create(key, data); errors.Is(err, nats.ErrKeyExists) {
+		// Two entries in the same nanosecond; keep both
+		_, err = a.kv.Create(key+"-1", data)
+		if err != nil {
+			return fmt.Errorf("failed to record audit entry: %w", err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	return nil
+}
+
+// list returns the entries about a resource, or all entries when resource is empty,
+// oldest first; limit keeps only the newest entries when positive
+func (a *auditLog) list(ctx context.Context, resource string, limit int) ([]AuditEntry, error) {
+	keys, err := a.kv.Keys(nats.Context(ctx))
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit entries: %w", err)
+	}
+	sort.Strings(keys)
+
+	var entries []AuditEntry
+	for _, key := range keys {
+		value, err := a.kv.Get(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get audit entry %s: %w", key, err)
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(value.Value(), &entry); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal audit entry %s: %w", key, err)
+		}
+		if resource == "" || entry.Resource == resource {
+			entries = append(entries, entry)
+		}
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	return entries, nil
+}
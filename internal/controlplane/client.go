@@ -0,0 +1,160 @@
+package controlplane
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/julianshen/mycelium/internal/function"
+	"github.com/julianshen/mycelium/internal/trigger"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// Error is an error answered by the control plane
+type Error struct {
+	// Code is the status code: 400, 401, 403, 404 or 500
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("control plane error %s: %s", e.Code, e.Message)
+}
+
+// Client calls the control plane
+type Client struct {
+	nc     *nats.Conn
+	token  string
+	prefix string
+}
+
+// NewClient creates a client authenticating with a bearer token; the token may be
+// empty when the control plane runs without RBAC
+func NewClient(nc *nats.Conn, token string) *Client {
+	return &Client{nc: nc, token: token, prefix: DefaultSubjectPrefix}
+}
+
+// WithSubjectPrefix returns a client of a control plane serving under another prefix
+func (c *Client) WithSubjectPrefix(prefix string) *Client {
+	clone := *c
+	clone.prefix = prefix
+	return &clone
+}
+
+// call sends a request to an action and decodes the response into v
+func (c *Client) call(ctx context.Context, action string, request, v interface{}) error {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	msg := nats.NewMsg(Subject(c.prefix, action))
+	msg.Data = data
+	if c.token != "" {
+		msg.Header.Set(AuthorizationHeader, "Bearer "+c.token)
+	}
+
+	reply, err := c.nc.RequestMsgWithContext(ctx, msg)
+	if err != nil {
+		return fmt.Errorf("failed to send %s request: %w", action, err)
+	}
+	if code := reply.Header.Get(micro.ErrorCodeHeader); code != "" {
+		return &Error{Code: code, Message: reply.Header.Get(micro.ErrorHeader)}
+	}
+	if err := json.Unmarshal(reply.Data, v); err != nil {
+		return fmt.Errorf("failed to unmarshal %s response: %w", action, err)
+	}
+	return nil
+}
+
+// ListFunctions lists the functions of the registry
+func (c *Client) ListFunctions(ctx context.Context) ([]function.FunctionMeta, error) {
+	var resp FunctionsResponse
+	err := c.call(ctx, ActionFunctionsList, struct{}{}, &resp)
+	return resp.Functions, err
+}
+
+// ListFunctionsPage lists a page of the registry's functions ordered by name, starting
+// after the cursor; the returned cursor is empty on the last page
+func (c *Client) ListFunctionsPage(ctx context.Context, after string, limit int) ([]function.FunctionMeta, string, error) {
+	var resp FunctionsResponse
+	err := c.call(ctx, ActionFunctionsList, FunctionsRequest{After: after, Limit: limit}, &resp)
+	return resp.Functions, resp.Next, err
+}
+
+// GetFunction returns a function's metadata and, if requested, its binary
+func (c *Client) GetFunction(ctx context.Context, name string, includeBinary bool) (function.FunctionMeta, []byte, error) {
+	var resp FunctionResponse
+	err := c.call(ctx, ActionFunctionsGet, FunctionRequest{Name: name, IncludeBinary: includeBinary}, &resp)
+	return resp.Function, resp.Binary, err
+}
+
+// DeployFunctions stores a set of functions all-or-nothing and returns their stored metadata
+func (c *Client) DeployFunctions(ctx context.Context, deployments []Deployment) ([]function.FunctionMeta, error) {
+	var resp FunctionsResponse
+	err := c.call(ctx, ActionFunctionsDeploy, DeployRequest{Functions: deployments}, &resp)
+	return resp.Functions, err
+}
+
+// DeleteFunction deletes a function
+func (c *Client) DeleteFunction(ctx context.Context, name string) error {
+	var resp DeleteResponse
+	return c.call(ctx, ActionFunctionsDelete, FunctionRequest{Name: name}, &resp)
+}
+
+// ListTriggers returns a page of triggers ordered by ID and the total number of triggers
+func (c *Client) ListTriggers(ctx context.Context, opts trigger.ListOptions) ([]*trigger.Trigger, int, error) {
+	var resp TriggersResponse
+	err := c.call(ctx, ActionTriggersList, TriggersRequest{After: opts.After, Offset: opts.Offset, Limit: opts.Limit}, &resp)
+	return resp.Triggers, resp.Total, err
+}
+
+// GetTrigger returns a trigger
+func (c *Client) GetTrigger(ctx context.Context, id string) (*trigger.Trigger, error) {
+	var resp TriggerResponse
+	err := c.call(ctx, ActionTriggersGet, TriggerRequest{ID: id}, &resp)
+	return resp.Trigger, err
+}
+
+// SaveTrigger validates and saves a trigger and returns the analysis findings involving it
+func (c *Client) SaveTrigger(ctx context.Context, t *trigger.Trigger) ([]trigger.Finding, error) {
+	var resp TriggerResponse
+	err := c.call(ctx, ActionTriggersPut, TriggerRequest{Trigger: t}, &resp)
+	return resp.Findings, err
+}
+
+// SaveTriggerYAML validates and saves a YAML trigger definition
+func (c *Client) SaveTriggerYAML(ctx context.Context, data []byte) (*trigger.Trigger, []trigger.Finding, error) {
+	var resp TriggerResponse
+	err := c.call(ctx, ActionTriggersPut, TriggerRequest{YAML: string(data)}, &resp)
+	return resp.Trigger, resp.Findings, err
+}
+
+// ValidateTriggerYAML validates a YAML trigger definition without saving it
+func (c *Client) ValidateTriggerYAML(ctx context.Context, data []byte) (ValidationResponse, error) {
+	var resp ValidationResponse
+	err := c.call(ctx, ActionTriggersValidate, TriggerRequest{YAML: string(data)}, &resp)
+	return resp, err
+}
+
+// DeleteTrigger deletes a trigger
+func (c *Client) DeleteTrigger(ctx context.Context, id string) error {
+	var resp DeleteResponse
+	return c.call(ctx, ActionTriggersDelete, TriggerRequest{ID: id}, &resp)
+}
+
+// AuditLog returns the audit entries about a resource, or all entries when resource
+// is empty, oldest first
+func (c *Client) AuditLog(ctx context.Context, resource string, limit int) ([]AuditEntry, error) {
+	var resp AuditResponse
+	err := c.call(ctx, ActionAuditList, AuditRequest{Resource: resource, Limit: limit}, &resp)
+	return resp.Entries, err
+}
+
+// CordonInstance cordons, drains or uncordons a runtime instance and returns its status
+func (c *Client) CordonInstance(ctx context.Context, request CordonRequest) (function.CordonStatus, error) {
+	var status function.CordonStatus
+	err := c.call(ctx, ActionRuntimeCordon, request, &status)
+	return status, err
+}
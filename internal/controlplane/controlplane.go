@@ -0,0 +1,672 @@
+// Package controlplane serves a NATS micro service for managing functions and
+// triggers, so UIs and automation share one validated, authorized and audited API
+// instead of writing to the KV buckets directly.
+package controlplane
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/audit"
+	"github.com/julianshen/mycelium/internal/function"
+	"github.com/julianshen/mycelium/internal/trigger"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// Control plane defaults
+const (
+	DefaultServiceName   = "controlplane"
+	DefaultSubjectPrefix = "controlplane"
+	// DefaultTriggerNamespace is the store namespace triggers are saved under, as by triggerctl
+	DefaultTriggerNamespace = "default"
+	DefaultRequestTimeout   = 30 * time.Second
+	// DefaultRuntimeService is the service name of the runtime instances cordoned
+	DefaultRuntimeService = "function-runtime"
+)
+
+// AuthorizationHeader carries the bearer token of a request
+const AuthorizationHeader = "Authorization"
+
+// Anonymous is the actor of requests when RBAC is disabled
+const Anonymous = "anonymous"
+
+// Control plane actions, the endpoint subjects below the subject prefix
+const (
+	ActionFunctionsList    = "functions.list"
+	ActionFunctionsGet     = "functions.get"
+	ActionFunctionsDeploy  = "functions.deploy"
+	ActionFunctionsDelete  = "functions.delete"
+	ActionTriggersList     = "triggers.list"
+	ActionTriggersGet      = "triggers.get"
+	ActionTriggersPut      = "triggers.put"
+	ActionTriggersDelete   = "triggers.delete"
+	ActionTriggersValidate = "triggers.validate"
+	ActionAuditList        = "audit.list"
+	ActionRuntimeCordon    = "runtime.cordon"
+)
+
+// Config configures the control plane service
+type Config struct {
+	// Conn is the connection the service is served on; it is not closed by the service
+	Conn     *nats.Conn
+	Registry function.Registry
+	Triggers trigger.TriggerStore
+	// Policy authorizes requests by their bearer token; nil disables RBAC and every
+	// request is made as Anonymous
+	Policy *Policy
+	// AuditBucket is the KV bucket changes are recorded in (default: DefaultAuditBucket)
+	AuditBucket string
+	// AuditTrail also chains audit entries into a tamper-evident trail (optional)
+	AuditTrail *audit.Trail
+	// Name is the service name (default: DefaultServiceName)
+	Name    string
+	Version string
+	// SubjectPrefix is the prefix of the endpoint subjects (default: DefaultSubjectPrefix)
+	SubjectPrefix string
+	// RequestTimeout bounds the store and registry calls of a request (default: DefaultRequestTimeout)
+	RequestTimeout time.Duration
+	// RuntimeService is the service name of the runtime instances cordon requests
+	// address (default: DefaultRuntimeService)
+	RuntimeService string
+}
+
+// Service is the control plane micro service
+type Service struct {
+	service  micro.Service
+	conn     *nats.Conn
+	registry function.Registry
+	triggers trigger.TriggerStore
+	policy   *Policy
+	audit    *auditLog
+	trail    *audit.Trail
+	timeout  time.Duration
+	runtime  string
+}
+
+// endpoint describes a control plane endpoint
+type endpoint struct {
+	action      string
+	permission  string
+	description string
+	// mutates marks endpoints whose requests are audited
+	mutates bool
+	handle  func(s *Service, ctx context.Context, data []byte) (response interface{}, resource string, err error)
+}
+
+var endpoints = []endpoint{
+	{ActionFunctionsList, PermFunctionsRead, "List functions", false, (*Service).listFunctions},
+	{ActionFunctionsGet, PermFunctionsRead, "Get a function, optionally with its binary", false, (*Service).getFunction},
+	{ActionFunctionsDeploy, PermFunctionsWrite, "Store a set of functions all-or-nothing", true, (*Service).deployFunctions},
+	{ActionFunctionsDelete, PermFunctionsWrite, "Delete a function", true, (*Service).deleteFunction},
+	{ActionTriggersList, PermTriggersRead, "List a page of triggers", false, (*Service).listTriggers},
+	{ActionTriggersGet, PermTriggersRead, "Get a trigger", false, (*Service).getTrigger},
+	{ActionTriggersPut, PermTriggersWrite, "Validate and save a trigger", true, (*Service).putTrigger},
+	{ActionTriggersDelete, PermTriggersWrite, "Delete a trigger", true, (*Service).deleteTrigger},
+	{ActionTriggersValidate, PermTriggersRead, "Validate a trigger without saving it", false, (*Service).validateTrigger},
+	{ActionAuditList, PermAuditRead, "List the audit log", false, (*Service).listAudit},
+	{ActionRuntimeCordon, PermRuntimeWrite, "Cordon, drain or uncordon a runtime instance", true, (*Service).cordonRuntime},
+}
+
+// Subject returns the subject of a control plane action
+func Subject(prefix, action string) string {
+	return prefix + "." + action
+}
+
+// New starts the control plane service
+func New(cfg Config) (*Service, error) {
+	if cfg.Conn == nil || cfg.Registry == nil || cfg.Triggers == nil {
+		return nil, fmt.Errorf("control plane requires a connection, registry and trigger store")
+	}
+	if cfg.AuditBucket == "" {
+		cfg.AuditBucket = DefaultAuditBucket
+	}
+	if cfg.Name == "" {
+		cfg.Name = DefaultServiceName
+	}
+	if cfg.Version == "" {
+		cfg.Version = "1.0.0"
+	}
+	if cfg.SubjectPrefix == "" {
+		cfg.SubjectPrefix = DefaultSubjectPrefix
+	}
+	if cfg.RequestTimeout <= 0 {
+		cfg.RequestTimeout = DefaultRequestTimeout
+	}
+	if cfg.RuntimeService == "" {
+		cfg.RuntimeService = DefaultRuntimeService
+	}
+
+	auditLog, err := newAuditLog(cfg.Conn, cfg.AuditBucket)
+	if err != nil {
+		return nil, err
+	}
+	s := &Service{
+		conn:     cfg.Conn,
+		registry: cfg.Registry,
+		triggers: cfg.Triggers,
+		policy:   cfg.Policy,
+		audit:    auditLog,
+		trail:    cfg.AuditTrail,
+		timeout:  cfg.RequestTimeout,
+		runtime:  cfg.RuntimeService,
+	}
+
+	s.service, err = micro.AddService(cfg.Conn, micro.Config{
+		Name:        cfg.Name,
+		Version:     cfg.Version,
+		Description: "Management API for functions and triggers",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create NATS service: %w", err)
+	}
+	for _, ep := range endpoints {
+		// Endpoint names may not contain dots, e.g. functions-list
+		if err := s.service.AddEndpoint(strings.ReplaceAll(ep.action, ".", "-"), s.handler(ep),
+			micro.WithEndpointSubject(Subject(cfg.SubjectPrefix, ep.action)),
+			micro.WithEndpointMetadata(map[string]string{
+				"description": ep.description,
+				"permission":  ep.permission,
+				"format":      "application/json",
+			})); err != nil {
+			s.service.Stop()
+			return nil, fmt.Errorf("failed to add %s endpoint: %w", ep.action, err)
+		}
+	}
+	return s, nil
+}
+
+// Stop stops the service
+func (s *Service) Stop() error {
+	return s.service.Stop()
+}
+
+// requestError is an error answered with a status code other than 500
+type requestError struct {
+	code string
+	err  error
+}
+
+func (e *requestError) Error() string { return e.err.Error() }
+func (e *requestError) Unwrap() error { return e.err }
+
+func badRequest(format string, args ...interface{}) error {
+	return &requestError{code: "400", err: fmt.Errorf(format, args...)}
+}
+
+// handler authorizes, runs and audits the requests of an endpoint
+func (s *Service) handler(ep endpoint) micro.HandlerFunc {
+	return func(req micro.Request) {
+		actor, err := s.authorize(req, ep.permission)
+		if err != nil {
+			code := "401"
+			if errors.Is(err, ErrForbidden) {
+				code = "403"
+			}
+			s.record(AuditEntry{Actor: actor, Action: ep.action, Outcome: OutcomeDenied, Error: err.Error()})
+			req.Error(code, err.Error(), nil)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(trigger.WithActor(context.Background(), actor), s.timeout)
+		defer cancel()
+		response, resource, err := ep.handle(s, ctx, req.Data())
+		if ep.mutates {
+			entry := AuditEntry{Actor: actor, Action: ep.action, Resource: resource, Outcome: OutcomeSucceeded}
+			if err != nil {
+				entry.Outcome, entry.Error = OutcomeFailed, err.Error()
+			}
+			s.record(entry)
+		}
+		if err != nil {
+			code := "500"
+			var reqErr *requestError
+			if errors.As(err, &reqErr) {
+				code = reqErr.code
+			}
+			req.Error(code, err.Error(), nil)
+			return
+		}
+		if err := req.RespondJSON(response); err != nil {
+			log.Printf("Failed to respond to %s request: %v", ep.action, err)
+		}
+	}
+}
+
+// authorize returns the actor of a request and whether it holds a permission
+func (s *Service) authorize(req micro.Request, permission string) (string, error) {
+	if s.policy == nil {
+		return Anonymous, nil
+	}
+	token, _ := strings.CutPrefix(req.Headers().Get(AuthorizationHeader), "Bearer ")
+	principal, err := s.policy.Authenticate(strings.TrimSpace(token))
+	if err != nil {
+		return "", err
+	}
+	if !s.policy.Allowed(principal, permission) {
+		return principal.Name, fmt.Errorf("%w: %s lacks %s", ErrForbidden, principal.Name, permission)
+	}
+	return principal.Name, nil
+}
+
+// record appends an audit entry; failing to audit is logged and does not fail the request
+func (s *Service) record(entry AuditEntry) {
+	if err := s.audit.record(entry); err != nil {
+		log.Printf("Failed to audit %s of %s by %s: %v", entry.Action, entry.Resource, entry.Actor, err)
+	}
+	if s.trail == nil {
+		return
+	}
+	details := map[string]string{"outcome": entry.Outcome}
+	if entry.Error != "" {
+		details["error"] = entry.Error
+	}
+	_, err := s.trail.Append(context.Background(), audit.Record{
+		Time:     entry.Time,
+		Kind:     audit.KindAdmin,
+		Actor:    entry.Actor,
+		Action:   entry.Action,
+		Resource: entry.Resource,
+		Details:  details,
+	})
+	if err != nil {
+		log.Printf("Failed to chain audit entry %s of %s by %s: %v", entry.Action, entry.Resource, entry.Actor, err)
+	}
+}
+
+// decode unmarshals a request body, accepting an empty body as the zero request
+func decode(data []byte, v interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return badRequest("invalid request: %v", err)
+	}
+	return nil
+}
+
+// notFound maps the not-found errors of the registries and stores to 404
+func notFound(err error) error {
+	if errors.Is(err, jetstream.ErrKeyNotFound) || errors.Is(err, nats.ErrKeyNotFound) || errors.Is(err, fs.ErrNotExist) {
+		return &requestError{code: "404", err: err}
+	}
+	return err
+}
+
+// FunctionRequest names a function
+type FunctionRequest struct {
+	Name string `json:"name"`
+	// IncludeBinary returns the function binary with its metadata
+	IncludeBinary bool `json:"include_binary,omitempty"`
+}
+
+// FunctionResponse is a function's metadata and, if requested, its binary
+type FunctionResponse struct {
+	Function function.FunctionMeta `json:"function"`
+	Binary   []byte                `json:"binary,omitempty"`
+}
+
+// FunctionsRequest selects a page of functions ordered by name
+type FunctionsRequest struct {
+	// After is the cursor of the page, the Next of the previous one
+	After string `json:"after,omitempty"`
+	Limit int    `json:"limit,omitempty"`
+}
+
+// FunctionsResponse lists functions
+type FunctionsResponse struct {
+	Functions []function.FunctionMeta `json:"functions"`
+	// Next is the cursor of the following page of a listing, empty on the last page
+	Next string `json:"next,omitempty"`
+}
+
+// Deployment is a function to store, its binary base64-encoded in JSON
+type Deployment struct {
+	Meta   function.FunctionMeta `json:"meta"`
+	Binary []byte                `json:"binary"`
+}
+
+// DeployRequest stores a set of functions all-or-nothing
+type DeployRequest struct {
+	Functions []Deployment `json:"functions"`
+}
+
+// TriggerRequest names a trigger, or carries one as JSON or YAML for put and validate
+type TriggerRequest struct {
+	ID      string           `json:"id,omitempty"`
+	Trigger *trigger.Trigger `json:"trigger,omitempty"`
+	// YAML is a trigger definition as triggerctl reads it, validated with line numbers
+	YAML string `json:"yaml,omitempty"`
+}
+
+// TriggerResponse is a trigger and the analysis findings involving it
+type TriggerResponse struct {
+	Trigger  *trigger.Trigger  `json:"trigger"`
+	Findings []trigger.Finding `json:"findings,omitempty"`
+}
+
+// TriggersRequest selects a page of triggers ordered by ID
+type TriggersRequest struct {
+	After  string `json:"after,omitempty"`
+	Offset int    `json:"offset,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+}
+
+// TriggersResponse is a page of triggers and the total number of triggers
+type TriggersResponse struct {
+	Triggers []*trigger.Trigger `json:"triggers"`
+	Total    int                `json:"total"`
+}
+
+// ValidationResponse is the result of validating a trigger
+type ValidationResponse struct {
+	Valid    bool              `json:"valid"`
+	Errors   []string          `json:"errors,omitempty"`
+	Findings []trigger.Finding `json:"findings,omitempty"`
+}
+
+// DeleteResponse confirms a deletion
+type DeleteResponse struct {
+	Deleted string `json:"deleted"`
+}
+
+// AuditRequest selects audit entries
+type AuditRequest struct {
+	// Resource limits the entries to a trigger ID or function name
+	Resource string `json:"resource,omitempty"`
+	// Limit keeps only the newest entries when positive
+	Limit int `json:"limit,omitempty"`
+}
+
+// AuditResponse lists audit entries, oldest first
+type AuditResponse struct {
+	Entries []AuditEntry `json:"entries"`
+}
+
+// CordonRequest cordons, drains or uncordons a runtime instance
+type CordonRequest struct {
+	InstanceID string `json:"instance_id"`
+	// Action is function.CordonActionCordon, CordonActionDrain or CordonActionUncordon
+	Action string `json:"action"`
+	// Timeout bounds how long a drain waits for in-flight invocations (default: function.DefaultDrainTimeout)
+	Timeout time.Duration `json:"timeout,omitempty"`
+}
+
+func (s *Service) listFunctions(ctx context.Context, data []byte) (interface{}, string, error) {
+	var req FunctionsRequest
+	if err := decode(data, &req); err != nil {
+		return nil, "", err
+	}
+	if req.Limit < 0 {
+		return nil, "", badRequest("limit must not be negative")
+	}
+	opts := function.FunctionListOptions{After: req.After, Limit: req.Limit}
+
+	var page function.FunctionPage
+	if paged, ok := s.registry.(function.PagedRegistry); ok {
+		var err error
+		if page, err = paged.ListFunctionsPage(ctx, opts); err != nil {
+			return nil, "", err
+		}
+	} else {
+		functions, err := s.registry.ListFunctions()
+		if err != nil {
+			return nil, "", err
+		}
+		page = function.PageFunctions(functions, opts)
+	}
+	return FunctionsResponse{Functions: page.Functions, Next: page.Next}, "", nil
+}
+
+func (s *Service) getFunction(ctx context.Context, data []byte) (interface{}, string, error) {
+	var req FunctionRequest
+	if err := decode(data, &req); err != nil {
+		return nil, "", err
+	}
+	if req.Name == "" {
+		return nil, "", badRequest("request must name a function")
+	}
+	meta, binary, err := s.registry.GetFunction(req.Name)
+	if err != nil {
+		return nil, req.Name, notFound(err)
+	}
+	response := FunctionResponse{Function: meta}
+	if req.IncludeBinary {
+		response.Binary = binary
+	}
+	return response, req.Name, nil
+}
+
+func (s *Service) deployFunctions(ctx context.Context, data []byte) (interface{}, string, error) {
+	var req DeployRequest
+	if err := decode(data, &req); err != nil {
+		return nil, "", err
+	}
+	if len(req.Functions) == 0 {
+		return nil, "", badRequest("request must contain functions")
+	}
+	names := make([]string, len(req.Functions))
+	deployments := make([]function.FunctionDeployment, len(req.Functions))
+	for i, d := range req.Functions {
+		if d.Meta.Name == "" || d.Meta.Version == "" {
+			return nil, "", badRequest("function %d must have a name and version", i)
+		}
+		names[i] = d.Meta.Name
+		deployments[i] = function.FunctionDeployment{Meta: d.Meta, Binary: d.Binary}
+	}
+	resource := strings.Join(names, ",")
+	if err := s.registry.DeployFunctions(deployments); err != nil {
+		return nil, resource, err
+	}
+
+	functions := make([]function.FunctionMeta, len(names))
+	for i, name := range names {
+		meta, _, err := s.registry.GetFunction(name)
+		if err != nil {
+			return nil, resource, err
+		}
+		functions[i] = meta
+	}
+	return FunctionsResponse{Functions: functions}, resource, nil
+}
+
+func (s *Service) deleteFunction(ctx context.Context, data []byte) (interface{}, string, error) {
+	var req FunctionRequest
+	if err := decode(data, &req); err != nil {
+		return nil, "", err
+	}
+	if req.Name == "" {
+		return nil, "", badRequest("request must name a function")
+	}
+	if err := s.registry.DeleteFunction(req.Name); err != nil {
+		return nil, req.Name, notFound(err)
+	}
+	return DeleteResponse{Deleted: req.Name}, req.Name, nil
+}
+
+func (s *Service) listTriggers(ctx context.Context, data []byte) (interface{}, string, error) {
+	var req TriggersRequest
+	if err := decode(data, &req); err != nil {
+		return nil, "", err
+	}
+	if req.Offset < 0 || req.Limit < 0 {
+		return nil, "", badRequest("offset and limit must not be negative")
+	}
+	triggers, total, err := s.triggers.ListTriggers(ctx, trigger.ListOptions{After: req.After, Offset: req.Offset, Limit: req.Limit})
+	if err != nil {
+		return nil, "", err
+	}
+	if triggers == nil {
+		triggers = []*trigger.Trigger{}
+	}
+	return TriggersResponse{Triggers: triggers, Total: total}, "", nil
+}
+
+func (s *Service) getTrigger(ctx context.Context, data []byte) (interface{}, string, error) {
+	var req TriggerRequest
+	if err := decode(data, &req); err != nil {
+		return nil, "", err
+	}
+	if req.ID == "" {
+		return nil, "", badRequest("request must name a trigger")
+	}
+	t, err := s.findTrigger(ctx, req.ID)
+	if err != nil {
+		return nil, req.ID, err
+	}
+	return TriggerResponse{Trigger: t}, req.ID, nil
+}
+
+func (s *Service) putTrigger(ctx context.Context, data []byte) (interface{}, string, error) {
+	t, findings, err := s.checkTrigger(ctx, data)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := s.triggers.SaveTrigger(ctx, DefaultTriggerNamespace, t.ID, t); err != nil {
+		if errors.Is(err, trigger.ErrPolicyViolation) {
+			return nil, t.ID, &requestError{code: "400", err: err}
+		}
+		return nil, t.ID, err
+	}
+	return TriggerResponse{Trigger: t, Findings: findings}, t.ID, nil
+}
+
+func (s *Service) validateTrigger(ctx context.Context, data []byte) (interface{}, string, error) {
+	t, findings, err := s.checkTrigger(ctx, data)
+	var invalid *requestError
+	if errors.As(err, &invalid) && invalid.code == "400" {
+		var errs trigger.ValidationErrors
+		if errors.As(err, &errs) {
+			response := ValidationResponse{}
+			for _, e := range errs {
+				response.Errors = append(response.Errors, e.Error())
+			}
+			return response, "", nil
+		}
+		return ValidationResponse{Errors: []string{err.Error()}}, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return ValidationResponse{Valid: true, Findings: findings}, t.ID, nil
+}
+
+func (s *Service) deleteTrigger(ctx context.Context, data []byte) (interface{}, string, error) {
+	var req TriggerRequest
+	if err := decode(data, &req); err != nil {
+		return nil, "", err
+	}
+	if req.ID == "" {
+		return nil, "", badRequest("request must name a trigger")
+	}
+	if _, err := s.findTrigger(ctx, req.ID); err != nil {
+		return nil, req.ID, err
+	}
+	if err := s.triggers.DeleteTrigger(ctx, DefaultTriggerNamespace, req.ID); err != nil {
+		return nil, req.ID, err
+	}
+	return DeleteResponse{Deleted: req.ID}, req.ID, nil
+}
+
+func (s *Service) listAudit(ctx context.Context, data []byte) (interface{}, string, error) {
+	var req AuditRequest
+	if err := decode(data, &req); err != nil {
+		return nil, "", err
+	}
+	entries, err := s.audit.list(ctx, req.Resource, req.Limit)
+	if err != nil {
+		return nil, "", err
+	}
+	if entries == nil {
+		entries = []AuditEntry{}
+	}
+	return AuditResponse{Entries: entries}, "", nil
+}
+
+// findTrigger returns the trigger with an ID
+func (s *Service) cordonRuntime(ctx context.Context, data []byte) (interface{}, string, error) {
+	var request CordonRequest
+	if err := decode(data, &request); err != nil {
+		return nil, "", err
+	}
+	if request.InstanceID == "" {
+		return nil, "", badRequest("instance_id is required")
+	}
+	switch request.Action {
+	case function.CordonActionCordon, function.CordonActionDrain, function.CordonActionUncordon:
+	default:
+		return nil, request.InstanceID, badRequest("unknown cordon action %q", request.Action)
+	}
+
+	status, err := function.CordonInstance(s.conn, s.runtime, request.InstanceID, function.CordonRequest{
+		Action:  request.Action,
+		Timeout: request.Timeout,
+		Actor:   trigger.ActorFromContext(ctx),
+	}, s.timeout)
+	if errors.Is(err, nats.ErrNoResponders) {
+		return nil, request.InstanceID, &requestError{code: "404", err: fmt.Errorf("runtime instance %s not found", request.InstanceID)}
+	}
+	if err != nil {
+		return nil, request.InstanceID, err
+	}
+	return status, request.InstanceID, nil
+}
+
+func (s *Service) findTrigger(ctx context.Context, id string) (*trigger.Trigger, error) {
+	var found *trigger.Trigger
+	err := s.triggers.ForEachTrigger(ctx, func(t *trigger.Trigger) bool {
+		if t.ID == id {
+			found = t
+		}
+		return found == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, &requestError{code: "404", err: fmt.Errorf("trigger %s not found", id)}
+	}
+	return found, nil
+}
+
+// checkTrigger decodes the trigger of a put or validate request, validates it against
+// the trigger schema and analyzes it against the stored triggers
+func (s *Service) checkTrigger(ctx context.Context, data []byte) (*trigger.Trigger, []trigger.Finding, error) {
+	var req TriggerRequest
+	if err := decode(data, &req); err != nil {
+		return nil, nil, err
+	}
+
+	var t *trigger.Trigger
+	switch {
+	case req.YAML != "" && req.Trigger != nil:
+		return nil, nil, badRequest("request must carry a trigger or YAML, not both")
+	case req.YAML != "":
+		parsed, err := trigger.ParseYAML([]byte(req.YAML))
+		if err != nil {
+			return nil, nil, &requestError{code: "400", err: err}
+		}
+		t = parsed
+	case req.Trigger != nil:
+		if err := req.Trigger.Validate(); err != nil {
+			return nil, nil, &requestError{code: "400", err: err}
+		}
+		t = req.Trigger
+	default:
+		return nil, nil, badRequest("request must carry a trigger")
+	}
+
+	others, err := s.triggers.GetAllTriggers(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return t, trigger.CheckTrigger(t, others), nil
+}
@@ -0,0 +1,282 @@
+package controlplane
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/function"
+	"github.com/julianshen/mycelium/internal/trigger"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// TestPolicy tests authentication by token digest and role permissions
+func TestPolicy(t *testing.T) {
+	policy, err := ParsePolicy([]byte(`
+roles:
+  trigger-author: ["triggers:*"]
+principals:
+  - name: dashboard
+    token_sha256: ` + tokenHash("dashboard-token") + `
+    roles: [viewer]
+  - name: ci
+    token_sha256: ` + tokenHash("ci-token") + `
+    roles: [trigger-author, viewer]
+  - name: ops
+    token_sha256: ` + tokenHash("ops-token") + `
+    roles: [admin]
+`))
+	require.NoError(t, err)
+
+	_, err = policy.Authenticate("")
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+	_, err = policy.Authenticate("wrong-token")
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+
+	dashboard, err := policy.Authenticate("dashboard-token")
+	require.NoError(t, err)
+	assert.Equal(t, "dashboard", dashboard.Name)
+	assert.True(t, policy.Allowed(dashboard, PermTriggersRead))
+	assert.False(t, policy.Allowed(dashboard, PermTriggersWrite))
+	assert.False(t, policy.Allowed(dashboard, PermAuditRead))
+
+	ci, err := policy.Authenticate("ci-token")
+	require.NoError(t, err)
+	assert.True(t, policy.Allowed(ci, PermTriggersWrite))
+	assert.True(t, policy.Allowed(ci, PermFunctionsRead))
+	assert.False(t, policy.Allowed(ci, PermFunctionsWrite))
+
+	ops, err := policy.Authenticate("ops-token")
+	require.NoError(t, err)
+	assert.True(t, policy.Allowed(ops, PermAuditRead))
+
+	_, err = ParsePolicy([]byte(`principals: [{name: x, token_sha256: ` + tokenHash("x") + `, roles: [root]}]`))
+	assert.ErrorContains(t, err, "unknown role root")
+	_, err = ParsePolicy([]byte(`principals: [{name: x, token_sha256: plain-token, roles: [viewer]}]`))
+	assert.ErrorContains(t, err, "token_sha256")
+}
+
+// TestControlPlane tests the endpoints, RBAC and audit log of the service
+func TestControlPlane(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	if _, err := js.AccountInfo(); err != nil {
+		t.Skip("JetStream not available, skipping integration test")
+	}
+
+	id := uuid.NewString()[:8]
+	triggerBucket := "controlplane-test-" + id
+	auditBucket := "controlplane-audit-test-" + id
+	store, err := trigger.NewNATSStore(nc, triggerBucket)
+	require.NoError(t, err)
+	defer js.DeleteKeyValue(triggerBucket)
+	defer store.Close()
+	require.NoError(t, store.Watch(context.Background()))
+	defer js.DeleteKeyValue(auditBucket)
+
+	policy, err := ParsePolicy([]byte(`
+principals:
+  - name: dashboard
+    token_sha256: ` + tokenHash("dashboard-token") + `
+    roles: [viewer]
+  - name: ops
+    token_sha256: ` + tokenHash("ops-token") + `
+    roles: [admin]
+`))
+	require.NoError(t, err)
+
+	prefix := "controlplane-test-" + id
+	service, err := New(Config{
+		Conn:          nc,
+		Registry:      &function.MemoryRegistry{},
+		Triggers:      store,
+		Policy:        policy,
+		AuditBucket:   auditBucket,
+		SubjectPrefix: prefix,
+	})
+	require.NoError(t, err)
+	defer service.Stop()
+
+	ctx := context.Background()
+	ops := NewClient(nc, "ops-token").WithSubjectPrefix(prefix)
+	dashboard := NewClient(nc, "dashboard-token").WithSubjectPrefix(prefix)
+	anonymous := NewClient(nc, "").WithSubjectPrefix(prefix)
+
+	// Functions
+	deployed, err := ops.DeployFunctions(ctx, []Deployment{
+		{Meta: function.FunctionMeta{Name: "resize", Type: function.TypeBuiltin, Version: "1.0.0"}, Binary: []byte("binary")},
+	})
+	require.NoError(t, err)
+	require.Len(t, deployed, 1)
+	functions, err := dashboard.ListFunctions(ctx)
+	require.NoError(t, err)
+	require.Len(t, functions, 1)
+	functions, next, err := dashboard.ListFunctionsPage(ctx, "", 1)
+	require.NoError(t, err)
+	require.Len(t, functions, 1)
+	assert.Empty(t, next, "a single function fits one page")
+	functions, _, err = dashboard.ListFunctionsPage(ctx, "resize", 1)
+	require.NoError(t, err)
+	assert.Empty(t, functions)
+	meta, binary, err := dashboard.GetFunction(ctx, "resize", true)
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.0", meta.Version)
+	assert.Equal(t, []byte("binary"), binary)
+
+	// Triggers are validated before they are saved
+	_, _, err = ops.SaveTriggerYAML(ctx, []byte("id: bad\nenabled: maybe\n"))
+	var cpErr *Error
+	require.True(t, errors.As(err, &cpErr), "%v", err)
+	assert.Equal(t, "400", cpErr.Code)
+
+	validation, err := dashboard.ValidateTriggerYAML(ctx, []byte("id: bad\nenabled: maybe\n"))
+	require.NoError(t, err)
+	assert.False(t, validation.Valid)
+	assert.NotEmpty(t, validation.Errors)
+
+	saved, _, err := ops.SaveTriggerYAML(ctx, []byte("id: large-images\nname: Large images\nenabled: true\nevent_type: prod.image.uploaded\naction: \"function:resize\"\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "large-images", saved.ID)
+	assert.Eventually(t, func() bool {
+		got, err := dashboard.GetTrigger(ctx, "large-images")
+		return err == nil && got.Action == "function:resize"
+	}, 2*time.Second, 20*time.Millisecond)
+	triggers, total, err := dashboard.ListTriggers(ctx, trigger.ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.Len(t, triggers, 1)
+
+	// RBAC
+	_, err = dashboard.SaveTrigger(ctx, saved)
+	require.True(t, errors.As(err, &cpErr))
+	assert.Equal(t, "403", cpErr.Code)
+	_, err = anonymous.ListFunctions(ctx)
+	require.True(t, errors.As(err, &cpErr))
+	assert.Equal(t, "401", cpErr.Code)
+
+	// Deletion and not found
+	require.NoError(t, ops.DeleteTrigger(ctx, "large-images"))
+	assert.Eventually(t, func() bool {
+		_, err := dashboard.GetTrigger(ctx, "large-images")
+		return errors.As(err, &cpErr) && cpErr.Code == "404"
+	}, 2*time.Second, 20*time.Millisecond)
+	require.NoError(t, ops.DeleteFunction(ctx, "resize"))
+
+	// Audit log of changes and refusals
+	entries, err := ops.AuditLog(ctx, "", 0)
+	require.NoError(t, err)
+	var actions []string
+	for _, entry := range entries {
+		actions = append(actions, entry.Action+" "+entry.Actor+" "+entry.Outcome)
+	}
+	assert.Equal(t, []string{
+		"functions.deploy ops succeeded",
+		"triggers.put ops failed",
+		"triggers.put ops succeeded",
+		"triggers.put dashboard denied",
+		"functions.list  denied",
+		"triggers.delete ops succeeded",
+		"functions.delete ops succeeded",
+	}, actions)
+
+	entries, err = ops.AuditLog(ctx, "large-images", 1)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, ActionTriggersDelete, entries[0].Action)
+
+	_, err = dashboard.AuditLog(ctx, "", 0)
+	require.True(t, errors.As(err, &cpErr))
+	assert.Equal(t, "403", cpErr.Code)
+}
+
+// TestCordonRuntime tests cordoning and uncordoning runtime instances through the control plane
+func TestCordonRuntime(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	if _, err := js.AccountInfo(); err != nil {
+		t.Skip("JetStream not available, skipping integration test")
+	}
+
+	id := uuid.NewString()[:8]
+	auditBucket := "controlplane-audit-test-" + id
+	defer js.DeleteKeyValue(auditBucket)
+	store, err := trigger.NewNATSStore(nc, "controlplane-cordon-test-"+id)
+	require.NoError(t, err)
+	defer js.DeleteKeyValue("controlplane-cordon-test-" + id)
+	defer store.Close()
+
+	runtime, err := function.NewRuntimeService(function.RuntimeServiceConfig{
+		Conn:        nc,
+		ServiceName: "controlplane-test-runtime-" + id,
+		Registry:    &function.MemoryRegistry{},
+		Metrics:     &function.SimpleMetricsCollector{},
+		Logger:      &function.SimpleLogger{},
+		Group:       "controlplane-test-" + id,
+	})
+	require.NoError(t, err)
+	require.NoError(t, runtime.Start())
+	defer runtime.Stop()
+
+	prefix := "controlplane-test-" + id
+	service, err := New(Config{
+		Conn:           nc,
+		Registry:       &function.MemoryRegistry{},
+		Triggers:       store,
+		AuditBucket:    auditBucket,
+		SubjectPrefix:  prefix,
+		RuntimeService: "controlplane-test-runtime-" + id,
+	})
+	require.NoError(t, err)
+	defer service.Stop()
+
+	ctx := context.Background()
+	client := NewClient(nc, "").WithSubjectPrefix(prefix)
+	instanceID := runtime.InstanceID()
+	status, err := client.CordonInstance(ctx, CordonRequest{InstanceID: instanceID, Action: function.CordonActionDrain, Timeout: time.Second})
+	require.NoError(t, err)
+	assert.True(t, status.Cordoned)
+	assert.True(t, status.Drained)
+	assert.True(t, runtime.Cordoned())
+
+	status, err = client.CordonInstance(ctx, CordonRequest{InstanceID: instanceID, Action: function.CordonActionUncordon})
+	require.NoError(t, err)
+	assert.False(t, status.Cordoned)
+	assert.False(t, runtime.Cordoned())
+
+	var cpErr *Error
+	_, err = client.CordonInstance(ctx, CordonRequest{InstanceID: "missing", Action: function.CordonActionCordon})
+	require.True(t, errors.As(err, &cpErr), "%v", err)
+	assert.Equal(t, "404", cpErr.Code)
+	_, err = client.CordonInstance(ctx, CordonRequest{InstanceID: instanceID, Action: "evict"})
+	require.True(t, errors.As(err, &cpErr), "%v", err)
+	assert.Equal(t, "400", cpErr.Code)
+
+	entries, err := client.AuditLog(ctx, instanceID, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, ActionRuntimeCordon, entries[0].Action)
+}
@@ -0,0 +1,138 @@
+package controlplane
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Permissions checked by the control plane endpoints, as <resource>:<verb>
+const (
+	PermFunctionsRead  = "functions:read"
+	PermFunctionsWrite = "functions:write"
+	PermTriggersRead   = "triggers:read"
+	PermTriggersWrite  = "triggers:write"
+	PermAuditRead      = "audit:read"
+	PermRuntimeWrite   = "runtime:write"
+)
+
+// Built-in roles, available to every policy unless it redefines them
+var builtinRoles = map[string][]string{
+	"viewer": {PermFunctionsRead, PermTriggersRead},
+	"editor": {PermFunctionsRead, PermFunctionsWrite, PermTriggersRead, PermTriggersWrite},
+	"admin":  {"*"},
+}
+
+var (
+	// ErrUnauthenticated is returned when a request carries no known token
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrForbidden is returned when the principal of a request lacks a permission
+	ErrForbidden = errors.New("forbidden")
+)
+
+// Principal is a caller of the control plane, identified by a bearer token
+type Principal struct {
+	Name string `yaml:"name"`
+	// TokenSHA256 is the hex SHA-256 of the principal's token, e.g. the output of
+	// `printf %s "$TOKEN" | sha256sum`, so the policy file holds no secrets
+	TokenSHA256 string   `yaml:"token_sha256"`
+	Roles       []string `yaml:"roles"`
+}
+
+// Policy maps the tokens of principals to roles and roles to permissions. Role
+// permissions are <resource>:<verb> strings where either part may be "*".
+type Policy struct {
+	Roles      map[string][]string `yaml:"roles,omitempty"`
+	Principals []Principal         `yaml:"principals"`
+}
+
+// LoadPolicy reads an RBAC policy from a YAML file
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy: %w", err)
+	}
+	return ParsePolicy(data)
+}
+
+// ParsePolicy decodes an RBAC policy and checks that its principals and roles are complete
+func ParsePolicy(data []byte) (*Policy, error) {
+	var p Policy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %w", err)
+	}
+
+	names := make(map[string]bool)
+	for i, principal := range p.Principals {
+		if principal.Name == "" {
+			return nil, fmt.Errorf("principal %d has no name", i)
+		}
+		if names[principal.Name] {
+			return nil, fmt.Errorf("duplicate principal %s", principal.Name)
+		}
+		names[principal.Name] = true
+		if _, err := hex.DecodeString(principal.TokenSHA256); err != nil || len(principal.TokenSHA256) != 2*sha256.Size {
+			return nil, fmt.Errorf("principal %s: token_sha256 must be a hex SHA-256", principal.Name)
+		}
+		for _, role := range principal.Roles {
+			if _, ok := p.permissions(role); !ok {
+				return nil, fmt.Errorf("principal %s: unknown role %s", principal.Name, role)
+			}
+		}
+	}
+	return &p, nil
+}
+
+// Authenticate returns the principal whose token hashes to the token's digest
+func (p *Policy) Authenticate(token string) (*Principal, error) {
+	if token == "" {
+		return nil, ErrUnauthenticated
+	}
+	sum := sha256.Sum256([]byte(token))
+	digest := hex.EncodeToString(sum[:])
+	for i := range p.Principals {
+		if subtle.ConstantTimeCompare([]byte(strings.ToLower(p.Principals[i].TokenSHA256)), []byte(digest)) == 1 {
+			return &p.Principals[i], nil
+		}
+	}
+	return nil, ErrUnauthenticated
+}
+
+// Allowed reports whether one of the principal's roles grants a permission
+func (p *Policy) Allowed(principal *Principal, permission string) bool {
+	for _, role := range principal.Roles {
+		permissions, _ := p.permissions(role)
+		for _, granted := range permissions {
+			if permissionMatches(granted, permission) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// permissions returns the permissions of a role, preferring the policy's own roles
+func (p *Policy) permissions(role string) ([]string, bool) {
+	if permissions, ok := p.Roles[role]; ok {
+		return permissions, true
+	}
+	permissions, ok := builtinRoles[role]
+	return permissions, ok
+}
+
+// permissionMatches reports whether a granted permission, possibly with wildcards,
+// covers a required one
+func permissionMatches(granted, required string) bool {
+	if granted == "*" || granted == required {
+		return true
+	}
+	grantedResource, grantedVerb, _ := strings.Cut(granted, ":")
+	resource, verb, _ := strings.Cut(required, ":")
+	return (grantedResource == "*" || grantedResource == resource) && (grantedVerb == "*" || grantedVerb == verb)
+}
@@ -0,0 +1,172 @@
+package event
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/nats-io/nats.go"
+)
+
+// Claim check defaults
+const (
+	DefaultClaimCheckBucket = "event-payloads"
+	// DefaultClaimCheckThreshold is the data size above which payloads are offloaded,
+	// a quarter of the server's default 1MiB max payload so events keep room for
+	// their attributes and request envelopes
+	DefaultClaimCheckThreshold = 256 * 1024
+	// DefaultClaimCheckTTL is how long offloaded payloads are kept
+	DefaultClaimCheckTTL = 7 * 24 * time.Hour
+)
+
+// ErrClaimCheckMismatch is returned when a resolved payload does not match its reference
+var ErrClaimCheckMismatch = errors.New("claim-checked payload does not match its digest")
+
+// ClaimCheckConfig configures a ClaimCheck
+type ClaimCheckConfig struct {
+	// Bucket is the object store payloads are kept in (default: DefaultClaimCheckBucket)
+	Bucket string
+	// Threshold is the data size in bytes above which payloads are offloaded
+	// (default: DefaultClaimCheckThreshold)
+	Threshold int
+	// TTL is how long offloaded payloads are kept; consumers must resolve events
+	// within it (default: DefaultClaimCheckTTL)
+	TTL time.Duration
+}
+
+// ClaimCheck moves the data of large events to an object store and replaces it with
+// a reference, so producers stay below the server's max payload. The reference is
+// the claimcheck extension, <bucket>/<sha256 of the data>; the claimchecksize
+// extension carries the size of the data. The data content type is kept.
+type ClaimCheck struct {
+	js        nats.JetStreamContext
+	bucket    string
+	threshold int
+	stores    map[string]nats.ObjectStore
+	mu        sync.Mutex
+}
+
+// NewClaimCheck creates a claim check, creating its object store if needed
+func NewClaimCheck(nc *nats.Conn, cfg ClaimCheckConfig) (*ClaimCheck, error) {
+	if cfg.Bucket == "" {
+		cfg.Bucket = DefaultClaimCheckBucket
+	}
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = DefaultClaimCheckThreshold
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultClaimCheckTTL
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get JetStream context: %w", err)
+	}
+	store, err := js.ObjectStore(cfg.Bucket)
+	if errors.Is(err, nats.ErrStreamNotFound) || errors.Is(err, nats.ErrBucketNotFound) {
+		store, err = js.CreateObjectStore(&nats.ObjectStoreConfig{
+			Bucket:      cfg.Bucket,
+			Description: "Payloads of claim-checked events",
+			TTL:         cfg.TTL,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get claim check bucket: %w", err)
+	}
+
+	return &ClaimCheck{
+		js:        js,
+		bucket:    cfg.Bucket,
+		threshold: cfg.Threshold,
+		stores:    map[string]nats.ObjectStore{cfg.Bucket: store},
+	}, nil
+}
+
+// IsClaimChecked reports whether an event's data was replaced by a reference
+func IsClaimChecked(e *cloudevents.Event) bool {
+	_, ok := e.Extensions()[ExtClaimCheck]
+	return ok
+}
+
+// Offload returns the event with its data moved to the object store when the data
+// exceeds the threshold, and the event itself otherwise. The event passed in is not
+// modified. Identical payloads are stored once.
+func (c *ClaimCheck) Offload(e *cloudevents.Event) (*cloudevents.Event, error) {
+	if e == nil || len(e.Data()) <= c.threshold || IsClaimChecked(e) {
+		return e, nil
+	}
+
+	data := e.Data()
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+
+	store, err := c.store(c.bucket)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := store.GetInfo(digest); errors.Is(err, nats.ErrObjectNotFound) {
+		if _, err := store.PutBytes(digest, data); err != nil {
+			return nil, fmt.Errorf("failed to store payload of event %s: %w", e.ID(), err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to look up payload of event %s: %w", e.ID(), err)
+	}
+
+	offloaded := e.Clone()
+	offloaded.DataEncoded = nil
+	offloaded.SetExtension(ExtClaimCheck, c.bucket+"/"+digest)
+	offloaded.SetExtension(ExtClaimCheckSize, len(data))
+	return &offloaded, nil
+}
+
+// Resolve replaces the reference of a claim-checked event with its data, in place.
+// Events that are not claim-checked are left alone.
+func (c *ClaimCheck) Resolve(e *cloudevents.Event) error {
+	if e == nil || !IsClaimChecked(e) {
+		return nil
+	}
+
+	ref, _ := e.Extensions()[ExtClaimCheck].(string)
+	bucket, digest, ok := strings.Cut(ref, "/")
+	if !ok || bucket == "" || digest == "" {
+		return fmt.Errorf("invalid claim check reference %q on event %s", ref, e.ID())
+	}
+
+	store, err := c.store(bucket)
+	if err != nil {
+		return err
+	}
+	data, err := store.GetBytes(digest)
+	if err != nil {
+		return fmt.Errorf("failed to resolve payload of event %s: %w", e.ID(), err)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != digest {
+		return fmt.Errorf("event %s: %w", e.ID(), ErrClaimCheckMismatch)
+	}
+
+	e.DataEncoded = data
+	e.SetExtension(ExtClaimCheck, nil)
+	e.SetExtension(ExtClaimCheckSize, nil)
+	return nil
+}
+
+// store returns the object store of a bucket, so events offloaded to other buckets resolve too
+func (c *ClaimCheck) store(bucket string) (nats.ObjectStore, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if store, ok := c.stores[bucket]; ok {
+		return store, nil
+	}
+	store, err := c.js.ObjectStore(bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get claim check bucket %s: %w", bucket, err)
+	}
+	c.stores[bucket] = store
+	return store, nil
+}
@@ -0,0 +1,129 @@
+package event
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestClaimCheck tests offloading large event data and resolving it, directly and
+// through a watcher
+func TestClaimCheck(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	if _, err := js.AccountInfo(); err != nil {
+		t.Skip("JetStream not available, skipping integration test")
+	}
+
+	id := uuid.NewString()[:8]
+	bucket := "claimcheck-test-" + id
+	claims, err := NewClaimCheck(nc, ClaimCheckConfig{Bucket: bucket, Threshold: 1024})
+	require.NoError(t, err)
+	defer js.DeleteObjectStore(bucket)
+
+	newEvent := func(size int) *cloudevents.Event {
+		e := cloudevents.NewEvent()
+		e.SetID(uuid.NewString())
+		e.SetSource("test")
+		e.SetType("blob.uploaded")
+		require.NoError(t, e.SetData(cloudevents.ApplicationJSON, map[string]string{
+			"blob": string(bytes.Repeat([]byte("x"), size)),
+		}))
+		return &e
+	}
+
+	t.Run("small events are left alone", func(t *testing.T) {
+		small := newEvent(10)
+		offloaded, err := claims.Offload(small)
+		require.NoError(t, err)
+		assert.Same(t, small, offloaded)
+		assert.False(t, IsClaimChecked(offloaded))
+	})
+
+	t.Run("large events are offloaded and resolved", func(t *testing.T) {
+		large := newEvent(4096)
+		data := large.Data()
+		offloaded, err := claims.Offload(large)
+		require.NoError(t, err)
+		assert.True(t, IsClaimChecked(offloaded))
+		assert.Empty(t, offloaded.Data())
+		assert.Equal(t, data, large.Data(), "the original event is not modified")
+		assert.Equal(t, cloudevents.ApplicationJSON, offloaded.DataContentType())
+
+		// The reference survives the wire format
+		wire, err := offloaded.MarshalJSON()
+		require.NoError(t, err)
+		decoded := cloudevents.NewEvent()
+		require.NoError(t, decoded.UnmarshalJSON(wire))
+
+		require.NoError(t, claims.Resolve(&decoded))
+		assert.False(t, IsClaimChecked(&decoded))
+		assert.Equal(t, data, decoded.Data())
+	})
+
+	t.Run("tampered payloads are rejected", func(t *testing.T) {
+		offloaded, err := claims.Offload(newEvent(2048))
+		require.NoError(t, err)
+		store, err := js.ObjectStore(bucket)
+		require.NoError(t, err)
+		ref := offloaded.Extensions()[ExtClaimCheck].(string)
+		_, err = store.PutBytes(ref[len(bucket)+1:], []byte("tampered"))
+		require.NoError(t, err)
+
+		assert.ErrorIs(t, claims.Resolve(offloaded), ErrClaimCheckMismatch)
+	})
+
+	t.Run("watchers resolve events before handling them", func(t *testing.T) {
+		stream := "claimcheck-test-" + id
+		subject := "claimchecktest." + id
+		_, err := js.AddStream(&nats.StreamConfig{Name: stream, Subjects: []string{subject}})
+		require.NoError(t, err)
+		defer js.DeleteStream(stream)
+
+		handled := make(chan *cloudevents.Event, 1)
+		watcher, err := NewWatcher(WatcherConfig{
+			URL:         nats.DefaultURL,
+			StreamName:  stream,
+			Subject:     subject,
+			DurableName: "claimcheck-test-" + id,
+			ClaimCheck:  &ClaimCheckConfig{Bucket: bucket},
+		}, func(e *cloudevents.Event) error {
+			handled <- e
+			return nil
+		})
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		require.NoError(t, watcher.Start(ctx))
+
+		large := newEvent(8192)
+		offloaded, err := claims.Offload(large)
+		require.NoError(t, err)
+		data, err := offloaded.MarshalJSON()
+		require.NoError(t, err)
+		_, err = js.Publish(subject, data)
+		require.NoError(t, err)
+
+		select {
+		case e := <-handled:
+			assert.False(t, IsClaimChecked(e))
+			assert.Equal(t, large.Data(), e.Data())
+		case <-time.After(5 * time.Second):
+			t.Fatal("event was not handled")
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package event
+
+// CloudEvents extension attributes used by Mycelium events.
+// Extension names must consist of lower-case alphanumeric characters only,
+// so these replace the older underscore names (actor_type, ...), which the
+// CloudEvents SDK rejects.
+const (
+	ExtActorType = "actortype" // Type of the actor that caused the event, e.g. user or system
+	ExtActorID   = "actorid"   // ID of the actor that caused the event
+	ExtRequestID = "requestid" // ID of the request the event belongs to
+	ExtTraceID   = "traceid"   // Trace ID used to correlate events across services
+
+	ExtCorrelationID = "correlationid" // ID of the request event a response event answers
+	ExtInvocationID  = "invocationid"  // ID of the function invocation that produced the event
+
+	ExtParentIDs  = "parentids"  // Comma-separated IDs of the events a derived event was created from, see SetLineage
+	ExtProducedBy = "producedby" // Function or trigger that created a derived event, e.g. function:resize
+
+	ExtReplay = "replay" // true on events republished by a replay
+
+	ExtClaimCheck     = "claimcheck"     // Reference to the offloaded data of a large event, see ClaimCheck
+	ExtClaimCheckSize = "claimchecksize" // Size in bytes of the offloaded data
+
+	ExtNATSStream   = "natsstream"   // JetStream stream a watcher consumed the event from, see MessageRef
+	ExtNATSSequence = "natssequence" // Stream sequence of the message, as a string since it may exceed 32 bits
+	ExtNATSConsumer = "natsconsumer" // Consumer that delivered the message
+	ExtNATSDelivery = "natsdelivery" // Delivery attempt of the message as a string, "1" for the first delivery
+)
+
+// Legacy extension names still read for compatibility with older producers
+const (
+	LegacyExtActorType = "actor_type"
+	LegacyExtActorID   = "actor_id"
+	LegacyExtRequestID = "context_request_id"
+	LegacyExtTraceID   = "context_trace_id"
+)
@@ -0,0 +1,175 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+)
+
+// Health reporting defaults
+const (
+	DefaultHealthSubject  = "triggerd.health"
+	DefaultHealthInterval = 30 * time.Second
+)
+
+// EventTypeHealth is the type of the health events a HealthReporter publishes
+const EventTypeHealth = "triggerd.health"
+
+// Health is a snapshot of a watcher's consumer health
+type Health struct {
+	Stream          string  `json:"stream"`
+	Consumer        string  `json:"consumer"`
+	ConsumerLag     uint64  `json:"consumer_lag"`     // Messages not yet delivered
+	AckPending      int     `json:"ack_pending"`      // Messages delivered but not acknowledged
+	Received        uint64  `json:"received"`         // Messages received in the interval
+	Failed          uint64  `json:"failed"`           // Messages that failed in the interval
+	Redelivered     uint64  `json:"redelivered"`      // Messages received on a redelivery in the interval
+	Poisoned        uint64  `json:"poisoned"`         // Messages routed to the poison subject in the interval
+	Invalid         uint64  `json:"invalid"`          // Messages rejected as invalid CloudEvents in the interval
+	AckFloor        uint64  `json:"ack_floor"`        // Stream sequence up to which every message is acknowledged
+	ErrorRate       float64 `json:"error_rate"`       // Failed / received in the interval
+	IntervalSeconds float64 `json:"interval_seconds"` // Length of the interval
+	// Connections holds the connection reuse counters of the action executors since
+	// startup, when the reporter was given them
+	Connections interface{} `json:"connections,omitempty"`
+	// SLA holds the SLA counters of the actions since startup, when the reporter was
+	// given them
+	SLA interface{} `json:"sla,omitempty"`
+}
+
+// HealthReporter periodically publishes a watcher's health as CloudEvents into the
+// event stream, so ordinary triggers can alert on trigger-system degradation, e.g.
+// event.type == "triggerd.health" && event.data.after.consumer_lag > 1000.
+type HealthReporter struct {
+	nc       *nats.Conn
+	watcher  *Watcher
+	subject  string
+	interval time.Duration
+	last     WatcherStats
+	// connections reports the action executors' connection counters (optional)
+	connections func() interface{}
+	// sla reports the SLA counters (optional)
+	sla func() interface{}
+}
+
+// NewHealthReporter creates a health reporter for a watcher
+func NewHealthReporter(nc *nats.Conn, watcher *Watcher, subject string, interval time.Duration) *HealthReporter {
+	if subject == "" {
+		subject = DefaultHealthSubject
+	}
+	if interval <= 0 {
+		interval = DefaultHealthInterval
+	}
+	return &HealthReporter{
+		nc:       nc,
+		watcher:  watcher,
+		subject:  subject,
+		interval: interval,
+	}
+}
+
+// SetConnectionStats makes health events carry the counters returned by fn
+func (r *HealthReporter) SetConnectionStats(fn func() interface{}) {
+	r.connections = fn
+}
+
+// SetSLAStats makes health events carry the SLA counters returned by fn
+func (r *HealthReporter) SetSLAStats(fn func() interface{}) {
+	r.sla = fn
+}
+
+// Run publishes a health event every interval until the context is cancelled
+func (r *HealthReporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Publish(); err != nil {
+				log.Printf("Error publishing health event: %v", err)
+			}
+		}
+	}
+}
+
+// Snapshot returns the watcher's health since the previous snapshot
+func (r *HealthReporter) Snapshot() (Health, error) {
+	state, err := r.watcher.Consumer()
+	if err != nil {
+		return Health{}, err
+	}
+
+	stats := r.watcher.Stats()
+	health := Health{
+		Stream:          r.watcher.config.StreamName,
+		Consumer:        r.watcher.config.DurableName,
+		ConsumerLag:     state.Pending,
+		AckPending:      state.AckPending,
+		Received:        stats.Received - r.last.Received,
+		Failed:          stats.Failed - r.last.Failed,
+		Redelivered:     stats.Redelivered - r.last.Redelivered,
+		Poisoned:        stats.Poisoned - r.last.Poisoned,
+		Invalid:         stats.Invalid - r.last.Invalid,
+		AckFloor:        state.AckFloor,
+		IntervalSeconds: r.interval.Seconds(),
+	}
+	if r.connections != nil {
+		health.Connections = r.connections()
+	}
+	if r.sla != nil {
+		health.SLA = r.sla()
+	}
+	if health.Received > 0 {
+		health.ErrorRate = float64(health.Failed) / float64(health.Received)
+	}
+	r.last = stats
+	return health, nil
+}
+
+// Publish publishes the current health snapshot
+func (r *HealthReporter) Publish() error {
+	health, err := r.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	ce, err := NewHealthEvent(health)
+	if err != nil {
+		return err
+	}
+	data, err := ce.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("failed to marshal health event: %w", err)
+	}
+	if err := r.nc.Publish(r.subject, data); err != nil {
+		return fmt.Errorf("failed to publish health event: %w", err)
+	}
+	return nil
+}
+
+// NewHealthEvent builds the CloudEvent describing a health snapshot.
+// The snapshot is carried as data.after like other Mycelium events.
+func NewHealthEvent(health Health) (*cloudevents.Event, error) {
+	ce := cloudevents.NewEvent()
+	ce.SetID(uuid.NewString())
+	ce.SetSource(fmt.Sprintf("mycelium/triggerd/%s", health.Consumer))
+	ce.SetType(EventTypeHealth)
+	ce.SetTime(time.Now())
+	ce.SetExtension(ExtActorType, "system")
+	ce.SetExtension(ExtActorID, "triggerd")
+
+	if err := ce.SetData(cloudevents.ApplicationJSON, map[string]interface{}{
+		"after": health,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to set health data: %w", err)
+	}
+	return &ce, nil
+}
@@ -0,0 +1,191 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/nats-io/nats.go"
+)
+
+// Prefixes of the producedby extension
+const (
+	ProducerFunction = "function:"
+	ProducerTrigger  = "trigger:"
+)
+
+// DefaultLineageDepth is how many generations of ancestors TraceLineage follows
+const DefaultLineageDepth = 50
+
+// ErrEventNotFound is returned when the event a lineage is traced for is not stored
+// in any of the searched streams
+var ErrEventNotFound = errors.New("event not found")
+
+// SetLineage records on a derived event the events it was created from and the
+// function or trigger that created it, e.g. ProducerFunction+"resize"
+func SetLineage(derived *cloudevents.Event, producer string, parents ...*cloudevents.Event) {
+	if derived == nil {
+		return
+	}
+	var ids []string
+	for _, parent := range parents {
+		if parent != nil && parent.ID() != "" && parent.ID() != derived.ID() {
+			ids = append(ids, parent.ID())
+		}
+	}
+	if len(ids) > 0 {
+		derived.SetExtension(ExtParentIDs, strings.Join(ids, ","))
+	}
+	if producer != "" {
+		derived.SetExtension(ExtProducedBy, producer)
+	}
+}
+
+// ParentIDs returns the IDs of the events an event was derived from
+func ParentIDs(event *cloudevents.Event) []string {
+	value, _ := event.Extensions()[ExtParentIDs].(string)
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// ProducedBy returns the function or trigger that created a derived event, if any
+func ProducedBy(event *cloudevents.Event) string {
+	value, _ := event.Extensions()[ExtProducedBy].(string)
+	return value
+}
+
+// LineageConfig configures the search of a lineage
+type LineageConfig struct {
+	// Streams are searched for the events of the chain, e.g. the event stream of a
+	// namespace and the stream capturing action results
+	Streams []string
+	// StartTime limits the search to events stored since (default: all stored events)
+	StartTime time.Time
+	// MaxDepth is how many generations of ancestors are followed (default: DefaultLineageDepth)
+	MaxDepth int
+}
+
+// LineageNode is an event of a causal chain
+type LineageNode struct {
+	ID string `json:"id"`
+	// Depth is 0 for the traced event, 1 for its parents, 2 for theirs, and so on
+	Depth      int       `json:"depth"`
+	Type       string    `json:"type,omitempty"`
+	Source     string    `json:"source,omitempty"`
+	Time       time.Time `json:"time"`
+	Parents    []string  `json:"parents,omitempty"`
+	ProducedBy string    `json:"produced_by,omitempty"`
+	Stream     string    `json:"stream,omitempty"`
+	Sequence   uint64    `json:"sequence,omitempty"`
+	// Missing is set for ancestors not stored in the searched streams, e.g. aged out
+	Missing bool `json:"missing,omitempty"`
+}
+
+// TraceLineage reconstructs the causal chain of an event from the streams it and its
+// ancestors were stored in. The nodes are returned breadth-first, the event itself
+// first; an ancestor reached over several paths is listed once, at its lowest depth.
+func TraceLineage(ctx context.Context, nc *nats.Conn, cfg LineageConfig, eventID string) ([]LineageNode, error) {
+	if len(cfg.Streams) == 0 {
+		return nil, fmt.Errorf("lineage needs at least one stream")
+	}
+	if cfg.MaxDepth <= 0 {
+		cfg.MaxDepth = DefaultLineageDepth
+	}
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get JetStream context: %w", err)
+	}
+
+	stored := make(map[string]LineageNode)
+	for _, stream := range cfg.Streams {
+		if err := indexStream(ctx, js, stream, cfg.StartTime, stored); err != nil {
+			return nil, err
+		}
+	}
+
+	root, ok := stored[eventID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrEventNotFound, eventID)
+	}
+	chain := []LineageNode{root}
+	seen := map[string]bool{eventID: true}
+	for i := 0; i < len(chain); i++ {
+		node := chain[i]
+		if node.Depth >= cfg.MaxDepth {
+			continue
+		}
+		for _, parentID := range node.Parents {
+			if seen[parentID] {
+				continue
+			}
+			seen[parentID] = true
+			parent, ok := stored[parentID]
+			if !ok {
+				parent = LineageNode{ID: parentID, Missing: true}
+			}
+			parent.Depth = node.Depth + 1
+			chain = append(chain, parent)
+		}
+	}
+	return chain, nil
+}
+
+// indexStream records the stored events of a stream by ID. Events republished by a
+// replay keep their ID, so only the first copy is recorded.
+func indexStream(ctx context.Context, js nats.JetStreamContext, stream string, since time.Time, stored map[string]LineageNode) error {
+	start := []nats.SubOpt{nats.BindStream(stream), nats.OrderedConsumer()}
+	if since.IsZero() {
+		start = append(start, nats.DeliverAll())
+	} else {
+		start = append(start, nats.StartTime(since))
+	}
+	sub, err := js.SubscribeSync(">", start...)
+	if err != nil {
+		return fmt.Errorf("failed to read stream %s: %w", stream, err)
+	}
+	defer sub.Unsubscribe()
+
+	// Nothing is stored after the start position
+	consumer, err := sub.ConsumerInfo()
+	if err != nil {
+		return fmt.Errorf("failed to get consumer info: %w", err)
+	}
+	done := consumer.NumPending == 0 && consumer.Delivered.Consumer == 0
+
+	for !done {
+		msg, err := sub.NextMsgWithContext(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read stream %s: %w", stream, err)
+		}
+		meta, err := msg.Metadata()
+		if err != nil {
+			return fmt.Errorf("failed to get message metadata: %w", err)
+		}
+		done = meta.NumPending == 0
+
+		ce := cloudevents.NewEvent()
+		if err := json.Unmarshal(msg.Data, &ce); err != nil || ce.ID() == "" {
+			continue
+		}
+		if _, ok := stored[ce.ID()]; ok {
+			continue
+		}
+		stored[ce.ID()] = LineageNode{
+			ID:         ce.ID(),
+			Type:       ce.Type(),
+			Source:     ce.Source(),
+			Time:       ce.Time(),
+			Parents:    ParentIDs(&ce),
+			ProducedBy: ProducedBy(&ce),
+			Stream:     stream,
+			Sequence:   meta.Sequence.Stream,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTraceLineage tests that derived events carry their lineage and that the causal
+// chain of an event is reconstructed from the streams it spans
+func TestTraceLineage(t *testing.T) {
+	newEvent := func(id string) *cloudevents.Event {
+		ce := cloudevents.NewEvent()
+		ce.SetID(id)
+		ce.SetSource("test")
+		ce.SetType("lineage.test")
+		return &ce
+	}
+
+	upload := newEvent("upload")
+	resized := newEvent("resized")
+	SetLineage(resized, ProducerFunction+"resize", upload)
+	indexed := newEvent("indexed")
+	SetLineage(indexed, ProducerTrigger+"index", resized, newEvent("expired"), upload)
+	assert.Equal(t, []string{"upload"}, ParentIDs(resized))
+	assert.Equal(t, "function:resize", ProducedBy(resized))
+	assert.Nil(t, ParentIDs(upload))
+
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	if _, err := js.AccountInfo(); err != nil {
+		t.Skip("JetStream not available, skipping integration test")
+	}
+
+	// Source events and derived events are kept in separate streams
+	id := uuid.NewString()[:8]
+	streams := []string{"lineage-events-" + id, "lineage-results-" + id}
+	for i, stream := range streams {
+		_, err := js.AddStream(&nats.StreamConfig{Name: stream, Subjects: []string{stream + ".>"}})
+		require.NoError(t, err)
+		defer js.DeleteStream(streams[i])
+	}
+	publish := func(stream string, ce *cloudevents.Event) {
+		data, err := json.Marshal(ce)
+		require.NoError(t, err)
+		_, err = js.Publish(stream+".event", data)
+		require.NoError(t, err)
+	}
+	publish(streams[0], upload)
+	publish(streams[1], resized)
+	publish(streams[1], indexed)
+
+	ctx := context.Background()
+	chain, err := TraceLineage(ctx, nc, LineageConfig{Streams: streams}, "indexed")
+	require.NoError(t, err)
+	require.Len(t, chain, 4)
+	assert.Equal(t, "indexed", chain[0].ID)
+	assert.Equal(t, "trigger:index", chain[0].ProducedBy)
+	assert.Equal(t, "resized", chain[1].ID)
+	assert.Equal(t, 1, chain[1].Depth)
+	assert.True(t, chain[2].Missing)
+	assert.Equal(t, "upload", chain[3].ID)
+	assert.Equal(t, 1, chain[3].Depth)
+	assert.Equal(t, streams[0], chain[3].Stream)
+
+	chain, err = TraceLineage(ctx, nc, LineageConfig{Streams: streams}, "resized")
+	require.NoError(t, err)
+	require.Len(t, chain, 2)
+	assert.Equal(t, "upload", chain[1].ID)
+
+	_, err = TraceLineage(ctx, nc, LineageConfig{Streams: streams}, "unknown")
+	assert.ErrorIs(t, err, ErrEventNotFound)
+}
@@ -0,0 +1,84 @@
+package event
+
+import (
+	"fmt"
+	"strconv"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/types"
+	"github.com/nats-io/nats.go"
+)
+
+// MessageRef identifies the JetStream message an event was consumed from, so an alert
+// about the event leads to the exact message, e.g. nats stream get <stream> <sequence>
+type MessageRef struct {
+	Stream   string `json:"stream"`
+	Sequence uint64 `json:"sequence"`
+	Consumer string `json:"consumer,omitempty"`
+	Delivery uint64 `json:"delivery,omitempty"`
+}
+
+// String returns the reference as stream#sequence
+func (r MessageRef) String() string {
+	return fmt.Sprintf("%s#%d", r.Stream, r.Sequence)
+}
+
+// Details returns the reference as audit record details
+func (r MessageRef) Details() map[string]string {
+	return map[string]string{
+		"stream":          r.Stream,
+		"stream_sequence": strconv.FormatUint(r.Sequence, 10),
+		"consumer":        r.Consumer,
+		"delivery":        strconv.FormatUint(r.Delivery, 10),
+	}
+}
+
+// messageRefOf returns the reference of a JetStream message; ok is false for core NATS messages
+func messageRefOf(msg *nats.Msg) (MessageRef, bool) {
+	meta, err := msg.Metadata()
+	if err != nil {
+		return MessageRef{}, false
+	}
+	return MessageRef{
+		Stream:   meta.Stream,
+		Sequence: meta.Sequence.Stream,
+		Consumer: meta.Consumer,
+		Delivery: meta.NumDelivered,
+	}, true
+}
+
+// SetMessageRef records the message an event was consumed from in its extensions
+func SetMessageRef(event *cloudevents.Event, ref MessageRef) {
+	event.SetExtension(ExtNATSStream, ref.Stream)
+	event.SetExtension(ExtNATSSequence, strconv.FormatUint(ref.Sequence, 10))
+	if ref.Consumer != "" {
+		event.SetExtension(ExtNATSConsumer, ref.Consumer)
+	}
+	if ref.Delivery > 0 {
+		event.SetExtension(ExtNATSDelivery, strconv.FormatUint(ref.Delivery, 10))
+	}
+}
+
+// MessageRefOf returns the message an event was consumed from, as recorded by the
+// watcher; ok is false for events that carry no reference
+func MessageRefOf(event *cloudevents.Event) (MessageRef, bool) {
+	if event == nil {
+		return MessageRef{}, false
+	}
+	extensions := event.Extensions()
+	stream, _ := types.ToString(extensions[ExtNATSStream])
+	sequence, _ := types.ToString(extensions[ExtNATSSequence])
+	if stream == "" || sequence == "" {
+		return MessageRef{}, false
+	}
+	ref := MessageRef{Stream: stream}
+	var err error
+	if ref.Sequence, err = strconv.ParseUint(sequence, 10, 64); err != nil {
+		return MessageRef{}, false
+	}
+	ref.Consumer, _ = types.ToString(extensions[ExtNATSConsumer])
+	if delivery, err := types.ToString(extensions[ExtNATSDelivery]); err == nil {
+		ref.Delivery, _ = strconv.ParseUint(delivery, 10, 64)
+	}
+	return ref, true
+}
@@ -0,0 +1,91 @@
+package event
+
+import (
+	"fmt"
+	"time"
+)
+
+// Message outcomes reported to a MetricsCollector
+const (
+	// OutcomeAck is a handled message, acknowledged in JetStream mode
+	OutcomeAck = "ack"
+	// OutcomeNak is a message that could not be parsed or whose handler failed; in
+	// JetStream mode it is redelivered, core NATS messages are dropped
+	OutcomeNak = "nak"
+	// OutcomePoison is a message whose last delivery attempt failed and that was
+	// routed to the watcher's poison subject instead of being redelivered
+	OutcomePoison = "poison"
+	// OutcomeInvalid is a message that is not a valid CloudEvent; it is routed to the
+	// poison subject, if any, and terminated rather than redelivered
+	OutcomeInvalid = "invalid"
+)
+
+// MetricsCollector receives a watcher's message metrics, e.g. to export them to a
+// monitoring system. Methods are called from the message handler and must not block.
+type MetricsCollector interface {
+	// RecordMessageReceived records a received message and its delivery attempt,
+	// 1 for the first delivery and more for redeliveries
+	RecordMessageReceived(subject string, delivery uint64)
+	// RecordMessageHandled records how long handling a message took and its outcome,
+	// OutcomeAck, OutcomeNak, OutcomePoison or OutcomeInvalid
+	RecordMessageHandled(subject string, duration time.Duration, outcome string)
+}
+
+// ExemplarCollector is a MetricsCollector that also receives the JetStream message of
+// every handled message, e.g. to attach it as an exemplar to the handling duration so
+// operators can jump from a latency spike or error rate to the message. Core NATS
+// messages have no reference and are only recorded with RecordMessageHandled.
+type ExemplarCollector interface {
+	MetricsCollector
+	RecordMessageExemplar(subject string, ref MessageRef, duration time.Duration, outcome string)
+}
+
+// ConsumerState is the state of a watcher's consumer as the server reports it
+type ConsumerState struct {
+	Stream   string `json:"stream,omitempty"`
+	Consumer string `json:"consumer,omitempty"`
+	// Pending counts stream messages not yet delivered; core watchers report the
+	// messages buffered by their subscription
+	Pending uint64 `json:"pending"`
+	// AckPending counts delivered messages awaiting acknowledgement, Redelivered
+	// those of them delivered more than once
+	AckPending  int `json:"ack_pending"`
+	Redelivered int `json:"redelivered"`
+	// Delivered is the stream sequence of the last delivered message and AckFloor the
+	// one up to which every message is acknowledged
+	Delivered uint64 `json:"delivered"`
+	AckFloor  uint64 `json:"ack_floor"`
+	// LastActive is when the last message was delivered, nil if none was
+	LastActive *time.Time `json:"last_active,omitempty"`
+}
+
+// Consumer returns the state of the watcher's consumer, so daemons can report lag
+// without asking the server themselves. Core watchers only report Pending.
+func (w *Watcher) Consumer() (ConsumerState, error) {
+	if w.sub == nil {
+		return ConsumerState{}, fmt.Errorf("watcher not started")
+	}
+	if w.config.Core {
+		msgs, _, err := w.sub.Pending()
+		if err != nil {
+			return ConsumerState{}, fmt.Errorf("failed to get pending messages: %w", err)
+		}
+		return ConsumerState{Pending: uint64(msgs)}, nil
+	}
+
+	info, err := w.sub.ConsumerInfo()
+	if err != nil {
+		return ConsumerState{}, fmt.Errorf("failed to get consumer info: %w", err)
+	}
+	state := ConsumerState{
+		Stream:      info.Stream,
+		Consumer:    info.Name,
+		Pending:     info.NumPending,
+		AckPending:  info.NumAckPending,
+		Redelivered: info.NumRedelivered,
+		Delivered:   info.Delivered.Stream,
+		AckFloor:    info.AckFloor.Stream,
+		LastActive:  info.Delivered.Last,
+	}
+	return state, nil
+}
@@ -0,0 +1,61 @@
+package event
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Transport modes
+const (
+	// ModeAuto uses JetStream when the server offers it and core NATS otherwise
+	ModeAuto = "auto"
+	// ModeJetStream consumes events from streams and keeps state in KV buckets
+	ModeJetStream = "jetstream"
+	// ModeCore runs on a bare NATS server: plain subscriptions, request/reply and
+	// file-based triggers and functions
+	ModeCore = "core"
+)
+
+// JetStreamEnabled reports whether the server offers JetStream to the connection's account
+func JetStreamEnabled(nc *nats.Conn) (bool, error) {
+	js, err := nc.JetStream()
+	if err != nil {
+		return false, fmt.Errorf("failed to get JetStream context: %w", err)
+	}
+	_, err = js.AccountInfo()
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, nats.ErrJetStreamNotEnabled),
+		errors.Is(err, nats.ErrJetStreamNotEnabledForAccount),
+		errors.Is(err, nats.ErrNoResponders):
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to get JetStream account info: %w", err)
+}
+
+// ResolveMode turns ModeAuto into ModeJetStream or ModeCore by asking the server.
+// An explicit ModeJetStream fails when the server does not offer JetStream.
+func ResolveMode(nc *nats.Conn, mode string) (string, error) {
+	switch mode {
+	case ModeCore:
+		return ModeCore, nil
+	case "", ModeAuto, ModeJetStream:
+	default:
+		return "", fmt.Errorf("unknown mode %q (expected %s, %s or %s)", mode, ModeAuto, ModeJetStream, ModeCore)
+	}
+
+	enabled, err := JetStreamEnabled(nc)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case enabled:
+		return ModeJetStream, nil
+	case mode == ModeJetStream:
+		return "", fmt.Errorf("JetStream is not enabled on the server")
+	}
+	return ModeCore, nil
+}
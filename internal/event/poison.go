@@ -0,0 +1,112 @@
+package event
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// DefaultPoisonSubject is the subject triggerd routes poison messages to
+const DefaultPoisonSubject = "triggerd.poison"
+
+// Headers of messages routed to a poison subject, carrying the context of the failure
+// next to the original headers and data
+const (
+	PoisonHeaderError      = "Mycelium-Poison-Error"
+	PoisonHeaderSubject    = "Mycelium-Poison-Subject"
+	PoisonHeaderStream     = "Mycelium-Poison-Stream"
+	PoisonHeaderConsumer   = "Mycelium-Poison-Consumer"
+	PoisonHeaderSequence   = "Mycelium-Poison-Sequence"
+	PoisonHeaderDeliveries = "Mycelium-Poison-Deliveries"
+	PoisonHeaderTime       = "Mycelium-Poison-Time"
+	// PoisonHeaderViolations holds the JSON violation list of an invalid CloudEvent
+	PoisonHeaderViolations = "Mycelium-Poison-Violations"
+)
+
+// findPoisonStream records whether a stream captures the poison subject, so poison
+// messages are published with JetStream acknowledgements only when one does. Without
+// one, the configured poison stream is created.
+func (w *Watcher) findPoisonStream() error {
+	if w.config.PoisonSubject == "" || w.config.Core {
+		return nil
+	}
+	_, err := w.js.StreamNameBySubject(w.config.PoisonSubject)
+	if errors.Is(err, nats.ErrNoMatchingStream) {
+		if w.config.PoisonStream == "" {
+			return nil
+		}
+		_, err = w.js.AddStream(&nats.StreamConfig{
+			Name:     w.config.PoisonStream,
+			Subjects: []string{w.config.PoisonSubject},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create poison stream: %w", err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to look up the stream of the poison subject: %w", err)
+	}
+	w.poisonStored = true
+	return nil
+}
+
+// poisoned reports whether a failed delivery is the last one, after which the message
+// goes to the poison subject instead of being redelivered
+func (w *Watcher) poisoned(delivery uint64) bool {
+	if w.config.PoisonSubject == "" {
+		return false
+	}
+	if w.config.Core {
+		// Core NATS never redelivers
+		return true
+	}
+	return w.config.MaxDeliveries > 0 && delivery >= uint64(w.config.MaxDeliveries)
+}
+
+// poison publishes a message to the poison subject with the context of its failure.
+// When a stream captures the poison subject, the message is only terminated once the
+// stream stored the copy; otherwise the copy only reaches current subscribers.
+func (w *Watcher) poison(msg *nats.Msg, delivery uint64, cause error) error {
+	poisoned := nats.NewMsg(w.config.PoisonSubject)
+	poisoned.Data = msg.Data
+	for name, values := range msg.Header {
+		poisoned.Header[name] = append([]string(nil), values...)
+	}
+	poisoned.Header.Set(PoisonHeaderError, strings.Join(strings.Fields(cause.Error()), " "))
+	poisoned.Header.Set(PoisonHeaderSubject, msg.Subject)
+	poisoned.Header.Set(PoisonHeaderDeliveries, strconv.FormatUint(delivery, 10))
+	poisoned.Header.Set(PoisonHeaderTime, time.Now().UTC().Format(time.RFC3339Nano))
+	var invalid *InvalidEventError
+	if errors.As(cause, &invalid) {
+		if violations, err := json.Marshal(invalid.Violations); err == nil {
+			poisoned.Header.Set(PoisonHeaderViolations, string(violations))
+		}
+	}
+
+	if w.config.Core {
+		if err := w.conn.PublishMsg(poisoned); err != nil {
+			return fmt.Errorf("failed to publish poison message: %w", err)
+		}
+		return nil
+	}
+
+	if meta, err := msg.Metadata(); err == nil {
+		poisoned.Header.Set(PoisonHeaderStream, meta.Stream)
+		poisoned.Header.Set(PoisonHeaderConsumer, meta.Consumer)
+		poisoned.Header.Set(PoisonHeaderSequence, strconv.FormatUint(meta.Sequence.Stream, 10))
+	}
+	var err error
+	if w.poisonStored {
+		_, err = w.js.PublishMsg(poisoned)
+	} else {
+		err = w.conn.PublishMsg(poisoned)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to publish poison message: %w", err)
+	}
+	return nil
+}
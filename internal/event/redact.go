@@ -0,0 +1,149 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultRedactionReplacement replaces redacted values
+const DefaultRedactionReplacement = "[REDACTED]"
+
+// RedactionRule lists the fields redacted for matching event types
+type RedactionRule struct {
+	// EventTypes are glob patterns of the event types the rule applies to, empty applies to all
+	EventTypes []string `yaml:"event_types,omitempty" json:"event_types,omitempty"`
+	// Fields are dot-separated paths into the event, e.g. data.after.email or extensions.actorid.
+	// A "*" segment matches every key or array element, e.g. data.*.ssn covers before and after.
+	Fields []string `yaml:"fields" json:"fields"`
+}
+
+// RedactionPolicy strips sensitive fields from events before they are logged, audited or
+// archived. Redaction works on a copy, so trigger matching still sees the full event.
+type RedactionPolicy struct {
+	Replacement string          `yaml:"replacement,omitempty" json:"replacement,omitempty"`
+	Rules       []RedactionRule `yaml:"rules" json:"rules"`
+}
+
+// LoadRedactionPolicy reads a redaction policy from a YAML file
+func LoadRedactionPolicy(file string) (*RedactionPolicy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read redaction policy: %w", err)
+	}
+
+	var policy RedactionPolicy
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse redaction policy: %w", err)
+	}
+	for i, rule := range policy.Rules {
+		for _, pattern := range rule.EventTypes {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("rule %d: invalid event type pattern %q: %w", i, pattern, err)
+			}
+		}
+		for _, field := range rule.Fields {
+			root, _, _ := strings.Cut(field, ".")
+			if (root != "data" && root != "extensions") || !strings.Contains(field, ".") {
+				return nil, fmt.Errorf("rule %d: field %q must start with data. or extensions.", i, field)
+			}
+		}
+	}
+	return &policy, nil
+}
+
+// fields returns the field paths redacted for an event type
+func (p *RedactionPolicy) fields(eventType string) []string {
+	var fields []string
+	for _, rule := range p.Rules {
+		if len(rule.EventTypes) == 0 {
+			fields = append(fields, rule.Fields...)
+			continue
+		}
+		for _, pattern := range rule.EventTypes {
+			if matched, _ := path.Match(pattern, eventType); matched {
+				fields = append(fields, rule.Fields...)
+				break
+			}
+		}
+	}
+	return fields
+}
+
+// Redact returns a copy of the event with the policy's fields replaced.
+// The event itself is returned when no rule applies or the policy is nil.
+func (p *RedactionPolicy) Redact(e *cloudevents.Event) *cloudevents.Event {
+	if p == nil || e == nil {
+		return e
+	}
+	fields := p.fields(e.Type())
+	if len(fields) == 0 {
+		return e
+	}
+
+	replacement := p.Replacement
+	if replacement == "" {
+		replacement = DefaultRedactionReplacement
+	}
+
+	redacted := e.Clone()
+	var data interface{}
+	dataRedacted := false
+	dataParsed := len(e.Data()) > 0 && json.Unmarshal(e.Data(), &data) == nil
+
+	for _, field := range fields {
+		root, rest, _ := strings.Cut(field, ".")
+		switch root {
+		case "extensions":
+			if _, ok := redacted.Extensions()[rest]; ok {
+				redacted.SetExtension(rest, replacement)
+			}
+		case "data":
+			if dataParsed {
+				data = redactPath(data, strings.Split(rest, "."), replacement)
+				dataRedacted = true
+			} else if len(e.Data()) > 0 {
+				// Data that cannot be inspected is dropped rather than leaked
+				redacted.SetData(cloudevents.TextPlain, replacement)
+			}
+		}
+	}
+
+	if dataRedacted {
+		if err := redacted.SetData(cloudevents.ApplicationJSON, data); err != nil {
+			redacted.SetData(cloudevents.TextPlain, replacement)
+		}
+	}
+	return &redacted
+}
+
+// redactPath replaces the value at a path inside decoded JSON
+func redactPath(value interface{}, segments []string, replacement string) interface{} {
+	if len(segments) == 0 {
+		return replacement
+	}
+
+	segment, rest := segments[0], segments[1:]
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if segment == "*" {
+			for key, child := range v {
+				v[key] = redactPath(child, rest, replacement)
+			}
+		} else if child, ok := v[segment]; ok {
+			v[segment] = redactPath(child, rest, replacement)
+		}
+	case []interface{}:
+		for i, child := range v {
+			if segment == "*" || segment == fmt.Sprint(i) {
+				v[i] = redactPath(child, rest, replacement)
+			}
+		}
+	}
+	return value
+}
@@ -0,0 +1,67 @@
+package event
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRedactionPolicy tests that configured fields are redacted on a copy of the event
+func TestRedactionPolicy(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "redaction.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(`
+rules:
+  - event_types: ["*.user.*"]
+    fields: ["data.*.email", "data.after.addresses.*.street", "extensions.actorid"]
+`), 0644))
+	policy, err := LoadRedactionPolicy(file)
+	require.NoError(t, err)
+
+	e := cloudevents.NewEvent()
+	e.SetID("event-1")
+	e.SetSource("test")
+	e.SetType("default.user.updated")
+	e.SetExtension(ExtActorID, "alice")
+	require.NoError(t, e.SetData(cloudevents.ApplicationJSON, map[string]interface{}{
+		"before": map[string]interface{}{"email": "old@example.com", "role": "user"},
+		"after": map[string]interface{}{
+			"email":     "new@example.com",
+			"role":      "admin",
+			"addresses": []interface{}{map[string]interface{}{"street": "Main St 1", "city": "Berlin"}},
+		},
+	}))
+	original := string(e.Data())
+
+	redacted := policy.Redact(&e)
+
+	var data map[string]map[string]interface{}
+	require.NoError(t, json.Unmarshal(redacted.Data(), &data))
+	assert.Equal(t, DefaultRedactionReplacement, data["before"]["email"])
+	assert.Equal(t, DefaultRedactionReplacement, data["after"]["email"])
+	assert.Equal(t, "admin", data["after"]["role"])
+	address := data["after"]["addresses"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, DefaultRedactionReplacement, address["street"])
+	assert.Equal(t, "Berlin", address["city"])
+	assert.Equal(t, DefaultRedactionReplacement, redacted.Extensions()[ExtActorID])
+
+	// The original event keeps its data for matching
+	assert.Equal(t, original, string(e.Data()))
+	assert.Equal(t, "alice", e.Extensions()[ExtActorID])
+
+	// Other event types are untouched
+	e.SetType("default.order.created")
+	assert.Same(t, &e, policy.Redact(&e))
+}
+
+// TestLoadRedactionPolicyRejectsUnknownFields tests field path validation
+func TestLoadRedactionPolicyRejectsUnknownFields(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "redaction.yaml")
+	require.NoError(t, os.WriteFile(file, []byte("rules:\n  - fields: [\"payload.email\"]\n"), 0644))
+	_, err := LoadRedactionPolicy(file)
+	assert.Error(t, err)
+}
@@ -0,0 +1,129 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// RedriveConfig configures a re-drive of the poison messages stored in a stream
+type RedriveConfig struct {
+	// Stream captures the poison subject, see WatcherConfig.PoisonStream
+	Stream string
+	// Subject filters the re-driven messages (default: all subjects of the stream)
+	Subject string
+	// Keep leaves re-driven messages in the stream instead of deleting them
+	Keep bool
+}
+
+// RedriveStats summarizes a re-drive
+type RedriveStats struct {
+	Redriven uint64
+	// Skipped counts the messages without the subject they failed on, which are kept
+	Skipped       uint64
+	FirstSequence uint64
+	LastSequence  uint64
+}
+
+// Redrive publishes the poison messages stored in a stream back to the subjects they
+// failed on, without the PoisonHeader* headers, so the watchers handle them again once
+// the cause of the failure is fixed. Only messages stored when the re-drive started
+// are re-driven, so messages failing again are kept for the next one. Re-driven
+// messages are deleted from the stream unless Keep is set.
+func Redrive(ctx context.Context, nc *nats.Conn, cfg RedriveConfig) (RedriveStats, error) {
+	var stats RedriveStats
+	if cfg.Stream == "" {
+		return stats, fmt.Errorf("redrive needs a stream")
+	}
+	if cfg.Subject == "" {
+		cfg.Subject = ">"
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		return stats, fmt.Errorf("failed to get JetStream context: %w", err)
+	}
+	info, err := js.StreamInfo(cfg.Stream)
+	if err != nil {
+		return stats, fmt.Errorf("failed to get stream info: %w", err)
+	}
+	end := info.State.LastSeq
+
+	sub, err := js.SubscribeSync(cfg.Subject, nats.BindStream(cfg.Stream), nats.OrderedConsumer(), nats.DeliverAll())
+	if err != nil {
+		return stats, fmt.Errorf("failed to subscribe to %s: %w", cfg.Subject, err)
+	}
+	defer sub.Unsubscribe()
+
+	// Nothing is stored
+	consumer, err := sub.ConsumerInfo()
+	if err != nil {
+		return stats, fmt.Errorf("failed to get consumer info: %w", err)
+	}
+	done := consumer.NumPending == 0 && consumer.Delivered.Consumer == 0
+
+	for !done {
+		msg, err := sub.NextMsgWithContext(ctx)
+		if err != nil {
+			return stats, err
+		}
+		meta, err := msg.Metadata()
+		if err != nil {
+			return stats, fmt.Errorf("failed to get message metadata: %w", err)
+		}
+		sequence := meta.Sequence.Stream
+		if sequence > end {
+			break
+		}
+		done = meta.NumPending == 0
+
+		if stats.FirstSequence == 0 {
+			stats.FirstSequence = sequence
+		}
+		stats.LastSequence = sequence
+
+		redriven := redriveMsg(msg)
+		if redriven == nil {
+			stats.Skipped++
+			continue
+		}
+		// Messages from a stream go back through JetStream, core NATS ones are only published
+		if msg.Header.Get(PoisonHeaderStream) != "" {
+			_, err = js.PublishMsg(redriven, nats.Context(ctx))
+		} else {
+			err = nc.PublishMsg(redriven)
+		}
+		if err != nil {
+			return stats, fmt.Errorf("failed to re-drive message %d to %s: %w", sequence, redriven.Subject, err)
+		}
+		stats.Redriven++
+
+		if !cfg.Keep {
+			if err := js.DeleteMsg(cfg.Stream, sequence); err != nil {
+				return stats, fmt.Errorf("failed to delete re-driven message %d: %w", sequence, err)
+			}
+		}
+	}
+	return stats, nil
+}
+
+// redriveMsg returns the message a poison message was made from, nil when it does
+// not carry the subject it failed on
+func redriveMsg(msg *nats.Msg) *nats.Msg {
+	subject := msg.Header.Get(PoisonHeaderSubject)
+	if subject == "" {
+		return nil
+	}
+	redriven := nats.NewMsg(subject)
+	redriven.Data = msg.Data
+	for name, values := range msg.Header {
+		// The original message ID would be dropped as a duplicate within the stream's window
+		if strings.HasPrefix(name, "Mycelium-Poison-") || name == nats.MsgIdHdr {
+			continue
+		}
+		redriven.Header[name] = append([]string(nil), values...)
+	}
+	return redriven
+}
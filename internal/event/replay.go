@@ -0,0 +1,226 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/types"
+	"github.com/nats-io/nats.go"
+)
+
+// Replay defaults
+const (
+	DefaultReplayBucket          = "replay-checkpoints"
+	DefaultReplayCheckpointEvery = 100
+)
+
+// ReplayConfig configures a replay of the events stored in a stream
+type ReplayConfig struct {
+	Stream string
+	// Subject filters the replayed events (default: all subjects of the stream)
+	Subject string
+	// Name is the key of the replay's checkpoint; running a replay under the name of
+	// an interrupted one resumes it
+	Name string
+	// StartTime or StartSequence select the first event of a new replay (default: the
+	// first stored event); they are ignored when resuming
+	StartTime     time.Time
+	StartSequence uint64
+	// Bucket is the KV bucket holding checkpoints (default: DefaultReplayBucket)
+	Bucket string
+	// CheckpointEvery is how many replayed events a checkpoint is written after
+	// (default: DefaultReplayCheckpointEvery)
+	CheckpointEvery int
+}
+
+// ReplayCheckpoint records the progress of a replay
+type ReplayCheckpoint struct {
+	Stream       string    `json:"stream"`
+	Subject      string    `json:"subject"`
+	LastSequence uint64    `json:"last_sequence"` // Last replayed stream sequence
+	EndSequence  uint64    `json:"end_sequence"`  // Last stream sequence when the replay started
+	Replayed     uint64    `json:"replayed"`      // Events replayed over all runs
+	Updated      time.Time `json:"updated"`
+}
+
+// ReplayStats summarizes a replay run
+type ReplayStats struct {
+	Resumed  bool
+	Replayed uint64 // Events replayed in this run
+	Skipped  uint64 // Messages that are not CloudEvents or were republished by earlier replays
+	// Expired counts the stream sequences of the range that retention removed before
+	// they were replayed
+	Expired       uint64
+	FirstSequence uint64
+	LastSequence  uint64
+}
+
+// IsReplay reports whether an event was republished by a replay
+func IsReplay(event *cloudevents.Event) bool {
+	value, ok := event.Extensions()[ExtReplay]
+	if !ok {
+		return false
+	}
+	replay, err := types.ToBool(value)
+	return err == nil && replay
+}
+
+// Replay republishes stored events to their subjects, marked with the replay
+// extension, so the trigger daemons process them again. Only original events stored
+// when the replay started are replayed. Progress is checkpointed in KV: when the
+// replay is interrupted, e.g. by cancelling ctx, running it again under the same name
+// resumes after the last replayed event. The checkpoint is removed once the replay
+// completes.
+func Replay(ctx context.Context, nc *nats.Conn, cfg ReplayConfig) (ReplayStats, error) {
+	var stats ReplayStats
+	if cfg.Stream == "" || cfg.Name == "" {
+		return stats, fmt.Errorf("replay needs a stream and a name")
+	}
+	if cfg.Subject == "" {
+		cfg.Subject = ">"
+	}
+	if cfg.Bucket == "" {
+		cfg.Bucket = DefaultReplayBucket
+	}
+	if cfg.CheckpointEvery <= 0 {
+		cfg.CheckpointEvery = DefaultReplayCheckpointEvery
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		return stats, fmt.Errorf("failed to get JetStream context: %w", err)
+	}
+	kv, err := js.KeyValue(cfg.Bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{Bucket: cfg.Bucket})
+	}
+	if err != nil {
+		return stats, fmt.Errorf("failed to get checkpoint bucket: %w", err)
+	}
+	info, err := js.StreamInfo(cfg.Stream)
+	if err != nil {
+		return stats, fmt.Errorf("failed to get stream info: %w", err)
+	}
+
+	// Resume from the checkpoint of an interrupted replay
+	checkpoint := ReplayCheckpoint{Stream: cfg.Stream, Subject: cfg.Subject, EndSequence: info.State.LastSeq}
+	start := []nats.SubOpt{nats.BindStream(cfg.Stream), nats.OrderedConsumer()}
+	entry, err := kv.Get(cfg.Name)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(entry.Value(), &checkpoint); err != nil {
+			return stats, fmt.Errorf("invalid checkpoint %s: %w", cfg.Name, err)
+		}
+		if checkpoint.Stream != cfg.Stream || checkpoint.Subject != cfg.Subject {
+			return stats, fmt.Errorf("checkpoint %s belongs to a replay of %s on %s", cfg.Name, checkpoint.Subject, checkpoint.Stream)
+		}
+		stats.Resumed = true
+		cfg.StartSequence = checkpoint.LastSequence + 1
+		start = append(start, nats.StartSequence(cfg.StartSequence))
+	case errors.Is(err, nats.ErrKeyNotFound):
+		if cfg.StartSequence > 0 {
+			start = append(start, nats.StartSequence(cfg.StartSequence))
+		} else if !cfg.StartTime.IsZero() {
+			start = append(start, nats.StartTime(cfg.StartTime))
+		} else {
+			start = append(start, nats.DeliverAll())
+		}
+	default:
+		return stats, fmt.Errorf("failed to get checkpoint %s: %w", cfg.Name, err)
+	}
+
+	// Events of the range may have aged out of the stream since the replay started
+	if cfg.StartSequence > 0 && cfg.StartSequence < info.State.FirstSeq {
+		stats.Expired = min(info.State.FirstSeq, checkpoint.EndSequence+1) - cfg.StartSequence
+	}
+
+	save := func() error {
+		checkpoint.Updated = time.Now()
+		data, err := json.Marshal(checkpoint)
+		if err != nil {
+			return err
+		}
+		if _, err := kv.Put(cfg.Name, data); err != nil {
+			return fmt.Errorf("failed to save checkpoint: %w", err)
+		}
+		return nil
+	}
+
+	sub, err := js.SubscribeSync(cfg.Subject, start...)
+	if err != nil {
+		return stats, fmt.Errorf("failed to subscribe to %s: %w", cfg.Subject, err)
+	}
+	defer sub.Unsubscribe()
+
+	// Nothing is stored after the start position
+	consumer, err := sub.ConsumerInfo()
+	if err != nil {
+		return stats, fmt.Errorf("failed to get consumer info: %w", err)
+	}
+	done := consumer.NumPending == 0 && consumer.Delivered.Consumer == 0
+
+	unsaved := 0
+	for !done {
+		msg, err := sub.NextMsgWithContext(ctx)
+		if err != nil {
+			if ctx.Err() != nil && stats.Replayed > 0 {
+				if err := save(); err != nil {
+					return stats, err
+				}
+			}
+			return stats, err
+		}
+		meta, err := msg.Metadata()
+		if err != nil {
+			return stats, fmt.Errorf("failed to get message metadata: %w", err)
+		}
+
+		// Events stored after the replay started, including the replayed ones, are live traffic
+		sequence := meta.Sequence.Stream
+		if sequence > checkpoint.EndSequence {
+			break
+		}
+		done = meta.NumPending == 0
+
+		ce := cloudevents.NewEvent()
+		if err := json.Unmarshal(msg.Data, &ce); err != nil || IsReplay(&ce) {
+			stats.Skipped++
+		} else {
+			ce.SetExtension(ExtReplay, true)
+			data, err := json.Marshal(ce)
+			if err != nil {
+				return stats, fmt.Errorf("failed to marshal event %s: %w", ce.ID(), err)
+			}
+			if _, err := js.Publish(msg.Subject, data, nats.Context(ctx)); err != nil {
+				if stats.Replayed > 0 {
+					save()
+				}
+				return stats, fmt.Errorf("failed to republish event %s: %w", ce.ID(), err)
+			}
+			stats.Replayed++
+			checkpoint.Replayed++
+			unsaved++
+		}
+
+		if stats.FirstSequence == 0 {
+			stats.FirstSequence = sequence
+		}
+		stats.LastSequence = sequence
+		checkpoint.LastSequence = sequence
+		if unsaved >= cfg.CheckpointEvery {
+			if err := save(); err != nil {
+				return stats, err
+			}
+			unsaved = 0
+		}
+	}
+
+	if err := kv.Delete(cfg.Name); err != nil && !errors.Is(err, nats.ErrKeyNotFound) {
+		return stats, fmt.Errorf("failed to remove checkpoint: %w", err)
+	}
+	return stats, nil
+}
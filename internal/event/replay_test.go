@@ -0,0 +1,87 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestReplayResumesFromCheckpoint tests that a replay resumes after its checkpoint,
+// marks republished events and removes the checkpoint when it completes
+func TestReplayResumesFromCheckpoint(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	if _, err := js.AccountInfo(); err != nil {
+		t.Skip("JetStream not available, skipping integration test")
+	}
+
+	id := uuid.NewString()[:8]
+	stream := "replay-test-" + id
+	subject := "replaytest." + id + ".>"
+	_, err = js.AddStream(&nats.StreamConfig{Name: stream, Subjects: []string{subject}})
+	require.NoError(t, err)
+	defer js.DeleteStream(stream)
+
+	for i := 1; i <= 5; i++ {
+		ce := cloudevents.NewEvent()
+		ce.SetID(fmt.Sprintf("event-%d", i))
+		ce.SetSource("test")
+		ce.SetType("user.updated")
+		data, err := json.Marshal(ce)
+		require.NoError(t, err)
+		_, err = js.Publish("replaytest."+id+".user.updated", data)
+		require.NoError(t, err)
+	}
+	_, err = js.Publish("replaytest."+id+".raw", []byte("not an event"))
+	require.NoError(t, err)
+
+	// An interrupted replay got through the first three events
+	bucket := "replay-test-" + id
+	defer js.DeleteKeyValue(bucket)
+	kv, err := js.CreateKeyValue(&nats.KeyValueConfig{Bucket: bucket})
+	require.NoError(t, err)
+	checkpoint, err := json.Marshal(ReplayCheckpoint{Stream: stream, Subject: subject, LastSequence: 3, EndSequence: 6, Replayed: 3})
+	require.NoError(t, err)
+	_, err = kv.Put("resume", checkpoint)
+	require.NoError(t, err)
+
+	stats, err := Replay(context.Background(), nc, ReplayConfig{Stream: stream, Subject: subject, Name: "resume", Bucket: bucket})
+	require.NoError(t, err)
+	assert.True(t, stats.Resumed)
+	assert.Equal(t, uint64(2), stats.Replayed)
+	assert.Equal(t, uint64(1), stats.Skipped)
+	assert.Equal(t, uint64(4), stats.FirstSequence)
+	assert.Equal(t, uint64(6), stats.LastSequence)
+
+	_, err = kv.Get("resume")
+	assert.ErrorIs(t, err, nats.ErrKeyNotFound)
+
+	// The republished events follow the stored ones
+	for seq, id := range map[uint64]string{7: "event-4", 8: "event-5"} {
+		msg, err := js.GetMsg(stream, seq)
+		require.NoError(t, err)
+		ce := cloudevents.NewEvent()
+		require.NoError(t, json.Unmarshal(msg.Data, &ce))
+		assert.Equal(t, id, ce.ID())
+		assert.True(t, IsReplay(&ce))
+	}
+
+	// A checkpoint of another replay is not resumed
+	_, err = kv.Put("other", checkpoint)
+	require.NoError(t, err)
+	_, err = Replay(context.Background(), nc, ReplayConfig{Stream: stream, Subject: "replaytest." + id + ".user.>", Name: "other", Bucket: bucket})
+	assert.ErrorContains(t, err, "belongs to a replay")
+}
@@ -0,0 +1,97 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// templatePlaceholder matches {{id}}, {{type}}, {{source}}, {{subject}} and {{data.<path>}}
+var templatePlaceholder = regexp.MustCompile(`\{\{\s*([^{}\s]+)\s*\}\}`)
+
+// ExpandTemplate replaces the placeholders of a template, such as a URL or a topic,
+// with event attributes and fields of the decoded event data, escaping the values with
+// escape when it is set. Placeholders the event has no value for are an error.
+func ExpandTemplate(template string, e *cloudevents.Event, data map[string]interface{}, escape func(string) string) (string, error) {
+	var missing string
+	expanded := templatePlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		name := templatePlaceholder.FindStringSubmatch(placeholder)[1]
+		value, ok := templateValue(name, e, data)
+		if !ok {
+			if missing == "" {
+				missing = name
+			}
+			return ""
+		}
+		if escape != nil {
+			return escape(value)
+		}
+		return value
+	})
+	if missing != "" {
+		return "", fmt.Errorf("event has no %s for the template", missing)
+	}
+	return expanded, nil
+}
+
+// templateValue returns the string value of a template placeholder
+func templateValue(name string, e *cloudevents.Event, data map[string]interface{}) (string, bool) {
+	switch name {
+	case "id":
+		return e.ID(), true
+	case "type":
+		return e.Type(), true
+	case "source":
+		return e.Source(), true
+	case "subject":
+		return e.Subject(), e.Subject() != ""
+	}
+
+	path, ok := strings.CutPrefix(name, "data.")
+	if !ok {
+		return "", false
+	}
+	value, ok := LookupPath(data, path)
+	if !ok || value == nil {
+		return "", false
+	}
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		encoded, err := json.Marshal(v)
+		return string(encoded), err == nil
+	}
+}
+
+// LookupPath returns the value at a dot-separated path of decoded JSON; array
+// elements are addressed by index
+func LookupPath(value interface{}, path string) (interface{}, bool) {
+	for _, segment := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			next, ok := v[segment]
+			if !ok {
+				return nil, false
+			}
+			value = next
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil, false
+			}
+			value = v[index]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
@@ -0,0 +1,245 @@
+package event
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// Rules a CloudEvent can violate
+const (
+	RuleRequired           = "required"            // A required attribute is missing or empty
+	RuleInvalidType        = "invalid_type"        // An attribute has the wrong JSON type
+	RuleInvalidFormat      = "invalid_format"      // An attribute is not a valid URI, timestamp or media type
+	RuleUnsupportedVersion = "unsupported_version" // The specversion is not one Mycelium handles
+	RuleInvalidName        = "invalid_name"        // An extension name is not lower-case alphanumeric
+	RuleConflictingData    = "conflicting_data"    // Both data and data_base64 are set
+	RuleMalformed          = "malformed"           // The event is not a JSON object
+)
+
+// SupportedSpecVersions are the CloudEvents spec versions events may declare
+var SupportedSpecVersions = []string{cloudevents.VersionV1, cloudevents.VersionV03}
+
+// contextAttributes are the attributes defined by the spec; any other top-level member
+// of a JSON event is an extension
+var contextAttributes = map[string]bool{
+	"specversion": true, "id": true, "source": true, "type": true, "subject": true,
+	"time": true, "datacontenttype": true, "dataschema": true, "schemaurl": true,
+	"datacontentencoding": true, "data": true, "data_base64": true,
+}
+
+// Violation is an attribute of a CloudEvent that breaks the spec or Mycelium's rules
+type Violation struct {
+	Attribute string `json:"attribute"`
+	Rule      string `json:"rule"`
+	Message   string `json:"message"`
+}
+
+func (v Violation) String() string {
+	if v.Attribute == "" {
+		return v.Message
+	}
+	return v.Attribute + ": " + v.Message
+}
+
+// InvalidEventError is returned for events that fail validation, with every violation
+// found so producers can fix them at once
+type InvalidEventError struct {
+	EventID    string      `json:"event_id,omitempty"`
+	Violations []Violation `json:"violations"`
+}
+
+func (e *InvalidEventError) Error() string {
+	parts := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		parts[i] = v.String()
+	}
+	if e.EventID == "" {
+		return fmt.Sprintf("invalid CloudEvent: %s", strings.Join(parts, "; "))
+	}
+	return fmt.Sprintf("invalid CloudEvent %s: %s", e.EventID, strings.Join(parts, "; "))
+}
+
+// ValidateEvent checks the spec-required attributes and extension names of an event.
+// It returns an *InvalidEventError listing the violations, or nil.
+func ValidateEvent(e *cloudevents.Event) error {
+	if e == nil {
+		return &InvalidEventError{Violations: []Violation{{Rule: RuleRequired, Message: "event is missing"}}}
+	}
+
+	var violations []Violation
+	add := func(attribute, rule, format string, args ...interface{}) {
+		violations = append(violations, Violation{Attribute: attribute, Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+	checkSpecVersion(e.SpecVersion(), add)
+	for attribute, value := range map[string]string{"id": e.ID(), "source": e.Source(), "type": e.Type()} {
+		if value == "" {
+			add(attribute, RuleRequired, "required attribute is missing")
+		}
+	}
+	if e.Source() != "" {
+		checkURIReference("source", e.Source(), add)
+	}
+	if e.DataSchema() != "" {
+		checkURI("dataschema", e.DataSchema(), add)
+	}
+	if e.DataContentType() != "" {
+		checkMediaType(e.DataContentType(), add)
+	}
+	for name := range e.Extensions() {
+		checkExtensionName(name, add)
+	}
+	return invalidEvent(e.ID(), violations)
+}
+
+// ValidateEventJSON checks an event in the JSON format before it is parsed, so events
+// the SDK would reject with a single opaque error yield every violation instead. It
+// returns an *InvalidEventError listing the violations, or nil.
+func ValidateEventJSON(data []byte) error {
+	var attributes map[string]json.RawMessage
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&attributes); err != nil || attributes == nil {
+		return &InvalidEventError{Violations: []Violation{{Rule: RuleMalformed, Message: "event is not a JSON object"}}}
+	}
+
+	var violations []Violation
+	add := func(attribute, rule, format string, args ...interface{}) {
+		violations = append(violations, Violation{Attribute: attribute, Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// stringAttribute decodes an optional string attribute; null counts as absent
+	stringAttribute := func(name string) (string, bool) {
+		raw, ok := attributes[name]
+		if !ok || string(raw) == "null" {
+			return "", false
+		}
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			add(name, RuleInvalidType, "must be a string")
+			return "", false
+		}
+		return s, true
+	}
+
+	id, _ := stringAttribute("id")
+	if version, ok := stringAttribute("specversion"); ok || !hasValue(attributes, "specversion") {
+		checkSpecVersion(version, add)
+	}
+	for _, name := range []string{"id", "source", "type"} {
+		value, ok := stringAttribute(name)
+		switch {
+		case ok && value == "":
+			add(name, RuleRequired, "required attribute is empty")
+		case !ok && !hasValue(attributes, name):
+			add(name, RuleRequired, "required attribute is missing")
+		case ok && name == "source":
+			checkURIReference(name, value, add)
+		}
+	}
+	if value, ok := stringAttribute("subject"); ok && value == "" {
+		add("subject", RuleRequired, "must not be empty when present")
+	}
+	if value, ok := stringAttribute("time"); ok {
+		if _, err := time.Parse(time.RFC3339Nano, value); err != nil {
+			add("time", RuleInvalidFormat, "must be an RFC 3339 timestamp")
+		}
+	}
+	if value, ok := stringAttribute("dataschema"); ok {
+		checkURI("dataschema", value, add)
+	}
+	if value, ok := stringAttribute("datacontenttype"); ok {
+		checkMediaType(value, add)
+	}
+	stringAttribute("data_base64")
+	if hasValue(attributes, "data") && hasValue(attributes, "data_base64") {
+		add("data_base64", RuleConflictingData, "data and data_base64 are mutually exclusive")
+	}
+
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		if !contextAttributes[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		checkExtensionName(name, add)
+		switch strings.TrimSpace(string(attributes[name]))[0] {
+		case '{', '[':
+			add(name, RuleInvalidType, "extension values must be strings, numbers or booleans")
+		}
+	}
+	return invalidEvent(id, violations)
+}
+
+// hasValue reports whether a JSON attribute is present and not null
+func hasValue(attributes map[string]json.RawMessage, name string) bool {
+	raw, ok := attributes[name]
+	return ok && string(raw) != "null"
+}
+
+// invalidEvent wraps violations into an *InvalidEventError, nil without violations
+func invalidEvent(id string, violations []Violation) error {
+	if len(violations) == 0 {
+		return nil
+	}
+	sort.SliceStable(violations, func(i, j int) bool { return violations[i].Attribute < violations[j].Attribute })
+	return &InvalidEventError{EventID: id, Violations: violations}
+}
+
+type addViolation func(attribute, rule, format string, args ...interface{})
+
+func checkSpecVersion(version string, add addViolation) {
+	if version == "" {
+		add("specversion", RuleRequired, "required attribute is missing")
+		return
+	}
+	for _, supported := range SupportedSpecVersions {
+		if version == supported {
+			return
+		}
+	}
+	add("specversion", RuleUnsupportedVersion, "%q is not one of %s", version, strings.Join(SupportedSpecVersions, ", "))
+}
+
+func checkURIReference(attribute, value string, add addViolation) {
+	if _, err := url.Parse(value); err != nil {
+		add(attribute, RuleInvalidFormat, "must be a URI reference")
+	}
+}
+
+func checkURI(attribute, value string, add addViolation) {
+	if u, err := url.Parse(value); err != nil || !u.IsAbs() {
+		add(attribute, RuleInvalidFormat, "must be an absolute URI")
+	}
+}
+
+func checkMediaType(value string, add addViolation) {
+	mediaType, _, _ := strings.Cut(value, ";")
+	major, minor, ok := strings.Cut(strings.TrimSpace(mediaType), "/")
+	if !ok || major == "" || minor == "" {
+		add("datacontenttype", RuleInvalidFormat, "must be a media type such as application/json")
+	}
+}
+
+// checkExtensionName applies the spec's naming rule: extension names consist of
+// lower-case ASCII letters and digits only
+func checkExtensionName(name string, add addViolation) {
+	if name == "" {
+		add(name, RuleInvalidName, "extension names must not be empty")
+		return
+	}
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			add(name, RuleInvalidName, "extension names must consist of lower-case letters and digits")
+			return
+		}
+	}
+}
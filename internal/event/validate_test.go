@@ -0,0 +1,72 @@
+package event
+
+import (
+	"errors"
+	"testing"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// violations returns the attribute:rule pairs of a validation error
+func violations(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var invalid *InvalidEventError
+	require.True(t, errors.As(err, &invalid), "unexpected error %v", err)
+	var pairs []string
+	for _, v := range invalid.Violations {
+		pairs = append(pairs, v.Attribute+":"+v.Rule)
+	}
+	return pairs
+}
+
+// TestValidateEventJSON tests that every violation of a JSON event is reported
+func TestValidateEventJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected []string
+	}{
+		{"valid", `{"specversion":"1.0","id":"1","source":"orders","type":"order.created","time":"2024-01-02T03:04:05Z","actorid":"alice","replay":true,"data":{"a":1}}`, nil},
+		{"not an object", `[1,2]`, []string{":malformed"}},
+		{"not JSON", `{"id":`, []string{":malformed"}},
+		{"missing attributes", `{"data":{}}`, []string{"id:required", "source:required", "specversion:required", "type:required"}},
+		{"empty id and wrong types", `{"specversion":"1.0","id":"","source":42,"type":"t"}`, []string{"id:required", "source:invalid_type"}},
+		{"unsupported version", `{"specversion":"2.0","id":"1","source":"s","type":"t"}`, []string{"specversion:unsupported_version"}},
+		{"bad formats", `{"specversion":"1.0","id":"1","source":"s","type":"t","time":"yesterday","dataschema":"schemas/order","datacontenttype":"json"}`,
+			[]string{"datacontenttype:invalid_format", "dataschema:invalid_format", "time:invalid_format"}},
+		{"extension names and values", `{"specversion":"1.0","id":"1","source":"s","type":"t","actor_type":"user","Tenant":"acme","meta":{"a":1}}`,
+			[]string{"Tenant:invalid_name", "actor_type:invalid_name", "meta:invalid_type"}},
+		{"conflicting data", `{"specversion":"1.0","id":"1","source":"s","type":"t","data":"x","data_base64":"eA=="}`, []string{"data_base64:conflicting_data"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, violations(t, ValidateEventJSON([]byte(tt.data))))
+		})
+	}
+
+	err := ValidateEventJSON([]byte(`{"specversion":"1.0","id":"e1","source":"s"}`))
+	assert.EqualError(t, err, "invalid CloudEvent e1: type: required attribute is missing")
+}
+
+// TestValidateEvent tests validating parsed events
+func TestValidateEvent(t *testing.T) {
+	assert.Equal(t, []string{":required"}, violations(t, ValidateEvent(nil)))
+
+	e := cloudevents.NewEvent()
+	assert.Equal(t, []string{"id:required", "source:required", "type:required"}, violations(t, ValidateEvent(&e)))
+
+	e.SetID("e1")
+	e.SetSource("orders")
+	e.SetType("order.created")
+	e.SetExtension(ExtActorID, "alice")
+	assert.NoError(t, ValidateEvent(&e))
+
+	e.SetDataSchema("relative/schema")
+	assert.Equal(t, []string{"dataschema:invalid_format"}, violations(t, ValidateEvent(&e)))
+}
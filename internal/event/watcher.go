@@ -2,8 +2,11 @@ package event
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
@@ -19,11 +22,43 @@ type WatcherConfig struct {
 	DurableName   string        // Durable consumer name
 	AckWait       time.Duration // How long to wait for ACK
 	MaxDeliveries int           // Maximum number of delivery attempts
+	// Core subscribes with plain NATS instead of a JetStream consumer, for servers
+	// without JetStream. Events published while no watcher runs are lost and failed
+	// events are not redelivered.
+	Core bool
+	// Metrics receives the watcher's message metrics (optional)
+	Metrics MetricsCollector
+	// ClaimCheck resolves claim-checked events before they are handled, so handlers
+	// see their full data (optional, JetStream only). Events that cannot be resolved
+	// fail like events whose handler failed.
+	ClaimCheck *ClaimCheckConfig
+	// PoisonSubject receives messages whose last delivery attempt failed, with the
+	// failure context in PoisonHeader* headers, and the message is terminated instead
+	// of redelivered (optional). In core mode every failed message is routed there.
+	PoisonSubject string
+	// PoisonStream is created to capture PoisonSubject when no stream does, keeping
+	// poison messages for inspection and Redrive (optional, JetStream only)
+	PoisonStream string
 }
 
 // EventHandler is a function type that processes events
 type EventHandler func(*cloudevents.Event) error
 
+// redeliveryError is a handler error asking for a delayed redelivery, see RedeliverAfter
+type redeliveryError struct {
+	err   error
+	delay time.Duration
+}
+
+func (e *redeliveryError) Error() string { return e.err.Error() }
+func (e *redeliveryError) Unwrap() error { return e.err }
+
+// RedeliverAfter wraps a handler error so the message is redelivered after delay
+// rather than at once, e.g. when the event could not be handled for lack of capacity
+func RedeliverAfter(err error, delay time.Duration) error {
+	return &redeliveryError{err: err, delay: delay}
+}
+
 // Watcher represents a NATS event watcher
 type Watcher struct {
 	conn    *nats.Conn
@@ -31,6 +66,41 @@ type Watcher struct {
 	sub     *nats.Subscription
 	config  WatcherConfig
 	handler EventHandler
+	claims  *ClaimCheck
+	// Message counters for health reporting
+	received    atomic.Uint64
+	failed      atomic.Uint64
+	acked       atomic.Uint64
+	naked       atomic.Uint64
+	redelivered atomic.Uint64
+	poisonCount atomic.Uint64
+	invalid     atomic.Uint64
+	// poisonStored is set when a stream captures the poison subject
+	poisonStored bool
+	stopOnce     sync.Once
+	// handling holds the messages of the events being handled, see Defer
+	handling sync.Map
+}
+
+// handledMessage is a message whose event is being handled
+type handledMessage struct {
+	msg      *nats.Msg
+	delivery uint64
+	started  time.Time
+	deferred bool
+	once     sync.Once
+	done     chan struct{}
+}
+
+// WatcherStats are the message counters of a watcher
+type WatcherStats struct {
+	Received    uint64 // Messages received
+	Failed      uint64 // Messages that could not be parsed or whose handler failed
+	Acked       uint64 // Messages handled, see OutcomeAck
+	Naked       uint64 // Messages that failed, see OutcomeNak
+	Redelivered uint64 // Messages received on a redelivery
+	Poisoned    uint64 // Messages routed to the poison subject, see OutcomePoison
+	Invalid     uint64 // Messages rejected as invalid CloudEvents, see OutcomeInvalid
 }
 
 // NewWatcher creates a new NATS event watcher
@@ -41,6 +111,14 @@ func NewWatcher(config WatcherConfig, handler EventHandler) (*Watcher, error) {
 		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
 	}
 
+	if config.Core {
+		return &Watcher{
+			conn:    nc,
+			config:  config,
+			handler: handler,
+		}, nil
+	}
+
 	// Create JetStream Context
 	js, err := nc.JetStream()
 	if err != nil {
@@ -48,37 +126,48 @@ func NewWatcher(config WatcherConfig, handler EventHandler) (*Watcher, error) {
 		return nil, fmt.Errorf("failed to create JetStream context: %w", err)
 	}
 
+	var claims *ClaimCheck
+	if config.ClaimCheck != nil {
+		claims, err = NewClaimCheck(nc, *config.ClaimCheck)
+		if err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+
 	return &Watcher{
 		conn:    nc,
 		js:      js,
 		config:  config,
 		handler: handler,
+		claims:  claims,
 	}, nil
 }
 
 // Start begins watching for events
 func (w *Watcher) Start(ctx context.Context) error {
-	// Create consumer configuration
-	consumerConfig := &nats.ConsumerConfig{
-		Durable:       w.config.DurableName,
-		AckPolicy:     nats.AckExplicitPolicy,
-		DeliverPolicy: nats.DeliverNewPolicy,
-		AckWait:       w.config.AckWait,
-		MaxDeliver:    w.config.MaxDeliveries,
+	if w.config.Core {
+		return w.startCore(ctx)
 	}
 
-	// Create or update the consumer
-	_, err := w.js.AddConsumer(w.config.StreamName, consumerConfig)
-	if err != nil {
-		return fmt.Errorf("failed to create consumer: %w", err)
+	if err := w.findPoisonStream(); err != nil {
+		return err
+	}
+
+	if err := w.ensureConsumer(); err != nil {
+		return err
 	}
 
-	// Subscribe to the subject
+	// Subscribe through the durable consumer. Messages are acknowledged by
+	// handleMessage, or later for deferred events, never automatically when the
+	// callback returns.
+	bind := nats.Bind(w.config.StreamName, w.config.DurableName)
 	var sub *nats.Subscription
+	var err error
 	if w.config.QueueGroup != "" {
-		sub, err = w.js.QueueSubscribe(w.config.Subject, w.config.QueueGroup, w.handleMessage)
+		sub, err = w.js.QueueSubscribe(w.config.Subject, w.config.QueueGroup, w.handleMessage, bind, nats.ManualAck())
 	} else {
-		sub, err = w.js.Subscribe(w.config.Subject, w.handleMessage)
+		sub, err = w.js.Subscribe(w.config.Subject, w.handleMessage, bind, nats.ManualAck())
 	}
 	if err != nil {
 		return fmt.Errorf("failed to subscribe: %w", err)
@@ -95,42 +184,321 @@ func (w *Watcher) Start(ctx context.Context) error {
 	return nil
 }
 
-// Stop stops watching for events
-func (w *Watcher) Stop() {
-	if w.sub != nil {
-		if err := w.sub.Unsubscribe(); err != nil {
-			log.Printf("Error unsubscribing: %v", err)
+// ensureConsumer creates the durable push consumer the watcher subscribes through, or
+// updates its limits when it exists. Members of a queue group share the consumer and
+// its deliver subject.
+func (w *Watcher) ensureConsumer() error {
+	info, err := w.js.ConsumerInfo(w.config.StreamName, w.config.DurableName)
+	if err == nil && info.Config.DeliverSubject == "" {
+		// Earlier versions created a pull consumer under the name and consumed through
+		// another one, so it holds no progress worth keeping
+		if err := w.js.DeleteConsumer(w.config.StreamName, w.config.DurableName); err != nil {
+			return fmt.Errorf("failed to replace consumer: %w", err)
+		}
+		err = nats.ErrConsumerNotFound
+	}
+	if errors.Is(err, nats.ErrConsumerNotFound) {
+		_, err = w.js.AddConsumer(w.config.StreamName, &nats.ConsumerConfig{
+			Durable:        w.config.DurableName,
+			DeliverSubject: nats.NewInbox(),
+			DeliverGroup:   w.config.QueueGroup,
+			FilterSubject:  w.config.Subject,
+			AckPolicy:      nats.AckExplicitPolicy,
+			DeliverPolicy:  nats.DeliverNewPolicy,
+			AckWait:        w.config.AckWait,
+			MaxDeliver:     w.config.MaxDeliveries,
+		})
+		// Another member of the group created it first
+		if errors.Is(err, nats.ErrConsumerNameAlreadyInUse) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create consumer: %w", err)
 		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get consumer: %w", err)
+	}
+
+	config := info.Config
+	config.FilterSubject = w.config.Subject
+	config.AckWait = w.config.AckWait
+	config.MaxDeliver = w.config.MaxDeliveries
+	if _, err := w.js.UpdateConsumer(w.config.StreamName, &config); err != nil {
+		return fmt.Errorf("failed to update consumer: %w", err)
+	}
+	return nil
+}
+
+// startCore subscribes to the subject with plain NATS
+func (w *Watcher) startCore(ctx context.Context) error {
+	var sub *nats.Subscription
+	var err error
+	if w.config.QueueGroup != "" {
+		sub, err = w.conn.QueueSubscribe(w.config.Subject, w.config.QueueGroup, w.handleMessage)
+	} else {
+		sub, err = w.conn.Subscribe(w.config.Subject, w.handleMessage)
 	}
-	if w.conn != nil {
-		w.conn.Close()
+	if err != nil {
+		return fmt.Errorf("failed to subscribe: %w", err)
 	}
+	w.sub = sub
+
+	go func() {
+		<-ctx.Done()
+		w.Stop()
+	}()
+	return nil
+}
+
+// Stop stops watching for events. It may be called more than once, e.g. before the
+// context passed to Start is cancelled.
+func (w *Watcher) Stop() {
+	w.stopOnce.Do(func() {
+		if w.sub != nil {
+			if err := w.sub.Unsubscribe(); err != nil {
+				log.Printf("Error unsubscribing: %v", err)
+			}
+		}
+		if w.conn != nil {
+			w.conn.Close()
+		}
+	})
 }
 
 // handleMessage processes incoming NATS messages
 func (w *Watcher) handleMessage(msg *nats.Msg) {
+	w.received.Add(1)
+	delivery := uint64(1)
+	var ref MessageRef
+	var stored bool
+	if !w.config.Core {
+		if ref, stored = messageRefOf(msg); stored {
+			delivery = ref.Delivery
+		}
+	}
+	if delivery > 1 {
+		w.redelivered.Add(1)
+	}
+	if w.config.Metrics != nil {
+		w.config.Metrics.RecordMessageReceived(msg.Subject, delivery)
+	}
+	started := time.Now()
+
+	// Reject events breaking the spec before they fail somewhere downstream
+	if err := ValidateEventJSON(msg.Data); err != nil {
+		w.failed.Add(1)
+		log.Printf("Rejecting invalid CloudEvent on %s: %v", msg.Subject, err)
+		w.reject(msg, delivery, started, err)
+		return
+	}
+
 	// Parse the CloudEvent
 	ce := cloudevents.NewEvent()
 	if err := ce.UnmarshalJSON(msg.Data); err != nil {
+		w.failed.Add(1)
 		log.Printf("Error unmarshaling CloudEvent: %v", err)
-		if err := msg.Nak(); err != nil {
-			log.Printf("Error sending NAK: %v", err)
+		w.fail(msg, delivery, started, err)
+		return
+	}
+
+	// Let handlers, action results and audit records point back at the exact message
+	if stored {
+		SetMessageRef(&ce, ref)
+	}
+
+	if w.claims != nil {
+		if err := w.claims.Resolve(&ce); err != nil {
+			w.failed.Add(1)
+			log.Printf("Error resolving claim-checked CloudEvent: %v", err)
+			w.fail(msg, delivery, started, err)
+			return
+		}
+	}
+
+	handled := &handledMessage{msg: msg, delivery: delivery, started: started, done: make(chan struct{})}
+	w.handling.Store(&ce, handled)
+	err := w.handler(&ce)
+	w.handling.Delete(&ce)
+	if err == nil && handled.deferred {
+		return
+	}
+	w.finish(handled, err)
+}
+
+// Defer lets the handler of an event finish it in the background: its message is not
+// acknowledged when the handler returns, but when the returned function is called with
+// the error the handler would have returned. Until then the message is kept in
+// progress, and it is redelivered if the watcher stops first. Defer must be called by
+// the handler; a handler returning an error fails the message at once.
+func (w *Watcher) Defer(e *cloudevents.Event) func(err error) {
+	value, ok := w.handling.Load(e)
+	if !ok {
+		return func(error) {}
+	}
+	handled := value.(*handledMessage)
+	handled.deferred = true
+	if !w.config.Core {
+		go w.keepInProgress(handled)
+	}
+	return func(err error) { w.finish(handled, err) }
+}
+
+// keepInProgress stops JetStream from redelivering a deferred message until it is finished
+func (w *Watcher) keepInProgress(handled *handledMessage) {
+	interval := w.config.AckWait / 2
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-handled.done:
+			return
+		case <-ticker.C:
+			if err := handled.msg.InProgress(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// finish acknowledges a handled message, or fails it when its handling failed. Only
+// the first call counts.
+func (w *Watcher) finish(handled *handledMessage, err error) {
+	handled.once.Do(func() {
+		close(handled.done)
+		msg := handled.msg
+		if err != nil {
+			w.failed.Add(1)
+			log.Printf("Error processing CloudEvent: %v", err)
+			w.fail(msg, handled.delivery, handled.started, err)
+			return
+		}
+
+		w.acked.Add(1)
+		w.recordHandled(msg, handled.started, OutcomeAck)
+		if w.config.Core {
+			return
+		}
+		if err := msg.Ack(); err != nil {
+			log.Printf("Error sending ACK: %v", err)
 		}
+	})
+}
+
+// fail routes a failed message to the poison subject after its last delivery attempt
+// and asks for a redelivery otherwise
+func (w *Watcher) fail(msg *nats.Msg, delivery uint64, started time.Time, cause error) {
+	if !w.poisoned(delivery) {
+		var redelivery *redeliveryError
+		if errors.As(cause, &redelivery) {
+			w.nakWithDelay(msg, started, redelivery.delay)
+			return
+		}
+		w.nak(msg, started)
+		return
+	}
+	if err := w.poison(msg, delivery, cause); err != nil {
+		log.Printf("Error routing message to poison subject: %v", err)
+		w.nak(msg, started)
 		return
 	}
 
-	// Optionally extract NATS metadata using the NATS extension if needed
-	// Optionally extract Actor and Context from extensions if needed
+	w.poisonCount.Add(1)
+	w.recordHandled(msg, started, OutcomePoison)
+	log.Printf("Routed message %s to poison subject %s after %d deliveries", msg.Subject, w.config.PoisonSubject, delivery)
+	if w.config.Core {
+		return
+	}
+	if err := msg.Term(); err != nil {
+		log.Printf("Error sending TERM: %v", err)
+	}
+}
 
-	if err := w.handler(&ce); err != nil {
-		log.Printf("Error processing CloudEvent: %v", err)
-		if err := msg.Nak(); err != nil {
-			log.Printf("Error sending NAK: %v", err)
+// reject drops an invalid message, which no redelivery can fix: it is routed to the
+// poison subject when there is one and terminated
+func (w *Watcher) reject(msg *nats.Msg, delivery uint64, started time.Time, cause error) {
+	w.invalid.Add(1)
+	if w.config.PoisonSubject != "" {
+		if err := w.poison(msg, delivery, cause); err != nil {
+			log.Printf("Error routing message to poison subject: %v", err)
+			w.nak(msg, started)
+			return
 		}
+		w.poisonCount.Add(1)
+	}
+
+	w.recordHandled(msg, started, OutcomeInvalid)
+	if w.config.Core {
+		return
+	}
+	if err := msg.Term(); err != nil {
+		log.Printf("Error sending TERM: %v", err)
+	}
+}
+
+// nak asks JetStream to redeliver a message; core NATS messages are not redelivered
+func (w *Watcher) nak(msg *nats.Msg, started time.Time) {
+	w.naked.Add(1)
+	w.recordHandled(msg, started, OutcomeNak)
+	if w.config.Core {
+		return
+	}
+	if err := msg.Nak(); err != nil {
+		log.Printf("Error sending NAK: %v", err)
+	}
+}
+
+// nakWithDelay asks JetStream to redeliver a message after a delay
+func (w *Watcher) nakWithDelay(msg *nats.Msg, started time.Time, delay time.Duration) {
+	w.naked.Add(1)
+	w.recordHandled(msg, started, OutcomeNak)
+	if w.config.Core {
+		return
+	}
+	if err := msg.NakWithDelay(delay); err != nil {
+		log.Printf("Error sending NAK: %v", err)
+	}
+}
+
+// recordHandled reports a handled message to the metrics collector
+func (w *Watcher) recordHandled(msg *nats.Msg, started time.Time, outcome string) {
+	if w.config.Metrics == nil {
 		return
 	}
+	duration := time.Since(started)
+	w.config.Metrics.RecordMessageHandled(msg.Subject, duration, outcome)
+	if exemplars, ok := w.config.Metrics.(ExemplarCollector); ok && !w.config.Core {
+		if ref, ok := messageRefOf(msg); ok {
+			exemplars.RecordMessageExemplar(msg.Subject, ref, duration, outcome)
+		}
+	}
+}
 
-	if err := msg.Ack(); err != nil {
-		log.Printf("Error sending ACK: %v", err)
+// Stats returns the watcher's message counters
+func (w *Watcher) Stats() WatcherStats {
+	return WatcherStats{
+		Received:    w.received.Load(),
+		Failed:      w.failed.Load(),
+		Acked:       w.acked.Load(),
+		Naked:       w.naked.Load(),
+		Redelivered: w.redelivered.Load(),
+		Poisoned:    w.poisonCount.Load(),
+		Invalid:     w.invalid.Load(),
+	}
+}
+
+// Lag returns the number of stream messages not yet delivered to the watcher's
+// consumer and the number of delivered messages awaiting acknowledgement.
+// Core watchers report the messages buffered by their subscription.
+func (w *Watcher) Lag() (pending uint64, ackPending int, err error) {
+	state, err := w.Consumer()
+	if err != nil {
+		return 0, 0, err
 	}
+	return state.Pending, state.AckPending, nil
 }
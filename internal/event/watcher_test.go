@@ -0,0 +1,537 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingMetrics is an ExemplarCollector keeping what it records
+type recordingMetrics struct {
+	mu         sync.Mutex
+	deliveries []uint64
+	outcomes   []string
+	exemplars  []MessageRef
+}
+
+func (m *recordingMetrics) RecordMessageReceived(subject string, delivery uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deliveries = append(m.deliveries, delivery)
+}
+
+func (m *recordingMetrics) RecordMessageHandled(subject string, duration time.Duration, outcome string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.outcomes = append(m.outcomes, outcome)
+}
+
+func (m *recordingMetrics) RecordMessageExemplar(subject string, ref MessageRef, duration time.Duration, outcome string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.exemplars = append(m.exemplars, ref)
+}
+
+// TestWatcherMetrics tests the message metrics and consumer state of a watcher whose
+// handler fails the first delivery of an event
+func TestWatcherMetrics(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	if _, err := js.AccountInfo(); err != nil {
+		t.Skip("JetStream not available, skipping integration test")
+	}
+
+	id := uuid.NewString()[:8]
+	stream := "watcher-test-" + id
+	subject := "watchertest." + id
+	_, err = js.AddStream(&nats.StreamConfig{Name: stream, Subjects: []string{subject}})
+	require.NoError(t, err)
+	defer js.DeleteStream(stream)
+
+	metrics := &recordingMetrics{}
+	handled := make(chan struct{}, 1)
+	var attempts int
+	var refs []MessageRef
+	watcher, err := NewWatcher(WatcherConfig{
+		URL:           nats.DefaultURL,
+		StreamName:    stream,
+		Subject:       subject,
+		DurableName:   "watcher-test-" + id,
+		AckWait:       time.Second,
+		MaxDeliveries: 3,
+		Metrics:       metrics,
+	}, func(e *cloudevents.Event) error {
+		if ref, ok := MessageRefOf(e); ok {
+			refs = append(refs, ref)
+		}
+		attempts++
+		if attempts == 1 {
+			return fmt.Errorf("first attempt fails")
+		}
+		handled <- struct{}{}
+		return nil
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, watcher.Start(ctx))
+
+	event := cloudevents.NewEvent()
+	event.SetID("watched-1")
+	event.SetSource("test")
+	event.SetType("order.created")
+	data, err := event.MarshalJSON()
+	require.NoError(t, err)
+	_, err = js.Publish(subject, data)
+	require.NoError(t, err)
+
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was not redelivered")
+	}
+
+	assert.Eventually(t, func() bool {
+		metrics.mu.Lock()
+		defer metrics.mu.Unlock()
+		return len(metrics.outcomes) == 2
+	}, 2*time.Second, 20*time.Millisecond)
+	stats := watcher.Stats()
+	assert.Equal(t, uint64(2), stats.Received)
+	assert.Equal(t, uint64(1), stats.Naked)
+	assert.Equal(t, uint64(1), stats.Redelivered)
+
+	metrics.mu.Lock()
+	assert.Equal(t, []uint64{1, 2}, metrics.deliveries)
+	assert.Equal(t, []string{OutcomeNak, OutcomeAck}, metrics.outcomes)
+	// Handlers and exemplars see the message each delivery came from
+	require.Len(t, refs, 2)
+	consumer := refs[0].Consumer
+	expected := []MessageRef{
+		{Stream: stream, Sequence: 1, Consumer: consumer, Delivery: 1},
+		{Stream: stream, Sequence: 1, Consumer: consumer, Delivery: 2},
+	}
+	assert.Equal(t, expected, metrics.exemplars)
+	metrics.mu.Unlock()
+	assert.Equal(t, expected, refs)
+	assert.Equal(t, stream+"#1", refs[0].String())
+
+	assert.Eventually(t, func() bool {
+		state, err := watcher.Consumer()
+		return err == nil && state.AckFloor == 1 && state.AckPending == 0
+	}, 2*time.Second, 20*time.Millisecond)
+	state, err := watcher.Consumer()
+	require.NoError(t, err)
+	assert.Equal(t, stream, state.Stream)
+	assert.Equal(t, consumer, state.Consumer)
+	assert.Equal(t, uint64(0), state.Pending)
+	assert.Equal(t, uint64(1), state.Delivered)
+	assert.NotNil(t, state.LastActive)
+}
+
+// TestWatcherBindsDurable tests that the members of a queue group consume through the
+// configured durable, replacing a pull consumer left under its name
+func TestWatcherBindsDurable(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	if _, err := js.AccountInfo(); err != nil {
+		t.Skip("JetStream not available, skipping integration test")
+	}
+
+	id := uuid.NewString()[:8]
+	stream := "watcher-test-" + id
+	subject := "watchertest." + id
+	durable := "prefix-watcher-test-" + id
+	_, err = js.AddStream(&nats.StreamConfig{Name: stream, Subjects: []string{subject}})
+	require.NoError(t, err)
+	defer js.DeleteStream(stream)
+	_, err = js.AddConsumer(stream, &nats.ConsumerConfig{Durable: durable, AckPolicy: nats.AckExplicitPolicy})
+	require.NoError(t, err)
+
+	var handled atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watchers := make([]*Watcher, 2)
+	for i := range watchers {
+		watcher, err := NewWatcher(WatcherConfig{
+			URL:           nats.DefaultURL,
+			StreamName:    stream,
+			Subject:       subject,
+			QueueGroup:    "watcher-test",
+			DurableName:   durable,
+			AckWait:       time.Second,
+			MaxDeliveries: 3,
+		}, func(e *cloudevents.Event) error {
+			handled.Add(1)
+			return nil
+		})
+		require.NoError(t, err)
+		require.NoError(t, watcher.Start(ctx))
+		defer watcher.Stop()
+		watchers[i] = watcher
+	}
+
+	for _, watcher := range watchers {
+		state, err := watcher.Consumer()
+		require.NoError(t, err)
+		assert.Equal(t, durable, state.Consumer)
+	}
+	info, err := js.ConsumerInfo(stream, durable)
+	require.NoError(t, err)
+	assert.True(t, info.PushBound)
+	assert.Equal(t, "watcher-test", info.Config.DeliverGroup)
+	assert.Equal(t, time.Second, info.Config.AckWait)
+	assert.Equal(t, 3, info.Config.MaxDeliver)
+
+	for i := 0; i < 4; i++ {
+		event := cloudevents.NewEvent()
+		event.SetID(fmt.Sprintf("bound-%d", i))
+		event.SetSource("test")
+		event.SetType("order.created")
+		data, err := event.MarshalJSON()
+		require.NoError(t, err)
+		_, err = js.Publish(subject, data)
+		require.NoError(t, err)
+	}
+	assert.Eventually(t, func() bool { return handled.Load() == 4 }, 5*time.Second, 20*time.Millisecond)
+
+	info, err = js.ConsumerInfo(stream, durable)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), info.Delivered.Stream)
+	names := 0
+	for range js.ConsumerNames(stream) {
+		names++
+	}
+	assert.Equal(t, 1, names)
+}
+
+// TestWatcherDefer tests that a deferred message is kept in progress beyond its ack
+// wait and acknowledged once it is finished
+func TestWatcherDefer(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	if _, err := js.AccountInfo(); err != nil {
+		t.Skip("JetStream not available, skipping integration test")
+	}
+
+	id := uuid.NewString()[:8]
+	stream := "watcher-defer-test-" + id
+	subject := "watcherdefertest." + id
+	_, err = js.AddStream(&nats.StreamConfig{Name: stream, Subjects: []string{subject}})
+	require.NoError(t, err)
+	defer js.DeleteStream(stream)
+
+	finished := make(chan func(error), 1)
+	var watcher *Watcher
+	watcher, err = NewWatcher(WatcherConfig{
+		URL:           nats.DefaultURL,
+		StreamName:    stream,
+		Subject:       subject,
+		DurableName:   "watcher-defer-test-" + id,
+		AckWait:       time.Second,
+		MaxDeliveries: 3,
+	}, func(e *cloudevents.Event) error {
+		finished <- watcher.Defer(e)
+		return nil
+	})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, watcher.Start(ctx))
+
+	event := cloudevents.NewEvent()
+	event.SetID("deferred-1")
+	event.SetSource("test")
+	event.SetType("order.created")
+	data, err := event.MarshalJSON()
+	require.NoError(t, err)
+	_, err = js.Publish(subject, data)
+	require.NoError(t, err)
+
+	finish := <-finished
+	time.Sleep(2500 * time.Millisecond)
+	assert.Equal(t, uint64(1), watcher.Stats().Received, "deferred messages are not redelivered")
+	assert.Zero(t, watcher.Stats().Acked)
+
+	finish(nil)
+	finish(fmt.Errorf("only the first call counts"))
+	assert.Equal(t, WatcherStats{Received: 1, Acked: 1}, watcher.Stats())
+	assert.Eventually(t, func() bool {
+		state, err := watcher.Consumer()
+		return err == nil && state.AckFloor == 1 && state.AckPending == 0
+	}, 2*time.Second, 20*time.Millisecond)
+}
+
+// TestWatcherPoisonSubject tests that a message failing every delivery attempt is
+// routed to the poison subject with its failure context and not redelivered again
+func TestWatcherPoisonSubject(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	if _, err := js.AccountInfo(); err != nil {
+		t.Skip("JetStream not available, skipping integration test")
+	}
+
+	id := uuid.NewString()[:8]
+	stream := "watcher-poison-test-" + id
+	subject := "watchertest." + id
+	poisonSubject := "watcherpoison." + id
+	_, err = js.AddStream(&nats.StreamConfig{Name: stream, Subjects: []string{subject}})
+	require.NoError(t, err)
+	defer js.DeleteStream(stream)
+
+	poisoned, err := nc.SubscribeSync(poisonSubject)
+	require.NoError(t, err)
+
+	metrics := &recordingMetrics{}
+	watcher, err := NewWatcher(WatcherConfig{
+		URL:           nats.DefaultURL,
+		StreamName:    stream,
+		Subject:       subject,
+		DurableName:   "watcher-poison-test-" + id,
+		AckWait:       time.Second,
+		MaxDeliveries: 3,
+		Metrics:       metrics,
+		PoisonSubject: poisonSubject,
+	}, func(e *cloudevents.Event) error {
+		return fmt.Errorf("downstream\nunavailable")
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, watcher.Start(ctx))
+
+	event := cloudevents.NewEvent()
+	event.SetID("poisoned-1")
+	event.SetSource("test")
+	event.SetType("order.created")
+	data, err := event.MarshalJSON()
+	require.NoError(t, err)
+	original := nats.NewMsg(subject)
+	original.Data = data
+	original.Header.Set("Tenant", "acme")
+	_, err = js.PublishMsg(original)
+	require.NoError(t, err)
+
+	msg, err := poisoned.NextMsg(5 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, data, msg.Data)
+	assert.Equal(t, "acme", msg.Header.Get("Tenant"))
+	assert.Equal(t, "downstream unavailable", msg.Header.Get(PoisonHeaderError))
+	assert.Equal(t, subject, msg.Header.Get(PoisonHeaderSubject))
+	assert.Equal(t, stream, msg.Header.Get(PoisonHeaderStream))
+	assert.Equal(t, "1", msg.Header.Get(PoisonHeaderSequence))
+	assert.Equal(t, "3", msg.Header.Get(PoisonHeaderDeliveries))
+	assert.NotEmpty(t, msg.Header.Get(PoisonHeaderTime))
+
+	// The message is terminated rather than left to the redelivery limit
+	assert.Eventually(t, func() bool {
+		state, err := watcher.Consumer()
+		return err == nil && state.AckFloor == 1 && state.AckPending == 0
+	}, 2*time.Second, 20*time.Millisecond)
+	_, err = poisoned.NextMsg(1500 * time.Millisecond)
+	assert.ErrorIs(t, err, nats.ErrTimeout)
+
+	stats := watcher.Stats()
+	assert.Equal(t, uint64(3), stats.Received)
+	assert.Equal(t, uint64(3), stats.Failed)
+	assert.Equal(t, uint64(2), stats.Naked)
+	assert.Equal(t, uint64(1), stats.Poisoned)
+	metrics.mu.Lock()
+	assert.Equal(t, []string{OutcomeNak, OutcomeNak, OutcomePoison}, metrics.outcomes)
+	metrics.mu.Unlock()
+}
+
+// TestWatcherPoisonStreamRedrive tests that the watcher creates its poison stream and
+// that re-driven poison messages are handled again on their original subject
+func TestWatcherPoisonStreamRedrive(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	if _, err := js.AccountInfo(); err != nil {
+		t.Skip("JetStream not available, skipping integration test")
+	}
+
+	id := uuid.NewString()[:8]
+	stream := "watcher-redrive-test-" + id
+	poisonStream := "watcher-redrive-poison-" + id
+	subject := "watchertest." + id
+	poisonSubject := "watcherpoison." + id
+	_, err = js.AddStream(&nats.StreamConfig{Name: stream, Subjects: []string{subject}})
+	require.NoError(t, err)
+	defer js.DeleteStream(stream)
+	defer js.DeleteStream(poisonStream)
+
+	var fixed atomic.Bool
+	handled := make(chan string, 1)
+	watcher, err := NewWatcher(WatcherConfig{
+		URL:           nats.DefaultURL,
+		StreamName:    stream,
+		Subject:       subject,
+		DurableName:   "watcher-redrive-test-" + id,
+		AckWait:       time.Second,
+		MaxDeliveries: 1,
+		PoisonSubject: poisonSubject,
+		PoisonStream:  poisonStream,
+	}, func(e *cloudevents.Event) error {
+		if !fixed.Load() {
+			return fmt.Errorf("downstream unavailable")
+		}
+		handled <- e.ID()
+		return nil
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, watcher.Start(ctx))
+
+	event := cloudevents.NewEvent()
+	event.SetID("redriven-1")
+	event.SetSource("test")
+	event.SetType("order.created")
+	data, err := event.MarshalJSON()
+	require.NoError(t, err)
+	original := nats.NewMsg(subject)
+	original.Data = data
+	original.Header.Set(nats.MsgIdHdr, "redriven-1")
+	_, err = js.PublishMsg(original)
+	require.NoError(t, err)
+
+	// The failed message is kept in the created poison stream
+	assert.Eventually(t, func() bool {
+		info, err := js.StreamInfo(poisonStream)
+		return err == nil && info.State.Msgs == 1
+	}, 5*time.Second, 20*time.Millisecond)
+	_, err = js.Publish(poisonSubject, []byte("not a poison message"))
+	require.NoError(t, err)
+
+	// Once the cause is fixed, the re-driven message is handled and leaves the poison stream
+	fixed.Store(true)
+	stats, err := Redrive(ctx, nc, RedriveConfig{Stream: poisonStream})
+	require.NoError(t, err)
+	assert.Equal(t, RedriveStats{Redriven: 1, Skipped: 1, FirstSequence: 1, LastSequence: 2}, stats)
+	select {
+	case handled := <-handled:
+		assert.Equal(t, "redriven-1", handled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("re-driven message was not handled")
+	}
+	info, err := js.StreamInfo(poisonStream)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), info.State.Msgs)
+
+	_, err = Redrive(ctx, nc, RedriveConfig{})
+	assert.Error(t, err)
+}
+
+// TestWatcherInvalidEvent tests that an invalid CloudEvent is routed to the poison
+// subject with its violations on the first delivery instead of reaching the handler
+func TestWatcherInvalidEvent(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	if _, err := js.AccountInfo(); err != nil {
+		t.Skip("JetStream not available, skipping integration test")
+	}
+
+	id := uuid.NewString()[:8]
+	stream := "watcher-invalid-test-" + id
+	subject := "watchertest." + id
+	poisonSubject := "watcherpoison." + id
+	_, err = js.AddStream(&nats.StreamConfig{Name: stream, Subjects: []string{subject}})
+	require.NoError(t, err)
+	defer js.DeleteStream(stream)
+
+	poisoned, err := nc.SubscribeSync(poisonSubject)
+	require.NoError(t, err)
+
+	metrics := &recordingMetrics{}
+	watcher, err := NewWatcher(WatcherConfig{
+		URL:           nats.DefaultURL,
+		StreamName:    stream,
+		Subject:       subject,
+		DurableName:   "watcher-invalid-test-" + id,
+		AckWait:       time.Second,
+		MaxDeliveries: 3,
+		Metrics:       metrics,
+		PoisonSubject: poisonSubject,
+	}, func(e *cloudevents.Event) error {
+		t.Errorf("handler called for invalid event %s", e.ID())
+		return nil
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, watcher.Start(ctx))
+
+	data := []byte(`{"specversion":"1.0","id":"invalid-1","source":"test","actor_type":"user"}`)
+	_, err = js.Publish(subject, data)
+	require.NoError(t, err)
+
+	msg, err := poisoned.NextMsg(5 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, data, msg.Data)
+	assert.Equal(t, "1", msg.Header.Get(PoisonHeaderDeliveries))
+	assert.JSONEq(t, `[
+		{"attribute":"actor_type","rule":"invalid_name","message":"extension names must consist of lower-case letters and digits"},
+		{"attribute":"type","rule":"required","message":"required attribute is missing"}
+	]`, msg.Header.Get(PoisonHeaderViolations))
+
+	assert.Eventually(t, func() bool {
+		state, err := watcher.Consumer()
+		return err == nil && state.AckFloor == 1 && state.AckPending == 0
+	}, 2*time.Second, 20*time.Millisecond)
+	stats := watcher.Stats()
+	assert.Equal(t, uint64(1), stats.Received)
+	assert.Equal(t, uint64(1), stats.Invalid)
+	assert.Equal(t, uint64(1), stats.Poisoned)
+	metrics.mu.Lock()
+	assert.Equal(t, []string{OutcomeInvalid}, metrics.outcomes)
+	metrics.mu.Unlock()
+}
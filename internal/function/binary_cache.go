@@ -0,0 +1,160 @@
+package function
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBinaryCacheSize is the default size limit of the local binary cache
+const DefaultBinaryCacheSize = 1 << 30
+
+// binaryCacheSuffix is the file extension of cached binaries
+const binaryCacheSuffix = ".bin"
+
+// BinaryCache keeps function binaries on local disk, keyed by digest, so runtime
+// restarts load functions without downloading them again. The least recently used
+// binaries are evicted once the cache exceeds its size limit; recency is kept in the
+// file modification times, so it survives restarts too.
+type BinaryCache struct {
+	dir      string
+	maxBytes int64
+	size     int64
+	lru      *list.List // Most recently used first
+	entries  map[string]*list.Element
+	mu       sync.Mutex
+}
+
+// binaryCacheEntry is a cached binary
+type binaryCacheEntry struct {
+	digest string
+	size   int64
+}
+
+// NewBinaryCache opens a binary cache in dir, creating the directory if needed.
+// Binaries already in dir are kept up to maxBytes (default: DefaultBinaryCacheSize).
+func NewBinaryCache(dir string, maxBytes int64) (*BinaryCache, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultBinaryCacheSize
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create binary cache directory: %w", err)
+	}
+
+	c := &BinaryCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read binary cache directory: %w", err)
+	}
+
+	// Rebuild the LRU order from modification times, most recent first
+	type cached struct {
+		entry   binaryCacheEntry
+		modTime time.Time
+	}
+	var existing []cached
+	for _, file := range files {
+		digest, ok := strings.CutSuffix(file.Name(), binaryCacheSuffix)
+		if !ok || file.IsDir() {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		existing = append(existing, cached{binaryCacheEntry{digest, info.Size()}, info.ModTime()})
+	}
+	sort.Slice(existing, func(i, j int) bool { return existing[i].modTime.After(existing[j].modTime) })
+
+	for _, e := range existing {
+		c.entries[e.entry.digest] = c.lru.PushBack(&binaryCacheEntry{e.entry.digest, e.entry.size})
+		c.size += e.entry.size
+	}
+	c.evict()
+	return c, nil
+}
+
+// path returns the file a binary is cached in
+func (c *BinaryCache) path(digest string) string {
+	return filepath.Join(c.dir, digest+binaryCacheSuffix)
+}
+
+// Get returns the cached binary with the digest. Files that no longer match their
+// digest are removed and reported as a miss.
+func (c *BinaryCache) Get(digest string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[digest]
+	if !ok {
+		return nil, false
+	}
+
+	binary, err := os.ReadFile(c.path(digest))
+	if err != nil || BinaryDigest(binary) != digest {
+		c.remove(element)
+		return nil, false
+	}
+
+	now := time.Now()
+	os.Chtimes(c.path(digest), now, now)
+	c.lru.MoveToFront(element)
+	return binary, true
+}
+
+// Put caches a binary under its digest and evicts the least recently used binaries
+// beyond the size limit. Binaries larger than the limit are not cached.
+func (c *BinaryCache) Put(digest string, binary []byte) error {
+	if int64(len(binary)) > c.maxBytes {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[digest]; ok {
+		c.lru.MoveToFront(element)
+		return nil
+	}
+
+	if err := writeFileAtomic(c.path(digest), binary); err != nil {
+		return fmt.Errorf("failed to cache binary: %w", err)
+	}
+	c.entries[digest] = c.lru.PushFront(&binaryCacheEntry{digest, int64(len(binary))})
+	c.size += int64(len(binary))
+	c.evict()
+	return nil
+}
+
+// Size returns the total size of the cached binaries in bytes
+func (c *BinaryCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// evict removes the least recently used binaries until the cache fits its limit
+func (c *BinaryCache) evict() {
+	for c.size > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops a binary from the cache and disk
+func (c *BinaryCache) remove(element *list.Element) {
+	entry := c.lru.Remove(element).(*binaryCacheEntry)
+	delete(c.entries, entry.digest)
+	c.size -= entry.size
+	os.Remove(c.path(entry.digest))
+}
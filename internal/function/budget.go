@@ -0,0 +1,103 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// PartialResultError is returned by functions that stop before finishing, e.g. because
+// their deadline is near, together with the events produced so far. The runtime answers
+// it as a success marked partial; clients return the events with the error.
+type PartialResultError struct {
+	Reason string
+}
+
+func (e *PartialResultError) Error() string {
+	return fmt.Sprintf("partial result: %s", e.Reason)
+}
+
+// PartialReason returns the reason of the partial result, so packages that only see
+// the error can detect partial results without importing this one
+func (e *PartialResultError) PartialReason() string {
+	return e.Reason
+}
+
+// partialResult is implemented by the errors of partial results, also by those defined
+// outside this package such as the public API's
+type partialResult interface {
+	error
+	PartialReason() string
+}
+
+// PartialResult marks the events a function returns as incomplete:
+//
+//	if function.DeadlineNear(ctx, time.Second) {
+//	    return done, function.PartialResult("deadline near, 40 of 100 items processed")
+//	}
+func PartialResult(reason string) error {
+	return &PartialResultError{Reason: reason}
+}
+
+// budget is the time budget of an invocation
+type budget struct {
+	deadline  time.Time
+	heartbeat func(progress string)
+}
+
+type budgetContextKey struct{}
+
+// withBudget returns a context carrying the deadline and heartbeat callback of an invocation
+func withBudget(ctx context.Context, deadline time.Time, heartbeat func(progress string)) context.Context {
+	return context.WithValue(ctx, budgetContextKey{}, &budget{deadline: deadline, heartbeat: heartbeat})
+}
+
+// Remaining returns the time left until the invocation's deadline: the caller's timeout
+// or the watchdog's hard ceiling, whichever comes first. Plugin processes and code
+// outside the runtime only see the context's deadline, which excludes the hard ceiling.
+// ok is false for invocations without a deadline.
+func Remaining(ctx context.Context) (remaining time.Duration, ok bool) {
+	deadline, ok := ctx.Deadline()
+	if b, exists := ctx.Value(budgetContextKey{}).(*budget); exists && !b.deadline.IsZero() {
+		if !ok || b.deadline.Before(deadline) {
+			deadline, ok = b.deadline, true
+		}
+	}
+	if !ok {
+		return 0, false
+	}
+	return time.Until(deadline), true
+}
+
+// DeadlineNear reports whether less than margin is left until the invocation's
+// deadline, the point to return a partial result. It is false without a deadline.
+func DeadlineNear(ctx context.Context, margin time.Duration) bool {
+	remaining, ok := Remaining(ctx)
+	return ok && remaining < margin
+}
+
+// Heartbeat reports that a long-running invocation is making progress. The watchdog
+// does not flag invocations as stuck while they send heartbeats; the deadline and the
+// hard ceiling still apply. Progress is a short description shown with the in-flight
+// invocation. Plugin functions send heartbeats to the runtime through its host service;
+// outside the runtime Heartbeat does nothing.
+func Heartbeat(ctx context.Context, progress string) {
+	if b, ok := ctx.Value(budgetContextKey{}).(*budget); ok && b.heartbeat != nil {
+		b.heartbeat(progress)
+	}
+}
+
+// invocationDeadline returns the earlier of the caller's budget and the hard ceiling,
+// zero when neither is set
+func invocationDeadline(started time.Time, budget, hardCeiling time.Duration) time.Time {
+	var deadline time.Time
+	for _, limit := range []time.Duration{budget, hardCeiling} {
+		if limit <= 0 {
+			continue
+		}
+		if d := started.Add(limit); deadline.IsZero() || d.Before(deadline) {
+			deadline = d
+		}
+	}
+	return deadline
+}
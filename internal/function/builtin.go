@@ -0,0 +1,58 @@
+package function
+
+import "fmt"
+
+// TypeBuiltin is the function type of functions implemented by the runtime itself
+const TypeBuiltin = "builtin"
+
+// ConfigBuiltin names the implementation of a builtin function, so several functions
+// can use one implementation with different configurations. Functions without it use
+// the implementation named like the function.
+const ConfigBuiltin = "builtin"
+
+// builtinFunctions are the implementations of builtin functions by name
+var builtinFunctions = map[string]func(meta FunctionMeta) (Function, error){
+	"example": func(meta FunctionMeta) (Function, error) {
+		return &ExampleFunction{name: meta.Name}, nil
+	},
+	BuiltinHTTPEnrich: newHTTPEnrichFunction,
+	BuiltinTransform:  newTransformFunction,
+	BuiltinRouter:     newRouterFunction,
+}
+
+// builtinPlugin is a builtin function loaded for a function's metadata
+type builtinPlugin struct {
+	meta FunctionMeta
+	fn   Function
+}
+
+func (p *builtinPlugin) Name() string       { return p.meta.Name }
+func (p *builtinPlugin) Version() string    { return p.meta.Version }
+func (p *builtinPlugin) Type() string       { return p.meta.Type }
+func (p *builtinPlugin) Function() Function { return p.fn }
+
+// builtinName returns the name of the implementation a builtin function uses
+func builtinName(meta FunctionMeta) string {
+	if name := meta.Config[ConfigBuiltin]; name != "" {
+		return name
+	}
+	return meta.Name
+}
+
+// loadBuiltin creates the builtin function a function's metadata selects
+func loadBuiltin(meta FunctionMeta) (Plugin, error) {
+	newFunction, ok := builtinFunctions[builtinName(meta)]
+	if !ok {
+		return nil, fmt.Errorf("built-in function %s not found", builtinName(meta))
+	}
+	return newBuiltin(meta, newFunction)
+}
+
+// newBuiltin creates a builtin function with an implementation
+func newBuiltin(meta FunctionMeta, newFunction func(meta FunctionMeta) (Function, error)) (Plugin, error) {
+	fn, err := newFunction(meta)
+	if err != nil {
+		return nil, err
+	}
+	return &builtinPlugin{meta: meta, fn: fn}, nil
+}
@@ -0,0 +1,118 @@
+package function
+
+import (
+	"errors"
+	"sync"
+)
+
+// DefaultMaxConcurrentInvocations is the default number of concurrent invocations allowed per function
+const DefaultMaxConcurrentInvocations = 10
+
+// ErrFunctionSaturated is returned when a function has no free execution slots
+var ErrFunctionSaturated = errors.New("function concurrency limit reached")
+
+// bulkhead limits the number of concurrent invocations of a single function
+type bulkhead struct {
+	slots chan struct{}
+}
+
+func newBulkhead(limit int) *bulkhead {
+	if limit <= 0 {
+		limit = DefaultMaxConcurrentInvocations
+	}
+	return &bulkhead{slots: make(chan struct{}, limit)}
+}
+
+// tryAcquire reserves an execution slot without blocking
+func (b *bulkhead) tryAcquire() bool {
+	select {
+	case b.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// acquire reserves an execution slot, waiting for one to become free
+func (b *bulkhead) acquire() {
+	b.slots <- struct{}{}
+}
+
+// release frees a previously acquired execution slot
+func (b *bulkhead) release() {
+	<-b.slots
+}
+
+// inFlight returns the number of currently acquired slots
+func (b *bulkhead) inFlight() int {
+	return len(b.slots)
+}
+
+// bulkheads partitions execution capacity per function so one saturated
+// function cannot exhaust the capacity available to the others
+type bulkheads struct {
+	defaultLimit int
+	overrides    map[string]int
+	partitions   map[string]*bulkhead
+	// unloaded is shared by the functions that are not loaded yet
+	unloaded *bulkhead
+	mu       sync.Mutex
+}
+
+func newBulkheads(defaultLimit int, overrides map[string]int) *bulkheads {
+	return &bulkheads{
+		defaultLimit: defaultLimit,
+		overrides:    overrides,
+		partitions:   make(map[string]*bulkhead),
+		unloaded:     newBulkhead(defaultLimit),
+	}
+}
+
+// get returns the bulkhead for the named function, creating it on first use. Functions
+// that are not loaded share one partition unless they have an override, so invocations
+// naming unknown functions cannot grow the partitions without bound. Invocations only
+// hold a shared slot while their function loads, see RuntimeService.runInvocation.
+func (b *bulkheads) get(name string, loaded bool) *bulkhead {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if bh, exists := b.partitions[name]; exists {
+		return bh
+	}
+
+	limit := b.defaultLimit
+	override, ok := b.overrides[name]
+	if ok {
+		limit = override
+	} else if !loaded {
+		return b.unloaded
+	}
+	bh := newBulkhead(limit)
+	b.partitions[name] = bh
+	return bh
+}
+
+// isShared reports whether bh is the partition shared by functions that are not loaded
+func (b *bulkheads) isShared(bh *bulkhead) bool {
+	return bh == b.unloaded
+}
+
+// remove drops the partition of an unloaded function; invocations holding its slots
+// still release them
+func (b *bulkheads) remove(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.partitions, name)
+}
+
+// inFlight returns the number of acquired slots across all functions
+func (b *bulkheads) inFlight() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	total := b.unloaded.inFlight()
+	for _, bh := range b.partitions {
+		total += bh.inFlight()
+	}
+	return total
+}
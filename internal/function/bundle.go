@@ -0,0 +1,362 @@
+package function
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BundleManifestFile is the manifest of a bundle directory
+const BundleManifestFile = "bundle.json"
+
+// Bundle artifact kinds
+const (
+	// ArtifactPlugin is a plugin binary for one platform, deployable to a registry
+	ArtifactPlugin = "plugin"
+	// ArtifactWASM is a WebAssembly (wasip1) build of the function. The runtime does
+	// not execute WASM functions yet; it is bundled for hosts that do.
+	ArtifactWASM = "wasm"
+	// ArtifactOCI is an OCI image layout holding the plugin binaries as a multi-platform image
+	ArtifactOCI = "oci"
+)
+
+// ErrPlatformNotBundled is returned when a bundle has no plugin for a platform
+var ErrPlatformNotBundled = errors.New("platform not bundled")
+
+// Bundle is a deployable function build: its metadata and the artifacts built for
+// each target platform, stored in a directory next to a bundle.json manifest
+type Bundle struct {
+	Meta      FunctionMeta     `json:"meta"`
+	Artifacts []BundleArtifact `json:"artifacts"`
+	Created   time.Time        `json:"created"`
+
+	dir string
+}
+
+// BundleArtifact is a file of a bundle
+type BundleArtifact struct {
+	Kind string `json:"kind"`
+	// Platform is the os/arch the artifact runs on, empty for OCI layouts
+	Platform string `json:"platform,omitempty"`
+	// Path is relative to the bundle directory
+	Path string `json:"path"`
+	// Digest is the hex SHA-256 of the file, as registries record it; OCI layouts
+	// carry the digest of their index
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+}
+
+// NewBundle creates an empty bundle in dir
+func NewBundle(dir string, meta FunctionMeta) (*Bundle, error) {
+	if meta.Name == "" {
+		return nil, fmt.Errorf("function name cannot be empty")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create bundle directory: %w", err)
+	}
+	return &Bundle{Meta: meta, Created: time.Now().UTC(), dir: dir}, nil
+}
+
+// Dir returns the directory of the bundle
+func (b *Bundle) Dir() string {
+	return b.dir
+}
+
+// AddArtifact records a file already written below the bundle directory
+func (b *Bundle) AddArtifact(kind, platform, path string) (BundleArtifact, error) {
+	data, err := os.ReadFile(filepath.Join(b.dir, path))
+	if err != nil {
+		return BundleArtifact{}, fmt.Errorf("failed to read artifact: %w", err)
+	}
+	artifact := BundleArtifact{
+		Kind:     kind,
+		Platform: platform,
+		Path:     filepath.ToSlash(path),
+		Digest:   BinaryDigest(data),
+		Size:     int64(len(data)),
+	}
+	b.Artifacts = append(b.Artifacts, artifact)
+	return artifact, nil
+}
+
+// Platforms returns the platforms the bundle has plugins for, sorted
+func (b *Bundle) Platforms() []string {
+	var platforms []string
+	for _, a := range b.Artifacts {
+		if a.Kind == ArtifactPlugin {
+			platforms = append(platforms, a.Platform)
+		}
+	}
+	sort.Strings(platforms)
+	return platforms
+}
+
+// Save writes the manifest of the bundle
+func (b *Bundle) Save() error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal bundle manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(b.dir, BundleManifestFile), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write bundle manifest: %w", err)
+	}
+	return nil
+}
+
+// LoadBundle reads the bundle in a directory
+func LoadBundle(dir string) (*Bundle, error) {
+	data, err := os.ReadFile(filepath.Join(dir, BundleManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle manifest: %w", err)
+	}
+	var b Bundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("failed to parse bundle manifest: %w", err)
+	}
+	if b.Meta.Name == "" {
+		return nil, fmt.Errorf("bundle in %s has no function name", dir)
+	}
+	b.dir = dir
+	return &b, nil
+}
+
+// LoadBundles reads the bundles of a manifest directory: the directory itself when it
+// is a bundle, otherwise each of its subdirectories that is one. A function bundled
+// twice is an error.
+func LoadBundles(dir string) ([]*Bundle, error) {
+	if _, err := os.Stat(filepath.Join(dir, BundleManifestFile)); err == nil {
+		b, err := LoadBundle(dir)
+		if err != nil {
+			return nil, err
+		}
+		return []*Bundle{b}, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest directory: %w", err)
+	}
+	var bundles []*Bundle
+	byName := make(map[string]string)
+	for _, entry := range entries {
+		sub := filepath.Join(dir, entry.Name())
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(sub, BundleManifestFile)); err != nil {
+			continue
+		}
+		b, err := LoadBundle(sub)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := byName[b.Meta.Name]; ok {
+			return nil, fmt.Errorf("function %s is bundled in both %s and %s", b.Meta.Name, other, sub)
+		}
+		byName[b.Meta.Name] = sub
+		bundles = append(bundles, b)
+	}
+	return bundles, nil
+}
+
+// PluginDigest returns the digest of the bundle's plugin for a platform
+func (b *Bundle) PluginDigest(platform string) (string, error) {
+	for _, a := range b.Artifacts {
+		if a.Kind == ArtifactPlugin && a.Platform == platform {
+			return a.Digest, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s has no plugin for %s (bundled: %s)",
+		ErrPlatformNotBundled, b.Meta.Name, platform, strings.Join(b.Platforms(), ", "))
+}
+
+// Deployment returns the deployment of the bundle's plugin for a platform, checking
+// the binary against the digest recorded when the bundle was built
+func (b *Bundle) Deployment(platform string) (FunctionDeployment, error) {
+	for _, a := range b.Artifacts {
+		if a.Kind != ArtifactPlugin || a.Platform != platform {
+			continue
+		}
+		binary, err := os.ReadFile(filepath.Join(b.dir, filepath.FromSlash(a.Path)))
+		if err != nil {
+			return FunctionDeployment{}, fmt.Errorf("failed to read plugin of %s: %w", b.Meta.Name, err)
+		}
+		if BinaryDigest(binary) != a.Digest {
+			return FunctionDeployment{}, fmt.Errorf("plugin of %s for %s does not match the digest recorded in the bundle", b.Meta.Name, platform)
+		}
+		meta := b.Meta
+		meta.Digest = a.Digest
+		return FunctionDeployment{Meta: meta, Binary: binary}, nil
+	}
+	return FunctionDeployment{}, fmt.Errorf("%w: %s has no plugin for %s (bundled: %s)",
+		ErrPlatformNotBundled, b.Meta.Name, platform, strings.Join(b.Platforms(), ", "))
+}
+
+// OCI media types
+const (
+	ociLayoutVersion     = "1.0.0"
+	ociIndexMediaType    = "application/vnd.oci.image.index.v1+json"
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	ociConfigMediaType   = "application/vnd.oci.image.config.v1+json"
+	ociLayerMediaType    = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+// ociDescriptor is an OCI content descriptor
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *ociPlatform      `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociPlatform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+}
+
+// WriteOCILayout writes the plugins of the bundle as a multi-platform image in OCI
+// image layout to path below the bundle directory and records it as an artifact.
+// Each image has a single layer holding the plugin as /function.
+func (b *Bundle) WriteOCILayout(path string) (BundleArtifact, error) {
+	root := filepath.Join(b.dir, path)
+	blobs := filepath.Join(root, "blobs", "sha256")
+	if err := os.MkdirAll(blobs, 0755); err != nil {
+		return BundleArtifact{}, fmt.Errorf("failed to create OCI layout: %w", err)
+	}
+	writeBlob := func(mediaType string, data []byte) (ociDescriptor, error) {
+		digest := BinaryDigest(data)
+		if err := os.WriteFile(filepath.Join(blobs, digest), data, 0644); err != nil {
+			return ociDescriptor{}, fmt.Errorf("failed to write OCI blob: %w", err)
+		}
+		return ociDescriptor{MediaType: mediaType, Digest: "sha256:" + digest, Size: int64(len(data))}, nil
+	}
+	writeJSON := func(mediaType string, v interface{}) (ociDescriptor, error) {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return ociDescriptor{}, fmt.Errorf("failed to marshal OCI %s: %w", mediaType, err)
+		}
+		return writeBlob(mediaType, data)
+	}
+
+	var manifests []ociDescriptor
+	for _, a := range b.Artifacts {
+		if a.Kind != ArtifactPlugin {
+			continue
+		}
+		binary, err := os.ReadFile(filepath.Join(b.dir, filepath.FromSlash(a.Path)))
+		if err != nil {
+			return BundleArtifact{}, fmt.Errorf("failed to read plugin: %w", err)
+		}
+		goos, goarch, _ := strings.Cut(a.Platform, "/")
+
+		layer, diffID, err := pluginLayer(binary, b.Created)
+		if err != nil {
+			return BundleArtifact{}, err
+		}
+		layerDesc, err := writeBlob(ociLayerMediaType, layer)
+		if err != nil {
+			return BundleArtifact{}, err
+		}
+		configDesc, err := writeJSON(ociConfigMediaType, map[string]interface{}{
+			"created":      b.Created,
+			"os":           goos,
+			"architecture": goarch,
+			"config":       map[string]interface{}{"Entrypoint": []string{"/function"}},
+			"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": []string{"sha256:" + diffID}},
+		})
+		if err != nil {
+			return BundleArtifact{}, err
+		}
+		manifestDesc, err := writeJSON(ociManifestMediaType, map[string]interface{}{
+			"schemaVersion": 2,
+			"mediaType":     ociManifestMediaType,
+			"config":        configDesc,
+			"layers":        []ociDescriptor{layerDesc},
+		})
+		if err != nil {
+			return BundleArtifact{}, err
+		}
+		manifestDesc.Platform = &ociPlatform{OS: goos, Architecture: goarch}
+		manifests = append(manifests, manifestDesc)
+	}
+	if len(manifests) == 0 {
+		return BundleArtifact{}, fmt.Errorf("bundle has no plugins to package")
+	}
+
+	indexDesc, err := writeJSON(ociIndexMediaType, map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ociIndexMediaType,
+		"manifests":     manifests,
+		"annotations": map[string]string{
+			"org.opencontainers.image.title":   b.Meta.Name,
+			"org.opencontainers.image.version": b.Meta.Version,
+		},
+	})
+	if err != nil {
+		return BundleArtifact{}, err
+	}
+	indexDesc.Annotations = map[string]string{"org.opencontainers.image.ref.name": b.Meta.Version}
+	index, err := json.MarshalIndent(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ociIndexMediaType,
+		"manifests":     []ociDescriptor{indexDesc},
+	}, "", "  ")
+	if err != nil {
+		return BundleArtifact{}, fmt.Errorf("failed to marshal OCI index: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "index.json"), index, 0644); err != nil {
+		return BundleArtifact{}, fmt.Errorf("failed to write OCI index: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "oci-layout"), []byte(`{"imageLayoutVersion":"`+ociLayoutVersion+`"}`), 0644); err != nil {
+		return BundleArtifact{}, fmt.Errorf("failed to write OCI layout: %w", err)
+	}
+
+	artifact := BundleArtifact{
+		Kind:   ArtifactOCI,
+		Path:   filepath.ToSlash(path),
+		Digest: strings.TrimPrefix(indexDesc.Digest, "sha256:"),
+		Size:   indexDesc.Size,
+	}
+	b.Artifacts = append(b.Artifacts, artifact)
+	return artifact, nil
+}
+
+// pluginLayer returns a gzipped tar holding a plugin as /function, and the digest of
+// the uncompressed tar. Timestamps are fixed so rebuilding yields the same layer.
+func pluginLayer(binary []byte, modTime time.Time) ([]byte, string, error) {
+	var tarball bytes.Buffer
+	tw := tar.NewWriter(&tarball)
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    "function",
+		Mode:    0755,
+		Size:    int64(len(binary)),
+		ModTime: modTime,
+	}); err != nil {
+		return nil, "", fmt.Errorf("failed to write layer: %w", err)
+	}
+	if _, err := tw.Write(binary); err != nil {
+		return nil, "", fmt.Errorf("failed to write layer: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return nil, "", fmt.Errorf("failed to write layer: %w", err)
+	}
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write(tarball.Bytes()); err != nil {
+		return nil, "", fmt.Errorf("failed to compress layer: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, "", fmt.Errorf("failed to compress layer: %w", err)
+	}
+	return compressed.Bytes(), BinaryDigest(tarball.Bytes()), nil
+}
@@ -0,0 +1,53 @@
+package function
+
+import (
+	"github.com/julianshen/mycelium/internal/event"
+
+	ce "github.com/cloudevents/sdk-go/v2"
+	"github.com/nats-io/nats.go"
+)
+
+// newClaimCheck creates the claim check of a client or runtime, nil when not configured
+func newClaimCheck(nc *nats.Conn, cfg *event.ClaimCheckConfig) (*event.ClaimCheck, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	return event.NewClaimCheck(nc, *cfg)
+}
+
+// offloadEvent moves the data of a large event to the claim check bucket, if configured
+func offloadEvent(claims *event.ClaimCheck, e *ce.Event) (*ce.Event, error) {
+	if claims == nil {
+		return e, nil
+	}
+	return claims.Offload(e)
+}
+
+// offloadEvents offloads the data of large events, returning a new slice
+func offloadEvents(claims *event.ClaimCheck, events []*ce.Event) ([]*ce.Event, error) {
+	if claims == nil {
+		return events, nil
+	}
+	offloaded := make([]*ce.Event, len(events))
+	for i, e := range events {
+		o, err := claims.Offload(e)
+		if err != nil {
+			return nil, err
+		}
+		offloaded[i] = o
+	}
+	return offloaded, nil
+}
+
+// resolveEvents restores the data of claim-checked events in place
+func resolveEvents(claims *event.ClaimCheck, events []*ce.Event) error {
+	if claims == nil {
+		return nil
+	}
+	for _, e := range events {
+		if err := claims.Resolve(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -3,9 +3,15 @@ package function
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	mevent "github.com/julianshen/mycelium/internal/event"
+
 	ce "github.com/cloudevents/sdk-go/v2"
 	"github.com/nats-io/nats.go"
 )
@@ -15,6 +21,25 @@ type Client struct {
 	nc       *nats.Conn
 	registry Registry
 	timeout  time.Duration
+	offline  *offlineBuffer
+	// offlineRetry is set while a flush of the offline buffer is scheduled
+	offlineRetry atomic.Bool
+	ownsConn     bool
+	// resultSubject is the subject prefix of function output events
+	resultSubject string
+	// group is the runtime group the client addresses
+	group string
+	// clusters are the connections invocations fail over between, the primary first
+	clusters      []*cluster
+	stickyRouting bool
+	sticky        map[string]*cluster
+	// claims offloads large event payloads to an object store (optional)
+	claims *mevent.ClaimCheck
+	// gossip routes invocations to instances that have the function loaded (optional)
+	gossip *GossipView
+	mu     sync.Mutex
+	done   chan struct{}
+	once   sync.Once
 }
 
 // ClientConfig holds the configuration for the client
@@ -22,68 +47,415 @@ type ClientConfig struct {
 	NATSURL  string
 	Registry Registry
 	Timeout  time.Duration
+	// OfflineBuffer enables store-and-forward delivery while NATS is unreachable (optional)
+	OfflineBuffer *OfflineBufferConfig
+	// Conn reuses an existing connection instead of dialing NATSURL (optional).
+	// The client does not close a shared connection.
+	Conn *nats.Conn
+	// ResultSubject is the subject prefix SubscribeResults listens on (default: DefaultResultSubject)
+	ResultSubject string
+	// Clusters lists the NATS URLs of further clusters that invocations fail over to
+	// when the primary cluster is unreachable or has no runtime listening (optional)
+	Clusters []string
+	// HealthCheckInterval is how often clusters are probed (default: DefaultHealthCheckInterval)
+	HealthCheckInterval time.Duration
+	// StickyRouting keeps invoking a function on the cluster that last served it
+	// for as long as that cluster stays healthy
+	StickyRouting bool
+	// ClaimCheck offloads event data above a size threshold to a JetStream object
+	// store and resolves claim-checked results (optional)
+	ClaimCheck *mevent.ClaimCheckConfig
+	// Group is the runtime group invocations are sent to (default: DefaultRuntimeGroup),
+	// see RuntimeServiceConfig.Group
+	Group string
+	// GossipRouting sends invocations on the primary cluster to a runtime instance that
+	// announced having the function loaded, see RuntimeServiceConfig.Gossip. Functions
+	// no instance announced go to the group's queue as before.
+	GossipRouting bool
 }
 
 // NewClient creates a new function client
 func NewClient(cfg ClientConfig) (*Client, error) {
-	nc, err := nats.Connect(cfg.NATSURL)
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	if cfg.ResultSubject == "" {
+		cfg.ResultSubject = DefaultResultSubject
+	}
+	if cfg.HealthCheckInterval <= 0 {
+		cfg.HealthCheckInterval = DefaultHealthCheckInterval
+	}
+	if cfg.Group == "" {
+		cfg.Group = DefaultRuntimeGroup
+	}
+
+	c := &Client{
+		registry:      cfg.Registry,
+		timeout:       cfg.Timeout,
+		resultSubject: cfg.ResultSubject,
+		group:         cfg.Group,
+		stickyRouting: cfg.StickyRouting,
+		sticky:        make(map[string]*cluster),
+		done:          make(chan struct{}),
+	}
+
+	if cfg.Conn != nil {
+		if cfg.OfflineBuffer != nil {
+			return nil, fmt.Errorf("offline buffer requires the client to own its connection")
+		}
+		c.nc = cfg.Conn
+		if err := c.startClusters(cfg.Conn.ConnectedUrl(), cfg, nil); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	c.ownsConn = true
+
+	var opts []nats.Option
+	if cfg.OfflineBuffer != nil {
+		buffer, err := newOfflineBuffer(*cfg.OfflineBuffer)
+		if err != nil {
+			return nil, err
+		}
+		c.offline = buffer
+
+		// Keep trying to reach NATS in the background and drain the buffer whenever we (re)connect,
+		// including anything left over from a previous run
+		opts = append(opts,
+			nats.RetryOnFailedConnect(true),
+			nats.MaxReconnects(-1),
+			nats.ConnectHandler(func(*nats.Conn) { go c.FlushOffline() }),
+			nats.ReconnectHandler(func(*nats.Conn) { go c.FlushOffline() }),
+		)
+	}
+
+	// With failover clusters, the client starts even while the primary cluster is down
+	connectOpts := opts
+	if len(cfg.Clusters) > 0 {
+		connectOpts = append(connectOpts, nats.RetryOnFailedConnect(true), nats.MaxReconnects(-1))
+	}
+
+	nc, err := nats.Connect(cfg.NATSURL, connectOpts...)
 	if err != nil {
+		if c.offline != nil {
+			c.offline.close()
+		}
 		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
 	}
+	c.nc = nc
 
-	if cfg.Timeout == 0 {
-		cfg.Timeout = 30 * time.Second
+	if err := c.startClusters(cfg.NATSURL, cfg, opts); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// startClusters connects to the failover clusters and starts probing their health
+func (c *Client) startClusters(primary string, cfg ClientConfig, opts []nats.Option) error {
+	claims, err := newClaimCheck(c.nc, cfg.ClaimCheck)
+	if err != nil {
+		c.Close()
+		return err
+	}
+	c.claims = claims
+
+	if cfg.GossipRouting {
+		view, err := WatchGossip(c.nc, c.group)
+		if err != nil {
+			c.Close()
+			return err
+		}
+		c.gossip = view
 	}
 
-	return &Client{
-		nc:       nc,
-		registry: cfg.Registry,
-		timeout:  cfg.Timeout,
-	}, nil
+	if err := c.connectClusters(primary, cfg.Clusters, opts); err != nil {
+		c.Close()
+		return err
+	}
+	if len(c.clusters) > 1 {
+		go c.checkHealth(cfg.HealthCheckInterval)
+	}
+	return nil
 }
 
-// InvokeFunction invokes a function with the given event using NATS Service API
+// InvokeFunction invokes a function with the given event using NATS Service API.
+// The time left until the context's deadline is the function's time budget, see
+// Remaining. A function returning a partial result yields its events together with a
+// *PartialResultError. name may be a name@version reference, see InvokeFunctionVersion.
 func (c *Client) InvokeFunction(ctx context.Context, name string, event *ce.Event) ([]*ce.Event, error) {
+	event, err := offloadEvent(c.claims, event)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create request
 	req := struct {
-		FunctionName string    `json:"functionName"`
-		Event        *ce.Event `json:"event"`
+		FunctionName string        `json:"functionName"`
+		Event        *ce.Event     `json:"event"`
+		Budget       time.Duration `json:"budget,omitempty"`
 	}{
 		FunctionName: name,
 		Event:        event,
 	}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Budget = time.Until(deadline)
+	}
 
 	reqData, err := json.Marshal(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	// Use NATS Service API endpoint subject for function invocation
-	// The service listens on "function.invoke" as defined in the service
-	responseMsg, err := c.nc.RequestWithContext(ctx, "function.invoke", reqData)
+	// Store the invocation for later delivery while NATS is unreachable
+	candidates := c.candidates(name)
+	if len(candidates) == 0 {
+		if c.offline != nil {
+			return nil, c.enqueueOffline(InvokeSubject(c.group), reqData, true)
+		}
+		candidates = c.clusters[:1]
+	}
+
+	// Invocations must not overtake the ones still buffered
+	if c.offline != nil {
+		if queued, err := c.queueBehindOffline(InvokeSubject(c.group), reqData, true); queued {
+			return nil, err
+		}
+	}
+
+	// Send the invocation to the invoke endpoint of the client's runtime group.
+	// Unreachable clusters and clusters without a runtime fail over to the next one.
+	var responseMsg *nats.Msg
+	for _, cl := range candidates {
+		responseMsg, err = c.request(ctx, cl, name, reqData)
+		if err == nil {
+			c.served(name, cl)
+			break
+		}
+		if !isFailoverError(err) || ctx.Err() != nil {
+			break
+		}
+		cl.healthy.Store(false)
+	}
 	if err != nil {
+		if c.offline != nil && isConnectionError(err) {
+			return nil, c.enqueueOffline(InvokeSubject(c.group), reqData, true)
+		}
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 
 	// Parse response
 	var resp struct {
-		Events    []*ce.Event `json:"events,omitempty"`
-		Error     string      `json:"error,omitempty"`
-		ErrorType string      `json:"errorType,omitempty"`
+		Events     []*ce.Event        `json:"events,omitempty"`
+		Error      string             `json:"error,omitempty"`
+		ErrorType  string             `json:"errorType,omitempty"`
+		Violations []mevent.Violation `json:"violations,omitempty"`
+		Partial    string             `json:"partial,omitempty"`
 	}
 
 	if err := json.Unmarshal(responseMsg.Data, &resp); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
 
+	// Let callers inspect the violations of events the runtime rejected
+	if len(resp.Violations) > 0 {
+		invalid := &mevent.InvalidEventError{Violations: resp.Violations}
+		if event != nil {
+			invalid.EventID = event.ID()
+		}
+		return nil, fmt.Errorf("function error (%s): %w", resp.ErrorType, invalid)
+	}
+	// Let callers detect functions cancelled after their timeout with errors.Is
+	if resp.ErrorType == "timeout" {
+		return nil, fmt.Errorf("function error (%s): %w%s", resp.ErrorType, ErrFunctionTimeout,
+			strings.TrimPrefix(resp.Error, ErrFunctionTimeout.Error()))
+	}
 	if resp.Error != "" {
 		return nil, fmt.Errorf("function error (%s): %s", resp.ErrorType, resp.Error)
 	}
 
+	if err := resolveEvents(c.claims, resp.Events); err != nil {
+		return nil, err
+	}
+	if resp.Partial != "" {
+		return resp.Events, &PartialResultError{Reason: resp.Partial}
+	}
 	return resp.Events, nil
 }
 
+// InvokeFunctionVersion invokes a version of a function retained by the registry, which
+// runtimes serve alongside the current version. An empty or "latest" version invokes
+// the current version like InvokeFunction.
+func (c *Client) InvokeFunctionVersion(ctx context.Context, name, version string, event *ce.Event) ([]*ce.Event, error) {
+	return c.InvokeFunction(ctx, FunctionRef(name, version), event)
+}
+
+// request sends an invocation to a cluster. On the primary cluster, invocations go to
+// an instance gossiping that it has the function loaded, falling back to the group's
+// queue when the instance is gone.
+func (c *Client) request(ctx context.Context, cl *cluster, name string, reqData []byte) (*nats.Msg, error) {
+	if cl.nc == c.nc {
+		if instance := pickInstance(c.gossip, name); instance != "" {
+			msg, err := cl.nc.RequestWithContext(ctx, InstanceInvokeSubject(c.group, instance), reqData)
+			if !errors.Is(err, nats.ErrNoResponders) {
+				return msg, err
+			}
+		}
+	}
+	return cl.nc.RequestWithContext(ctx, InvokeSubject(c.group), reqData)
+}
+
+// PublishEvent publishes a CloudEvent to the given subject.
+// With an offline buffer configured, events are stored locally while NATS is
+// unreachable and published in order once the connection is restored. Events published
+// while buffered messages remain are queued behind them.
+func (c *Client) PublishEvent(ctx context.Context, subject string, event *ce.Event) error {
+	event, err := offloadEvent(c.claims, event)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	nc := c.connected()
+	if nc == nil {
+		if c.offline != nil {
+			return c.enqueueOffline(subject, data, false)
+		}
+		nc = c.nc
+	} else if c.offline != nil {
+		// Events must not overtake the ones still buffered
+		if queued, err := c.queueBehindOffline(subject, data, false); queued {
+			return err
+		}
+	}
+
+	if err := nc.Publish(subject, data); err != nil {
+		if c.offline != nil && isConnectionError(err) {
+			return c.enqueueOffline(subject, data, false)
+		}
+		return fmt.Errorf("failed to publish event: %w", err)
+	}
+	return nil
+}
+
+// FlushOffline delivers buffered events and invocations in the order they were queued.
+// It stops at the first delivery failure, leaving the remaining messages queued.
+// Invocations no runtime answered are retried after OfflineBufferConfig.RetryInterval,
+// up to MaxAttempts flushes.
+func (c *Client) FlushOffline() (int, error) {
+	if c.offline == nil {
+		return 0, nil
+	}
+
+	delivered, err := c.offline.flush(func(msg offlineMessage) error {
+		nc := c.connected()
+		if nc == nil {
+			return nats.ErrConnectionReconnecting
+		}
+		if !msg.Request {
+			return nc.Publish(msg.Subject, msg.Data)
+		}
+
+		// Replayed invocations have no caller waiting for the result: any reply, also an
+		// error, means a runtime received it. Without one, e.g. while no runtime is up
+		// yet, it stays buffered.
+		_, err := nc.Request(msg.Subject, msg.Data, c.timeout)
+		if err != nil && !isConnectionError(err) {
+			return fmt.Errorf("%w: %w", errNotDelivered, err)
+		}
+		return err
+	})
+	if errors.Is(err, errNotDelivered) {
+		c.retryOffline()
+	}
+	return delivered, err
+}
+
+// retryOffline flushes the offline buffer again after its retry interval, unless a
+// retry is already scheduled or the client is closed
+func (c *Client) retryOffline() {
+	if !c.offlineRetry.CompareAndSwap(false, true) {
+		return
+	}
+	time.AfterFunc(c.offline.retryInterval, func() {
+		c.offlineRetry.Store(false)
+		select {
+		case <-c.done:
+			return
+		default:
+		}
+		c.FlushOffline()
+	})
+}
+
+// PendingOffline returns the number of messages waiting in the offline buffer
+func (c *Client) PendingOffline() int {
+	if c.offline == nil {
+		return 0
+	}
+	return c.offline.len()
+}
+
+// enqueueOffline stores a message in the offline buffer
+func (c *Client) enqueueOffline(subject string, data []byte, request bool) error {
+	err := c.offline.enqueue(offlineMessage{
+		Subject:  subject,
+		Data:     data,
+		Request:  request,
+		QueuedAt: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to buffer message: %w", err)
+	}
+	return ErrQueuedOffline
+}
+
+// queueBehindOffline buffers a message while older ones are waiting in the offline
+// buffer or being flushed, and starts a flush when none is running. It returns false
+// once the buffer has drained and the message can be sent directly.
+func (c *Client) queueBehindOffline(subject string, data []byte, request bool) (bool, error) {
+	queued, idle, err := c.offline.enqueueBehind(offlineMessage{
+		Subject:  subject,
+		Data:     data,
+		Request:  request,
+		QueuedAt: time.Now(),
+	})
+	if !queued {
+		return false, nil
+	}
+	if err != nil {
+		return true, fmt.Errorf("failed to buffer message: %w", err)
+	}
+	if idle {
+		go c.FlushOffline()
+	}
+	return true, ErrQueuedOffline
+}
+
+// isConnectionError reports whether err means NATS is currently unreachable
+func isConnectionError(err error) bool {
+	return errors.Is(err, nats.ErrNoServers) ||
+		errors.Is(err, nats.ErrConnectionClosed) ||
+		errors.Is(err, nats.ErrConnectionReconnecting) ||
+		errors.Is(err, nats.ErrDisconnected)
+}
+
 // Close closes the client
 func (c *Client) Close() {
-	c.nc.Close()
+	c.once.Do(func() { close(c.done) })
+	if c.gossip != nil {
+		c.gossip.Close()
+	}
+	if c.ownsConn {
+		c.nc.Close()
+	}
+	// Failover cluster connections are always owned by the client
+	for _, cl := range c.clusters[min(1, len(c.clusters)):] {
+		cl.nc.Close()
+	}
+	if c.offline != nil {
+		c.offline.close()
+	}
 }
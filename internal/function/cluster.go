@@ -0,0 +1,111 @@
+package function
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// DefaultHealthCheckInterval is how often a multi-cluster client probes its clusters
+const DefaultHealthCheckInterval = 5 * time.Second
+
+// cluster is one NATS cluster a client can invoke functions on
+type cluster struct {
+	url     string
+	nc      *nats.Conn
+	healthy atomic.Bool
+}
+
+func newCluster(url string, nc *nats.Conn) *cluster {
+	cl := &cluster{url: url, nc: nc}
+	cl.healthy.Store(true)
+	return cl
+}
+
+// connectClusters connects to the failover clusters. They are dialed in the background,
+// so a cluster that is down when the client starts is picked up once it comes back.
+func (c *Client) connectClusters(primary string, urls []string, opts []nats.Option) error {
+	c.clusters = []*cluster{newCluster(primary, c.nc)}
+	for _, url := range urls {
+		nc, err := nats.Connect(url, append(opts,
+			nats.RetryOnFailedConnect(true),
+			nats.MaxReconnects(-1),
+		)...)
+		if err != nil {
+			return fmt.Errorf("failed to connect to NATS cluster %s: %w", url, err)
+		}
+		c.clusters = append(c.clusters, newCluster(url, nc))
+	}
+	return nil
+}
+
+// checkHealth probes every cluster until the client is closed. A cluster is healthy
+// while it is connected and answers a round trip within the interval.
+func (c *Client) checkHealth(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			for _, cl := range c.clusters {
+				cl.healthy.Store(cl.nc.IsConnected() && cl.nc.FlushTimeout(interval) == nil)
+			}
+		}
+	}
+}
+
+// candidates returns the connected clusters to try for a function, healthy clusters
+// first. With sticky routing, the cluster that last served the function leads.
+func (c *Client) candidates(functionName string) []*cluster {
+	var sticky *cluster
+	if c.stickyRouting {
+		c.mu.Lock()
+		sticky = c.sticky[functionName]
+		c.mu.Unlock()
+	}
+
+	var healthy, degraded []*cluster
+	if sticky != nil && sticky.nc.IsConnected() && sticky.healthy.Load() {
+		healthy = append(healthy, sticky)
+	}
+	for _, cl := range c.clusters {
+		switch {
+		case cl == sticky && len(healthy) > 0, !cl.nc.IsConnected():
+		case cl.healthy.Load():
+			healthy = append(healthy, cl)
+		default:
+			degraded = append(degraded, cl)
+		}
+	}
+	return append(healthy, degraded...)
+}
+
+// served records the cluster that served a function
+func (c *Client) served(functionName string, cl *cluster) {
+	if !c.stickyRouting {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sticky[functionName] = cl
+}
+
+// connected returns the connection of the first connected cluster, nil if there is none
+func (c *Client) connected() *nats.Conn {
+	if candidates := c.candidates(""); len(candidates) > 0 {
+		return candidates[0].nc
+	}
+	return nil
+}
+
+// isFailoverError reports whether an invocation may be retried on another cluster,
+// because the cluster is unreachable or no runtime is listening there
+func isFailoverError(err error) bool {
+	return isConnectionError(err) || errors.Is(err, nats.ErrNoResponders)
+}
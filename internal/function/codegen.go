@@ -0,0 +1,195 @@
+package function
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// goInitialisms are name segments rendered in upper case in generated identifiers
+var goInitialisms = map[string]bool{
+	"api": true, "id": true, "ip": true, "json": true, "sql": true,
+	"uri": true, "url": true, "uuid": true, "http": true,
+}
+
+// GenerateGo generates a Go source file with a struct per event data schema and a
+// DataAs<Type> helper decoding the data of a CloudEvent of that type. Type names come
+// from the schema title, or the event type when the schema has none.
+func GenerateGo(pkg string, schemas map[string]*EventSchema) ([]byte, error) {
+	eventTypes := make([]string, 0, len(schemas))
+	for eventType := range schemas {
+		eventTypes = append(eventTypes, eventType)
+	}
+	sort.Strings(eventTypes)
+
+	g := &generator{names: make(map[string]string)}
+	fmt.Fprintf(&g.buf, "// Code generated by functionctl codegen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&g.buf, "package %s\n\n", pkg)
+	fmt.Fprintf(&g.buf, "import (\n\t\"fmt\"\n\n\tcloudevents \"github.com/cloudevents/sdk-go/v2\"\n)\n")
+
+	for _, eventType := range eventTypes {
+		schema := schemas[eventType]
+		name := goName(schema.Title)
+		if name == "" {
+			name = goName(eventType)
+		}
+		if other, taken := g.names[name]; taken {
+			return nil, fmt.Errorf("event types %s and %s both generate type %s", other, eventType, name)
+		}
+		g.names[name] = eventType
+
+		fmt.Fprintf(&g.buf, "\n// %sEventType is the type of events carrying %s data\n", name, name)
+		fmt.Fprintf(&g.buf, "const %sEventType = %q\n", name, eventType)
+		g.namedType(name, fmt.Sprintf("is the data of %s events", eventType), schema)
+		fmt.Fprintf(&g.buf, `
+// DataAs%[1]s decodes the data of a %[2]s event
+func DataAs%[1]s(event *cloudevents.Event) (*%[1]s, error) {
+	if event.Type() != %[1]sEventType {
+		return nil, fmt.Errorf("expected event type %%q, got %%q", %[1]sEventType, event.Type())
+	}
+	var data %[1]s
+	if err := event.DataAs(&data); err != nil {
+		return nil, fmt.Errorf("failed to decode %[2]s data: %%w", err)
+	}
+	return &data, nil
+}
+`, name, eventType)
+
+		// Nested object types are emitted after the type that uses them
+		for len(g.pending) > 0 {
+			next := g.pending[0]
+			g.pending = g.pending[1:]
+			g.namedType(next.name, "is the "+next.path+" field of "+eventType+" data", next.schema)
+		}
+	}
+
+	source, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to format generated code: %w", err)
+	}
+	return source, nil
+}
+
+// generator accumulates generated declarations
+type generator struct {
+	buf     bytes.Buffer
+	names   map[string]string
+	pending []pendingType
+}
+
+// pendingType is a nested object type still to be declared
+type pendingType struct {
+	name   string
+	path   string
+	schema *EventSchema
+}
+
+// namedType declares a type for a schema
+func (g *generator) namedType(name, doc string, schema *EventSchema) {
+	if schema.Description != "" {
+		doc = strings.TrimSpace(schema.Description)
+	}
+	fmt.Fprintf(&g.buf, "\n// %s %s\n", name, doc)
+
+	if schema.Type != "object" || len(schema.Properties) == 0 {
+		fmt.Fprintf(&g.buf, "type %s %s\n", name, g.goType(schema, name, name))
+		return
+	}
+
+	required := make(map[string]bool, len(schema.Required))
+	for _, field := range schema.Required {
+		required[field] = true
+	}
+	fields := make([]string, 0, len(schema.Properties))
+	for field := range schema.Properties {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	fmt.Fprintf(&g.buf, "type %s struct {\n", name)
+	for _, field := range fields {
+		prop := schema.Properties[field]
+		fieldName := goName(field)
+		if fieldName == "" {
+			fieldName = "Field"
+		}
+		if prop.Description != "" {
+			fmt.Fprintf(&g.buf, "\t// %s\n", strings.TrimSpace(prop.Description))
+		}
+
+		typ := g.goType(prop, name+fieldName, field)
+		tag := field
+		if !required[field] {
+			tag += ",omitempty"
+			if nullable(typ) {
+				typ = "*" + typ
+			}
+		}
+		fmt.Fprintf(&g.buf, "\t%s %s `json:%q`\n", fieldName, typ, tag)
+	}
+	fmt.Fprintf(&g.buf, "}\n")
+}
+
+// goType returns the Go type of a schema, queueing a named type for nested objects
+func (g *generator) goType(schema *EventSchema, name, path string) string {
+	switch schema.Type {
+	case "string":
+		return "string"
+	case "integer":
+		return "int64"
+	case "number":
+		return "float64"
+	case "boolean":
+		return "bool"
+	case "array":
+		if schema.Items == nil {
+			return "[]interface{}"
+		}
+		return "[]" + g.goType(schema.Items, name+"Item", path+" items")
+	case "object":
+		if len(schema.Properties) == 0 {
+			return "map[string]interface{}"
+		}
+		if _, declared := g.names[name]; declared {
+			return name
+		}
+		g.names[name] = path
+		g.pending = append(g.pending, pendingType{name: name, path: path, schema: schema})
+		return name
+	}
+	return "interface{}"
+}
+
+// nullable reports whether an optional field of the type needs a pointer to tell
+// absent from the zero value
+func nullable(typ string) bool {
+	return !strings.HasPrefix(typ, "[]") && !strings.HasPrefix(typ, "map[") && typ != "interface{}"
+}
+
+// goName turns a property name or event type into an exported Go identifier,
+// e.g. order_id becomes OrderID and com.example.order.created ComExampleOrderCreated
+func goName(s string) string {
+	parts := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var b strings.Builder
+	for _, part := range parts {
+		if goInitialisms[strings.ToLower(part)] {
+			b.WriteString(strings.ToUpper(part))
+			continue
+		}
+		runes := []rune(part)
+		runes[0] = unicode.ToUpper(runes[0])
+		b.WriteString(string(runes))
+	}
+
+	name := b.String()
+	if name != "" && unicode.IsDigit([]rune(name)[0]) {
+		name = "T" + name
+	}
+	return name
+}
@@ -0,0 +1,268 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// CordonVerb is the $SRV verb cordoning, draining and uncordoning a runtime instance.
+// It is only served on $SRV.CORDON.<service>.<id>, since instances are taken out of
+// rotation one at a time.
+const CordonVerb = "CORDON"
+
+// Cordon actions
+const (
+	CordonActionCordon   = "cordon"
+	CordonActionUncordon = "uncordon"
+	CordonActionDrain    = "drain"
+)
+
+// DefaultDrainTimeout is how long a drain waits for in-flight invocations
+const DefaultDrainTimeout = 30 * time.Second
+
+// CordonHopsHeader counts how often an invocation was handed back to the group's
+// queue by cordoned instances
+const CordonHopsHeader = "Mycelium-Cordon-Hops"
+
+// maxCordonHops bounds the hand-backs of an invocation, so invocations of a group
+// whose instances are all cordoned fail instead of circulating
+const maxCordonHops = 32
+
+// ErrInstanceCordoned is answered to invocations no uncordoned instance took over
+var ErrInstanceCordoned = errors.New("runtime instance is cordoned")
+
+// CordonRequest cordons, drains or uncordons a runtime instance
+type CordonRequest struct {
+	Action string `json:"action"`
+	// Timeout bounds how long a drain waits for in-flight invocations (default: DefaultDrainTimeout)
+	Timeout time.Duration `json:"timeout,omitempty"`
+	Actor   string        `json:"actor,omitempty"`
+}
+
+// CordonStatus is an instance's answer to a CordonRequest
+type CordonStatus struct {
+	Service    string `json:"service"`
+	InstanceID string `json:"instance_id"`
+	Cordoned   bool   `json:"cordoned"`
+	// InFlight counts the invocations still executing on the instance
+	InFlight int `json:"in_flight"`
+	// Drained is set when a drain finished with no invocations left
+	Drained bool `json:"drained,omitempty"`
+}
+
+// CordonSubject returns the CORDON subject of a runtime instance
+func CordonSubject(serviceName, id string) string {
+	return fmt.Sprintf("%s.%s.%s.%s", micro.APIPrefix, CordonVerb, serviceName, id)
+}
+
+// Cordon stops the instance from picking new invocations: its function subscriptions
+// leave their queue groups after processing the events already delivered to them,
+// gossip stops advertising its functions, and invocations reaching it through the
+// group's queue are handed back to the queue for another instance to take. Invocations
+// in flight finish normally.
+func (rs *RuntimeService) Cordon() {
+	rs.cordonMu.Lock()
+	defer rs.cordonMu.Unlock()
+	if rs.cordoned.Swap(true) {
+		return
+	}
+
+	rs.mu.Lock()
+	subscriptions := rs.subscriptions
+	rs.subscriptions = nil
+	rs.drainingSubs = append(rs.drainingSubs, subscriptions...)
+	rs.mu.Unlock()
+	for _, sub := range subscriptions {
+		if err := sub.Drain(); err != nil {
+			rs.logger.Error("Failed to drain function subscription", Field{Key: "subject", Value: sub.Subject}, Field{Key: "error", Value: err})
+		}
+	}
+	if rs.gossip != nil {
+		rs.announce(false)
+	}
+}
+
+// Uncordon puts a cordoned instance back into rotation
+func (rs *RuntimeService) Uncordon() error {
+	rs.cordonMu.Lock()
+	defer rs.cordonMu.Unlock()
+	if !rs.cordoned.Load() {
+		return nil
+	}
+
+	if err := rs.subscribe(rs.subscriptionConfigs); err != nil {
+		rs.unsubscribe()
+		return err
+	}
+	rs.cordoned.Store(false)
+	if rs.gossip != nil {
+		rs.announce(false)
+	}
+	return nil
+}
+
+// Cordoned reports whether the instance is cordoned
+func (rs *RuntimeService) Cordoned() bool {
+	return rs.cordoned.Load()
+}
+
+// Drain cordons the instance and waits until its in-flight invocations and the events
+// already delivered to its function subscriptions are processed, or ctx is done
+func (rs *RuntimeService) Drain(ctx context.Context) CordonStatus {
+	rs.Cordon()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		status := rs.cordonStatus()
+		if status.Drained || ctx.Err() != nil {
+			return status
+		}
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+	}
+}
+
+// cordonStatus reports the instance's cordon state and the work left on it
+func (rs *RuntimeService) cordonStatus() CordonStatus {
+	info := rs.service.Info()
+	status := CordonStatus{
+		Service:    info.Name,
+		InstanceID: info.ID,
+		Cordoned:   rs.cordoned.Load(),
+		InFlight:   rs.getBulkheads().inFlight(),
+	}
+
+	// Subscriptions stop being valid once their delivered events are processed
+	rs.mu.Lock()
+	draining := rs.drainingSubs[:0]
+	for _, sub := range rs.drainingSubs {
+		if sub.IsValid() {
+			draining = append(draining, sub)
+		}
+	}
+	rs.drainingSubs = draining
+	rs.mu.Unlock()
+
+	status.Drained = status.Cordoned && status.InFlight == 0 && len(draining) == 0
+	return status
+}
+
+// handBack returns an invocation that reached a cordoned instance to the group's
+// queue, answering ErrInstanceCordoned once it was handed back maxCordonHops times
+func (rs *RuntimeService) handBack(req micro.Request) {
+	hops, _ := strconv.Atoi(req.Headers().Get(CordonHopsHeader))
+	if hops >= maxCordonHops {
+		rs.respondWithError(req, "instance_cordoned", ErrInstanceCordoned)
+		return
+	}
+
+	msg := nats.NewMsg(InvokeSubject(rs.group))
+	for key, values := range req.Headers() {
+		msg.Header[key] = values
+	}
+	msg.Header.Set(CordonHopsHeader, strconv.Itoa(hops+1))
+	msg.Reply = req.Reply()
+	msg.Data = req.Data()
+	if err := rs.natsConn.PublishMsg(msg); err != nil {
+		rs.logger.Error("Failed to hand back invocation", Field{Key: "error", Value: err})
+		rs.respondWithError(req, "instance_cordoned", ErrInstanceCordoned)
+	}
+}
+
+// addCordonEndpoint registers the CORDON endpoint of the instance
+func (rs *RuntimeService) addCordonEndpoint() error {
+	info := rs.service.Info()
+	return rs.service.AddEndpoint("cordon", micro.HandlerFunc(rs.handleCordon),
+		micro.WithEndpointSubject(CordonSubject(info.Name, info.ID)),
+		micro.WithEndpointMetadata(map[string]string{
+			"description": "Cordon, drain or uncordon this runtime instance",
+			"format":      "application/json",
+		}))
+}
+
+// handleCordon answers CORDON requests. Drains are answered once they finished or
+// timed out, without blocking other requests.
+func (rs *RuntimeService) handleCordon(req micro.Request) {
+	var request CordonRequest
+	if err := json.Unmarshal(req.Data(), &request); err != nil {
+		req.Error("400", "invalid cordon request", nil)
+		return
+	}
+
+	switch request.Action {
+	case CordonActionCordon:
+		rs.Cordon()
+	case CordonActionUncordon:
+		if err := rs.Uncordon(); err != nil {
+			req.Error("500", err.Error(), nil)
+			return
+		}
+	case CordonActionDrain:
+		timeout := request.Timeout
+		if timeout <= 0 {
+			timeout = DefaultDrainTimeout
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			status := rs.Drain(ctx)
+			rs.logger.Info("Runtime instance drained",
+				Field{Key: "drained", Value: status.Drained},
+				Field{Key: "inFlight", Value: status.InFlight},
+				Field{Key: "actor", Value: request.Actor})
+			req.RespondJSON(status)
+		}()
+		return
+	default:
+		req.Error("400", fmt.Sprintf("unknown cordon action %q", request.Action), nil)
+		return
+	}
+
+	status := rs.cordonStatus()
+	rs.logger.Info("Runtime instance "+request.Action+"ed",
+		Field{Key: "inFlight", Value: status.InFlight},
+		Field{Key: "actor", Value: request.Actor})
+	req.RespondJSON(status)
+}
+
+// CordonInstance sends a cordon, drain or uncordon request to a runtime instance and
+// returns its status. A drain is answered once it finished, so its timeout is added to
+// the request timeout.
+func CordonInstance(nc *nats.Conn, serviceName, instanceID string, request CordonRequest, timeout time.Duration) (CordonStatus, error) {
+	var status CordonStatus
+	if instanceID == "" {
+		return status, fmt.Errorf("cordon requests need an instance ID")
+	}
+	if request.Action == CordonActionDrain {
+		if request.Timeout <= 0 {
+			request.Timeout = DefaultDrainTimeout
+		}
+		timeout += request.Timeout
+	}
+	data, err := json.Marshal(request)
+	if err != nil {
+		return status, err
+	}
+
+	msg, err := nc.Request(CordonSubject(serviceName, instanceID), data, timeout)
+	if err != nil {
+		return status, fmt.Errorf("failed to %s instance: %w", request.Action, err)
+	}
+	if msg.Header.Get(micro.ErrorHeader) != "" {
+		return status, fmt.Errorf("%s request failed: %s", request.Action, msg.Header.Get(micro.ErrorHeader))
+	}
+	if err := json.Unmarshal(msg.Data, &status); err != nil {
+		return status, fmt.Errorf("failed to unmarshal reply: %w", err)
+	}
+	return status, nil
+}
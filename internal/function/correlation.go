@@ -0,0 +1,54 @@
+package function
+
+import (
+	ce "github.com/cloudevents/sdk-go/v2"
+
+	mevent "github.com/julianshen/mycelium/internal/event"
+)
+
+// SetCorrelation marks response as produced by invocationID in answer to request.
+// The request ID is stored in the correlationid extension unless the response already
+// carries one, and the invocation ID in the invocationid extension.
+func SetCorrelation(response, request *ce.Event, invocationID string) {
+	if response == nil {
+		return
+	}
+	if request != nil && request.ID() != "" && CorrelationID(response) == "" {
+		response.SetExtension(mevent.ExtCorrelationID, request.ID())
+	}
+	if invocationID != "" {
+		response.SetExtension(mevent.ExtInvocationID, invocationID)
+	}
+}
+
+// setLineage records request as the parent of a response and the function as its producer
+func setLineage(response, request *ce.Event, functionName string) {
+	mevent.SetLineage(response, mevent.ProducerFunction+functionName, request)
+}
+
+// CorrelationID returns the ID of the request event a response answers, if any
+func CorrelationID(event *ce.Event) string {
+	return stringExtension(event, mevent.ExtCorrelationID)
+}
+
+// InvocationID returns the ID of the invocation that produced an event, if any
+func InvocationID(event *ce.Event) string {
+	return stringExtension(event, mevent.ExtInvocationID)
+}
+
+// IsResponseTo reports whether response answers request
+func IsResponseTo(response, request *ce.Event) bool {
+	return request != nil && request.ID() != "" && CorrelationID(response) == request.ID()
+}
+
+// stringExtension returns an extension value as a string
+func stringExtension(event *ce.Event, name string) string {
+	if event == nil {
+		return ""
+	}
+	value, ok := event.Extensions()[name].(string)
+	if !ok {
+		return ""
+	}
+	return value
+}
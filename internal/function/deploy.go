@@ -0,0 +1,225 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/julianshen/mycelium/internal/audit"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// FunctionDeployment is one function of an atomic deployment
+type FunctionDeployment struct {
+	Meta   FunctionMeta
+	Binary []byte
+}
+
+// DeploymentError reports which function made a deployment fail and whether rolling back succeeded
+type DeploymentError struct {
+	Function    string
+	Err         error
+	RollbackErr error
+}
+
+func (e *DeploymentError) Error() string {
+	msg := fmt.Sprintf("deployment failed at function %s: %v", e.Function, e.Err)
+	if e.RollbackErr != nil {
+		msg += fmt.Sprintf(" (rollback failed: %v)", e.RollbackErr)
+	}
+	return msg
+}
+
+func (e *DeploymentError) Unwrap() error {
+	return e.Err
+}
+
+// validateDeployments checks that every function is named exactly once
+func validateDeployments(deployments []FunctionDeployment) error {
+	if len(deployments) == 0 {
+		return fmt.Errorf("deployment contains no functions")
+	}
+
+	seen := make(map[string]bool, len(deployments))
+	for _, d := range deployments {
+		if d.Meta.Name == "" {
+			return fmt.Errorf("function name cannot be empty")
+		}
+		if seen[d.Meta.Name] {
+			return fmt.Errorf("function %s is deployed more than once", d.Meta.Name)
+		}
+		seen[d.Meta.Name] = true
+	}
+	return nil
+}
+
+// previousFunction is the registry state of a function before a deployment
+type previousFunction struct {
+	meta     []byte
+	revision uint64 // 0 when the function did not exist
+}
+
+// DeployFunctions stores a set of functions all-or-nothing.
+// Binaries are uploaded first, by digest, and metadata is written afterwards with
+// revision checks, so a concurrent change to any function aborts the deployment.
+// Binaries already stored are not uploaded again, which makes redeploying unchanged
+// artifacts a metadata-only operation. On failure every function written so far is
+// restored to its previous revision and binaries uploaded by the deployment are removed.
+// Deployments exceeding the registry's quota fail with ErrQuotaExceeded before anything is written.
+func (r *NATSRegistry) DeployFunctions(deployments []FunctionDeployment) error {
+	if err := validateDeployments(deployments); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	binaries := make(map[string][]byte, len(deployments))
+	for _, d := range deployments {
+		binaries[d.Meta.Name] = d.Binary
+	}
+	if err := r.checkQuota(ctx, binaries); err != nil {
+		return err
+	}
+
+	// Snapshot the current metadata of every function
+	previous := make([]previousFunction, len(deployments))
+	var pruned []string
+	for i, d := range deployments {
+		entry, err := r.kv.Get(ctx, d.Meta.Name)
+		switch {
+		case err == nil:
+			previous[i].meta = entry.Value()
+			previous[i].revision = entry.Revision()
+			pruned = append(pruned, r.revisionObjects(ctx, d.Meta.Name)...)
+		case !errors.Is(err, jetstream.ErrKeyNotFound):
+			return fmt.Errorf("failed to get metadata of %s: %w", d.Meta.Name, err)
+		}
+	}
+
+	// Upload all binaries before any metadata changes
+	metas := make([]FunctionMeta, len(deployments))
+	var uploaded []string
+	for i, d := range deployments {
+		digest, created, err := r.putBinary(ctx, d.Binary)
+		if err != nil {
+			return &DeploymentError{
+				Function:    d.Meta.Name,
+				Err:         fmt.Errorf("failed to store binary: %w", err),
+				RollbackErr: r.removeBinaries(ctx, uploaded),
+			}
+		}
+		if created {
+			uploaded = append(uploaded, digest)
+		}
+		metas[i] = d.Meta
+		metas[i].Digest = digest
+	}
+
+	// Write metadata, failing if any function changed since the snapshot
+	written := make([]uint64, 0, len(deployments))
+	for i, meta := range metas {
+		metaData, err := encodeMeta(meta)
+		if err == nil {
+			var revision uint64
+			if previous[i].revision == 0 {
+				revision, err = r.kv.Create(ctx, meta.Name, metaData)
+			} else {
+				revision, err = r.kv.Update(ctx, meta.Name, metaData, previous[i].revision)
+			}
+			if err == nil {
+				written = append(written, revision)
+				continue
+			}
+		}
+
+		return &DeploymentError{
+			Function: meta.Name,
+			Err:      fmt.Errorf("failed to store metadata: %w", err),
+			RollbackErr: errors.Join(
+				r.restoreMetadata(ctx, deployments[:len(written)], previous, written),
+				r.removeBinaries(ctx, uploaded),
+			),
+		}
+	}
+
+	// Keep the deployed versions; the functions already serve them, so a failure is
+	// reported without rolling back
+	for _, meta := range metas {
+		previous, err := r.storeVersion(ctx, meta)
+		if err != nil {
+			return fmt.Errorf("%s was deployed but its version is not kept: %w", meta.Name, err)
+		}
+		if previous != "" {
+			pruned = append(pruned, previous)
+		}
+	}
+
+	// Release the binaries of revisions that dropped out of the history and are no
+	// retained version
+	r.pruneObjects(ctx, pruned)
+
+	for _, meta := range metas {
+		err := r.recordChange(ctx, audit.Record{
+			Action:   "deploy",
+			Resource: meta.Name,
+			Details:  map[string]string{"version": meta.Version, "digest": meta.Digest},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Announce the deployment once every function is written
+	var errs []error
+	for _, meta := range metas {
+		errs = append(errs, r.announce(ctx, EventTypeFunctionDeployed, LifecycleChange{
+			Function: meta.Name,
+			Type:     meta.Type,
+			Version:  meta.Version,
+			Digest:   meta.Digest,
+		}))
+	}
+	return errors.Join(errs...)
+}
+
+// restoreMetadata rolls metadata back to the snapshot, guarded by the revisions the deployment wrote
+func (r *NATSRegistry) restoreMetadata(ctx context.Context, deployments []FunctionDeployment, previous []previousFunction, written []uint64) error {
+	var errs []error
+	for i, d := range deployments {
+		var err error
+		if previous[i].revision == 0 {
+			err = r.kv.Delete(ctx, d.Meta.Name, jetstream.LastRevision(written[i]))
+		} else {
+			_, err = r.kv.Update(ctx, d.Meta.Name, previous[i].meta, written[i])
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore metadata of %s: %w", d.Meta.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// removeBinaries deletes the binaries a failed deployment uploaded
+func (r *NATSRegistry) removeBinaries(ctx context.Context, digests []string) error {
+	var errs []error
+	for _, digest := range digests {
+		if err := r.objectStore.Delete(ctx, binaryKey(digest)); err != nil && !errors.Is(err, jetstream.ErrObjectNotFound) {
+			errs = append(errs, fmt.Errorf("failed to remove binary %s: %w", digest, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DeployFunctions stores a set of functions all-or-nothing
+func (r *MemoryRegistry) DeployFunctions(deployments []FunctionDeployment) error {
+	if err := validateDeployments(deployments); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, d := range deployments {
+		r.store(d.Meta, d.Binary)
+	}
+	return nil
+}
@@ -0,0 +1,423 @@
+package function
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mevent "github.com/julianshen/mycelium/internal/event"
+
+	ce "github.com/cloudevents/sdk-go/v2"
+)
+
+// BuiltinHTTPEnrich is the builtin function calling an HTTP API and adding its
+// response to the event
+const BuiltinHTTPEnrich = "http-enrich"
+
+// Config keys of http-enrich functions; the request timeout is Config["timeout"]
+const (
+	ConfigEnrichURL             = "url"              // URL template, e.g. https://users.internal/v1/users/{{data.user_id}}
+	ConfigEnrichMethod          = "method"           // HTTP method (default: GET)
+	ConfigEnrichHeaderPrefix    = "header."          // Request headers, e.g. header.Authorization; values are templates
+	ConfigEnrichTarget          = "target"           // Event data field receiving the response (default: DefaultEnrichTarget)
+	ConfigEnrichMapping         = "mapping"          // field=response.path pairs copied into the event data instead of the whole response
+	ConfigEnrichOnError         = "on_error"         // fail (default) or passthrough, emitting the event unenriched
+	ConfigEnrichCacheTTL        = "cache_ttl"        // How long responses are cached per request (default: not cached)
+	ConfigEnrichCacheSize       = "cache_size"       // Responses kept in the cache (default: DefaultEnrichCacheSize)
+	ConfigEnrichBreakerFailures = "breaker_failures" // Consecutive failures opening the circuit (default: DefaultEnrichBreakerFailures)
+	ConfigEnrichBreakerCooldown = "breaker_cooldown" // How long an open circuit rejects calls (default: DefaultEnrichBreakerCooldown)
+)
+
+// http-enrich defaults
+const (
+	DefaultEnrichTimeout         = 5 * time.Second
+	DefaultEnrichTarget          = "enrichment"
+	DefaultEnrichCacheSize       = 1000
+	DefaultEnrichBreakerFailures = 5
+	DefaultEnrichBreakerCooldown = 30 * time.Second
+	maxEnrichResponse            = 1 << 20
+)
+
+// ErrCircuitOpen is returned while a function's circuit breaker rejects calls
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// enrichField copies a response field into the event data
+type enrichField struct {
+	field string
+	path  string
+}
+
+// httpEnrichFunction calls an HTTP API for every event and adds the JSON response
+// to the event data. Calls go through a circuit breaker, and GET responses can be
+// cached per request.
+type httpEnrichFunction struct {
+	name        string
+	url         string
+	method      string
+	headers     map[string]string
+	timeout     time.Duration
+	target      string
+	mapping     []enrichField
+	passthrough bool
+	cacheTTL    time.Duration
+	cache       *responseCache
+	breaker     *circuitBreaker
+	client      *http.Client
+}
+
+// newHTTPEnrichFunction creates an http-enrich function from a function's config
+func newHTTPEnrichFunction(meta FunctionMeta) (Function, error) {
+	cfg := meta.Config
+	f := &httpEnrichFunction{
+		name:    meta.Name,
+		url:     cfg[ConfigEnrichURL],
+		method:  strings.ToUpper(cfg[ConfigEnrichMethod]),
+		headers: make(map[string]string),
+		timeout: DefaultEnrichTimeout,
+		target:  cfg[ConfigEnrichTarget],
+		client:  &http.Client{},
+	}
+	if f.url == "" {
+		return nil, fmt.Errorf("http-enrich function %s: %s is required", meta.Name, ConfigEnrichURL)
+	}
+	if f.method == "" {
+		f.method = http.MethodGet
+	}
+	if f.target == "" {
+		f.target = DefaultEnrichTarget
+	}
+	for key, value := range cfg {
+		if header, ok := strings.CutPrefix(key, ConfigEnrichHeaderPrefix); ok && header != "" {
+			f.headers[header] = value
+		}
+	}
+
+	var err error
+	if value := cfg[ConfigTimeout]; value != "" {
+		if f.timeout, err = time.ParseDuration(value); err != nil || f.timeout <= 0 {
+			return nil, fmt.Errorf("http-enrich function %s: invalid timeout %q", meta.Name, value)
+		}
+	}
+	if f.mapping, err = parseEnrichMapping(cfg[ConfigEnrichMapping]); err != nil {
+		return nil, fmt.Errorf("http-enrich function %s: %w", meta.Name, err)
+	}
+	switch cfg[ConfigEnrichOnError] {
+	case "", "fail":
+	case "passthrough":
+		f.passthrough = true
+	default:
+		return nil, fmt.Errorf("http-enrich function %s: invalid on_error %q", meta.Name, cfg[ConfigEnrichOnError])
+	}
+
+	if value := cfg[ConfigEnrichCacheTTL]; value != "" {
+		if f.cacheTTL, err = time.ParseDuration(value); err != nil || f.cacheTTL < 0 {
+			return nil, fmt.Errorf("http-enrich function %s: invalid cache_ttl %q", meta.Name, value)
+		}
+	}
+	if f.cacheTTL > 0 {
+		size := DefaultEnrichCacheSize
+		if value := cfg[ConfigEnrichCacheSize]; value != "" {
+			if size, err = strconv.Atoi(value); err != nil || size <= 0 {
+				return nil, fmt.Errorf("http-enrich function %s: invalid cache_size %q", meta.Name, value)
+			}
+		}
+		f.cache = newResponseCache(size)
+	}
+
+	threshold := DefaultEnrichBreakerFailures
+	if value := cfg[ConfigEnrichBreakerFailures]; value != "" {
+		if threshold, err = strconv.Atoi(value); err != nil || threshold <= 0 {
+			return nil, fmt.Errorf("http-enrich function %s: invalid breaker_failures %q", meta.Name, value)
+		}
+	}
+	cooldown := DefaultEnrichBreakerCooldown
+	if value := cfg[ConfigEnrichBreakerCooldown]; value != "" {
+		if cooldown, err = time.ParseDuration(value); err != nil || cooldown <= 0 {
+			return nil, fmt.Errorf("http-enrich function %s: invalid breaker_cooldown %q", meta.Name, value)
+		}
+	}
+	f.breaker = newCircuitBreaker(threshold, cooldown)
+
+	return f, nil
+}
+
+// parseEnrichMapping parses "field=response.path" pairs separated by commas
+func parseEnrichMapping(value string) ([]enrichField, error) {
+	var mapping []enrichField
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		field, path, ok := strings.Cut(pair, "=")
+		field, path = strings.TrimSpace(field), strings.TrimSpace(path)
+		if !ok || field == "" || path == "" {
+			return nil, fmt.Errorf("invalid mapping %q, expected field=response.path", pair)
+		}
+		mapping = append(mapping, enrichField{field: field, path: path})
+	}
+	return mapping, nil
+}
+
+// Execute calls the API for the event and returns the event with the response added.
+// With on_error=passthrough a failed call returns the event unchanged.
+func (f *httpEnrichFunction) Execute(ctx context.Context, event *ce.Event) ([]*ce.Event, error) {
+	data := make(map[string]interface{})
+	if len(event.Data()) > 0 {
+		if err := json.Unmarshal(event.Data(), &data); err != nil {
+			return nil, fmt.Errorf("http-enrich function %s: event data must be a JSON object: %w", f.name, err)
+		}
+	}
+
+	response, err := f.fetch(ctx, event, data)
+	if err != nil {
+		if f.passthrough {
+			return []*ce.Event{event}, nil
+		}
+		return nil, fmt.Errorf("http-enrich function %s: %w", f.name, err)
+	}
+
+	if len(f.mapping) == 0 {
+		data[f.target] = response
+	}
+	for _, m := range f.mapping {
+		if value, ok := mevent.LookupPath(response, m.path); ok {
+			data[m.field] = value
+		}
+	}
+
+	enriched := event.Clone()
+	if err := enriched.SetData(ce.ApplicationJSON, data); err != nil {
+		return nil, fmt.Errorf("http-enrich function %s: failed to set event data: %w", f.name, err)
+	}
+	return []*ce.Event{&enriched}, nil
+}
+
+// fetch returns the decoded API response for an event, from the cache when possible
+func (f *httpEnrichFunction) fetch(ctx context.Context, event *ce.Event, data map[string]interface{}) (interface{}, error) {
+	target, err := mevent.ExpandTemplate(f.url, event, data, escapeTemplateValue)
+	if err != nil {
+		return nil, err
+	}
+	headers := make(map[string]string, len(f.headers))
+	for name, value := range f.headers {
+		if headers[name], err = mevent.ExpandTemplate(value, event, data, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	cacheable := f.cache != nil && f.method == http.MethodGet
+	key := requestKey(f.method, target, headers)
+	if cacheable {
+		if response, ok := f.cache.get(key); ok {
+			return response, nil
+		}
+	}
+
+	if !f.breaker.allow() {
+		return nil, ErrCircuitOpen
+	}
+	response, status, err := f.call(ctx, target, headers)
+	switch {
+	case err == nil:
+		f.breaker.success()
+	case ctx.Err() != nil:
+		// The invocation was cancelled, which says nothing about the API
+		f.breaker.abort()
+	case status == 0 || status >= 500 || status == http.StatusTooManyRequests:
+		f.breaker.failure()
+	default:
+		// The API answered, the request was at fault
+		f.breaker.success()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cacheable {
+		f.cache.put(key, response, f.cacheTTL)
+	}
+	return response, nil
+}
+
+// call sends a request and decodes the JSON response. The status is 0 when no
+// response was received.
+func (f *httpEnrichFunction) call(ctx context.Context, target string, headers map[string]string) (interface{}, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, f.method, target, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	// Errors name the URL template, since expanded URLs may carry event data
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("request to %s failed: %w", f.url, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxEnrichResponse+1))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read response of %s: %w", f.url, err)
+	}
+	if resp.StatusCode >= 300 {
+		return nil, resp.StatusCode, fmt.Errorf("%s returned %s", f.url, resp.Status)
+	}
+	if len(body) > maxEnrichResponse {
+		return nil, resp.StatusCode, fmt.Errorf("response of %s exceeds %d bytes", f.url, maxEnrichResponse)
+	}
+
+	var response interface{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("response of %s is not JSON: %w", f.url, err)
+	}
+	return response, resp.StatusCode, nil
+}
+
+// requestKey identifies a request in the response cache
+func requestKey(method, target string, headers map[string]string) string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var key strings.Builder
+	key.WriteString(method + " " + target)
+	for _, name := range names {
+		key.WriteString("\n" + name + ": " + headers[name])
+	}
+	return key.String()
+}
+
+// escapeTemplateValue escapes a value for any part of a URL
+func escapeTemplateValue(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+}
+
+// circuitBreaker stops calling an API after consecutive failures. Once the cooldown
+// has passed, a single trial call decides whether the circuit closes again.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	mu        sync.Mutex
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow reports whether a call may be made
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.probing || time.Since(b.openedAt) < b.cooldown {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// success closes the circuit
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.probing = false
+}
+
+// failure counts a failed call, opening the circuit at the threshold
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.probing = false
+	if b.failures >= b.threshold {
+		b.openedAt = time.Now()
+	}
+}
+
+// abort ends a call without an outcome
+func (b *circuitBreaker) abort() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// responseCache keeps decoded responses until they expire, evicting the least
+// recently used ones beyond its size
+type responseCache struct {
+	size    int
+	lru     *list.List // Most recently used first
+	entries map[string]*list.Element
+	mu      sync.Mutex
+}
+
+// responseCacheEntry is a cached response
+type responseCacheEntry struct {
+	key      string
+	response interface{}
+	expires  time.Time
+}
+
+func newResponseCache(size int) *responseCache {
+	return &responseCache{size: size, lru: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// get returns the cached response of a request unless it expired
+func (c *responseCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*responseCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.lru.Remove(element)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	return entry.response, true
+}
+
+// put caches the response of a request
+func (c *responseCache) put(key string, response interface{}, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &responseCacheEntry{key: key, response: response, expires: time.Now().Add(ttl)}
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*responseCacheEntry).key)
+	}
+}
@@ -0,0 +1,114 @@
+package function
+
+import (
+	"time"
+)
+
+// Defaults of the versions an instance loads for name@version references
+const (
+	DefaultMaxVersionedFunctions = 4
+	DefaultVersionIdleTimeout    = 10 * time.Minute
+)
+
+// touchVersion records an invocation of a version loaded for a name@version reference
+func (rs *RuntimeService) touchVersion(ref string) {
+	if _, version := ParseFunctionRef(ref); version == "" {
+		return
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.versionUse == nil {
+		rs.versionUse = make(map[string]time.Time)
+	}
+	rs.versionUse[ref] = time.Now()
+}
+
+// evictVersions unloads the least recently invoked versions loaded for name@version
+// references while more than the limit are loaded, never the one named by keep
+func (rs *RuntimeService) evictVersions(keep string) {
+	limit := rs.maxVersions
+	if limit <= 0 {
+		limit = DefaultMaxVersionedFunctions
+	}
+	for {
+		rs.mu.RLock()
+		var oldest string
+		var oldestUse time.Time
+		for ref, used := range rs.versionUse {
+			if ref != keep && (oldest == "" || used.Before(oldestUse)) {
+				oldest, oldestUse = ref, used
+			}
+		}
+		over := len(rs.versionUse) > limit
+		rs.mu.RUnlock()
+
+		if !over || oldest == "" {
+			return
+		}
+		rs.unload(oldest, "limit")
+	}
+}
+
+// evictIdleVersions unloads the versions loaded for name@version references that were
+// not invoked within the idle timeout
+func (rs *RuntimeService) evictIdleVersions(now time.Time) {
+	idle := rs.versionIdle
+	if idle <= 0 {
+		idle = DefaultVersionIdleTimeout
+	}
+	rs.mu.RLock()
+	var expired []string
+	for ref, used := range rs.versionUse {
+		if now.Sub(used) >= idle {
+			expired = append(expired, ref)
+		}
+	}
+	rs.mu.RUnlock()
+
+	for _, ref := range expired {
+		rs.unload(ref, "idle")
+	}
+}
+
+// runVersionEviction unloads idle versions until stop is closed
+func (rs *RuntimeService) runVersionEviction(stop <-chan struct{}) {
+	idle := rs.versionIdle
+	if idle <= 0 {
+		idle = DefaultVersionIdleTimeout
+	}
+	ticker := time.NewTicker(idle / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			rs.evictIdleVersions(now)
+		}
+	}
+}
+
+// unload removes a loaded function reference and releases its reservation and
+// bulkhead. Its plugin is closed once its in-flight invocations finished.
+func (rs *RuntimeService) unload(ref, reason string) {
+	rs.mu.Lock()
+	plugin, loaded := rs.plugins[ref]
+	delete(rs.plugins, ref)
+	delete(rs.metas, ref)
+	delete(rs.loaded, ref)
+	delete(rs.versionUse, ref)
+	rs.mu.Unlock()
+	if !loaded {
+		return
+	}
+
+	rs.reservations.release(ref)
+	rs.getBulkheads().remove(ref)
+	if rs.logger != nil {
+		rs.logger.Info("Unloaded function version",
+			Field{Key: "functionName", Value: ref},
+			Field{Key: "reason", Value: reason})
+	}
+	go rs.retirePlugin(ref, plugin)
+}
@@ -57,6 +57,10 @@ func (m *SimpleMetricsCollector) RecordFunctionMemoryUsage(functionName string,
 	fmt.Printf("METRIC: Function %s memory usage: %d bytes\n", functionName, memoryBytes)
 }
 
+func (m *SimpleMetricsCollector) RecordFlagEvaluation(functionName, flag, value string) {
+	fmt.Printf("METRIC: Function %s flag %s: %s\n", functionName, flag, value)
+}
+
 // SimpleLogger is a minimal logger implementation for testing
 type SimpleLogger struct{}
 
@@ -80,46 +84,6 @@ func (l *SimpleLogger) WithFields(fields ...Field) Logger {
 	return l
 }
 
-// registryEntry represents a stored function
-type registryEntry struct {
-	meta   FunctionMeta
-	binary []byte
-}
-
-// MemoryRegistry is a minimal in-memory registry implementation for testing
-type MemoryRegistry struct {
-	functions map[string]registryEntry
-}
-
-func (r *MemoryRegistry) StoreFunction(meta FunctionMeta, binary []byte) error {
-	if r.functions == nil {
-		r.functions = make(map[string]registryEntry)
-	}
-	r.functions[meta.Name] = registryEntry{meta: meta, binary: binary}
-	return nil
-}
-
-func (r *MemoryRegistry) GetFunction(name string) (FunctionMeta, []byte, error) {
-	entry, exists := r.functions[name]
-	if !exists {
-		return FunctionMeta{}, nil, fmt.Errorf("function %s not found", name)
-	}
-	return entry.meta, entry.binary, nil
-}
-
-func (r *MemoryRegistry) ListFunctions() ([]FunctionMeta, error) {
-	functions := make([]FunctionMeta, 0, len(r.functions))
-	for _, entry := range r.functions {
-		functions = append(functions, entry.meta)
-	}
-	return functions, nil
-}
-
-func (r *MemoryRegistry) DeleteFunction(name string) error {
-	delete(r.functions, name)
-	return nil
-}
-
 // CreateExampleRuntimeService creates a runtime service for testing.
 // For detailed examples, see examples/ directory.
 func CreateExampleRuntimeService(natsURL string) (*RuntimeService, error) {
@@ -0,0 +1,207 @@
+package function
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// FileRegistry implements the Registry interface on a local directory.
+// Each function is stored as <name>.json (metadata) and <name>.bin (binary).
+type FileRegistry struct {
+	dir string
+	mu  sync.RWMutex
+}
+
+// NewFileRegistry creates a registry in dir, creating the directory if needed
+func NewFileRegistry(dir string) (*FileRegistry, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create registry directory: %w", err)
+	}
+	return &FileRegistry{dir: dir}, nil
+}
+
+// paths returns the metadata and binary file of a function
+func (r *FileRegistry) paths(name string) (string, string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", "", fmt.Errorf("invalid function name %q", name)
+	}
+	base := filepath.Join(r.dir, name)
+	return base + ".json", base + ".bin", nil
+}
+
+// StoreFunction stores a function's metadata and binary
+func (r *FileRegistry) StoreFunction(meta FunctionMeta, binary []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.store(meta, binary)
+}
+
+// store writes a function; the binary is written first so metadata never points to a missing binary
+func (r *FileRegistry) store(meta FunctionMeta, binary []byte) error {
+	metaPath, binPath, err := r.paths(meta.Name)
+	if err != nil {
+		return err
+	}
+
+	metaData, err := encodeMeta(meta)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	if err := writeFileAtomic(binPath, binary); err != nil {
+		return fmt.Errorf("failed to store binary: %w", err)
+	}
+	if err := writeFileAtomic(metaPath, metaData); err != nil {
+		return fmt.Errorf("failed to store metadata: %w", err)
+	}
+	return nil
+}
+
+// GetFunction retrieves a function's metadata and binary
+func (r *FileRegistry) GetFunction(name string) (FunctionMeta, []byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	metaPath, binPath, err := r.paths(name)
+	if err != nil {
+		return FunctionMeta{}, nil, err
+	}
+
+	metaData, err := os.ReadFile(metaPath)
+	if err != nil {
+		return FunctionMeta{}, nil, fmt.Errorf("failed to get metadata: %w", err)
+	}
+
+	meta, err := decodeMeta(metaData)
+	if err != nil {
+		return FunctionMeta{}, nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
+	}
+
+	binary, err := os.ReadFile(binPath)
+	if err != nil {
+		return FunctionMeta{}, nil, fmt.Errorf("failed to get binary: %w", err)
+	}
+
+	return meta, binary, nil
+}
+
+// ListFunctions returns a list of all available functions
+func (r *FileRegistry) ListFunctions() ([]FunctionMeta, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	files, err := filepath.Glob(filepath.Join(r.dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list functions: %w", err)
+	}
+
+	functions := make([]FunctionMeta, 0, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+
+		meta, err := decodeMeta(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal %s: %w", file, err)
+		}
+		functions = append(functions, meta)
+	}
+	return functions, nil
+}
+
+// DeleteFunction removes a function
+func (r *FileRegistry) DeleteFunction(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	metaPath, binPath, err := r.paths(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(metaPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete metadata: %w", err)
+	}
+	if err := os.Remove(binPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete binary: %w", err)
+	}
+	return nil
+}
+
+// DeployFunctions stores a set of functions all-or-nothing.
+// Functions written before a failure are restored from their previous files.
+func (r *FileRegistry) DeployFunctions(deployments []FunctionDeployment) error {
+	if err := validateDeployments(deployments); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	type snapshot struct {
+		meta   []byte
+		binary []byte
+	}
+	previous := make([]*snapshot, len(deployments))
+	for i, d := range deployments {
+		metaPath, binPath, err := r.paths(d.Meta.Name)
+		if err != nil {
+			return err
+		}
+		metaData, metaErr := os.ReadFile(metaPath)
+		binary, binErr := os.ReadFile(binPath)
+		if metaErr == nil && binErr == nil {
+			previous[i] = &snapshot{meta: metaData, binary: binary}
+		}
+	}
+
+	for i, d := range deployments {
+		if err := r.store(d.Meta, d.Binary); err != nil {
+			var rollbackErrs []error
+			for j := i; j >= 0; j-- {
+				metaPath, binPath, _ := r.paths(deployments[j].Meta.Name)
+				if previous[j] == nil {
+					rollbackErrs = append(rollbackErrs, ignoreNotExist(os.Remove(metaPath)), ignoreNotExist(os.Remove(binPath)))
+					continue
+				}
+				rollbackErrs = append(rollbackErrs,
+					writeFileAtomic(binPath, previous[j].binary),
+					writeFileAtomic(metaPath, previous[j].meta))
+			}
+			return &DeploymentError{Function: d.Meta.Name, Err: err, RollbackErr: errors.Join(rollbackErrs...)}
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic replaces a file by writing a temporary file and renaming it
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ignoreNotExist drops errors about files that do not exist
+func ignoreNotExist(err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
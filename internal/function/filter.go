@@ -0,0 +1,90 @@
+package function
+
+import (
+	"fmt"
+	"strings"
+
+	ce "github.com/cloudevents/sdk-go/v2"
+)
+
+// EventRejectedError is returned when an event does not match the event types or
+// sources a function declares in its metadata
+type EventRejectedError struct {
+	FunctionName string
+	EventType    string
+	EventSource  string
+	Reason       string
+}
+
+func (e *EventRejectedError) Error() string {
+	return fmt.Sprintf("function %s does not accept event (type %q, source %q): %s",
+		e.FunctionName, e.EventType, e.EventSource, e.Reason)
+}
+
+// AcceptsEvent checks an event against the function's declared event types and sources.
+// Empty lists accept everything; patterns may use "*" to match any sequence of characters.
+// A non-matching event yields an *EventRejectedError.
+func (m FunctionMeta) AcceptsEvent(event *ce.Event) error {
+	if len(m.EventTypes) == 0 && len(m.EventSources) == 0 {
+		return nil
+	}
+
+	if event == nil {
+		return &EventRejectedError{FunctionName: m.Name, Reason: "missing event"}
+	}
+
+	if len(m.EventTypes) > 0 && !matchAnyEventPattern(m.EventTypes, event.Type()) {
+		return &EventRejectedError{
+			FunctionName: m.Name,
+			EventType:    event.Type(),
+			EventSource:  event.Source(),
+			Reason:       fmt.Sprintf("type not in %v", m.EventTypes),
+		}
+	}
+
+	if len(m.EventSources) > 0 && !matchAnyEventPattern(m.EventSources, event.Source()) {
+		return &EventRejectedError{
+			FunctionName: m.Name,
+			EventType:    event.Type(),
+			EventSource:  event.Source(),
+			Reason:       fmt.Sprintf("source not in %v", m.EventSources),
+		}
+	}
+
+	return nil
+}
+
+// matchAnyEventPattern reports whether value matches any of the patterns
+func matchAnyEventPattern(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matchEventPattern(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchEventPattern matches value against a pattern where "*" matches any sequence
+// of characters and everything else is literal
+func matchEventPattern(pattern, value string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == value
+	}
+
+	if !strings.HasPrefix(value, parts[0]) {
+		return false
+	}
+	value = value[len(parts[0]):]
+
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(value, part)
+		if i < 0 {
+			return false
+		}
+		value = value[i+len(part):]
+	}
+
+	return len(value) >= len(last) && strings.HasSuffix(value, last)
+}
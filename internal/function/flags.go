@@ -0,0 +1,145 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// DefaultFlagBucket is the KV bucket used for function feature flags
+const DefaultFlagBucket = "function-flags"
+
+// FlagMetricsCollector is a MetricsCollector that also records feature flag evaluations,
+// e.g. to count how many invocations took a new code path while it is rolled out
+type FlagMetricsCollector interface {
+	MetricsCollector
+	// RecordFlagEvaluation records the value a function read for a flag, "unset" for
+	// flags that are not set
+	RecordFlagEvaluation(functionName, flag, value string)
+}
+
+// Flags are the feature flags of a single function
+type Flags interface {
+	// Lookup returns the value of a flag; ok is false when the flag is not set
+	Lookup(name string) (value string, ok bool)
+}
+
+type flagsContextKey struct{}
+
+// WithFlags returns a context carrying the function's feature flags
+func WithFlags(ctx context.Context, flags Flags) context.Context {
+	return context.WithValue(ctx, flagsContextKey{}, flags)
+}
+
+// FlagValue returns the value of a feature flag of the invoked function, or fallback
+// when the flag is not set or the invocation has no flags. go-plugin functions look
+// flags up in the runtime through its host service, see hostFlags.
+func FlagValue(ctx context.Context, name, fallback string) string {
+	flags, ok := ctx.Value(flagsContextKey{}).(Flags)
+	if !ok || flags == nil {
+		return fallback
+	}
+	if value, ok := flags.Lookup(name); ok {
+		return value
+	}
+	return fallback
+}
+
+// FlagEnabled reports whether a feature flag of the invoked function is set to a true
+// value (1, t, true, ...). Unset flags and invocations without flags are disabled.
+func FlagEnabled(ctx context.Context, name string) bool {
+	enabled, _ := strconv.ParseBool(FlagValue(ctx, name, "false"))
+	return enabled
+}
+
+// FlagKey returns the KV key of a function's flag
+func FlagKey(functionName, flag string) string {
+	return functionName + "." + flag
+}
+
+// KVFlagProvider serves feature flags from a JetStream KV bucket holding each flag under
+// FlagKey(function, flag). It watches the bucket, so flag changes apply to the next
+// invocation without reloading or redeploying functions.
+type KVFlagProvider struct {
+	watcher jetstream.KeyWatcher
+	metrics MetricsCollector
+	mu      sync.RWMutex
+	values  map[string]string
+}
+
+// NewKVFlagProvider loads the flags of a bucket and keeps them up to date until Stop
+func NewKVFlagProvider(ctx context.Context, kv jetstream.KeyValue, metrics MetricsCollector) (*KVFlagProvider, error) {
+	watcher, err := kv.WatchAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch flag bucket: %w", err)
+	}
+	p := &KVFlagProvider{
+		watcher: watcher,
+		metrics: metrics,
+		values:  make(map[string]string),
+	}
+
+	// The watcher delivers the current values followed by a nil entry
+	for entry := range watcher.Updates() {
+		if entry == nil {
+			go p.watch()
+			return p, nil
+		}
+		p.apply(entry)
+	}
+	return nil, fmt.Errorf("failed to load flags: watcher stopped")
+}
+
+// watch applies flag changes until the watcher stops
+func (p *KVFlagProvider) watch() {
+	for entry := range p.watcher.Updates() {
+		if entry != nil {
+			p.apply(entry)
+		}
+	}
+}
+
+func (p *KVFlagProvider) apply(entry jetstream.KeyValueEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if entry.Operation() == jetstream.KeyValuePut {
+		p.values[entry.Key()] = string(entry.Value())
+	} else {
+		delete(p.values, entry.Key())
+	}
+}
+
+// For returns the flags of a function
+func (p *KVFlagProvider) For(functionName string) Flags {
+	return &functionFlags{provider: p, functionName: functionName}
+}
+
+// Stop stops watching the bucket; the flags keep their last values
+func (p *KVFlagProvider) Stop() error {
+	return p.watcher.Stop()
+}
+
+// functionFlags are the flags of one function, recording every lookup
+type functionFlags struct {
+	provider     *KVFlagProvider
+	functionName string
+}
+
+// Lookup returns the value of a flag; ok is false when the flag is not set
+func (f *functionFlags) Lookup(name string) (string, bool) {
+	f.provider.mu.RLock()
+	value, ok := f.provider.values[FlagKey(f.functionName, name)]
+	f.provider.mu.RUnlock()
+
+	if metrics, isFlagMetrics := f.provider.metrics.(FlagMetricsCollector); isFlagMetrics {
+		recorded := value
+		if !ok {
+			recorded = "unset"
+		}
+		metrics.RecordFlagEvaluation(f.functionName, name, recorded)
+	}
+	return value, ok
+}
@@ -3,11 +3,26 @@ package function
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	mevent "github.com/julianshen/mycelium/internal/event"
+
 	ce "github.com/cloudevents/sdk-go/v2"
+	"github.com/hashicorp/go-plugin"
 	"github.com/nats-io/nats.go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -116,6 +131,97 @@ func TestMemoryRegistry(t *testing.T) {
 	assert.Len(t, functions, 0)
 }
 
+// TestPageFunctions tests paging functions listed by registries that do not page
+func TestPageFunctions(t *testing.T) {
+	functions := []FunctionMeta{{Name: "resize"}, {Name: "alpha"}, {Name: "notify"}, {Name: "billing"}}
+
+	page := PageFunctions(functions, FunctionListOptions{Limit: 2})
+	assert.Equal(t, []FunctionMeta{{Name: "alpha"}, {Name: "billing"}}, page.Functions)
+	assert.Equal(t, "billing", page.Next)
+
+	page = PageFunctions(functions, FunctionListOptions{After: page.Next, Limit: 2})
+	assert.Equal(t, []FunctionMeta{{Name: "notify"}, {Name: "resize"}}, page.Functions)
+	assert.Empty(t, page.Next)
+
+	assert.Len(t, PageFunctions(functions, FunctionListOptions{}).Functions, 4)
+}
+
+// TestMemoryRegistryConcurrency tests concurrent use of the in-memory registry
+func TestMemoryRegistryConcurrency(t *testing.T) {
+	registry := &MemoryRegistry{}
+	done := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		go func(i int) {
+			defer func() { done <- struct{}{} }()
+			for j := 0; j < 100; j++ {
+				name := fmt.Sprintf("fn-%d", j%10)
+				assert.NoError(t, registry.StoreFunction(FunctionMeta{Name: name, Version: fmt.Sprint(i)}, nil))
+				registry.GetFunction(name)
+				_, err := registry.ListFunctions()
+				assert.NoError(t, err)
+				if j%7 == 0 {
+					assert.NoError(t, registry.DeleteFunction(name))
+				}
+			}
+		}(i)
+	}
+	for i := 0; i < 8; i++ {
+		<-done
+	}
+}
+
+// TestMemoryRegistryTTL tests eviction of functions after the TTL
+func TestMemoryRegistryTTL(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	registry := NewMemoryRegistry(MemoryRegistryConfig{TTL: time.Minute})
+	registry.now = func() time.Time { return now }
+
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "old"}, nil))
+	now = now.Add(30 * time.Second)
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "new"}, nil))
+	now = now.Add(45 * time.Second)
+
+	_, _, err := registry.GetFunction("old")
+	assert.ErrorContains(t, err, "not found")
+	_, _, err = registry.GetFunction("new")
+	assert.NoError(t, err)
+	functions, err := registry.ListFunctions()
+	require.NoError(t, err)
+	require.Len(t, functions, 1)
+	assert.Equal(t, "new", functions[0].Name)
+	assert.Equal(t, 1, registry.EvictExpired())
+
+	// Storing a function again restarts its TTL
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "new"}, nil))
+	now = now.Add(45 * time.Second)
+	_, _, err = registry.GetFunction("new")
+	assert.NoError(t, err)
+}
+
+// TestMemoryRegistrySnapshot tests saving and restoring the in-memory registry
+func TestMemoryRegistrySnapshot(t *testing.T) {
+	registry := &MemoryRegistry{}
+	meta := FunctionMeta{Name: "echo", Type: "builtin", Version: "1.0.0", Config: map[string]string{"env": "test"}}
+	require.NoError(t, registry.StoreFunction(meta, []byte("binary")))
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "example", Type: "builtin", Version: "2.0.0"}, nil))
+
+	path := filepath.Join(t.TempDir(), "registry.json")
+	require.NoError(t, registry.SaveSnapshot(path))
+
+	restored := &MemoryRegistry{}
+	require.NoError(t, restored.StoreFunction(FunctionMeta{Name: "stale"}, nil))
+	require.NoError(t, restored.LoadSnapshot(path))
+	got, binary, err := restored.GetFunction("echo")
+	require.NoError(t, err)
+	assert.Equal(t, meta, got)
+	assert.Equal(t, []byte("binary"), binary)
+	functions, err := restored.ListFunctions()
+	require.NoError(t, err)
+	assert.Len(t, functions, 2, "restoring replaces the registry's functions")
+
+	assert.ErrorContains(t, restored.Restore(strings.NewReader(`{"version": 9}`)), "unsupported snapshot version")
+}
+
 // TestSimpleMetricsCollector tests the metrics collection
 func TestSimpleMetricsCollector(t *testing.T) {
 	metrics := &SimpleMetricsCollector{}
@@ -196,6 +302,14 @@ func TestRuntimeServiceLoadPlugin(t *testing.T) {
 	_, err = rs.loadPlugin(unsupportedMeta, []byte{})
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "unsupported plugin type")
+
+	// Builtins added by the configuration are loaded like the runtime's own
+	rs.builtins = map[string]func(FunctionMeta) (Function, error){
+		"unknown": func(meta FunctionMeta) (Function, error) { return &ExampleFunction{name: meta.Name}, nil },
+	}
+	plugin, err = rs.loadPlugin(unknownMeta, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "unknown", plugin.Name())
 }
 
 // MockNATSTest tests with embedded NATS server (requires NATS server running)
@@ -475,3 +589,1749 @@ func TestRuntimeService(t *testing.T) {
 		t.Fatalf("Failed to start service: %v", err)
 	}
 }
+
+// TestBulkheadIsolation tests that saturating one function does not affect another
+func TestBulkheadIsolation(t *testing.T) {
+	bulkheads := newBulkheads(2, map[string]int{"limited": 1})
+
+	limited := bulkheads.get("limited", false)
+	assert.True(t, limited.tryAcquire())
+	assert.False(t, limited.tryAcquire())
+
+	other := bulkheads.get("other", true)
+	assert.True(t, other.tryAcquire())
+	assert.True(t, other.tryAcquire())
+	assert.False(t, other.tryAcquire())
+	assert.Equal(t, 2, other.inFlight())
+
+	limited.release()
+	assert.True(t, limited.tryAcquire())
+	assert.Same(t, limited, bulkheads.get("limited", true))
+
+	// Functions that are not loaded share a partition instead of adding one per name
+	unknown := bulkheads.get("unknown-1", false)
+	assert.Same(t, unknown, bulkheads.get("unknown-2", false))
+	assert.NotSame(t, other, unknown)
+	assert.True(t, unknown.tryAcquire())
+	assert.Len(t, bulkheads.partitions, 2)
+	assert.Equal(t, 4, bulkheads.inFlight())
+}
+
+// TestStagePlugin tests platform-aware plugin staging and cleanup
+func TestStagePlugin(t *testing.T) {
+	dir, pluginPath, err := stagePlugin([]byte("mock plugin binary"))
+	require.NoError(t, err)
+
+	assert.Equal(t, pluginFileName, filepath.Base(pluginPath))
+	data, err := os.ReadFile(pluginPath)
+	require.NoError(t, err)
+	assert.Equal(t, "mock plugin binary", string(data))
+
+	cmd := pluginCommand(pluginPath)
+	assert.Equal(t, pluginPath, cmd.Path)
+	assert.Equal(t, dir, cmd.Dir)
+
+	removeStagingDir(dir)
+	_, err = os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+}
+
+// TestOfflineBuffer tests ordering, capacity limits, and expiry of the offline buffer
+func TestOfflineBuffer(t *testing.T) {
+	buffer, err := newOfflineBuffer(OfflineBufferConfig{
+		Path:       filepath.Join(t.TempDir(), "offline.db"),
+		MaxEntries: 3,
+		MaxAge:     time.Hour,
+	})
+	require.NoError(t, err)
+	defer buffer.close()
+
+	// An expired message is dropped before the capacity check
+	require.NoError(t, buffer.enqueue(offlineMessage{Subject: "stale", QueuedAt: time.Now().Add(-2 * time.Hour)}))
+	for i := 0; i < 3; i++ {
+		require.NoError(t, buffer.enqueue(offlineMessage{Subject: fmt.Sprintf("events.%d", i), QueuedAt: time.Now()}))
+	}
+	assert.ErrorIs(t, buffer.enqueue(offlineMessage{Subject: "overflow", QueuedAt: time.Now()}), ErrOfflineBufferFull)
+	assert.Equal(t, 3, buffer.len())
+
+	// Flushing stops at the first failure and keeps the remaining messages
+	var sent []string
+	delivered, err := buffer.flush(func(msg offlineMessage) error {
+		if len(sent) == 2 {
+			return nats.ErrDisconnected
+		}
+		sent = append(sent, msg.Subject)
+		return nil
+	})
+	assert.ErrorIs(t, err, nats.ErrDisconnected)
+	assert.Equal(t, 2, delivered)
+	assert.Equal(t, []string{"events.0", "events.1"}, sent)
+	assert.Equal(t, 1, buffer.len())
+
+	delivered, err = buffer.flush(func(msg offlineMessage) error {
+		sent = append(sent, msg.Subject)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+	assert.Equal(t, "events.2", sent[2])
+	assert.Equal(t, 0, buffer.len())
+}
+
+// TestOfflineBufferAttempts tests that messages NATS did not deliver stay buffered until
+// they run out of attempts
+func TestOfflineBufferAttempts(t *testing.T) {
+	buffer, err := newOfflineBuffer(OfflineBufferConfig{
+		Path:        filepath.Join(t.TempDir(), "offline.db"),
+		MaxAttempts: 2,
+	})
+	require.NoError(t, err)
+	defer buffer.close()
+
+	require.NoError(t, buffer.enqueue(offlineMessage{Subject: "events.0", Request: true, QueuedAt: time.Now()}))
+	require.NoError(t, buffer.enqueue(offlineMessage{Subject: "events.1", QueuedAt: time.Now()}))
+	var sent []string
+	notDelivered := func(msg offlineMessage) error {
+		if msg.Request {
+			return fmt.Errorf("%w: %w", errNotDelivered, nats.ErrNoResponders)
+		}
+		sent = append(sent, msg.Subject)
+		return nil
+	}
+
+	delivered, err := buffer.flush(notDelivered)
+	assert.ErrorIs(t, err, nats.ErrNoResponders)
+	assert.Zero(t, delivered)
+	assert.Equal(t, 2, buffer.len(), "the undelivered invocation stays ahead of the event")
+
+	// The last attempt discards the invocation and the flush goes on
+	delivered, err = buffer.flush(notDelivered)
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+	assert.Equal(t, []string{"events.1"}, sent)
+	assert.Zero(t, buffer.len())
+}
+
+// TestClientFlushWithoutRuntime tests that invocations flushed while no runtime is
+// subscribed stay buffered and are delivered once one is
+func TestClientFlushWithoutRuntime(t *testing.T) {
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	client, err := NewClient(ClientConfig{
+		NATSURL: "nats://localhost:4222",
+		Timeout: time.Second,
+		OfflineBuffer: &OfflineBufferConfig{
+			Path:          filepath.Join(t.TempDir(), "offline.db"),
+			RetryInterval: 100 * time.Millisecond,
+		},
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	subject := fmt.Sprintf("offline-runtime.%d", time.Now().UnixNano())
+	require.NoError(t, client.offline.enqueue(offlineMessage{
+		Subject:  subject,
+		Data:     []byte("{}"),
+		Request:  true,
+		QueuedAt: time.Now(),
+	}))
+	_, err = client.FlushOffline()
+	assert.ErrorIs(t, err, nats.ErrNoResponders)
+	assert.Equal(t, 1, client.PendingOffline())
+
+	// The scheduled retry delivers it once a runtime answers
+	received := make(chan struct{}, 1)
+	sub, err := nc.Subscribe(subject, func(msg *nats.Msg) {
+		received <- struct{}{}
+		msg.Respond([]byte("{}"))
+	})
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+	require.NoError(t, nc.Flush())
+
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("buffered invocation was not retried")
+	}
+	require.Eventually(t, func() bool { return client.PendingOffline() == 0 }, 5*time.Second, 10*time.Millisecond)
+}
+
+// TestOfflineBufferPublishDuringFlush tests that messages published during a flush are
+// queued behind the buffered ones and delivered by the same flush
+func TestOfflineBufferPublishDuringFlush(t *testing.T) {
+	buffer, err := newOfflineBuffer(OfflineBufferConfig{Path: filepath.Join(t.TempDir(), "offline.db")})
+	require.NoError(t, err)
+	defer buffer.close()
+
+	queued, _, err := buffer.enqueueBehind(offlineMessage{Subject: "direct", QueuedAt: time.Now()})
+	require.NoError(t, err)
+	assert.False(t, queued, "a drained buffer lets messages be sent directly")
+
+	require.NoError(t, buffer.enqueue(offlineMessage{Subject: "events.0", QueuedAt: time.Now()}))
+	queued, idle, err := buffer.enqueueBehind(offlineMessage{Subject: "events.1", QueuedAt: time.Now()})
+	require.NoError(t, err)
+	assert.True(t, queued)
+	assert.True(t, idle)
+
+	sending := make(chan struct{})
+	proceed := make(chan struct{})
+	var sent []string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buffer.flush(func(msg offlineMessage) error {
+			if len(sent) == 0 {
+				close(sending)
+				<-proceed
+			}
+			sent = append(sent, msg.Subject)
+			return nil
+		})
+	}()
+
+	<-sending
+	queued, idle, err = buffer.enqueueBehind(offlineMessage{Subject: "events.2", QueuedAt: time.Now()})
+	require.NoError(t, err)
+	assert.True(t, queued)
+	assert.False(t, idle, "the running flush delivers it")
+	close(proceed)
+	<-done
+
+	assert.Equal(t, []string{"events.0", "events.1", "events.2"}, sent)
+	queued, _, err = buffer.enqueueBehind(offlineMessage{Subject: "direct", QueuedAt: time.Now()})
+	require.NoError(t, err)
+	assert.False(t, queued)
+}
+
+// TestClientPublishesBehindOfflineBuffer tests that events published once NATS is
+// reachable again are delivered after the buffered ones
+func TestClientPublishesBehindOfflineBuffer(t *testing.T) {
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	client, err := NewClient(ClientConfig{
+		NATSURL: "nats://localhost:4222",
+		OfflineBuffer: &OfflineBufferConfig{
+			Path: filepath.Join(t.TempDir(), "offline.db"),
+		},
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	received := make(chan string, 10)
+	sub, err := nc.Subscribe("offline-order.>", func(msg *nats.Msg) { received <- msg.Subject })
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+	require.NoError(t, nc.Flush())
+
+	// Messages buffered while disconnected wait for the flush of the reconnect
+	for i := 0; i < 3; i++ {
+		require.NoError(t, client.offline.enqueue(offlineMessage{
+			Subject:  fmt.Sprintf("offline-order.buffered%d", i),
+			Data:     []byte("{}"),
+			QueuedAt: time.Now(),
+		}))
+	}
+
+	event := ce.NewEvent()
+	event.SetID("order-1")
+	event.SetSource("offline-test")
+	event.SetType("com.example.offline")
+	assert.ErrorIs(t, client.PublishEvent(context.Background(), "offline-order.live", &event), ErrQueuedOffline)
+
+	var subjects []string
+	for len(subjects) < 4 {
+		select {
+		case subject := <-received:
+			subjects = append(subjects, subject)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received only %v", subjects)
+		}
+	}
+	assert.Equal(t, []string{
+		"offline-order.buffered0", "offline-order.buffered1", "offline-order.buffered2", "offline-order.live",
+	}, subjects)
+
+	// Once drained, events are published directly
+	require.Eventually(t, func() bool { return client.PendingOffline() == 0 }, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, client.PublishEvent(context.Background(), "offline-order.direct", &event))
+	assert.Equal(t, "offline-order.direct", <-received)
+}
+
+// TestClientQueuesWhileOffline tests that the client buffers invocations when NATS is unreachable
+func TestClientQueuesWhileOffline(t *testing.T) {
+	client, err := NewClient(ClientConfig{
+		NATSURL: "nats://127.0.0.1:1",
+		OfflineBuffer: &OfflineBufferConfig{
+			Path: filepath.Join(t.TempDir(), "offline.db"),
+		},
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	event := ce.NewEvent()
+	event.SetID("offline-1")
+	event.SetSource("offline-test")
+	event.SetType("com.example.offline")
+
+	_, err = client.InvokeFunction(context.Background(), "example", &event)
+	assert.ErrorIs(t, err, ErrQueuedOffline)
+
+	err = client.PublishEvent(context.Background(), "events.offline", &event)
+	assert.ErrorIs(t, err, ErrQueuedOffline)
+	assert.Equal(t, 2, client.PendingOffline())
+}
+
+// TestWatchdogFlagsAndCancelsStuckInvocations tests stuck detection and the hard ceiling
+func TestWatchdogFlagsAndCancelsStuckInvocations(t *testing.T) {
+	rs := &RuntimeService{
+		metrics:  &SimpleMetricsCollector{},
+		logger:   &SimpleLogger{},
+		watchdog: WatchdogConfig{StuckThreshold: time.Minute, HardCeiling: 5 * time.Minute},
+	}
+
+	started := time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	id := rs.inFlight.start(&invocation{functionName: "slow", eventID: "e1", started: started, cancel: cancel})
+
+	rs.checkInFlight(started.Add(30 * time.Second))
+	require.Len(t, rs.InFlightInvocations(), 1)
+	assert.False(t, rs.InFlightInvocations()[0].Stuck)
+
+	rs.checkInFlight(started.Add(2 * time.Minute))
+	assert.True(t, rs.InFlightInvocations()[0].Stuck)
+	assert.NoError(t, ctx.Err())
+
+	rs.checkInFlight(started.Add(6 * time.Minute))
+	assert.True(t, rs.InFlightInvocations()[0].Cancelled)
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+
+	stats := rs.watchdogStats(nil).(map[string]any)
+	assert.Equal(t, 1, stats["in_flight"])
+
+	rs.inFlight.finish(id)
+	assert.Empty(t, rs.InFlightInvocations())
+}
+
+// TestInvocationBudget tests the deadline helpers and heartbeats restarting the stuck threshold
+func TestInvocationBudget(t *testing.T) {
+	_, ok := Remaining(context.Background())
+	assert.False(t, ok)
+	assert.False(t, DeadlineNear(context.Background(), time.Hour))
+	Heartbeat(context.Background(), "ignored outside the runtime")
+
+	// The hard ceiling bounds the reported deadline below the caller's budget
+	started := time.Now()
+	ctx, cancel := context.WithDeadline(context.Background(), started.Add(time.Minute))
+	defer cancel()
+	ctx = withBudget(ctx, invocationDeadline(started, time.Minute, 10*time.Second), nil)
+	remaining, ok := Remaining(ctx)
+	require.True(t, ok)
+	assert.InDelta(t, 10*time.Second, remaining, float64(time.Second))
+	assert.True(t, DeadlineNear(ctx, 20*time.Second))
+	assert.False(t, DeadlineNear(ctx, time.Second))
+	assert.True(t, invocationDeadline(started, 0, 0).IsZero())
+
+	rs := &RuntimeService{
+		metrics:  &SimpleMetricsCollector{},
+		logger:   &SimpleLogger{},
+		watchdog: WatchdogConfig{StuckThreshold: time.Minute},
+	}
+	id := rs.inFlight.start(&invocation{functionName: "batch", started: started, cancel: func() {}})
+	ctx = withBudget(context.Background(), time.Time{}, func(progress string) {
+		rs.inFlight.heartbeat(id, progress, started.Add(90*time.Second))
+	})
+	Heartbeat(ctx, "40 of 100 items")
+	rs.checkInFlight(started.Add(2 * time.Minute))
+	invocations := rs.InFlightInvocations()
+	require.Len(t, invocations, 1)
+	assert.False(t, invocations[0].Stuck, "heartbeats restart the stuck threshold")
+	assert.Equal(t, "40 of 100 items", invocations[0].Progress)
+	rs.checkInFlight(started.Add(3 * time.Minute))
+	assert.True(t, rs.InFlightInvocations()[0].Stuck)
+}
+
+// TestFunctionMetaAcceptsEvent tests input event filtering declared in function metadata
+func TestFunctionMetaAcceptsEvent(t *testing.T) {
+	event := ce.NewEvent()
+	event.SetID("filter-test")
+	event.SetSource("mycelium/users/api")
+	event.SetType("user.updated")
+
+	tests := []struct {
+		name   string
+		meta   FunctionMeta
+		accept bool
+	}{
+		{"no filters", FunctionMeta{Name: "f"}, true},
+		{"exact type", FunctionMeta{Name: "f", EventTypes: []string{"user.updated"}}, true},
+		{"wildcard type", FunctionMeta{Name: "f", EventTypes: []string{"order.*", "user.*"}}, true},
+		{"other type", FunctionMeta{Name: "f", EventTypes: []string{"user.deleted"}}, false},
+		{"wildcard source", FunctionMeta{Name: "f", EventSources: []string{"mycelium/*/api"}}, true},
+		{"other source", FunctionMeta{Name: "f", EventTypes: []string{"user.*"}, EventSources: []string{"billing"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.meta.AcceptsEvent(&event)
+			if tt.accept {
+				assert.NoError(t, err)
+				return
+			}
+			var rejected *EventRejectedError
+			require.ErrorAs(t, err, &rejected)
+			assert.Equal(t, "user.updated", rejected.EventType)
+		})
+	}
+}
+
+// TestSetCorrelation tests the correlation extensions set on response events
+func TestSetCorrelation(t *testing.T) {
+	request := ce.NewEvent()
+	request.SetID("request-1")
+
+	response := ce.NewEvent()
+	SetCorrelation(&response, &request, "invocation-1")
+	assert.Equal(t, "request-1", CorrelationID(&response))
+	assert.Equal(t, "invocation-1", InvocationID(&response))
+	assert.True(t, IsResponseTo(&response, &request))
+
+	// An explicit correlation chosen by the function is kept
+	other := ce.NewEvent()
+	other.SetID("request-2")
+	SetCorrelation(&response, &other, "invocation-2")
+	assert.Equal(t, "request-1", CorrelationID(&response))
+	assert.Equal(t, "invocation-2", InvocationID(&response))
+	assert.False(t, IsResponseTo(&response, &other))
+}
+
+// TestMigrateRegistry tests copying functions between backends with digest verification
+func TestMigrateRegistry(t *testing.T) {
+	source := &MemoryRegistry{}
+	require.NoError(t, source.StoreFunction(FunctionMeta{Name: "resize", Type: "hashicorp-plugin", Version: "1.2.0"}, []byte("resize-bin")))
+	require.NoError(t, source.StoreFunction(FunctionMeta{Name: "notify", Type: "builtin", Version: "1.0.0"}, []byte("notify-bin")))
+
+	target, err := NewFileRegistry(t.TempDir())
+	require.NoError(t, err)
+
+	report, err := MigrateRegistry(source, target, MigrateOptions{DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"notify", "resize"}, report.Copied)
+	functions, err := target.ListFunctions()
+	require.NoError(t, err)
+	assert.Empty(t, functions)
+
+	report, err = MigrateRegistry(source, target, MigrateOptions{})
+	require.NoError(t, err)
+	assert.Len(t, report.Copied, 2)
+
+	meta, binary, err := target.GetFunction("resize")
+	require.NoError(t, err)
+	assert.Equal(t, "1.2.0", meta.Version)
+	assert.Equal(t, BinaryDigest([]byte("resize-bin")), BinaryDigest(binary))
+
+	// Running again only copies what changed
+	require.NoError(t, source.StoreFunction(FunctionMeta{Name: "notify", Type: "builtin", Version: "1.1.0"}, []byte("notify-bin")))
+	report, err = MigrateRegistry(source, target, MigrateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"notify"}, report.Copied)
+	assert.Equal(t, []string{"resize"}, report.UpToDate)
+}
+
+// TestDualWriteRegistry tests mirroring writes during a backend cutover
+func TestDualWriteRegistry(t *testing.T) {
+	primary := &MemoryRegistry{}
+	secondary, err := NewFileRegistry(t.TempDir())
+	require.NoError(t, err)
+
+	var mirrorErrors []string
+	registry := &DualWriteRegistry{
+		Primary:   primary,
+		Secondary: secondary,
+		OnSecondaryError: func(op, name string, err error) {
+			mirrorErrors = append(mirrorErrors, op+" "+name)
+		},
+	}
+
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "resize", Version: "1.0.0"}, []byte("bin")))
+	_, _, err = secondary.GetFunction("resize")
+	require.NoError(t, err)
+
+	// Secondary failures are reported without failing the write
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "bad/name"}, []byte("bin")))
+	assert.Equal(t, []string{"store bad/name"}, mirrorErrors)
+
+	registry.ReadSecondary = true
+	functions, err := registry.ListFunctions()
+	require.NoError(t, err)
+	assert.Len(t, functions, 1)
+
+	require.NoError(t, registry.DeleteFunction("resize"))
+	_, _, err = secondary.GetFunction("resize")
+	assert.Error(t, err)
+}
+
+// TestResourceRequirements tests parsing function resource requirements from metadata
+func TestResourceRequirements(t *testing.T) {
+	req, err := FunctionMeta{Config: map[string]string{"memory": "128Mi", "cpu": "500m"}}.Resources()
+	require.NoError(t, err)
+	assert.Equal(t, ResourceRequirements{MemoryBytes: 128 << 20, MilliCPU: 500}, req)
+
+	req, err = FunctionMeta{Config: map[string]string{"memory": "1G", "cpu": "1.5"}}.Resources()
+	require.NoError(t, err)
+	assert.Equal(t, ResourceRequirements{MemoryBytes: 1e9, MilliCPU: 1500}, req)
+
+	req, err = FunctionMeta{}.Resources()
+	require.NoError(t, err)
+	assert.Equal(t, ResourceRequirements{}, req)
+
+	_, err = FunctionMeta{Config: map[string]string{"memory": "lots"}}.Resources()
+	assert.Error(t, err)
+	_, err = FunctionMeta{Config: map[string]string{"cpu": "-1"}}.Resources()
+	assert.Error(t, err)
+}
+
+// TestFunctionTimeout tests parsing Config["timeout"] and the runtime's default timeout
+func TestFunctionTimeout(t *testing.T) {
+	timeout, ok, err := FunctionMeta{Config: map[string]string{"timeout": "5s"}}.Timeout()
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Second, timeout)
+
+	_, ok, err = FunctionMeta{}.Timeout()
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, _, err = FunctionMeta{Config: map[string]string{"timeout": "soon"}}.Timeout()
+	assert.Error(t, err)
+	_, _, err = FunctionMeta{Config: map[string]string{"timeout": "-1s"}}.Timeout()
+	assert.Error(t, err)
+
+	rs := &RuntimeService{
+		metas:   map[string]FunctionMeta{"slow": {Name: "slow", Config: map[string]string{"timeout": "1m"}}},
+		timeout: 10 * time.Second,
+	}
+	assert.Equal(t, time.Minute, rs.functionTimeout("slow"))
+	assert.Equal(t, 10*time.Second, rs.functionTimeout("example"))
+
+	// Functions with an invalid timeout are not loaded
+	registry := &MemoryRegistry{}
+	require.NoError(t, registry.StoreFunction(FunctionMeta{
+		Name:   "example",
+		Type:   "builtin",
+		Config: map[string]string{"timeout": "soon"},
+	}, nil))
+	rs.registry = registry
+	rs.plugins = make(map[string]Plugin)
+	_, err = rs.getPlugin("example")
+	assert.ErrorContains(t, err, "invalid timeout")
+}
+
+// TestRuntimeServiceAdmission tests that functions are only loaded while capacity remains
+func TestRuntimeServiceAdmission(t *testing.T) {
+	registry := &MemoryRegistry{}
+	require.NoError(t, registry.StoreFunction(FunctionMeta{
+		Name:   "example",
+		Type:   "builtin",
+		Config: map[string]string{"memory": "256Mi", "cpu": "1"},
+	}, nil))
+
+	rs := &RuntimeService{
+		registry:     registry,
+		plugins:      make(map[string]Plugin),
+		metrics:      &SimpleMetricsCollector{},
+		logger:       &SimpleLogger{},
+		reservations: reservations{capacity: ResourceRequirements{MemoryBytes: 128 << 20}},
+	}
+
+	_, err := rs.getPlugin("example")
+	assert.ErrorIs(t, err, ErrInsufficientCapacity)
+	assert.Equal(t, ResourceRequirements{}, rs.CapacityStats().Reserved)
+
+	rs.reservations.capacity.MemoryBytes = 512 << 20
+	_, err = rs.getPlugin("example")
+	require.NoError(t, err)
+
+	stats := rs.CapacityStats()
+	assert.Equal(t, ResourceRequirements{MemoryBytes: 256 << 20, MilliCPU: 1000}, stats.Reserved)
+	assert.Equal(t, ResourceRequirements{}, stats.Used)
+}
+
+// slowRegistry counts fetches of functions that take a while
+type slowRegistry struct {
+	*MemoryRegistry
+	fetches atomic.Int32
+}
+
+func (r *slowRegistry) GetFunction(name string) (FunctionMeta, []byte, error) {
+	r.fetches.Add(1)
+	time.Sleep(20 * time.Millisecond)
+	return r.MemoryRegistry.GetFunction(name)
+}
+
+// TestConcurrentColdLoads tests that concurrent cold invocations load a function once
+func TestConcurrentColdLoads(t *testing.T) {
+	registry := &slowRegistry{MemoryRegistry: &MemoryRegistry{}}
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "example", Type: "builtin"}, nil))
+	rs := &RuntimeService{
+		registry: registry,
+		plugins:  make(map[string]Plugin),
+		metrics:  &SimpleMetricsCollector{},
+		logger:   &SimpleLogger{},
+	}
+
+	plugins := make([]Plugin, 8)
+	var wg sync.WaitGroup
+	for i := range plugins {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			plugin, err := rs.getPlugin("example")
+			assert.NoError(t, err)
+			plugins[i] = plugin
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), registry.fetches.Load())
+	for _, plugin := range plugins {
+		assert.Same(t, plugins[0], plugin)
+	}
+	assert.Empty(t, rs.loadLocks)
+}
+
+// versionsRegistry serves any version of the functions it holds
+type versionsRegistry struct {
+	*MemoryRegistry
+}
+
+func (r versionsRegistry) FunctionVersions(name string) ([]FunctionMeta, error) {
+	meta, _, err := r.GetFunction(name)
+	return []FunctionMeta{meta}, err
+}
+
+func (r versionsRegistry) GetFunctionVersion(name, version string) (FunctionMeta, []byte, error) {
+	meta, binary, err := r.GetFunction(name)
+	meta.Version = version
+	return meta, binary, err
+}
+
+// TestVersionEviction tests that versions loaded by reference are bounded and release
+// their reservations when unloaded
+func TestVersionEviction(t *testing.T) {
+	registry := versionsRegistry{&MemoryRegistry{}}
+	require.NoError(t, registry.StoreFunction(FunctionMeta{
+		Name:   "example",
+		Type:   "builtin",
+		Config: map[string]string{"memory": "64Mi"},
+	}, nil))
+	rs := &RuntimeService{
+		registry:     registry,
+		plugins:      make(map[string]Plugin),
+		metrics:      &SimpleMetricsCollector{},
+		logger:       &SimpleLogger{},
+		reservations: reservations{capacity: ResourceRequirements{MemoryBytes: 1 << 30}},
+		maxVersions:  2,
+		versionIdle:  time.Minute,
+	}
+
+	loaded := func() []string {
+		rs.mu.RLock()
+		defer rs.mu.RUnlock()
+		var refs []string
+		for ref := range rs.plugins {
+			refs = append(refs, ref)
+		}
+		sort.Strings(refs)
+		return refs
+	}
+
+	for _, ref := range []string{"example", "example@1", "example@2"} {
+		_, err := rs.getPlugin(ref)
+		require.NoError(t, err)
+	}
+	// Invoking example@1 makes example@2 the least recently used version
+	_, err := rs.getPlugin("example@1")
+	require.NoError(t, err)
+	_, err = rs.getPlugin("example@3")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example", "example@1", "example@3"}, loaded())
+	assert.Equal(t, int64(3*64<<20), rs.CapacityStats().Reserved.MemoryBytes)
+
+	// Idle versions are unloaded, the current version stays
+	rs.evictIdleVersions(time.Now().Add(time.Minute))
+	assert.Equal(t, []string{"example"}, loaded())
+	assert.Equal(t, int64(64<<20), rs.CapacityStats().Reserved.MemoryBytes)
+	assert.Empty(t, rs.versionUse)
+
+	// Unloaded versions are loaded again when invoked
+	_, err = rs.getPlugin("example@2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example", "example@2"}, loaded())
+}
+
+// TestScriptFunction tests running a script over stdio with timeouts and output caps
+func TestScriptFunction(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not available")
+	}
+
+	load := func(script string, config map[string]string) Function {
+		config[ConfigRuntime] = "python3"
+		plugin, err := loadScript(FunctionMeta{Name: "script", Type: TypeScript, Config: config}, []byte(script))
+		require.NoError(t, err)
+		t.Cleanup(func() { plugin.(*scriptPlugin).Close() })
+		return plugin.Function()
+	}
+
+	event := ce.NewEvent()
+	event.SetID("script-1")
+	event.SetSource("test")
+	event.SetType("order.created")
+	require.NoError(t, event.SetData(ce.ApplicationJSON, map[string]interface{}{"amount": 21}))
+
+	echo := load(`
+import json, sys
+event = json.load(sys.stdin)
+for i in range(2):
+    print(json.dumps({"specversion": "1.0", "id": "out-%d" % i, "source": "script",
+                      "type": event["type"] + ".processed", "data": {"amount": event["data"]["amount"] * 2}}))
+`, map[string]string{})
+	events, err := echo.Execute(context.Background(), &event)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, "order.created.processed", events[0].Type())
+	assert.JSONEq(t, `{"amount": 42}`, string(events[1].Data()))
+
+	silent := load("import sys; sys.stdin.read()", map[string]string{})
+	events, err = silent.Execute(context.Background(), &event)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	failing := load("import sys; sys.stderr.write('boom'); sys.exit(3)", map[string]string{})
+	_, err = failing.Execute(context.Background(), &event)
+	assert.ErrorContains(t, err, "boom")
+
+	slow := load("import time; time.sleep(5)", map[string]string{ConfigTimeout: "200ms"})
+	_, err = slow.Execute(context.Background(), &event)
+	assert.ErrorContains(t, err, "timed out")
+
+	chatty := load("print('x' * 4096)", map[string]string{ConfigMaxOutput: "1Ki"})
+	_, err = chatty.Execute(context.Background(), &event)
+	assert.ErrorIs(t, err, ErrScriptOutputTooLarge)
+
+	_, err = loadScript(FunctionMeta{Name: "script", Type: TypeScript, Config: map[string]string{ConfigRuntime: "cobol"}}, nil)
+	assert.Error(t, err)
+}
+
+// TestJavaScriptFunction tests running JavaScript in the embedded engine
+func TestJavaScriptFunction(t *testing.T) {
+	var logs []string
+	writer := &lineWriter{emit: func(line string) { logs = append(logs, line) }}
+	output := func(functionName, stream string) io.Writer { return writer }
+	load := func(source string, config map[string]string) Function {
+		plugin, err := loadJavaScript(FunctionMeta{Name: "transform", Type: TypeJavaScript, Config: config}, []byte(source), output)
+		require.NoError(t, err)
+		return plugin.Function()
+	}
+
+	event := ce.NewEvent()
+	event.SetID("js-1")
+	event.SetSource("test")
+	event.SetType("order.created")
+	event.SetExtension("tenant", "acme")
+	require.NoError(t, event.SetData(ce.ApplicationJSON, map[string]interface{}{"amount": 21}))
+
+	double := load(`
+function handle(event) {
+  console.log("doubling", event.id, event.tenant);
+  return [1, 2].map(i => ({
+    id: event.id + "-" + i,
+    source: "transform",
+    type: event.type + ".processed",
+    data: {amount: event.data.amount * 2},
+  }));
+}`, nil)
+	events, err := double.Execute(context.Background(), &event)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, "js-1-2", events[1].ID())
+	assert.Equal(t, "order.created.processed", events[0].Type())
+	assert.Equal(t, ce.ApplicationJSON, events[0].DataContentType())
+	assert.JSONEq(t, `{"amount": 42}`, string(events[0].Data()))
+	assert.Equal(t, []string{"doubling js-1 acme"}, logs)
+
+	silent := load("function handle(event) { return null }", nil)
+	events, err = silent.Execute(context.Background(), &event)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	invalid := load(`function handle(event) { return {id: "x", type: "t"} }`, nil)
+	_, err = invalid.Execute(context.Background(), &event)
+	assert.ErrorContains(t, err, "source")
+
+	throwing := load(`function handle(event) { throw new Error("boom") }`, nil)
+	_, err = throwing.Execute(context.Background(), &event)
+	assert.ErrorContains(t, err, "boom")
+
+	slow := load("function handle(event) { for (;;) {} }", map[string]string{ConfigTimeout: "100ms"})
+	_, err = slow.Execute(context.Background(), &event)
+	assert.ErrorContains(t, err, "timed out")
+
+	// Concurrent invocations run on separate runtimes
+	done := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		go func() {
+			_, err := double.Execute(context.Background(), &event)
+			done <- err
+		}()
+	}
+	for i := 0; i < 8; i++ {
+		assert.NoError(t, <-done)
+	}
+
+	_, err = loadJavaScript(FunctionMeta{Name: "broken"}, []byte("function handle( {"), nil)
+	assert.Error(t, err)
+	_, err = loadJavaScript(FunctionMeta{Name: "nohandler"}, []byte("var x = 1"), nil)
+	assert.ErrorContains(t, err, "does not define a handle(event) function")
+}
+
+// TestEventSchemaValidation tests checking event data against a JSON Schema
+func TestEventSchemaValidation(t *testing.T) {
+	schema, err := ParseEventSchema([]byte(`{
+		"type": "object",
+		"required": ["order_id", "amount"],
+		"additionalProperties": false,
+		"properties": {
+			"order_id": {"type": "string", "minLength": 1},
+			"amount": {"type": "number", "minimum": 0},
+			"status": {"type": "string", "enum": ["open", "paid"]},
+			"items": {"type": "array", "items": {"type": "object", "properties": {"sku": {"type": "string"}}}}
+		}
+	}`))
+	require.NoError(t, err)
+
+	event := ce.NewEvent()
+	event.SetType("order.created")
+
+	require.NoError(t, event.SetData(ce.ApplicationJSON, map[string]interface{}{
+		"order_id": "o-1", "amount": 12, "status": "paid", "items": []interface{}{map[string]interface{}{"sku": "a"}},
+	}))
+	assert.Empty(t, schema.ValidateEventData(&event))
+
+	require.NoError(t, event.SetData(ce.ApplicationJSON, map[string]interface{}{
+		"amount": -1, "status": "lost", "items": []interface{}{map[string]interface{}{"sku": 7}}, "note": "x",
+	}))
+	assert.Equal(t, []string{
+		"data.amount: -1 is less than 0",
+		"data.items[0].sku: expected string, got integer",
+		"data.note: unknown field",
+		"data.status: must be one of [open paid]",
+		"data.order_id: required field is missing",
+	}, schema.ValidateEventData(&event))
+
+	_, err = ParseEventSchema([]byte(`{"type": "decimal"}`))
+	assert.Error(t, err)
+}
+
+// TestGenerateGo tests generating Go types and DataAs helpers from event data schemas
+func TestGenerateGo(t *testing.T) {
+	schema, err := ParseEventSchema([]byte(`{
+		"title": "OrderCreated",
+		"type": "object",
+		"required": ["order_id"],
+		"properties": {
+			"order_id": {"type": "string"},
+			"amount": {"type": "number"},
+			"customer": {"type": "object", "properties": {"email": {"type": "string"}}},
+			"tags": {"type": "array", "items": {"type": "string"}}
+		}
+	}`))
+	require.NoError(t, err)
+
+	source, err := GenerateGo("events", map[string]*EventSchema{"com.example.order.created": schema})
+	require.NoError(t, err)
+
+	code := string(source)
+	assert.Contains(t, code, "package events")
+	assert.Contains(t, code, `const OrderCreatedEventType = "com.example.order.created"`)
+	assert.Contains(t, code, "OrderID  string                `json:\"order_id\"`")
+	assert.Contains(t, code, "Amount   *float64              `json:\"amount,omitempty\"`")
+	assert.Contains(t, code, "Customer *OrderCreatedCustomer `json:\"customer,omitempty\"`")
+	assert.Contains(t, code, "Tags     []string              `json:\"tags,omitempty\"`")
+	assert.Contains(t, code, "type OrderCreatedCustomer struct")
+	assert.Contains(t, code, "func DataAsOrderCreated(event *cloudevents.Event) (*OrderCreated, error)")
+}
+
+// TestBinaryCache tests digest-keyed caching with LRU eviction that survives restarts
+func TestBinaryCache(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewBinaryCache(dir, 10)
+	require.NoError(t, err)
+
+	a, b, c := []byte("aaaa"), []byte("bbbb"), []byte("cccc")
+	require.NoError(t, cache.Put(BinaryDigest(a), a))
+	require.NoError(t, cache.Put(BinaryDigest(b), b))
+
+	// Using a makes b the least recently used binary, which c evicts
+	binary, ok := cache.Get(BinaryDigest(a))
+	require.True(t, ok)
+	assert.Equal(t, a, binary)
+	require.NoError(t, cache.Put(BinaryDigest(c), c))
+	_, ok = cache.Get(BinaryDigest(b))
+	assert.False(t, ok)
+	assert.Equal(t, int64(8), cache.Size())
+
+	// Binaries larger than the cache are not cached
+	require.NoError(t, cache.Put(BinaryDigest([]byte("too large!!")), []byte("too large!!")))
+	assert.Equal(t, int64(8), cache.Size())
+
+	// A reopened cache keeps its binaries, and corrupted files are dropped
+	require.NoError(t, os.WriteFile(filepath.Join(dir, BinaryDigest(c)+".bin"), []byte("cccx"), 0644))
+	reopened, err := NewBinaryCache(dir, 10)
+	require.NoError(t, err)
+	binary, ok = reopened.Get(BinaryDigest(a))
+	require.True(t, ok)
+	assert.Equal(t, a, binary)
+	_, ok = reopened.Get(BinaryDigest(c))
+	assert.False(t, ok)
+	assert.Equal(t, int64(4), reopened.Size())
+}
+
+// TestPluginOutputCapture tests that plugin process output is split into lines attributed
+// to the function's in-flight invocations and buffered
+func TestPluginOutputCapture(t *testing.T) {
+	rs := &RuntimeService{
+		logger: &SimpleLogger{},
+		logs:   newFunctionLogs(2),
+	}
+
+	stdout := rs.pluginOutput("echo", "stdout")
+	_, err := stdout.Write([]byte("starting\npart"))
+	require.NoError(t, err)
+
+	id := rs.inFlight.start(&invocation{id: "inv-1", functionName: "echo", started: time.Now()})
+	rs.inFlight.start(&invocation{id: "inv-2", functionName: "other", started: time.Now()})
+	_, err = stdout.Write([]byte("ial line\r\n"))
+	require.NoError(t, err)
+	rs.inFlight.finish(id)
+
+	_, err = rs.pluginOutput("echo", "stderr").Write([]byte("failed\n"))
+	require.NoError(t, err)
+
+	// The buffer keeps the latest lines
+	lines := rs.logs.recent("echo", 0)
+	require.Len(t, lines, 2)
+	assert.Equal(t, "partial line", lines[0].Message)
+	assert.Equal(t, "stdout", lines[0].Stream)
+	assert.Equal(t, []string{"inv-1"}, lines[0].InvocationIDs)
+	assert.Equal(t, "failed", lines[1].Message)
+	assert.Equal(t, "stderr", lines[1].Stream)
+	assert.Empty(t, lines[1].InvocationIDs)
+
+	assert.Len(t, rs.logs.recent("echo", 1), 1)
+	assert.Empty(t, rs.logs.recent("other", 0))
+}
+
+// TestPinRequiresVersionedRegistry tests that pinning fails on registries without versions
+// and leaves the function unpinned
+func TestPinRequiresVersionedRegistry(t *testing.T) {
+	registry := &MemoryRegistry{}
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "example", Type: "builtin", Version: "2.0.0"}, nil))
+	rs := &RuntimeService{
+		registry: registry,
+		plugins:  make(map[string]Plugin),
+		metrics:  &SimpleMetricsCollector{},
+		logger:   &SimpleLogger{},
+	}
+
+	_, err := rs.Pin("example", "1.0.0")
+	assert.ErrorContains(t, err, "does not keep function versions")
+
+	plugin, err := rs.getPlugin("example")
+	require.NoError(t, err)
+	assert.Equal(t, "2.0.0", plugin.Version())
+	assert.False(t, rs.LoadedFunctions()[0].Pinned)
+
+	// Unpinning a loaded function reloads it from the registry
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "example", Type: "builtin", Version: "2.1.0"}, nil))
+	loaded, err := rs.Pin("example", "")
+	require.NoError(t, err)
+	assert.Equal(t, "2.1.0", loaded.Version)
+}
+
+// TestFunctionRefs tests invoking a version by reference alongside the current version
+func TestFunctionRefs(t *testing.T) {
+	name, version := ParseFunctionRef("example@1.0.0")
+	assert.Equal(t, "example", name)
+	assert.Equal(t, "1.0.0", version)
+	name, version = ParseFunctionRef("example@latest")
+	assert.Equal(t, "example", name)
+	assert.Empty(t, version)
+	assert.Equal(t, "example", FunctionRef("example", LatestVersion))
+	assert.Equal(t, "example@2.0.0", FunctionRef("example", "2.0.0"))
+
+	registry := &MemoryRegistry{}
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "example", Type: "builtin", Version: "2.0.0"}, nil))
+	rs := &RuntimeService{
+		registry: registry,
+		plugins:  make(map[string]Plugin),
+		metrics:  &SimpleMetricsCollector{},
+		logger:   &SimpleLogger{},
+	}
+
+	// Registries without versions serve the version they hold by reference
+	current, err := rs.getPlugin("example")
+	require.NoError(t, err)
+	versioned, err := rs.getPlugin("example@2.0.0")
+	require.NoError(t, err)
+	assert.Equal(t, "2.0.0", versioned.Version())
+	assert.NotSame(t, current, versioned)
+	assert.Len(t, rs.LoadedFunctions(), 2)
+
+	_, err = rs.getPlugin("example@1.0.0")
+	assert.ErrorIs(t, err, ErrVersionNotFound)
+}
+
+// TestHTTPEnrichFunction tests the builtin http-enrich function
+func TestHTTPEnrichFunction(t *testing.T) {
+	var calls atomic.Int32
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if failing.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path == "/users/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		assert.Equal(t, "Bearer test-token", r.Header.Get("Authorization"))
+		fmt.Fprintf(w, `{"id": %q, "profile": {"tier": "gold"}}`, r.URL.Path[len("/users/"):])
+	}))
+	defer server.Close()
+
+	load := func(config map[string]string) Function {
+		config[ConfigBuiltin] = BuiltinHTTPEnrich
+		config[ConfigEnrichURL] = server.URL + "/users/{{data.user}}"
+		config["header.Authorization"] = "Bearer test-token"
+		plugin, err := loadBuiltin(FunctionMeta{Name: "user-lookup", Type: TypeBuiltin, Config: config})
+		require.NoError(t, err)
+		return plugin.Function()
+	}
+	eventFor := func(user string) *ce.Event {
+		event := ce.NewEvent()
+		event.SetID("enrich-" + user)
+		event.SetSource("test")
+		event.SetType("order.created")
+		require.NoError(t, event.SetData(ce.ApplicationJSON, map[string]interface{}{"user": user, "amount": 21}))
+		return &event
+	}
+
+	enrich := load(map[string]string{})
+	events, err := enrich.Execute(context.Background(), eventFor("u 1"))
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "enrich-u 1", events[0].ID())
+	assert.JSONEq(t, `{"user": "u 1", "amount": 21, "enrichment": {"id": "u 1", "profile": {"tier": "gold"}}}`, string(events[0].Data()))
+
+	mapped := load(map[string]string{ConfigEnrichMapping: "tier=profile.tier, unknown=profile.none", ConfigEnrichCacheTTL: "1m"})
+	for i := 0; i < 3; i++ {
+		events, err = mapped.Execute(context.Background(), eventFor("u2"))
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"user": "u2", "amount": 21, "tier": "gold"}`, string(events[0].Data()))
+	}
+	assert.Equal(t, int32(2), calls.Load(), "repeated requests are served from the cache")
+
+	_, err = enrich.Execute(context.Background(), eventFor("missing"))
+	assert.ErrorContains(t, err, "404")
+
+	event := ce.NewEvent()
+	event.SetID("no-user")
+	event.SetSource("test")
+	event.SetType("order.created")
+	_, err = enrich.Execute(context.Background(), &event)
+	assert.ErrorContains(t, err, "data.user")
+
+	// Consecutive server errors open the circuit until the cooldown passes
+	failing.Store(true)
+	breaking := load(map[string]string{ConfigEnrichBreakerFailures: "2", ConfigEnrichBreakerCooldown: "100ms"})
+	for i := 0; i < 2; i++ {
+		_, err = breaking.Execute(context.Background(), eventFor("u3"))
+		assert.ErrorContains(t, err, "503")
+	}
+	before := calls.Load()
+	_, err = breaking.Execute(context.Background(), eventFor("u3"))
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, before, calls.Load(), "an open circuit does not call the API")
+
+	failing.Store(false)
+	time.Sleep(150 * time.Millisecond)
+	_, err = breaking.Execute(context.Background(), eventFor("u3"))
+	assert.NoError(t, err)
+
+	// Passthrough emits the event unenriched when the call fails
+	failing.Store(true)
+	passthrough := load(map[string]string{ConfigEnrichOnError: "passthrough"})
+	input := eventFor("u4")
+	events, err = passthrough.Execute(context.Background(), input)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.JSONEq(t, string(input.Data()), string(events[0].Data()))
+
+	_, err = loadBuiltin(FunctionMeta{Name: "user-lookup", Type: TypeBuiltin, Config: map[string]string{ConfigBuiltin: BuiltinHTTPEnrich}})
+	assert.ErrorContains(t, err, "url is required")
+}
+
+// multiEventFunction returns two events
+type multiEventFunction struct{}
+
+func (multiEventFunction) Execute(ctx context.Context, event *ce.Event) ([]*ce.Event, error) {
+	first, second := event.Clone(), event.Clone()
+	second.SetID(event.ID() + "-2")
+	return []*ce.Event{&first, &second}, nil
+}
+
+// TestPluginGRPC tests executing functions over the plugin's gRPC service per ABI version
+func TestPluginGRPC(t *testing.T) {
+	event := ce.NewEvent()
+	event.SetID("grpc-1")
+	event.SetSource("test")
+	event.SetType("order.created")
+
+	for version, want := range map[int]int{PluginABIv1: 1, PluginABIv2: 2} {
+		client, server := plugin.TestPluginGRPCConn(t, false, map[string]plugin.Plugin{
+			"function": &FunctionPlugin{Impl: multiEventFunction{}, ABIVersion: version},
+		})
+		raw, err := client.Dispense("function")
+		require.NoError(t, err)
+		events, err := raw.(Function).Execute(context.Background(), &event)
+		require.NoError(t, err)
+		assert.Len(t, events, want, "ABI version %d", version)
+		assert.Equal(t, "grpc-1", events[0].ID())
+		client.Close()
+		server.Stop()
+	}
+
+	// The call carries the invocation's deadline and partial results keep their events
+	client, server := plugin.TestPluginGRPCConn(t, false, map[string]plugin.Plugin{
+		"function": &FunctionPlugin{Impl: deadlineFunction{}, ABIVersion: PluginABIv2},
+	})
+	defer server.Stop()
+	defer client.Close()
+	raw, err := client.Dispense("function")
+	require.NoError(t, err)
+	events, err := raw.(Function).Execute(context.Background(), &event)
+	require.NoError(t, err)
+	assert.Len(t, events, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	events, err = raw.(Function).Execute(ctx, &event)
+	var partial *PartialResultError
+	require.ErrorAs(t, err, &partial)
+	assert.Equal(t, "deadline near", partial.Reason)
+	assert.Len(t, events, 1)
+}
+
+// TestPluginHostState tests that plugin functions reach the state store of their
+// invocation through the runtime's host service
+func TestPluginHostState(t *testing.T) {
+	client, server := plugin.TestPluginGRPCConn(t, false, map[string]plugin.Plugin{
+		"function": &FunctionPlugin{Impl: counterFunction{}, ABIVersion: PluginABIv2},
+	})
+	defer server.Stop()
+	defer client.Close()
+	raw, err := client.Dispense("function")
+	require.NoError(t, err)
+
+	event := ce.NewEvent()
+	event.SetID("state-1")
+	event.SetSource("test")
+	event.SetType("order.created")
+
+	// Without a state store the function gets ErrStateUnavailable
+	_, err = raw.(Function).Execute(context.Background(), &event)
+	assert.ErrorContains(t, err, ErrStateUnavailable.Error())
+
+	state := &memoryState{values: make(map[string][]byte)}
+	ctx := WithState(context.Background(), state)
+	for i := 1; i <= 2; i++ {
+		events, err := raw.(Function).Execute(ctx, &event)
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		assert.Equal(t, fmt.Sprint(i), string(events[0].Data()))
+	}
+	assert.Equal(t, "2", string(state.values["count"]))
+}
+
+// TestPluginHostFlags tests that plugin functions read the flags of their invocation
+// through the runtime's host service
+func TestPluginHostFlags(t *testing.T) {
+	client, server := plugin.TestPluginGRPCConn(t, false, map[string]plugin.Plugin{
+		"function": &FunctionPlugin{Impl: flagEchoFunction{}, ABIVersion: PluginABIv2},
+	})
+	defer server.Stop()
+	defer client.Close()
+	raw, err := client.Dispense("function")
+	require.NoError(t, err)
+
+	event := ce.NewEvent()
+	event.SetID("flags-1")
+	event.SetSource("test")
+	event.SetType("order.created")
+
+	// Without flags the function gets the fallback
+	events, err := raw.(Function).Execute(context.Background(), &event)
+	require.NoError(t, err)
+	assert.Equal(t, "standard", events[0].Subject())
+
+	ctx := WithFlags(context.Background(), staticFlags{"tier": "gold"})
+	events, err = raw.(Function).Execute(ctx, &event)
+	require.NoError(t, err)
+	assert.Equal(t, "gold", events[0].Subject())
+}
+
+// flagEchoFunction answers with the value of its tier flag as the subject
+type flagEchoFunction struct{}
+
+func (flagEchoFunction) Execute(ctx context.Context, event *ce.Event) ([]*ce.Event, error) {
+	reply := event.Clone()
+	reply.SetSubject(FlagValue(ctx, "tier", "standard"))
+	return []*ce.Event{&reply}, nil
+}
+
+// staticFlags are fixed flag values
+type staticFlags map[string]string
+
+func (f staticFlags) Lookup(name string) (string, bool) {
+	value, ok := f[name]
+	return value, ok
+}
+
+// TestPluginHostHeartbeat tests that heartbeats of plugin functions reach the runtime
+func TestPluginHostHeartbeat(t *testing.T) {
+	client, server := plugin.TestPluginGRPCConn(t, false, map[string]plugin.Plugin{
+		"function": &FunctionPlugin{Impl: heartbeatFunction{}, ABIVersion: PluginABIv2},
+	})
+	defer server.Stop()
+	defer client.Close()
+	raw, err := client.Dispense("function")
+	require.NoError(t, err)
+
+	event := ce.NewEvent()
+	event.SetID("heartbeat-1")
+	event.SetSource("test")
+	event.SetType("order.created")
+
+	var progress []string
+	ctx := withBudget(context.Background(), time.Time{}, func(p string) { progress = append(progress, p) })
+	_, err = raw.(Function).Execute(ctx, &event)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1 of 2", "2 of 2"}, progress)
+}
+
+// heartbeatFunction reports its progress through two steps
+type heartbeatFunction struct{}
+
+func (heartbeatFunction) Execute(ctx context.Context, event *ce.Event) ([]*ce.Event, error) {
+	for i := 1; i <= 2; i++ {
+		Heartbeat(ctx, fmt.Sprintf("%d of 2", i))
+	}
+	return nil, nil
+}
+
+// counterFunction counts its invocations in its state, swapping the count in
+type counterFunction struct{}
+
+func (counterFunction) Execute(ctx context.Context, event *ce.Event) ([]*ce.Event, error) {
+	state, err := StateFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	count := 0
+	value, revision, err := state.Get(ctx, "count")
+	if err == nil {
+		count, _ = strconv.Atoi(string(value))
+	} else if !errors.Is(err, ErrStateKeyNotFound) {
+		return nil, err
+	}
+	if _, err := state.CompareAndSwap(ctx, "count", []byte(strconv.Itoa(count+1)), revision); err != nil {
+		return nil, err
+	}
+	reply := event.Clone()
+	reply.SetData("text/plain", []byte(strconv.Itoa(count+1)))
+	return []*ce.Event{&reply}, nil
+}
+
+// memoryState is a StateStore in memory
+type memoryState struct {
+	mu       sync.Mutex
+	values   map[string][]byte
+	revision uint64
+}
+
+func (s *memoryState) Get(ctx context.Context, key string) ([]byte, uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.values[key]
+	if !ok {
+		return nil, 0, ErrStateKeyNotFound
+	}
+	return value, s.revision, nil
+}
+
+func (s *memoryState) Put(ctx context.Context, key string, value []byte) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	s.revision++
+	return s.revision, nil
+}
+
+func (s *memoryState) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+	return nil
+}
+
+func (s *memoryState) CompareAndSwap(ctx context.Context, key string, value []byte, revision uint64) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.values[key]; ok != (revision != 0) || ok && revision != s.revision {
+		return 0, ErrStateConflict
+	}
+	s.values[key] = value
+	s.revision++
+	return s.revision, nil
+}
+
+// deadlineFunction returns a partial result when its deadline is less than an hour away
+type deadlineFunction struct{}
+
+func (deadlineFunction) Execute(ctx context.Context, event *ce.Event) ([]*ce.Event, error) {
+	if DeadlineNear(ctx, time.Hour) {
+		return []*ce.Event{event}, PartialResult("deadline near")
+	}
+	return []*ce.Event{event}, nil
+}
+
+// TestLoadPluginBinary tests loading a plugin binary over gRPC with mutual TLS
+func TestLoadPluginBinary(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping plugin build in short mode")
+	}
+	path := filepath.Join(t.TempDir(), "echoplugin")
+	build := exec.Command("go", "build", "-o", path, "./testdata/echoplugin")
+	output, err := build.CombinedOutput()
+	require.NoError(t, err, string(output))
+	binary, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	pm := NewPluginManager()
+	defer pm.Close()
+	loaded, err := pm.LoadPlugin(FunctionMeta{Name: "echo", Type: "plugin", Digest: BinaryDigest(binary)}, binary)
+	require.NoError(t, err)
+	assert.Equal(t, PluginABIVersion, loaded.(ABIPlugin).ABIVersion())
+
+	event := ce.NewEvent()
+	event.SetID("echo-1")
+	event.SetSource("test")
+	event.SetType("order.created")
+	events, err := loaded.Function().Execute(context.Background(), &event)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, "echo.reply", events[1].Type())
+
+	event.SetType("echo.fail")
+	_, err = loaded.Function().Execute(context.Background(), &event)
+	assert.EqualError(t, err, "echo failed")
+
+	// The plugin reaches the invocation's state store through the broker
+	event.SetType("echo.state")
+	_, err = loaded.Function().Execute(context.Background(), &event)
+	assert.ErrorContains(t, err, ErrStateUnavailable.Error())
+	state := &memoryState{values: make(map[string][]byte)}
+	events, err = loaded.Function().Execute(WithState(context.Background(), state), &event)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "echo-1", events[0].ID())
+	assert.Equal(t, "echo-1", string(state.values["last"]))
+
+	// Plugins built for another deployment refuse the handshake
+	pm.SetHandshakeSecret("other")
+	_, err = pm.LoadPlugin(FunctionMeta{Name: "echo", Type: "plugin"}, binary)
+	assert.ErrorContains(t, err, "plugin secret")
+}
+
+// abiTestPlugin is a plugin loaded over a negotiated ABI
+type abiTestPlugin struct {
+	ExamplePlugin
+	version int
+}
+
+func (p *abiTestPlugin) ABIVersion() int    { return p.version }
+func (p *abiTestPlugin) Features() []string { return PluginABIFeatures(p.version) }
+
+// TestPluginABINegotiation tests the plugin sets offered per ABI version, what each
+// version returns and the errors of plugins implementing no supported version
+func TestPluginABINegotiation(t *testing.T) {
+	sets := PluginSets(multiEventFunction{})
+	require.Len(t, sets, PluginABIVersion-MinPluginABIVersion+1)
+	assert.Equal(t, PluginABIv2, sets[PluginABIv2]["function"].(*FunctionPlugin).ABIVersion)
+
+	event := ce.NewEvent()
+	event.SetID("abi-1")
+	event.SetSource("test")
+	event.SetType("order.created")
+
+	var v1 FunctionResult
+	require.NoError(t, (&FunctionServer{Impl: multiEventFunction{}, ABIVersion: PluginABIv1}).Execute(context.Background(), &event, &v1))
+	assert.Equal(t, "abi-1", v1.Event.ID())
+	assert.Empty(t, v1.Events)
+
+	var v2 FunctionResult
+	require.NoError(t, (&FunctionServer{Impl: multiEventFunction{}, ABIVersion: PluginABIv2}).Execute(context.Background(), &event, &v2))
+	assert.Equal(t, "abi-1", v2.Event.ID())
+	assert.Len(t, v2.Events, 2)
+
+	assert.Empty(t, PluginABIFeatures(PluginABIv1))
+	assert.Contains(t, PluginABIFeatures(PluginABIv2), FeatureMultipleEvents)
+
+	// go-plugin reports versions it cannot speak in its handshake error
+	newer := checkHandshakeError("resize", fmt.Errorf("Incompatible API version with plugin. Plugin version: 3, Client versions: [1 2]"))
+	var incompatible *IncompatiblePluginError
+	require.ErrorAs(t, newer, &incompatible)
+	assert.Equal(t, 3, incompatible.PluginVersion)
+	assert.Contains(t, newer.Error(), "upgrade the runtime")
+
+	older := checkHandshakeError("resize", fmt.Errorf("Incompatible API version with plugin. Plugin version: 0, Client versions: [1 2]"))
+	assert.Contains(t, older.Error(), "incompatible with the runtime's ABI versions 1 to 2")
+
+	other := fmt.Errorf("plugin exited before we could connect")
+	assert.Equal(t, other, checkHandshakeError("resize", other))
+
+	// Loaded functions report the negotiated ABI
+	rs := &RuntimeService{plugins: make(map[string]Plugin)}
+	meta := FunctionMeta{Name: "resize", Type: "hashicorp-plugin", Version: "1.0.0"}
+	rs.install(meta.Name, meta, nil, &abiTestPlugin{ExamplePlugin: ExamplePlugin{meta: meta}, version: PluginABIv2})
+	loaded := rs.LoadedFunctions()
+	require.Len(t, loaded, 1)
+	assert.Equal(t, PluginABIv2, loaded[0].ABIVersion)
+	assert.Equal(t, []string{FeatureMultipleEvents}, loaded[0].Features)
+}
+
+// TestPluginSecurity tests deployment handshake secrets and the verification of plugin
+// binaries before they are executed
+func TestPluginSecurity(t *testing.T) {
+	secret, err := NewPluginSecret()
+	require.NoError(t, err)
+	assert.Len(t, secret, 64)
+	other, err := NewPluginSecret()
+	require.NoError(t, err)
+	assert.NotEqual(t, secret, other)
+
+	handshake := PluginHandshakeWithSecret(secret)
+	assert.Equal(t, secret, handshake.MagicCookieValue)
+	assert.Equal(t, PluginHandshake.MagicCookieKey, handshake.MagicCookieKey)
+	assert.Equal(t, PluginHandshake.ProtocolVersion, handshake.ProtocolVersion)
+	assert.Equal(t, PluginHandshake, PluginHandshakeWithSecret(""))
+
+	// Binaries that differ from the registry's digest are never executed
+	binary := []byte("#!/bin/sh\nexit 1\n")
+	pm := NewPluginManager()
+	pm.SetHandshakeSecret(secret)
+	_, err = pm.LoadPlugin(FunctionMeta{Name: "resize", Type: "hashicorp-plugin", Digest: BinaryDigest([]byte("original"))}, binary)
+	assert.ErrorIs(t, err, ErrPluginDigestMismatch)
+
+	_, err = pm.LoadPlugin(FunctionMeta{Name: "resize", Type: "hashicorp-plugin", Digest: "sha256"}, binary)
+	assert.ErrorContains(t, err, "invalid digest")
+
+	// Without a registry digest the binary is checked against the fetched one
+	path := filepath.Join(t.TempDir(), "resize")
+	require.NoError(t, os.WriteFile(path, binary, 0755))
+	config, err := pluginSecureConfig(FunctionMeta{Name: "resize"}, binary)
+	require.NoError(t, err)
+	ok, err := config.Check(path)
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+// TestBundle tests writing, reading and deploying a function bundle
+func TestBundle(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "resize-1.0.0")
+	bundle, err := NewBundle(dir, FunctionMeta{Name: "resize", Type: "hashicorp-plugin", Version: "1.0.0"})
+	require.NoError(t, err)
+
+	for _, platform := range []string{"linux/amd64", "linux/arm64"} {
+		path := filepath.Join("plugins", strings.ReplaceAll(platform, "/", "_"), "resize")
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, path), []byte("binary for "+platform), 0755))
+		_, err := bundle.AddArtifact(ArtifactPlugin, platform, path)
+		require.NoError(t, err)
+	}
+	oci, err := bundle.WriteOCILayout("oci")
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, "oci", "blobs", "sha256", oci.Digest))
+	require.NoError(t, bundle.Save())
+
+	loaded, err := LoadBundle(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"linux/amd64", "linux/arm64"}, loaded.Platforms())
+
+	deployment, err := loaded.Deployment("linux/arm64")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("binary for linux/arm64"), deployment.Binary)
+	assert.Equal(t, BinaryDigest(deployment.Binary), deployment.Meta.Digest)
+
+	_, err = loaded.Deployment("darwin/arm64")
+	assert.ErrorIs(t, err, ErrPlatformNotBundled)
+
+	// Binaries changed after the build are not deployed
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "plugins", "linux_amd64", "resize"), []byte("tampered"), 0755))
+	_, err = loaded.Deployment("linux/amd64")
+	assert.ErrorContains(t, err, "does not match the digest")
+
+	registry, err := NewFileRegistry(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, registry.DeployFunctions([]FunctionDeployment{deployment}))
+	meta, binary, err := registry.GetFunction("resize")
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.0", meta.Version)
+	assert.Equal(t, deployment.Binary, binary)
+}
+
+// TestLocalRuntime tests executing functions from a local directory without NATS
+func TestLocalRuntime(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "greet.js")
+	writeSource := func(greeting string, modTime time.Time) {
+		require.NoError(t, os.WriteFile(source, []byte(`
+function handle(event) {
+  return {id: event.id + "-greeted", source: "greet", type: "greeted", data: {greeting: "`+greeting+`"}};
+}`), 0644))
+		require.NoError(t, os.Chtimes(source, modTime, modTime))
+	}
+	writeSource("hello", time.Now().Add(-time.Hour))
+
+	// Functions in the file registry layout are loaded as well
+	registry, err := NewFileRegistry(dir)
+	require.NoError(t, err)
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "example", Type: TypeBuiltin}, nil))
+
+	runtime, err := NewLocalRuntime(dir)
+	require.NoError(t, err)
+	defer runtime.Close()
+
+	event := ce.NewEvent()
+	event.SetID("local-1")
+	event.SetSource("test")
+	event.SetType("user.created")
+
+	events, err := runtime.InvokeFunction(context.Background(), "greet", &event)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.JSONEq(t, `{"greeting": "hello"}`, string(events[0].Data()))
+	assert.True(t, IsResponseTo(events[0], &event))
+	assert.Equal(t, []string{"local-1"}, mevent.ParentIDs(events[0]))
+	assert.Equal(t, "function:greet", mevent.ProducedBy(events[0]))
+
+	// Edits apply to the next invocation
+	writeSource("hi", time.Now())
+	events, err = runtime.InvokeFunction(context.Background(), "greet", &event)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"greeting": "hi"}`, string(events[0].Data()))
+
+	events, err = runtime.InvokeFunction(context.Background(), "example", &event)
+	require.NoError(t, err)
+	assert.Equal(t, "response-local-1", events[0].ID())
+
+	_, err = runtime.InvokeFunction(context.Background(), "missing", &event)
+	assert.ErrorIs(t, err, ErrFunctionNotFound)
+	_, err = runtime.InvokeFunction(context.Background(), "../greet", &event)
+	assert.Error(t, err)
+
+	functions, err := runtime.ListFunctions()
+	require.NoError(t, err)
+	require.Len(t, functions, 2)
+	assert.Equal(t, "example", functions[0].Name)
+	assert.Equal(t, TypeJavaScript, functions[1].Type)
+
+	_, err = NewLocalRuntime(source)
+	assert.Error(t, err)
+}
+
+// TestTransformFunction tests the builtin mapping event data with an expression
+func TestTransformFunction(t *testing.T) {
+	load := func(config map[string]string) (Function, error) {
+		config["builtin"] = BuiltinTransform
+		plugin, err := loadBuiltin(FunctionMeta{Name: "order-summary", Type: TypeBuiltin, Config: config})
+		if err != nil {
+			return nil, err
+		}
+		return plugin.Function(), nil
+	}
+
+	event := ce.NewEvent()
+	event.SetID("order-1")
+	event.SetSource("shop")
+	event.SetType("order.created")
+	event.SetSubject("orders/1")
+	event.SetExtension("tenant", "acme")
+	require.NoError(t, event.SetData(ce.ApplicationJSON, map[string]interface{}{
+		"customer": map[string]interface{}{"email": "ana@example.com"},
+		"items":    []interface{}{map[string]interface{}{"price": 5}, map[string]interface{}{"price": 7.5}},
+	}))
+
+	summarize, err := load(map[string]string{
+		"expression": `{"email": data.customer.email, "total": sum(map(data.items, .price)), "tenant": event.extensions.tenant, "order": event.id}`,
+		"type":       "order.summarized",
+	})
+	require.NoError(t, err)
+	events, err := summarize.Execute(context.Background(), &event)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "order.summarized", events[0].Type())
+	assert.Equal(t, "shop", events[0].Source())
+	assert.Equal(t, "orders/1", events[0].Subject())
+	assert.NotEqual(t, "order-1", events[0].ID())
+	assert.JSONEq(t, `{"email": "ana@example.com", "total": 12.5, "tenant": "acme", "order": "order-1"}`, string(events[0].Data()))
+
+	// Expressions returning nil filter the event out
+	filter, err := load(map[string]string{"expression": `len(data.items) > 5 ? data : nil`})
+	require.NoError(t, err)
+	events, err = filter.Execute(context.Background(), &event)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	_, err = load(map[string]string{})
+	assert.ErrorContains(t, err, "expression is required")
+	_, err = load(map[string]string{"expression": "data.("})
+	assert.ErrorContains(t, err, "invalid expression")
+
+	broken, err := load(map[string]string{"expression": `data.missing.field`})
+	require.NoError(t, err)
+	_, err = broken.Execute(context.Background(), &event)
+	assert.Error(t, err)
+}
+
+// TestRouterFunction tests routing events to the targets of the rules they match
+func TestRouterFunction(t *testing.T) {
+	load := func(config map[string]string) (Function, error) {
+		config["builtin"] = BuiltinRouter
+		plugin, err := loadBuiltin(FunctionMeta{Name: "order-router", Type: TypeBuiltin, Config: config})
+		if err != nil {
+			return nil, err
+		}
+		return plugin.Function(), nil
+	}
+	rules := `[
+		{"name": "large", "when": "data.total > 100", "subject": "orders.large"},
+		{"name": "eu", "when": "event.extensions.region == 'eu'", "function": "eu-orders"},
+		{"subject": "orders.other"}
+	]`
+
+	event := ce.NewEvent()
+	event.SetID("order-1")
+	event.SetSource("shop")
+	event.SetType("order.created")
+	event.SetExtension("region", "eu")
+	require.NoError(t, event.SetData(ce.ApplicationJSON, map[string]interface{}{"total": 250}))
+
+	first, err := load(map[string]string{"rules": rules})
+	require.NoError(t, err)
+	events, err := first.Execute(context.Background(), &event)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "order-1", events[0].ID())
+	assert.Equal(t, "large", events[0].Extensions()[ExtRouteRule])
+	assert.Equal(t, "orders.large", events[0].Extensions()[ExtRouteSubject])
+	assert.NotContains(t, event.Extensions(), ExtRouteRule)
+
+	all, err := load(map[string]string{"rules": rules, "match": "all"})
+	require.NoError(t, err)
+	events, err = all.Execute(context.Background(), &event)
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	assert.Equal(t, "eu-orders", events[1].Extensions()[ExtRouteFunction])
+	assert.Equal(t, "rule-3", events[2].Extensions()[ExtRouteRule])
+
+	// The runtime takes the target off the events it delivers
+	subject, functionName := routeTarget(events[1])
+	assert.Empty(t, subject)
+	assert.Equal(t, "eu-orders", functionName)
+	assert.NotContains(t, events[1].Extensions(), ExtRouteFunction)
+
+	// Events matching no rule are dropped
+	strict, err := load(map[string]string{"rules": `[{"when": "data.total > 1000", "subject": "orders.huge"}]`})
+	require.NoError(t, err)
+	events, err = strict.Execute(context.Background(), &event)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	_, err = load(map[string]string{})
+	assert.ErrorContains(t, err, "rules is required")
+	_, err = load(map[string]string{"rules": `[{"when": "true"}]`})
+	assert.ErrorContains(t, err, "needs either a subject or a function")
+	_, err = load(map[string]string{"rules": `[{"when": "1 + 1", "subject": "orders"}]`})
+	assert.ErrorContains(t, err, "invalid condition")
+	_, err = load(map[string]string{"rules": `[{"subject": "orders"}]`, "match": "any"})
+	assert.ErrorContains(t, err, "invalid match")
+}
+
+// TestMetaSchemaVersions tests upgrading stored metadata on read, keeping unknown
+// fields, and refusing to write back metadata of a newer schema version
+func TestMetaSchemaVersions(t *testing.T) {
+	dir := t.TempDir()
+	registry, err := NewFileRegistry(dir)
+	require.NoError(t, err)
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "resize", Type: TypeBuiltin, Version: "1.0.0"}, []byte("binary")))
+
+	// Metadata stored before versioning is read as version 1
+	legacy := `{"name": "resize", "type": "builtin", "version": "1.0.0", "retries": 3}`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "resize.json"), []byte(legacy), 0644))
+	meta, _, err := registry.GetFunction("resize")
+	require.NoError(t, err)
+	assert.Equal(t, 1, meta.SchemaVersion)
+	assert.Equal(t, "1.0.0", meta.Version)
+
+	// Rewriting it stores the current version and keeps the unknown field
+	meta.Version = "1.0.1"
+	require.NoError(t, registry.StoreFunction(meta, []byte("binary")))
+	data, err := os.ReadFile(filepath.Join(dir, "resize.json"))
+	require.NoError(t, err)
+	var stored map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &stored))
+	assert.Equal(t, float64(MetaSchemaVersion), stored["schemaVersion"])
+	assert.Equal(t, float64(3), stored["retries"])
+	assert.Equal(t, "1.0.1", stored["version"])
+	functions, err := registry.ListFunctions()
+	require.NoError(t, err)
+	require.Len(t, functions, 1)
+	assert.Equal(t, MetaSchemaVersion, functions[0].SchemaVersion)
+
+	// Metadata of a newer release is readable but not written back incompletely
+	newer := fmt.Sprintf(`{"name": "resize", "type": "builtin", "version": "2.0.0", "schemaVersion": %d}`, MetaSchemaVersion+1)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "resize.json"), []byte(newer), 0644))
+	meta, _, err = registry.GetFunction("resize")
+	require.NoError(t, err)
+	assert.Equal(t, MetaSchemaVersion+1, meta.SchemaVersion)
+	err = registry.StoreFunction(meta, []byte("binary"))
+	assert.ErrorIs(t, err, ErrUnsupportedMetaVersion)
+
+	_, err = decodeMeta([]byte(`{"name": "resize", "schemaVersion": 0}`))
+	assert.ErrorContains(t, err, "invalid schemaVersion")
+}
@@ -0,0 +1,373 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// DefaultGossipInterval is the announcement interval assumed for peers that do not send one
+const DefaultGossipInterval = 10 * time.Second
+
+// gossipExpiry is how many announcement intervals a peer stays in a view without announcing
+const gossipExpiry = 3
+
+// GossipSubject returns the subject the instances of a runtime group announce their
+// loaded functions on
+func GossipSubject(group string) string {
+	return group + ".gossip"
+}
+
+// InstanceInvokeSubject returns the subject invoking functions on a single instance of
+// a runtime group. It is served by instances that gossip.
+func InstanceInvokeSubject(group, instanceID string) string {
+	return InvokeSubject(group) + "." + instanceID
+}
+
+// GossipConfig configures the announcements of a runtime instance, see
+// RuntimeServiceConfig.Gossip
+type GossipConfig struct {
+	// Interval between announcements, 0 disables gossip
+	Interval time.Duration
+	// Preload is how many of the functions most popular across the group the instance
+	// loads before they are invoked on it, 0 disables preloading
+	Preload int
+}
+
+// GossipFunction is a function loaded on an announcing instance
+type GossipFunction struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	// LoadLatency is how long fetching and loading the function took
+	LoadLatency time.Duration `json:"load_latency"`
+	// Invocations counts the invocations served since the function was loaded
+	Invocations uint64 `json:"invocations"`
+}
+
+// GossipAnnouncement is published by runtime instances on the gossip subject of their group
+type GossipAnnouncement struct {
+	Service    string           `json:"service"`
+	InstanceID string           `json:"instance_id"`
+	Group      string           `json:"group"`
+	Time       time.Time        `json:"time"`
+	Interval   time.Duration    `json:"interval"`
+	Functions  []GossipFunction `json:"functions,omitempty"`
+	// Leaving is announced by stopping instances, so peers forget them right away
+	Leaving bool `json:"leaving,omitempty"`
+	// Cordoned instances announce no functions, so clients do not route to them
+	Cordoned bool `json:"cordoned,omitempty"`
+}
+
+// FunctionPopularity aggregates the announcements about a function
+type FunctionPopularity struct {
+	Name string `json:"name"`
+	// Instances is how many live instances have the function loaded
+	Instances   int    `json:"instances"`
+	Invocations uint64 `json:"invocations"`
+	// LoadLatency is the mean load latency observed by those instances
+	LoadLatency time.Duration `json:"load_latency"`
+}
+
+// GossipView keeps the latest announcement of every live instance of a runtime group.
+// Instances that stop announcing are forgotten after three of their intervals.
+type GossipView struct {
+	sub   *nats.Subscription
+	mu    sync.RWMutex
+	peers map[string]gossipPeer
+}
+
+// gossipPeer is the latest announcement of an instance and when it was received
+type gossipPeer struct {
+	announcement GossipAnnouncement
+	received     time.Time
+}
+
+// WatchGossip follows the announcements of the instances of a runtime group
+func WatchGossip(nc *nats.Conn, group string) (*GossipView, error) {
+	if group == "" {
+		group = DefaultRuntimeGroup
+	}
+	v := &GossipView{peers: make(map[string]gossipPeer)}
+	sub, err := nc.Subscribe(GossipSubject(group), v.handleAnnouncement)
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to gossip: %w", err)
+	}
+	v.sub = sub
+	return v, nil
+}
+
+// handleAnnouncement records an announcement, ignoring malformed ones
+func (v *GossipView) handleAnnouncement(msg *nats.Msg) {
+	var announcement GossipAnnouncement
+	if err := json.Unmarshal(msg.Data, &announcement); err != nil || announcement.InstanceID == "" {
+		return
+	}
+	v.record(announcement, time.Now())
+}
+
+// record stores the announcement of an instance, or forgets a leaving instance
+func (v *GossipView) record(announcement GossipAnnouncement, received time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if announcement.Leaving {
+		delete(v.peers, announcement.InstanceID)
+		return
+	}
+	v.peers[announcement.InstanceID] = gossipPeer{announcement: announcement, received: received}
+}
+
+// live returns the announcements of instances that have not expired, sorted by instance ID
+func (v *GossipView) live(now time.Time) []GossipAnnouncement {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	announcements := make([]GossipAnnouncement, 0, len(v.peers))
+	for _, peer := range v.peers {
+		interval := peer.announcement.Interval
+		if interval <= 0 {
+			interval = DefaultGossipInterval
+		}
+		if now.Sub(peer.received) <= gossipExpiry*interval {
+			announcements = append(announcements, peer.announcement)
+		}
+	}
+	sort.Slice(announcements, func(i, j int) bool { return announcements[i].InstanceID < announcements[j].InstanceID })
+	return announcements
+}
+
+// Peers returns the latest announcements of the live instances, sorted by instance ID
+func (v *GossipView) Peers() []GossipAnnouncement {
+	return v.live(time.Now())
+}
+
+// Instances returns the IDs of the live instances that have a function loaded
+func (v *GossipView) Instances(function string) []string {
+	var ids []string
+	for _, announcement := range v.Peers() {
+		for _, fn := range announcement.Functions {
+			if fn.Name == function {
+				ids = append(ids, announcement.InstanceID)
+				break
+			}
+		}
+	}
+	return ids
+}
+
+// Popular ranks the functions loaded on live instances by how many instances loaded
+// them, then by their invocations
+func (v *GossipView) Popular() []FunctionPopularity {
+	byName := make(map[string]*FunctionPopularity)
+	latencies := make(map[string]time.Duration)
+	for _, announcement := range v.Peers() {
+		for _, fn := range announcement.Functions {
+			p, ok := byName[fn.Name]
+			if !ok {
+				p = &FunctionPopularity{Name: fn.Name}
+				byName[fn.Name] = p
+			}
+			p.Instances++
+			p.Invocations += fn.Invocations
+			latencies[fn.Name] += fn.LoadLatency
+		}
+	}
+
+	popular := make([]FunctionPopularity, 0, len(byName))
+	for name, p := range byName {
+		p.LoadLatency = latencies[name] / time.Duration(p.Instances)
+		popular = append(popular, *p)
+	}
+	sort.Slice(popular, func(i, j int) bool {
+		a, b := popular[i], popular[j]
+		if a.Instances != b.Instances {
+			return a.Instances > b.Instances
+		}
+		if a.Invocations != b.Invocations {
+			return a.Invocations > b.Invocations
+		}
+		return a.Name < b.Name
+	})
+	return popular
+}
+
+// Close stops following the announcements
+func (v *GossipView) Close() error {
+	return v.sub.Unsubscribe()
+}
+
+// gossip announces the functions loaded on a runtime instance and preloads the ones
+// popular across its group
+type gossip struct {
+	cfg  GossipConfig
+	view *GossipView
+	mu   sync.Mutex
+	// stats of the functions loaded on this instance, by name
+	stats map[string]*GossipFunction
+	// preloadFailed holds the functions that failed to preload, so they are not
+	// retried every interval
+	preloadFailed map[string]bool
+}
+
+// recordLoad records how long loading a function took; gossip may be nil
+func (g *gossip) recordLoad(meta FunctionMeta, latency time.Duration) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stats[meta.Name] = &GossipFunction{Name: meta.Name, Version: meta.Version, LoadLatency: latency}
+	delete(g.preloadFailed, meta.Name)
+}
+
+// recordInvocation counts an invocation of a function; gossip may be nil
+func (g *gossip) recordInvocation(name string) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if stats, ok := g.stats[name]; ok {
+		stats.Invocations++
+	}
+}
+
+// pickInstance returns a random live instance of the view that has a function loaded
+func pickInstance(view *GossipView, function string) string {
+	if view == nil {
+		return ""
+	}
+	ids := view.Instances(function)
+	if len(ids) == 0 {
+		return ""
+	}
+	return ids[rand.Intn(len(ids))]
+}
+
+// startGossip registers the instance's invoke endpoint and follows the group's gossip
+func (rs *RuntimeService) startGossip(cfg GossipConfig) error {
+	info := rs.service.Info()
+	if err := rs.service.AddEndpoint("invoke-instance", micro.HandlerFunc(rs.handleFunctionInvocation),
+		micro.WithEndpointSubject(InstanceInvokeSubject(rs.group, info.ID)),
+		micro.WithEndpointMetadata(map[string]string{
+			"description": "Execute a serverless function on this runtime instance",
+			"format":      "application/json",
+			"group":       rs.group,
+		})); err != nil {
+		return err
+	}
+
+	view, err := WatchGossip(rs.natsConn, rs.group)
+	if err != nil {
+		return err
+	}
+	rs.gossip = &gossip{
+		cfg:           cfg,
+		view:          view,
+		stats:         make(map[string]*GossipFunction),
+		preloadFailed: make(map[string]bool),
+	}
+	return nil
+}
+
+// runGossip announces the instance's functions and preloads popular ones at the
+// gossip interval until stopped
+func (rs *RuntimeService) runGossip(stop <-chan struct{}) {
+	ticker := time.NewTicker(rs.gossip.cfg.Interval)
+	defer ticker.Stop()
+
+	rs.announce(false)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			rs.announce(false)
+			rs.preloadPopular()
+		}
+	}
+}
+
+// announce publishes the functions loaded on the instance to its group
+func (rs *RuntimeService) announce(leaving bool) {
+	info := rs.service.Info()
+	announcement := GossipAnnouncement{
+		Service:    info.Name,
+		InstanceID: info.ID,
+		Group:      rs.group,
+		Time:       time.Now(),
+		Interval:   rs.gossip.cfg.Interval,
+		Leaving:    leaving,
+		Cordoned:   rs.cordoned.Load(),
+	}
+	if !leaving && !announcement.Cordoned {
+		loadedFunctions := rs.LoadedFunctions()
+		rs.gossip.mu.Lock()
+		for _, loaded := range loadedFunctions {
+			fn := GossipFunction{Name: loaded.Name, Version: loaded.Version}
+			if stats, ok := rs.gossip.stats[loaded.Name]; ok {
+				fn.LoadLatency, fn.Invocations = stats.LoadLatency, stats.Invocations
+			}
+			announcement.Functions = append(announcement.Functions, fn)
+		}
+		rs.gossip.mu.Unlock()
+	}
+
+	data, err := json.Marshal(announcement)
+	if err != nil {
+		rs.logger.Error("Failed to marshal gossip announcement", Field{Key: "error", Value: err})
+		return
+	}
+	if err := rs.natsConn.Publish(GossipSubject(rs.group), data); err != nil {
+		rs.logger.Error("Failed to publish gossip announcement", Field{Key: "error", Value: err})
+	}
+}
+
+// stopGossip announces that the instance leaves and stops following the group's gossip
+func (rs *RuntimeService) stopGossip() {
+	if rs.gossip == nil {
+		return
+	}
+	rs.announce(true)
+	rs.gossip.view.Close()
+}
+
+// preloadPopular loads the most popular functions of the group not loaded on this
+// instance yet. Functions failing to load, e.g. for lack of capacity, are skipped
+// until they load on invocation.
+func (rs *RuntimeService) preloadPopular() {
+	g := rs.gossip
+	if g.cfg.Preload <= 0 || rs.cordoned.Load() {
+		return
+	}
+	popular := g.view.Popular()
+	for _, p := range popular[:min(g.cfg.Preload, len(popular))] {
+		rs.mu.RLock()
+		_, loaded := rs.plugins[p.Name]
+		rs.mu.RUnlock()
+		g.mu.Lock()
+		failed := g.preloadFailed[p.Name]
+		g.mu.Unlock()
+		if loaded || failed {
+			continue
+		}
+
+		if _, err := rs.getPlugin(p.Name); err != nil {
+			g.mu.Lock()
+			g.preloadFailed[p.Name] = true
+			g.mu.Unlock()
+			rs.logger.Error("Failed to preload function",
+				Field{Key: "functionName", Value: p.Name},
+				Field{Key: "error", Value: err})
+			continue
+		}
+		rs.logger.Info("Preloaded function popular across the group",
+			Field{Key: "functionName", Value: p.Name},
+			Field{Key: "instances", Value: p.Instances})
+	}
+}
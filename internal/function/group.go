@@ -0,0 +1,159 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+// DefaultRuntimeGroup is the subject prefix of the invoke, describe and health endpoints
+const DefaultRuntimeGroup = "function"
+
+// Health statuses reported by the health endpoint
+const (
+	HealthStatusOK    = "ok"
+	HealthStatusStuck = "stuck"
+)
+
+// InvokeSubject returns the subject invocations of a runtime group are sent to
+func InvokeSubject(group string) string {
+	return group + ".invoke"
+}
+
+// DescribeSubject returns the subject of the describe endpoint of a runtime group
+func DescribeSubject(group string) string {
+	return group + ".describe"
+}
+
+// HealthSubject returns the subject of the health endpoint of a runtime group
+func HealthSubject(group string) string {
+	return group + ".health"
+}
+
+// DescribeRequest is the request of the describe endpoint
+type DescribeRequest struct {
+	Function string `json:"function"`
+}
+
+// FunctionDescription is the response of the describe endpoint: the metadata of the
+// version the instance serves and, once loaded, what it loaded
+type FunctionDescription struct {
+	Service    string          `json:"service"`
+	InstanceID string          `json:"instance_id"`
+	Group      string          `json:"group"`
+	Function   FunctionMeta    `json:"function"`
+	Loaded     *LoadedFunction `json:"loaded,omitempty"`
+}
+
+// RuntimeHealth is the response of the health endpoint
+type RuntimeHealth struct {
+	Service    string        `json:"service"`
+	InstanceID string        `json:"instance_id"`
+	Group      string        `json:"group"`
+	Status     string        `json:"status"`
+	Functions  int           `json:"functions"`
+	InFlight   int           `json:"in_flight"`
+	Stuck      int           `json:"stuck"`
+	Capacity   CapacityStats `json:"capacity"`
+	// Cordoned instances take no new invocations
+	Cordoned bool `json:"cordoned,omitempty"`
+}
+
+// addGroupEndpoints registers the invoke, describe and health endpoints under the
+// runtime's group, so runtimes with different groups share a connection without
+// their subjects colliding. Every endpoint carries the group in its metadata.
+func (rs *RuntimeService) addGroupEndpoints() error {
+	group := rs.service.AddGroup(rs.group)
+	metadata := func(description string) micro.EndpointOpt {
+		return micro.WithEndpointMetadata(map[string]string{
+			"description": description,
+			"format":      "application/json",
+			"group":       rs.group,
+		})
+	}
+
+	if err := group.AddEndpoint("invoke", micro.HandlerFunc(rs.handleFunctionInvocation),
+		metadata("Execute a serverless function with CloudEvents")); err != nil {
+		return err
+	}
+	if err := group.AddEndpoint("describe", micro.HandlerFunc(rs.handleDescribe),
+		metadata("Describe the version of a function the runtime serves")); err != nil {
+		return err
+	}
+	return group.AddEndpoint("health", micro.HandlerFunc(rs.handleHealth),
+		metadata("Report the health of a runtime instance"))
+}
+
+// handleDescribe answers describe requests
+func (rs *RuntimeService) handleDescribe(req micro.Request) {
+	var request DescribeRequest
+	if err := json.Unmarshal(req.Data(), &request); err != nil || request.Function == "" {
+		req.Error("400", "request must name a function", nil)
+		return
+	}
+
+	info := rs.service.Info()
+	description := FunctionDescription{Service: info.Name, InstanceID: info.ID, Group: rs.group}
+	rs.mu.RLock()
+	loaded, ok := rs.loaded[request.Function]
+	description.Function = rs.metas[request.Function]
+	rs.mu.RUnlock()
+
+	if ok {
+		description.Loaded = &loaded
+	} else {
+		meta, _, err := rs.fetchFunction(request.Function)
+		if err != nil {
+			req.Error("404", err.Error(), nil)
+			return
+		}
+		description.Function = meta
+	}
+	req.RespondJSON(description)
+}
+
+// handleHealth answers health requests
+func (rs *RuntimeService) handleHealth(req micro.Request) {
+	info := rs.service.Info()
+	health := RuntimeHealth{
+		Service:    info.Name,
+		InstanceID: info.ID,
+		Group:      rs.group,
+		Status:     HealthStatusOK,
+		Functions:  len(rs.LoadedFunctions()),
+		Capacity:   rs.CapacityStats(),
+		Cordoned:   rs.cordoned.Load(),
+	}
+	for _, inv := range rs.InFlightInvocations() {
+		health.InFlight++
+		if inv.Stuck {
+			health.Stuck++
+		}
+	}
+	if health.Stuck > 0 {
+		health.Status = HealthStatusStuck
+	}
+	req.RespondJSON(health)
+}
+
+// DescribeFunction asks an instance of the client's runtime group to describe a function
+func (c *Client) DescribeFunction(ctx context.Context, name string) (*FunctionDescription, error) {
+	request, err := json.Marshal(DescribeRequest{Function: name})
+	if err != nil {
+		return nil, err
+	}
+	msg, err := c.nc.RequestWithContext(ctx, DescribeSubject(c.group), request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if msg.Header.Get(micro.ErrorCodeHeader) != "" {
+		return nil, fmt.Errorf("failed to describe function %s: %s", name, msg.Header.Get(micro.ErrorHeader))
+	}
+	var description FunctionDescription
+	if err := json.Unmarshal(msg.Data, &description); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return &description, nil
+}
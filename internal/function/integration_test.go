@@ -2,13 +2,24 @@ package function
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/julianshen/mycelium/internal/event"
+	"github.com/julianshen/mycelium/internal/naming"
+	"github.com/julianshen/mycelium/internal/profiling"
+
 	ce "github.com/cloudevents/sdk-go/v2"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -636,5 +647,1958 @@ func TestBasicClientServer(t *testing.T) {
 		assert.Equal(t, expectedID, result.ID(), "Iteration %d: incorrect response ID", i)
 		assert.Equal(t, "example-function", result.Source(), "Iteration %d: incorrect source", i)
 		assert.Equal(t, "com.example.response", result.Type(), "Iteration %d: incorrect type", i)
+		assert.True(t, IsResponseTo(result, &event), "Iteration %d: response not correlated", i)
+		assert.NotEmpty(t, InvocationID(result), "Iteration %d: missing invocation ID", i)
+	}
+}
+
+// TestFunctionStateStore tests the scoped function state store against JetStream KV
+func TestFunctionStateStore(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	js, err := jetstream.New(nc)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	kv, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{Bucket: "test-function-state"})
+	require.NoError(t, err)
+	defer js.DeleteKeyValue(ctx, "test-function-state")
+
+	counter := NewKVStateStore(kv, "counter")
+	other := NewKVStateStore(kv, "other")
+
+	// Keys are isolated per function
+	_, _, err = counter.Get(ctx, "count")
+	assert.ErrorIs(t, err, ErrStateKeyNotFound)
+
+	rev, err := counter.CompareAndSwap(ctx, "count", []byte("1"), 0)
+	require.NoError(t, err)
+	_, err = counter.CompareAndSwap(ctx, "count", []byte("1"), 0)
+	assert.ErrorIs(t, err, ErrStateConflict)
+
+	_, err = counter.CompareAndSwap(ctx, "count", []byte("2"), rev)
+	require.NoError(t, err)
+	_, err = counter.CompareAndSwap(ctx, "count", []byte("3"), rev)
+	assert.ErrorIs(t, err, ErrStateConflict)
+
+	value, _, err := counter.Get(ctx, "count")
+	require.NoError(t, err)
+	assert.Equal(t, "2", string(value))
+
+	_, _, err = other.Get(ctx, "count")
+	assert.ErrorIs(t, err, ErrStateKeyNotFound)
+
+	// The store is reachable from the invocation context
+	state, err := StateFromContext(WithState(ctx, counter))
+	require.NoError(t, err)
+	require.NoError(t, state.Delete(ctx, "count"))
+	_, _, err = counter.Get(ctx, "count")
+	assert.ErrorIs(t, err, ErrStateKeyNotFound)
+
+	_, err = StateFromContext(ctx)
+	assert.ErrorIs(t, err, ErrStateUnavailable)
+}
+
+// flagMetrics records feature flag evaluations
+type flagMetrics struct {
+	SimpleMetricsCollector
+	mu          sync.Mutex
+	evaluations []string
+}
+
+func (m *flagMetrics) RecordFlagEvaluation(functionName, flag, value string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.evaluations = append(m.evaluations, functionName+"."+flag+"="+value)
+}
+
+// flagFunction answers with the value of its variant flag
+type flagFunction struct{}
+
+func (flagFunction) Execute(ctx context.Context, request *ce.Event) ([]*ce.Event, error) {
+	response := ce.NewEvent()
+	response.SetID("flag-response")
+	response.SetSource("flag-test")
+	response.SetType("com.example.flag")
+	if err := response.SetData(ce.ApplicationJSON, map[string]string{"variant": FlagValue(ctx, "variant", "control")}); err != nil {
+		return nil, err
+	}
+	return []*ce.Event{&response}, nil
+}
+
+// TestFeatureFlags tests that function feature flags are served from KV and hot-reloaded
+func TestFeatureFlags(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	js, err := jetstream.New(nc)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	bucket := "test-function-flags"
+	kv, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{Bucket: bucket})
+	require.NoError(t, err)
+	defer js.DeleteKeyValue(ctx, bucket)
+	_, err = kv.Put(ctx, FlagKey("checkout", "new-path"), []byte("true"))
+	require.NoError(t, err)
+
+	metrics := &flagMetrics{}
+	provider, err := NewKVFlagProvider(ctx, kv, metrics)
+	require.NoError(t, err)
+	defer provider.Stop()
+
+	// Flags set before the provider started are loaded, scoped per function
+	checkout := WithFlags(ctx, provider.For("checkout"))
+	assert.True(t, FlagEnabled(checkout, "new-path"))
+	assert.Equal(t, "fallback", FlagValue(checkout, "missing", "fallback"))
+	assert.False(t, FlagEnabled(WithFlags(ctx, provider.For("billing")), "new-path"))
+	assert.False(t, FlagEnabled(ctx, "new-path"))
+
+	// Changes apply without restarting the provider
+	_, err = kv.Put(ctx, FlagKey("checkout", "new-path"), []byte("false"))
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return !FlagEnabled(checkout, "new-path") }, 2*time.Second, 10*time.Millisecond)
+	require.NoError(t, kv.Delete(ctx, FlagKey("checkout", "new-path")))
+	assert.Eventually(t, func() bool { return FlagValue(checkout, "new-path", "unset") == "unset" }, 2*time.Second, 10*time.Millisecond)
+
+	metrics.mu.Lock()
+	assert.Contains(t, metrics.evaluations, "checkout.new-path=true")
+	assert.Contains(t, metrics.evaluations, "checkout.missing=unset")
+	assert.Contains(t, metrics.evaluations, "billing.new-path=unset")
+	metrics.mu.Unlock()
+
+	// Invocations of the runtime see the flags of their function
+	group := "flag-test"
+	registry := &MemoryRegistry{}
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "flagged", Type: TypeBuiltin, Version: "1.0.0"}, nil))
+	service, err := NewRuntimeService(RuntimeServiceConfig{
+		Conn:        nc,
+		ServiceName: "flag-test-function-runtime",
+		Registry:    registry,
+		Metrics:     &SimpleMetricsCollector{},
+		Logger:      &SimpleLogger{},
+		Group:       group,
+		FlagBucket:  bucket,
+		Builtins: map[string]func(FunctionMeta) (Function, error){
+			"flagged": func(FunctionMeta) (Function, error) { return flagFunction{}, nil },
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, service.Start())
+	defer service.Stop()
+
+	client, err := NewClient(ClientConfig{Conn: nc, Group: group, Timeout: 2 * time.Second})
+	require.NoError(t, err)
+	defer client.Close()
+
+	request := ce.NewEvent()
+	request.SetID("flag-1")
+	request.SetSource("flag-test")
+	request.SetType("com.example.flag")
+	variant := func() string {
+		events, err := client.InvokeFunction(ctx, "flagged", &request)
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		var data struct {
+			Variant string `json:"variant"`
+		}
+		require.NoError(t, events[0].DataAs(&data))
+		return data.Variant
+	}
+	assert.Equal(t, "control", variant())
+
+	_, err = kv.Put(ctx, FlagKey("flagged", "variant"), []byte("treatment"))
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return variant() == "treatment" }, 2*time.Second, 50*time.Millisecond)
+}
+
+// failingCreateKV fails metadata creation for one function to simulate a partial deployment
+type failingCreateKV struct {
+	jetstream.KeyValue
+	failOn string
+}
+
+func (kv failingCreateKV) Create(ctx context.Context, key string, value []byte, opts ...jetstream.KVCreateOpt) (uint64, error) {
+	if key == kv.failOn {
+		return 0, fmt.Errorf("simulated failure")
+	}
+	return kv.KeyValue.Create(ctx, key, value, opts...)
+}
+
+// TestDeployFunctionsRollsBack tests that a failed deployment restores every function
+func TestDeployFunctionsRollsBack(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	registry, err := NewNATSRegistry(nc)
+	require.NoError(t, err)
+	defer func() {
+		for _, name := range []string{"deploy-step-1", "deploy-step-2"} {
+			registry.DeleteFunction(name)
+		}
+	}()
+
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "deploy-step-1", Type: "builtin", Version: "1.0.0"}, []byte("v1")))
+
+	pipeline := []FunctionDeployment{
+		{Meta: FunctionMeta{Name: "deploy-step-1", Type: "builtin", Version: "2.0.0"}, Binary: []byte("v2")},
+		{Meta: FunctionMeta{Name: "deploy-step-2", Type: "builtin", Version: "2.0.0"}, Binary: []byte("v2")},
+	}
+
+	// A failure on the second step leaves the first one untouched
+	registry.kv = failingCreateKV{KeyValue: registry.kv, failOn: "deploy-step-2"}
+	err = registry.DeployFunctions(pipeline)
+	var deployErr *DeploymentError
+	require.ErrorAs(t, err, &deployErr)
+	assert.Equal(t, "deploy-step-2", deployErr.Function)
+	assert.NoError(t, deployErr.RollbackErr)
+
+	meta, binary, err := registry.GetFunction("deploy-step-1")
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.0", meta.Version)
+	assert.Equal(t, "v1", string(binary))
+	_, _, err = registry.GetFunction("deploy-step-2")
+	assert.Error(t, err)
+
+	// Without failures every step is deployed
+	registry.kv = registry.kv.(failingCreateKV).KeyValue
+	require.NoError(t, registry.DeployFunctions(pipeline))
+	for _, d := range pipeline {
+		meta, binary, err := registry.GetFunction(d.Meta.Name)
+		require.NoError(t, err)
+		assert.Equal(t, "2.0.0", meta.Version)
+		assert.Equal(t, "v2", string(binary))
+	}
+
+	// Duplicate functions are rejected up front
+	assert.Error(t, registry.DeployFunctions([]FunctionDeployment{pipeline[0], pipeline[0]}))
+}
+
+func TestListLoadedFunctions(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	registry := &MemoryRegistry{}
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "example", Type: "builtin", Version: "1.2.0"}, []byte("v1")))
+
+	cfg := RuntimeServiceConfig{
+		NATSURL:     "nats://localhost:4222",
+		ServiceName: "loaded-test-function-runtime",
+		Version:     "1.0.0",
+		Registry:    registry,
+		Metrics:     &SimpleMetricsCollector{},
+		Logger:      &SimpleLogger{},
+	}
+
+	// Two instances of the same service, only the first one loads the function
+	first, err := NewRuntimeService(cfg)
+	require.NoError(t, err)
+	require.NoError(t, first.Start())
+	defer first.Stop()
+
+	second, err := NewRuntimeService(cfg)
+	require.NoError(t, err)
+	require.NoError(t, second.Start())
+	defer second.Stop()
+
+	_, err = first.getPlugin("example")
+	require.NoError(t, err)
+
+	instances, err := ListLoadedFunctions(nc, cfg.ServiceName, 500*time.Millisecond)
+	require.NoError(t, err)
+	require.Len(t, instances, 2)
+
+	loaded := make(map[string][]LoadedFunction)
+	for _, instance := range instances {
+		assert.Equal(t, cfg.ServiceName, instance.Service)
+		loaded[instance.InstanceID] = instance.Functions
+	}
+	require.Len(t, loaded[first.service.Info().ID], 1)
+	assert.Equal(t, "example", loaded[first.service.Info().ID][0].Name)
+	assert.Equal(t, "1.2.0", loaded[first.service.Info().ID][0].Version)
+	assert.Equal(t, BinaryDigest([]byte("v1")), loaded[first.service.Info().ID][0].Digest)
+	assert.Empty(t, loaded[second.service.Info().ID])
+
+	// The instance subject reaches a single instance
+	msg, err := nc.Request(FunctionsSubject(cfg.ServiceName, second.service.Info().ID), nil, time.Second)
+	require.NoError(t, err)
+	var instance InstanceFunctions
+	require.NoError(t, json.Unmarshal(msg.Data, &instance))
+	assert.Equal(t, second.service.Info().ID, instance.InstanceID)
+}
+
+// TestFetchFunctionLogs tests that function output is merged from every instance
+func TestFetchFunctionLogs(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	cfg := RuntimeServiceConfig{
+		NATSURL:     "nats://localhost:4222",
+		ServiceName: "logs-test-function-runtime",
+		Version:     "1.0.0",
+		Registry:    &MemoryRegistry{},
+		Metrics:     &SimpleMetricsCollector{},
+		Logger:      &SimpleLogger{},
+	}
+
+	first, err := NewRuntimeService(cfg)
+	require.NoError(t, err)
+	require.NoError(t, first.Start())
+	defer first.Stop()
+
+	second, err := NewRuntimeService(cfg)
+	require.NoError(t, err)
+	require.NoError(t, second.Start())
+	defer second.Stop()
+
+	live, err := nc.SubscribeSync(FunctionLogSubject("echo"))
+	require.NoError(t, err)
+	defer live.Unsubscribe()
+	require.NoError(t, nc.Flush())
+
+	fmt.Fprintln(first.pluginOutput("echo", "stdout"), "one")
+	fmt.Fprintln(second.pluginOutput("echo", "stderr"), "two")
+	fmt.Fprintln(first.pluginOutput("echo", "stdout"), "three")
+
+	lines, err := FetchFunctionLogs(nc, cfg.ServiceName, "echo", 2, 500*time.Millisecond)
+	require.NoError(t, err)
+	require.Len(t, lines, 2)
+	assert.Equal(t, "two", lines[0].Message)
+	assert.Equal(t, second.service.Info().ID, lines[0].InstanceID)
+	assert.Equal(t, "three", lines[1].Message)
+
+	// Lines are published as they are captured; instances publish on their own
+	// connections, so lines of different instances may arrive in any order
+	var published []string
+	for i := 0; i < 3; i++ {
+		msg, err := live.NextMsg(time.Second)
+		require.NoError(t, err)
+		var line LogLine
+		require.NoError(t, json.Unmarshal(msg.Data, &line))
+		published = append(published, line.Message)
+	}
+	assert.ElementsMatch(t, []string{"one", "two", "three"}, published)
+
+	_, err = FetchFunctionLogs(nc, cfg.ServiceName, "", 0, 500*time.Millisecond)
+	assert.ErrorContains(t, err, "logs request failed")
+}
+
+// TestRegistryQuota tests usage reporting and quota enforcement on StoreFunction and DeployFunctions
+func TestRegistryQuota(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	ctx := context.Background()
+	registry, err := NewNATSRegistryWithBuckets(nc, "quota-test-functions", "quota-test-binaries")
+	require.NoError(t, err)
+	defer func() {
+		js, _ := jetstream.New(nc)
+		js.DeleteKeyValue(ctx, "quota-test-functions")
+		js.DeleteObjectStore(ctx, "quota-test-binaries")
+	}()
+
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "quota-a", Type: "builtin"}, []byte("12345")))
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "quota-b", Type: "builtin"}, []byte("12345")))
+
+	usage, err := registry.Usage(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, usage.Functions)
+	assert.Equal(t, 2, usage.Versions)
+	assert.Equal(t, 1, usage.Binaries)
+	assert.Equal(t, int64(5), usage.BinaryBytes)
+	assert.True(t, usage.Quota.Unlimited())
+
+	require.NoError(t, registry.SetQuota(ctx, StorageQuota{MaxFunctions: 2, MaxBinaryBytes: 12}))
+	quota, err := registry.Quota(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, StorageQuota{MaxFunctions: 2, MaxBinaryBytes: 12}, quota)
+
+	// Another client of the same buckets enforces the quota
+	other, err := NewNATSRegistryWithBuckets(nc, "quota-test-functions", "quota-test-binaries")
+	require.NoError(t, err)
+	err = other.StoreFunction(FunctionMeta{Name: "quota-c", Type: "builtin"}, []byte("x"))
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+
+	// Binaries of retained revisions keep counting after updates
+	require.NoError(t, other.StoreFunction(FunctionMeta{Name: "quota-a", Type: "builtin"}, []byte("1234567")))
+	err = other.StoreFunction(FunctionMeta{Name: "quota-b", Type: "builtin"}, []byte("123456"))
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	require.NoError(t, other.StoreFunction(FunctionMeta{Name: "quota-b", Type: "builtin"}, []byte("1234567")))
+
+	err = other.DeployFunctions([]FunctionDeployment{
+		{Meta: FunctionMeta{Name: "quota-a", Type: "builtin"}, Binary: []byte("abcdefg")},
+		{Meta: FunctionMeta{Name: "quota-b", Type: "builtin"}, Binary: []byte("hijklmn")},
+	})
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+
+	usage, err = other.Usage(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 4, usage.Versions)
+	assert.Equal(t, 2, usage.Binaries)
+	assert.Equal(t, int64(12), usage.BinaryBytes)
+
+	// Removing the quota lifts the limits
+	require.NoError(t, registry.SetQuota(ctx, StorageQuota{}))
+	require.NoError(t, other.StoreFunction(FunctionMeta{Name: "quota-c", Type: "builtin"}, []byte("x")))
+}
+
+// TestRegistryRollback tests that retained revisions can be fetched and rolled back to
+func TestRegistryRollback(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	ctx := context.Background()
+	registry, err := NewNATSRegistryWithBuckets(nc, "rollback-test-functions", "rollback-test-binaries")
+	require.NoError(t, err)
+	defer func() {
+		js, _ := jetstream.New(nc)
+		js.DeleteKeyValue(ctx, "rollback-test-functions")
+		js.DeleteKeyValue(ctx, "rollback-test-functions-versions")
+		js.DeleteKeyValue(ctx, "rollback-test-functions-audit")
+		js.DeleteObjectStore(ctx, "rollback-test-binaries")
+	}()
+
+	// Buckets created with a shorter history are raised to DefaultFunctionHistory
+	js, err := jetstream.New(nc)
+	require.NoError(t, err)
+	_, err = js.UpdateKeyValue(ctx, jetstream.KeyValueConfig{Bucket: "rollback-test-functions", History: 1})
+	require.NoError(t, err)
+	registry, err = NewNATSRegistryWithBuckets(nc, "rollback-test-functions", "rollback-test-binaries")
+	require.NoError(t, err)
+	status, err := registry.kv.Status(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(DefaultFunctionHistory), status.History())
+
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "resize", Type: "builtin", Version: "1.0.0"}, []byte("v1")))
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "resize", Type: "builtin", Version: "1.1.0"}, []byte("v1.1")))
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "resize", Type: "builtin", Version: "2.0.0"}, []byte("v2")))
+
+	versions, err := registry.FunctionVersions("resize")
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	assert.Equal(t, "2.0.0", versions[0].Version)
+	assert.Equal(t, "1.0.0", versions[2].Version)
+
+	meta, binary, err := registry.GetFunctionVersion("resize", "1.0.0")
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.0", meta.Version)
+	assert.Equal(t, "v1", string(binary))
+	_, _, err = registry.GetFunctionVersion("resize", "0.9.0")
+	assert.ErrorIs(t, err, ErrVersionNotFound)
+
+	// Without a version the function goes back to the previous one
+	meta, err = registry.Rollback(ctx, "resize", "", "oncall", "bad release")
+	require.NoError(t, err)
+	assert.Equal(t, "1.1.0", meta.Version)
+	_, binary, err = registry.GetFunction("resize")
+	require.NoError(t, err)
+	assert.Equal(t, "v1.1", string(binary))
+
+	meta, err = registry.Rollback(ctx, "resize", "1.0.0", "oncall", "")
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.0", meta.Version)
+
+	entries, err := registry.AuditLog(ctx, "resize")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, AuditEntry{Time: entries[0].Time, Action: AuditRollback, Function: "resize", Version: "1.1.0", Previous: "2.0.0", Actor: "oncall", Reason: "bad release"}, entries[0])
+	assert.Equal(t, "1.1.0", entries[1].Previous)
+
+	// Versions outlive the history of the current version until they are retired
+	for i := 0; i < DefaultFunctionHistory+2; i++ {
+		version := fmt.Sprintf("3.0.%d", i)
+		require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "resize", Type: "builtin", Version: version}, []byte(version)))
+	}
+	meta, binary, err = registry.GetFunctionVersion("resize", "1.1.0")
+	require.NoError(t, err)
+	assert.Equal(t, "v1.1", string(binary))
+	versions, err = registry.FunctionVersions("resize")
+	require.NoError(t, err)
+	require.Len(t, versions, 3+DefaultFunctionHistory+2)
+	assert.Equal(t, fmt.Sprintf("3.0.%d", DefaultFunctionHistory+1), versions[0].Version)
+
+	err = registry.RetireVersion(ctx, "resize", versions[0].Version, "oncall")
+	assert.ErrorContains(t, err, "is the current version")
+	require.NoError(t, registry.RetireVersion(ctx, "resize", "1.1.0", "oncall"))
+	_, _, err = registry.GetFunctionVersion("resize", "1.1.0")
+	assert.ErrorIs(t, err, ErrVersionNotFound)
+	_, err = registry.objectStore.GetInfo(ctx, binaryObject(meta))
+	assert.ErrorIs(t, err, jetstream.ErrObjectNotFound)
+	assert.ErrorIs(t, registry.RetireVersion(ctx, "resize", "1.1.0", "oncall"), ErrVersionNotFound)
+
+	// Versions with characters keys do not allow are kept too
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "resize", Type: "builtin", Version: "4.0.0+build.7"}, []byte("v4")))
+	_, binary, err = registry.GetFunctionVersion("resize", "4.0.0+build.7")
+	require.NoError(t, err)
+	assert.Equal(t, "v4", string(binary))
+	assert.Equal(t, "resize.4.0.0=2Bbuild.7", versionKey("resize", "4.0.0+build.7"))
+
+	require.NoError(t, registry.DeleteFunction("resize"))
+	_, _, err = registry.GetFunctionVersion("resize", "2.0.0")
+	assert.Error(t, err)
+}
+
+// TestRegistryLifecycleEvents tests that deployments, deletions and promotions are
+// published and posted to webhooks
+func TestRegistryLifecycleEvents(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	ctx := context.Background()
+	registry, err := NewNATSRegistryWithBuckets(nc, "lifecycle-test-functions", "lifecycle-test-binaries")
+	require.NoError(t, err)
+	defer func() {
+		js, _ := jetstream.New(nc)
+		js.DeleteKeyValue(ctx, "lifecycle-test-functions")
+		js.DeleteKeyValue(ctx, "lifecycle-test-functions-versions")
+		js.DeleteKeyValue(ctx, "lifecycle-test-functions-audit")
+		js.DeleteObjectStore(ctx, "lifecycle-test-binaries")
+	}()
+
+	posted := make(chan string, 10)
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		var received ce.Event
+		if err := json.NewDecoder(r.Body).Decode(&received); err == nil && r.Header.Get("Content-Type") == "application/cloudevents+json" {
+			posted <- received.Type()
+		}
+	}))
+	defer server.Close()
+
+	events := make(chan *nats.Msg, 10)
+	sub, err := nc.ChanSubscribe("lifecycle-test.events", events)
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+	registry.SetLifecycleNotifier(NewLifecycleNotifier(nc, LifecycleConfig{
+		Subject:  "lifecycle-test.events",
+		Webhooks: []string{server.URL},
+	}))
+
+	receive := func() (*ce.Event, LifecycleChange) {
+		t.Helper()
+		select {
+		case msg := <-events:
+			var received ce.Event
+			require.NoError(t, json.Unmarshal(msg.Data, &received))
+			var data struct {
+				After LifecycleChange `json:"after"`
+			}
+			require.NoError(t, received.DataAs(&data))
+			assert.Equal(t, received.Type(), <-posted, "the webhook receives the same event")
+			return &received, data.After
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for lifecycle event")
+			return nil, LifecycleChange{}
+		}
 	}
+
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "resize", Type: "builtin", Version: "1.0.0"}, []byte("v1")))
+	lifecycle, change := receive()
+	assert.Equal(t, EventTypeFunctionDeployed, lifecycle.Type())
+	assert.Equal(t, "mycelium/registry/lifecycle-test-functions", lifecycle.Source())
+	assert.Equal(t, "resize", lifecycle.Subject())
+	assert.Equal(t, LifecycleChange{Function: "resize", Type: "builtin", Version: "1.0.0", Digest: BinaryDigest([]byte("v1"))}, change)
+
+	require.NoError(t, registry.DeployFunctions([]FunctionDeployment{{Meta: FunctionMeta{Name: "resize", Type: "builtin", Version: "2.0.0"}, Binary: []byte("v2")}}))
+	_, change = receive()
+	assert.Equal(t, "2.0.0", change.Version)
+
+	_, err = registry.Rollback(ctx, "resize", "", "oncall", "bad release")
+	require.NoError(t, err)
+	lifecycle, change = receive()
+	assert.Equal(t, EventTypeVersionPromoted, lifecycle.Type())
+	assert.Equal(t, "oncall", lifecycle.Extensions()[event.ExtActorID])
+	assert.Equal(t, "1.0.0", change.Version)
+	assert.Equal(t, "2.0.0", change.Previous)
+
+	require.NoError(t, registry.DeleteFunction("resize"))
+	lifecycle, _ = receive()
+	assert.Equal(t, EventTypeFunctionDeleted, lifecycle.Type())
+
+	// A failing webhook is reported after the change succeeded
+	failing.Store(true)
+	err = registry.StoreFunction(FunctionMeta{Name: "resize", Type: "builtin", Version: "3.0.0"}, []byte("v3"))
+	assert.ErrorContains(t, err, "succeeded but was not announced")
+	meta, _, err := registry.GetFunction("resize")
+	require.NoError(t, err)
+	assert.Equal(t, "3.0.0", meta.Version)
+}
+
+// TestRegistryListing tests streaming and paging the functions of a registry
+func TestRegistryListing(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	ctx := context.Background()
+	registry, err := NewNATSRegistryWithBuckets(nc, "list-test-functions", "list-test-binaries")
+	require.NoError(t, err)
+	defer func() {
+		js, _ := jetstream.New(nc)
+		js.DeleteKeyValue(ctx, "list-test-functions")
+		js.DeleteKeyValue(ctx, "list-test-functions-versions")
+		js.DeleteObjectStore(ctx, "list-test-binaries")
+	}()
+
+	var deployments []FunctionDeployment
+	for i := 0; i < 40; i++ {
+		deployments = append(deployments, FunctionDeployment{
+			Meta:   FunctionMeta{Name: fmt.Sprintf("fn-%02d", i), Type: "builtin", Version: "1.0.0"},
+			Binary: []byte(fmt.Sprintf("binary-%02d", i)),
+		})
+	}
+	require.NoError(t, registry.DeployFunctions(deployments))
+
+	functions, err := registry.ListFunctions()
+	require.NoError(t, err)
+	require.Len(t, functions, 40)
+	assert.Equal(t, "fn-00", functions[0].Name)
+	assert.Equal(t, "fn-39", functions[39].Name)
+
+	// Pages follow the cursor until the last one
+	var names []string
+	opts := FunctionListOptions{Limit: 15, Concurrency: 4}
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 3)
+		page, err := registry.ListFunctionsPage(ctx, opts)
+		require.NoError(t, err)
+		for _, meta := range page.Functions {
+			names = append(names, meta.Name)
+		}
+		if page.Next == "" {
+			assert.Len(t, page.Functions, 10)
+			break
+		}
+		opts.After = page.Next
+	}
+	require.Len(t, names, 40)
+	assert.Equal(t, "fn-15", names[15])
+
+	// Iteration stops when fn returns false, and fetches binaries when asked
+	var visited []string
+	err = registry.ForEachFunction(ctx, FunctionListOptions{After: "fn-30", WithBinaries: true}, func(meta FunctionMeta, binary []byte) bool {
+		assert.Equal(t, "binary-"+meta.Name[3:], string(binary))
+		visited = append(visited, meta.Name)
+		return len(visited) < 3
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"fn-31", "fn-32", "fn-33"}, visited)
+}
+
+// TestPinFunction tests pinning the fleet and a single instance to a previous version
+func TestPinFunction(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	ctx := context.Background()
+	registry, err := NewNATSRegistryWithBuckets(nc, "pin-test-functions", "pin-test-binaries")
+	require.NoError(t, err)
+	defer func() {
+		js, _ := jetstream.New(nc)
+		js.DeleteKeyValue(ctx, "pin-test-functions")
+		js.DeleteKeyValue(ctx, "pin-test-functions-versions")
+		js.DeleteObjectStore(ctx, "pin-test-binaries")
+	}()
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "example", Type: "builtin", Version: "1.0.0"}, []byte("v1")))
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "example", Type: "builtin", Version: "2.0.0"}, []byte("v2")))
+
+	cfg := RuntimeServiceConfig{
+		NATSURL:     "nats://localhost:4222",
+		ServiceName: "pin-test-function-runtime",
+		Version:     "1.0.0",
+		Registry:    registry,
+		Metrics:     &SimpleMetricsCollector{},
+		Logger:      &SimpleLogger{},
+	}
+	first, err := NewRuntimeService(cfg)
+	require.NoError(t, err)
+	require.NoError(t, first.Start())
+	defer first.Stop()
+
+	second, err := NewRuntimeService(cfg)
+	require.NoError(t, err)
+	require.NoError(t, second.Start())
+	defer second.Stop()
+
+	_, err = first.getPlugin("example")
+	require.NoError(t, err)
+	assert.Equal(t, "2.0.0", first.LoadedFunctions()[0].Version)
+
+	// A retained version is served by reference alongside the current one
+	previous, err := second.getPlugin(FunctionRef("example", "1.0.0"))
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.0", previous.Version())
+	assert.Equal(t, BinaryDigest([]byte("v1")), second.LoadedFunctions()[0].Digest)
+
+	// Pinning the fleet loads the version everywhere
+	results, err := PinFunction(nc, cfg.ServiceName, "", PinRequest{Function: "example", Version: "1.0.0", Actor: "oncall"}, 500*time.Millisecond)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	for _, result := range results {
+		assert.Empty(t, result.Error)
+		assert.Equal(t, "1.0.0", result.Version)
+		assert.Equal(t, BinaryDigest([]byte("v1")), result.Digest)
+		assert.True(t, result.Pinned)
+	}
+	assert.Equal(t, "1.0.0", first.getFunctionMeta("example").Version)
+
+	// A version the registry does not retain is reported and leaves the pin in place
+	results, err = PinFunction(nc, cfg.ServiceName, second.service.Info().ID, PinRequest{Function: "example", Version: "0.1.0"}, time.Second)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Contains(t, results[0].Error, "function version not found")
+	assert.Equal(t, "1.0.0", second.getFunctionMeta("example").Version)
+
+	// Unpinning one instance reloads the registry's version there only
+	results, err = PinFunction(nc, cfg.ServiceName, second.service.Info().ID, PinRequest{Function: "example"}, time.Second)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "2.0.0", results[0].Version)
+	assert.False(t, results[0].Pinned)
+	assert.Equal(t, "1.0.0", first.getFunctionMeta("example").Version)
+	assert.True(t, first.LoadedFunctions()[0].Pinned)
+}
+
+func TestRegistryDeduplicatesBinaries(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	registry, err := NewNATSRegistryWithBuckets(nc, "dedup-test-functions", "dedup-test-binaries")
+	require.NoError(t, err)
+	defer func() {
+		js, _ := jetstream.New(nc)
+		js.DeleteKeyValue(context.Background(), "dedup-test-functions")
+		js.DeleteKeyValue(context.Background(), "dedup-test-functions-versions")
+		js.DeleteObjectStore(context.Background(), "dedup-test-binaries")
+	}()
+
+	countObjects := func() int {
+		objects, err := registry.objectStore.List(context.Background())
+		if err != nil {
+			return 0
+		}
+		return len(objects)
+	}
+
+	// Two functions sharing an artifact share one object
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "dedup-a", Type: "builtin", Version: "1.0.0"}, []byte("shared")))
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "dedup-b", Type: "builtin", Version: "2.0.0"}, []byte("shared")))
+	assert.Equal(t, 1, countObjects())
+
+	meta, binary, err := registry.GetFunction("dedup-b")
+	require.NoError(t, err)
+	assert.Equal(t, BinaryDigest([]byte("shared")), meta.Digest)
+	assert.Equal(t, "shared", string(binary))
+
+	// With a local cache, binaries downloaded once are served from disk
+	cache, err := NewBinaryCache(t.TempDir(), 0)
+	require.NoError(t, err)
+	registry.SetBinaryCache(cache)
+	_, _, err = registry.GetFunction("dedup-a")
+	require.NoError(t, err)
+	cached, ok := cache.Get(BinaryDigest([]byte("shared")))
+	require.True(t, ok)
+	assert.Equal(t, "shared", string(cached))
+	registry.SetBinaryCache(nil)
+
+	// Redeploying an unchanged artifact only writes metadata
+	require.NoError(t, registry.DeployFunctions([]FunctionDeployment{
+		{Meta: FunctionMeta{Name: "dedup-a", Type: "builtin", Version: "1.0.1"}, Binary: []byte("shared")},
+	}))
+	assert.Equal(t, 1, countObjects())
+
+	// A new artifact is stored once and the shared one stays while referenced
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "dedup-a", Type: "builtin", Version: "1.1.0"}, []byte("new")))
+	assert.Equal(t, 2, countObjects())
+
+	// Binaries are removed with the last revision referencing them; the shared one is
+	// kept for rolling dedup-a back
+	require.NoError(t, registry.DeleteFunction("dedup-b"))
+	assert.Equal(t, 2, countObjects())
+	_, binary, err = registry.GetFunction("dedup-a")
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(binary))
+	require.NoError(t, registry.DeleteFunction("dedup-a"))
+	assert.Equal(t, 0, countObjects())
+}
+
+// TestSchemaRegistryValidatesEventData tests rejecting event data that does not match its registered schema
+func TestSchemaRegistryValidatesEventData(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	schemas, err := NewNATSSchemaRegistry(nc, "schema-test")
+	require.NoError(t, err)
+	defer func() {
+		js, _ := jetstream.New(nc)
+		js.DeleteKeyValue(context.Background(), "schema-test")
+	}()
+
+	ctx := context.Background()
+	assert.Error(t, schemas.PutSchema(ctx, "order.created", []byte(`{"type": "money"}`)))
+	require.NoError(t, schemas.PutSchema(ctx, "order.created",
+		[]byte(`{"type": "object", "required": ["order_id"], "properties": {"order_id": {"type": "string"}}}`)))
+
+	eventTypes, err := schemas.ListSchemas(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"order.created"}, eventTypes)
+
+	rs := &RuntimeService{schemas: schemas}
+	event := ce.NewEvent()
+	event.SetType("order.created")
+	require.NoError(t, event.SetData(ce.ApplicationJSON, map[string]interface{}{"order_id": "o-1"}))
+	assert.NoError(t, rs.validateEventData(ctx, "orders", &event))
+
+	require.NoError(t, event.SetData(ce.ApplicationJSON, map[string]interface{}{"order_id": 1}))
+	var invalid *InvalidEventDataError
+	require.ErrorAs(t, rs.validateEventData(ctx, "orders", &event), &invalid)
+	assert.Equal(t, []string{"data.order_id: expected string, got integer"}, invalid.Violations)
+
+	// Event types without a schema are not checked
+	event.SetType("order.deleted")
+	assert.NoError(t, rs.validateEventData(ctx, "orders", &event))
+}
+
+// TestInvocationMirroring tests publishing sanitized copies of invocations to the debug subject
+func TestInvocationMirroring(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	registry := &MemoryRegistry{}
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "example", Type: "builtin", Version: "1.0.0"}, nil))
+
+	service, err := NewRuntimeService(RuntimeServiceConfig{
+		NATSURL:     "nats://localhost:4222",
+		ServiceName: "mirror-test-function-runtime",
+		Registry:    registry,
+		Metrics:     &SimpleMetricsCollector{},
+		Logger:      &SimpleLogger{},
+		Mirror: MirrorConfig{
+			Subject:   "mirror-test",
+			Functions: []string{"example"},
+			Redaction: &event.RedactionPolicy{Rules: []event.RedactionRule{{Fields: []string{"data.token"}}}},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, service.Start())
+	defer service.Stop()
+
+	sub, err := nc.SubscribeSync(MirrorSubject("mirror-test", "*"))
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	invoke := func(functionName string) {
+		request := ce.NewEvent()
+		request.SetID("mirror-1")
+		request.SetSource("mirror-test")
+		request.SetType("com.example.mirror")
+		require.NoError(t, request.SetData(ce.ApplicationJSON, map[string]string{"token": "secret", "user": "alice"}))
+		data, err := json.Marshal(map[string]interface{}{"functionName": functionName, "event": &request})
+		require.NoError(t, err)
+		_, err = nc.Request("function.invoke", data, 2*time.Second)
+		require.NoError(t, err)
+	}
+
+	invoke("example")
+	msg, err := sub.NextMsg(time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "mirror-test.example", msg.Subject)
+
+	var record MirroredInvocation
+	require.NoError(t, json.Unmarshal(msg.Data, &record))
+	assert.Equal(t, "example", record.FunctionName)
+	assert.Empty(t, record.ErrorType)
+	assert.Len(t, record.Response, 1)
+	assert.JSONEq(t, `{"token": "[REDACTED]", "user": "alice"}`, string(record.Request.Data()))
+
+	// Functions outside the filter are not mirrored
+	invoke("missing")
+	_, err = sub.NextMsg(200 * time.Millisecond)
+	assert.ErrorIs(t, err, nats.ErrTimeout)
+}
+
+// TestSubscribeResults tests consuming function output events of asynchronous and streamed invocations
+func TestSubscribeResults(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	registry := &MemoryRegistry{}
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "example", Type: "builtin", Version: "1.0.0"}, nil))
+
+	cfg := RuntimeServiceConfig{
+		NATSURL:       "nats://localhost:4222",
+		ServiceName:   "results-test-function-runtime",
+		Registry:      registry,
+		Metrics:       &SimpleMetricsCollector{},
+		Logger:        &SimpleLogger{},
+		ResultSubject: "results-test",
+	}
+	service, err := NewRuntimeService(cfg)
+	require.NoError(t, err)
+	require.NoError(t, service.Start())
+	defer service.Stop()
+
+	client, err := NewClient(ClientConfig{Conn: nc, ResultSubject: "results-test"})
+	require.NoError(t, err)
+	defer client.Close()
+
+	results, err := client.SubscribeResults("example")
+	require.NoError(t, err)
+	defer results.Unsubscribe()
+	require.NoError(t, nc.Flush())
+
+	newEvent := func(id string) *ce.Event {
+		event := ce.NewEvent()
+		event.SetID(id)
+		event.SetSource("results-test")
+		event.SetType("com.example.results")
+		return &event
+	}
+
+	// Asynchronous invocations deliver their output to subscribers
+	request := newEvent("async-1")
+	require.NoError(t, client.InvokeFunctionAsync(context.Background(), "example", request))
+	select {
+	case result := <-results.Events():
+		assert.Equal(t, "response-async-1", result.ID())
+		assert.True(t, IsResponseTo(result, request))
+	case <-time.After(2 * time.Second):
+		t.Fatal("no result delivered for asynchronous invocation")
+	}
+
+	// Synchronous invocations are not published unless results are streamed
+	_, err = client.InvokeFunction(context.Background(), "example", newEvent("sync-1"))
+	require.NoError(t, err)
+	select {
+	case result := <-results.Events():
+		t.Fatalf("unexpected result %s", result.ID())
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	service.streamResults = true
+	_, err = client.InvokeFunction(context.Background(), "example", newEvent("sync-2"))
+	require.NoError(t, err)
+	select {
+	case result := <-results.Events():
+		assert.Equal(t, "response-sync-2", result.ID())
+	case <-time.After(2 * time.Second):
+		t.Fatal("no result delivered for streamed invocation")
+	}
+
+	// Unsubscribing closes the events channel
+	require.NoError(t, results.Unsubscribe())
+	_, open := <-results.Events()
+	assert.False(t, open)
+}
+
+// errorLogger records the messages of errors it logs
+type errorLogger struct {
+	SimpleLogger
+	mu     sync.Mutex
+	errors []string
+}
+
+func (l *errorLogger) Error(msg string, fields ...Field) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errors = append(l.errors, msg)
+}
+
+func (l *errorLogger) logged(msg string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, logged := range l.errors {
+		if logged == msg {
+			return true
+		}
+	}
+	return false
+}
+
+// TestPersistResults tests that persisted results are stored by a stream and that
+// results no stream stores are reported instead of dropped
+func TestPersistResults(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+	js, err := jetstream.New(nc)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	stream, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:     "persist-results-test",
+		Subjects: []string{"persist-results-test.>"},
+	})
+	require.NoError(t, err)
+	defer js.DeleteStream(ctx, "persist-results-test")
+
+	registry := &MemoryRegistry{}
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "example", Type: "builtin", Version: "1.0.0"}, nil))
+	logger := &errorLogger{}
+	service, err := NewRuntimeService(RuntimeServiceConfig{
+		NATSURL:        "nats://localhost:4222",
+		ServiceName:    "persist-results-test-function-runtime",
+		Registry:       registry,
+		Metrics:        &SimpleMetricsCollector{},
+		Logger:         logger,
+		ResultSubject:  "persist-results-test",
+		PersistResults: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, service.Start())
+	defer service.Stop()
+
+	client, err := NewClient(ClientConfig{Conn: nc})
+	require.NoError(t, err)
+	defer client.Close()
+
+	request := ce.NewEvent()
+	request.SetID("persist-1")
+	request.SetSource("results-test")
+	request.SetType("com.example.results")
+	require.NoError(t, client.InvokeFunctionAsync(ctx, "example", &request))
+	require.Eventually(t, func() bool {
+		info, err := stream.Info(ctx)
+		return err == nil && info.State.Msgs == 1
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.False(t, logger.logged("Failed to publish result event"))
+
+	// Without a stream the result fails to publish
+	require.NoError(t, js.DeleteStream(ctx, "persist-results-test"))
+	require.NoError(t, client.InvokeFunctionAsync(ctx, "example", &request))
+	require.Eventually(t, func() bool { return logger.logged("Failed to publish result event") }, 10*time.Second, 20*time.Millisecond)
+}
+
+// TestClientFailsOverBetweenClusters tests that invocations reach a healthy cluster while the primary is down
+func TestClientFailsOverBetweenClusters(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	nc.Close()
+
+	registry := &MemoryRegistry{}
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "example", Type: "builtin", Version: "1.0.0"}, nil))
+
+	cfg := RuntimeServiceConfig{
+		NATSURL:     "nats://localhost:4222",
+		ServiceName: "failover-test-function-runtime",
+		Registry:    registry,
+		Metrics:     &SimpleMetricsCollector{},
+		Logger:      &SimpleLogger{},
+	}
+	service, err := NewRuntimeService(cfg)
+	require.NoError(t, err)
+	require.NoError(t, service.Start())
+	defer service.Stop()
+
+	// The primary cluster is unreachable, the client still starts
+	client, err := NewClient(ClientConfig{
+		NATSURL:             "nats://localhost:4299",
+		Clusters:            []string{"nats://localhost:4222"},
+		HealthCheckInterval: 50 * time.Millisecond,
+		StickyRouting:       true,
+		Timeout:             5 * time.Second,
+	})
+	require.NoError(t, err)
+	defer client.Close()
+	require.Eventually(t, func() bool { return client.clusters[1].nc.IsConnected() }, 2*time.Second, 10*time.Millisecond)
+
+	event := ce.NewEvent()
+	event.SetID("failover-1")
+	event.SetSource("failover-test")
+	event.SetType("com.example.failover")
+
+	responses, err := client.InvokeFunction(context.Background(), "example", &event)
+	require.NoError(t, err)
+	require.Len(t, responses, 1)
+	assert.Equal(t, "response-failover-1", responses[0].ID())
+
+	// The serving cluster is remembered and the primary is reported unhealthy
+	assert.Same(t, client.clusters[1], client.sticky["example"])
+	assert.Eventually(t, func() bool { return !client.clusters[0].healthy.Load() }, 2*time.Second, 10*time.Millisecond)
+}
+
+// TestClaimCheckedInvocations tests that large events travel as references between the
+// client and runtime and are resolved on both sides
+func TestClaimCheckedInvocations(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	if _, err := js.AccountInfo(); err != nil {
+		t.Skip("JetStream not available, skipping integration test")
+	}
+
+	bucket := fmt.Sprintf("claimcheck-function-test-%d", time.Now().UnixNano())
+	defer js.DeleteObjectStore(bucket)
+	// A threshold below the example function's response offloads the output too
+	claims := &event.ClaimCheckConfig{Bucket: bucket, Threshold: 64}
+
+	registry := &MemoryRegistry{}
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "example", Type: "builtin", Version: "1.0.0"}, nil))
+	service, err := NewRuntimeService(RuntimeServiceConfig{
+		NATSURL:     "nats://localhost:4222",
+		ServiceName: "claimcheck-test-function-runtime",
+		Registry:    registry,
+		Metrics:     &SimpleMetricsCollector{},
+		Logger:      &SimpleLogger{},
+		ClaimCheck:  claims,
+	})
+	require.NoError(t, err)
+	require.NoError(t, service.Start())
+	defer service.Stop()
+
+	client, err := NewClient(ClientConfig{Conn: nc, ClaimCheck: claims})
+	require.NoError(t, err)
+	defer client.Close()
+
+	request := ce.NewEvent()
+	request.SetID("large-1")
+	request.SetSource("claimcheck-test")
+	request.SetType("com.example.large")
+	require.NoError(t, request.SetData(ce.ApplicationJSON, map[string]string{"blob": fmt.Sprintf("%0512d", 0)}))
+
+	events, err := client.InvokeFunction(context.Background(), "example", &request)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.False(t, event.IsClaimChecked(events[0]))
+	var data map[string]string
+	require.NoError(t, events[0].DataAs(&data))
+	assert.Equal(t, "com.example.large", data["original_type"])
+
+	// References the runtime cannot resolve fail the invocation
+	missing := request.Clone()
+	missing.SetID("missing-1")
+	missing.DataEncoded = nil
+	missing.SetExtension(event.ExtClaimCheck, bucket+"/missing")
+	_, err = client.InvokeFunction(context.Background(), "example", &missing)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "claim_check_error")
+}
+
+func TestRuntimeGroups(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	// Two runtimes share one connection under different groups
+	services := make(map[string]*RuntimeService)
+	for group, version := range map[string]string{"group-test.a": "1.0.0", "group-test.b": "2.0.0"} {
+		registry := &MemoryRegistry{}
+		require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "example", Type: "builtin", Version: version}, nil))
+		service, err := NewRuntimeService(RuntimeServiceConfig{
+			Conn:        nc,
+			ServiceName: "group-test-function-runtime",
+			Registry:    registry,
+			Metrics:     &SimpleMetricsCollector{},
+			Logger:      &SimpleLogger{},
+			Group:       group,
+		})
+		require.NoError(t, err)
+		require.NoError(t, service.Start())
+		defer service.Stop()
+		services[group] = service
+	}
+
+	info := services["group-test.a"].service.Info()
+	subjects := make(map[string]string)
+	for _, endpoint := range info.Endpoints {
+		subjects[endpoint.Name] = endpoint.Subject
+		if endpoint.Name == "invoke" {
+			assert.Equal(t, "group-test.a", endpoint.Metadata["group"])
+		}
+	}
+	assert.Equal(t, "group-test.a.invoke", subjects["invoke"])
+	assert.Equal(t, "group-test.a.describe", subjects["describe"])
+	assert.Equal(t, "group-test.a.health", subjects["health"])
+
+	ctx := context.Background()
+	for group, version := range map[string]string{"group-test.a": "1.0.0", "group-test.b": "2.0.0"} {
+		client, err := NewClient(ClientConfig{Conn: nc, Group: group, Timeout: 2 * time.Second})
+		require.NoError(t, err)
+		defer client.Close()
+
+		description, err := client.DescribeFunction(ctx, "example")
+		require.NoError(t, err)
+		assert.Equal(t, group, description.Group)
+		assert.Equal(t, version, description.Function.Version)
+		assert.Nil(t, description.Loaded)
+
+		event := ce.NewEvent()
+		event.SetID("group-" + version)
+		event.SetSource("group-test")
+		event.SetType("com.example.group")
+		_, err = client.InvokeFunction(ctx, "example", &event)
+		require.NoError(t, err)
+
+		description, err = client.DescribeFunction(ctx, "example")
+		require.NoError(t, err)
+		require.NotNil(t, description.Loaded)
+		assert.Equal(t, version, description.Loaded.Version)
+
+		_, err = client.DescribeFunction(ctx, "missing")
+		assert.Error(t, err)
+
+		msg, err := nc.Request(HealthSubject(group), nil, time.Second)
+		require.NoError(t, err)
+		var health RuntimeHealth
+		require.NoError(t, json.Unmarshal(msg.Data, &health))
+		assert.Equal(t, HealthStatusOK, health.Status)
+		assert.Equal(t, group, health.Group)
+		assert.Equal(t, 1, health.Functions)
+	}
+
+	// Stopping a runtime leaves the shared connection open for the other
+	require.NoError(t, services["group-test.a"].Stop())
+	assert.True(t, nc.IsConnected())
+	_, err = nc.Request(HealthSubject("group-test.b"), nil, time.Second)
+	assert.NoError(t, err)
+}
+
+// TestRuntimePrefix tests that runtimes of different environments share a connection
+// without answering each other's invocations
+func TestRuntimePrefix(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	for _, environment := range []string{"staging", "prod"} {
+		registry := &MemoryRegistry{}
+		require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "example", Type: "builtin", Version: "1.0.0"}, nil))
+		service, err := NewRuntimeService(RuntimeServiceConfig{
+			Conn:     nc,
+			Registry: registry,
+			Metrics:  &SimpleMetricsCollector{},
+			Logger:   &SimpleLogger{},
+			Group:    "prefix-test",
+			Prefix:   naming.Prefix{Tenant: "acme", Environment: environment},
+		})
+		require.NoError(t, err)
+		require.NoError(t, service.Start())
+		defer service.Stop()
+
+		info := service.service.Info()
+		assert.Equal(t, "acme-"+environment+"-function-runtime", info.Name)
+		assert.Equal(t, "acme-"+environment+"-prefix-test", service.group)
+	}
+
+	msg, err := nc.Request(HealthSubject("acme-staging-prefix-test"), nil, time.Second)
+	require.NoError(t, err)
+	var health RuntimeHealth
+	require.NoError(t, json.Unmarshal(msg.Data, &health))
+	assert.Equal(t, "acme-staging-prefix-test", health.Group)
+
+	_, err = nc.Request(HealthSubject("prefix-test"), nil, 200*time.Millisecond)
+	assert.ErrorIs(t, err, nats.ErrNoResponders)
+}
+
+// TestRuntimeProfileEndpoint tests profiling a runtime instance with the admin token
+func TestRuntimeProfileEndpoint(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	newService := func(tokenSHA256 string) *RuntimeService {
+		service, err := NewRuntimeService(RuntimeServiceConfig{
+			Conn:               nc,
+			ServiceName:        "profile-test-function-runtime",
+			Registry:           &MemoryRegistry{},
+			Metrics:            &SimpleMetricsCollector{},
+			Logger:             &SimpleLogger{},
+			Group:              "profile-test",
+			ProfileTokenSHA256: tokenSHA256,
+		})
+		require.NoError(t, err)
+		require.NoError(t, service.Start())
+		t.Cleanup(func() { service.Stop() })
+		return service
+	}
+
+	_, err = NewRuntimeService(RuntimeServiceConfig{Conn: nc, Registry: &MemoryRegistry{}, ProfileTokenSHA256: "short"})
+	assert.Error(t, err)
+
+	sum := sha256.Sum256([]byte("admin-token"))
+	service := newService(hex.EncodeToString(sum[:]))
+	info := service.service.Info()
+	subject := ProfileSubject(info.Name, info.ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = profiling.Fetch(ctx, nc, subject, "wrong", profiling.Request{Kind: profiling.KindHeap})
+	var profErr *profiling.Error
+	require.ErrorAs(t, err, &profErr)
+	assert.Equal(t, "401", profErr.Code)
+
+	heap, err := profiling.Fetch(ctx, nc, subject, "admin-token", profiling.Request{Kind: profiling.KindHeap})
+	require.NoError(t, err)
+	assert.NotEmpty(t, heap)
+	samples, err := profiling.FetchMetrics(ctx, nc, subject, "admin-token")
+	require.NoError(t, err)
+	assert.NotEmpty(t, samples)
+
+	// Without a token the endpoint does not exist
+	unprofiled := newService("")
+	info = unprofiled.service.Info()
+	for _, endpoint := range info.Endpoints {
+		assert.NotEqual(t, "profile", endpoint.Name)
+	}
+	_, err = nc.Request(ProfileSubject(info.Name, info.ID), nil, 200*time.Millisecond)
+	assert.Error(t, err)
+}
+
+// TestRegistryVerifyBinaries tests that corrupt and missing binaries are reported and
+// restored from a mirror
+func TestRegistryVerifyBinaries(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	ctx := context.Background()
+	registry, err := NewNATSRegistryWithBuckets(nc, "verify-test-functions", "verify-test-binaries")
+	require.NoError(t, err)
+	defer func() {
+		js, _ := jetstream.New(nc)
+		js.DeleteKeyValue(ctx, "verify-test-functions")
+		js.DeleteKeyValue(ctx, "verify-test-functions-versions")
+		js.DeleteObjectStore(ctx, "verify-test-binaries")
+	}()
+
+	resize := FunctionMeta{Name: "verify-resize", Type: "builtin", Version: "1.0.0"}
+	crop := FunctionMeta{Name: "verify-crop", Type: "builtin", Version: "2.0.0"}
+	require.NoError(t, registry.StoreFunction(resize, []byte("resize-binary")))
+	require.NoError(t, registry.StoreFunction(crop, []byte("crop-binary")))
+
+	report, err := registry.VerifyBinaries(ctx, VerifyOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Verified)
+	assert.Empty(t, report.Issues)
+
+	// Corrupt one binary and lose the other
+	resizeDigest, cropDigest := BinaryDigest([]byte("resize-binary")), BinaryDigest([]byte("crop-binary"))
+	_, err = registry.objectStore.PutBytes(ctx, binaryKey(resizeDigest), []byte("bit rot"))
+	require.NoError(t, err)
+	require.NoError(t, registry.objectStore.Delete(ctx, binaryKey(cropDigest)))
+
+	report, err = registry.VerifyBinaries(ctx, VerifyOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Verified)
+	require.Len(t, report.Unrepaired(), 2)
+	problems := map[string]string{}
+	for _, issue := range report.Issues {
+		problems[issue.Functions[0]] = issue.Problem
+	}
+	assert.Equal(t, map[string]string{"verify-resize@1.0.0": IntegrityCorrupt, "verify-crop@2.0.0": IntegrityMissing}, problems)
+	_, _, err = registry.GetFunction("verify-resize")
+	assert.ErrorIs(t, err, ErrDigestMismatch)
+
+	// A mirror with an intact copy of only one of them restores it
+	mirror := NewMemoryRegistry(MemoryRegistryConfig{})
+	require.NoError(t, mirror.StoreFunction(resize, []byte("resize-binary")))
+	report, err = registry.VerifyBinaries(ctx, VerifyOptions{Mirror: mirror})
+	require.NoError(t, err)
+	require.Len(t, report.Unrepaired(), 1)
+	assert.Equal(t, "verify-crop@2.0.0", report.Unrepaired()[0].Functions[0])
+	assert.Contains(t, report.Unrepaired()[0].RepairError, "no intact copy")
+
+	_, binary, err := registry.GetFunction("verify-resize")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("resize-binary"), binary)
+}
+
+// TestRuntimeGossip tests announcements, preloading of popular functions and routing by gossip
+func TestRuntimeGossip(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	group := "gossip-test"
+	registry := &MemoryRegistry{}
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "example", Type: "builtin", Version: "1.0.0"}, nil))
+	var services []*RuntimeService
+	for i := 0; i < 2; i++ {
+		service, err := NewRuntimeService(RuntimeServiceConfig{
+			Conn:        nc,
+			ServiceName: "gossip-test-function-runtime",
+			Registry:    registry,
+			Metrics:     &SimpleMetricsCollector{},
+			Logger:      &SimpleLogger{},
+			Group:       group,
+			Gossip:      GossipConfig{Interval: 100 * time.Millisecond, Preload: 1},
+		})
+		require.NoError(t, err)
+		require.NoError(t, service.Start())
+		defer service.Stop()
+		services = append(services, service)
+	}
+
+	client, err := NewClient(ClientConfig{Conn: nc, Group: group, Timeout: 2 * time.Second, GossipRouting: true})
+	require.NoError(t, err)
+	defer client.Close()
+
+	event := ce.NewEvent()
+	event.SetID("gossip-1")
+	event.SetSource("gossip-test")
+	event.SetType("com.example.gossip")
+	_, err = client.InvokeFunction(context.Background(), "example", &event)
+	require.NoError(t, err)
+
+	// The instance that did not serve the invocation preloads the function its peer announced
+	require.Eventually(t, func() bool {
+		popular := client.gossip.Popular()
+		return len(popular) == 1 && popular[0].Instances == 2 && popular[0].Invocations == 1
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, "example", client.gossip.Popular()[0].Name)
+	for _, service := range services {
+		loaded := service.LoadedFunctions()
+		require.Len(t, loaded, 1)
+		assert.Equal(t, "example", loaded[0].Name)
+	}
+
+	// Routed invocations reach the announcing instances
+	for i := 0; i < 4; i++ {
+		_, err = client.InvokeFunction(context.Background(), "example", &event)
+		require.NoError(t, err)
+	}
+	request, err := json.Marshal(map[string]interface{}{"functionName": "example", "event": &event})
+	require.NoError(t, err)
+	_, err = nc.Request(InstanceInvokeSubject(group, services[1].service.Info().ID), request, 2*time.Second)
+	require.NoError(t, err)
+
+	// Stopping instances leave the view right away
+	require.NoError(t, services[0].Stop())
+	require.Eventually(t, func() bool {
+		return len(client.gossip.Peers()) == 1
+	}, 2*time.Second, 20*time.Millisecond)
+	assert.Equal(t, services[1].service.Info().ID, client.gossip.Peers()[0].InstanceID)
+	_, err = client.InvokeFunction(context.Background(), "example", &event)
+	require.NoError(t, err)
+}
+
+// TestInvalidEventRejected tests that the runtime answers invocations with invalid
+// events with their violations, which the client returns as a typed error
+func TestInvalidEventRejected(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	registry := &MemoryRegistry{}
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "example", Type: "builtin", Version: "1.0.0"}, nil))
+	service, err := NewRuntimeService(RuntimeServiceConfig{
+		NATSURL:     "nats://localhost:4222",
+		ServiceName: "invalid-event-test-function-runtime",
+		Registry:    registry,
+		Metrics:     &SimpleMetricsCollector{},
+		Logger:      &SimpleLogger{},
+	})
+	require.NoError(t, err)
+	require.NoError(t, service.Start())
+	defer service.Stop()
+
+	msg, err := nc.Request("function.invoke", []byte(`{"functionName":"example","event":{"specversion":"1.0","id":"bad-1","type":"com.example","Tenant":"acme"}}`), 5*time.Second)
+	require.NoError(t, err)
+	var response struct {
+		ErrorType  string            `json:"errorType"`
+		Violations []event.Violation `json:"violations"`
+	}
+	require.NoError(t, json.Unmarshal(msg.Data, &response))
+	assert.Equal(t, "invalid_event", response.ErrorType)
+	assert.Equal(t, []event.Violation{
+		{Attribute: "Tenant", Rule: event.RuleInvalidName, Message: "extension names must consist of lower-case letters and digits"},
+		{Attribute: "source", Rule: event.RuleRequired, Message: "required attribute is missing"},
+	}, response.Violations)
+
+	msg, err = nc.Request("function.invoke", []byte(`{"functionName":"example"}`), 5*time.Second)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(msg.Data, &response))
+	assert.Equal(t, "invalid_event", response.ErrorType)
+
+	client, err := NewClient(ClientConfig{NATSURL: "nats://localhost:4222", Registry: registry, Timeout: 5 * time.Second})
+	require.NoError(t, err)
+	defer client.Close()
+	request := ce.NewEvent()
+	request.SetID("bad-2")
+	request.SetSource("invalid-event-test")
+	request.SetType("com.example")
+	request.SetDataSchema("schemas/order")
+	_, err = client.InvokeFunction(context.Background(), "example", &request)
+	var invalid *event.InvalidEventError
+	require.ErrorAs(t, err, &invalid)
+	assert.Equal(t, "bad-2", invalid.EventID)
+	require.Len(t, invalid.Violations, 1)
+	assert.Equal(t, "dataschema", invalid.Violations[0].Attribute)
+}
+
+// budgetFunction reports its remaining budget and returns a partial result
+type budgetFunction struct{}
+
+func (budgetFunction) Execute(ctx context.Context, request *ce.Event) ([]*ce.Event, error) {
+	Heartbeat(ctx, "started")
+	remaining, ok := Remaining(ctx)
+	if !ok {
+		return nil, fmt.Errorf("no deadline")
+	}
+	response := ce.NewEvent()
+	response.SetID("budget-response")
+	response.SetSource("budget-test")
+	response.SetType("com.example.budget")
+	if err := response.SetData(ce.ApplicationJSON, map[string]int64{"remaining_ms": remaining.Milliseconds()}); err != nil {
+		return nil, err
+	}
+	return []*ce.Event{&response}, PartialResult("stopped early")
+}
+
+// TestInvocationBudgetAndPartialResults tests that functions see the caller's budget
+// and that partial results reach the client with their events
+func TestInvocationBudgetAndPartialResults(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	builtinFunctions["budget-test"] = func(FunctionMeta) (Function, error) { return budgetFunction{}, nil }
+	defer delete(builtinFunctions, "budget-test")
+	registry := &MemoryRegistry{}
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "budget-test", Type: "builtin", Version: "1.0.0"}, nil))
+	service, err := NewRuntimeService(RuntimeServiceConfig{
+		NATSURL:     "nats://localhost:4222",
+		ServiceName: "budget-test-function-runtime",
+		Registry:    registry,
+		Metrics:     &SimpleMetricsCollector{},
+		Logger:      &SimpleLogger{},
+	})
+	require.NoError(t, err)
+	require.NoError(t, service.Start())
+	defer service.Stop()
+
+	client, err := NewClient(ClientConfig{NATSURL: "nats://localhost:4222", Registry: registry})
+	require.NoError(t, err)
+	defer client.Close()
+
+	request := ce.NewEvent()
+	request.SetID("budget-1")
+	request.SetSource("budget-test")
+	request.SetType("com.example.budget")
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	events, err := client.InvokeFunction(ctx, "budget-test", &request)
+	var partial *PartialResultError
+	require.ErrorAs(t, err, &partial)
+	assert.Equal(t, "stopped early", partial.Reason)
+	require.Len(t, events, 1)
+
+	var data struct {
+		RemainingMs int64 `json:"remaining_ms"`
+	}
+	require.NoError(t, events[0].DataAs(&data))
+	assert.Greater(t, data.RemainingMs, int64(2000))
+	assert.LessOrEqual(t, data.RemainingMs, int64(3000))
+
+	// Without a deadline the function has no budget
+	_, err = client.InvokeFunction(context.Background(), "budget-test", &request)
+	assert.ErrorContains(t, err, "no deadline")
+}
+
+// blockingFunction runs until its invocation is cancelled, or for a second when it
+// ignores the context
+type blockingFunction struct{ ignoreContext bool }
+
+func (f blockingFunction) Execute(ctx context.Context, request *ce.Event) ([]*ce.Event, error) {
+	if f.ignoreContext {
+		time.Sleep(time.Second)
+		return nil, nil
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// TestFunctionTimeoutCancelsInvocation tests that invocations running past the function's
+// timeout are cancelled and callers get a timeout error
+func TestFunctionTimeoutCancelsInvocation(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	group := "timeout-test"
+	registry := &MemoryRegistry{}
+	require.NoError(t, registry.StoreFunction(FunctionMeta{
+		Name:    "blocking",
+		Type:    TypeBuiltin,
+		Version: "1.0.0",
+		Config:  map[string]string{"timeout": "200ms"},
+	}, nil))
+	require.NoError(t, registry.StoreFunction(FunctionMeta{
+		Name:    "sleeping",
+		Type:    TypeBuiltin,
+		Version: "1.0.0",
+	}, nil))
+	service, err := NewRuntimeService(RuntimeServiceConfig{
+		Conn:            nc,
+		ServiceName:     "timeout-test-function-runtime",
+		Registry:        registry,
+		Metrics:         &SimpleMetricsCollector{},
+		Logger:          &SimpleLogger{},
+		Group:           group,
+		FunctionTimeout: 300 * time.Millisecond,
+		Builtins: map[string]func(FunctionMeta) (Function, error){
+			"blocking": func(FunctionMeta) (Function, error) { return blockingFunction{}, nil },
+			"sleeping": func(FunctionMeta) (Function, error) { return blockingFunction{ignoreContext: true}, nil },
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, service.Start())
+	defer service.Stop()
+
+	client, err := NewClient(ClientConfig{Conn: nc, Group: group, Timeout: 5 * time.Second})
+	require.NoError(t, err)
+	defer client.Close()
+
+	request := ce.NewEvent()
+	request.SetID("timeout-1")
+	request.SetSource("timeout-test")
+	request.SetType("com.example.timeout")
+
+	// Functions ignoring the context are answered when the runtime's default elapses
+	for name, timeout := range map[string]string{"blocking": "200ms", "sleeping": "300ms"} {
+		start := time.Now()
+		_, err = client.InvokeFunction(context.Background(), name, &request)
+		require.ErrorIs(t, err, ErrFunctionTimeout, name)
+		assert.ErrorContains(t, err, "function error (timeout): function timed out after "+timeout)
+		assert.Less(t, time.Since(start), 900*time.Millisecond, name)
+	}
+}
+
+// TestRouterSubscription tests a router function subscribed to an event stream,
+// publishing events to the subjects and functions of their rules
+func TestRouterSubscription(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	group := "router-test"
+	registry := &MemoryRegistry{}
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "example", Type: TypeBuiltin, Version: "1.0.0"}, nil))
+	require.NoError(t, registry.StoreFunction(FunctionMeta{
+		Name:    "order-router",
+		Type:    TypeBuiltin,
+		Version: "1.0.0",
+		Config: map[string]string{
+			"builtin": BuiltinRouter,
+			"rules":   `[{"name": "large", "when": "data.total > 100", "subject": "router-test.large"}, {"function": "example"}]`,
+		},
+	}, nil))
+	service, err := NewRuntimeService(RuntimeServiceConfig{
+		Conn:          nc,
+		ServiceName:   "router-test-function-runtime",
+		Registry:      registry,
+		Metrics:       &SimpleMetricsCollector{},
+		Logger:        &SimpleLogger{},
+		Group:         group,
+		Subscriptions: []FunctionSubscription{{Subject: "router-test.orders", Function: "order-router"}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, service.Start())
+	defer service.Stop()
+
+	client, err := NewClient(ClientConfig{Conn: nc, Group: group, Timeout: 2 * time.Second})
+	require.NoError(t, err)
+	defer client.Close()
+	results, err := client.SubscribeResults("example")
+	require.NoError(t, err)
+	defer results.Unsubscribe()
+	large, err := nc.SubscribeSync("router-test.large")
+	require.NoError(t, err)
+	defer large.Unsubscribe()
+	require.NoError(t, nc.Flush())
+
+	publish := func(id string, total int) *ce.Event {
+		event := ce.NewEvent()
+		event.SetID(id)
+		event.SetSource("shop")
+		event.SetType("order.created")
+		require.NoError(t, event.SetData(ce.ApplicationJSON, map[string]int{"total": total}))
+		data, err := json.Marshal(event)
+		require.NoError(t, err)
+		require.NoError(t, nc.Publish("router-test.orders", data))
+		return &event
+	}
+	publish("order-large", 250)
+	small := publish("order-small", 20)
+
+	msg, err := large.NextMsg(2 * time.Second)
+	require.NoError(t, err)
+	routed := ce.NewEvent()
+	require.NoError(t, json.Unmarshal(msg.Data, &routed))
+	assert.Equal(t, "order-large", routed.ID())
+	assert.Equal(t, "large", routed.Extensions()[ExtRouteRule])
+	assert.NotContains(t, routed.Extensions(), ExtRouteSubject)
+
+	// The catch-all rule chains an invocation of the example function
+	select {
+	case result := <-results.Events():
+		assert.Equal(t, "response-order-small", result.ID())
+		assert.True(t, IsResponseTo(result, small))
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the routed invocation")
+	}
+
+	// Invoked directly, the router answers with the targets of the events
+	events, err := client.InvokeFunction(context.Background(), "order-router", small)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "example", events[0].Extensions()[ExtRouteFunction])
+}
+
+// TestCordonAndDrain tests taking a runtime instance out of rotation and back
+func TestCordonAndDrain(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	registry := &MemoryRegistry{}
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "example", Type: "builtin", Version: "1.0.0"}, nil))
+	cfg := RuntimeServiceConfig{
+		Conn:        nc,
+		ServiceName: "cordon-test-function-runtime",
+		Registry:    registry,
+		Metrics:     &SimpleMetricsCollector{},
+		Logger:      &SimpleLogger{},
+		Group:       "cordon-test",
+	}
+	first, err := NewRuntimeService(cfg)
+	require.NoError(t, err)
+	require.NoError(t, first.Start())
+	defer first.Stop()
+	second, err := NewRuntimeService(cfg)
+	require.NoError(t, err)
+	require.NoError(t, second.Start())
+	defer second.Stop()
+
+	client, err := NewClient(ClientConfig{Conn: nc, Group: "cordon-test", Timeout: 2 * time.Second})
+	require.NoError(t, err)
+	defer client.Close()
+	invoke := func(n int) {
+		for i := 0; i < n; i++ {
+			event := ce.NewEvent()
+			event.SetID(fmt.Sprintf("cordon-%d", i))
+			event.SetSource("cordon-test")
+			event.SetType("com.example.cordon")
+			_, err := client.InvokeFunction(context.Background(), "example", &event)
+			require.NoError(t, err)
+		}
+	}
+
+	// A drained instance takes no invocations; they are served by the other one
+	firstID := first.service.Info().ID
+	status, err := CordonInstance(nc, cfg.ServiceName, firstID, CordonRequest{Action: CordonActionDrain, Timeout: time.Second}, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, firstID, status.InstanceID)
+	assert.True(t, status.Cordoned)
+	assert.True(t, status.Drained)
+	invoke(20)
+	assert.Empty(t, first.LoadedFunctions())
+	assert.Len(t, second.LoadedFunctions(), 1)
+
+	// With every instance cordoned, invocations fail instead of circulating
+	_, err = CordonInstance(nc, cfg.ServiceName, second.service.Info().ID, CordonRequest{Action: CordonActionCordon}, time.Second)
+	require.NoError(t, err)
+	event := ce.NewEvent()
+	event.SetID("cordon-all")
+	event.SetSource("cordon-test")
+	event.SetType("com.example.cordon")
+	_, err = client.InvokeFunction(context.Background(), "example", &event)
+	assert.ErrorContains(t, err, "instance_cordoned")
+
+	// Uncordoned instances are back in rotation
+	for _, rs := range []*RuntimeService{first, second} {
+		status, err := CordonInstance(nc, cfg.ServiceName, rs.service.Info().ID, CordonRequest{Action: CordonActionUncordon}, time.Second)
+		require.NoError(t, err)
+		assert.False(t, status.Cordoned)
+	}
+	var health RuntimeHealth
+	msg, err := nc.Request(HealthSubject("cordon-test"), nil, time.Second)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(msg.Data, &health))
+	assert.False(t, health.Cordoned)
+	invoke(20)
+	assert.Len(t, first.LoadedFunctions(), 1)
+
+	_, err = CordonInstance(nc, cfg.ServiceName, firstID, CordonRequest{Action: "evict"}, time.Second)
+	assert.ErrorContains(t, err, "unknown cordon action")
+}
+
+// TestColdFunctionsDoNotShareExecutionSlots tests that a cold function hanging in its
+// invocation does not hold the shared slot other cold functions need to load
+func TestColdFunctionsDoNotShareExecutionSlots(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	group := "cold-bulkhead-test"
+	registry := &MemoryRegistry{}
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "blocking", Type: TypeBuiltin, Version: "1.0.0"}, nil))
+	require.NoError(t, registry.StoreFunction(FunctionMeta{Name: "example", Type: TypeBuiltin, Version: "1.0.0"}, nil))
+	service, err := NewRuntimeService(RuntimeServiceConfig{
+		Conn:                     nc,
+		ServiceName:              "cold-bulkhead-test-function-runtime",
+		Registry:                 registry,
+		Metrics:                  &SimpleMetricsCollector{},
+		Logger:                   &SimpleLogger{},
+		Group:                    group,
+		MaxConcurrentInvocations: 1,
+		FunctionTimeout:          5 * time.Second,
+		Builtins: map[string]func(FunctionMeta) (Function, error){
+			"blocking": func(FunctionMeta) (Function, error) { return blockingFunction{}, nil },
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, service.Start())
+	defer service.Stop()
+
+	client, err := NewClient(ClientConfig{Conn: nc, Group: group, Timeout: 5 * time.Second})
+	require.NoError(t, err)
+	defer client.Close()
+
+	request := ce.NewEvent()
+	request.SetID("cold-1")
+	request.SetSource("cold-bulkhead-test")
+	request.SetType("com.example.cold")
+
+	// The blocking function hangs in its own partition once it loaded
+	require.NoError(t, client.InvokeFunctionAsync(context.Background(), "blocking", &request))
+	require.Eventually(t, func() bool { return len(service.inFlight.invocationIDs("blocking")) == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, service.getBulkhead("blocking").inFlight())
+
+	// Another cold function still gets the shared slot to load
+	events, err := client.InvokeFunction(context.Background(), "example", &request)
+	require.NoError(t, err)
+	assert.Len(t, events, 1)
 }
@@ -0,0 +1,238 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	ce "github.com/cloudevents/sdk-go/v2/event"
+	"github.com/dop251/goja"
+)
+
+// TypeJavaScript is the function type of JavaScript run in an embedded engine
+const TypeJavaScript = "javascript"
+
+// JavaScriptHandler is the global function a JavaScript function must define. It is
+// called with the CloudEvent as an object and returns an event, an array of events,
+// or null for none.
+const JavaScriptHandler = "handle"
+
+// jsPlugin is a compiled JavaScript function
+type jsPlugin struct {
+	meta FunctionMeta
+	fn   *jsFunction
+}
+
+func (p *jsPlugin) Name() string       { return p.meta.Name }
+func (p *jsPlugin) Version() string    { return p.meta.Version }
+func (p *jsPlugin) Type() string       { return p.meta.Type }
+func (p *jsPlugin) Function() Function { return p.fn }
+
+// loadJavaScript compiles a JavaScript function. The source is stored in the registry
+// as the function binary; Config["timeout"] limits each invocation like for scripts.
+// console.log and console.error write to output, which may be nil.
+func loadJavaScript(meta FunctionMeta, source []byte, output func(functionName, stream string) io.Writer) (Plugin, error) {
+	program, err := goja.Compile(meta.Name+".js", string(source), true)
+	if err != nil {
+		return nil, fmt.Errorf("javascript function %s: %w", meta.Name, err)
+	}
+
+	fn := &jsFunction{program: program, timeout: DefaultScriptTimeout}
+	if value := meta.Config[ConfigTimeout]; value != "" {
+		if fn.timeout, err = time.ParseDuration(value); err != nil || fn.timeout <= 0 {
+			return nil, fmt.Errorf("javascript function %s: invalid timeout %q", meta.Name, value)
+		}
+	}
+	if output != nil {
+		fn.stdout = output(meta.Name, "stdout")
+		fn.stderr = output(meta.Name, "stderr")
+	}
+
+	// Run the top level once so a missing handler fails the load, not the first invocation
+	vm, err := fn.newVM()
+	if err != nil {
+		return nil, fmt.Errorf("javascript function %s: %w", meta.Name, err)
+	}
+	fn.idle = append(fn.idle, vm)
+	return &jsPlugin{meta: meta, fn: fn}, nil
+}
+
+// jsFunction runs a compiled program. A goja runtime is single-threaded, so concurrent
+// invocations each take an idle runtime or create one; global state of a script is
+// therefore per runtime and must not be relied on between invocations.
+type jsFunction struct {
+	program *goja.Program
+	timeout time.Duration
+	stdout  io.Writer
+	stderr  io.Writer
+
+	mu   sync.Mutex
+	idle []*jsVM
+}
+
+// jsVM is a runtime with the program loaded
+type jsVM struct {
+	rt     *goja.Runtime
+	handle goja.Callable
+}
+
+// newVM creates a runtime, runs the program and looks up its handler
+func (f *jsFunction) newVM() (*jsVM, error) {
+	rt := goja.New()
+	console := rt.NewObject()
+	console.Set("log", f.consoleWriter(f.stdout))
+	console.Set("error", f.consoleWriter(f.stderr))
+	rt.Set("console", console)
+
+	if _, err := rt.RunProgram(f.program); err != nil {
+		return nil, err
+	}
+	handle, ok := goja.AssertFunction(rt.Get(JavaScriptHandler))
+	if !ok {
+		return nil, fmt.Errorf("script does not define a %s(event) function", JavaScriptHandler)
+	}
+	return &jsVM{rt: rt, handle: handle}, nil
+}
+
+// consoleWriter returns a console method writing its arguments as a line to w
+func (f *jsFunction) consoleWriter(w io.Writer) func(goja.FunctionCall) goja.Value {
+	return func(call goja.FunctionCall) goja.Value {
+		if w == nil {
+			return goja.Undefined()
+		}
+		args := make([]string, len(call.Arguments))
+		for i, arg := range call.Arguments {
+			args[i] = arg.String()
+		}
+		fmt.Fprintln(w, strings.Join(args, " "))
+		return goja.Undefined()
+	}
+}
+
+// acquire takes an idle runtime or creates one
+func (f *jsFunction) acquire() (*jsVM, error) {
+	f.mu.Lock()
+	if n := len(f.idle); n > 0 {
+		vm := f.idle[n-1]
+		f.idle = f.idle[:n-1]
+		f.mu.Unlock()
+		return vm, nil
+	}
+	f.mu.Unlock()
+	return f.newVM()
+}
+
+// release returns a runtime to the idle ones
+func (f *jsFunction) release(vm *jsVM) {
+	f.mu.Lock()
+	f.idle = append(f.idle, vm)
+	f.mu.Unlock()
+}
+
+// Execute calls the handler with the event and validates the events it returns
+func (f *jsFunction) Execute(ctx context.Context, event *ce.Event) ([]*ce.Event, error) {
+	input, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event: %w", err)
+	}
+	vm, err := f.acquire()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create javascript runtime: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { vm.rt.Interrupt(ctx.Err()) })
+
+	output, err := f.call(vm, input)
+	if stop() {
+		f.release(vm)
+	}
+	// An interrupted runtime is dropped, it cannot be reused
+	var interrupted *goja.InterruptedError
+	switch {
+	case errors.As(err, &interrupted) && errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return nil, fmt.Errorf("javascript timed out after %s", f.timeout)
+	case errors.As(err, &interrupted) && ctx.Err() != nil:
+		return nil, ctx.Err()
+	case err != nil:
+		return nil, err
+	}
+	return parseJavaScriptEvents(output)
+}
+
+// call runs the handler on a JSON event and returns its result as JSON
+func (f *jsFunction) call(vm *jsVM, input []byte) (string, error) {
+	jsonObject := vm.rt.Get("JSON").ToObject(vm.rt)
+	parse, _ := goja.AssertFunction(jsonObject.Get("parse"))
+	stringify, _ := goja.AssertFunction(jsonObject.Get("stringify"))
+
+	event, err := parse(jsonObject, vm.rt.ToValue(string(input)))
+	if err != nil {
+		return "", fmt.Errorf("failed to pass event to javascript: %w", err)
+	}
+	result, err := vm.handle(goja.Undefined(), event)
+	if err != nil {
+		return "", fmt.Errorf("javascript failed: %w", err)
+	}
+	if goja.IsUndefined(result) || goja.IsNull(result) {
+		return "", nil
+	}
+	output, err := stringify(jsonObject, result)
+	if err != nil {
+		return "", fmt.Errorf("invalid javascript result: %w", err)
+	}
+	return output.String(), nil
+}
+
+// parseJavaScriptEvents decodes the events a handler returned. specversion defaults
+// to 1.0 and datacontenttype to application/json when there is data; every event must
+// then be a valid CloudEvent.
+func parseJavaScriptEvents(output string) ([]*ce.Event, error) {
+	if output == "" {
+		return nil, nil
+	}
+	var objects []map[string]interface{}
+	if strings.HasPrefix(output, "[") {
+		if err := json.Unmarshal([]byte(output), &objects); err != nil {
+			return nil, fmt.Errorf("invalid javascript result: handle must return events: %w", err)
+		}
+	} else {
+		var object map[string]interface{}
+		if err := json.Unmarshal([]byte(output), &object); err != nil {
+			return nil, fmt.Errorf("invalid javascript result: handle must return events: %w", err)
+		}
+		objects = append(objects, object)
+	}
+
+	events := make([]*ce.Event, 0, len(objects))
+	for i, object := range objects {
+		if object == nil {
+			return nil, fmt.Errorf("invalid event %d in javascript result: not an object", i)
+		}
+		if _, ok := object["specversion"]; !ok {
+			object["specversion"] = ce.CloudEventsVersionV1
+		}
+		if _, ok := object["datacontenttype"]; !ok && object["data"] != nil {
+			object["datacontenttype"] = ce.ApplicationJSON
+		}
+		data, err := json.Marshal(object)
+		if err != nil {
+			return nil, fmt.Errorf("invalid event %d in javascript result: %w", i, err)
+		}
+		e := ce.New()
+		if err := json.Unmarshal(data, &e); err != nil {
+			return nil, fmt.Errorf("invalid event %d in javascript result: %w", i, err)
+		}
+		if err := e.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid event %d in javascript result: %w", i, err)
+		}
+		events = append(events, &e)
+	}
+	return events, nil
+}
@@ -0,0 +1,149 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	mevent "github.com/julianshen/mycelium/internal/event"
+
+	ce "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+)
+
+// Lifecycle event types published when the registry changes
+const (
+	EventTypeFunctionDeployed = "function.deployed"
+	EventTypeFunctionDeleted  = "function.deleted"
+	// EventTypeVersionPromoted is published when a retained version becomes the one
+	// the registry serves again, e.g. by a rollback
+	EventTypeVersionPromoted = "version.promoted"
+)
+
+// Lifecycle notification defaults
+const (
+	DefaultLifecycleSubject        = "functions.lifecycle"
+	DefaultLifecycleWebhookTimeout = 10 * time.Second
+)
+
+// LifecycleChange describes a change to a function in the registry. It is carried as
+// data.after of lifecycle events.
+type LifecycleChange struct {
+	Function string `json:"function"`
+	Type     string `json:"type,omitempty"`
+	Version  string `json:"version,omitempty"`
+	// Previous is the version served before a promotion
+	Previous string `json:"previous,omitempty"`
+	Digest   string `json:"digest,omitempty"`
+	Actor    string `json:"actor,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// LifecycleConfig holds the configuration for lifecycle notifications
+type LifecycleConfig struct {
+	// Subject lifecycle events are published to (empty disables publishing)
+	Subject string
+	// Webhooks are the URLs every lifecycle event is posted to as a structured CloudEvent
+	Webhooks []string
+	// Timeout of each webhook request (default: DefaultLifecycleWebhookTimeout)
+	Timeout time.Duration
+}
+
+// LifecycleNotifier announces registry changes as CloudEvents on NATS and to HTTP
+// webhooks, so CI systems and chat notifications react to deployments without
+// polling the registry
+type LifecycleNotifier struct {
+	nc     *nats.Conn
+	config LifecycleConfig
+	client *http.Client
+}
+
+// NewLifecycleNotifier creates a lifecycle notifier
+func NewLifecycleNotifier(nc *nats.Conn, config LifecycleConfig) *LifecycleNotifier {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultLifecycleWebhookTimeout
+	}
+	return &LifecycleNotifier{
+		nc:     nc,
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+	}
+}
+
+// Notify publishes a lifecycle event and posts it to every webhook. Every webhook is
+// called even when others fail; the failures are returned joined.
+func (n *LifecycleNotifier) Notify(ctx context.Context, source, eventType string, change LifecycleChange) error {
+	event, err := NewLifecycleEvent(source, eventType, change)
+	if err != nil {
+		return err
+	}
+	body, err := event.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("failed to marshal lifecycle event: %w", err)
+	}
+
+	var errs []error
+	if n.config.Subject != "" {
+		if err := n.nc.Publish(n.config.Subject, body); err != nil {
+			errs = append(errs, fmt.Errorf("failed to publish lifecycle event: %w", err))
+		}
+	}
+	for _, url := range n.config.Webhooks {
+		if err := n.post(ctx, url, body); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// post posts a lifecycle event to a webhook
+func (n *LifecycleNotifier) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	// Drain the body so the connection goes back to the pool
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s returned %s", url, resp.Status)
+	}
+	return nil
+}
+
+// NewLifecycleEvent builds the CloudEvent announcing a registry change.
+// The change is carried as data.after like other Mycelium events.
+func NewLifecycleEvent(source, eventType string, change LifecycleChange) (*ce.Event, error) {
+	event := ce.NewEvent()
+	event.SetID(uuid.NewString())
+	event.SetSource(source)
+	event.SetSubject(change.Function)
+	event.SetType(eventType)
+	event.SetTime(time.Now())
+	if change.Actor != "" {
+		event.SetExtension(mevent.ExtActorType, "user")
+		event.SetExtension(mevent.ExtActorID, change.Actor)
+	} else {
+		event.SetExtension(mevent.ExtActorType, "system")
+		event.SetExtension(mevent.ExtActorID, "function-registry")
+	}
+
+	if err := event.SetData(ce.ApplicationJSON, map[string]interface{}{
+		"after": change,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to set lifecycle data: %w", err)
+	}
+	return &event, nil
+}
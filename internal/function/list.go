@@ -0,0 +1,200 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// DefaultListConcurrency is how many functions a listing fetches at once
+const DefaultListConcurrency = 16
+
+// FunctionListOptions selects the functions a listing returns
+type FunctionListOptions struct {
+	// After is a cursor: only functions whose name sorts after it are listed, e.g. the
+	// Next of the previous page
+	After string
+	// Limit bounds the number of functions listed (0 lists all)
+	Limit int
+	// Concurrency bounds the functions fetched at once (default: DefaultListConcurrency)
+	Concurrency int
+	// WithBinaries also fetches every function's binary. Listings read metadata only
+	// by default and never touch the object store.
+	WithBinaries bool
+}
+
+// FunctionPage is a page of functions ordered by name
+type FunctionPage struct {
+	Functions []FunctionMeta `json:"functions"`
+	// Next is the cursor of the following page, empty on the last page
+	Next string `json:"next,omitempty"`
+}
+
+// PagedRegistry is implemented by registries that list functions page by page
+type PagedRegistry interface {
+	// ListFunctionsPage returns a page of the functions' metadata ordered by name
+	ListFunctionsPage(ctx context.Context, opts FunctionListOptions) (FunctionPage, error)
+}
+
+// PageFunctions returns a page of already listed functions, for registries that do
+// not page themselves
+func PageFunctions(functions []FunctionMeta, opts FunctionListOptions) FunctionPage {
+	sorted := make([]FunctionMeta, 0, len(functions))
+	for _, meta := range functions {
+		if meta.Name > opts.After {
+			sorted = append(sorted, meta)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	page := FunctionPage{Functions: sorted}
+	if opts.Limit > 0 && len(sorted) > opts.Limit {
+		page.Functions = sorted[:opts.Limit]
+		page.Next = sorted[opts.Limit-1].Name
+	}
+	return page
+}
+
+// ForEachFunction calls fn for every function ordered by name until fn returns false.
+// Metadata, and binaries WithBinaries, are fetched concurrently ahead of fn, so large
+// registries are listed without a round trip per function. Functions deleted while
+// listing are skipped. Iteration stops with ctx's error when ctx is cancelled.
+func (r *NATSRegistry) ForEachFunction(ctx context.Context, opts FunctionListOptions, fn func(meta FunctionMeta, binary []byte) bool) error {
+	keys, _, err := r.listKeys(ctx, opts)
+	if err != nil {
+		return err
+	}
+	return r.fetchFunctions(ctx, keys, opts, fn)
+}
+
+// ListFunctionsPage returns a page of the functions' metadata ordered by name
+func (r *NATSRegistry) ListFunctionsPage(ctx context.Context, opts FunctionListOptions) (FunctionPage, error) {
+	keys, more, err := r.listKeys(ctx, opts)
+	if err != nil {
+		return FunctionPage{}, err
+	}
+
+	opts.WithBinaries = false
+	page := FunctionPage{Functions: make([]FunctionMeta, 0, len(keys))}
+	err = r.fetchFunctions(ctx, keys, opts, func(meta FunctionMeta, _ []byte) bool {
+		page.Functions = append(page.Functions, meta)
+		return true
+	})
+	if err != nil {
+		return FunctionPage{}, err
+	}
+	if more && len(keys) > 0 {
+		page.Next = keys[len(keys)-1]
+	}
+	return page, nil
+}
+
+// listKeys returns the sorted function names a listing covers and whether more follow
+func (r *NATSRegistry) listKeys(ctx context.Context, opts FunctionListOptions) ([]string, bool, error) {
+	lister, err := r.kv.ListKeys(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to list functions: %w", err)
+	}
+	defer lister.Stop()
+
+	var keys []string
+	for key := range lister.Keys() {
+		if key > opts.After {
+			keys = append(keys, key)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	sort.Strings(keys)
+
+	if opts.Limit > 0 && len(keys) > opts.Limit {
+		return keys[:opts.Limit], true, nil
+	}
+	return keys, false, nil
+}
+
+// listed is a fetched function of a listing
+type listed struct {
+	meta   FunctionMeta
+	binary []byte
+	err    error
+}
+
+// fetchFunctions fetches functions concurrently and calls fn with them in order. At
+// most opts.Concurrency functions are fetched or waiting for fn at a time.
+func (r *NATSRegistry) fetchFunctions(ctx context.Context, keys []string, opts FunctionListOptions, fn func(FunctionMeta, []byte) bool) error {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultListConcurrency
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]chan listed, len(keys))
+	for i := range results {
+		results[i] = make(chan listed, 1)
+	}
+	slots := make(chan struct{}, concurrency)
+	go func() {
+		for i, key := range keys {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go func(result chan<- listed, key string) {
+				result <- r.fetchListed(ctx, key, opts.WithBinaries)
+			}(results[i], key)
+		}
+	}()
+
+	for _, result := range results {
+		var fetched listed
+		select {
+		case fetched = <-result:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		<-slots
+
+		if errors.Is(fetched.err, jetstream.ErrKeyNotFound) {
+			continue
+		}
+		if fetched.err != nil {
+			return fetched.err
+		}
+		if !fn(fetched.meta, fetched.binary) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// fetchListed fetches the metadata of a listed function and, if asked, its binary
+func (r *NATSRegistry) fetchListed(ctx context.Context, key string, withBinary bool) listed {
+	entry, err := r.kv.Get(ctx, key)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return listed{err: err}
+	}
+	if err != nil {
+		return listed{err: fmt.Errorf("failed to get function %s: %w", key, err)}
+	}
+
+	meta, err := decodeMeta(entry.Value())
+	if err != nil {
+		return listed{err: fmt.Errorf("failed to unmarshal function %s: %w", key, err)}
+	}
+	if !withBinary {
+		return listed{meta: meta}
+	}
+	binary, err := r.getBinary(ctx, meta)
+	if err != nil {
+		return listed{err: fmt.Errorf("function %s: %w", key, err)}
+	}
+	return listed{meta: meta, binary: binary}
+}
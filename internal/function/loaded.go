@@ -0,0 +1,131 @@
+package function
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// FunctionsVerb is the $SRV verb answering with the functions loaded on runtime instances.
+// Like PING, INFO and STATS it is served on $SRV.FUNCTIONS.<service> by every instance
+// and on $SRV.FUNCTIONS.<service>.<id> by a single instance.
+const FunctionsVerb = "FUNCTIONS"
+
+// LoadedFunction describes a function cached by a runtime instance
+type LoadedFunction struct {
+	Name     string    `json:"name"`
+	Version  string    `json:"version"`
+	Type     string    `json:"type"`
+	Digest   string    `json:"digest"` // SHA-256 of the loaded binary, see BinaryDigest
+	LoadedAt time.Time `json:"loaded_at"`
+	// Pinned is set when the function is pinned to its version and ignores registry updates
+	Pinned bool `json:"pinned,omitempty"`
+	// ABIVersion and Features are negotiated with plugins, see ABIPlugin
+	ABIVersion int      `json:"abi_version,omitempty"`
+	Features   []string `json:"features,omitempty"`
+}
+
+// InstanceFunctions is the response of the FUNCTIONS endpoint
+type InstanceFunctions struct {
+	Service    string           `json:"service"`
+	InstanceID string           `json:"instance_id"`
+	Version    string           `json:"version"`
+	Functions  []LoadedFunction `json:"functions"`
+}
+
+// FunctionsSubject returns the FUNCTIONS subject of a service, or of one instance when id is set
+func FunctionsSubject(serviceName, id string) string {
+	if id == "" {
+		return fmt.Sprintf("%s.%s.%s", micro.APIPrefix, FunctionsVerb, serviceName)
+	}
+	return fmt.Sprintf("%s.%s.%s.%s", micro.APIPrefix, FunctionsVerb, serviceName, id)
+}
+
+// LoadedFunctions returns the functions currently loaded on this instance, sorted by name
+func (rs *RuntimeService) LoadedFunctions() []LoadedFunction {
+	rs.mu.RLock()
+	functions := make([]LoadedFunction, 0, len(rs.loaded))
+	for _, loaded := range rs.loaded {
+		functions = append(functions, loaded)
+	}
+	rs.mu.RUnlock()
+
+	sort.Slice(functions, func(i, j int) bool { return functions[i].Name < functions[j].Name })
+	return functions
+}
+
+// addFunctionsEndpoints registers the FUNCTIONS endpoints of the service.
+// The service-wide subject has no queue group so every instance answers.
+func (rs *RuntimeService) addFunctionsEndpoints() error {
+	info := rs.service.Info()
+	handler := micro.HandlerFunc(rs.handleLoadedFunctions)
+
+	if err := rs.service.AddEndpoint("functions", handler,
+		micro.WithEndpointSubject(FunctionsSubject(info.Name, "")),
+		micro.WithEndpointQueueGroupDisabled(),
+		micro.WithEndpointMetadata(map[string]string{
+			"description": "List the functions loaded on every runtime instance",
+			"format":      "application/json",
+		})); err != nil {
+		return err
+	}
+
+	return rs.service.AddEndpoint("functions-instance", handler,
+		micro.WithEndpointSubject(FunctionsSubject(info.Name, info.ID)),
+		micro.WithEndpointMetadata(map[string]string{
+			"description": "List the functions loaded on this runtime instance",
+			"format":      "application/json",
+		}))
+}
+
+// handleLoadedFunctions answers FUNCTIONS requests
+func (rs *RuntimeService) handleLoadedFunctions(req micro.Request) {
+	info := rs.service.Info()
+	req.RespondJSON(InstanceFunctions{
+		Service:    info.Name,
+		InstanceID: info.ID,
+		Version:    info.Version,
+		Functions:  rs.LoadedFunctions(),
+	})
+}
+
+// ListLoadedFunctions asks every instance of a runtime service for its loaded functions.
+// Responses are collected until the timeout passes, since the number of instances is unknown.
+func ListLoadedFunctions(nc *nats.Conn, serviceName string, timeout time.Duration) ([]InstanceFunctions, error) {
+	inbox := nc.NewRespInbox()
+	sub, err := nc.SubscribeSync(inbox)
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to replies: %w", err)
+	}
+	defer sub.Unsubscribe()
+
+	if err := nc.PublishRequest(FunctionsSubject(serviceName, ""), inbox, nil); err != nil {
+		return nil, fmt.Errorf("failed to request loaded functions: %w", err)
+	}
+
+	var instances []InstanceFunctions
+	deadline := time.Now().Add(timeout)
+	for {
+		msg, err := sub.NextMsg(time.Until(deadline))
+		if errors.Is(err, nats.ErrTimeout) {
+			break
+		}
+		if err != nil {
+			return instances, fmt.Errorf("failed to receive reply: %w", err)
+		}
+
+		var instance InstanceFunctions
+		if err := json.Unmarshal(msg.Data, &instance); err != nil {
+			return instances, fmt.Errorf("failed to unmarshal reply: %w", err)
+		}
+		instances = append(instances, instance)
+	}
+
+	sort.Slice(instances, func(i, j int) bool { return instances[i].InstanceID < instances[j].InstanceID })
+	return instances, nil
+}
@@ -0,0 +1,255 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	mevent "github.com/julianshen/mycelium/internal/event"
+	"github.com/julianshen/mycelium/internal/profiling"
+
+	ce "github.com/cloudevents/sdk-go/v2/event"
+	"github.com/google/uuid"
+)
+
+// ErrFunctionNotFound is returned when a local runtime's directory holds no function
+// of the requested name
+var ErrFunctionNotFound = errors.New("function not found")
+
+// LocalRuntime executes functions from a local directory in-process, bypassing NATS,
+// so functions and the triggers invoking them can be developed offline. It implements
+// the same InvokeFunction as Client.
+//
+// The directory holds functions in the FileRegistry layout (<name>.json metadata and
+// <name>.bin binary), or as bare sources: <name>.js runs as a JavaScript function and
+// <name>.py as a python3 script. Functions are reloaded when their files change, so
+// edits apply to the next invocation.
+type LocalRuntime struct {
+	dir string
+	// output receives the logs of plugin processes and JavaScript consoles (optional)
+	output func(functionName, stream string) io.Writer
+	// secret is the handshake secret plugins are started with (optional)
+	secret string
+	mu     sync.Mutex
+	loaded map[string]*localFunction
+}
+
+// localFunction is a function loaded from the runtime's directory
+type localFunction struct {
+	meta    FunctionMeta
+	plugin  Plugin
+	modTime time.Time
+}
+
+// localSource is the metadata and files of a function in the runtime's directory
+type localSource struct {
+	meta   FunctionMeta
+	files  []string
+	binary string
+}
+
+// NewLocalRuntime creates a runtime executing the functions in dir
+func NewLocalRuntime(dir string) (*LocalRuntime, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open function directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return &LocalRuntime{dir: dir, loaded: make(map[string]*localFunction)}, nil
+}
+
+// SetOutput captures the stdout and stderr of functions loaded afterwards
+func (r *LocalRuntime) SetOutput(output func(functionName, stream string) io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.output = output
+}
+
+// SetPluginSecret starts plugins loaded afterwards with a deployment's handshake secret,
+// see PluginHandshakeWithSecret
+func (r *LocalRuntime) SetPluginSecret(secret string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.secret = secret
+}
+
+// source finds a function in the directory; metadata takes precedence over bare sources
+func (r *LocalRuntime) source(name string) (*localSource, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return nil, fmt.Errorf("invalid function name %q", name)
+	}
+	base := filepath.Join(r.dir, name)
+
+	if data, err := os.ReadFile(base + ".json"); err == nil {
+		var meta FunctionMeta
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal metadata of %s: %w", name, err)
+		}
+		meta.Name = name
+		src := &localSource{meta: meta, files: []string{base + ".json"}}
+		// Builtin functions have no binary
+		if _, err := os.Stat(base + ".bin"); err == nil {
+			src.binary = base + ".bin"
+			src.files = append(src.files, src.binary)
+		}
+		return src, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read metadata of %s: %w", name, err)
+	}
+
+	if _, err := os.Stat(base + ".js"); err == nil {
+		return &localSource{
+			meta:   FunctionMeta{Name: name, Type: TypeJavaScript},
+			files:  []string{base + ".js"},
+			binary: base + ".js",
+		}, nil
+	}
+	if _, err := os.Stat(base + ".py"); err == nil {
+		return &localSource{
+			meta:   FunctionMeta{Name: name, Type: TypeScript, Config: map[string]string{ConfigRuntime: "python3"}},
+			files:  []string{base + ".py"},
+			binary: base + ".py",
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: %s in %s", ErrFunctionNotFound, name, r.dir)
+}
+
+// latestModTime returns the latest modification time of files
+func latestModTime(files []string) (time.Time, error) {
+	var latest time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to stat %s: %w", file, err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// load returns a function, reloading it when its files changed since it was loaded
+func (r *LocalRuntime) load(name string) (*localFunction, error) {
+	src, err := r.source(name)
+	if err != nil {
+		return nil, err
+	}
+	changed, err := latestModTime(src.files)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if fn, ok := r.loaded[name]; ok && fn.modTime.Equal(changed) {
+		return fn, nil
+	}
+
+	var binary []byte
+	if src.binary != "" {
+		if binary, err = os.ReadFile(src.binary); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", src.binary, err)
+		}
+	}
+	plugin, err := loadFunction(src.meta, binary, r.output, r.secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load function %s: %w", name, err)
+	}
+
+	// Invocations still running on the replaced version may fail once it is closed
+	if previous, ok := r.loaded[name]; ok {
+		closePlugin(previous.plugin)
+	}
+	fn := &localFunction{meta: src.meta, plugin: plugin, modTime: changed}
+	r.loaded[name] = fn
+	return fn, nil
+}
+
+// InvokeFunction executes a function with the given event and returns its output events.
+// Like Client.InvokeFunction, partial results yield the events with a *PartialResultError.
+func (r *LocalRuntime) InvokeFunction(ctx context.Context, name string, event *ce.Event) ([]*ce.Event, error) {
+	fn, err := r.load(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := mevent.ValidateEvent(event); err != nil {
+		return nil, fmt.Errorf("function error (invalid_event): %w", err)
+	}
+	if err := fn.meta.AcceptsEvent(event); err != nil {
+		return nil, fmt.Errorf("function error (event_rejected): %w", err)
+	}
+
+	var events []*ce.Event
+	pprof.Do(ctx, pprof.Labels(profiling.LabelFunction, name), func(ctx context.Context) {
+		events, err = fn.plugin.Function().Execute(ctx, event)
+	})
+	var partial partialResult
+	if err != nil && !errors.As(err, &partial) {
+		return nil, fmt.Errorf("function error (execution_error): %w", err)
+	}
+
+	invocationID := uuid.NewString()
+	for _, response := range events {
+		SetCorrelation(response, event, invocationID)
+		setLineage(response, event, name)
+	}
+	if partial != nil {
+		return events, &PartialResultError{Reason: partial.PartialReason()}
+	}
+	return events, nil
+}
+
+// ListFunctions returns the metadata of every function in the directory, sorted by name
+func (r *LocalRuntime) ListFunctions() ([]FunctionMeta, error) {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list functions: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	var functions []FunctionMeta
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		name := strings.TrimSuffix(entry.Name(), ext)
+		if entry.IsDir() || seen[name] || (ext != ".json" && ext != ".js" && ext != ".py") {
+			continue
+		}
+		seen[name] = true
+		src, err := r.source(name)
+		if err != nil {
+			return nil, err
+		}
+		functions = append(functions, src.meta)
+	}
+	sort.Slice(functions, func(i, j int) bool { return functions[i].Name < functions[j].Name })
+	return functions, nil
+}
+
+// Close unloads every function, stopping plugin processes and removing staged files
+func (r *LocalRuntime) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, fn := range r.loaded {
+		closePlugin(fn.plugin)
+		delete(r.loaded, name)
+	}
+}
+
+// closePlugin releases the resources of plugins that hold any
+func closePlugin(plugin Plugin) {
+	if closer, ok := plugin.(io.Closer); ok {
+		closer.Close()
+	}
+}
@@ -0,0 +1,234 @@
+package function
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// LogsVerb is the $SRV verb answering with the recent output of a function's plugin
+// processes. Every instance answers on $SRV.LOGS.<service>.
+const LogsVerb = "LOGS"
+
+// LogSubjectPrefix is the subject prefix captured output is published under as
+// <LogSubjectPrefix>.<function>, so logs can be followed live
+const LogSubjectPrefix = "function.logs"
+
+// DefaultLogBufferLines is how many recent output lines are kept per function
+const DefaultLogBufferLines = 500
+
+// maxLogLine bounds a captured line; longer output is split
+const maxLogLine = 64 * 1024
+
+// LogLine is a line a plugin process wrote to stdout or stderr
+type LogLine struct {
+	Time     time.Time `json:"time"`
+	Function string    `json:"function"`
+	// InvocationIDs are the invocations of the function in flight when the line was
+	// written; a single ID attributes the line to its invocation
+	InvocationIDs []string `json:"invocation_ids,omitempty"`
+	Stream        string   `json:"stream"` // stdout or stderr
+	Message       string   `json:"message"`
+	InstanceID    string   `json:"instance_id,omitempty"`
+}
+
+// LogsRequest asks for the recent output of a function
+type LogsRequest struct {
+	Function string `json:"function"`
+	Lines    int    `json:"lines,omitempty"` // Default: all buffered lines
+}
+
+// LogsSubject returns the LOGS subject of a service
+func LogsSubject(serviceName string) string {
+	return fmt.Sprintf("%s.%s.%s", micro.APIPrefix, LogsVerb, serviceName)
+}
+
+// FunctionLogSubject returns the subject a function's output is published to
+func FunctionLogSubject(name string) string {
+	return LogSubjectPrefix + "." + name
+}
+
+// functionLogs keeps the recent output lines of each function
+type functionLogs struct {
+	mu    sync.Mutex
+	max   int
+	lines map[string][]LogLine
+}
+
+func newFunctionLogs(max int) *functionLogs {
+	if max <= 0 {
+		max = DefaultLogBufferLines
+	}
+	return &functionLogs{max: max, lines: make(map[string][]LogLine)}
+}
+
+// add appends a line, dropping the oldest line of the function when the buffer is full
+func (l *functionLogs) add(line LogLine) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lines := append(l.lines[line.Function], line)
+	if len(lines) > l.max {
+		lines = lines[len(lines)-l.max:]
+	}
+	l.lines[line.Function] = lines
+}
+
+// recent returns up to n of the function's latest lines, oldest first (n <= 0 returns all)
+func (l *functionLogs) recent(name string, n int) []LogLine {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lines := l.lines[name]
+	if n > 0 && len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return append([]LogLine(nil), lines...)
+}
+
+// lineWriter splits written output into lines
+type lineWriter struct {
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	emit func(line string)
+}
+
+// Write emits every complete line and keeps the remainder for the next write
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			if w.buf.Len() >= maxLogLine {
+				w.emit(string(w.buf.Next(maxLogLine)))
+				continue
+			}
+			return len(p), nil
+		}
+		line := w.buf.Next(i + 1)
+		w.emit(strings.TrimRight(string(line), "\r\n"))
+	}
+}
+
+// pluginOutput returns the writer capturing one output stream of a function's plugin
+// process. Lines are logged with the function name and in-flight invocation IDs,
+// buffered for the LOGS endpoint and published to the function's log subject.
+func (rs *RuntimeService) pluginOutput(functionName, stream string) io.Writer {
+	return &lineWriter{emit: func(message string) {
+		line := LogLine{
+			Time:          time.Now(),
+			Function:      functionName,
+			InvocationIDs: rs.inFlight.invocationIDs(functionName),
+			Stream:        stream,
+			Message:       message,
+		}
+		if rs.service != nil {
+			line.InstanceID = rs.service.Info().ID
+		}
+
+		if rs.logger != nil {
+			fields := []Field{
+				{Key: "functionName", Value: functionName},
+				{Key: "stream", Value: stream},
+				{Key: "message", Value: message},
+			}
+			if len(line.InvocationIDs) == 1 {
+				fields = append(fields, Field{Key: "invocationID", Value: line.InvocationIDs[0]})
+			} else if len(line.InvocationIDs) > 1 {
+				fields = append(fields, Field{Key: "invocationIDs", Value: line.InvocationIDs})
+			}
+			rs.logger.Info("Function output", fields...)
+		}
+
+		if rs.logs != nil {
+			rs.logs.add(line)
+		}
+		if rs.natsConn != nil {
+			if data, err := json.Marshal(line); err == nil {
+				rs.natsConn.Publish(FunctionLogSubject(functionName), data)
+			}
+		}
+	}}
+}
+
+// addLogsEndpoint registers the LOGS endpoint of the service.
+// The subject has no queue group so every instance answers.
+func (rs *RuntimeService) addLogsEndpoint() error {
+	return rs.service.AddEndpoint("logs", micro.HandlerFunc(rs.handleLogs),
+		micro.WithEndpointSubject(LogsSubject(rs.service.Info().Name)),
+		micro.WithEndpointQueueGroupDisabled(),
+		micro.WithEndpointMetadata(map[string]string{
+			"description": "Return the recent plugin process output of a function",
+			"format":      "application/json",
+		}))
+}
+
+// handleLogs answers LOGS requests with this instance's buffered lines
+func (rs *RuntimeService) handleLogs(req micro.Request) {
+	var request LogsRequest
+	if err := json.Unmarshal(req.Data(), &request); err != nil || request.Function == "" {
+		req.Error("400", "request must name a function", nil)
+		return
+	}
+	req.RespondJSON(rs.logs.recent(request.Function, request.Lines))
+}
+
+// FetchFunctionLogs asks every instance of a runtime service for the recent output of a
+// function and merges the lines by time, keeping the latest lines (0 keeps all).
+// Responses are collected until the timeout passes, since the number of instances is unknown.
+func FetchFunctionLogs(nc *nats.Conn, serviceName, functionName string, lines int, timeout time.Duration) ([]LogLine, error) {
+	request, err := json.Marshal(LogsRequest{Function: functionName, Lines: lines})
+	if err != nil {
+		return nil, err
+	}
+
+	inbox := nc.NewRespInbox()
+	sub, err := nc.SubscribeSync(inbox)
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to replies: %w", err)
+	}
+	defer sub.Unsubscribe()
+
+	if err := nc.PublishRequest(LogsSubject(serviceName), inbox, request); err != nil {
+		return nil, fmt.Errorf("failed to request logs: %w", err)
+	}
+
+	var merged []LogLine
+	deadline := time.Now().Add(timeout)
+	for {
+		msg, err := sub.NextMsg(time.Until(deadline))
+		if errors.Is(err, nats.ErrTimeout) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to receive reply: %w", err)
+		}
+		if msg.Header.Get(micro.ErrorHeader) != "" {
+			return nil, fmt.Errorf("logs request failed: %s", msg.Header.Get(micro.ErrorHeader))
+		}
+
+		var instanceLines []LogLine
+		if err := json.Unmarshal(msg.Data, &instanceLines); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal reply: %w", err)
+		}
+		merged = append(merged, instanceLines...)
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Time.Before(merged[j].Time) })
+	if lines > 0 && len(merged) > lines {
+		merged = merged[len(merged)-lines:]
+	}
+	return merged, nil
+}
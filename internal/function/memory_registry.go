@@ -0,0 +1,208 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memorySnapshotVersion is the format version of MemoryRegistry snapshots
+const memorySnapshotVersion = 1
+
+// registryEntry represents a stored function
+type registryEntry struct {
+	meta   FunctionMeta
+	binary []byte
+	stored time.Time
+}
+
+// MemoryRegistry is an in-memory registry, safe for concurrent use. The zero value is
+// an empty registry that keeps functions until they are deleted; NewMemoryRegistry
+// creates one that evicts functions after a TTL, e.g. to cache another backend.
+type MemoryRegistry struct {
+	functions map[string]registryEntry
+	// ttl evicts functions this long after they were stored (zero keeps them)
+	ttl time.Duration
+	now func() time.Time
+	mu  sync.RWMutex
+}
+
+// MemoryRegistryConfig holds the configuration of a MemoryRegistry
+type MemoryRegistryConfig struct {
+	// TTL evicts functions this long after they were last stored (optional)
+	TTL time.Duration
+}
+
+// NewMemoryRegistry creates an empty in-memory registry
+func NewMemoryRegistry(cfg MemoryRegistryConfig) *MemoryRegistry {
+	return &MemoryRegistry{functions: make(map[string]registryEntry), ttl: cfg.TTL}
+}
+
+// expired reports whether an entry outlived the TTL
+func (r *MemoryRegistry) expired(entry registryEntry) bool {
+	if r.ttl <= 0 {
+		return false
+	}
+	return r.clock().Sub(entry.stored) >= r.ttl
+}
+
+// clock returns the current time
+func (r *MemoryRegistry) clock() time.Time {
+	if r.now != nil {
+		return r.now()
+	}
+	return time.Now()
+}
+
+// store adds a function; the caller holds the write lock
+func (r *MemoryRegistry) store(meta FunctionMeta, binary []byte) {
+	if r.functions == nil {
+		r.functions = make(map[string]registryEntry)
+	}
+	r.functions[meta.Name] = registryEntry{meta: meta, binary: binary, stored: r.clock()}
+}
+
+// StoreFunction stores a function's metadata and binary
+func (r *MemoryRegistry) StoreFunction(meta FunctionMeta, binary []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.store(meta, binary)
+	return nil
+}
+
+// GetFunction retrieves a function's metadata and binary
+func (r *MemoryRegistry) GetFunction(name string) (FunctionMeta, []byte, error) {
+	r.mu.RLock()
+	entry, exists := r.functions[name]
+	r.mu.RUnlock()
+	if !exists || r.expired(entry) {
+		return FunctionMeta{}, nil, fmt.Errorf("function %s not found", name)
+	}
+	return entry.meta, entry.binary, nil
+}
+
+// ListFunctions returns the stored functions, sorted by name
+func (r *MemoryRegistry) ListFunctions() ([]FunctionMeta, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	functions := make([]FunctionMeta, 0, len(r.functions))
+	for _, entry := range r.functions {
+		if !r.expired(entry) {
+			functions = append(functions, entry.meta)
+		}
+	}
+	sort.Slice(functions, func(i, j int) bool { return functions[i].Name < functions[j].Name })
+	return functions, nil
+}
+
+// DeleteFunction removes a function
+func (r *MemoryRegistry) DeleteFunction(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.functions, name)
+	return nil
+}
+
+// EvictExpired removes the functions that outlived the TTL and returns how many it
+// removed. Expired functions are never returned, so this only releases their memory.
+func (r *MemoryRegistry) EvictExpired() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	evicted := 0
+	for name, entry := range r.functions {
+		if r.expired(entry) {
+			delete(r.functions, name)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// memorySnapshot is the serialized form of a MemoryRegistry
+type memorySnapshot struct {
+	Version   int                   `json:"version"`
+	Functions []memorySnapshotEntry `json:"functions"`
+}
+
+type memorySnapshotEntry struct {
+	Meta   FunctionMeta `json:"meta"`
+	Binary []byte       `json:"binary"`
+	Stored time.Time    `json:"stored"`
+}
+
+// Snapshot writes the functions of the registry to w as JSON, for Restore
+func (r *MemoryRegistry) Snapshot(w io.Writer) error {
+	r.mu.RLock()
+	snapshot := memorySnapshot{Version: memorySnapshotVersion, Functions: make([]memorySnapshotEntry, 0, len(r.functions))}
+	for _, entry := range r.functions {
+		if !r.expired(entry) {
+			snapshot.Functions = append(snapshot.Functions, memorySnapshotEntry{Meta: entry.meta, Binary: entry.binary, Stored: entry.stored})
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(snapshot.Functions, func(i, j int) bool { return snapshot.Functions[i].Meta.Name < snapshot.Functions[j].Meta.Name })
+	if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return nil
+}
+
+// Restore replaces the functions of the registry with a snapshot written by Snapshot.
+// Functions keep the time they were stored, so the TTL of restored functions carries on.
+func (r *MemoryRegistry) Restore(reader io.Reader) error {
+	var snapshot memorySnapshot
+	if err := json.NewDecoder(reader).Decode(&snapshot); err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	if snapshot.Version != memorySnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", snapshot.Version)
+	}
+
+	functions := make(map[string]registryEntry, len(snapshot.Functions))
+	for _, entry := range snapshot.Functions {
+		functions[entry.Meta.Name] = registryEntry{meta: entry.Meta, binary: entry.Binary, stored: entry.Stored}
+	}
+	r.mu.Lock()
+	r.functions = functions
+	r.mu.Unlock()
+	return nil
+}
+
+// SaveSnapshot writes a snapshot of the registry to a file, replacing it atomically
+func (r *MemoryRegistry) SaveSnapshot(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".snapshot-*")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if err := r.Snapshot(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	return nil
+}
+
+// LoadSnapshot restores the registry from a file written by SaveSnapshot
+func (r *MemoryRegistry) LoadSnapshot(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer f.Close()
+	return r.Restore(f)
+}
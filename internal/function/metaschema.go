@@ -0,0 +1,124 @@
+package function
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// MetaSchemaVersion is the version of the FunctionMeta serialization format this release
+// writes. Metadata without a schemaVersion predates versioning and is version 1.
+//
+// Changing the meaning or shape of stored fields requires bumping the version and adding
+// a migration from the previous version to metaMigrations. Adding an optional field does
+// not: releases that do not know it keep it when they rewrite the metadata.
+const MetaSchemaVersion = 2
+
+// ErrUnsupportedMetaVersion is returned when writing metadata that was read in a newer
+// schema version than this release writes, which would lose what changed since
+var ErrUnsupportedMetaVersion = errors.New("unsupported function metadata schema version")
+
+// metaMigrations upgrade stored metadata, as its JSON fields, from the version of their
+// key to the next one
+var metaMigrations = map[int]func(fields map[string]json.RawMessage) error{
+	// Version 2 only introduced the schemaVersion field itself
+	1: func(fields map[string]json.RawMessage) error { return nil },
+}
+
+// metaFields are the JSON fields FunctionMeta declares; other stored fields are kept
+// in FunctionMeta.unknown
+var metaFields = func() map[string]bool {
+	fields := make(map[string]bool)
+	t := reflect.TypeOf(FunctionMeta{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}()
+
+// UnknownFields returns the stored fields the metadata was read with that this release
+// does not know
+func (m FunctionMeta) UnknownFields() map[string]json.RawMessage {
+	return m.unknown
+}
+
+// WithUnknownFields returns the metadata carrying stored fields this release does not
+// know, which are written back unchanged, see UnknownFields
+func (m FunctionMeta) WithUnknownFields(fields map[string]json.RawMessage) FunctionMeta {
+	m.unknown = fields
+	return m
+}
+
+// decodeMeta reads stored metadata, upgrading it from older schema versions. The
+// returned SchemaVersion is the version the metadata was stored in. Metadata of newer
+// versions is read as far as this release understands it.
+func decodeMeta(data []byte) (FunctionMeta, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return FunctionMeta{}, err
+	}
+
+	stored := 1
+	if raw, ok := fields["schemaVersion"]; ok {
+		if err := json.Unmarshal(raw, &stored); err != nil || stored < 1 {
+			return FunctionMeta{}, fmt.Errorf("invalid schemaVersion %s", raw)
+		}
+	}
+	for version := stored; version < MetaSchemaVersion; version++ {
+		migrate, ok := metaMigrations[version]
+		if !ok {
+			return FunctionMeta{}, fmt.Errorf("no migration from schema version %d", version)
+		}
+		if err := migrate(fields); err != nil {
+			return FunctionMeta{}, fmt.Errorf("failed to migrate from schema version %d: %w", version, err)
+		}
+	}
+
+	migrated, err := json.Marshal(fields)
+	if err != nil {
+		return FunctionMeta{}, err
+	}
+	var meta FunctionMeta
+	if err := json.Unmarshal(migrated, &meta); err != nil {
+		return FunctionMeta{}, err
+	}
+	meta.SchemaVersion = stored
+	for name, raw := range fields {
+		if !metaFields[name] {
+			if meta.unknown == nil {
+				meta.unknown = make(map[string]json.RawMessage)
+			}
+			meta.unknown[name] = raw
+		}
+	}
+	return meta, nil
+}
+
+// encodeMeta serializes metadata in the current schema version, with the fields this
+// release does not know that it was read with. Metadata read in a newer version is
+// rejected with ErrUnsupportedMetaVersion rather than written back incompletely.
+func encodeMeta(meta FunctionMeta) ([]byte, error) {
+	if meta.SchemaVersion > MetaSchemaVersion {
+		return nil, fmt.Errorf("%w: %s was stored in version %d, this release writes %d",
+			ErrUnsupportedMetaVersion, meta.Name, meta.SchemaVersion, MetaSchemaVersion)
+	}
+	meta.SchemaVersion = MetaSchemaVersion
+	data, err := json.Marshal(meta)
+	if err != nil || len(meta.unknown) == 0 {
+		return data, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for name, raw := range meta.unknown {
+		fields[name] = raw
+	}
+	return json.Marshal(fields)
+}
@@ -0,0 +1,157 @@
+package function
+
+import (
+	"encoding/json"
+	"math/rand"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/event"
+
+	ce "github.com/cloudevents/sdk-go/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// DefaultMirrorSubject is the subject prefix mirrored invocations are published under
+const DefaultMirrorSubject = "function.mirror"
+
+// MirrorConfig configures publishing sanitized copies of invocations to a debug subject
+type MirrorConfig struct {
+	// Subject is the subject prefix; invocations of a function are published to
+	// <Subject>.<function name>. Empty disables mirroring.
+	Subject string
+	// SampleRate is the fraction of invocations mirrored, between 0 and 1 (default: 1)
+	SampleRate float64
+	// Functions limits mirroring to these functions; empty mirrors every function
+	Functions []string
+	// Redaction strips sensitive fields from mirrored request and response events
+	Redaction *event.RedactionPolicy
+}
+
+// MirroredInvocation is the copy of an invocation published to the mirror subject
+type MirroredInvocation struct {
+	FunctionName string      `json:"functionName"`
+	Received     time.Time   `json:"received"`
+	DurationMs   int64       `json:"durationMs"`
+	Request      *ce.Event   `json:"request,omitempty"`
+	Response     []*ce.Event `json:"response,omitempty"`
+	ErrorType    string      `json:"errorType,omitempty"`
+	Error        string      `json:"error,omitempty"`
+}
+
+// MirrorSubject returns the subject the invocations of a function are mirrored to
+func MirrorSubject(prefix, functionName string) string {
+	return prefix + "." + functionName
+}
+
+// mirror decides which invocations are mirrored and publishes them
+type mirror struct {
+	nc        *nats.Conn
+	cfg       MirrorConfig
+	functions map[string]bool
+	logger    Logger
+}
+
+// newMirror creates the invocation mirror, nil when mirroring is disabled
+func newMirror(nc *nats.Conn, cfg MirrorConfig, logger Logger) *mirror {
+	if cfg.Subject == "" {
+		return nil
+	}
+	if cfg.SampleRate <= 0 || cfg.SampleRate > 1 {
+		cfg.SampleRate = 1
+	}
+
+	m := &mirror{nc: nc, cfg: cfg, logger: logger}
+	if len(cfg.Functions) > 0 {
+		m.functions = make(map[string]bool, len(cfg.Functions))
+		for _, name := range cfg.Functions {
+			m.functions[name] = true
+		}
+	}
+	return m
+}
+
+// wrap returns a request that mirrors its response when the invocation is sampled,
+// or the request itself otherwise
+func (m *mirror) wrap(req micro.Request, functionName string, request *ce.Event) micro.Request {
+	if m == nil || (m.functions != nil && !m.functions[functionName]) {
+		return req
+	}
+	if m.cfg.SampleRate < 1 && rand.Float64() >= m.cfg.SampleRate {
+		return req
+	}
+	return &mirroredRequest{
+		Request:      req,
+		mirror:       m,
+		functionName: functionName,
+		request:      request,
+		received:     time.Now(),
+	}
+}
+
+// publish sends a sanitized copy of an invocation and its response
+func (m *mirror) publish(functionName string, request *ce.Event, received time.Time, response []byte) {
+	record := MirroredInvocation{
+		FunctionName: functionName,
+		Received:     received,
+		DurationMs:   time.Since(received).Milliseconds(),
+		Request:      m.cfg.Redaction.Redact(request),
+	}
+
+	var decoded struct {
+		Events    []*ce.Event `json:"events"`
+		Error     string      `json:"error"`
+		ErrorType string      `json:"errorType"`
+	}
+	if err := json.Unmarshal(response, &decoded); err == nil {
+		record.Error = decoded.Error
+		record.ErrorType = decoded.ErrorType
+		for _, e := range decoded.Events {
+			record.Response = append(record.Response, m.cfg.Redaction.Redact(e))
+		}
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		m.logger.Error("Failed to marshal mirrored invocation", Field{Key: "error", Value: err})
+		return
+	}
+	if err := m.nc.Publish(MirrorSubject(m.cfg.Subject, functionName), data); err != nil {
+		m.logger.Error("Failed to publish mirrored invocation",
+			Field{Key: "functionName", Value: functionName},
+			Field{Key: "error", Value: err})
+	}
+}
+
+// mirroredRequest publishes a copy of the invocation when it is answered
+type mirroredRequest struct {
+	micro.Request
+	mirror       *mirror
+	functionName string
+	request      *ce.Event
+	received     time.Time
+}
+
+// Respond sends the response and mirrors it
+func (r *mirroredRequest) Respond(data []byte, opts ...micro.RespondOpt) error {
+	err := r.Request.Respond(data, opts...)
+	r.mirror.publish(r.functionName, r.request, r.received, data)
+	return err
+}
+
+// RespondJSON sends the JSON response and mirrors it
+func (r *mirroredRequest) RespondJSON(data any, opts ...micro.RespondOpt) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return micro.ErrMarshalResponse
+	}
+	return r.Respond(encoded, opts...)
+}
+
+// Error sends the error response and mirrors it
+func (r *mirroredRequest) Error(code, description string, data []byte, opts ...micro.RespondOpt) error {
+	err := r.Request.Error(code, description, data, opts...)
+	response, _ := json.Marshal(map[string]string{"error": description, "errorType": code})
+	r.mirror.publish(r.functionName, r.request, r.received, response)
+	return err
+}
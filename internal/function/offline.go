@@ -0,0 +1,298 @@
+package function
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// ErrQueuedOffline is returned when NATS is unreachable and a request was stored for later delivery
+	ErrQueuedOffline = errors.New("NATS unreachable, request queued for delivery")
+	// ErrOfflineBufferFull is returned when the offline buffer has reached its capacity
+	ErrOfflineBufferFull = errors.New("offline buffer is full")
+	// errNotDelivered is returned by flush senders when NATS was reachable but the message
+	// was not delivered, e.g. no runtime answered; the attempt counts against MaxAttempts
+	errNotDelivered = errors.New("message not delivered")
+)
+
+// Default offline buffer limits
+const (
+	DefaultOfflineMaxEntries    = 10000
+	DefaultOfflineMaxAge        = 24 * time.Hour
+	DefaultOfflineMaxAttempts   = 10
+	DefaultOfflineRetryInterval = 5 * time.Second
+)
+
+var offlineBucket = []byte("pending")
+
+// OfflineBufferConfig configures store-and-forward delivery for the client
+type OfflineBufferConfig struct {
+	Path       string        // Path of the bolt database file
+	MaxEntries int           // Maximum number of buffered messages (default: DefaultOfflineMaxEntries)
+	MaxAge     time.Duration // Buffered messages older than this are discarded (default: DefaultOfflineMaxAge)
+	// MaxAttempts discards an invocation no runtime answered after this many flushes
+	// (default: DefaultOfflineMaxAttempts)
+	MaxAttempts int
+	// RetryInterval is how long a flush that found no runtime waits before it retries
+	// (default: DefaultOfflineRetryInterval)
+	RetryInterval time.Duration
+}
+
+// offlineMessage is a message waiting to be delivered once NATS is reachable
+type offlineMessage struct {
+	Subject  string    `json:"subject"`
+	Data     []byte    `json:"data"`
+	Request  bool      `json:"request"`
+	QueuedAt time.Time `json:"queuedAt"`
+	// Attempts counts the flushes that reached NATS but not a runtime
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// offlineBuffer persists messages in a local bolt database and replays them in order
+type offlineBuffer struct {
+	db            *bolt.DB
+	maxEntries    int
+	maxAge        time.Duration
+	maxAttempts   int
+	retryInterval time.Duration
+	flushMu       sync.Mutex
+	// mu orders enqueueBehind against the end of a flush; flushing is set while one runs
+	mu       sync.Mutex
+	flushing bool
+}
+
+// newOfflineBuffer opens (or creates) the offline buffer database
+func newOfflineBuffer(cfg OfflineBufferConfig) (*offlineBuffer, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("offline buffer path cannot be empty")
+	}
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = DefaultOfflineMaxEntries
+	}
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = DefaultOfflineMaxAge
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultOfflineMaxAttempts
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = DefaultOfflineRetryInterval
+	}
+
+	db, err := bolt.Open(cfg.Path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open offline buffer: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(offlineBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create offline buffer bucket: %w", err)
+	}
+
+	return &offlineBuffer{
+		db:            db,
+		maxEntries:    cfg.MaxEntries,
+		maxAge:        cfg.MaxAge,
+		maxAttempts:   cfg.MaxAttempts,
+		retryInterval: cfg.RetryInterval,
+	}, nil
+}
+
+// enqueue appends a message to the buffer
+func (b *offlineBuffer) enqueue(msg offlineMessage) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.put(msg)
+}
+
+// enqueueBehind appends a message while older messages are buffered or being flushed,
+// so it is not delivered ahead of them. queued is false once the buffer is drained and
+// the message may be sent directly; idle reports that no flush delivers it yet.
+func (b *offlineBuffer) enqueueBehind(msg offlineMessage) (queued, idle bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.flushing && b.empty() {
+		return false, false, nil
+	}
+	return true, !b.flushing, b.put(msg)
+}
+
+// empty reports whether no messages are buffered
+func (b *offlineBuffer) empty() bool {
+	empty := true
+	b.db.View(func(tx *bolt.Tx) error {
+		k, _ := tx.Bucket(offlineBucket).Cursor().First()
+		empty = k == nil
+		return nil
+	})
+	return empty
+}
+
+// put appends a message to the buffer, b.mu must be held
+func (b *offlineBuffer) put(msg offlineMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal offline message: %w", err)
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(offlineBucket)
+		b.expire(bucket, time.Now())
+
+		if countKeys(bucket) >= b.maxEntries {
+			return ErrOfflineBufferFull
+		}
+
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return fmt.Errorf("failed to allocate offline sequence: %w", err)
+		}
+		return bucket.Put(sequenceKey(seq), data)
+	})
+}
+
+// expire removes messages older than the maximum age.
+// Keys are ordered by sequence, so the scan stops at the first fresh message.
+func (b *offlineBuffer) expire(bucket *bolt.Bucket, now time.Time) {
+	var stale [][]byte
+	c := bucket.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var msg offlineMessage
+		if err := json.Unmarshal(v, &msg); err == nil && now.Sub(msg.QueuedAt) <= b.maxAge {
+			break
+		}
+		stale = append(stale, k)
+	}
+	for _, k := range stale {
+		bucket.Delete(k)
+	}
+}
+
+// flush delivers buffered messages in order until send fails.
+// Delivered and expired messages are removed; the rest stay queued. A message send
+// fails with errNotDelivered counts an attempt and is discarded after maxAttempts.
+func (b *offlineBuffer) flush(send func(offlineMessage) error) (int, error) {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	b.setFlushing(true)
+	defer b.setFlushing(false)
+
+	delivered := 0
+	for {
+		var key []byte
+		var msg offlineMessage
+
+		// The flush ends with the buffer drained, atomically with enqueueBehind
+		b.mu.Lock()
+		err := b.db.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket(offlineBucket)
+			b.expire(bucket, time.Now())
+
+			k, v := bucket.Cursor().First()
+			if k == nil {
+				return nil
+			}
+			key = append([]byte(nil), k...)
+			return json.Unmarshal(v, &msg)
+		})
+		if err == nil && key == nil {
+			b.flushing = false
+		}
+		b.mu.Unlock()
+		if err != nil {
+			return delivered, fmt.Errorf("failed to read offline buffer: %w", err)
+		}
+		if key == nil {
+			return delivered, nil
+		}
+
+		sendErr := send(msg)
+		if sendErr != nil && !errors.Is(sendErr, errNotDelivered) {
+			return delivered, sendErr
+		}
+		if sendErr != nil {
+			msg.Attempts++
+			if msg.Attempts < b.maxAttempts {
+				if err := b.saveAttempts(key, msg); err != nil {
+					return delivered, err
+				}
+				return delivered, sendErr
+			}
+		}
+
+		// Delivered messages, and those out of attempts, leave the buffer
+		err = b.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(offlineBucket).Delete(key)
+		})
+		if err != nil {
+			return delivered, fmt.Errorf("failed to remove delivered message: %w", err)
+		}
+		if sendErr == nil {
+			delivered++
+		}
+	}
+}
+
+// saveAttempts records the failed attempts of a buffered message
+func (b *offlineBuffer) saveAttempts(key []byte, msg offlineMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal offline message: %w", err)
+	}
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(offlineBucket).Put(key, data)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record delivery attempt: %w", err)
+	}
+	return nil
+}
+
+// setFlushing records whether a flush is running
+func (b *offlineBuffer) setFlushing(flushing bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flushing = flushing
+}
+
+// len returns the number of buffered messages
+func (b *offlineBuffer) len() int {
+	n := 0
+	b.db.View(func(tx *bolt.Tx) error {
+		n = countKeys(tx.Bucket(offlineBucket))
+		return nil
+	})
+	return n
+}
+
+// close closes the underlying database
+func (b *offlineBuffer) close() error {
+	return b.db.Close()
+}
+
+// countKeys counts the keys in a bucket, including writes pending in the current transaction
+func countKeys(bucket *bolt.Bucket) int {
+	n := 0
+	c := bucket.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		n++
+	}
+	return n
+}
+
+// sequenceKey encodes a sequence number so keys sort in insertion order
+func sequenceKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
@@ -0,0 +1,374 @@
+package function
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// PinVerb is the $SRV verb pinning functions to a version on runtime instances.
+// Every instance answers on $SRV.PIN.<service>, a single one on $SRV.PIN.<service>.<id>.
+const PinVerb = "PIN"
+
+// pluginRetireTimeout bounds how long a replaced plugin waits for its in-flight
+// invocations before it is closed
+const pluginRetireTimeout = time.Minute
+
+// PinRequest pins a function to a version, or unpins it when Version is empty
+type PinRequest struct {
+	Function string `json:"function"`
+	Version  string `json:"version,omitempty"`
+	Actor    string `json:"actor,omitempty"`
+}
+
+// PinResult is an instance's answer to a PinRequest
+type PinResult struct {
+	Service    string `json:"service"`
+	InstanceID string `json:"instance_id"`
+	Function   string `json:"function"`
+	// Version and Digest are what the instance serves after the request
+	Version string `json:"version,omitempty"`
+	Digest  string `json:"digest,omitempty"`
+	Pinned  bool   `json:"pinned"`
+	Error   string `json:"error,omitempty"`
+}
+
+// PinSubject returns the PIN subject of a service, or of one instance when id is set
+func PinSubject(serviceName, id string) string {
+	if id == "" {
+		return fmt.Sprintf("%s.%s.%s", micro.APIPrefix, PinVerb, serviceName)
+	}
+	return fmt.Sprintf("%s.%s.%s.%s", micro.APIPrefix, PinVerb, serviceName, id)
+}
+
+// fetchFunction retrieves the version of a function the instance should serve: the
+// version a name@version reference asks for, the pinned version, or the registry's
+// current one
+func (rs *RuntimeService) fetchFunction(ref string) (FunctionMeta, []byte, error) {
+	name, version := ParseFunctionRef(ref)
+	pinned := version == ""
+	if pinned {
+		rs.mu.RLock()
+		version = rs.pins[name]
+		rs.mu.RUnlock()
+	}
+
+	if version == "" {
+		meta, binary, err := rs.registry.GetFunction(name)
+		if err != nil {
+			return FunctionMeta{}, nil, fmt.Errorf("failed to get function from registry: %w", err)
+		}
+		return meta, binary, nil
+	}
+
+	versioned, ok := rs.registry.(VersionedRegistry)
+	if !ok && !pinned {
+		// Registries without versions still serve the version they hold
+		meta, binary, err := rs.registry.GetFunction(name)
+		if err != nil {
+			return FunctionMeta{}, nil, fmt.Errorf("failed to get function from registry: %w", err)
+		}
+		if meta.Version != version {
+			return FunctionMeta{}, nil, fmt.Errorf("failed to get function from registry: %w: %s", ErrVersionNotFound, ref)
+		}
+		return meta, binary, nil
+	}
+	if !ok {
+		return FunctionMeta{}, nil, fmt.Errorf("registry does not keep function versions to pin %s@%s", name, version)
+	}
+	meta, binary, err := versioned.GetFunctionVersion(name, version)
+	if err != nil {
+		return FunctionMeta{}, nil, fmt.Errorf("failed to get function from registry: %w", err)
+	}
+	return meta, binary, nil
+}
+
+// loadLock serializes the loads of a function reference; refs counts its holder and
+// waiters, so it is dropped once no load needs it
+type loadLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lockLoad serializes loading a function reference, so concurrent cold invocations
+// load it once and reloads do not race them, and returns the unlock function
+func (rs *RuntimeService) lockLoad(ref string) func() {
+	rs.loadMu.Lock()
+	if rs.loadLocks == nil {
+		rs.loadLocks = make(map[string]*loadLock)
+	}
+	lock, ok := rs.loadLocks[ref]
+	if !ok {
+		lock = &loadLock{}
+		rs.loadLocks[ref] = lock
+	}
+	lock.refs++
+	rs.loadMu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+		rs.loadMu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(rs.loadLocks, ref)
+		}
+		rs.loadMu.Unlock()
+	}
+}
+
+// install makes a loaded plugin serve a function reference and returns the plugin it
+// replaces
+func (rs *RuntimeService) install(ref string, meta FunctionMeta, binary []byte, plugin Plugin) Plugin {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	old := rs.plugins[ref]
+	rs.plugins[ref] = plugin
+	if rs.metas == nil {
+		rs.metas = make(map[string]FunctionMeta)
+	}
+	rs.metas[ref] = meta
+	if rs.loaded == nil {
+		rs.loaded = make(map[string]LoadedFunction)
+	}
+	loaded := LoadedFunction{
+		Name:     meta.Name,
+		Version:  meta.Version,
+		Type:     meta.Type,
+		Digest:   BinaryDigest(binary),
+		LoadedAt: time.Now(),
+		Pinned:   rs.pins[ref] != "",
+	}
+	if abi, ok := plugin.(ABIPlugin); ok {
+		loaded.ABIVersion = abi.ABIVersion()
+		loaded.Features = abi.Features()
+	}
+	rs.loaded[ref] = loaded
+	return old
+}
+
+// reloadFunction replaces a function with the version the instance should serve now.
+// The replaced plugin is closed once the function has no invocations in flight.
+func (rs *RuntimeService) reloadFunction(name string) error {
+	unlock := rs.lockLoad(name)
+	defer unlock()
+
+	meta, binary, err := rs.fetchFunction(name)
+	if err != nil {
+		return err
+	}
+	resources, err := meta.Resources()
+	if err != nil {
+		return err
+	}
+
+	// Swap the reservation, restoring the one of the current version on failure
+	current := rs.getFunctionMeta(name)
+	currentResources, _ := current.Resources()
+	rs.reservations.release(name)
+	restore := func() {
+		if current.Name != "" {
+			rs.reservations.reserve(name, currentResources)
+		}
+	}
+	if err := rs.reservations.reserve(name, resources); err != nil {
+		restore()
+		return err
+	}
+
+	plugin, err := rs.loadPlugin(meta, binary)
+	if err != nil {
+		rs.reservations.release(name)
+		restore()
+		return fmt.Errorf("failed to load plugin: %w", err)
+	}
+
+	if old := rs.install(name, meta, binary, plugin); old != nil {
+		go rs.retirePlugin(name, old)
+	}
+	return nil
+}
+
+// retirePlugin closes a replaced plugin once the function's invocations finished, or
+// after pluginRetireTimeout
+func (rs *RuntimeService) retirePlugin(name string, plugin Plugin) {
+	closer, ok := plugin.(io.Closer)
+	if !ok {
+		return
+	}
+	deadline := time.Now().Add(pluginRetireTimeout)
+	for len(rs.inFlight.invocationIDs(name)) > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err := closer.Close(); err != nil && rs.logger != nil {
+		rs.logger.Error("Failed to close replaced plugin",
+			Field{Key: "functionName", Value: name},
+			Field{Key: "error", Value: err})
+	}
+}
+
+// Pin pins a function to a version on this instance and loads it, or unpins it when
+// version is empty and reloads the registry's current version. A pinned function keeps
+// serving its version when the registry changes.
+func (rs *RuntimeService) Pin(name, version string) (LoadedFunction, error) {
+	rs.mu.Lock()
+	if rs.pins == nil {
+		rs.pins = make(map[string]string)
+	}
+	previous := rs.pins[name]
+	if version == "" {
+		delete(rs.pins, name)
+	} else {
+		rs.pins[name] = version
+	}
+	_, loaded := rs.plugins[name]
+	rs.mu.Unlock()
+
+	// Pinning loads the version right away so a missing version is reported; unpinning
+	// only reloads functions that are loaded
+	if version != "" || loaded {
+		if err := rs.reloadFunction(name); err != nil {
+			rs.mu.Lock()
+			if previous == "" {
+				delete(rs.pins, name)
+			} else {
+				rs.pins[name] = previous
+			}
+			rs.mu.Unlock()
+			return LoadedFunction{}, err
+		}
+	}
+
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return rs.loaded[name], nil
+}
+
+// addPinEndpoints registers the PIN endpoints of the service.
+// The service-wide subject has no queue group so every instance answers.
+func (rs *RuntimeService) addPinEndpoints() error {
+	info := rs.service.Info()
+	handler := micro.HandlerFunc(rs.handlePin)
+
+	if err := rs.service.AddEndpoint("pin", handler,
+		micro.WithEndpointSubject(PinSubject(info.Name, "")),
+		micro.WithEndpointQueueGroupDisabled(),
+		micro.WithEndpointMetadata(map[string]string{
+			"description": "Pin a function to a version on every runtime instance",
+			"format":      "application/json",
+		})); err != nil {
+		return err
+	}
+
+	return rs.service.AddEndpoint("pin-instance", handler,
+		micro.WithEndpointSubject(PinSubject(info.Name, info.ID)),
+		micro.WithEndpointMetadata(map[string]string{
+			"description": "Pin a function to a version on this runtime instance",
+			"format":      "application/json",
+		}))
+}
+
+// handlePin answers PIN requests
+func (rs *RuntimeService) handlePin(req micro.Request) {
+	var request PinRequest
+	if err := json.Unmarshal(req.Data(), &request); err != nil || request.Function == "" {
+		req.Error("400", "request must name a function", nil)
+		return
+	}
+
+	info := rs.service.Info()
+	result := PinResult{Service: info.Name, InstanceID: info.ID, Function: request.Function}
+	loaded, err := rs.Pin(request.Function, request.Version)
+	if err != nil {
+		result.Error = err.Error()
+		rs.logger.Error("Failed to pin function",
+			Field{Key: "functionName", Value: request.Function},
+			Field{Key: "version", Value: request.Version},
+			Field{Key: "error", Value: err})
+	} else {
+		result.Version = loaded.Version
+		result.Digest = loaded.Digest
+		result.Pinned = loaded.Pinned
+		message := "Function pinned"
+		if request.Version == "" {
+			message = "Function unpinned"
+		}
+		rs.logger.Info(message,
+			Field{Key: "functionName", Value: request.Function},
+			Field{Key: "version", Value: loaded.Version},
+			Field{Key: "actor", Value: request.Actor})
+	}
+	req.RespondJSON(result)
+}
+
+// PinFunction pins a function to a version on one runtime instance, or on every
+// instance of the service when instanceID is empty, and returns their answers. An
+// empty version unpins the function. Instances that fail report it in PinResult.Error.
+// Fleet responses are collected until the timeout passes, since the number of
+// instances is unknown.
+func PinFunction(nc *nats.Conn, serviceName, instanceID string, request PinRequest, timeout time.Duration) ([]PinResult, error) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if instanceID != "" {
+		msg, err := nc.Request(PinSubject(serviceName, instanceID), data, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("failed to pin function: %w", err)
+		}
+		result, err := decodePinResult(msg)
+		if err != nil {
+			return nil, err
+		}
+		return []PinResult{result}, nil
+	}
+
+	inbox := nc.NewRespInbox()
+	sub, err := nc.SubscribeSync(inbox)
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to replies: %w", err)
+	}
+	defer sub.Unsubscribe()
+
+	if err := nc.PublishRequest(PinSubject(serviceName, ""), inbox, data); err != nil {
+		return nil, fmt.Errorf("failed to pin function: %w", err)
+	}
+
+	var results []PinResult
+	deadline := time.Now().Add(timeout)
+	for {
+		msg, err := sub.NextMsg(time.Until(deadline))
+		if errors.Is(err, nats.ErrTimeout) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to receive reply: %w", err)
+		}
+		result, err := decodePinResult(msg)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// decodePinResult reads an instance's answer to a PinRequest
+func decodePinResult(msg *nats.Msg) (PinResult, error) {
+	var result PinResult
+	if msg.Header.Get(micro.ErrorHeader) != "" {
+		return result, fmt.Errorf("pin request failed: %s", msg.Header.Get(micro.ErrorHeader))
+	}
+	if err := json.Unmarshal(msg.Data, &result); err != nil {
+		return result, fmt.Errorf("failed to unmarshal reply: %w", err)
+	}
+	return result, nil
+}
@@ -2,11 +2,10 @@ package function
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"net/rpc"
-	"os"
-	"os/exec"
-	"path/filepath"
+	"io"
+	"sync"
 
 	"github.com/cloudevents/sdk-go/v2/event"
 	"github.com/hashicorp/go-plugin"
@@ -17,6 +16,11 @@ import (
 type PluginManager struct {
 	plugins map[string]Plugin
 	client  *plugin.Client
+	// output returns the writer a stream ("stdout" or "stderr") of a function's plugin
+	// process is copied to (optional)
+	output func(functionName, stream string) io.Writer
+	// secret is the deployment's handshake value, see PluginHandshakeWithSecret (optional)
+	secret string
 }
 
 // NewPluginManager creates a new plugin manager
@@ -26,67 +30,114 @@ func NewPluginManager() *PluginManager {
 	}
 }
 
+// SetOutput captures the stdout and stderr of plugin processes started afterwards
+func (pm *PluginManager) SetOutput(output func(functionName, stream string) io.Writer) {
+	pm.output = output
+}
+
+// SetHandshakeSecret starts plugins loaded afterwards with a deployment's handshake value
+func (pm *PluginManager) SetHandshakeSecret(secret string) {
+	pm.secret = secret
+}
+
 // LoadPlugin loads a function plugin
 func (pm *PluginManager) LoadPlugin(meta FunctionMeta, binary []byte) (Plugin, error) {
-	// Create a temporary directory for the plugin
-	dir, err := os.MkdirTemp("", "function-plugin-*")
+	secure, err := pluginSecureConfig(meta, binary)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+		return nil, err
 	}
-	defer os.RemoveAll(dir)
 
-	// Write the plugin binary
-	pluginPath := filepath.Join(dir, "plugin")
-	if err := os.WriteFile(pluginPath, binary, 0755); err != nil {
-		return nil, fmt.Errorf("failed to write plugin binary: %w", err)
+	// Stage the plugin binary for the current platform. The staging directory
+	// must outlive the plugin process because Windows cannot delete a running executable.
+	dir, pluginPath, err := stagePlugin(binary)
+	if err != nil {
+		return nil, err
 	}
 
-	// Create the plugin client
-	client := plugin.NewClient(&plugin.ClientConfig{
-		HandshakeConfig: plugin.HandshakeConfig{
-			ProtocolVersion:  1,
-			MagicCookieKey:   "FUNCTION_PLUGIN",
-			MagicCookieValue: "function",
-		},
-		Plugins: map[string]plugin.Plugin{
-			"function": &FunctionPlugin{},
-		},
-		Cmd:              exec.Command(pluginPath),
+	// Create the plugin client, offering every ABI version the runtime implements. The
+	// binary is verified before it is executed, and the gRPC channel uses mutual TLS
+	// with certificates generated for this plugin process.
+	config := &plugin.ClientConfig{
+		HandshakeConfig:  PluginHandshakeWithSecret(pm.secret),
+		VersionedPlugins: PluginSets(nil),
+		Cmd:              pluginCommand(pluginPath),
+		SecureConfig:     secure,
+		AutoMTLS:         true,
 		AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
-		GRPCDialOptions: []grpc.DialOption{
-			grpc.WithInsecure(),
-		},
-	})
+	}
+	if pm.output != nil {
+		config.SyncStdout = pm.output(meta.Name, "stdout")
+		config.SyncStderr = pm.output(meta.Name, "stderr")
+		// Output written before the handshake completes goes to Stderr
+		config.Stderr = config.SyncStderr
+	}
+	client := plugin.NewClient(config)
 
 	// Connect to the plugin
 	rpcClient, err := client.Client()
 	if err != nil {
 		client.Kill()
-		return nil, fmt.Errorf("failed to connect to plugin: %w", err)
+		removeStagingDir(dir)
+		return nil, fmt.Errorf("failed to connect to plugin: %w", checkStartError(meta.Name, checkHandshakeError(meta.Name, err)))
 	}
 
 	// Get the plugin instance
 	raw, err := rpcClient.Dispense("function")
 	if err != nil {
 		client.Kill()
+		removeStagingDir(dir)
 		return nil, fmt.Errorf("failed to dispense plugin: %w", err)
 	}
 
+	fn, ok := raw.(Function)
+	if !ok {
+		client.Kill()
+		removeStagingDir(dir)
+		return nil, fmt.Errorf("plugin %s does not implement a function", meta.Name)
+	}
+
 	// Create the plugin wrapper
 	p := &pluginWrapper{
-		meta:   meta,
-		client: client,
-		plugin: raw.(Function),
+		meta:       meta,
+		client:     client,
+		plugin:     fn,
+		stagingDir: dir,
+		abiVersion: client.NegotiatedVersion(),
 	}
 
+	pm.plugins[meta.Name] = p
+
 	return p, nil
 }
 
+// Close stops all plugins loaded by the manager
+func (pm *PluginManager) Close() error {
+	for name, p := range pm.plugins {
+		if closer, ok := p.(io.Closer); ok {
+			closer.Close()
+		}
+		delete(pm.plugins, name)
+	}
+	return nil
+}
+
 // pluginWrapper wraps a function plugin
 type pluginWrapper struct {
-	meta   FunctionMeta
-	client *plugin.Client
-	plugin Function
+	meta       FunctionMeta
+	client     *plugin.Client
+	plugin     Function
+	stagingDir string
+	abiVersion int
+	closeOnce  sync.Once
+}
+
+// Close kills the plugin process and removes its staged binary
+func (p *pluginWrapper) Close() error {
+	p.closeOnce.Do(func() {
+		p.client.Kill()
+		removeStagingDir(p.stagingDir)
+	})
+	return nil
 }
 
 // Name returns the name of the plugin
@@ -109,62 +160,67 @@ func (p *pluginWrapper) Function() Function {
 	return p.plugin
 }
 
-// FunctionPlugin is the plugin implementation
+// ABIVersion returns the ABI version negotiated with the plugin
+func (p *pluginWrapper) ABIVersion() int {
+	return p.abiVersion
+}
+
+// Features returns the features of the negotiated ABI version
+func (p *pluginWrapper) Features() []string {
+	return PluginABIFeatures(p.abiVersion)
+}
+
+// FunctionPlugin is the plugin implementation of an ABI version. Plugins are served
+// over gRPC only, which AutoMTLS secures; net/rpc is not supported.
 type FunctionPlugin struct {
 	plugin.NetRPCUnsupportedPlugin
-	Impl Function
+	Impl       Function
+	ABIVersion int
 }
 
 // GRPCServer implements the plugin.GRPCPlugin interface
 func (p *FunctionPlugin) GRPCServer(broker *plugin.GRPCBroker, s *grpc.Server) error {
-	// TODO: Implement gRPC server
+	s.RegisterService(&functionServiceDesc, &FunctionServer{Impl: p.Impl, ABIVersion: p.ABIVersion, broker: broker})
 	return nil
 }
 
-// GRPCClient implements the plugin.GRPCPlugin interface
+// GRPCClient implements the plugin.GRPCPlugin interface. The runtime serves the
+// plugin its host service over the broker.
 func (p *FunctionPlugin) GRPCClient(ctx context.Context, broker *plugin.GRPCBroker, c *grpc.ClientConn) (interface{}, error) {
-	// TODO: Implement gRPC client
-	return nil, nil
-}
-
-func (p *FunctionPlugin) Server(*plugin.MuxBroker) (interface{}, error) {
-	return &FunctionServer{Impl: p.Impl}, nil
-}
-
-func (p *FunctionPlugin) Client(b *plugin.MuxBroker, c *rpc.Client) (interface{}, error) {
-	return &FunctionClient{client: c}, nil
+	host := &hostServer{}
+	return &grpcFunction{conn: c, host: host, hostBroker: host.serve(broker)}, nil
 }
 
 // FunctionServer is the RPC server for functions
 type FunctionServer struct {
-	Impl Function
+	Impl       Function
+	ABIVersion int
+
+	// broker connects to the runtime's host service, see hostContext
+	broker    *plugin.GRPCBroker
+	hostMu    sync.Mutex
+	hostConns map[uint32]*grpc.ClientConn
 }
 
 // Execute implements the RPC call for function execution
 func (s *FunctionServer) Execute(ctx context.Context, event *event.Event, result *FunctionResult) error {
 	events, err := s.Impl.Execute(ctx, event)
-	if err != nil {
+	var partial partialResult
+	if errors.As(err, &partial) {
+		result.Partial = partial.PartialReason()
+	} else if err != nil {
 		result.Error = err.Error()
 		return nil
 	}
 
-	// For now, return the first event if available
-	// TODO: Handle multiple events properly
+	// ABI v1 returns the first event only; later versions return every event and
+	// keep the first in Event for v1 readers
 	if len(events) > 0 {
 		result.Event = events[0]
 	}
+	if s.ABIVersion >= PluginABIv2 {
+		result.Events = events
+	}
 
 	return nil
 }
-
-// FunctionClient is the RPC client for functions
-type FunctionClient struct {
-	client *rpc.Client
-}
-
-// Execute calls the remote function implementation
-func (c *FunctionClient) Execute(ctx context.Context, event *event.Event) (FunctionResult, error) {
-	var result FunctionResult
-	err := c.client.Call("Plugin.Execute", event, &result)
-	return result, err
-}
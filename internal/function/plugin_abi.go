@@ -0,0 +1,121 @@
+package function
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-plugin"
+)
+
+// Plugin ABI versions. Plugins serve every ABI version they implement and the
+// runtime loads them over the highest version both sides support, so plugins built
+// for an older ABI keep working when the runtime adds capabilities.
+const (
+	// PluginABIv1 executes an event and returns at most one event
+	PluginABIv1 = 1
+	// PluginABIv2 returns every event a function produces
+	PluginABIv2 = 2
+
+	// MinPluginABIVersion and PluginABIVersion are the oldest and newest ABI versions the runtime implements
+	MinPluginABIVersion = PluginABIv1
+	PluginABIVersion    = PluginABIv2
+)
+
+// Plugin features, the capabilities an ABI version guarantees. Later versions add
+// features such as streaming results or access to function state.
+const (
+	FeatureMultipleEvents = "multiple_events"
+)
+
+// pluginABIFeatures are the features of every ABI version
+var pluginABIFeatures = map[int][]string{
+	PluginABIv1: nil,
+	PluginABIv2: {FeatureMultipleEvents},
+}
+
+// PluginABIFeatures returns the features an ABI version guarantees
+func PluginABIFeatures(version int) []string {
+	return append([]string(nil), pluginABIFeatures[version]...)
+}
+
+// PluginHandshake is the handshake plugins are served with. Its ProtocolVersion is
+// the ABI version of plugins that serve a single plugin set, which were built before
+// ABI versions were negotiated.
+var PluginHandshake = plugin.HandshakeConfig{
+	ProtocolVersion:  PluginABIv1,
+	MagicCookieKey:   "FUNCTION_PLUGIN",
+	MagicCookieValue: "function",
+}
+
+// PluginSets returns the plugin sets of every ABI version the runtime implements.
+// The runtime passes a nil function; plugins pass their implementation and serve the
+// sets with plugin.Serve and PluginHandshake as VersionedPlugins.
+func PluginSets(impl Function) map[int]plugin.PluginSet {
+	sets := make(map[int]plugin.PluginSet)
+	for version := MinPluginABIVersion; version <= PluginABIVersion; version++ {
+		sets[version] = plugin.PluginSet{
+			"function": &FunctionPlugin{Impl: impl, ABIVersion: version},
+		}
+	}
+	return sets
+}
+
+// ABIPlugin is implemented by plugins loaded over a negotiated ABI
+type ABIPlugin interface {
+	// ABIVersion returns the negotiated ABI version
+	ABIVersion() int
+	// Features returns the features of the negotiated ABI version
+	Features() []string
+}
+
+// IncompatiblePluginError is returned when a plugin implements no ABI version the runtime supports
+type IncompatiblePluginError struct {
+	FunctionName string
+	// PluginVersion is the ABI version the plugin offered, 0 when unknown
+	PluginVersion int
+	Min, Max      int
+	Err           error
+}
+
+func (e *IncompatiblePluginError) Error() string {
+	switch {
+	case e.PluginVersion > e.Max:
+		return fmt.Sprintf("plugin %s implements ABI version %d, the runtime supports versions %d to %d: upgrade the runtime",
+			e.FunctionName, e.PluginVersion, e.Min, e.Max)
+	case e.PluginVersion > 0:
+		return fmt.Sprintf("plugin %s implements ABI version %d, the runtime supports versions %d to %d: rebuild the plugin against a current SDK",
+			e.FunctionName, e.PluginVersion, e.Min, e.Max)
+	default:
+		return fmt.Sprintf("plugin %s is incompatible with the runtime's ABI versions %d to %d: %v",
+			e.FunctionName, e.Min, e.Max, e.Err)
+	}
+}
+
+func (e *IncompatiblePluginError) Unwrap() error {
+	return e.Err
+}
+
+// incompatibleVersion matches go-plugin's handshake errors for versions it cannot speak
+var incompatibleVersion = regexp.MustCompile(`Incompatible (?:core )?API version with plugin\. Plugin version: (\d+)`)
+
+// checkHandshakeError turns handshake failures caused by ABI versions into an
+// *IncompatiblePluginError and returns other errors unchanged
+func checkHandshakeError(functionName string, err error) error {
+	match := incompatibleVersion.FindStringSubmatch(err.Error())
+	if match == nil {
+		return err
+	}
+	incompatible := &IncompatiblePluginError{
+		FunctionName: functionName,
+		Min:          MinPluginABIVersion,
+		Max:          PluginABIVersion,
+		Err:          err,
+	}
+	// A core protocol mismatch is about go-plugin, not the function ABI
+	if !strings.Contains(err.Error(), "core API") {
+		incompatible.PluginVersion, _ = strconv.Atoi(match[1])
+	}
+	return incompatible
+}
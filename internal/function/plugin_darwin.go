@@ -0,0 +1,38 @@
+//go:build darwin
+
+package function
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+
+	"golang.org/x/sys/unix"
+)
+
+// pluginFileName is the file name used for staged plugin binaries
+const pluginFileName = "plugin"
+
+// quarantineAttr is the extended attribute Gatekeeper uses to block downloaded binaries
+const quarantineAttr = "com.apple.quarantine"
+
+// preparePluginBinary makes a staged plugin binary runnable.
+// Binaries fetched from the registry may inherit the quarantine attribute,
+// which makes Gatekeeper refuse to launch them.
+func preparePluginBinary(path string) error {
+	if err := os.Chmod(path, 0755); err != nil {
+		return err
+	}
+	if err := unix.Removexattr(path, quarantineAttr); err != nil && !errors.Is(err, unix.ENOATTR) {
+		return err
+	}
+	return nil
+}
+
+// configurePluginProcess applies platform specific process settings
+func configurePluginProcess(cmd *exec.Cmd) {}
+
+// removeStagingDir removes a plugin staging directory
+func removeStagingDir(dir string) {
+	os.RemoveAll(dir)
+}
@@ -0,0 +1,113 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	ce "github.com/cloudevents/sdk-go/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// The gRPC service functions are served with. Requests and responses carry JSON, the
+// CloudEvent and its FunctionResult, in a BytesValue, so no generated code is needed.
+const (
+	functionServiceName   = "mycelium.function.Function"
+	functionExecuteMethod = "/" + functionServiceName + "/Execute"
+)
+
+// functionServiceDesc describes the gRPC service of a function plugin
+var functionServiceDesc = grpc.ServiceDesc{
+	ServiceName: functionServiceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Execute", Handler: executeHandler},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+// executeHandler serves Execute calls with the FunctionServer registered for the service
+func executeHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := new(wrapperspb.BytesValue)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	execute := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(*FunctionServer).execute(ctx, req.(*wrapperspb.BytesValue))
+	}
+	if interceptor == nil {
+		return execute(ctx, req)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: functionExecuteMethod}
+	return interceptor(ctx, req, info, execute)
+}
+
+// execute decodes the event of a gRPC request and encodes its result
+func (s *FunctionServer) execute(ctx context.Context, req *wrapperspb.BytesValue) (*wrapperspb.BytesValue, error) {
+	var event ce.Event
+	if err := json.Unmarshal(req.GetValue(), &event); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal event: %w", err)
+	}
+	ctx, err := s.hostContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var result FunctionResult
+	if err := s.Execute(ctx, &event, &result); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal result: %w", err)
+	}
+	return wrapperspb.Bytes(data), nil
+}
+
+// grpcFunction is the runtime's side of a function plugin served over gRPC
+type grpcFunction struct {
+	conn *grpc.ClientConn
+	// host serves the plugin the state store, flags and heartbeats of its invocations
+	// on the broker connection hostBroker
+	host       *hostServer
+	hostBroker uint32
+}
+
+// Execute calls the plugin's function. Errors and partial results returned by the
+// function are returned like those of in-process functions. The call carries the
+// deadline of ctx, and cancelling ctx cancels it in the plugin. The function reaches
+// the state store, flags and heartbeat callback attached to ctx through the host
+// service while it runs.
+func (f *grpcFunction) Execute(ctx context.Context, event *ce.Event) ([]*ce.Event, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event: %w", err)
+	}
+	if f.host != nil {
+		invocation, done := f.host.start(ctx)
+		defer done()
+		ctx = withHost(ctx, f.hostBroker, invocation)
+	}
+	resp := new(wrapperspb.BytesValue)
+	if err := f.conn.Invoke(ctx, functionExecuteMethod, wrapperspb.Bytes(data), resp); err != nil {
+		return nil, fmt.Errorf("failed to call plugin: %w", err)
+	}
+
+	var result FunctionResult
+	if err := json.Unmarshal(resp.GetValue(), &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal result: %w", err)
+	}
+	if result.Error != "" {
+		return nil, errors.New(result.Error)
+	}
+	// ABI v1 plugins return at most one event in Event
+	events := result.Events
+	if len(events) == 0 && result.Event != nil {
+		events = []*ce.Event{result.Event}
+	}
+	if result.Partial != "" {
+		return events, PartialResult(result.Partial)
+	}
+	return events, nil
+}
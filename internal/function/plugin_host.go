@@ -0,0 +1,335 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/go-plugin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// The gRPC service the runtime serves each plugin process over the go-plugin broker, so
+// plugin functions reach the state store, feature flags and watchdog of the invocation
+// they run. Execute calls name
+// the broker connection of the service and the invocation in their metadata; host calls
+// name the invocation, whose context the runtime keeps while the plugin executes it.
+const (
+	hostServiceName      = "mycelium.function.Host"
+	hostBrokerHeader     = "mycelium-host-broker"
+	hostInvocationHeader = "mycelium-invocation"
+)
+
+// hostRequest is the request of a host service call, carried as JSON like Execute calls
+type hostRequest struct {
+	Invocation string `json:"invocation"`
+	Key        string `json:"key,omitempty"`
+	Value      []byte `json:"value,omitempty"`
+	Revision   uint64 `json:"revision,omitempty"`
+	Progress   string `json:"progress,omitempty"`
+}
+
+// hostResponse is the response of a host service call
+type hostResponse struct {
+	Value    []byte `json:"value,omitempty"`
+	Revision uint64 `json:"revision,omitempty"`
+	Found    bool   `json:"found,omitempty"`
+}
+
+// hostServiceDesc describes the host service of the runtime
+var hostServiceDesc = grpc.ServiceDesc{
+	ServiceName: hostServiceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		hostMethod("StateGet", (*hostServer).stateGet),
+		hostMethod("StatePut", (*hostServer).statePut),
+		hostMethod("StateDelete", (*hostServer).stateDelete),
+		hostMethod("StateCompareAndSwap", (*hostServer).stateCompareAndSwap),
+		hostMethod("FlagLookup", (*hostServer).flagLookup),
+		hostMethod("Heartbeat", (*hostServer).heartbeat),
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+// hostMethod describes a host service method served by call
+func hostMethod(name string, call func(h *hostServer, ctx context.Context, invocation context.Context, req hostRequest) (hostResponse, error)) grpc.MethodDesc {
+	fullMethod := "/" + hostServiceName + "/" + name
+	handler := func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		in := new(wrapperspb.BytesValue)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		serve := func(ctx context.Context, in interface{}) (interface{}, error) {
+			h := srv.(*hostServer)
+			var req hostRequest
+			if err := json.Unmarshal(in.(*wrapperspb.BytesValue).GetValue(), &req); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "failed to unmarshal request: %v", err)
+			}
+			invocation, ok := h.invocation(req.Invocation)
+			if !ok {
+				return nil, status.Errorf(codes.FailedPrecondition, "invocation %s is not running", req.Invocation)
+			}
+			resp, err := call(h, ctx, invocation, req)
+			if err != nil {
+				return nil, hostError(err)
+			}
+			data, err := json.Marshal(resp)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal response: %w", err)
+			}
+			return wrapperspb.Bytes(data), nil
+		}
+		if interceptor == nil {
+			return serve(ctx, in)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: fullMethod}
+		return interceptor(ctx, in, info, serve)
+	}
+	return grpc.MethodDesc{MethodName: name, Handler: handler}
+}
+
+// hostError converts the errors plugin functions check for to gRPC status codes, which
+// hostCallError converts back in the plugin
+func hostError(err error) error {
+	switch {
+	case errors.Is(err, ErrStateKeyNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrStateConflict):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, ErrStateUnavailable):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return err
+}
+
+// hostCallError converts the error of a host service call for the plugin function
+func hostCallError(method string, err error) error {
+	switch status.Code(err) {
+	case codes.NotFound:
+		return ErrStateKeyNotFound
+	case codes.Aborted:
+		return ErrStateConflict
+	case codes.FailedPrecondition:
+		return ErrStateUnavailable
+	}
+	return fmt.Errorf("failed to call runtime %s: %w", method, err)
+}
+
+// hostServer serves the host service to one plugin process. It holds the contexts of
+// the invocations the plugin is executing.
+type hostServer struct {
+	invocations sync.Map
+}
+
+// serve serves the host service on a broker connection until the plugin exits and
+// returns the connection's ID
+func (h *hostServer) serve(broker *plugin.GRPCBroker) uint32 {
+	id := broker.NextId()
+	go broker.AcceptAndServe(id, func(opts []grpc.ServerOption) *grpc.Server {
+		s := grpc.NewServer(opts...)
+		s.RegisterService(&hostServiceDesc, h)
+		return s
+	})
+	return id
+}
+
+// start registers an invocation and returns its ID and the function ending it
+func (h *hostServer) start(ctx context.Context) (string, func()) {
+	id := uuid.NewString()
+	h.invocations.Store(id, ctx)
+	return id, func() { h.invocations.Delete(id) }
+}
+
+// invocation returns the context of a running invocation
+func (h *hostServer) invocation(id string) (context.Context, bool) {
+	value, ok := h.invocations.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return value.(context.Context), true
+}
+
+func (h *hostServer) stateGet(ctx, invocation context.Context, req hostRequest) (hostResponse, error) {
+	state, err := StateFromContext(invocation)
+	if err != nil {
+		return hostResponse{}, err
+	}
+	value, revision, err := state.Get(ctx, req.Key)
+	return hostResponse{Value: value, Revision: revision}, err
+}
+
+func (h *hostServer) statePut(ctx, invocation context.Context, req hostRequest) (hostResponse, error) {
+	state, err := StateFromContext(invocation)
+	if err != nil {
+		return hostResponse{}, err
+	}
+	revision, err := state.Put(ctx, req.Key, req.Value)
+	return hostResponse{Revision: revision}, err
+}
+
+func (h *hostServer) stateDelete(ctx, invocation context.Context, req hostRequest) (hostResponse, error) {
+	state, err := StateFromContext(invocation)
+	if err != nil {
+		return hostResponse{}, err
+	}
+	return hostResponse{}, state.Delete(ctx, req.Key)
+}
+
+func (h *hostServer) stateCompareAndSwap(ctx, invocation context.Context, req hostRequest) (hostResponse, error) {
+	state, err := StateFromContext(invocation)
+	if err != nil {
+		return hostResponse{}, err
+	}
+	revision, err := state.CompareAndSwap(ctx, req.Key, req.Value, req.Revision)
+	return hostResponse{Revision: revision}, err
+}
+
+func (h *hostServer) flagLookup(ctx, invocation context.Context, req hostRequest) (hostResponse, error) {
+	flags, ok := invocation.Value(flagsContextKey{}).(Flags)
+	if !ok || flags == nil {
+		return hostResponse{}, nil
+	}
+	value, found := flags.Lookup(req.Key)
+	return hostResponse{Value: []byte(value), Found: found}, nil
+}
+
+func (h *hostServer) heartbeat(ctx, invocation context.Context, req hostRequest) (hostResponse, error) {
+	Heartbeat(invocation, req.Progress)
+	return hostResponse{}, nil
+}
+
+// withHost names the host service connection and the invocation in the metadata of
+// an Execute call
+func withHost(ctx context.Context, broker uint32, invocation string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx,
+		hostBrokerHeader, strconv.FormatUint(uint64(broker), 10),
+		hostInvocationHeader, invocation)
+}
+
+// hostClient calls the host service of the runtime for one invocation of a plugin
+// function
+type hostClient struct {
+	conn       *grpc.ClientConn
+	invocation string
+}
+
+// call calls a host service method
+func (c *hostClient) call(ctx context.Context, method string, req hostRequest) (hostResponse, error) {
+	req.Invocation = c.invocation
+	data, err := json.Marshal(req)
+	if err != nil {
+		return hostResponse{}, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	out := new(wrapperspb.BytesValue)
+	if err := c.conn.Invoke(ctx, "/"+hostServiceName+"/"+method, wrapperspb.Bytes(data), out); err != nil {
+		return hostResponse{}, hostCallError(method, err)
+	}
+	var resp hostResponse
+	if err := json.Unmarshal(out.GetValue(), &resp); err != nil {
+		return hostResponse{}, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return resp, nil
+}
+
+// hostState is the StateStore of plugin functions: the runtime serves it from the
+// state store of the invocation
+type hostState struct {
+	client *hostClient
+}
+
+// Get returns the value and revision stored under key
+func (s hostState) Get(ctx context.Context, key string) ([]byte, uint64, error) {
+	resp, err := s.client.call(ctx, "StateGet", hostRequest{Key: key})
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.Value, resp.Revision, nil
+}
+
+// Put stores value under key and returns the new revision
+func (s hostState) Put(ctx context.Context, key string, value []byte) (uint64, error) {
+	resp, err := s.client.call(ctx, "StatePut", hostRequest{Key: key, Value: value})
+	return resp.Revision, err
+}
+
+// Delete removes key
+func (s hostState) Delete(ctx context.Context, key string) error {
+	_, err := s.client.call(ctx, "StateDelete", hostRequest{Key: key})
+	return err
+}
+
+// CompareAndSwap stores value only if key is at the expected revision
+func (s hostState) CompareAndSwap(ctx context.Context, key string, value []byte, revision uint64) (uint64, error) {
+	resp, err := s.client.call(ctx, "StateCompareAndSwap", hostRequest{Key: key, Value: value, Revision: revision})
+	return resp.Revision, err
+}
+
+// hostFlags are the Flags of plugin functions: the runtime looks them up in the flags
+// of the invocation, so changes apply to the next lookup and are recorded in its
+// metrics. Flags the runtime cannot be asked for are unset.
+type hostFlags struct {
+	ctx    context.Context
+	client *hostClient
+}
+
+// Lookup returns the value of a flag; ok is false when the flag is not set
+func (f hostFlags) Lookup(name string) (string, bool) {
+	resp, err := f.client.call(f.ctx, "FlagLookup", hostRequest{Key: name})
+	if err != nil {
+		return "", false
+	}
+	return string(resp.Value), resp.Found
+}
+
+// hostContext attaches what the runtime serves for the invocation named in the metadata
+// of an Execute call to the plugin function's context. Calls without it, e.g. from
+// runtimes predating the host service, are executed unchanged.
+func (s *FunctionServer) hostContext(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	brokers, invocations := md.Get(hostBrokerHeader), md.Get(hostInvocationHeader)
+	if s.broker == nil || len(brokers) == 0 || len(invocations) == 0 {
+		return ctx, nil
+	}
+	broker, err := strconv.ParseUint(brokers[0], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid host broker %q: %w", brokers[0], err)
+	}
+	conn, err := s.hostConn(uint32(broker))
+	if err != nil {
+		return nil, err
+	}
+	client := &hostClient{conn: conn, invocation: invocations[0]}
+	ctx = WithState(ctx, hostState{client: client})
+	ctx = WithFlags(ctx, hostFlags{ctx: ctx, client: client})
+	// Heartbeats reach the watchdog of the runtime; the deadline is the context's
+	return withBudget(ctx, time.Time{}, func(progress string) {
+		client.call(ctx, "Heartbeat", hostRequest{Progress: progress})
+	}), nil
+}
+
+// hostConn returns the connection to the runtime's host service, dialing it on first use
+func (s *FunctionServer) hostConn(broker uint32) (*grpc.ClientConn, error) {
+	s.hostMu.Lock()
+	defer s.hostMu.Unlock()
+	if conn, ok := s.hostConns[broker]; ok {
+		return conn, nil
+	}
+	conn, err := s.broker.Dial(broker)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to runtime: %w", err)
+	}
+	if s.hostConns == nil {
+		s.hostConns = make(map[uint32]*grpc.ClientConn)
+	}
+	s.hostConns[broker] = conn
+	return conn, nil
+}
@@ -0,0 +1,67 @@
+package function
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/go-plugin"
+)
+
+// ErrPluginDigestMismatch is returned when a plugin binary does not match the digest
+// recorded by the registry, e.g. because the binary was tampered with
+var ErrPluginDigestMismatch = errors.New("plugin binary does not match its digest")
+
+// NewPluginSecret generates a random handshake value for a deployment, see
+// PluginHandshakeWithSecret
+func NewPluginSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate plugin secret: %w", err)
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// PluginHandshakeWithSecret returns the handshake of a deployment's plugins: the secret
+// replaces the magic cookie value, so a runtime does not start plugins built for another
+// deployment by mistake. It is not a security control: the value is passed to plugin
+// processes in their environment and is embedded in plugin binaries. Plugins are secured
+// by verifying their binary against its digest and by mutual TLS on the plugin channel.
+// An empty secret returns PluginHandshake.
+func PluginHandshakeWithSecret(secret string) plugin.HandshakeConfig {
+	handshake := PluginHandshake
+	if secret != "" {
+		handshake.MagicCookieValue = secret
+	}
+	return handshake
+}
+
+// pluginSecureConfig verifies the staged plugin binary right before it is executed:
+// against the registry's digest when the metadata has one, otherwise against the
+// digest of the binary as it was fetched
+func pluginSecureConfig(meta FunctionMeta, binary []byte) (*plugin.SecureConfig, error) {
+	digest := meta.Digest
+	if digest == "" {
+		digest = BinaryDigest(binary)
+	}
+	checksum, err := hex.DecodeString(digest)
+	if err != nil || len(checksum) != sha256.Size {
+		return nil, fmt.Errorf("plugin %s: invalid digest %q", meta.Name, meta.Digest)
+	}
+	return &plugin.SecureConfig{Checksum: checksum, Hash: sha256.New()}, nil
+}
+
+// checkStartError explains plugins that failed verification or refused the handshake
+func checkStartError(functionName string, err error) error {
+	if errors.Is(err, plugin.ErrChecksumsDoNotMatch) {
+		return fmt.Errorf("plugin %s: %w", functionName, ErrPluginDigestMismatch)
+	}
+	// Plugins exit without handshaking when the secret they were built with differs
+	if strings.Contains(err.Error(), "Unrecognized remote plugin message") {
+		return fmt.Errorf("%w (check that plugin %s was built with the runtime's plugin secret)", err, functionName)
+	}
+	return err
+}
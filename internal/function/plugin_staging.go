@@ -0,0 +1,40 @@
+package function
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// stagePlugin writes a plugin binary into a fresh staging directory and
+// prepares it for execution on the current platform. The caller owns the
+// returned directory and must remove it with removeStagingDir once the
+// plugin process has exited.
+func stagePlugin(binary []byte) (dir string, pluginPath string, err error) {
+	dir, err = os.MkdirTemp("", "function-plugin-*")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create temp directory: %w", err)
+	}
+
+	pluginPath = filepath.Join(dir, pluginFileName)
+	if err := os.WriteFile(pluginPath, binary, 0755); err != nil {
+		removeStagingDir(dir)
+		return "", "", fmt.Errorf("failed to write plugin binary: %w", err)
+	}
+
+	if err := preparePluginBinary(pluginPath); err != nil {
+		removeStagingDir(dir)
+		return "", "", fmt.Errorf("failed to prepare plugin binary: %w", err)
+	}
+
+	return dir, pluginPath, nil
+}
+
+// pluginCommand builds the command used to launch a staged plugin
+func pluginCommand(pluginPath string) *exec.Cmd {
+	cmd := exec.Command(pluginPath)
+	cmd.Dir = filepath.Dir(pluginPath)
+	configurePluginProcess(cmd)
+	return cmd
+}
@@ -0,0 +1,25 @@
+//go:build !windows && !darwin
+
+package function
+
+import (
+	"os"
+	"os/exec"
+)
+
+// pluginFileName is the file name used for staged plugin binaries
+const pluginFileName = "plugin"
+
+// preparePluginBinary makes a staged plugin binary runnable
+func preparePluginBinary(path string) error {
+	// WriteFile honours the umask, so make sure the binary is executable
+	return os.Chmod(path, 0755)
+}
+
+// configurePluginProcess applies platform specific process settings
+func configurePluginProcess(cmd *exec.Cmd) {}
+
+// removeStagingDir removes a plugin staging directory
+func removeStagingDir(dir string) {
+	os.RemoveAll(dir)
+}
@@ -0,0 +1,40 @@
+//go:build windows
+
+package function
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// pluginFileName is the file name used for staged plugin binaries.
+// Windows only executes files with a known executable extension.
+const pluginFileName = "plugin.exe"
+
+// preparePluginBinary makes a staged plugin binary runnable
+func preparePluginBinary(path string) error {
+	return nil
+}
+
+// configurePluginProcess applies platform specific process settings.
+// Plugins get their own process group so console signals aimed at the
+// runtime are not delivered to them before go-plugin shuts them down.
+func configurePluginProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP,
+	}
+}
+
+// removeStagingDir removes a plugin staging directory.
+// Windows keeps the executable locked until the process handle is released,
+// so removal is retried for a short period after the plugin is killed.
+func removeStagingDir(dir string) {
+	for i := 0; i < 10; i++ {
+		if err := os.RemoveAll(dir); err == nil {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
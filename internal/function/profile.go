@@ -0,0 +1,36 @@
+package function
+
+import (
+	"fmt"
+
+	"github.com/julianshen/mycelium/internal/profiling"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+// ProfileVerb is the $SRV verb answering with Go profiles and runtime metrics of a
+// runtime instance. It is only served on $SRV.PROFILE.<service>.<id>, since profiles
+// are taken of one instance at a time.
+const ProfileVerb = "PROFILE"
+
+// ProfileSubject returns the PROFILE subject of a runtime instance
+func ProfileSubject(serviceName, id string) string {
+	return fmt.Sprintf("%s.%s.%s.%s", micro.APIPrefix, ProfileVerb, serviceName, id)
+}
+
+// addProfileEndpoint registers the PROFILE endpoint of the instance, authenticating
+// requests against the hex SHA-256 of the admin token. Invocations are labeled with
+// their function, so CPU profiles attribute the time of in-process functions to them.
+func (rs *RuntimeService) addProfileEndpoint(tokenSHA256 string) error {
+	handler, err := profiling.NewHandler(tokenSHA256)
+	if err != nil {
+		return err
+	}
+	info := rs.service.Info()
+	return rs.service.AddEndpoint("profile", handler.MicroHandler(),
+		micro.WithEndpointSubject(ProfileSubject(info.Name, info.ID)),
+		micro.WithEndpointMetadata(map[string]string{
+			"description": "Return a Go profile or the runtime metrics of this runtime instance",
+			"format":      "application/octet-stream",
+		}))
+}
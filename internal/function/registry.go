@@ -2,9 +2,12 @@ package function
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 
+	"github.com/julianshen/mycelium/internal/audit"
+	"github.com/julianshen/mycelium/internal/event"
+
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 )
@@ -15,27 +18,71 @@ type NATSRegistry struct {
 	js          jetstream.JetStream
 	kv          jetstream.KeyValue
 	objectStore jetstream.ObjectStore
+	// cache keeps fetched binaries on local disk (optional)
+	cache *BinaryCache
+	// trail records registry changes in the tamper-evident audit trail (optional)
+	trail *audit.Trail
+	// lifecycle announces registry changes as CloudEvents and to webhooks (optional)
+	lifecycle *LifecycleNotifier
 }
 
+// Default registry buckets
+const (
+	DefaultFunctionBucket = "functions"
+	DefaultBinaryBucket   = "function-binaries"
+)
+
 // NewNATSRegistry creates a new NATS registry
 func NewNATSRegistry(nc *nats.Conn) (*NATSRegistry, error) {
+	return NewNATSRegistryWithBuckets(nc, DefaultFunctionBucket, DefaultBinaryBucket)
+}
+
+// OpenRegistry returns the registry for a transport mode: the NATS registry when the
+// server offers JetStream, and a file registry in dir on a bare NATS server
+func OpenRegistry(nc *nats.Conn, mode, dir string) (Registry, error) {
+	mode, err := event.ResolveMode(nc, mode)
+	if err != nil {
+		return nil, err
+	}
+	if mode == event.ModeCore {
+		if dir == "" {
+			return nil, fmt.Errorf("a registry directory is required in core mode")
+		}
+		return NewFileRegistry(dir)
+	}
+	return NewNATSRegistry(nc)
+}
+
+// NewNATSRegistryWithBuckets creates a NATS registry scoped to the given metadata KV bucket
+// and binary object store, e.g. the buckets provisioned for a namespace
+func NewNATSRegistryWithBuckets(nc *nats.Conn, functionBucket, binaryBucket string) (*NATSRegistry, error) {
 	js, err := jetstream.New(nc)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create jetstream: %w", err)
 	}
 
-	// Create or get the KV bucket
-	kv, err := js.CreateKeyValue(context.Background(), jetstream.KeyValueConfig{
-		Bucket: "functions",
-	})
+	// Get the KV bucket, creating it if it doesn't exist
+	kv, err := js.KeyValue(context.Background(), functionBucket)
+	if errors.Is(err, jetstream.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(context.Background(), jetstream.KeyValueConfig{
+			Bucket:  functionBucket,
+			History: DefaultFunctionHistory,
+		})
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create KV bucket: %w", err)
 	}
+	if err := ensureHistory(context.Background(), js, kv); err != nil {
+		return nil, err
+	}
 
-	// Create or get the object store bucket
-	objectStore, err := js.CreateObjectStore(context.Background(), jetstream.ObjectStoreConfig{
-		Bucket: "function-binaries",
-	})
+	// Get the object store bucket, creating it if it doesn't exist
+	objectStore, err := js.ObjectStore(context.Background(), binaryBucket)
+	if errors.Is(err, jetstream.ErrBucketNotFound) {
+		objectStore, err = js.CreateObjectStore(context.Background(), jetstream.ObjectStoreConfig{
+			Bucket: binaryBucket,
+		})
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create object store: %w", err)
 	}
@@ -48,86 +95,259 @@ func NewNATSRegistry(nc *nats.Conn) (*NATSRegistry, error) {
 	}, nil
 }
 
-// StoreFunction stores a function's metadata and binary
+// SetBinaryCache makes the registry serve binaries from a local disk cache and cache
+// every binary it downloads. Only binaries stored by digest are cached.
+func (r *NATSRegistry) SetBinaryCache(cache *BinaryCache) {
+	r.cache = cache
+}
+
+// SetAuditTrail records every change to the registry in a tamper-evident audit trail.
+// Changes that succeed but fail to be recorded return an error.
+func (r *NATSRegistry) SetAuditTrail(trail *audit.Trail) {
+	r.trail = trail
+}
+
+// recordChange appends a registry change to the audit trail, if any
+func (r *NATSRegistry) recordChange(ctx context.Context, record audit.Record) error {
+	if r.trail == nil {
+		return nil
+	}
+	record.Kind = audit.KindRegistry
+	if _, err := r.trail.Append(ctx, record); err != nil {
+		return fmt.Errorf("%s of %s succeeded but was not audited: %w", record.Action, record.Resource, err)
+	}
+	return nil
+}
+
+// SetLifecycleNotifier announces every deployment, deletion and promotion of a
+// function. Changes that succeed but fail to be announced return an error.
+func (r *NATSRegistry) SetLifecycleNotifier(notifier *LifecycleNotifier) {
+	r.lifecycle = notifier
+}
+
+// announce publishes a lifecycle event of a registry change, if a notifier is set
+func (r *NATSRegistry) announce(ctx context.Context, eventType string, change LifecycleChange) error {
+	if r.lifecycle == nil {
+		return nil
+	}
+	source := "mycelium/registry/" + r.kv.Bucket()
+	if err := r.lifecycle.Notify(ctx, source, eventType, change); err != nil {
+		return fmt.Errorf("%s of %s succeeded but was not announced: %w", eventType, change.Function, err)
+	}
+	return nil
+}
+
+// binaryKey returns the object name a binary is stored under. Binaries are keyed by
+// their digest, so functions and versions sharing an artifact share one object.
+func binaryKey(digest string) string {
+	return "sha256-" + digest
+}
+
+// putBinary stores a binary by digest unless an identical one is already stored.
+// It reports whether a new object was created.
+func (r *NATSRegistry) putBinary(ctx context.Context, binary []byte) (string, bool, error) {
+	digest := BinaryDigest(binary)
+	_, err := r.objectStore.GetInfo(ctx, binaryKey(digest))
+	if err == nil {
+		return digest, false, nil
+	}
+	if !errors.Is(err, jetstream.ErrObjectNotFound) {
+		return "", false, err
+	}
+
+	if _, err := r.objectStore.PutBytes(ctx, binaryKey(digest), binary); err != nil {
+		return "", false, err
+	}
+	return digest, true, nil
+}
+
+// StoreFunction stores a function's metadata and binary.
+// It fails with ErrQuotaExceeded when the registry's quota does not allow it.
+// The binary is stored first, by digest, so metadata never references a missing binary;
+// storing an unchanged binary again only writes metadata.
 func (r *NATSRegistry) StoreFunction(meta FunctionMeta, binary []byte) error {
+	ctx := context.Background()
+
+	if err := r.checkQuota(ctx, map[string][]byte{meta.Name: binary}); err != nil {
+		return err
+	}
+
+	digest, _, err := r.putBinary(ctx, binary)
+	if err != nil {
+		return fmt.Errorf("failed to store binary: %w", err)
+	}
+	meta.Digest = digest
+
+	// Keep the version before the function points at it
+	objects := r.revisionObjects(ctx, meta.Name)
+	previous, err := r.storeVersion(ctx, meta)
+	if err != nil {
+		return err
+	}
+	if previous != "" {
+		objects = append(objects, previous)
+	}
+
 	// Store the metadata
-	metaData, err := json.Marshal(meta)
+	metaData, err := encodeMeta(meta)
 	if err != nil {
 		return fmt.Errorf("failed to marshal metadata: %w", err)
 	}
 
-	_, err = r.kv.Put(context.Background(), meta.Name, metaData)
+	_, err = r.kv.Put(ctx, meta.Name, metaData)
 	if err != nil {
 		return fmt.Errorf("failed to store metadata: %w", err)
 	}
 
-	// Store the binary
-	_, err = r.objectStore.PutBytes(context.Background(), meta.Name, binary)
+	// Release the binaries of revisions that dropped out of the history and are no
+	// retained version. Failures only leave an unreferenced object behind, so they
+	// are not reported.
+	r.pruneObjects(ctx, objects)
+
+	return errors.Join(
+		r.recordChange(ctx, audit.Record{
+			Action:   "store",
+			Resource: meta.Name,
+			Details:  map[string]string{"version": meta.Version, "digest": digest},
+		}),
+		r.announce(ctx, EventTypeFunctionDeployed, LifecycleChange{
+			Function: meta.Name,
+			Type:     meta.Type,
+			Version:  meta.Version,
+			Digest:   digest,
+		}),
+	)
+}
+
+// getMeta returns the stored metadata of a function
+func (r *NATSRegistry) getMeta(ctx context.Context, name string) (*FunctionMeta, error) {
+	entry, err := r.kv.Get(ctx, name)
 	if err != nil {
-		return fmt.Errorf("failed to store binary: %w", err)
+		return nil, err
 	}
 
-	return nil
+	meta, err := decodeMeta(entry.Value())
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
+	}
+	return &meta, nil
 }
 
 // GetFunction retrieves a function's metadata and binary
 func (r *NATSRegistry) GetFunction(name string) (FunctionMeta, []byte, error) {
+	ctx := context.Background()
+
 	// Get the metadata
-	entry, err := r.kv.Get(context.Background(), name)
+	entry, err := r.kv.Get(ctx, name)
 	if err != nil {
 		return FunctionMeta{}, nil, fmt.Errorf("failed to get metadata: %w", err)
 	}
 
-	var meta FunctionMeta
-	if err := json.Unmarshal(entry.Value(), &meta); err != nil {
+	meta, err := decodeMeta(entry.Value())
+	if err != nil {
 		return FunctionMeta{}, nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
 	}
 
-	// Get the binary
-	binary, err := r.objectStore.GetBytes(context.Background(), name)
+	binary, err := r.getBinary(ctx, meta)
 	if err != nil {
-		return FunctionMeta{}, nil, fmt.Errorf("failed to get binary: %w", err)
+		return FunctionMeta{}, nil, err
 	}
-
 	return meta, binary, nil
 }
 
-// ListFunctions returns a list of all available functions
-func (r *NATSRegistry) ListFunctions() ([]FunctionMeta, error) {
-	keys, err := r.kv.Keys(context.Background())
-	if err != nil {
-		return nil, fmt.Errorf("failed to list functions: %w", err)
-	}
-
-	var functions []FunctionMeta
-	for _, key := range keys {
-		entry, err := r.kv.Get(context.Background(), key)
+// getBinary retrieves the binary a function revision references
+func (r *NATSRegistry) getBinary(ctx context.Context, meta FunctionMeta) ([]byte, error) {
+	// Functions stored before binaries were keyed by digest keep their binary under their name
+	if meta.Digest == "" {
+		binary, err := r.objectStore.GetBytes(ctx, meta.Name)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get function %s: %w", key, err)
+			return nil, fmt.Errorf("failed to get binary: %w", err)
 		}
+		return binary, nil
+	}
 
-		var meta FunctionMeta
-		if err := json.Unmarshal(entry.Value(), &meta); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal function %s: %w", key, err)
+	if r.cache != nil {
+		if binary, ok := r.cache.Get(meta.Digest); ok {
+			return binary, nil
 		}
+	}
 
-		functions = append(functions, meta)
+	binary, err := r.objectStore.GetBytes(ctx, binaryKey(meta.Digest))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get binary: %w", err)
+	}
+	if BinaryDigest(binary) != meta.Digest {
+		return nil, fmt.Errorf("binary of %s: %w", meta.Name, ErrDigestMismatch)
+	}
+
+	// A failed cache write only costs a download on the next load
+	if r.cache != nil {
+		r.cache.Put(meta.Digest, binary)
 	}
 
+	return binary, nil
+}
+
+// ListFunctions returns a list of all available functions ordered by name. Metadata is
+// fetched concurrently; use ForEachFunction or ListFunctionsPage for large registries.
+func (r *NATSRegistry) ListFunctions() ([]FunctionMeta, error) {
+	var functions []FunctionMeta
+	err := r.ForEachFunction(context.Background(), FunctionListOptions{}, func(meta FunctionMeta, _ []byte) bool {
+		functions = append(functions, meta)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
 	return functions, nil
 }
 
-// DeleteFunction removes a function
+// DeleteFunction removes a function with its retained revisions and versions. Its
+// binaries are removed once no other function references them.
 func (r *NATSRegistry) DeleteFunction(name string) error {
-	// Delete the metadata
-	if err := r.kv.Delete(context.Background(), name); err != nil {
+	ctx := context.Background()
+
+	meta, err := r.getMeta(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to get metadata: %w", err)
+	}
+	objects := r.revisionObjects(ctx, name)
+
+	// Delete the versions first, so a failure leaves the function in place
+	stored, err := r.storedVersions(ctx, name)
+	if err != nil {
+		return err
+	}
+	if len(stored[name]) > 0 {
+		kv, err := r.versionBucket(ctx, false)
+		if err != nil {
+			return err
+		}
+		for _, version := range stored[name] {
+			if err := kv.Purge(ctx, versionKey(name, version.Version)); err != nil {
+				return fmt.Errorf("failed to delete version %s: %w", version.Version, err)
+			}
+			objects = append(objects, binaryObject(version))
+		}
+	}
+
+	// Delete the metadata and its history
+	if err := r.kv.Purge(ctx, name); err != nil {
 		return fmt.Errorf("failed to delete metadata: %w", err)
 	}
 
-	// Delete the binary
-	if err := r.objectStore.Delete(context.Background(), name); err != nil {
+	// Delete the binaries
+	if err := r.pruneObjects(ctx, objects); err != nil {
 		return fmt.Errorf("failed to delete binary: %w", err)
 	}
 
-	return nil
+	return errors.Join(
+		r.recordChange(ctx, audit.Record{Action: "delete", Resource: name}),
+		r.announce(ctx, EventTypeFunctionDeleted, LifecycleChange{
+			Function: name,
+			Type:     meta.Type,
+			Version:  meta.Version,
+			Digest:   meta.Digest,
+		}),
+	)
 }
@@ -0,0 +1,181 @@
+package function
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+// Config keys holding a function's resource requirements
+const (
+	ConfigMemory = "memory"
+	ConfigCPU    = "cpu"
+)
+
+// ErrInsufficientCapacity is returned when loading a function would exceed the instance's capacity
+var ErrInsufficientCapacity = errors.New("insufficient runtime capacity")
+
+// ResourceRequirements are the resources a function reserves on a runtime instance
+type ResourceRequirements struct {
+	MemoryBytes int64 `json:"memory_bytes"`
+	MilliCPU    int64 `json:"milli_cpu"`
+}
+
+// Add returns the sum of two requirements
+func (r ResourceRequirements) Add(other ResourceRequirements) ResourceRequirements {
+	return ResourceRequirements{
+		MemoryBytes: r.MemoryBytes + other.MemoryBytes,
+		MilliCPU:    r.MilliCPU + other.MilliCPU,
+	}
+}
+
+// Fits reports whether r fits into capacity; zero capacity dimensions are unlimited
+func (r ResourceRequirements) Fits(capacity ResourceRequirements) bool {
+	return (capacity.MemoryBytes == 0 || r.MemoryBytes <= capacity.MemoryBytes) &&
+		(capacity.MilliCPU == 0 || r.MilliCPU <= capacity.MilliCPU)
+}
+
+// Resources parses the function's Config["memory"] and Config["cpu"].
+// Memory accepts bytes or Kubernetes-style quantities (128Mi, 1Gi, 500M);
+// CPU accepts cores (0.5, 2) or millicores (500m).
+func (m FunctionMeta) Resources() (ResourceRequirements, error) {
+	var req ResourceRequirements
+	var err error
+
+	if value := m.Config[ConfigMemory]; value != "" {
+		if req.MemoryBytes, err = ParseMemory(value); err != nil {
+			return ResourceRequirements{}, fmt.Errorf("function %s: %w", m.Name, err)
+		}
+	}
+	if value := m.Config[ConfigCPU]; value != "" {
+		if req.MilliCPU, err = ParseCPU(value); err != nil {
+			return ResourceRequirements{}, fmt.Errorf("function %s: %w", m.Name, err)
+		}
+	}
+	return req, nil
+}
+
+// memoryUnits are the supported memory quantity suffixes, longest first
+var memoryUnits = []struct {
+	suffix     string
+	multiplier float64
+}{
+	{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40},
+	{"K", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12},
+}
+
+// ParseMemory parses a memory quantity into bytes
+func ParseMemory(value string) (int64, error) {
+	number, multiplier := strings.TrimSpace(value), 1.0
+	for _, unit := range memoryUnits {
+		if strings.HasSuffix(number, unit.suffix) {
+			number, multiplier = strings.TrimSuffix(number, unit.suffix), unit.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n < 0 || math.IsInf(n, 0) {
+		return 0, fmt.Errorf("invalid memory quantity %q", value)
+	}
+	return int64(math.Ceil(n * multiplier)), nil
+}
+
+// ParseCPU parses a CPU quantity into millicores
+func ParseCPU(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if millis, ok := strings.CutSuffix(value, "m"); ok {
+		n, err := strconv.ParseInt(millis, 10, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid cpu quantity %q", value)
+		}
+		return n, nil
+	}
+
+	cores, err := strconv.ParseFloat(value, 64)
+	if err != nil || cores < 0 || math.IsInf(cores, 0) {
+		return 0, fmt.Errorf("invalid cpu quantity %q", value)
+	}
+	return int64(math.Ceil(cores * 1000)), nil
+}
+
+// CapacityStats reports reserved and used runtime capacity
+type CapacityStats struct {
+	Capacity ResourceRequirements `json:"capacity"` // Zero dimensions are unlimited
+	Reserved ResourceRequirements `json:"reserved"` // Reserved by loaded functions
+	Used     ResourceRequirements `json:"used"`     // Reserved by functions with in-flight invocations
+}
+
+// reservations tracks the resources reserved by loaded functions
+type reservations struct {
+	capacity ResourceRequirements
+	reserved map[string]ResourceRequirements
+	mu       sync.Mutex
+}
+
+// reserve admits a function if its requirements fit into the remaining capacity
+func (r *reservations) reserve(name string, req ResourceRequirements) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.reserved == nil {
+		r.reserved = make(map[string]ResourceRequirements)
+	}
+
+	var total ResourceRequirements
+	for other, reserved := range r.reserved {
+		if other != name {
+			total = total.Add(reserved)
+		}
+	}
+	if !total.Add(req).Fits(r.capacity) {
+		return fmt.Errorf("%w: function %s needs %d bytes / %dm CPU, %d bytes / %dm CPU already reserved",
+			ErrInsufficientCapacity, name, req.MemoryBytes, req.MilliCPU, total.MemoryBytes, total.MilliCPU)
+	}
+
+	r.reserved[name] = req
+	return nil
+}
+
+// release frees the reservation of a function
+func (r *reservations) release(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.reserved, name)
+}
+
+// stats summarizes reservations; busy reports whether a function has in-flight invocations
+func (r *reservations) stats(busy func(name string) bool) CapacityStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := CapacityStats{Capacity: r.capacity}
+	for name, req := range r.reserved {
+		stats.Reserved = stats.Reserved.Add(req)
+		if busy(name) {
+			stats.Used = stats.Used.Add(req)
+		}
+	}
+	return stats
+}
+
+// CapacityStats returns the instance's capacity with reserved and used resources
+func (rs *RuntimeService) CapacityStats() CapacityStats {
+	busy := make(map[string]bool)
+	for _, inv := range rs.InFlightInvocations() {
+		busy[inv.FunctionName] = true
+	}
+	return rs.reservations.stats(func(name string) bool { return busy[name] })
+}
+
+// serviceStats is the custom data reported by the service STATS endpoint
+func (rs *RuntimeService) serviceStats(endpoint *micro.Endpoint) any {
+	stats := rs.watchdogStats(endpoint).(map[string]any)
+	stats["capacity"] = rs.CapacityStats()
+	return stats
+}
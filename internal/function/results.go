@@ -0,0 +1,205 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/event"
+
+	ce "github.com/cloudevents/sdk-go/v2"
+	"github.com/nats-io/nats.go"
+)
+
+// DefaultResultSubject is the subject prefix function output events are published under
+const DefaultResultSubject = "function.results"
+
+// DefaultResultBuffer is the number of result events a subscription buffers
+const DefaultResultBuffer = 64
+
+// resultPublishTimeout bounds waiting for the acknowledgement of a persisted result
+const resultPublishTimeout = 5 * time.Second
+
+// ResultSubject returns the subject the output events of a function are published to.
+// A functionName of "*" addresses every function.
+func ResultSubject(prefix, functionName string) string {
+	return prefix + "." + functionName
+}
+
+// publishResults publishes the output events of an invocation to the function's result
+// subject. With route set, events carrying a route target, such as the output of router
+// functions, are published to their subject or invoke their function instead.
+func (rs *RuntimeService) publishResults(functionName string, events []*ce.Event, route bool) {
+	for _, e := range events {
+		subject := ResultSubject(rs.resultSubject, functionName)
+		var target string
+		if route {
+			subject, target = routeTarget(e)
+			if subject == "" && target == "" {
+				subject = ResultSubject(rs.resultSubject, functionName)
+			}
+		}
+
+		data, err := json.Marshal(e)
+		if err != nil {
+			rs.logger.Error("Failed to marshal result event", Field{Key: "error", Value: err})
+			continue
+		}
+		if target != "" {
+			// Chain an asynchronous invocation of the target function within the group
+			subject = InvokeSubject(rs.group)
+			data, err = json.Marshal(struct {
+				FunctionName string          `json:"functionName"`
+				Event        json.RawMessage `json:"event"`
+			}{FunctionName: target, Event: data})
+			if err != nil {
+				rs.logger.Error("Failed to marshal routed invocation", Field{Key: "error", Value: err})
+				continue
+			}
+		}
+		publish := rs.publishResult
+		if target != "" {
+			publish = rs.natsConn.Publish
+		}
+		if err := publish(subject, data); err != nil {
+			rs.logger.Error("Failed to publish result event",
+				Field{Key: "functionName", Value: functionName},
+				Field{Key: "subject", Value: subject},
+				Field{Key: "error", Value: err})
+		}
+	}
+}
+
+// publishResult publishes an output event. With PersistResults it is published through
+// JetStream, so it fails when no stream acknowledges storing it.
+func (rs *RuntimeService) publishResult(subject string, data []byte) error {
+	if rs.resultJS == nil {
+		return rs.natsConn.Publish(subject, data)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resultPublishTimeout)
+	defer cancel()
+	if _, err := rs.resultJS.Publish(ctx, subject, data); err != nil {
+		return fmt.Errorf("result was not stored: %w", err)
+	}
+	return nil
+}
+
+// InvokeFunctionAsync invokes a function without waiting for its output. The runtime
+// publishes the output events to the function's result subject, where SubscribeResults
+// receives them; execution errors are only logged by the runtime. With an offline buffer
+// configured, the invocation is stored while NATS is unreachable and sent on reconnect.
+func (c *Client) InvokeFunctionAsync(ctx context.Context, name string, event *ce.Event) error {
+	event, err := offloadEvent(c.claims, event)
+	if err != nil {
+		return err
+	}
+
+	req := struct {
+		FunctionName string    `json:"functionName"`
+		Event        *ce.Event `json:"event"`
+	}{
+		FunctionName: name,
+		Event:        event,
+	}
+
+	reqData, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	candidates := c.candidates(name)
+	if len(candidates) == 0 {
+		if c.offline != nil {
+			return c.enqueueOffline(InvokeSubject(c.group), reqData, false)
+		}
+		candidates = c.clusters[:1]
+	}
+
+	target := candidates[0]
+	if err := target.nc.Publish(InvokeSubject(c.group), reqData); err != nil {
+		if c.offline != nil && isConnectionError(err) {
+			return c.enqueueOffline(InvokeSubject(c.group), reqData, false)
+		}
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	c.served(name, target)
+	return nil
+}
+
+// ResultSubscription delivers the output events of a function
+type ResultSubscription struct {
+	subs   []*nats.Subscription
+	events chan *ce.Event
+	done   chan struct{}
+	once   sync.Once
+}
+
+// SubscribeResults subscribes to the output events of a function, or of every function
+// for "*". Events are published for asynchronous invocations, and for every invocation
+// when the runtime streams results. Messages that are not CloudEvents are skipped.
+// A multi-cluster client receives the results of every cluster.
+func (c *Client) SubscribeResults(functionName string) (*ResultSubscription, error) {
+	msgs := make(chan *nats.Msg, DefaultResultBuffer)
+	s := &ResultSubscription{
+		events: make(chan *ce.Event, DefaultResultBuffer),
+		done:   make(chan struct{}),
+	}
+	for _, cl := range c.clusters {
+		sub, err := cl.nc.ChanSubscribe(ResultSubject(c.resultSubject, functionName), msgs)
+		if err != nil {
+			for _, sub := range s.subs {
+				sub.Unsubscribe()
+			}
+			return nil, fmt.Errorf("failed to subscribe to results: %w", err)
+		}
+		s.subs = append(s.subs, sub)
+	}
+	go s.deliver(msgs, c.claims)
+	return s, nil
+}
+
+// deliver decodes result messages until the subscription is closed. Claim-checked
+// events whose payload cannot be resolved are skipped.
+func (s *ResultSubscription) deliver(msgs <-chan *nats.Msg, claims *event.ClaimCheck) {
+	defer close(s.events)
+	for {
+		select {
+		case <-s.done:
+			return
+		case msg := <-msgs:
+			event := ce.NewEvent()
+			if err := json.Unmarshal(msg.Data, &event); err != nil {
+				continue
+			}
+			if claims != nil && claims.Resolve(&event) != nil {
+				continue
+			}
+			select {
+			case s.events <- &event:
+			case <-s.done:
+				return
+			}
+		}
+	}
+}
+
+// Events returns the channel result events are delivered on. It is closed by Unsubscribe.
+func (s *ResultSubscription) Events() <-chan *ce.Event {
+	return s.events
+}
+
+// Unsubscribe stops the subscription and closes the events channel
+func (s *ResultSubscription) Unsubscribe() error {
+	var err error
+	s.once.Do(func() {
+		for _, sub := range s.subs {
+			if unsubErr := sub.Unsubscribe(); unsubErr != nil && err == nil {
+				err = unsubErr
+			}
+		}
+		close(s.done)
+	})
+	return err
+}
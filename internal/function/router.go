@@ -0,0 +1,144 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	ce "github.com/cloudevents/sdk-go/v2"
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// BuiltinRouter is the builtin function splitting an event stream by declarative rules
+const BuiltinRouter = "router"
+
+// Config keys of router functions
+const (
+	ConfigRouterRules = "rules" // JSON array of RouteRule
+	ConfigRouterMatch = "match" // first (default), routing to the first matching rule, or all
+)
+
+// Extensions naming where the runtime delivers an output event. Runtimes publish
+// events with ExtRouteSubject to that subject and invoke the function named by
+// ExtRouteFunction, instead of publishing them as the function's results.
+const (
+	ExtRouteSubject  = "routesubject"
+	ExtRouteFunction = "routefunction"
+	ExtRouteRule     = "routerule" // Name of the rule that routed the event
+)
+
+// RouteRule sends the events matching When to a subject or a function
+type RouteRule struct {
+	Name string `json:"name,omitempty"`
+	// When is an expr condition over event and data like the expression of a transform
+	// function; empty matches every event, e.g. for a final catch-all rule
+	When     string `json:"when,omitempty"`
+	Subject  string `json:"subject,omitempty"`
+	Function string `json:"function,omitempty"`
+}
+
+// compiledRoute is a rule with its compiled condition
+type compiledRoute struct {
+	RouteRule
+	program *vm.Program
+}
+
+// routerFunction returns every event once per matching rule, marked with the rule's
+// target. Events matching no rule are dropped.
+type routerFunction struct {
+	name   string
+	routes []compiledRoute
+	all    bool
+}
+
+// newRouterFunction compiles the rules of a router function's config
+func newRouterFunction(meta FunctionMeta) (Function, error) {
+	var rules []RouteRule
+	if meta.Config[ConfigRouterRules] == "" {
+		return nil, fmt.Errorf("router function %s: %s is required", meta.Name, ConfigRouterRules)
+	}
+	if err := json.Unmarshal([]byte(meta.Config[ConfigRouterRules]), &rules); err != nil {
+		return nil, fmt.Errorf("router function %s: invalid rules: %w", meta.Name, err)
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("router function %s: %s is required", meta.Name, ConfigRouterRules)
+	}
+
+	f := &routerFunction{name: meta.Name}
+	switch meta.Config[ConfigRouterMatch] {
+	case "", "first":
+	case "all":
+		f.all = true
+	default:
+		return nil, fmt.Errorf("router function %s: invalid match %q", meta.Name, meta.Config[ConfigRouterMatch])
+	}
+
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if (rule.Subject == "") == (rule.Function == "") {
+			return nil, fmt.Errorf("router function %s: rule %s needs either a subject or a function", meta.Name, rule.Name)
+		}
+		route := compiledRoute{RouteRule: rule}
+		if rule.When != "" {
+			program, err := expr.Compile(rule.When, expr.Env(transformEnv(nil, nil)), expr.AsBool())
+			if err != nil {
+				return nil, fmt.Errorf("router function %s: invalid condition of rule %s: %w", meta.Name, rule.Name, err)
+			}
+			route.program = program
+		}
+		f.routes = append(f.routes, route)
+	}
+	return f, nil
+}
+
+// Execute evaluates the rules in order and returns a copy of the event per matching rule
+func (f *routerFunction) Execute(ctx context.Context, event *ce.Event) ([]*ce.Event, error) {
+	var data interface{}
+	if len(event.Data()) > 0 {
+		if err := json.Unmarshal(event.Data(), &data); err != nil {
+			return nil, fmt.Errorf("router function %s: event data must be JSON: %w", f.name, err)
+		}
+	}
+	env := transformEnv(event, data)
+
+	var events []*ce.Event
+	for _, route := range f.routes {
+		if route.program != nil {
+			matched, err := expr.Run(route.program, env)
+			if err != nil {
+				return nil, fmt.Errorf("router function %s: rule %s: %w", f.name, route.Name, err)
+			}
+			if matched != true {
+				continue
+			}
+		}
+
+		routed := event.Clone()
+		routed.SetExtension(ExtRouteRule, route.Name)
+		if route.Subject != "" {
+			routed.SetExtension(ExtRouteSubject, route.Subject)
+		} else {
+			routed.SetExtension(ExtRouteFunction, route.Function)
+		}
+		events = append(events, &routed)
+		if !f.all {
+			break
+		}
+	}
+	return events, nil
+}
+
+// routeTarget returns where an output event is routed to and removes the target from
+// the event, so a function passing it on unchanged does not route it in a loop
+func routeTarget(event *ce.Event) (subject, functionName string) {
+	subject = stringExtension(event, ExtRouteSubject)
+	functionName = stringExtension(event, ExtRouteFunction)
+	if subject != "" || functionName != "" {
+		event.SetExtension(ExtRouteSubject, nil)
+		event.SetExtension(ExtRouteFunction, nil)
+	}
+	return subject, functionName
+}
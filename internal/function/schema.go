@@ -0,0 +1,286 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	ce "github.com/cloudevents/sdk-go/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// DefaultSchemaBucket is the KV bucket holding event data schemas
+const DefaultSchemaBucket = "event-schemas"
+
+// ErrSchemaNotFound is returned when no schema is registered for an event type
+var ErrSchemaNotFound = errors.New("schema not found")
+
+// SchemaRegistry stores the JSON Schemas of event data, keyed by event type
+type SchemaRegistry interface {
+	// GetSchema returns the schema of an event type's data
+	GetSchema(ctx context.Context, eventType string) (*EventSchema, error)
+	// PutSchema validates and stores the schema of an event type's data
+	PutSchema(ctx context.Context, eventType string, schema []byte) error
+	// ListSchemas returns the event types that have a schema
+	ListSchemas(ctx context.Context) ([]string, error)
+}
+
+// NATSSchemaRegistry implements SchemaRegistry on a JetStream KV bucket
+type NATSSchemaRegistry struct {
+	kv jetstream.KeyValue
+}
+
+// NewNATSSchemaRegistry creates a schema registry on the given KV bucket, creating it if needed
+func NewNATSSchemaRegistry(nc *nats.Conn, bucket string) (*NATSSchemaRegistry, error) {
+	if bucket == "" {
+		bucket = DefaultSchemaBucket
+	}
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create jetstream: %w", err)
+	}
+	kv, err := js.CreateOrUpdateKeyValue(context.Background(), jetstream.KeyValueConfig{
+		Bucket: bucket,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create schema bucket: %w", err)
+	}
+	return &NATSSchemaRegistry{kv: kv}, nil
+}
+
+// GetSchema returns the schema of an event type's data
+func (r *NATSSchemaRegistry) GetSchema(ctx context.Context, eventType string) (*EventSchema, error) {
+	entry, err := r.kv.Get(ctx, eventType)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return nil, fmt.Errorf("%w for event type %s", ErrSchemaNotFound, eventType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get schema: %w", err)
+	}
+	return ParseEventSchema(entry.Value())
+}
+
+// PutSchema validates and stores the schema of an event type's data
+func (r *NATSSchemaRegistry) PutSchema(ctx context.Context, eventType string, schema []byte) error {
+	if _, err := ParseEventSchema(schema); err != nil {
+		return err
+	}
+	if _, err := r.kv.Put(ctx, eventType, schema); err != nil {
+		return fmt.Errorf("failed to store schema: %w", err)
+	}
+	return nil
+}
+
+// ListSchemas returns the event types that have a schema, sorted
+func (r *NATSSchemaRegistry) ListSchemas(ctx context.Context) ([]string, error) {
+	keys, err := r.kv.Keys(ctx)
+	if errors.Is(err, jetstream.ErrNoKeysFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list schemas: %w", err)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// EventSchema is the subset of JSON Schema used to describe event data
+type EventSchema struct {
+	Title                string                  `json:"title,omitempty"`
+	Description          string                  `json:"description,omitempty"`
+	Type                 string                  `json:"type,omitempty"`
+	Properties           map[string]*EventSchema `json:"properties,omitempty"`
+	Required             []string                `json:"required,omitempty"`
+	AdditionalProperties *bool                   `json:"additionalProperties,omitempty"`
+	Items                *EventSchema            `json:"items,omitempty"`
+	Enum                 []interface{}           `json:"enum,omitempty"`
+	Format               string                  `json:"format,omitempty"`
+	MinLength            *int                    `json:"minLength,omitempty"`
+	Pattern              string                  `json:"pattern,omitempty"`
+	Minimum              *float64                `json:"minimum,omitempty"`
+	Maximum              *float64                `json:"maximum,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// ParseEventSchema parses a JSON Schema and precompiles its patterns
+func ParseEventSchema(data []byte) (*EventSchema, error) {
+	var s EventSchema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	if err := s.compile(""); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// compile checks the schema's types and precompiles the patterns of it and its subschemas
+func (s *EventSchema) compile(path string) error {
+	switch s.Type {
+	case "", "object", "array", "string", "number", "integer", "boolean", "null":
+	default:
+		return fmt.Errorf("invalid schema: %s: unsupported type %q", schemaPath(path), s.Type)
+	}
+	if s.Pattern != "" {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid schema: %s: invalid pattern: %w", schemaPath(path), err)
+		}
+		s.pattern = pattern
+	}
+	for name, prop := range s.Properties {
+		if err := prop.compile(joinSchemaPath(path, name)); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile(path + "[]")
+	}
+	return nil
+}
+
+// InvalidEventDataError is returned when event data does not conform to the schema
+// registered for its event type
+type InvalidEventDataError struct {
+	FunctionName string
+	EventType    string
+	Violations   []string
+}
+
+func (e *InvalidEventDataError) Error() string {
+	return fmt.Sprintf("function %s: event data of type %q does not match schema: %s",
+		e.FunctionName, e.EventType, strings.Join(e.Violations, "; "))
+}
+
+// ValidateEventData checks an event's JSON data against the schema. Violations are
+// reported as "path: message" strings; an empty result means the data conforms.
+func (s *EventSchema) ValidateEventData(event *ce.Event) []string {
+	var data interface{}
+	if len(event.Data()) > 0 {
+		if err := json.Unmarshal(event.Data(), &data); err != nil {
+			return []string{fmt.Sprintf("data: not valid JSON: %v", err)}
+		}
+	}
+
+	var violations []string
+	s.validate(data, "", &violations)
+	return violations
+}
+
+// validate checks a decoded JSON value against the schema, appending violations
+func (s *EventSchema) validate(value interface{}, path string, violations *[]string) {
+	fail := func(format string, args ...interface{}) {
+		*violations = append(*violations, schemaPath(path)+": "+fmt.Sprintf(format, args...))
+	}
+
+	if actual := jsonType(value); s.Type != "" && actual != s.Type &&
+		!(s.Type == "number" && actual == "integer") {
+		fail("expected %s, got %s", s.Type, actual)
+		return
+	}
+
+	if len(s.Enum) > 0 && !enumContains(s.Enum, value) {
+		fail("must be one of %v", s.Enum)
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			child := v[key]
+			prop, known := s.Properties[key]
+			if !known {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					*violations = append(*violations, schemaPath(joinSchemaPath(path, key))+": unknown field")
+				}
+				continue
+			}
+			prop.validate(child, joinSchemaPath(path, key), violations)
+		}
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				*violations = append(*violations, schemaPath(joinSchemaPath(path, name))+": required field is missing")
+			}
+		}
+
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i), violations)
+			}
+		}
+
+	case string:
+		if s.MinLength != nil && len(v) < *s.MinLength {
+			fail("shorter than %d characters", *s.MinLength)
+		} else if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("%q does not match pattern %s", v, s.Pattern)
+		}
+
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			fail("%v is less than %v", v, *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			fail("%v is greater than %v", v, *s.Maximum)
+		}
+	}
+}
+
+// jsonType maps a decoded JSON value to the JSON Schema type name it represents
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case nil:
+		return "null"
+	}
+	return "unknown"
+}
+
+// enumContains reports whether a decoded JSON value equals one of the enum values
+func enumContains(enum []interface{}, value interface{}) bool {
+	for _, candidate := range enum {
+		if fmt.Sprint(candidate) == fmt.Sprint(value) && jsonType(candidate) == jsonType(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func joinSchemaPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+// schemaPath renders a value path inside event data for messages
+func schemaPath(path string) string {
+	if path == "" || strings.HasPrefix(path, "[") {
+		return "data" + path
+	}
+	return "data." + path
+}
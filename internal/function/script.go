@@ -0,0 +1,221 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	ce "github.com/cloudevents/sdk-go/v2/event"
+)
+
+// TypeScript is the function type of scripts run by an interpreter over stdio
+const TypeScript = "script"
+
+// Config keys of script functions
+const (
+	ConfigRuntime   = "runtime"    // Interpreter, one of the keys of ScriptRuntimes
+	ConfigTimeout   = "timeout"    // Per-invocation timeout, e.g. 10s
+	ConfigMaxOutput = "max_output" // Stdout size cap, e.g. 512Ki
+)
+
+// Script defaults
+const (
+	DefaultScriptTimeout   = 30 * time.Second
+	DefaultScriptMaxOutput = 1 << 20
+	maxScriptStderr        = 4 << 10
+)
+
+// ErrScriptOutputTooLarge is returned when a script writes more than its output cap
+var ErrScriptOutputTooLarge = errors.New("script output exceeds limit")
+
+// ScriptRuntime describes an interpreter script functions can run on
+type ScriptRuntime struct {
+	Command   string   // Interpreter executable, looked up in PATH
+	Args      []string // Arguments before the script path
+	Extension string   // File extension of staged scripts
+}
+
+// ScriptRuntimes are the interpreters available to script functions
+var ScriptRuntimes = map[string]ScriptRuntime{
+	"python3": {Command: "python3", Args: []string{"-u"}, Extension: ".py"},
+	"node":    {Command: "node", Extension: ".js"},
+}
+
+// scriptPlugin is a script function staged on disk
+type scriptPlugin struct {
+	meta      FunctionMeta
+	fn        *scriptFunction
+	dir       string
+	closeOnce sync.Once
+}
+
+func (p *scriptPlugin) Name() string       { return p.meta.Name }
+func (p *scriptPlugin) Version() string    { return p.meta.Version }
+func (p *scriptPlugin) Type() string       { return p.meta.Type }
+func (p *scriptPlugin) Function() Function { return p.fn }
+
+// Close removes the staged script
+func (p *scriptPlugin) Close() error {
+	p.closeOnce.Do(func() {
+		removeStagingDir(p.dir)
+	})
+	return nil
+}
+
+// loadScript stages a script function. The script is stored in the registry as the
+// function binary and its interpreter is chosen by Config["runtime"].
+func loadScript(meta FunctionMeta, script []byte) (Plugin, error) {
+	runtime, ok := ScriptRuntimes[meta.Config[ConfigRuntime]]
+	if !ok {
+		return nil, fmt.Errorf("script function %s: unsupported runtime %q", meta.Name, meta.Config[ConfigRuntime])
+	}
+	interpreter, err := exec.LookPath(runtime.Command)
+	if err != nil {
+		return nil, fmt.Errorf("script function %s: runtime %s not available: %w", meta.Name, runtime.Command, err)
+	}
+
+	fn := &scriptFunction{
+		interpreter: interpreter,
+		args:        runtime.Args,
+		timeout:     DefaultScriptTimeout,
+		maxOutput:   DefaultScriptMaxOutput,
+	}
+	if value := meta.Config[ConfigTimeout]; value != "" {
+		if fn.timeout, err = time.ParseDuration(value); err != nil || fn.timeout <= 0 {
+			return nil, fmt.Errorf("script function %s: invalid timeout %q", meta.Name, value)
+		}
+	}
+	if value := meta.Config[ConfigMaxOutput]; value != "" {
+		if fn.maxOutput, err = ParseMemory(value); err != nil || fn.maxOutput <= 0 {
+			return nil, fmt.Errorf("script function %s: invalid max_output %q", meta.Name, value)
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "function-script-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	fn.path = filepath.Join(dir, "function"+runtime.Extension)
+	if err := os.WriteFile(fn.path, script, 0644); err != nil {
+		removeStagingDir(dir)
+		return nil, fmt.Errorf("failed to write script: %w", err)
+	}
+
+	return &scriptPlugin{meta: meta, fn: fn, dir: dir}, nil
+}
+
+// scriptFunction runs a script once per invocation. The CloudEvent is written to
+// stdin as JSON; response events are read from stdout as a single event, an array
+// of events, or a stream of events, and an empty stdout returns no events.
+type scriptFunction struct {
+	interpreter string
+	args        []string
+	path        string
+	timeout     time.Duration
+	maxOutput   int64
+}
+
+// Execute runs the script for an event
+func (f *scriptFunction) Execute(ctx context.Context, event *ce.Event) ([]*ce.Event, error) {
+	input, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+
+	stdout := &limitedBuffer{limit: f.maxOutput}
+	stderr := &limitedBuffer{limit: maxScriptStderr}
+	cmd := exec.CommandContext(ctx, f.interpreter, append(f.args, f.path)...)
+	cmd.Dir = filepath.Dir(f.path)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = time.Second
+	configurePluginProcess(cmd)
+
+	err = cmd.Run()
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return nil, fmt.Errorf("script timed out after %s", f.timeout)
+	case ctx.Err() != nil:
+		return nil, ctx.Err()
+	case stdout.overflow:
+		return nil, fmt.Errorf("%w of %d bytes", ErrScriptOutputTooLarge, f.maxOutput)
+	case err != nil:
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("script failed: %w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("script failed: %w", err)
+	}
+
+	return parseScriptOutput(stdout.Bytes())
+}
+
+// parseScriptOutput decodes the events a script wrote to stdout
+func parseScriptOutput(output []byte) ([]*ce.Event, error) {
+	var events []*ce.Event
+	decoder := json.NewDecoder(bytes.NewReader(output))
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err == io.EOF {
+			return events, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid script output: %w", err)
+		}
+
+		if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '[' {
+			var batch []*ce.Event
+			if err := json.Unmarshal(raw, &batch); err != nil {
+				return nil, fmt.Errorf("invalid event in script output: %w", err)
+			}
+			events = append(events, batch...)
+			continue
+		}
+
+		e := ce.New()
+		if err := json.Unmarshal(raw, &e); err != nil {
+			return nil, fmt.Errorf("invalid event in script output: %w", err)
+		}
+		events = append(events, &e)
+	}
+}
+
+// limitedBuffer keeps at most limit bytes and records whether more were written.
+// The buffer is not embedded so io.Copy cannot bypass Write through ReadFrom.
+type limitedBuffer struct {
+	buf      bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if remaining := b.limit - int64(b.buf.Len()); int64(len(p)) > remaining {
+		b.overflow = true
+		if remaining > 0 {
+			b.buf.Write(p[:remaining])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// Bytes returns the buffered bytes
+func (b *limitedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
+
+// String returns the buffered bytes as a string
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
@@ -3,19 +3,26 @@ package function
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"runtime/pprof"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	ce "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 	"github.com/nats-io/nats.go/micro"
 	"google.golang.org/grpc"
 
-	pb "mycelium/internal/function/proto"
+	"github.com/julianshen/mycelium/internal/event"
+	pb "github.com/julianshen/mycelium/internal/function/proto"
+	"github.com/julianshen/mycelium/internal/naming"
+	"github.com/julianshen/mycelium/internal/profiling"
 )
 
 // Service handles function execution through gRPC
@@ -30,13 +37,71 @@ type Service struct {
 
 // RuntimeService represents the function runtime service using NATS Service API
 type RuntimeService struct {
-	natsConn *nats.Conn
-	service  micro.Service
-	registry Registry
-	plugins  map[string]Plugin
-	metrics  MetricsCollector
-	logger   Logger
-	mu       sync.RWMutex
+	natsConn  *nats.Conn
+	service   micro.Service
+	registry  Registry
+	plugins   map[string]Plugin
+	metas     map[string]FunctionMeta
+	loaded    map[string]LoadedFunction
+	metrics   MetricsCollector
+	logger    Logger
+	bulkheads *bulkheads
+	stateKV   jetstream.KeyValue
+	inFlight  inFlightTracker
+	watchdog  WatchdogConfig
+	// integrity configures the periodic verification of the registry's binaries
+	integrity IntegrityCheckConfig
+	// gossip announces loaded functions to the runtime group (optional)
+	gossip *gossip
+	// pins maps functions to the version this instance serves regardless of the registry
+	pins map[string]string
+	// logs keeps the recent output of plugin processes for the LOGS endpoint
+	logs *functionLogs
+	// reservations tracks the resources reserved by loaded functions against the instance capacity
+	reservations reservations
+	stopCh       chan struct{}
+	// dropRejected drops events a function does not accept instead of returning an error
+	dropRejected bool
+	// timeout cancels invocations of functions without Config["timeout"] (optional)
+	timeout time.Duration
+	// flags serves the feature flags of functions (optional)
+	flags *KVFlagProvider
+	// pluginSecret is the handshake secret plugin processes are started with (optional)
+	pluginSecret string
+	// schemas validates event data before execution (optional)
+	schemas SchemaRegistry
+	// mirror publishes copies of sampled invocations (optional)
+	mirror *mirror
+	// resultSubject and streamResults control publishing of output events
+	resultSubject string
+	streamResults bool
+	// resultJS publishes output events with acknowledgement when results are persisted
+	resultJS jetstream.JetStream
+	// claims offloads large output events and resolves claim-checked input (optional)
+	claims *event.ClaimCheck
+	// group is the subject prefix of the invoke, describe and health endpoints
+	group string
+	// subscriptions feed the events of subjects to functions
+	subscriptions       []*nats.Subscription
+	subscriptionConfigs []FunctionSubscription
+	// cordoned instances take no new invocations; drainingSubs are the subscriptions
+	// they left that still process delivered events
+	cordoned     atomic.Bool
+	cordonMu     sync.Mutex
+	drainingSubs []*nats.Subscription
+	// ownsConn is set when the service dialed its connection and closes it on Stop
+	ownsConn bool
+	// builtins are the builtin function implementations added by the configuration
+	builtins map[string]func(meta FunctionMeta) (Function, error)
+	// maxVersions and versionIdle bound the versions loaded for name@version references,
+	// versionUse records when each of them was last invoked
+	maxVersions int
+	versionIdle time.Duration
+	versionUse  map[string]time.Time
+	// loadLocks serialize loading each function reference, see lockLoad
+	loadLocks map[string]*loadLock
+	loadMu    sync.Mutex
+	mu        sync.RWMutex
 }
 
 // RuntimeServiceConfig holds the configuration for the runtime service
@@ -48,6 +113,90 @@ type RuntimeServiceConfig struct {
 	Registry    Registry
 	Metrics     MetricsCollector
 	Logger      Logger
+	// MaxConcurrentInvocations limits concurrent invocations per function (default: DefaultMaxConcurrentInvocations)
+	MaxConcurrentInvocations int
+	// FunctionConcurrency overrides MaxConcurrentInvocations for individual functions
+	FunctionConcurrency map[string]int
+	// StateBucket enables the per-function state store backed by this JetStream KV bucket (optional)
+	StateBucket string
+	// FlagBucket enables feature flags backed by this JetStream KV bucket, see FlagKey.
+	// Changes apply to the next invocation without reloading functions (optional).
+	FlagBucket string
+	// Watchdog configures detection and cancellation of stuck invocations
+	Watchdog WatchdogConfig
+	// DropRejectedEvents answers invocations with events a function does not accept with
+	// an empty result instead of an event_rejected error
+	DropRejectedEvents bool
+	// MaxVersionedFunctions limits the versions an instance keeps loaded for name@version
+	// references next to the current versions; the least recently invoked is unloaded
+	// first (default: DefaultMaxVersionedFunctions)
+	MaxVersionedFunctions int
+	// VersionIdleTimeout unloads versions loaded for name@version references that were
+	// not invoked for this long (default: DefaultVersionIdleTimeout)
+	VersionIdleTimeout time.Duration
+	// FunctionTimeout cancels invocations of functions without Config["timeout"] that run
+	// longer; callers get a timeout error (optional, such functions run without a limit)
+	FunctionTimeout time.Duration
+	// Capacity is the instance's resource capacity; loading a function whose Config["memory"]
+	// and Config["cpu"] exceed the remaining capacity fails (zero dimensions are unlimited)
+	Capacity ResourceRequirements
+	// Schemas enables validation of inbound event data against the schema registered for
+	// the event type before Execute; events of types without a schema are not checked
+	Schemas SchemaRegistry
+	// Mirror publishes sanitized copies of sampled invocations and their responses to a
+	// debug subject so live traffic can be observed without attaching to the runtime
+	Mirror MirrorConfig
+	// ResultSubject is the subject prefix output events are published under as
+	// <ResultSubject>.<function> (default: DefaultResultSubject). Output events of
+	// asynchronous invocations, which have no reply subject, are always published.
+	ResultSubject string
+	// StreamResults also publishes the output events of synchronous invocations, so
+	// result subscribers observe every invocation of a function
+	StreamResults bool
+	// PersistResults publishes output events through JetStream and waits for the
+	// stream's acknowledgement, so events no stream stores are logged as failures
+	// instead of being dropped silently (optional, ignored in core mode)
+	PersistResults bool
+	// Mode is the transport mode (default: event.ModeAuto). Invocations always use
+	// request/reply; in core mode, for servers without JetStream, StateBucket, FlagBucket and
+	// ClaimCheck are ignored.
+	Mode string
+	// LogBufferLines is how many recent plugin output lines are kept per function for
+	// the LOGS endpoint (default: DefaultLogBufferLines)
+	LogBufferLines int
+	// ClaimCheck resolves claim-checked input events and offloads output event data
+	// above a size threshold to a JetStream object store (optional)
+	ClaimCheck *event.ClaimCheckConfig
+	// Group is the subject prefix of the invoke, describe and health endpoints
+	// (default: DefaultRuntimeGroup). Runtimes with different groups coexist on one
+	// connection; clients address them with ClientConfig.Group.
+	Group string
+	// Conn reuses an existing connection instead of dialing NATSURL (optional).
+	// The service does not close a shared connection.
+	Conn *nats.Conn
+	// ProfileTokenSHA256 enables the PROFILE endpoint for requests whose bearer token
+	// has this hex SHA-256 (optional, profiling is disabled without it)
+	ProfileTokenSHA256 string
+	// IntegrityCheck periodically verifies the binaries of registries implementing
+	// IntegrityVerifier (optional). One instance per registry is enough.
+	IntegrityCheck IntegrityCheckConfig
+	// Gossip announces the functions loaded on the instance, their load latencies and
+	// invocations to the runtime group, and preloads functions popular across the
+	// group (optional). Gossiping instances also serve InstanceInvokeSubject.
+	Gossip GossipConfig
+	// Subscriptions run functions as stream processors on the events of subjects (optional)
+	Subscriptions []FunctionSubscription
+	// Prefix is prefixed to the service name, the group, the state and claim check
+	// buckets and the queue groups of subscriptions, so runtimes of environments sharing
+	// a NATS cluster do not collide (optional, see naming.FromEnv)
+	Prefix naming.Prefix
+	// PluginSecret is the deployment's plugin handshake value, see
+	// PluginHandshakeWithSecret. Plugins built with another value, or none, refuse to
+	// start; it keeps deployments apart and is not a security control (optional).
+	PluginSecret string
+	// Builtins adds builtin function implementations by name, e.g. Go functions under
+	// test run in-process. They take precedence over the runtime's own builtins.
+	Builtins map[string]func(meta FunctionMeta) (Function, error)
 }
 
 // NewService creates a new function service
@@ -62,9 +211,13 @@ func NewService(js jetstream.JetStream, kv jetstream.KeyValue, store jetstream.O
 
 // NewRuntimeService creates a new runtime service using NATS Service API
 func NewRuntimeService(cfg RuntimeServiceConfig) (*RuntimeService, error) {
-	nc, err := nats.Connect(cfg.NATSURL)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	nc := cfg.Conn
+	if nc == nil {
+		var err error
+		nc, err = nats.Connect(cfg.NATSURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+		}
 	}
 
 	if cfg.ServiceName == "" {
@@ -76,41 +229,220 @@ func NewRuntimeService(cfg RuntimeServiceConfig) (*RuntimeService, error) {
 	if cfg.Description == "" {
 		cfg.Description = "Serverless function runtime service"
 	}
+	if cfg.ResultSubject == "" {
+		cfg.ResultSubject = DefaultResultSubject
+	}
+	if cfg.Group == "" {
+		cfg.Group = DefaultRuntimeGroup
+	}
+	cfg.Prefix.Apply(&cfg.ServiceName, &cfg.Group, &cfg.StateBucket, &cfg.FlagBucket)
+	if cfg.ClaimCheck != nil {
+		claimCheck := *cfg.ClaimCheck
+		if claimCheck.Bucket == "" {
+			claimCheck.Bucket = event.DefaultClaimCheckBucket
+		}
+		claimCheck.Bucket = cfg.Prefix.Name(claimCheck.Bucket)
+		cfg.ClaimCheck = &claimCheck
+	}
+	subscriptions := make([]FunctionSubscription, len(cfg.Subscriptions))
+	for i, s := range cfg.Subscriptions {
+		s.QueueGroup = cfg.Prefix.Name(s.QueueGroup)
+		subscriptions[i] = s
+	}
+	cfg.Subscriptions = subscriptions
 
 	rs := &RuntimeService{
-		natsConn: nc,
-		registry: cfg.Registry,
-		plugins:  make(map[string]Plugin),
-		metrics:  cfg.Metrics,
-		logger:   cfg.Logger,
+		natsConn:      nc,
+		registry:      cfg.Registry,
+		plugins:       make(map[string]Plugin),
+		metas:         make(map[string]FunctionMeta),
+		loaded:        make(map[string]LoadedFunction),
+		metrics:       cfg.Metrics,
+		logger:        cfg.Logger,
+		logs:          newFunctionLogs(cfg.LogBufferLines),
+		bulkheads:     newBulkheads(cfg.MaxConcurrentInvocations, cfg.FunctionConcurrency),
+		watchdog:      withWatchdogDefaults(cfg.Watchdog),
+		integrity:     cfg.IntegrityCheck,
+		dropRejected:  cfg.DropRejectedEvents,
+		timeout:       cfg.FunctionTimeout,
+		schemas:       cfg.Schemas,
+		mirror:        newMirror(nc, cfg.Mirror, cfg.Logger),
+		resultSubject: cfg.ResultSubject,
+		streamResults: cfg.StreamResults,
+		reservations:  reservations{capacity: cfg.Capacity},
+		group:         cfg.Group,
+		ownsConn:      cfg.Conn == nil,
+		builtins:      cfg.Builtins,
+		pluginSecret:  cfg.PluginSecret,
+		maxVersions:   cfg.MaxVersionedFunctions,
+		versionIdle:   cfg.VersionIdleTimeout,
 	}
 
 	// Create the NATS service
 	serviceConfig := micro.Config{
-		Name:        cfg.ServiceName,
-		Version:     cfg.Version,
-		Description: cfg.Description,
+		Name:         cfg.ServiceName,
+		Version:      cfg.Version,
+		Description:  cfg.Description,
+		StatsHandler: rs.serviceStats,
 	}
 
 	service, err := micro.AddService(nc, serviceConfig)
 	if err != nil {
-		nc.Close()
+		rs.closeConn()
 		return nil, fmt.Errorf("failed to create NATS service: %w", err)
 	}
 
 	rs.service = service
 
-	// Add the function execution endpoint
-	err = service.AddEndpoint("invoke", micro.HandlerFunc(rs.handleFunctionInvocation),
-		micro.WithEndpointSubject("function.invoke"),
-		micro.WithEndpointMetadata(map[string]string{
-			"description": "Execute a serverless function with CloudEvents",
-			"format":      "application/json",
-		}))
+	// Provision the function state and flag buckets and claim check and persist results;
+	// they need JetStream
+	if cfg.StateBucket != "" || cfg.FlagBucket != "" || cfg.ClaimCheck != nil || cfg.PersistResults {
+		mode, err := event.ResolveMode(nc, cfg.Mode)
+		if err != nil {
+			service.Stop()
+			rs.closeConn()
+			return nil, err
+		}
+		if mode == event.ModeCore {
+			if rs.logger != nil && cfg.StateBucket != "" {
+				rs.logger.Info("Function state is unavailable in core mode", Field{Key: "bucket", Value: cfg.StateBucket})
+			}
+			if rs.logger != nil && cfg.FlagBucket != "" {
+				rs.logger.Info("Feature flags are unavailable in core mode", Field{Key: "bucket", Value: cfg.FlagBucket})
+			}
+			if rs.logger != nil && cfg.ClaimCheck != nil {
+				rs.logger.Info("Claim check is unavailable in core mode", Field{Key: "bucket", Value: cfg.ClaimCheck.Bucket})
+			}
+			if rs.logger != nil && cfg.PersistResults {
+				rs.logger.Info("Results are published without acknowledgement in core mode")
+			}
+			cfg.StateBucket = ""
+			cfg.FlagBucket = ""
+			cfg.ClaimCheck = nil
+			cfg.PersistResults = false
+		}
+	}
+	rs.claims, err = newClaimCheck(nc, cfg.ClaimCheck)
 	if err != nil {
 		service.Stop()
-		nc.Close()
-		return nil, fmt.Errorf("failed to add invoke endpoint: %w", err)
+		rs.closeConn()
+		return nil, err
+	}
+	if cfg.StateBucket != "" {
+		js, err := jetstream.New(nc)
+		if err != nil {
+			service.Stop()
+			rs.closeConn()
+			return nil, fmt.Errorf("failed to create jetstream: %w", err)
+		}
+		rs.stateKV, err = js.CreateOrUpdateKeyValue(context.Background(), jetstream.KeyValueConfig{
+			Bucket: cfg.StateBucket,
+		})
+		if err != nil {
+			service.Stop()
+			rs.closeConn()
+			return nil, fmt.Errorf("failed to create state bucket: %w", err)
+		}
+	}
+	if cfg.FlagBucket != "" {
+		js, err := jetstream.New(nc)
+		if err != nil {
+			service.Stop()
+			rs.closeConn()
+			return nil, fmt.Errorf("failed to create jetstream: %w", err)
+		}
+		kv, err := js.CreateOrUpdateKeyValue(context.Background(), jetstream.KeyValueConfig{
+			Bucket: cfg.FlagBucket,
+		})
+		if err != nil {
+			service.Stop()
+			rs.closeConn()
+			return nil, fmt.Errorf("failed to create flag bucket: %w", err)
+		}
+		rs.flags, err = NewKVFlagProvider(context.Background(), kv, cfg.Metrics)
+		if err != nil {
+			service.Stop()
+			rs.closeConn()
+			return nil, err
+		}
+	}
+	if cfg.PersistResults {
+		rs.resultJS, err = jetstream.New(nc)
+		if err != nil {
+			service.Stop()
+			rs.closeConn()
+			return nil, fmt.Errorf("failed to create jetstream: %w", err)
+		}
+	}
+
+	// Add the invoke, describe and health endpoints under the runtime's group
+	if err := rs.addGroupEndpoints(); err != nil {
+		service.Stop()
+		rs.closeConn()
+		return nil, fmt.Errorf("failed to add %s endpoints: %w", cfg.Group, err)
+	}
+
+	// Add the endpoints listing the functions loaded on each instance
+	if err := rs.addFunctionsEndpoints(); err != nil {
+		service.Stop()
+		rs.closeConn()
+		return nil, fmt.Errorf("failed to add functions endpoint: %w", err)
+	}
+
+	// Add the endpoints pinning functions to a version
+	if err := rs.addPinEndpoints(); err != nil {
+		service.Stop()
+		rs.closeConn()
+		return nil, fmt.Errorf("failed to add pin endpoint: %w", err)
+	}
+
+	// Add the endpoint returning the recent output of functions' plugin processes
+	if err := rs.addLogsEndpoint(); err != nil {
+		service.Stop()
+		rs.closeConn()
+		return nil, fmt.Errorf("failed to add logs endpoint: %w", err)
+	}
+
+	// Add the endpoint taking the instance out of rotation
+	if err := rs.addCordonEndpoint(); err != nil {
+		service.Stop()
+		rs.closeConn()
+		return nil, fmt.Errorf("failed to add cordon endpoint: %w", err)
+	}
+
+	// Add the endpoint profiling the instance, for admins only
+	if cfg.ProfileTokenSHA256 != "" {
+		if err := rs.addProfileEndpoint(cfg.ProfileTokenSHA256); err != nil {
+			service.Stop()
+			rs.closeConn()
+			return nil, fmt.Errorf("failed to add profile endpoint: %w", err)
+		}
+	}
+
+	// Follow the gossip of the runtime group
+	if cfg.Gossip.Interval > 0 {
+		if err := rs.startGossip(cfg.Gossip); err != nil {
+			service.Stop()
+			rs.closeConn()
+			return nil, fmt.Errorf("failed to start gossip: %w", err)
+		}
+	}
+
+	// Run subscribed functions on the events of their subjects
+	rs.subscriptionConfigs = cfg.Subscriptions
+	if err := rs.subscribe(cfg.Subscriptions); err != nil {
+		rs.unsubscribe()
+		rs.stopGossip()
+		service.Stop()
+		rs.closeConn()
+		return nil, err
+	}
+
+	// Make sure the endpoint subscriptions reached the server before the service is used
+	if err := nc.Flush(); err != nil {
+		service.Stop()
+		rs.closeConn()
+		return nil, fmt.Errorf("failed to flush service subscriptions: %w", err)
 	}
 
 	return rs, nil
@@ -225,6 +557,20 @@ func (s *Service) Stop() {
 
 // Start starts the runtime service
 func (rs *RuntimeService) Start() error {
+	rs.mu.Lock()
+	if rs.stopCh == nil {
+		rs.stopCh = make(chan struct{})
+		go rs.runWatchdog(rs.stopCh)
+		go rs.runVersionEviction(rs.stopCh)
+		if verifier, ok := rs.registry.(IntegrityVerifier); ok && rs.integrity.Interval > 0 {
+			go rs.runIntegrityChecks(verifier, rs.integrity, rs.stopCh)
+		}
+		if rs.gossip != nil {
+			go rs.runGossip(rs.stopCh)
+		}
+	}
+	rs.mu.Unlock()
+
 	rs.logger.Info("Runtime service started",
 		Field{Key: "serviceName", Value: rs.service.Info().Name},
 		Field{Key: "version", Value: rs.service.Info().Version})
@@ -233,21 +579,59 @@ func (rs *RuntimeService) Start() error {
 
 // Stop stops the runtime service
 func (rs *RuntimeService) Stop() error {
+	rs.unsubscribe()
+	rs.stopGossip()
+	if rs.flags != nil {
+		rs.flags.Stop()
+	}
 	if rs.service != nil {
 		rs.service.Stop()
 	}
-	if rs.natsConn != nil {
-		rs.natsConn.Close()
+
+	rs.mu.Lock()
+	if rs.stopCh != nil {
+		close(rs.stopCh)
+		rs.stopCh = nil
 	}
+	rs.mu.Unlock()
+
+	// Shut down plugin processes and clean up their staged binaries
+	rs.mu.Lock()
+	for name, plugin := range rs.plugins {
+		if closer, ok := plugin.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				rs.logger.Error("Failed to close plugin",
+					Field{Key: "functionName", Value: name},
+					Field{Key: "error", Value: err})
+			}
+		}
+	}
+	rs.mu.Unlock()
+
+	rs.closeConn()
 	rs.logger.Info("Runtime service stopped")
 	return nil
 }
 
+// InstanceID returns the ID of the instance in per-instance subjects such as PinSubject
+func (rs *RuntimeService) InstanceID() string {
+	return rs.service.Info().ID
+}
+
+// closeConn closes the connection of the service unless it is shared
+func (rs *RuntimeService) closeConn() {
+	if rs.natsConn != nil && rs.ownsConn {
+		rs.natsConn.Close()
+	}
+}
+
 // handleFunctionInvocation handles function invocation requests via NATS Service API
 func (rs *RuntimeService) handleFunctionInvocation(req micro.Request) {
 	var request struct {
-		FunctionName string    `json:"functionName"`
-		Event        *ce.Event `json:"event"`
+		FunctionName string          `json:"functionName"`
+		Event        json.RawMessage `json:"event"`
+		// Budget is the time the caller waits for the response
+		Budget time.Duration `json:"budget,omitempty"`
 	}
 
 	if err := json.Unmarshal(req.Data(), &request); err != nil {
@@ -255,39 +639,285 @@ func (rs *RuntimeService) handleFunctionInvocation(req micro.Request) {
 		rs.respondWithError(req, "invalid_request", err)
 		return
 	}
+	// name@latest is the plain name, so both share the current version's plugin
+	request.FunctionName = FunctionRef(ParseFunctionRef(request.FunctionName))
 
-	// Get the function plugin
-	plugin, err := rs.getPlugin(request.FunctionName)
+	// Reject events breaking the spec with the list of their violations
+	invocationEvent, err := decodeInvocationEvent(request.Event)
 	if err != nil {
-		rs.logger.Error("Failed to get function plugin",
+		rs.metrics.RecordFunctionError(request.FunctionName, "invalid_event")
+		rs.logger.Error("Rejected invalid event",
+			Field{Key: "functionName", Value: request.FunctionName},
+			Field{Key: "error", Value: err})
+		rs.respondWithError(req, "invalid_event", err)
+		return
+	}
+
+	// Cordoned instances leave invocations to the other instances of the group
+	if rs.cordoned.Load() {
+		rs.handBack(req)
+		return
+	}
+
+	// Mirror sampled invocations to the debug subject once they are answered
+	req = rs.mirror.wrap(req, request.FunctionName, invocationEvent)
+
+	// Reserve an execution slot in the function's bulkhead
+	bh := rs.getBulkhead(request.FunctionName)
+	if !bh.tryAcquire() {
+		rs.metrics.RecordFunctionError(request.FunctionName, "concurrency_limit_exceeded")
+		rs.logger.Error("Function concurrency limit reached",
 			Field{Key: "functionName", Value: request.FunctionName},
+			Field{Key: "inFlight", Value: bh.inFlight()})
+		rs.respondWithError(req, "concurrency_limit_exceeded", ErrFunctionSaturated)
+		return
+	}
+
+	// Execute outside the endpoint handler so a slow function does not block other functions
+	go rs.runInvocation(bh, false, &invocationRequest{Request: req}, request.FunctionName, invocationEvent, request.Budget)
+}
+
+// runInvocation executes an invocation holding a slot of bh and releases it. A slot of
+// the partition shared by functions that are not loaded is only held while the function
+// loads; it then runs in a slot of its own partition, waiting for one when wait is set
+// and rejecting the invocation otherwise, so a hanging cold function cannot block the
+// others.
+func (rs *RuntimeService) runInvocation(bh *bulkhead, wait bool, req *invocationRequest, functionName string, event *ce.Event, budget time.Duration) {
+	if rs.getBulkheads().isShared(bh) {
+		_, err := rs.getPlugin(functionName)
+		bh.release()
+		if err != nil {
+			rs.respondPluginError(req, functionName, err)
+			return
+		}
+		bh = rs.getBulkhead(functionName)
+		if wait {
+			bh.acquire()
+		} else if !bh.tryAcquire() {
+			rs.metrics.RecordFunctionError(functionName, "concurrency_limit_exceeded")
+			rs.respondWithError(req, "concurrency_limit_exceeded", ErrFunctionSaturated)
+			return
+		}
+	}
+	defer bh.release()
+	rs.executeInvocation(req, functionName, event, budget)
+}
+
+// decodeInvocationEvent validates and parses the event of an invocation request
+func decodeInvocationEvent(data json.RawMessage) (*ce.Event, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, event.ValidateEvent(nil)
+	}
+	if err := event.ValidateEventJSON(data); err != nil {
+		return nil, err
+	}
+	decoded := ce.NewEvent()
+	if err := decoded.UnmarshalJSON(data); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal event: %w", err)
+	}
+	return &decoded, nil
+}
+
+// executeInvocation runs a function and responds to the invocation request. The
+// function's deadline is the caller's budget or the watchdog's hard ceiling, whichever
+// comes first.
+func (rs *RuntimeService) executeInvocation(req *invocationRequest, functionName string, event *ce.Event, budget time.Duration) {
+	// Track the invocation so the watchdog can report or cancel it if it hangs
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	inv := &invocation{
+		id:           uuid.NewString(),
+		functionName: functionName,
+		started:      time.Now(),
+		cancel:       cancel,
+		req:          req,
+	}
+	if event != nil {
+		inv.eventID = event.ID()
+	}
+	id := rs.inFlight.start(inv)
+	defer rs.inFlight.finish(id)
+
+	// The watchdog still enforces the hard ceiling, so it only bounds the reported deadline
+	if budget > 0 {
+		var cancelBudget context.CancelFunc
+		ctx, cancelBudget = context.WithDeadline(ctx, inv.started.Add(budget))
+		defer cancelBudget()
+	}
+	ctx = withBudget(ctx, invocationDeadline(inv.started, budget, rs.watchdog.HardCeiling),
+		func(progress string) { rs.inFlight.heartbeat(id, progress, time.Now()) })
+
+	// Get the function plugin
+	plugin, err := rs.getPlugin(functionName)
+	if err != nil {
+		rs.respondPluginError(req, functionName, err)
+		return
+	}
+
+	// Cancel runaway functions once their timeout elapses and answer the caller right
+	// away, also for plugins that do not receive the context
+	timeout := rs.functionTimeout(functionName)
+	timeoutErr := fmt.Errorf("%w after %s", ErrFunctionTimeout, timeout)
+	if timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeoutCause(ctx, timeout, ErrFunctionTimeout)
+		defer cancelTimeout()
+		stop := context.AfterFunc(ctx, func() {
+			if errors.Is(context.Cause(ctx), ErrFunctionTimeout) {
+				rs.respondWithError(req, "timeout", timeoutErr)
+			}
+		})
+		defer stop()
+	}
+
+	// Fetch the data of claim-checked events from the object store
+	if err := resolveEvents(rs.claims, []*ce.Event{event}); err != nil {
+		rs.metrics.RecordFunctionError(functionName, "claim_check_error")
+		rs.logger.Error("Failed to resolve claim-checked event",
+			Field{Key: "functionName", Value: functionName},
+			Field{Key: "error", Value: err})
+		rs.respondWithError(req, "claim_check_error", err)
+		return
+	}
+
+	// Reject events outside the function's declared event types and sources
+	if err := rs.getFunctionMeta(functionName).AcceptsEvent(event); err != nil {
+		if rs.dropRejected {
+			rs.metrics.RecordFunctionInvocation(functionName, 0, "dropped")
+			rs.respondWithEvents(req, nil, "")
+			return
+		}
+		rs.metrics.RecordFunctionError(functionName, "event_rejected")
+		rs.logger.Error("Function rejected event",
+			Field{Key: "functionName", Value: functionName},
+			Field{Key: "error", Value: err})
+		rs.respondWithError(req, "event_rejected", err)
+		return
+	}
+
+	// Reject event data that does not match the schema of its event type
+	if err := rs.validateEventData(ctx, functionName, event); err != nil {
+		rs.metrics.RecordFunctionError(functionName, "invalid_event_data")
+		rs.logger.Error("Event data failed schema validation",
+			Field{Key: "functionName", Value: functionName},
 			Field{Key: "error", Value: err})
-		rs.respondWithError(req, "plugin_not_found", err)
+		rs.respondWithError(req, "invalid_event_data", err)
 		return
 	}
 
-	// Execute the function
+	// Attach the function's scoped state store and feature flags, shared by all of its versions
+	name, _ := ParseFunctionRef(functionName)
+	if rs.stateKV != nil {
+		ctx = WithState(ctx, NewKVStateStore(rs.stateKV, name))
+	}
+	if rs.flags != nil {
+		ctx = WithFlags(ctx, rs.flags.For(name))
+	}
+
+	// Execute the function, labeled for CPU profiles
+	var events []*ce.Event
 	start := time.Now()
-	events, err := plugin.Function().Execute(context.Background(), request.Event)
+	pprof.Do(ctx, pprof.Labels(profiling.LabelFunction, functionName), func(ctx context.Context) {
+		events, err = plugin.Function().Execute(ctx, event)
+	})
 	duration := time.Since(start)
 
+	if errors.Is(context.Cause(ctx), ErrFunctionTimeout) {
+		rs.metrics.RecordFunctionError(functionName, "timeout")
+		rs.logger.Error("Function execution timed out",
+			Field{Key: "functionName", Value: functionName},
+			Field{Key: "error", Value: timeoutErr})
+		rs.respondWithError(req, "timeout", timeoutErr)
+		return
+	}
+
+	// Partial results are answered like complete ones, marked with their reason
+	status := "success"
+	var partial partialResult
+	if errors.As(err, &partial) {
+		status, err = "partial", nil
+	}
 	if err != nil {
-		rs.metrics.RecordFunctionError(request.FunctionName, "execution_error")
+		rs.metrics.RecordFunctionError(functionName, "execution_error")
 		rs.logger.Error("Function execution failed",
-			Field{Key: "functionName", Value: request.FunctionName},
+			Field{Key: "functionName", Value: functionName},
 			Field{Key: "error", Value: err})
 		rs.respondWithError(req, "execution_error", err)
 		return
 	}
 
 	// Record metrics
-	rs.metrics.RecordFunctionInvocation(request.FunctionName, duration, "success")
+	rs.metrics.RecordFunctionInvocation(functionName, duration, status)
+	rs.gossip.recordInvocation(functionName)
+
+	// Let consumers join the response events with the request, and trace their lineage
+	for _, response := range events {
+		SetCorrelation(response, event, inv.id)
+		setLineage(response, event, functionName)
+	}
+
+	// Keep large output events below the server's max payload
+	events, err = offloadEvents(rs.claims, events)
+	if err != nil {
+		rs.metrics.RecordFunctionError(functionName, "claim_check_error")
+		rs.logger.Error("Failed to offload output events",
+			Field{Key: "functionName", Value: functionName},
+			Field{Key: "error", Value: err})
+		rs.respondWithError(req, "claim_check_error", err)
+		return
+	}
+
+	// Publish the output for asynchronous callers and result subscribers
+	if req.Reply() == "" || rs.streamResults {
+		rs.publishResults(name, events, req.Reply() == "")
+	}
 
 	// Send response
+	var partialReason string
+	if partial != nil {
+		partialReason = partial.PartialReason()
+	}
+	rs.respondWithEvents(req, events, partialReason)
+}
+
+// validateEventData checks event data against the schema registered for the event type.
+// Events without a registered schema pass.
+func (rs *RuntimeService) validateEventData(ctx context.Context, functionName string, event *ce.Event) error {
+	if rs.schemas == nil || event == nil {
+		return nil
+	}
+	schema, err := rs.schemas.GetSchema(ctx, event.Type())
+	if errors.Is(err, ErrSchemaNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if violations := schema.ValidateEventData(event); len(violations) > 0 {
+		return &InvalidEventDataError{
+			FunctionName: functionName,
+			EventType:    event.Type(),
+			Violations:   violations,
+		}
+	}
+	return nil
+}
+
+// respondWithEvents sends the events produced by an invocation
+func (rs *RuntimeService) respondWithEvents(req micro.Request, events []*ce.Event, partial string) {
+	// Asynchronous invocations have nobody to answer
+	if req.Reply() == "" {
+		return
+	}
+
 	response := struct {
 		Events []*ce.Event `json:"events"`
+		// Partial is the reason the function returned a partial result
+		Partial string `json:"partial,omitempty"`
 	}{
-		Events: events,
+		Events:  events,
+		Partial: partial,
 	}
 
 	responseData, err := json.Marshal(response)
@@ -302,57 +932,140 @@ func (rs *RuntimeService) handleFunctionInvocation(req micro.Request) {
 	}
 }
 
-// getPlugin returns a function plugin by name
+// respondPluginError answers an invocation whose function could not be loaded
+func (rs *RuntimeService) respondPluginError(req micro.Request, functionName string, err error) {
+	rs.logger.Error("Failed to get function plugin",
+		Field{Key: "functionName", Value: functionName},
+		Field{Key: "error", Value: err})
+	errorType := "plugin_not_found"
+	if errors.Is(err, ErrInsufficientCapacity) {
+		errorType = "insufficient_capacity"
+	}
+	rs.respondWithError(req, errorType, err)
+}
+
+// getBulkhead returns the execution bulkhead for a function
+func (rs *RuntimeService) getBulkhead(name string) *bulkhead {
+	rs.mu.RLock()
+	_, loaded := rs.plugins[name]
+	rs.mu.RUnlock()
+	return rs.getBulkheads().get(name, loaded)
+}
+
+// getBulkheads returns the bulkheads of all functions
+func (rs *RuntimeService) getBulkheads() *bulkheads {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.bulkheads == nil {
+		rs.bulkheads = newBulkheads(DefaultMaxConcurrentInvocations, nil)
+	}
+	return rs.bulkheads
+}
+
+// getPlugin returns the plugin of a function name, or of a name@version reference.
+// Each version invoked by reference is loaded alongside the current one.
 func (rs *RuntimeService) getPlugin(name string) (Plugin, error) {
 	rs.mu.RLock()
 	plugin, exists := rs.plugins[name]
 	rs.mu.RUnlock()
 
+	if exists {
+		rs.touchVersion(name)
+		return plugin, nil
+	}
+
+	// Concurrent cold invocations wait for the first one to load the function
+	unlock := rs.lockLoad(name)
+	defer unlock()
+	rs.mu.RLock()
+	plugin, exists = rs.plugins[name]
+	rs.mu.RUnlock()
 	if exists {
 		return plugin, nil
 	}
 
 	// Load the function from registry
-	meta, binary, err := rs.registry.GetFunction(name)
+	start := time.Now()
+	meta, binary, err := rs.fetchFunction(name)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get function from registry: %w", err)
+		return nil, err
+	}
+
+	// Admit the function only if its resource reservation fits the instance
+	resources, err := meta.Resources()
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := meta.Timeout(); err != nil {
+		return nil, err
+	}
+	if err := rs.reservations.reserve(name, resources); err != nil {
+		return nil, err
 	}
 
 	// Load the plugin
 	plugin, err = rs.loadPlugin(meta, binary)
 	if err != nil {
+		rs.reservations.release(name)
 		return nil, fmt.Errorf("failed to load plugin: %w", err)
 	}
 
 	// Store the plugin
-	rs.mu.Lock()
-	rs.plugins[name] = plugin
-	rs.mu.Unlock()
+	if old := rs.install(name, meta, binary, plugin); old != nil {
+		go rs.retirePlugin(name, old)
+	}
+	rs.gossip.recordLoad(meta, time.Since(start))
+
+	// Versions loaded by reference are bounded, so callers cannot make the instance
+	// hold every retained version
+	if _, version := ParseFunctionRef(name); version != "" {
+		rs.touchVersion(name)
+		rs.evictVersions(name)
+	}
 
 	return plugin, nil
 }
 
+// getFunctionMeta returns the registry metadata of a loaded function.
+// Functions registered without metadata accept every event.
+func (rs *RuntimeService) getFunctionMeta(name string) FunctionMeta {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return rs.metas[name]
+}
+
 // loadPlugin loads a function plugin
 func (rs *RuntimeService) loadPlugin(meta FunctionMeta, binary []byte) (Plugin, error) {
+	if newFunction, ok := rs.builtins[builtinName(meta)]; ok && meta.Type == TypeBuiltin {
+		return newBuiltin(meta, newFunction)
+	}
+	return loadFunction(meta, binary, rs.pluginOutput, rs.pluginSecret)
+}
+
+// loadFunction loads a function of any supported type; output receives the logs of
+// plugin processes and JavaScript consoles and may be nil, secret is the handshake
+// secret plugins are started with
+func loadFunction(meta FunctionMeta, binary []byte, output func(functionName, stream string) io.Writer, secret string) (Plugin, error) {
 	// For MVP, support built-in functions and basic plugin types
 	switch meta.Type {
-	case "builtin":
-		// For built-in functions, we expect them to be pre-registered
-		// This is a simple implementation for MVP
-		if meta.Name == "example" {
-			exampleFunc := &ExampleFunction{name: meta.Name}
-			return &ExamplePlugin{
-				meta: meta,
-				fn:   exampleFunc,
-			}, nil
-		}
-		return nil, fmt.Errorf("built-in function %s not found", meta.Name)
+	case TypeBuiltin:
+		return loadBuiltin(meta)
 
 	case "hashicorp-plugin":
 		// For HashiCorp plugins, use the plugin manager
 		pluginManager := NewPluginManager()
+		if output != nil {
+			pluginManager.SetOutput(output)
+		}
+		pluginManager.SetHandshakeSecret(secret)
 		return pluginManager.LoadPlugin(meta, binary)
 
+	case TypeScript:
+		return loadScript(meta, binary)
+
+	case TypeJavaScript:
+		return loadJavaScript(meta, binary, output)
+
 	default:
 		return nil, fmt.Errorf("unsupported plugin type: %s", meta.Type)
 	}
@@ -360,13 +1073,22 @@ func (rs *RuntimeService) loadPlugin(meta FunctionMeta, binary []byte) (Plugin,
 
 // respondWithError sends an error response
 func (rs *RuntimeService) respondWithError(req micro.Request, errorType string, err error) {
+	if req.Reply() == "" {
+		return
+	}
+
 	response := struct {
-		Error     string `json:"error"`
-		ErrorType string `json:"errorType"`
+		Error      string            `json:"error"`
+		ErrorType  string            `json:"errorType"`
+		Violations []event.Violation `json:"violations,omitempty"`
 	}{
 		Error:     err.Error(),
 		ErrorType: errorType,
 	}
+	var invalid *event.InvalidEventError
+	if errors.As(err, &invalid) {
+		response.Violations = invalid.Violations
+	}
 
 	responseData, marshalErr := json.Marshal(response)
 	if marshalErr != nil {
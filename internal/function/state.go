@@ -0,0 +1,142 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// DefaultStateBucket is the KV bucket used for function state
+const DefaultStateBucket = "function-state"
+
+var (
+	// ErrStateKeyNotFound is returned when a state key does not exist
+	ErrStateKeyNotFound = errors.New("state key not found")
+	// ErrStateConflict is returned when a compare-and-swap finds an unexpected revision
+	ErrStateConflict = errors.New("state revision conflict")
+	// ErrStateUnavailable is returned when no state store is attached to the invocation
+	ErrStateUnavailable = errors.New("function state store not available")
+)
+
+// StateStore is a key-value store scoped to a single function
+type StateStore interface {
+	// Get returns the value and revision stored under key
+	Get(ctx context.Context, key string) ([]byte, uint64, error)
+	// Put stores value under key and returns the new revision
+	Put(ctx context.Context, key string, value []byte) (uint64, error)
+	// Delete removes key
+	Delete(ctx context.Context, key string) error
+	// CompareAndSwap stores value only if key is at the expected revision.
+	// A revision of 0 means the key must not exist yet.
+	CompareAndSwap(ctx context.Context, key string, value []byte, revision uint64) (uint64, error)
+}
+
+type stateContextKey struct{}
+
+// WithState returns a context carrying the function's state store
+func WithState(ctx context.Context, state StateStore) context.Context {
+	return context.WithValue(ctx, stateContextKey{}, state)
+}
+
+// StateFromContext returns the state store of the invoked function.
+// Functions call this from Execute to read and write their own state. Builtin
+// functions use the runtime's store directly; go-plugin functions get a client of the
+// runtime's host service, which serves the store of the invocation while it runs.
+func StateFromContext(ctx context.Context) (StateStore, error) {
+	state, ok := ctx.Value(stateContextKey{}).(StateStore)
+	if !ok || state == nil {
+		return nil, ErrStateUnavailable
+	}
+	return state, nil
+}
+
+// KVStateStore implements StateStore on a JetStream KV bucket,
+// prefixing every key with the function name
+type KVStateStore struct {
+	kv     jetstream.KeyValue
+	prefix string
+}
+
+// NewKVStateStore creates a state store for the named function
+func NewKVStateStore(kv jetstream.KeyValue, functionName string) *KVStateStore {
+	return &KVStateStore{
+		kv:     kv,
+		prefix: functionName + ".",
+	}
+}
+
+func (s *KVStateStore) key(key string) (string, error) {
+	if key == "" || strings.ContainsAny(key, "*> ") {
+		return "", fmt.Errorf("invalid state key %q", key)
+	}
+	return s.prefix + key, nil
+}
+
+// Get returns the value and revision stored under key
+func (s *KVStateStore) Get(ctx context.Context, key string) ([]byte, uint64, error) {
+	k, err := s.key(key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	entry, err := s.kv.Get(ctx, k)
+	if err != nil {
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			return nil, 0, ErrStateKeyNotFound
+		}
+		return nil, 0, fmt.Errorf("failed to get state: %w", err)
+	}
+	return entry.Value(), entry.Revision(), nil
+}
+
+// Put stores value under key and returns the new revision
+func (s *KVStateStore) Put(ctx context.Context, key string, value []byte) (uint64, error) {
+	k, err := s.key(key)
+	if err != nil {
+		return 0, err
+	}
+
+	revision, err := s.kv.Put(ctx, k, value)
+	if err != nil {
+		return 0, fmt.Errorf("failed to put state: %w", err)
+	}
+	return revision, nil
+}
+
+// Delete removes key
+func (s *KVStateStore) Delete(ctx context.Context, key string) error {
+	k, err := s.key(key)
+	if err != nil {
+		return err
+	}
+
+	if err := s.kv.Delete(ctx, k); err != nil {
+		return fmt.Errorf("failed to delete state: %w", err)
+	}
+	return nil
+}
+
+// CompareAndSwap stores value only if key is at the expected revision
+func (s *KVStateStore) CompareAndSwap(ctx context.Context, key string, value []byte, revision uint64) (uint64, error) {
+	k, err := s.key(key)
+	if err != nil {
+		return 0, err
+	}
+
+	var newRevision uint64
+	if revision == 0 {
+		newRevision, err = s.kv.Create(ctx, k, value)
+	} else {
+		newRevision, err = s.kv.Update(ctx, k, value, revision)
+	}
+	if err != nil {
+		if errors.Is(err, jetstream.ErrKeyExists) {
+			return 0, ErrStateConflict
+		}
+		return 0, fmt.Errorf("failed to swap state: %w", err)
+	}
+	return newRevision, nil
+}
@@ -0,0 +1,90 @@
+package function
+
+import (
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// FunctionSubscription runs a function as a stream processor on the CloudEvents
+// published to a subject, like an asynchronous invocation per event. Output events go
+// to the function's result subject, or to their route target, so a router function
+// subscribed to an inbound stream splits it into several subjects.
+type FunctionSubscription struct {
+	Subject  string
+	Function string
+	// QueueGroup spreads the events across the runtime instances subscribing with the
+	// same group (default: <runtime group>.<function>)
+	QueueGroup string
+}
+
+// subscriptionRequest is an event received by a function subscription, handled like
+// an asynchronous invocation without a reply subject
+type subscriptionRequest struct {
+	msg *nats.Msg
+}
+
+func (r *subscriptionRequest) Respond([]byte, ...micro.RespondOpt) error               { return nil }
+func (r *subscriptionRequest) RespondJSON(any, ...micro.RespondOpt) error              { return nil }
+func (r *subscriptionRequest) Data() []byte                                            { return r.msg.Data }
+func (r *subscriptionRequest) Headers() micro.Headers                                  { return micro.Headers(r.msg.Header) }
+func (r *subscriptionRequest) Subject() string                                         { return r.msg.Subject }
+func (r *subscriptionRequest) Reply() string                                           { return "" }
+func (r *subscriptionRequest) Error(string, string, []byte, ...micro.RespondOpt) error { return nil }
+
+// subscribe starts the function subscriptions of the runtime
+func (rs *RuntimeService) subscribe(subscriptions []FunctionSubscription) error {
+	for _, s := range subscriptions {
+		if s.Subject == "" || s.Function == "" {
+			return fmt.Errorf("function subscriptions need a subject and a function")
+		}
+		queue := s.QueueGroup
+		if queue == "" {
+			queue = rs.group + "." + s.Function
+		}
+		functionName := s.Function
+		sub, err := rs.natsConn.QueueSubscribe(s.Subject, queue, func(msg *nats.Msg) {
+			rs.handleSubscriptionEvent(functionName, msg)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to subscribe %s to %s: %w", s.Function, s.Subject, err)
+		}
+		rs.mu.Lock()
+		rs.subscriptions = append(rs.subscriptions, sub)
+		rs.mu.Unlock()
+	}
+	return nil
+}
+
+// handleSubscriptionEvent runs a subscribed function on an event. Events are processed
+// one at a time in the order of the subject, waiting for a free slot of the function's
+// bulkhead instead of dropping events while it is saturated.
+func (rs *RuntimeService) handleSubscriptionEvent(functionName string, msg *nats.Msg) {
+	invocationEvent, err := decodeInvocationEvent(msg.Data)
+	if err != nil {
+		rs.metrics.RecordFunctionError(functionName, "invalid_event")
+		rs.logger.Error("Rejected invalid event",
+			Field{Key: "functionName", Value: functionName},
+			Field{Key: "subject", Value: msg.Subject},
+			Field{Key: "error", Value: err})
+		return
+	}
+
+	bh := rs.getBulkhead(functionName)
+	bh.acquire()
+	rs.runInvocation(bh, true, &invocationRequest{Request: &subscriptionRequest{msg: msg}}, functionName, invocationEvent, 0)
+}
+
+// unsubscribe stops the function subscriptions of the runtime
+func (rs *RuntimeService) unsubscribe() {
+	rs.mu.Lock()
+	subscriptions := rs.subscriptions
+	rs.subscriptions = nil
+	rs.mu.Unlock()
+	for _, sub := range subscriptions {
+		if err := sub.Unsubscribe(); err != nil {
+			rs.logger.Error("Failed to unsubscribe function", Field{Key: "subject", Value: sub.Subject}, Field{Key: "error", Value: err})
+		}
+	}
+}
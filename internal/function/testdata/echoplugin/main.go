@@ -0,0 +1,44 @@
+// Command echoplugin is a function plugin returning the events it receives, used by
+// the plugin tests
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/julianshen/mycelium/pkg/function"
+
+	ce "github.com/cloudevents/sdk-go/v2"
+)
+
+type echo struct{}
+
+func (echo) Execute(ctx context.Context, event *ce.Event) ([]*ce.Event, error) {
+	switch event.Type() {
+	case "echo.fail":
+		return nil, errors.New("echo failed")
+	case "echo.state":
+		// Echo the event into the function's state and back out of it
+		state, err := function.StateFromContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := state.Put(ctx, "last", []byte(event.ID())); err != nil {
+			return nil, err
+		}
+		value, _, err := state.Get(ctx, "last")
+		if err != nil {
+			return nil, err
+		}
+		reply := event.Clone()
+		reply.SetID(string(value))
+		return []*ce.Event{&reply}, nil
+	}
+	reply := event.Clone()
+	reply.SetType("echo.reply")
+	return []*ce.Event{event, &reply}, nil
+}
+
+func main() {
+	function.Serve(echo{})
+}
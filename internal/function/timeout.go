@@ -0,0 +1,33 @@
+package function
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrFunctionTimeout is returned to callers when an invocation runs past the function's timeout
+var ErrFunctionTimeout = errors.New("function timed out")
+
+// Timeout parses the function's Config["timeout"], the time an invocation may run
+// before the runtime cancels it. ok is false for functions without a timeout.
+func (m FunctionMeta) Timeout() (timeout time.Duration, ok bool, err error) {
+	value := m.Config[ConfigTimeout]
+	if value == "" {
+		return 0, false, nil
+	}
+	timeout, err = time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return 0, false, fmt.Errorf("function %s: invalid timeout %q", m.Name, value)
+	}
+	return timeout, true, nil
+}
+
+// functionTimeout returns the timeout of a loaded function's invocations: its own
+// Config["timeout"] or the runtime's default, zero when neither is set
+func (rs *RuntimeService) functionTimeout(name string) time.Duration {
+	if timeout, ok, _ := rs.getFunctionMeta(name).Timeout(); ok {
+		return timeout
+	}
+	return rs.timeout
+}
@@ -0,0 +1,117 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	ce "github.com/cloudevents/sdk-go/v2"
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+	"github.com/google/uuid"
+)
+
+// BuiltinTransform is the builtin function mapping event data with an expr expression
+const BuiltinTransform = "transform"
+
+// Config keys of transform functions
+const (
+	ConfigTransformExpression = "expression" // expr expression returning the data of the response event
+	ConfigTransformType       = "type"       // Type of the response event (default: the type of the input event)
+	ConfigTransformSource     = "source"     // Source of the response event (default: the source of the input event)
+)
+
+// transformFunction evaluates an expression against every event and returns its result
+// as the data of a new event. An expression returning nil emits no event, so
+// transforms can also filter.
+type transformFunction struct {
+	name    string
+	program *vm.Program
+	typ     string
+	source  string
+}
+
+// newTransformFunction compiles the expression of a transform function's config
+func newTransformFunction(meta FunctionMeta) (Function, error) {
+	expression := meta.Config[ConfigTransformExpression]
+	if expression == "" {
+		return nil, fmt.Errorf("transform function %s: %s is required", meta.Name, ConfigTransformExpression)
+	}
+	program, err := expr.Compile(expression, expr.Env(transformEnv(nil, nil)))
+	if err != nil {
+		return nil, fmt.Errorf("transform function %s: invalid expression: %w", meta.Name, err)
+	}
+	return &transformFunction{
+		name:    meta.Name,
+		program: program,
+		typ:     meta.Config[ConfigTransformType],
+		source:  meta.Config[ConfigTransformSource],
+	}, nil
+}
+
+// transformVars are the variables of a transform expression. Data is untyped, so
+// expressions compile whatever shape the event data has.
+type transformVars struct {
+	Event map[string]interface{} `expr:"event"`
+	Data  interface{}            `expr:"data"`
+}
+
+// transformEnv returns the variables of a transform expression: the event's attributes,
+// extensions and parsed data as event, and its data as data
+func transformEnv(event *ce.Event, data interface{}) transformVars {
+	attributes := map[string]interface{}{
+		"id":         "",
+		"type":       "",
+		"source":     "",
+		"subject":    "",
+		"time":       "",
+		"extensions": map[string]interface{}{},
+		"data":       data,
+	}
+	if event != nil {
+		attributes["id"] = event.ID()
+		attributes["type"] = event.Type()
+		attributes["source"] = event.Source()
+		attributes["subject"] = event.Subject()
+		attributes["extensions"] = event.Extensions()
+		if !event.Time().IsZero() {
+			attributes["time"] = event.Time().Format(time.RFC3339Nano)
+		}
+	}
+	return transformVars{Event: attributes, Data: data}
+}
+
+// Execute evaluates the expression and returns its result as a new event
+func (f *transformFunction) Execute(ctx context.Context, event *ce.Event) ([]*ce.Event, error) {
+	var data interface{}
+	if len(event.Data()) > 0 {
+		if err := json.Unmarshal(event.Data(), &data); err != nil {
+			return nil, fmt.Errorf("transform function %s: event data must be JSON: %w", f.name, err)
+		}
+	}
+
+	result, err := expr.Run(f.program, transformEnv(event, data))
+	if err != nil {
+		return nil, fmt.Errorf("transform function %s: %w", f.name, err)
+	}
+	if result == nil {
+		return nil, nil
+	}
+
+	response := ce.NewEvent()
+	response.SetID(uuid.NewString())
+	response.SetType(event.Type())
+	response.SetSource(event.Source())
+	response.SetSubject(event.Subject())
+	if f.typ != "" {
+		response.SetType(f.typ)
+	}
+	if f.source != "" {
+		response.SetSource(f.source)
+	}
+	if err := response.SetData(ce.ApplicationJSON, result); err != nil {
+		return nil, fmt.Errorf("transform function %s: failed to set event data: %w", f.name, err)
+	}
+	return []*ce.Event{&response}, nil
+}
@@ -2,6 +2,7 @@ package function
 
 import (
 	"context"
+	"encoding/json"
 	"time"
 
 	ce "github.com/cloudevents/sdk-go/v2"
@@ -13,12 +14,28 @@ type FunctionMeta struct {
 	Type    string            `json:"type"`
 	Version string            `json:"version"`
 	Config  map[string]string `json:"config,omitempty"`
+	// EventTypes and EventSources restrict the events the function accepts ("*" wildcards allowed, empty accepts all)
+	EventTypes   []string `json:"eventTypes,omitempty"`
+	EventSources []string `json:"eventSources,omitempty"`
+	// Emits declares the event types the function returns, for event flow graphs
+	Emits []string `json:"emits,omitempty"`
+	// Digest is the SHA-256 of the function binary, set by registries that store binaries by content
+	Digest string `json:"digest,omitempty"`
+	// SchemaVersion is the serialization format version the metadata was stored in, set
+	// by registries when reading (see MetaSchemaVersion). Writes always use the current one.
+	SchemaVersion int `json:"schemaVersion,omitempty"`
+	// unknown holds stored fields this release does not know, written back unchanged
+	unknown map[string]json.RawMessage
 }
 
 // FunctionResult represents the result returned from a function
 type FunctionResult struct {
 	Event *ce.Event `json:"event"`
-	Error string    `json:"error,omitempty"`
+	// Events holds every event returned over plugin ABI v2 and later
+	Events []*ce.Event `json:"events,omitempty"`
+	Error  string      `json:"error,omitempty"`
+	// Partial is the reason of a partial result, see PartialResult
+	Partial string `json:"partial,omitempty"`
 }
 
 // Function represents the interface that all functions must implement
@@ -49,6 +66,8 @@ type Registry interface {
 	ListFunctions() ([]FunctionMeta, error)
 	// DeleteFunction removes a function
 	DeleteFunction(name string) error
+	// DeployFunctions stores a set of functions all-or-nothing
+	DeployFunctions(deployments []FunctionDeployment) error
 }
 
 // MetricsCollector defines the interface for collecting metrics
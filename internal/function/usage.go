@@ -0,0 +1,263 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Metadata keys of the binary object store holding the registry's quota
+const (
+	QuotaMaxFunctionsKey   = "mycelium.quota.max_functions"
+	QuotaMaxBinaryBytesKey = "mycelium.quota.max_binary_bytes"
+)
+
+// ErrQuotaExceeded is returned when storing functions would exceed the registry's quota
+var ErrQuotaExceeded = errors.New("registry quota exceeded")
+
+// StorageQuota limits what a registry, e.g. a namespace's, may store. Zero limits are unlimited.
+type StorageQuota struct {
+	MaxFunctions   int   `json:"max_functions,omitempty"`
+	MaxBinaryBytes int64 `json:"max_binary_bytes,omitempty"`
+}
+
+// Unlimited reports whether the quota sets no limit
+func (q StorageQuota) Unlimited() bool {
+	return q.MaxFunctions <= 0 && q.MaxBinaryBytes <= 0
+}
+
+// Metadata returns the quota as bucket metadata
+func (q StorageQuota) Metadata() map[string]string {
+	metadata := make(map[string]string)
+	if q.MaxFunctions > 0 {
+		metadata[QuotaMaxFunctionsKey] = strconv.Itoa(q.MaxFunctions)
+	}
+	if q.MaxBinaryBytes > 0 {
+		metadata[QuotaMaxBinaryBytesKey] = strconv.FormatInt(q.MaxBinaryBytes, 10)
+	}
+	return metadata
+}
+
+// ParseStorageQuota reads a quota from bucket metadata
+func ParseStorageQuota(metadata map[string]string) (StorageQuota, error) {
+	var q StorageQuota
+	var err error
+	if value, ok := metadata[QuotaMaxFunctionsKey]; ok {
+		if q.MaxFunctions, err = strconv.Atoi(value); err != nil {
+			return q, fmt.Errorf("invalid %s: %w", QuotaMaxFunctionsKey, err)
+		}
+	}
+	if value, ok := metadata[QuotaMaxBinaryBytesKey]; ok {
+		if q.MaxBinaryBytes, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return q, fmt.Errorf("invalid %s: %w", QuotaMaxBinaryBytesKey, err)
+		}
+	}
+	return q, nil
+}
+
+// StorageUsage is the storage consumed by a registry
+type StorageUsage struct {
+	Functions int `json:"functions"`
+	// Versions counts the retained revisions: the bucket history of the current versions
+	// and the other versions kept until they are retired
+	Versions int `json:"versions"`
+	// Binaries and BinaryBytes count the stored objects; binaries shared by functions count once
+	Binaries    int          `json:"binaries"`
+	BinaryBytes int64        `json:"binary_bytes"`
+	Quota       StorageQuota `json:"quota"`
+}
+
+// registryState is the function and binary layout a quota is checked against
+type registryState struct {
+	revisions map[string][]string // Function name to the objects of its history revisions, oldest first
+	retained  map[string][]string // Function name to the objects of its kept versions
+	sizes     map[string]int64    // Object name to size
+	history   int                 // Revisions kept per function
+	versions  int                 // Retained revisions across functions
+}
+
+// state reads which objects the retained revisions of each function reference and
+// the size of every object
+func (r *NATSRegistry) state(ctx context.Context) (*registryState, error) {
+	s := &registryState{revisions: make(map[string][]string), retained: make(map[string][]string), sizes: make(map[string]int64)}
+
+	history, err := r.history(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for name, metas := range history {
+		for _, meta := range metas {
+			s.revisions[name] = append(s.revisions[name], binaryObject(meta))
+		}
+		s.versions += len(metas)
+	}
+	stored, err := r.storedVersions(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	for name, metas := range stored {
+		for _, meta := range metas {
+			s.retained[name] = append(s.retained[name], binaryObject(meta))
+			if !containsRevision(history[name], meta) {
+				s.versions++
+			}
+		}
+	}
+
+	status, err := r.kv.Status(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get function bucket status: %w", err)
+	}
+	s.history = int(status.History())
+
+	objects, err := r.objectStore.List(ctx)
+	if err != nil && !errors.Is(err, jetstream.ErrNoObjectsFound) {
+		return nil, fmt.Errorf("failed to list binaries: %w", err)
+	}
+	for _, object := range objects {
+		s.sizes[object.Name] = int64(object.Size)
+	}
+	return s, nil
+}
+
+// binaryObject returns the object name of a function's binary
+func binaryObject(meta FunctionMeta) string {
+	// Functions stored before binaries were keyed by digest keep their binary under their name
+	if meta.Digest == "" {
+		return meta.Name
+	}
+	return binaryKey(meta.Digest)
+}
+
+// bytes returns the total size of the stored objects
+func (s *registryState) bytes() int64 {
+	var total int64
+	for _, size := range s.sizes {
+		total += size
+	}
+	return total
+}
+
+// referenced returns the objects referenced by a function-to-revisions map
+func referenced(revisions map[string][]string) map[string]bool {
+	objects := make(map[string]bool)
+	for _, list := range revisions {
+		for _, object := range list {
+			objects[object] = true
+		}
+	}
+	return objects
+}
+
+// Usage reports the storage the registry consumes and its quota
+func (r *NATSRegistry) Usage(ctx context.Context) (StorageUsage, error) {
+	var usage StorageUsage
+	s, err := r.state(ctx)
+	if err != nil {
+		return usage, err
+	}
+	usage.Functions = len(s.revisions)
+	usage.Versions = s.versions
+	usage.Binaries = len(s.sizes)
+	usage.BinaryBytes = s.bytes()
+
+	usage.Quota, err = r.Quota(ctx)
+	return usage, err
+}
+
+// Quota returns the quota recorded on the registry's binary object store
+func (r *NATSRegistry) Quota(ctx context.Context) (StorageQuota, error) {
+	status, err := r.objectStore.Status(ctx)
+	if err != nil {
+		return StorageQuota{}, fmt.Errorf("failed to get binary bucket status: %w", err)
+	}
+	return ParseStorageQuota(status.Metadata())
+}
+
+// SetQuota records a quota on the registry's binary object store, so every client
+// storing functions in the registry enforces it. A zero quota removes the limits.
+func (r *NATSRegistry) SetQuota(ctx context.Context, quota StorageQuota) error {
+	status, err := r.objectStore.Status(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get binary bucket status: %w", err)
+	}
+
+	// Update the backing stream so the rest of the bucket configuration is kept
+	stream, err := r.js.Stream(ctx, "OBJ_"+status.Bucket())
+	if err != nil {
+		return fmt.Errorf("failed to get binary bucket stream: %w", err)
+	}
+	cfg := stream.CachedInfo().Config
+	metadata := make(map[string]string)
+	for key, value := range cfg.Metadata {
+		if key != QuotaMaxFunctionsKey && key != QuotaMaxBinaryBytesKey {
+			metadata[key] = value
+		}
+	}
+	for key, value := range quota.Metadata() {
+		metadata[key] = value
+	}
+	cfg.Metadata = metadata
+	if _, err := r.js.UpdateStream(ctx, cfg); err != nil {
+		return fmt.Errorf("failed to update binary bucket: %w", err)
+	}
+	return nil
+}
+
+// checkQuota fails with ErrQuotaExceeded when storing the binaries of the given functions
+// would exceed the quota. Binaries of revisions dropping out of the history are pruned
+// after a store unless other revisions or kept versions reference them, so they do not
+// count.
+func (r *NATSRegistry) checkQuota(ctx context.Context, binaries map[string][]byte) error {
+	quota, err := r.Quota(ctx)
+	if err != nil || quota.Unlimited() {
+		return err
+	}
+	s, err := r.state(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The retained revisions after the store
+	after := make(map[string][]string, len(s.revisions)+len(binaries))
+	for name, list := range s.revisions {
+		after[name] = list
+	}
+	added := make(map[string]int64)
+	for name, binary := range binaries {
+		object := binaryKey(BinaryDigest(binary))
+		list := append(append([]string(nil), after[name]...), object)
+		if s.history > 0 && len(list) > s.history {
+			list = list[len(list)-s.history:]
+		}
+		after[name] = list
+		if _, stored := s.sizes[object]; !stored {
+			added[object] = int64(len(binary))
+		}
+	}
+
+	bytes := s.bytes()
+	for _, size := range added {
+		bytes += size
+	}
+	referencedAfter := referenced(after)
+	for object := range referenced(s.retained) {
+		referencedAfter[object] = true
+	}
+	for object := range referenced(s.revisions) {
+		if !referencedAfter[object] {
+			bytes -= s.sizes[object]
+		}
+	}
+
+	if quota.MaxFunctions > 0 && len(after) > quota.MaxFunctions {
+		return fmt.Errorf("%w: %d functions exceed the limit of %d", ErrQuotaExceeded, len(after), quota.MaxFunctions)
+	}
+	if quota.MaxBinaryBytes > 0 && bytes > quota.MaxBinaryBytes {
+		return fmt.Errorf("%w: %d binary bytes exceed the limit of %d", ErrQuotaExceeded, bytes, quota.MaxBinaryBytes)
+	}
+	return nil
+}
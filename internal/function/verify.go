@@ -0,0 +1,221 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Problems found by VerifyBinaries
+const (
+	IntegrityCorrupt    = "corrupt"    // The stored binary does not hash to its digest
+	IntegrityMissing    = "missing"    // A retained revision references a binary that is not stored
+	IntegrityUnreadable = "unreadable" // The binary could not be read
+)
+
+// IntegrityIssue is a stored binary that failed verification
+type IntegrityIssue struct {
+	Object string `json:"object"`
+	Digest string `json:"digest"`
+	// Functions are the retained revisions referencing the binary, as name@version
+	Functions []string `json:"functions"`
+	Problem   string   `json:"problem"`
+	Error     string   `json:"error,omitempty"`
+	// Repaired is set when an intact copy was restored from the mirror
+	Repaired    bool   `json:"repaired,omitempty"`
+	RepairError string `json:"repair_error,omitempty"`
+}
+
+// IntegrityReport is the result of a verification run
+type IntegrityReport struct {
+	Started  time.Time        `json:"started"`
+	Duration time.Duration    `json:"duration"`
+	Verified int              `json:"verified"`
+	Bytes    int64            `json:"bytes"`
+	Issues   []IntegrityIssue `json:"issues,omitempty"`
+	// Skipped counts revisions stored before binaries were keyed by digest, which have
+	// no digest to verify against
+	Skipped int `json:"skipped,omitempty"`
+}
+
+// Unrepaired returns the issues that remain after the run
+func (r *IntegrityReport) Unrepaired() []IntegrityIssue {
+	var issues []IntegrityIssue
+	for _, issue := range r.Issues {
+		if !issue.Repaired {
+			issues = append(issues, issue)
+		}
+	}
+	return issues
+}
+
+// VerifyOptions configures a verification run
+type VerifyOptions struct {
+	// Mirror holds copies of the functions, e.g. a registry in another cluster; binaries
+	// failing verification are restored from it when it has an intact copy (optional)
+	Mirror Registry
+}
+
+// IntegrityVerifier is implemented by registries that can verify their stored binaries
+type IntegrityVerifier interface {
+	VerifyBinaries(ctx context.Context, opts VerifyOptions) (*IntegrityReport, error)
+}
+
+// IntegrityCheckConfig configures the periodic verification of the registry by a
+// runtime service, see RuntimeServiceConfig.IntegrityCheck
+type IntegrityCheckConfig struct {
+	// Interval between verification runs, 0 disables them
+	Interval time.Duration
+	// Mirror binaries failing verification are restored from (optional)
+	Mirror Registry
+}
+
+// VerifyBinaries re-hashes every binary referenced by a retained revision against its
+// digest, bypassing the local binary cache, and restores binaries failing verification
+// from the mirror. Corruption otherwise only surfaces when a runtime loads the function.
+func (r *NATSRegistry) VerifyBinaries(ctx context.Context, opts VerifyOptions) (*IntegrityReport, error) {
+	report := &IntegrityReport{Started: time.Now()}
+	revisions, err := r.revisions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Binaries are shared by the revisions of every function with the same artifact
+	references := make(map[string][]FunctionMeta)
+	for _, metas := range revisions {
+		for _, meta := range metas {
+			if meta.Digest == "" {
+				report.Skipped++
+				continue
+			}
+			references[meta.Digest] = append(references[meta.Digest], meta)
+		}
+	}
+	digests := make([]string, 0, len(references))
+	for digest := range references {
+		digests = append(digests, digest)
+	}
+	sort.Strings(digests)
+
+	for _, digest := range digests {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		metas := references[digest]
+		issue := IntegrityIssue{Object: binaryKey(digest), Digest: digest}
+		for _, meta := range metas {
+			issue.Functions = append(issue.Functions, meta.Name+"@"+meta.Version)
+		}
+
+		binary, err := r.objectStore.GetBytes(ctx, issue.Object)
+		switch {
+		case errors.Is(err, jetstream.ErrObjectNotFound):
+			issue.Problem = IntegrityMissing
+		case errors.Is(err, jetstream.ErrDigestMismatch):
+			// The object store checks its own digest while reading
+			issue.Problem, issue.Error = IntegrityCorrupt, err.Error()
+		case err != nil:
+			issue.Problem, issue.Error = IntegrityUnreadable, err.Error()
+		case BinaryDigest(binary) != digest:
+			issue.Problem = IntegrityCorrupt
+		default:
+			report.Verified++
+			report.Bytes += int64(len(binary))
+			continue
+		}
+
+		if opts.Mirror != nil {
+			if err := r.repairBinary(ctx, opts.Mirror, digest, metas); err != nil {
+				issue.RepairError = err.Error()
+			} else {
+				issue.Repaired = true
+			}
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+	report.Duration = time.Since(report.Started)
+	return report, nil
+}
+
+// repairBinary replaces a binary with an intact copy of one of the revisions
+// referencing it from the mirror
+func (r *NATSRegistry) repairBinary(ctx context.Context, mirror Registry, digest string, metas []FunctionMeta) error {
+	versioned, _ := mirror.(VersionedRegistry)
+	for _, meta := range metas {
+		var binary []byte
+		var err error
+		if versioned != nil {
+			_, binary, err = versioned.GetFunctionVersion(meta.Name, meta.Version)
+		} else {
+			_, binary, err = mirror.GetFunction(meta.Name)
+		}
+		if err != nil || BinaryDigest(binary) != digest {
+			continue
+		}
+		if _, err := r.objectStore.PutBytes(ctx, binaryKey(digest), binary); err != nil {
+			return fmt.Errorf("failed to restore binary: %w", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("mirror has no intact copy")
+}
+
+// runIntegrityChecks verifies the registry's binaries at an interval until stopped
+func (rs *RuntimeService) runIntegrityChecks(verifier IntegrityVerifier, cfg IntegrityCheckConfig, stop <-chan struct{}) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			rs.checkIntegrity(verifier, cfg)
+		}
+	}
+}
+
+// checkIntegrity runs one verification and reports the binaries that failed it
+func (rs *RuntimeService) checkIntegrity(verifier IntegrityVerifier, cfg IntegrityCheckConfig) {
+	report, err := verifier.VerifyBinaries(context.Background(), VerifyOptions{Mirror: cfg.Mirror})
+	if err != nil {
+		rs.logger.Error("Binary integrity check failed", Field{Key: "error", Value: err})
+		return
+	}
+
+	for _, issue := range report.Issues {
+		recorded := make(map[string]bool)
+		for _, revision := range issue.Functions {
+			name, _, _ := strings.Cut(revision, "@")
+			if !recorded[name] {
+				recorded[name] = true
+				rs.metrics.RecordFunctionError(name, "binary_"+issue.Problem)
+			}
+		}
+		fields := []Field{
+			{Key: "object", Value: issue.Object},
+			{Key: "problem", Value: issue.Problem},
+			{Key: "functions", Value: issue.Functions},
+		}
+		if issue.Error != "" {
+			fields = append(fields, Field{Key: "error", Value: issue.Error})
+		}
+		if issue.Repaired {
+			rs.logger.Info("Restored function binary from mirror", fields...)
+			continue
+		}
+		if issue.RepairError != "" {
+			fields = append(fields, Field{Key: "repairError", Value: issue.RepairError})
+		}
+		rs.logger.Error("Function binary failed integrity check", fields...)
+	}
+	rs.logger.Info("Binary integrity check completed",
+		Field{Key: "verified", Value: report.Verified},
+		Field{Key: "issues", Value: len(report.Issues)},
+		Field{Key: "duration", Value: report.Duration})
+}
@@ -0,0 +1,605 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/audit"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// DefaultFunctionHistory is how many revisions of each function's current version the
+// registry keeps. Versions themselves are kept side by side until they are retired.
+const DefaultFunctionHistory = 10
+
+// ensureHistory raises the history of a function bucket created with fewer revisions
+// than DefaultFunctionHistory, e.g. before versions were kept, and keeps its other settings
+func ensureHistory(ctx context.Context, js jetstream.JetStream, kv jetstream.KeyValue) error {
+	status, err := kv.Status(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get KV bucket status: %w", err)
+	}
+	if status.History() >= DefaultFunctionHistory {
+		return nil
+	}
+	bucket, ok := status.(*jetstream.KeyValueBucketStatus)
+	if !ok {
+		return fmt.Errorf("KV bucket %s keeps %d revisions, at least %d are required", kv.Bucket(), status.History(), DefaultFunctionHistory)
+	}
+	config := bucket.StreamInfo().Config
+	config.MaxMsgsPerSubject = DefaultFunctionHistory
+	if _, err := js.UpdateStream(ctx, config); err != nil {
+		return fmt.Errorf("failed to raise the history of KV bucket %s to %d: %w", kv.Bucket(), DefaultFunctionHistory, err)
+	}
+	return nil
+}
+
+// Audit actions
+const (
+	AuditPin      = "pin"
+	AuditUnpin    = "unpin"
+	AuditRollback = "rollback"
+	AuditRetire   = "retire"
+)
+
+// LatestVersion refers to the registry's current version of a function
+const LatestVersion = "latest"
+
+// ErrVersionNotFound is returned when no retained revision of a function has the requested version
+var ErrVersionNotFound = errors.New("function version not found")
+
+// VersionedRegistry is implemented by registries that keep previous versions of functions
+type VersionedRegistry interface {
+	// FunctionVersions returns the retained versions of a function, the current one
+	// first and the others newest first
+	FunctionVersions(name string) ([]FunctionMeta, error)
+	// GetFunctionVersion retrieves a retained version of a function
+	GetFunctionVersion(name, version string) (FunctionMeta, []byte, error)
+}
+
+// FunctionRef returns the reference invoking a version of a function, name@version.
+// An empty or latest version refers to the current version, the plain name.
+func FunctionRef(name, version string) string {
+	if version == "" || version == LatestVersion {
+		return name
+	}
+	return name + "@" + version
+}
+
+// ParseFunctionRef splits a function reference into the function name and the version
+// it asks for, empty for the current version
+func ParseFunctionRef(ref string) (name, version string) {
+	name, version, _ = strings.Cut(ref, "@")
+	if version == LatestVersion {
+		version = ""
+	}
+	return name, version
+}
+
+// AuditEntry records a change to the version a function is served at
+type AuditEntry struct {
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"`
+	Function string    `json:"function"`
+	Version  string    `json:"version,omitempty"`  // Version served after the change, or the retired one
+	Previous string    `json:"previous,omitempty"` // Version served before the change
+	// Instance is the runtime instance a pin applies to, empty for the fleet
+	Instance string `json:"instance,omitempty"`
+	Actor    string `json:"actor,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// versionKey returns the key a version of a function is kept under in the version
+// bucket, <name>.<version> with the characters keys do not allow escaped as =XX
+func versionKey(name, version string) string {
+	var key strings.Builder
+	key.WriteString(name)
+	key.WriteByte('.')
+	for i := 0; i < len(version); i++ {
+		c := version[i]
+		if c == '-' || c == '_' || c == '.' || c == '/' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+			key.WriteByte(c)
+		} else {
+			fmt.Fprintf(&key, "=%02X", c)
+		}
+	}
+	return key.String()
+}
+
+// versionBucket returns the KV bucket the versions of the registry's functions are kept
+// in, <function bucket>-versions. Without create, it is nil until a version was stored.
+func (r *NATSRegistry) versionBucket(ctx context.Context, create bool) (jetstream.KeyValue, error) {
+	bucket := r.kv.Bucket() + "-versions"
+	kv, err := r.js.KeyValue(ctx, bucket)
+	if errors.Is(err, jetstream.ErrBucketNotFound) {
+		if !create {
+			return nil, nil
+		}
+		kv, err = r.js.CreateKeyValue(ctx, jetstream.KeyValueConfig{
+			Bucket:      bucket,
+			Description: fmt.Sprintf("Versions of the functions in %s", r.kv.Bucket()),
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open version bucket %s: %w", bucket, err)
+	}
+	return kv, nil
+}
+
+// storedVersions returns the versions kept of a function, or of every function when
+// name is empty, by function name and oldest first
+func (r *NATSRegistry) storedVersions(ctx context.Context, name string) (map[string][]FunctionMeta, error) {
+	kv, err := r.versionBucket(ctx, false)
+	if err != nil || kv == nil {
+		return nil, err
+	}
+	filter := ">"
+	if name != "" {
+		filter = name + ".>"
+	}
+	watcher, err := kv.Watch(ctx, filter, jetstream.IgnoreDeletes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch versions: %w", err)
+	}
+	defer watcher.Stop()
+
+	versions := make(map[string][]FunctionMeta)
+	for entry := range watcher.Updates() {
+		if entry == nil {
+			break
+		}
+		meta, err := decodeMeta(entry.Value())
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal version %s: %w", entry.Key(), err)
+		}
+		// Names may contain dots, so the filter also matches functions named name.*
+		if name == "" || meta.Name == name {
+			versions[meta.Name] = append(versions[meta.Name], meta)
+		}
+	}
+	return versions, nil
+}
+
+// storeVersion keeps a version of a function side by side with the others and returns
+// the object of the version it replaces, if any. Versions of revisions retained by the
+// history of the function, stored before versions were kept, are kept too.
+func (r *NATSRegistry) storeVersion(ctx context.Context, meta FunctionMeta) (string, error) {
+	if meta.Version == "" {
+		return "", nil
+	}
+	kv, err := r.versionBucket(ctx, true)
+	if err != nil {
+		return "", err
+	}
+
+	if history, err := r.kv.History(ctx, meta.Name); err == nil {
+		for _, entry := range history {
+			legacy, err := decodeMeta(entry.Value())
+			if entry.Operation() != jetstream.KeyValuePut || err != nil || legacy.Version == "" {
+				continue
+			}
+			data, err := encodeMeta(legacy)
+			if err != nil {
+				continue
+			}
+			// Versions stored since take precedence
+			kv.Create(ctx, versionKey(legacy.Name, legacy.Version), data)
+		}
+	}
+
+	key := versionKey(meta.Name, meta.Version)
+	var previous string
+	if entry, err := kv.Get(ctx, key); err == nil {
+		if old, err := decodeMeta(entry.Value()); err == nil && binaryObject(old) != binaryObject(meta) {
+			previous = binaryObject(old)
+		}
+	}
+	data, err := encodeMeta(meta)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+	if _, err := kv.Put(ctx, key, data); err != nil {
+		return "", fmt.Errorf("failed to store version: %w", err)
+	}
+	return previous, nil
+}
+
+// revisions returns the retained revisions of every function, oldest first: the history
+// of its current version followed by its other stored versions. A deleted function
+// retains nothing.
+func (r *NATSRegistry) revisions(ctx context.Context) (map[string][]FunctionMeta, error) {
+	history, err := r.history(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := r.storedVersions(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	for name, versions := range stored {
+		for _, meta := range versions {
+			if !containsRevision(history[name], meta) {
+				history[name] = append(history[name], meta)
+			}
+		}
+	}
+	return history, nil
+}
+
+// containsRevision reports whether revisions contain the version of meta with its binary
+func containsRevision(revisions []FunctionMeta, meta FunctionMeta) bool {
+	for _, revision := range revisions {
+		if revision.Version == meta.Version && binaryObject(revision) == binaryObject(meta) {
+			return true
+		}
+	}
+	return false
+}
+
+// history returns the revisions the history of every function retains, oldest first
+func (r *NATSRegistry) history(ctx context.Context) (map[string][]FunctionMeta, error) {
+	watcher, err := r.kv.WatchAll(ctx, jetstream.IncludeHistory())
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch functions: %w", err)
+	}
+	defer watcher.Stop()
+
+	revisions := make(map[string][]FunctionMeta)
+	for entry := range watcher.Updates() {
+		if entry == nil {
+			break
+		}
+		if entry.Operation() != jetstream.KeyValuePut {
+			delete(revisions, entry.Key())
+			continue
+		}
+		meta, err := decodeMeta(entry.Value())
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal function %s: %w", entry.Key(), err)
+		}
+		revisions[entry.Key()] = append(revisions[entry.Key()], meta)
+	}
+	return revisions, nil
+}
+
+// referencedObjects returns the objects a set of revisions reference
+func referencedObjects(revisions map[string][]FunctionMeta) map[string]bool {
+	objects := make(map[string]bool)
+	for _, metas := range revisions {
+		for _, meta := range metas {
+			objects[binaryObject(meta)] = true
+		}
+	}
+	return objects
+}
+
+// pruneObjects removes the given objects unless a retained revision references them
+func (r *NATSRegistry) pruneObjects(ctx context.Context, objects []string) error {
+	if len(objects) == 0 {
+		return nil
+	}
+	revisions, err := r.revisions(ctx)
+	if err != nil {
+		return err
+	}
+	referenced := referencedObjects(revisions)
+	var errs []error
+	for _, object := range objects {
+		if referenced[object] {
+			continue
+		}
+		if err := r.objectStore.Delete(ctx, object); err != nil && !errors.Is(err, jetstream.ErrObjectNotFound) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// revisionObjects returns the objects the retained revisions of a function reference
+func (r *NATSRegistry) revisionObjects(ctx context.Context, name string) []string {
+	history, err := r.kv.History(ctx, name)
+	if err != nil {
+		return nil
+	}
+	var objects []string
+	for _, entry := range history {
+		if entry.Operation() != jetstream.KeyValuePut {
+			continue
+		}
+		if meta, err := decodeMeta(entry.Value()); err == nil {
+			objects = append(objects, binaryObject(meta))
+		}
+	}
+	return objects
+}
+
+// FunctionVersions returns the retained versions of a function, the current one first
+// and the others newest first. Versions are kept until they are retired; revisions of
+// functions stored before versions were kept are retained by the history only.
+func (r *NATSRegistry) FunctionVersions(name string) ([]FunctionMeta, error) {
+	ctx := context.Background()
+	history, err := r.kv.History(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history of %s: %w", name, err)
+	}
+
+	var revisions []FunctionMeta
+	for _, entry := range history {
+		if entry.Operation() != jetstream.KeyValuePut {
+			revisions = nil
+			continue
+		}
+		meta, err := decodeMeta(entry.Value())
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal revision of %s: %w", name, err)
+		}
+		revisions = append(revisions, meta)
+	}
+	if len(revisions) == 0 {
+		return nil, fmt.Errorf("failed to get history of %s: %w", name, jetstream.ErrKeyNotFound)
+	}
+	stored, err := r.storedVersions(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// The current version, then stored versions and the history's, each newest first
+	versions := []FunctionMeta{revisions[len(revisions)-1]}
+	listed := map[string]bool{versions[0].Version: true}
+	candidates := append(revisions, stored[name]...)
+	for i := len(candidates) - 1; i >= 0; i-- {
+		meta := candidates[i]
+		if !listed[meta.Version] {
+			listed[meta.Version] = true
+			versions = append(versions, meta)
+		}
+	}
+	return versions, nil
+}
+
+// GetFunctionVersion retrieves a retained version of a function
+func (r *NATSRegistry) GetFunctionVersion(name, version string) (FunctionMeta, []byte, error) {
+	ctx := context.Background()
+	kv, err := r.versionBucket(ctx, false)
+	if err != nil {
+		return FunctionMeta{}, nil, err
+	}
+	if kv != nil {
+		if entry, err := kv.Get(ctx, versionKey(name, version)); err == nil {
+			meta, err := decodeMeta(entry.Value())
+			if err != nil {
+				return FunctionMeta{}, nil, fmt.Errorf("failed to unmarshal version: %w", err)
+			}
+			binary, err := r.getBinary(ctx, meta)
+			if err != nil {
+				return FunctionMeta{}, nil, err
+			}
+			return meta, binary, nil
+		}
+	}
+
+	// Functions stored before versions were kept retain theirs in the history
+	versions, err := r.FunctionVersions(name)
+	if err != nil {
+		return FunctionMeta{}, nil, err
+	}
+	for _, meta := range versions {
+		if meta.Version != version {
+			continue
+		}
+		binary, err := r.getBinary(context.Background(), meta)
+		if err != nil {
+			return FunctionMeta{}, nil, err
+		}
+		return meta, binary, nil
+	}
+	return FunctionMeta{}, nil, fmt.Errorf("%w: %s@%s", ErrVersionNotFound, name, version)
+}
+
+// Rollback points a function back at a retained revision: the newest one with the given
+// version, or with an empty version the newest one whose version differs from the
+// current. The revision is stored as a new revision, so the rollback can itself be
+// rolled back, and an audit entry is recorded.
+func (r *NATSRegistry) Rollback(ctx context.Context, name, version, actor, reason string) (FunctionMeta, error) {
+	entry, err := r.kv.Get(ctx, name)
+	if err != nil {
+		return FunctionMeta{}, fmt.Errorf("failed to get metadata: %w", err)
+	}
+	current, err := decodeMeta(entry.Value())
+	if err != nil {
+		return FunctionMeta{}, fmt.Errorf("failed to unmarshal metadata: %w", err)
+	}
+
+	versions, err := r.FunctionVersions(name)
+	if err != nil {
+		return FunctionMeta{}, err
+	}
+	var target *FunctionMeta
+	for i := range versions {
+		if (version == "" && versions[i].Version != current.Version) || (version != "" && versions[i].Version == version) {
+			target = &versions[i]
+			break
+		}
+	}
+	if target == nil {
+		if version == "" {
+			return FunctionMeta{}, fmt.Errorf("%w: %s has no previous version", ErrVersionNotFound, name)
+		}
+		return FunctionMeta{}, fmt.Errorf("%w: %s@%s", ErrVersionNotFound, name, version)
+	}
+
+	// The binary must still be stored before metadata points at it
+	if _, err := r.objectStore.GetInfo(ctx, binaryObject(*target)); err != nil {
+		return FunctionMeta{}, fmt.Errorf("binary of %s@%s: %w", name, target.Version, err)
+	}
+
+	data, err := encodeMeta(*target)
+	if err != nil {
+		return FunctionMeta{}, fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+	objects := r.revisionObjects(ctx, name)
+	if _, err := r.kv.Update(ctx, name, data, entry.Revision()); err != nil {
+		return FunctionMeta{}, fmt.Errorf("failed to store metadata: %w", err)
+	}
+	// Failures only leave an unreferenced object behind
+	r.pruneObjects(ctx, objects)
+
+	err = r.RecordAudit(ctx, AuditEntry{
+		Action:   AuditRollback,
+		Function: name,
+		Version:  target.Version,
+		Previous: current.Version,
+		Actor:    actor,
+		Reason:   reason,
+	})
+	return *target, errors.Join(err, r.announce(ctx, EventTypeVersionPromoted, LifecycleChange{
+		Function: name,
+		Type:     target.Type,
+		Version:  target.Version,
+		Previous: current.Version,
+		Digest:   target.Digest,
+		Actor:    actor,
+		Reason:   reason,
+	}))
+}
+
+// RetireVersion stops keeping a version of a function. Its binary is removed once no
+// retained revision references it; the current version cannot be retired.
+func (r *NATSRegistry) RetireVersion(ctx context.Context, name, version, actor string) error {
+	current, err := r.getMeta(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to get metadata: %w", err)
+	}
+	if current.Version == version {
+		return fmt.Errorf("%s@%s is the current version: roll back or deploy another version first", name, version)
+	}
+
+	kv, err := r.versionBucket(ctx, false)
+	if err != nil {
+		return err
+	}
+	if kv == nil {
+		return fmt.Errorf("%w: %s@%s", ErrVersionNotFound, name, version)
+	}
+	key := versionKey(name, version)
+	entry, err := kv.Get(ctx, key)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return fmt.Errorf("%w: %s@%s", ErrVersionNotFound, name, version)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get version: %w", err)
+	}
+	meta, err := decodeMeta(entry.Value())
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal version: %w", err)
+	}
+	if err := kv.Purge(ctx, key); err != nil {
+		return fmt.Errorf("failed to retire version: %w", err)
+	}
+	// Failures only leave an unreferenced object behind
+	r.pruneObjects(ctx, []string{binaryObject(meta)})
+
+	return r.RecordAudit(ctx, AuditEntry{
+		Action:   AuditRetire,
+		Function: name,
+		Version:  version,
+		Actor:    actor,
+	})
+}
+
+// auditBucket returns the KV bucket audit entries of the registry are kept in
+func (r *NATSRegistry) auditBucket(ctx context.Context) (jetstream.KeyValue, error) {
+	status, err := r.kv.Status(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get function bucket status: %w", err)
+	}
+	bucket := status.Bucket() + "-audit"
+
+	kv, err := r.js.KeyValue(ctx, bucket)
+	if errors.Is(err, jetstream.ErrBucketNotFound) {
+		kv, err = r.js.CreateKeyValue(ctx, jetstream.KeyValueConfig{
+			Bucket:      bucket,
+			Description: fmt.Sprintf("Version changes of the functions in %s", status.Bucket()),
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit bucket %s: %w", bucket, err)
+	}
+	return kv, nil
+}
+
+// RecordAudit appends an entry to the audit log of the registry, kept in the
+// <function bucket>-audit KV bucket
+func (r *NATSRegistry) RecordAudit(ctx context.Context, entry AuditEntry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	kv, err := r.auditBucket(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+	key := fmt.Sprintf("%s.%019d", entry.Function, entry.Time.UnixNano())
+	if _, err := kv.Put(ctx, key, data); err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+
+	details := map[string]string{"version": entry.Version, "previous": entry.Previous, "instance": entry.Instance, "reason": entry.Reason}
+	for k, v := range details {
+		if v == "" {
+			delete(details, k)
+		}
+	}
+	return r.recordChange(ctx, audit.Record{
+		Time:     entry.Time,
+		Actor:    entry.Actor,
+		Action:   entry.Action,
+		Resource: entry.Function,
+		Details:  details,
+	})
+}
+
+// AuditLog returns the audit entries of a function, or of all functions when name is
+// empty, oldest first
+func (r *NATSRegistry) AuditLog(ctx context.Context, name string) ([]AuditEntry, error) {
+	kv, err := r.auditBucket(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := kv.Keys(ctx)
+	if errors.Is(err, jetstream.ErrNoKeysFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit entries: %w", err)
+	}
+
+	var entries []AuditEntry
+	for _, key := range keys {
+		if name != "" && !strings.HasPrefix(key, name+".") {
+			continue
+		}
+		value, err := kv.Get(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get audit entry %s: %w", key, err)
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(value.Value(), &entry); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal audit entry %s: %w", key, err)
+		}
+		if name == "" || entry.Function == name {
+			entries = append(entries, entry)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	return entries, nil
+}
@@ -0,0 +1,264 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+// Watchdog defaults
+const (
+	DefaultWatchdogInterval       = 10 * time.Second
+	DefaultStuckInvocationTimeout = time.Minute
+)
+
+// ErrInvocationCancelled is returned to callers when the watchdog force-cancels a hung invocation
+var ErrInvocationCancelled = errors.New("invocation cancelled by watchdog after exceeding hard ceiling")
+
+// errAlreadyResponded is returned when a second response is attempted for an invocation
+var errAlreadyResponded = errors.New("invocation already responded")
+
+// WatchdogConfig configures detection of stuck in-flight invocations
+type WatchdogConfig struct {
+	Interval       time.Duration // How often in-flight invocations are checked (default: DefaultWatchdogInterval)
+	StuckThreshold time.Duration // Invocations running longer than this are reported as stuck (default: DefaultStuckInvocationTimeout)
+	HardCeiling    time.Duration // Invocations running longer than this are force-cancelled, 0 disables cancellation
+}
+
+// InFlightInvocation describes an invocation that is currently executing
+type InFlightInvocation struct {
+	InvocationID string        `json:"invocation_id"`
+	FunctionName string        `json:"function_name"`
+	EventID      string        `json:"event_id"`
+	StartedAt    time.Time     `json:"started_at"`
+	Duration     time.Duration `json:"duration"`
+	Stuck        bool          `json:"stuck"`
+	Cancelled    bool          `json:"cancelled"`
+	// LastHeartbeat and Progress are the time and description of the last heartbeat
+	// the function sent, see Heartbeat
+	LastHeartbeat time.Time `json:"last_heartbeat,omitempty"`
+	Progress      string    `json:"progress,omitempty"`
+}
+
+// invocation is the tracking record of one in-flight invocation
+type invocation struct {
+	id           string
+	functionName string
+	eventID      string
+	started      time.Time
+	cancel       context.CancelFunc
+	req          *invocationRequest
+	stuck        bool
+	cancelled    bool
+	// Last heartbeat of the function, which restarts the stuck threshold
+	lastHeartbeat time.Time
+	progress      string
+}
+
+// inFlightTracker records in-flight invocations. The zero value is ready to use.
+type inFlightTracker struct {
+	mu          sync.Mutex
+	next        uint64
+	invocations map[uint64]*invocation
+}
+
+// start registers a new in-flight invocation and returns its handle
+func (t *inFlightTracker) start(inv *invocation) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.invocations == nil {
+		t.invocations = make(map[uint64]*invocation)
+	}
+	t.next++
+	t.invocations[t.next] = inv
+	return t.next
+}
+
+// finish removes a completed invocation
+func (t *inFlightTracker) finish(id uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.invocations, id)
+}
+
+// heartbeat records the progress of an invocation. An invocation flagged as stuck is
+// unflagged, so it is reported again if it stops sending heartbeats.
+func (t *inFlightTracker) heartbeat(id uint64, progress string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if inv, ok := t.invocations[id]; ok {
+		inv.lastHeartbeat = now
+		inv.progress = progress
+		inv.stuck = false
+	}
+}
+
+// snapshot returns the in-flight invocations ordered by start time
+func (t *inFlightTracker) snapshot(now time.Time) []InFlightInvocation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := make([]InFlightInvocation, 0, len(t.invocations))
+	for _, inv := range t.invocations {
+		result = append(result, InFlightInvocation{
+			InvocationID:  inv.id,
+			FunctionName:  inv.functionName,
+			EventID:       inv.eventID,
+			StartedAt:     inv.started,
+			Duration:      now.Sub(inv.started),
+			Stuck:         inv.stuck,
+			Cancelled:     inv.cancelled,
+			LastHeartbeat: inv.lastHeartbeat,
+			Progress:      inv.progress,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartedAt.Before(result[j].StartedAt)
+	})
+	return result
+}
+
+// invocationIDs returns the IDs of a function's in-flight invocations, sorted
+func (t *inFlightTracker) invocationIDs(functionName string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var ids []string
+	for _, inv := range t.invocations {
+		if inv.functionName == functionName {
+			ids = append(ids, inv.id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// check flags invocations over the stuck threshold since their start or last heartbeat
+// and cancels those over the hard ceiling since their start. It returns the invocations
+// newly flagged as stuck and newly cancelled.
+func (t *inFlightTracker) check(now time.Time, cfg WatchdogConfig) (stuck, cancelled []*invocation) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, inv := range t.invocations {
+		elapsed := now.Sub(inv.started)
+		quiet := elapsed
+		if !inv.lastHeartbeat.IsZero() {
+			quiet = now.Sub(inv.lastHeartbeat)
+		}
+		if !inv.stuck && quiet >= cfg.StuckThreshold {
+			inv.stuck = true
+			stuck = append(stuck, inv)
+		}
+		if cfg.HardCeiling > 0 && !inv.cancelled && elapsed >= cfg.HardCeiling {
+			inv.cancelled = true
+			inv.cancel()
+			cancelled = append(cancelled, inv)
+		}
+	}
+	return stuck, cancelled
+}
+
+// invocationRequest guarantees a single response per invocation, so the watchdog
+// can answer the caller of a hung function without a second reply when it finally returns
+type invocationRequest struct {
+	micro.Request
+	once sync.Once
+}
+
+// Respond sends the response unless one was already sent
+func (r *invocationRequest) Respond(data []byte, opts ...micro.RespondOpt) error {
+	err := errAlreadyResponded
+	r.once.Do(func() { err = r.Request.Respond(data, opts...) })
+	return err
+}
+
+// RespondJSON sends the JSON response unless one was already sent
+func (r *invocationRequest) RespondJSON(data any, opts ...micro.RespondOpt) error {
+	err := errAlreadyResponded
+	r.once.Do(func() { err = r.Request.RespondJSON(data, opts...) })
+	return err
+}
+
+// Error sends the error response unless one was already sent
+func (r *invocationRequest) Error(code, description string, data []byte, opts ...micro.RespondOpt) error {
+	err := errAlreadyResponded
+	r.once.Do(func() { err = r.Request.Error(code, description, data, opts...) })
+	return err
+}
+
+// withWatchdogDefaults fills in unset watchdog settings
+func withWatchdogDefaults(cfg WatchdogConfig) WatchdogConfig {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultWatchdogInterval
+	}
+	if cfg.StuckThreshold <= 0 {
+		cfg.StuckThreshold = DefaultStuckInvocationTimeout
+	}
+	return cfg
+}
+
+// runWatchdog periodically checks in-flight invocations until stop is closed
+func (rs *RuntimeService) runWatchdog(stop <-chan struct{}) {
+	ticker := time.NewTicker(withWatchdogDefaults(rs.watchdog).Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			rs.checkInFlight(now)
+		}
+	}
+}
+
+// checkInFlight reports stuck invocations and force-cancels those over the hard ceiling
+func (rs *RuntimeService) checkInFlight(now time.Time) {
+	stuck, cancelled := rs.inFlight.check(now, withWatchdogDefaults(rs.watchdog))
+
+	for _, inv := range stuck {
+		rs.metrics.RecordFunctionError(inv.functionName, "stuck_invocation")
+		rs.logger.Error("Function invocation appears stuck",
+			Field{Key: "functionName", Value: inv.functionName},
+			Field{Key: "eventID", Value: inv.eventID},
+			Field{Key: "elapsed", Value: now.Sub(inv.started)})
+	}
+
+	for _, inv := range cancelled {
+		rs.metrics.RecordFunctionError(inv.functionName, "invocation_cancelled")
+		rs.logger.Error("Force-cancelled function invocation",
+			Field{Key: "functionName", Value: inv.functionName},
+			Field{Key: "eventID", Value: inv.eventID},
+			Field{Key: "elapsed", Value: now.Sub(inv.started)})
+		if inv.req != nil {
+			rs.respondWithError(inv.req, "invocation_cancelled", ErrInvocationCancelled)
+		}
+	}
+}
+
+// InFlightInvocations returns the invocations currently executing on this runtime
+func (rs *RuntimeService) InFlightInvocations() []InFlightInvocation {
+	return rs.inFlight.snapshot(time.Now())
+}
+
+// watchdogStats is the custom data reported by the service STATS endpoint
+func (rs *RuntimeService) watchdogStats(*micro.Endpoint) any {
+	invocations := rs.InFlightInvocations()
+	stuck := make([]InFlightInvocation, 0)
+	for _, inv := range invocations {
+		if inv.Stuck {
+			stuck = append(stuck, inv)
+		}
+	}
+	return map[string]any{
+		"in_flight": len(invocations),
+		"stuck":     stuck,
+	}
+}
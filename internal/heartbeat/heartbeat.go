@@ -0,0 +1,289 @@
+// Package heartbeat monitors the liveness of external systems. Producers such as
+// cron jobs register the interval they beat at and publish a beat on every run; the
+// monitor emits a heartbeat.missed CloudEvent into the event stream when one stops,
+// so ordinary triggers alert on it, and heartbeat.recovered once it beats again.
+package heartbeat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Heartbeat defaults
+const (
+	DefaultBucket = "heartbeats"
+	// DefaultBeatSubject is the subject prefix beats are published under, as
+	// <DefaultBeatSubject>.<name>
+	DefaultBeatSubject = "heartbeats.beat"
+	// DefaultEventSubject is the subject missed and recovered events are published to
+	DefaultEventSubject  = "heartbeats.events"
+	DefaultCheckInterval = 10 * time.Second
+)
+
+// Heartbeat event types
+const (
+	EventTypeMissed    = "heartbeat.missed"
+	EventTypeRecovered = "heartbeat.recovered"
+)
+
+// ErrNotRegistered is returned for heartbeats that were not registered
+var ErrNotRegistered = errors.New("heartbeat not registered")
+
+// maxUpdateRetries bounds the compare-and-swap attempts of an update
+const maxUpdateRetries = 10
+
+// namePattern restricts heartbeat names to characters valid in keys and subject tokens
+var namePattern = regexp.MustCompile(`^[-_a-zA-Z0-9]+$`)
+
+// Heartbeat is a registered producer and the state of its heartbeats
+type Heartbeat struct {
+	Name string `json:"name"`
+	// Interval is how often the producer beats
+	Interval time.Duration `json:"interval"`
+	// Grace is how much later than Interval a beat may arrive before it is missed
+	Grace       time.Duration     `json:"grace,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	// RegisteredAt is when the heartbeat was first registered; the first beat is
+	// expected within Interval of it
+	RegisteredAt time.Time `json:"registered_at"`
+	LastBeat     time.Time `json:"last_beat"`
+	// Missed is set once the missed event was emitted, until the next beat
+	Missed   bool      `json:"missed"`
+	MissedAt time.Time `json:"missed_at"`
+}
+
+// Deadline returns when the next beat is due at the latest
+func (h *Heartbeat) Deadline() time.Time {
+	last := h.LastBeat
+	if last.IsZero() {
+		last = h.RegisteredAt
+	}
+	return last.Add(h.Interval + h.Grace)
+}
+
+// validate checks a heartbeat registration
+func (h *Heartbeat) validate() error {
+	if !namePattern.MatchString(h.Name) {
+		return fmt.Errorf("invalid heartbeat name %q: only letters, digits, '-' and '_' are allowed", h.Name)
+	}
+	if h.Interval <= 0 {
+		return fmt.Errorf("heartbeat %s: interval must be positive", h.Name)
+	}
+	if h.Grace < 0 {
+		return fmt.Errorf("heartbeat %s: grace must not be negative", h.Name)
+	}
+	return nil
+}
+
+// Registry keeps heartbeat registrations and their state in a KV bucket. Updates use
+// compare-and-swap, so several monitors and producers share it safely.
+type Registry struct {
+	kv nats.KeyValue
+}
+
+// NewRegistry binds to the heartbeat bucket, creating it if it does not exist
+func NewRegistry(nc *nats.Conn, bucket string) (*Registry, error) {
+	if bucket == "" {
+		bucket = DefaultBucket
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get JetStream context: %w", err)
+	}
+	kv, err := js.KeyValue(bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket:      bucket,
+			Description: "Heartbeat registrations of external systems",
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get heartbeat bucket: %w", err)
+	}
+	return &Registry{kv: kv}, nil
+}
+
+// Register registers a heartbeat, or updates the interval, grace, description and
+// labels of a registered one while keeping its state
+func (r *Registry) Register(ctx context.Context, h Heartbeat) (*Heartbeat, error) {
+	if err := h.validate(); err != nil {
+		return nil, err
+	}
+	registered, _, err := r.update(ctx, h.Name, true, func(current *Heartbeat) bool {
+		if current.RegisteredAt.IsZero() {
+			current.Name = h.Name
+			current.RegisteredAt = time.Now()
+		}
+		current.Interval = h.Interval
+		current.Grace = h.Grace
+		current.Description = h.Description
+		current.Labels = h.Labels
+		return true
+	})
+	return registered, err
+}
+
+// Get returns a registered heartbeat
+func (r *Registry) Get(ctx context.Context, name string) (*Heartbeat, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	h, _, err := r.get(name)
+	return h, err
+}
+
+// List returns the registered heartbeats sorted by name
+func (r *Registry) List(ctx context.Context) ([]Heartbeat, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	keys, err := r.kv.Keys()
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list heartbeats: %w", err)
+	}
+
+	heartbeats := make([]Heartbeat, 0, len(keys))
+	for _, key := range keys {
+		h, _, err := r.get(key)
+		if errors.Is(err, ErrNotRegistered) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		heartbeats = append(heartbeats, *h)
+	}
+	sort.Slice(heartbeats, func(i, j int) bool { return heartbeats[i].Name < heartbeats[j].Name })
+	return heartbeats, nil
+}
+
+// Delete unregisters a heartbeat
+func (r *Registry) Delete(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, _, err := r.get(name); err != nil {
+		return err
+	}
+	if err := r.kv.Delete(name); err != nil {
+		return fmt.Errorf("failed to delete heartbeat %s: %w", name, err)
+	}
+	return nil
+}
+
+// Beat records a beat of a registered heartbeat and reports whether it recovered
+// from being missed
+func (r *Registry) Beat(ctx context.Context, name string, at time.Time) (*Heartbeat, bool, error) {
+	var recovered bool
+	h, _, err := r.update(ctx, name, false, func(current *Heartbeat) bool {
+		if at.Before(current.LastBeat) {
+			return false
+		}
+		current.LastBeat = at
+		recovered = current.Missed
+		current.Missed = false
+		current.MissedAt = time.Time{}
+		return true
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return h, recovered, nil
+}
+
+// markMissed marks a heartbeat past its deadline as missed and reports whether this
+// call did, so only one of several monitors emits the missed event
+func (r *Registry) markMissed(ctx context.Context, name string, now time.Time) (*Heartbeat, bool, error) {
+	return r.update(ctx, name, false, func(current *Heartbeat) bool {
+		if current.Missed || now.Before(current.Deadline()) {
+			return false
+		}
+		current.Missed = true
+		current.MissedAt = now
+		return true
+	})
+}
+
+// errConflict is returned when a heartbeat was updated concurrently
+var errConflict = errors.New("heartbeat updated concurrently")
+
+// update applies fn to a heartbeat with compare-and-swap, retrying on conflicts.
+// Unregistered heartbeats fail with ErrNotRegistered unless create is set. It
+// reports whether fn changed the heartbeat.
+func (r *Registry) update(ctx context.Context, name string, create bool, fn func(*Heartbeat) bool) (*Heartbeat, bool, error) {
+	for attempt := 0; attempt < maxUpdateRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, false, ctx.Err()
+			case <-time.After(time.Duration(rand.Int63n(int64(attempt) * int64(5*time.Millisecond)))):
+			}
+		}
+		h, changed, err := r.tryUpdate(name, create, fn)
+		if !errors.Is(err, errConflict) {
+			return h, changed, err
+		}
+	}
+	return nil, false, fmt.Errorf("failed to update heartbeat %s: too many concurrent updates", name)
+}
+
+// tryUpdate applies fn to the current revision of a heartbeat
+func (r *Registry) tryUpdate(name string, create bool, fn func(*Heartbeat) bool) (*Heartbeat, bool, error) {
+	h, revision, err := r.get(name)
+	if errors.Is(err, ErrNotRegistered) && create {
+		h, err = &Heartbeat{}, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if !fn(h) {
+		return h, false, nil
+	}
+
+	data, err := json.Marshal(h)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to marshal heartbeat: %w", err)
+	}
+	if revision == 0 {
+		_, err = r.kv.Create(name, data)
+	} else {
+		_, err = r.kv.Update(name, data, revision)
+	}
+	var apiErr *nats.APIError
+	if errors.Is(err, nats.ErrKeyExists) || errors.As(err, &apiErr) && apiErr.ErrorCode == nats.JSErrCodeStreamWrongLastSequence {
+		return nil, false, errConflict
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to update heartbeat %s: %w", name, err)
+	}
+	return h, true, nil
+}
+
+// get reads a heartbeat and its revision
+func (r *Registry) get(name string) (*Heartbeat, uint64, error) {
+	entry, err := r.kv.Get(name)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return nil, 0, fmt.Errorf("%w: %s", ErrNotRegistered, name)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get heartbeat %s: %w", name, err)
+	}
+	var h Heartbeat
+	if err := json.Unmarshal(entry.Value(), &h); err != nil {
+		return nil, 0, fmt.Errorf("invalid heartbeat %s: %w", name, err)
+	}
+	return &h, entry.Revision(), nil
+}
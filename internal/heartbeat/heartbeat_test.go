@@ -0,0 +1,126 @@
+package heartbeat
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDeadline tests that the first beat is due an interval after registration
+func TestDeadline(t *testing.T) {
+	registered := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := Heartbeat{Name: "backup", Interval: time.Hour, Grace: 5 * time.Minute, RegisteredAt: registered}
+	assert.Equal(t, registered.Add(65*time.Minute), h.Deadline())
+
+	h.LastBeat = registered.Add(2 * time.Hour)
+	assert.Equal(t, registered.Add(3*time.Hour+5*time.Minute), h.Deadline())
+
+	assert.NoError(t, h.validate())
+	assert.ErrorContains(t, (&Heartbeat{Name: "nightly.backup", Interval: time.Hour}).validate(), "invalid heartbeat name")
+	assert.ErrorContains(t, (&Heartbeat{Name: "backup"}).validate(), "interval must be positive")
+}
+
+// TestMonitor tests that a missed heartbeat is reported once and its recovery after
+// the next beat
+func TestMonitor(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	const bucket = "heartbeats-test"
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	js.DeleteKeyValue(bucket)
+	t.Cleanup(func() { js.DeleteKeyValue(bucket) })
+
+	ctx := context.Background()
+	registry, err := NewRegistry(nc, bucket)
+	require.NoError(t, err)
+	registered, err := registry.Register(ctx, Heartbeat{Name: "backup", Interval: time.Hour, Grace: time.Minute, Description: "Nightly backup"})
+	require.NoError(t, err)
+
+	events := make(chan *nats.Msg, 10)
+	sub, err := nc.ChanSubscribe("heartbeats-test.events", events)
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	now := registered.RegisteredAt.Add(30 * time.Minute)
+	monitors := []*Monitor{
+		NewMonitor(nc, registry, MonitorConfig{EventSubject: "heartbeats-test.events"}),
+		NewMonitor(nc, registry, MonitorConfig{EventSubject: "heartbeats-test.events"}),
+	}
+	for _, m := range monitors {
+		m.now = func() time.Time { return now }
+	}
+
+	// Within the deadline nothing is reported
+	require.NoError(t, monitors[0].Check(ctx))
+	assertNoEvent(t, events)
+
+	// Past the deadline the first monitor reports the miss and the second does not
+	now = registered.RegisteredAt.Add(2 * time.Hour)
+	require.NoError(t, monitors[0].Check(ctx))
+	require.NoError(t, monitors[1].Check(ctx))
+	missed := receiveEvent(t, events)
+	assert.Equal(t, EventTypeMissed, missed.Type())
+	assert.Equal(t, "backup", missed.Subject())
+	var data struct {
+		After Heartbeat `json:"after"`
+	}
+	require.NoError(t, missed.DataAs(&data))
+	assert.True(t, data.After.Missed)
+	assert.Equal(t, "Nightly backup", data.After.Description)
+	assertNoEvent(t, events)
+
+	// The next beat reports the recovery
+	require.NoError(t, monitors[1].Beat(ctx, "backup"))
+	recovered := receiveEvent(t, events)
+	assert.Equal(t, EventTypeRecovered, recovered.Type())
+
+	h, err := registry.Get(ctx, "backup")
+	require.NoError(t, err)
+	assert.False(t, h.Missed)
+	assert.True(t, h.LastBeat.Equal(now))
+
+	// Beats of unregistered heartbeats are rejected
+	assert.ErrorIs(t, monitors[0].Beat(ctx, "unknown"), ErrNotRegistered)
+
+	require.NoError(t, registry.Delete(ctx, "backup"))
+	heartbeats, err := registry.List(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, heartbeats)
+}
+
+// receiveEvent waits for a heartbeat event
+func receiveEvent(t *testing.T, events chan *nats.Msg) *cloudevents.Event {
+	t.Helper()
+	select {
+	case msg := <-events:
+		ce := cloudevents.NewEvent()
+		require.NoError(t, json.Unmarshal(msg.Data, &ce))
+		return &ce
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for heartbeat event")
+		return nil
+	}
+}
+
+// assertNoEvent asserts that no heartbeat event was published
+func assertNoEvent(t *testing.T, events chan *nats.Msg) {
+	t.Helper()
+	select {
+	case msg := <-events:
+		t.Fatalf("unexpected heartbeat event: %s", msg.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
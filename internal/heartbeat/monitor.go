@@ -0,0 +1,179 @@
+package heartbeat
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+	"github.com/julianshen/mycelium/internal/event"
+	"github.com/nats-io/nats.go"
+)
+
+// monitorQueueGroup shares the beats among the monitor instances
+const monitorQueueGroup = "heartbeat-monitors"
+
+// MonitorConfig holds the configuration for a heartbeat monitor
+type MonitorConfig struct {
+	// BeatSubject is the subject prefix beats are received under
+	BeatSubject string
+	// EventSubject is the subject missed and recovered events are published to
+	EventSubject string
+	// CheckInterval is how often the heartbeats' deadlines are checked
+	CheckInterval time.Duration
+}
+
+// Monitor records beats and emits a heartbeat.missed event when a registered
+// heartbeat misses its deadline, and heartbeat.recovered when it beats again.
+// Several monitors may share a registry; each event is emitted by one of them.
+type Monitor struct {
+	nc       *nats.Conn
+	registry *Registry
+	config   MonitorConfig
+	sub      *nats.Subscription
+	now      func() time.Time
+}
+
+// NewMonitor creates a heartbeat monitor
+func NewMonitor(nc *nats.Conn, registry *Registry, config MonitorConfig) *Monitor {
+	if config.BeatSubject == "" {
+		config.BeatSubject = DefaultBeatSubject
+	}
+	if config.EventSubject == "" {
+		config.EventSubject = DefaultEventSubject
+	}
+	if config.CheckInterval <= 0 {
+		config.CheckInterval = DefaultCheckInterval
+	}
+	return &Monitor{
+		nc:       nc,
+		registry: registry,
+		config:   config,
+		now:      time.Now,
+	}
+}
+
+// Start subscribes to beats and checks the deadlines every check interval until the
+// context is cancelled
+func (m *Monitor) Start(ctx context.Context) error {
+	sub, err := m.nc.QueueSubscribe(m.config.BeatSubject+".*", monitorQueueGroup, func(msg *nats.Msg) {
+		name := strings.TrimPrefix(msg.Subject, m.config.BeatSubject+".")
+		if err := m.Beat(ctx, name); err != nil {
+			log.Printf("Error recording heartbeat %s: %v", name, err)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to heartbeats: %w", err)
+	}
+	m.sub = sub
+
+	go func() {
+		ticker := time.NewTicker(m.config.CheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := m.Check(ctx); err != nil {
+					log.Printf("Error checking heartbeats: %v", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop unsubscribes from beats
+func (m *Monitor) Stop() {
+	if m.sub != nil {
+		m.sub.Unsubscribe()
+	}
+}
+
+// Beat records a beat and emits the recovered event if the heartbeat was missed
+func (m *Monitor) Beat(ctx context.Context, name string) error {
+	h, recovered, err := m.registry.Beat(ctx, name, m.now())
+	if err != nil || !recovered {
+		return err
+	}
+	return m.publish(EventTypeRecovered, h)
+}
+
+// Check emits a missed event for every heartbeat past its deadline that was not
+// reported yet
+func (m *Monitor) Check(ctx context.Context) error {
+	heartbeats, err := m.registry.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := m.now()
+	for _, h := range heartbeats {
+		if h.Missed || now.Before(h.Deadline()) {
+			continue
+		}
+		missed, marked, err := m.registry.markMissed(ctx, h.Name, now)
+		if err != nil {
+			log.Printf("Error marking heartbeat %s missed: %v", h.Name, err)
+			continue
+		}
+		if !marked {
+			continue
+		}
+		if err := m.publish(EventTypeMissed, missed); err != nil {
+			log.Printf("Error publishing missed heartbeat %s: %v", h.Name, err)
+		}
+	}
+	return nil
+}
+
+// publish publishes a heartbeat event
+func (m *Monitor) publish(eventType string, h *Heartbeat) error {
+	ce, err := NewEvent(eventType, h, m.now())
+	if err != nil {
+		return err
+	}
+	data, err := ce.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("failed to marshal heartbeat event: %w", err)
+	}
+	if err := m.nc.Publish(m.config.EventSubject, data); err != nil {
+		return fmt.Errorf("failed to publish heartbeat event: %w", err)
+	}
+	return nil
+}
+
+// NewEvent builds the CloudEvent reporting a missed or recovered heartbeat.
+// The heartbeat is carried as data.after like other Mycelium events.
+func NewEvent(eventType string, h *Heartbeat, at time.Time) (*cloudevents.Event, error) {
+	ce := cloudevents.NewEvent()
+	ce.SetID(uuid.NewString())
+	ce.SetSource(fmt.Sprintf("mycelium/heartbeat/%s", h.Name))
+	ce.SetSubject(h.Name)
+	ce.SetType(eventType)
+	ce.SetTime(at)
+	ce.SetExtension(event.ExtActorType, "system")
+	ce.SetExtension(event.ExtActorID, "heartbeat-monitor")
+
+	if err := ce.SetData(cloudevents.ApplicationJSON, map[string]interface{}{
+		"after": h,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to set heartbeat data: %w", err)
+	}
+	return &ce, nil
+}
+
+// Publish publishes a beat of a heartbeat, for producers written in Go
+func Publish(nc *nats.Conn, beatSubject, name string) error {
+	if beatSubject == "" {
+		beatSubject = DefaultBeatSubject
+	}
+	if err := nc.Publish(beatSubject+"."+name, nil); err != nil {
+		return fmt.Errorf("failed to publish heartbeat: %w", err)
+	}
+	return nil
+}
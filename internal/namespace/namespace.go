@@ -0,0 +1,301 @@
+package namespace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/function"
+	"github.com/julianshen/mycelium/internal/naming"
+	"github.com/julianshen/mycelium/internal/trigger"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Default provisioning settings
+const (
+	DefaultBucket        = "namespaces"
+	DefaultSubjectRoot   = "events"
+	DefaultTriggerBucket = "config-stream"
+	DefaultMaxAge        = 7 * 24 * time.Hour
+	DefaultReplicas      = 1
+)
+
+// ErrNamespaceNotFound is returned when a namespace has not been provisioned
+var ErrNamespaceNotFound = errors.New("namespace not found")
+
+// namePattern restricts namespace names to characters valid in subjects and bucket names
+var namePattern = regexp.MustCompile(`^[-_a-zA-Z0-9]+$`)
+
+// Config describes a namespace to provision
+type Config struct {
+	Name     string        // Namespace name
+	MaxAge   time.Duration // Retention of the event stream (default: DefaultMaxAge)
+	MaxBytes int64         // Size limit of the event stream, 0 means unlimited
+	Replicas int           // Replicas of the stream and buckets (default: DefaultReplicas)
+	// Quota limits the functions the namespace's registry may store (default: unlimited)
+	Quota function.StorageQuota
+	// TriggerPolicy is enforced on the triggers saved in the namespace (optional)
+	TriggerPolicy *trigger.Policy
+}
+
+// Resources are the NATS resources of a provisioned namespace
+type Resources struct {
+	Namespace      string                `json:"namespace"`
+	Stream         string                `json:"stream"`
+	Subjects       []string              `json:"subjects"`
+	TriggerBucket  string                `json:"trigger_bucket"`
+	TriggerPrefix  string                `json:"trigger_prefix"`
+	FunctionBucket string                `json:"function_bucket"`
+	BinaryBucket   string                `json:"binary_bucket"`
+	MaxAge         time.Duration         `json:"max_age"`
+	MaxBytes       int64                 `json:"max_bytes,omitempty"`
+	Replicas       int                   `json:"replicas"`
+	Quota          function.StorageQuota `json:"quota"`
+	TriggerPolicy  *trigger.Policy       `json:"trigger_policy,omitempty"`
+	CreatedAt      time.Time             `json:"created_at"`
+}
+
+// ProvisionerConfig configures a Provisioner
+type ProvisionerConfig struct {
+	Bucket        string // KV bucket recording provisioned namespaces (default: DefaultBucket)
+	SubjectRoot   string // First subject token of event subjects (default: DefaultSubjectRoot)
+	TriggerBucket string // Shared trigger KV bucket (default: DefaultTriggerBucket)
+	// Prefix is prefixed to the streams and buckets created for namespaces, so the
+	// namespaces of environments sharing a NATS cluster do not collide (optional)
+	Prefix naming.Prefix
+}
+
+// Provisioner creates namespaces with consistent naming and retention
+type Provisioner struct {
+	nc      *nats.Conn
+	js      jetstream.JetStream
+	records jetstream.KeyValue
+	cfg     ProvisionerConfig
+}
+
+// NewProvisioner creates a namespace provisioner
+func NewProvisioner(nc *nats.Conn, cfg ProvisionerConfig) (*Provisioner, error) {
+	if cfg.Bucket == "" {
+		cfg.Bucket = DefaultBucket
+	}
+	if cfg.SubjectRoot == "" {
+		cfg.SubjectRoot = DefaultSubjectRoot
+	}
+	if cfg.TriggerBucket == "" {
+		cfg.TriggerBucket = DefaultTriggerBucket
+	}
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create jetstream: %w", err)
+	}
+
+	records, err := js.CreateOrUpdateKeyValue(context.Background(), jetstream.KeyValueConfig{
+		Bucket: cfg.Bucket,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create namespace bucket: %w", err)
+	}
+
+	return &Provisioner{nc: nc, js: js, records: records, cfg: cfg}, nil
+}
+
+// Names returns the resource names of a namespace without creating anything
+func (p *Provisioner) Names(name string) Resources {
+	return Resources{
+		Namespace:      name,
+		Stream:         p.cfg.Prefix.Name("events-" + name),
+		Subjects:       []string{fmt.Sprintf("%s.%s.>", p.cfg.SubjectRoot, name)},
+		TriggerBucket:  p.cfg.TriggerBucket,
+		TriggerPrefix:  name + ".",
+		FunctionBucket: p.cfg.Prefix.Name("functions-" + name),
+		BinaryBucket:   p.cfg.Prefix.Name("function-binaries-" + name),
+	}
+}
+
+// Create provisions a namespace. It is idempotent: provisioning an existing namespace
+// again applies the new retention settings.
+func (p *Provisioner) Create(ctx context.Context, cfg Config) (*Resources, error) {
+	if !namePattern.MatchString(cfg.Name) {
+		return nil, fmt.Errorf("invalid namespace name %q: only letters, digits, '-' and '_' are allowed", cfg.Name)
+	}
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = DefaultMaxAge
+	}
+	if cfg.Replicas <= 0 {
+		cfg.Replicas = DefaultReplicas
+	}
+	if cfg.TriggerPolicy != nil {
+		if err := cfg.TriggerPolicy.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	res := p.Names(cfg.Name)
+	res.MaxAge = cfg.MaxAge
+	res.MaxBytes = cfg.MaxBytes
+	res.Replicas = cfg.Replicas
+	res.Quota = cfg.Quota
+	res.TriggerPolicy = cfg.TriggerPolicy
+	res.CreatedAt = time.Now().UTC()
+	if existing, err := p.Get(ctx, cfg.Name); err == nil {
+		res.CreatedAt = existing.CreatedAt
+	}
+
+	maxBytes := cfg.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = -1
+	}
+	if _, err := p.js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:        res.Stream,
+		Description: fmt.Sprintf("Events of namespace %s", cfg.Name),
+		Subjects:    res.Subjects,
+		Retention:   jetstream.LimitsPolicy,
+		Storage:     jetstream.FileStorage,
+		MaxAge:      cfg.MaxAge,
+		MaxBytes:    maxBytes,
+		Replicas:    cfg.Replicas,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to create event stream %s: %w", res.Stream, err)
+	}
+
+	// Triggers of every namespace share one bucket, keyed by "<namespace>.<name>"
+	if _, err := p.js.KeyValue(ctx, res.TriggerBucket); errors.Is(err, jetstream.ErrBucketNotFound) {
+		_, err = p.js.CreateKeyValue(ctx, jetstream.KeyValueConfig{Bucket: res.TriggerBucket})
+		if err != nil && !errors.Is(err, jetstream.ErrBucketExists) {
+			return nil, fmt.Errorf("failed to create trigger bucket %s: %w", res.TriggerBucket, err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get trigger bucket %s: %w", res.TriggerBucket, err)
+	}
+
+	if _, err := p.js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      res.FunctionBucket,
+		Description: fmt.Sprintf("Function metadata of namespace %s", cfg.Name),
+		History:     function.DefaultFunctionHistory,
+		Replicas:    cfg.Replicas,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to create function bucket %s: %w", res.FunctionBucket, err)
+	}
+
+	if _, err := p.js.CreateOrUpdateObjectStore(ctx, jetstream.ObjectStoreConfig{
+		Bucket:      res.BinaryBucket,
+		Description: fmt.Sprintf("Function binaries of namespace %s", cfg.Name),
+		Replicas:    cfg.Replicas,
+		// Registries of the namespace enforce the quota recorded on the bucket
+		Metadata: cfg.Quota.Metadata(),
+	}); err != nil {
+		return nil, fmt.Errorf("failed to create binary bucket %s: %w", res.BinaryBucket, err)
+	}
+
+	data, err := json.Marshal(res)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal namespace: %w", err)
+	}
+	if _, err := p.records.Put(ctx, cfg.Name, data); err != nil {
+		return nil, fmt.Errorf("failed to record namespace: %w", err)
+	}
+
+	return &res, nil
+}
+
+// Get returns the resources of a provisioned namespace
+func (p *Provisioner) Get(ctx context.Context, name string) (*Resources, error) {
+	entry, err := p.records.Get(ctx, name)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrNamespaceNotFound, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get namespace %s: %w", name, err)
+	}
+
+	var res Resources
+	if err := json.Unmarshal(entry.Value(), &res); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal namespace %s: %w", name, err)
+	}
+	return &res, nil
+}
+
+// List returns all provisioned namespaces
+func (p *Provisioner) List(ctx context.Context) ([]*Resources, error) {
+	keys, err := p.records.Keys(ctx)
+	if errors.Is(err, jetstream.ErrNoKeysFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %w", err)
+	}
+
+	namespaces := make([]*Resources, 0, len(keys))
+	for _, key := range keys {
+		res, err := p.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		namespaces = append(namespaces, res)
+	}
+	return namespaces, nil
+}
+
+// Usage reports the storage the function registry of a namespace consumes
+func (p *Provisioner) Usage(ctx context.Context, name string) (function.StorageUsage, error) {
+	res, err := p.Get(ctx, name)
+	if err != nil {
+		return function.StorageUsage{}, err
+	}
+	registry, err := function.NewNATSRegistryWithBuckets(p.nc, res.FunctionBucket, res.BinaryBucket)
+	if err != nil {
+		return function.StorageUsage{}, err
+	}
+	return registry.Usage(ctx)
+}
+
+// SetTriggerPolicy replaces the trigger policy of a provisioned namespace, nil removes it
+func (p *Provisioner) SetTriggerPolicy(ctx context.Context, name string, policy *trigger.Policy) (*Resources, error) {
+	if policy != nil {
+		if err := policy.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	entry, err := p.records.Get(ctx, name)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrNamespaceNotFound, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get namespace %s: %w", name, err)
+	}
+
+	var res Resources
+	if err := json.Unmarshal(entry.Value(), &res); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal namespace %s: %w", name, err)
+	}
+	res.TriggerPolicy = policy
+	data, err := json.Marshal(res)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal namespace: %w", err)
+	}
+	// Only replace the record that was read, so a concurrent change is not lost
+	if _, err := p.records.Update(ctx, name, data, entry.Revision()); err != nil {
+		return nil, fmt.Errorf("failed to record namespace: %w", err)
+	}
+	return &res, nil
+}
+
+// TriggerPolicy returns the trigger policy of a namespace, so a provisioner can be the
+// trigger.PolicySource of a trigger store. Namespaces that were not provisioned have
+// no policy.
+func (p *Provisioner) TriggerPolicy(ctx context.Context, name string) (*trigger.Policy, error) {
+	res, err := p.Get(ctx, name)
+	if errors.Is(err, ErrNamespaceNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res.TriggerPolicy, nil
+}
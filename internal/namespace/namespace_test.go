@@ -0,0 +1,185 @@
+package namespace
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/function"
+	"github.com/julianshen/mycelium/internal/naming"
+	"github.com/julianshen/mycelium/internal/trigger"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCreateNamespace tests provisioning a namespace against a NATS server
+func TestCreateNamespace(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	ctx := context.Background()
+	js, err := jetstream.New(nc)
+	require.NoError(t, err)
+
+	p, err := NewProvisioner(nc, ProvisionerConfig{
+		Bucket:        "test-namespaces",
+		SubjectRoot:   "test-events",
+		TriggerBucket: "test-namespace-triggers",
+	})
+	require.NoError(t, err)
+	defer func() {
+		js.DeleteStream(ctx, "events-acme")
+		js.DeleteKeyValue(ctx, "functions-acme")
+		js.DeleteObjectStore(ctx, "function-binaries-acme")
+		js.DeleteKeyValue(ctx, "test-namespace-triggers")
+		js.DeleteKeyValue(ctx, "test-namespaces")
+	}()
+
+	_, err = p.Create(ctx, Config{Name: "acme.prod"})
+	assert.Error(t, err)
+
+	res, err := p.Create(ctx, Config{Name: "acme", MaxAge: time.Hour})
+	require.NoError(t, err)
+	assert.Equal(t, "events-acme", res.Stream)
+	assert.Equal(t, []string{"test-events.acme.>"}, res.Subjects)
+	assert.Equal(t, "acme.", res.TriggerPrefix)
+
+	stream, err := js.Stream(ctx, res.Stream)
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, stream.CachedInfo().Config.MaxAge)
+
+	// Provisioning again updates retention and keeps the creation time
+	again, err := p.Create(ctx, Config{Name: "acme", MaxAge: 2 * time.Hour})
+	require.NoError(t, err)
+	assert.Equal(t, res.CreatedAt.Unix(), again.CreatedAt.Unix())
+
+	namespaces, err := p.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, namespaces, 1)
+	assert.Equal(t, 2*time.Hour, namespaces[0].MaxAge)
+
+	_, err = p.Get(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNamespaceNotFound)
+
+	// The function registry can be scoped to the namespace buckets
+	registry, err := function.NewNATSRegistryWithBuckets(nc, res.FunctionBucket, res.BinaryBucket)
+	require.NoError(t, err)
+	require.NoError(t, registry.StoreFunction(function.FunctionMeta{Name: "resize", Type: "builtin"}, []byte("bin")))
+	functions, err := registry.ListFunctions()
+	require.NoError(t, err)
+	assert.Len(t, functions, 1)
+
+	usage, err := p.Usage(ctx, "acme")
+	require.NoError(t, err)
+	assert.Equal(t, 1, usage.Functions)
+	assert.Equal(t, int64(3), usage.BinaryBytes)
+
+	// A quota set at provisioning is enforced by the namespace's registries
+	_, err = p.Create(ctx, Config{Name: "acme", Quota: function.StorageQuota{MaxFunctions: 1}})
+	require.NoError(t, err)
+	err = registry.StoreFunction(function.FunctionMeta{Name: "crop", Type: "builtin"}, []byte("bin"))
+	assert.ErrorIs(t, err, function.ErrQuotaExceeded)
+}
+
+// TestNamespaceTriggerPolicy tests that trigger stores enforce the policies of
+// provisioned namespaces
+func TestNamespaceTriggerPolicy(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+		return
+	}
+	defer nc.Close()
+
+	ctx := context.Background()
+	js, err := jetstream.New(nc)
+	require.NoError(t, err)
+
+	p, err := NewProvisioner(nc, ProvisionerConfig{
+		Bucket:        "test-policy-namespaces",
+		SubjectRoot:   "test-policy-events",
+		TriggerBucket: "test-policy-triggers",
+	})
+	require.NoError(t, err)
+	defer func() {
+		js.DeleteStream(ctx, "events-policed")
+		js.DeleteKeyValue(ctx, "functions-policed")
+		js.DeleteObjectStore(ctx, "function-binaries-policed")
+		js.DeleteKeyValue(ctx, "test-policy-triggers")
+		js.DeleteKeyValue(ctx, "test-policy-namespaces")
+	}()
+
+	_, err = p.Create(ctx, Config{Name: "policed", TriggerPolicy: &trigger.Policy{MaxTriggers: -1}})
+	assert.Error(t, err)
+	_, err = p.Create(ctx, Config{Name: "policed", TriggerPolicy: &trigger.Policy{
+		DefaultTimeout:     "30s",
+		RequiredLabels:     []string{"team"},
+		AllowedActionTypes: []string{"function"},
+		MaxTriggers:        1,
+	}})
+	require.NoError(t, err)
+
+	policy, err := p.TriggerPolicy(ctx, "unprovisioned")
+	require.NoError(t, err)
+	assert.Nil(t, policy)
+
+	store, err := trigger.NewNATSStore(nc, "test-policy-triggers")
+	require.NoError(t, err)
+	defer store.Close()
+	store.SetLifecycleSubject("")
+	store.SetPolicies(p)
+
+	// Violations are rejected and defaults applied
+	err = store.SaveTrigger(ctx, "policed", "alert", &trigger.Trigger{ID: "alert", Action: "notify"})
+	assert.ErrorIs(t, err, trigger.ErrPolicyViolation)
+	resize := &trigger.Trigger{ID: "resize", Action: "function:resize", Labels: map[string]string{"team": "media"}}
+	require.NoError(t, store.SaveTrigger(ctx, "policed", "resize", resize))
+	assert.Equal(t, "30s", resize.Timeout)
+
+	// Updating an existing trigger does not count against the limit, creating one does
+	resize.Description = "Resize uploaded images"
+	require.NoError(t, store.SaveTrigger(ctx, "policed", "resize", resize))
+	crop := &trigger.Trigger{ID: "crop", Action: "function:crop", Labels: map[string]string{"team": "media"}}
+	err = store.SaveTrigger(ctx, "policed", "crop", crop)
+	assert.ErrorIs(t, err, trigger.ErrPolicyViolation)
+
+	// Other namespaces are not affected
+	require.NoError(t, store.SaveTrigger(ctx, "default", "alert", &trigger.Trigger{ID: "alert", Action: "notify"}))
+
+	// Policies can be replaced and removed after provisioning
+	res, err := p.SetTriggerPolicy(ctx, "policed", &trigger.Policy{MaxTriggers: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 2, res.TriggerPolicy.MaxTriggers)
+	require.NoError(t, store.SaveTrigger(ctx, "policed", "crop", crop))
+
+	_, err = p.SetTriggerPolicy(ctx, "policed", nil)
+	require.NoError(t, err)
+	require.NoError(t, store.SaveTrigger(ctx, "policed", "alert", &trigger.Trigger{ID: "alert", Action: "notify"}))
+
+	_, err = p.SetTriggerPolicy(ctx, "missing", nil)
+	assert.ErrorIs(t, err, ErrNamespaceNotFound)
+}
+
+// TestPrefixedNames tests that the resources of namespaces carry the provisioner's prefix
+func TestPrefixedNames(t *testing.T) {
+	p := &Provisioner{cfg: ProvisionerConfig{
+		SubjectRoot:   DefaultSubjectRoot,
+		TriggerBucket: "acme-staging-config-stream",
+		Prefix:        naming.Prefix{Tenant: "acme", Environment: "staging"},
+	}}
+	res := p.Names("media")
+	assert.Equal(t, "acme-staging-events-media", res.Stream)
+	assert.Equal(t, []string{"events.media.>"}, res.Subjects)
+	assert.Equal(t, "acme-staging-config-stream", res.TriggerBucket)
+	assert.Equal(t, "acme-staging-functions-media", res.FunctionBucket)
+	assert.Equal(t, "acme-staging-function-binaries-media", res.BinaryBucket)
+}
@@ -0,0 +1,85 @@
+// Package naming prefixes the names of NATS resources with a tenant and environment,
+// so several deployments share a NATS cluster without their streams, consumers, KV
+// buckets, object stores and services colliding. Every component reads the prefix
+// from the same environment variables, which their --tenant and --environment flags
+// default to.
+package naming
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Environment variables the prefix is configured with
+const (
+	TenantEnv      = "MYCELIUM_TENANT"
+	EnvironmentEnv = "MYCELIUM_ENVIRONMENT"
+)
+
+// Separator joins the tenant, the environment and the name
+const Separator = "-"
+
+// partPattern restricts the prefix to characters valid in stream, consumer, bucket and
+// service names as well as in subject tokens
+var partPattern = regexp.MustCompile(`^[-_a-zA-Z0-9]+$`)
+
+// Prefix is the tenant and environment names are prefixed with. The zero value
+// leaves names unchanged.
+type Prefix struct {
+	Tenant      string
+	Environment string
+}
+
+// FromEnv returns the prefix configured in the environment
+func FromEnv() Prefix {
+	return Prefix{Tenant: os.Getenv(TenantEnv), Environment: os.Getenv(EnvironmentEnv)}
+}
+
+// RegisterFlags registers the --tenant and --environment flags, defaulting to the
+// prefix configured in the environment
+func (p *Prefix) RegisterFlags(fs *flag.FlagSet) {
+	env := FromEnv()
+	fs.StringVar(&p.Tenant, "tenant", env.Tenant, "Tenant prefixed to stream, consumer, bucket and service names (default: $"+TenantEnv+")")
+	fs.StringVar(&p.Environment, "environment", env.Environment, "Environment prefixed to stream, consumer, bucket and service names after the tenant (default: $"+EnvironmentEnv+")")
+}
+
+// Validate checks that the prefix only contains letters, digits, '-' and '_'
+func (p Prefix) Validate() error {
+	for _, part := range []struct{ name, value string }{{"tenant", p.Tenant}, {"environment", p.Environment}} {
+		if part.value != "" && !partPattern.MatchString(part.value) {
+			return fmt.Errorf("invalid %s %q: only letters, digits, '-' and '_' are allowed", part.name, part.value)
+		}
+	}
+	return nil
+}
+
+// String returns the prefix without its trailing separator, e.g. acme-staging
+func (p Prefix) String() string {
+	var parts []string
+	for _, part := range []string{p.Tenant, p.Environment} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, Separator)
+}
+
+// Name prefixes a name, e.g. config-stream becomes acme-staging-config-stream. Empty
+// names, which disable optional resources, stay empty.
+func (p Prefix) Name(name string) string {
+	prefix := p.String()
+	if prefix == "" || name == "" {
+		return name
+	}
+	return prefix + Separator + name
+}
+
+// Apply prefixes names in place, typically the values of name flags after parsing
+func (p Prefix) Apply(names ...*string) {
+	for _, name := range names {
+		*name = p.Name(*name)
+	}
+}
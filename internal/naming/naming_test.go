@@ -0,0 +1,46 @@
+package naming
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPrefix tests that names are prefixed with the tenant and environment
+func TestPrefix(t *testing.T) {
+	assert.Equal(t, "config-stream", Prefix{}.Name("config-stream"))
+	assert.Equal(t, "acme-config-stream", Prefix{Tenant: "acme"}.Name("config-stream"))
+	assert.Equal(t, "staging-config-stream", Prefix{Environment: "staging"}.Name("config-stream"))
+
+	p := Prefix{Tenant: "acme", Environment: "staging"}
+	assert.Equal(t, "acme-staging", p.String())
+	assert.Equal(t, "acme-staging-config-stream", p.Name("config-stream"))
+	assert.Empty(t, p.Name(""), "disabled resources stay disabled")
+
+	stream, bucket, disabled := "config-stream", "trigger-windows", ""
+	p.Apply(&stream, &bucket, &disabled)
+	assert.Equal(t, "acme-staging-config-stream", stream)
+	assert.Equal(t, "acme-staging-trigger-windows", bucket)
+	assert.Empty(t, disabled)
+
+	assert.NoError(t, p.Validate())
+	assert.ErrorContains(t, Prefix{Tenant: "acme.eu"}.Validate(), "invalid tenant")
+	assert.ErrorContains(t, Prefix{Environment: "prod *"}.Validate(), "invalid environment")
+}
+
+// TestRegisterFlags tests that the flags default to the environment
+func TestRegisterFlags(t *testing.T) {
+	t.Setenv(TenantEnv, "acme")
+	t.Setenv(EnvironmentEnv, "staging")
+
+	var p Prefix
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	p.RegisterFlags(fs)
+	require.NoError(t, fs.Parse(nil))
+	assert.Equal(t, Prefix{Tenant: "acme", Environment: "staging"}, p)
+
+	require.NoError(t, fs.Parse([]string{"--environment", "prod"}))
+	assert.Equal(t, "acme-prod-functions", p.Name("functions"))
+}
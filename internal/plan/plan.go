@@ -0,0 +1,224 @@
+// Package plan compares declarative manifests with live state and describes the
+// changes that would reconcile them, in the spirit of terraform plan
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Change actions
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// FieldChange is a field whose value differs between live state and the manifest.
+// Before is absent for created resources and After for deleted ones.
+type FieldChange struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// Change is a resource to create, update or delete
+type Change struct {
+	Action string        `json:"action"`
+	ID     string        `json:"id"`
+	Fields []FieldChange `json:"fields,omitempty"`
+}
+
+// Plan is the set of changes that would make live state match the manifests
+type Plan struct {
+	// Resource names the kind of resources compared, e.g. trigger
+	Resource string   `json:"resource"`
+	Changes  []Change `json:"changes"`
+	// Unchanged counts the resources that already match their manifest
+	Unchanged int `json:"unchanged"`
+}
+
+// Compute compares resources declared in manifests with live ones, both keyed by ID.
+// Resources are compared field by field on their JSON encoding, so only fields that
+// would be written are reported. Live resources without a manifest are deleted.
+func Compute(resource string, desired, live map[string]interface{}) (*Plan, error) {
+	p := &Plan{Resource: resource, Changes: []Change{}}
+
+	ids := make([]string, 0, len(desired)+len(live))
+	for id := range desired {
+		ids = append(ids, id)
+	}
+	for id := range live {
+		if _, ok := desired[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		before, err := fields(live[id])
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode live %s %s: %w", resource, id, err)
+		}
+		after, err := fields(desired[id])
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s %s: %w", resource, id, err)
+		}
+
+		change := Change{ID: id, Fields: diffFields(before, after)}
+		switch {
+		case before == nil:
+			change.Action = ActionCreate
+		case after == nil:
+			change.Action = ActionDelete
+		case len(change.Fields) == 0:
+			p.Unchanged++
+			continue
+		default:
+			change.Action = ActionUpdate
+		}
+		p.Changes = append(p.Changes, change)
+	}
+	return p, nil
+}
+
+// fields returns the top-level JSON fields of a resource, nil for no resource
+func fields(v interface{}) (map[string]interface{}, error) {
+	if v == nil || reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil() {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// diffFields returns the fields that differ, sorted by name. Zero values count as
+// absent, so a field omitted from a manifest matches an empty live one.
+func diffFields(before, after map[string]interface{}) []FieldChange {
+	names := make(map[string]bool)
+	for name := range before {
+		names[name] = true
+	}
+	for name := range after {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var changes []FieldChange
+	for _, name := range sorted {
+		b, a := zeroToNil(before[name]), zeroToNil(after[name])
+		if !reflect.DeepEqual(b, a) {
+			changes = append(changes, FieldChange{Field: name, Before: b, After: a})
+		}
+	}
+	return changes
+}
+
+// zeroToNil maps decoded JSON zero values to nil
+func zeroToNil(v interface{}) interface{} {
+	switch x := v.(type) {
+	case string:
+		if x == "" {
+			return nil
+		}
+	case bool:
+		if !x {
+			return nil
+		}
+	case float64:
+		if x == 0 {
+			return nil
+		}
+	case []interface{}:
+		if len(x) == 0 {
+			return nil
+		}
+	case map[string]interface{}:
+		if len(x) == 0 {
+			return nil
+		}
+	}
+	return v
+}
+
+// HasChanges reports whether applying the plan would change anything
+func (p *Plan) HasChanges() bool {
+	return len(p.Changes) > 0
+}
+
+// Count returns the number of changes with an action
+func (p *Plan) Count(action string) int {
+	n := 0
+	for _, c := range p.Changes {
+		if c.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// Summary returns the one-line totals of the plan
+func (p *Plan) Summary() string {
+	if !p.HasChanges() {
+		return fmt.Sprintf("No changes. Live %ss match the manifests.", p.Resource)
+	}
+	return fmt.Sprintf("Plan: %d to add, %d to change, %d to destroy.",
+		p.Count(ActionCreate), p.Count(ActionUpdate), p.Count(ActionDelete))
+}
+
+// WriteText writes the plan for humans: + creates, ~ updates and - deletes, with the
+// fields each change sets or clears
+func (p *Plan) WriteText(w io.Writer) error {
+	var b strings.Builder
+	for _, c := range p.Changes {
+		symbol := map[string]string{ActionCreate: "+", ActionUpdate: "~", ActionDelete: "-"}[c.Action]
+		fmt.Fprintf(&b, "  %s %s %s\n", symbol, p.Resource, c.ID)
+
+		width := 0
+		for _, f := range c.Fields {
+			if len(f.Field) > width {
+				width = len(f.Field)
+			}
+		}
+		for _, f := range c.Fields {
+			switch {
+			case f.Before == nil:
+				fmt.Fprintf(&b, "      + %-*s = %s\n", width, f.Field, formatValue(f.After))
+			case f.After == nil:
+				fmt.Fprintf(&b, "      - %-*s = %s\n", width, f.Field, formatValue(f.Before))
+			default:
+				fmt.Fprintf(&b, "      ~ %-*s = %s -> %s\n", width, f.Field, formatValue(f.Before), formatValue(f.After))
+			}
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(p.Summary())
+	b.WriteString("\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// formatValue renders a field value as compact JSON
+func formatValue(v interface{}) string {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,62 @@
+package plan
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type resource struct {
+	ID       string            `json:"id"`
+	Criteria string            `json:"criteria,omitempty"`
+	Enabled  bool              `json:"enabled"`
+	Vars     map[string]string `json:"vars,omitempty"`
+}
+
+// TestCompute tests creates, updates, deletes and unchanged resources
+func TestCompute(t *testing.T) {
+	desired := map[string]interface{}{
+		"new":     &resource{ID: "new", Enabled: true},
+		"changed": &resource{ID: "changed", Criteria: "x > 2", Enabled: true},
+		"same":    &resource{ID: "same", Vars: map[string]string{}},
+	}
+	live := map[string]interface{}{
+		"changed": &resource{ID: "changed", Criteria: "x > 1", Enabled: true, Vars: map[string]string{"a": "b"}},
+		"same":    &resource{ID: "same"},
+		"old":     &resource{ID: "old"},
+	}
+
+	p, err := Compute("trigger", desired, live)
+	require.NoError(t, err)
+	assert.Equal(t, 1, p.Unchanged)
+	require.Len(t, p.Changes, 3)
+
+	assert.Equal(t, Change{Action: ActionUpdate, ID: "changed", Fields: []FieldChange{
+		{Field: "criteria", Before: "x > 1", After: "x > 2"},
+		{Field: "vars", Before: map[string]interface{}{"a": "b"}},
+	}}, p.Changes[0])
+	assert.Equal(t, ActionCreate, p.Changes[1].Action)
+	assert.Equal(t, "new", p.Changes[1].ID)
+	assert.Equal(t, []FieldChange{{Field: "enabled", After: true}, {Field: "id", After: "new"}}, p.Changes[1].Fields)
+	assert.Equal(t, Change{Action: ActionDelete, ID: "old", Fields: []FieldChange{{Field: "id", Before: "old"}}}, p.Changes[2])
+
+	assert.True(t, p.HasChanges())
+	assert.Equal(t, "Plan: 1 to add, 1 to change, 1 to destroy.", p.Summary())
+
+	var b strings.Builder
+	require.NoError(t, p.WriteText(&b))
+	assert.Contains(t, b.String(), "  ~ trigger changed\n      ~ criteria = \"x > 1\" -> \"x > 2\"\n      - vars     = {\"a\":\"b\"}\n")
+	assert.Contains(t, b.String(), "  + trigger new\n")
+	assert.Contains(t, b.String(), "  - trigger old\n")
+}
+
+// TestComputeNoChanges tests the plan of matching state
+func TestComputeNoChanges(t *testing.T) {
+	p, err := Compute("function", map[string]interface{}{"a": resource{ID: "a"}}, map[string]interface{}{"a": resource{ID: "a"}})
+	require.NoError(t, err)
+	assert.False(t, p.HasChanges())
+	assert.Empty(t, p.Changes)
+	assert.Equal(t, "No changes. Live functions match the manifests.", p.Summary())
+}
@@ -0,0 +1,70 @@
+package profiling
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// Error is an error answered by a profiling endpoint
+type Error struct {
+	// Code is the status code: 400, 401, 409 or 500
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("profiling error %s: %s", e.Code, e.Message)
+}
+
+// Fetch requests a profile from the endpoint on a subject. The context must outlast
+// CPU profiles, which answer only once they are done sampling.
+func Fetch(ctx context.Context, nc *nats.Conn, subject, token string, req Request) ([]byte, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	msg := nats.NewMsg(subject)
+	msg.Data = data
+	if token != "" {
+		msg.Header.Set(AuthorizationHeader, "Bearer "+token)
+	}
+
+	reply, err := nc.RequestMsgWithContext(ctx, msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request %s profile: %w", req.Kind, err)
+	}
+	if code := reply.Header.Get(micro.ErrorCodeHeader); code != "" {
+		return nil, &Error{Code: code, Message: reply.Header.Get(micro.ErrorHeader)}
+	}
+	return reply.Data, nil
+}
+
+// FetchMetrics requests the runtime metrics from the endpoint on a subject
+func FetchMetrics(ctx context.Context, nc *nats.Conn, subject, token string) ([]Metric, error) {
+	data, err := Fetch(ctx, nc, subject, token, Request{Kind: KindMetrics})
+	if err != nil {
+		return nil, err
+	}
+	var result []Metric
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal metrics: %w", err)
+	}
+	return result, nil
+}
+
+// RequestTimeout returns how long to wait for the answer to a request: the sampling
+// time of CPU profiles plus a margin for sending the profile
+func RequestTimeout(req Request, margin time.Duration) time.Duration {
+	if req.Kind != KindCPU {
+		return margin
+	}
+	if req.Seconds == 0 {
+		return DefaultCPUDuration + margin
+	}
+	return time.Duration(req.Seconds)*time.Second + margin
+}
@@ -0,0 +1,321 @@
+// Package profiling serves Go profiles and runtime metrics of a process over NATS
+// request/reply, so production instances can be profiled without rebuilding them or
+// opening an HTTP port. Requests must carry an admin bearer token.
+package profiling
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"runtime/metrics"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// AuthorizationHeader carries the bearer token of a request
+const AuthorizationHeader = "Authorization"
+
+// Profile kinds. Everything but KindMetrics answers with a gzipped pprof protobuf
+// for `go tool pprof`; KindMetrics answers with runtime/metrics samples as JSON.
+const (
+	KindCPU       = "cpu"
+	KindHeap      = "heap"
+	KindAllocs    = "allocs"
+	KindGoroutine = "goroutine"
+	KindBlock     = "block"
+	KindMutex     = "mutex"
+	KindMetrics   = "metrics"
+)
+
+// Labels set on the goroutines doing work for a function or trigger, so CPU profiles
+// attribute samples to them, e.g. `go tool pprof -tagfocus function=resize`
+const (
+	LabelFunction = "function"
+	LabelTrigger  = "trigger"
+)
+
+// DefaultSubjectPrefix is the subject prefix trigger daemons serve profiling requests
+// under, as <prefix>.<instance-id>
+const DefaultSubjectPrefix = "triggerd.profile"
+
+// DefaultCPUDuration is how long a CPU profile samples when the request sets no duration
+const DefaultCPUDuration = 30 * time.Second
+
+// MaxCPUDuration is the longest CPU profile a request may ask for
+const MaxCPUDuration = 5 * time.Minute
+
+var (
+	// ErrUnauthenticated is returned when a request carries no or a wrong token
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrInvalidRequest is returned for requests of unknown kinds or durations out of range
+	ErrInvalidRequest = errors.New("invalid profiling request")
+	// ErrCPUProfileActive is returned while another CPU profile is being taken
+	ErrCPUProfileActive = errors.New("a CPU profile is already being taken")
+)
+
+// Request asks for a profile
+type Request struct {
+	Kind string `json:"kind"`
+	// Seconds is how long a CPU profile samples (default: DefaultCPUDuration)
+	Seconds int `json:"seconds,omitempty"`
+}
+
+// Metric is a runtime/metrics sample. Counters and gauges set Value, distributions
+// set Histogram.
+type Metric struct {
+	Name      string     `json:"name"`
+	Value     float64    `json:"value"`
+	Histogram *Histogram `json:"histogram,omitempty"`
+}
+
+// Histogram summarizes a runtime/metrics distribution by its quantiles, each the upper
+// bound of the bucket it falls in
+type Histogram struct {
+	Count uint64  `json:"count"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+}
+
+// Handler answers profiling requests authenticated with an admin token
+type Handler struct {
+	tokenSHA256 string
+	// cpu is held while a CPU profile is taken; the runtime allows only one at a time
+	cpu sync.Mutex
+}
+
+// NewHandler creates a handler accepting requests whose bearer token has the given hex
+// SHA-256, e.g. the output of `printf %s "$TOKEN" | sha256sum`, so configuration holds
+// no secrets
+func NewHandler(tokenSHA256 string) (*Handler, error) {
+	tokenSHA256 = strings.ToLower(strings.TrimSpace(tokenSHA256))
+	if digest, err := hex.DecodeString(tokenSHA256); err != nil || len(digest) != sha256.Size {
+		return nil, fmt.Errorf("profiling token must be a hex SHA-256 digest")
+	}
+	return &Handler{tokenSHA256: tokenSHA256}, nil
+}
+
+// authenticate checks a bearer token against the handler's digest
+func (h *Handler) authenticate(token string) error {
+	if token == "" {
+		return ErrUnauthenticated
+	}
+	sum := sha256.Sum256([]byte(token))
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(h.tokenSHA256)) != 1 {
+		return ErrUnauthenticated
+	}
+	return nil
+}
+
+// Profile authenticates a request and takes the profile it asks for
+func (h *Handler) Profile(ctx context.Context, token string, req Request) ([]byte, error) {
+	if err := h.authenticate(token); err != nil {
+		return nil, err
+	}
+
+	switch req.Kind {
+	case KindCPU:
+		duration := DefaultCPUDuration
+		if req.Seconds != 0 {
+			duration = time.Duration(req.Seconds) * time.Second
+		}
+		if duration <= 0 || duration > MaxCPUDuration {
+			return nil, fmt.Errorf("%w: CPU profile duration must be positive and at most %s", ErrInvalidRequest, MaxCPUDuration)
+		}
+		return h.cpuProfile(ctx, duration)
+	case KindMetrics:
+		return json.Marshal(ReadMetrics())
+	case KindHeap, KindAllocs, KindGoroutine, KindBlock, KindMutex:
+		var buf bytes.Buffer
+		if err := pprof.Lookup(req.Kind).WriteTo(&buf, 0); err != nil {
+			return nil, fmt.Errorf("failed to write %s profile: %w", req.Kind, err)
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("%w: unknown kind %q", ErrInvalidRequest, req.Kind)
+	}
+}
+
+// cpuProfile samples the CPU for a duration, or until the context is done
+func (h *Handler) cpuProfile(ctx context.Context, duration time.Duration) ([]byte, error) {
+	if !h.cpu.TryLock() {
+		return nil, ErrCPUProfileActive
+	}
+	defer h.cpu.Unlock()
+
+	var buf bytes.Buffer
+	if err := pprof.StartCPUProfile(&buf); err != nil {
+		// Another part of the process is profiling
+		return nil, fmt.Errorf("%w: %v", ErrCPUProfileActive, err)
+	}
+	timer := time.NewTimer(duration)
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		timer.Stop()
+	}
+	pprof.StopCPUProfile()
+	return buf.Bytes(), nil
+}
+
+// ReadMetrics reads every supported runtime/metrics sample, sorted by name
+func ReadMetrics() []Metric {
+	descriptions := metrics.All()
+	samples := make([]metrics.Sample, len(descriptions))
+	for i, d := range descriptions {
+		samples[i].Name = d.Name
+	}
+	metrics.Read(samples)
+
+	result := make([]Metric, 0, len(samples))
+	for _, sample := range samples {
+		metric := Metric{Name: sample.Name}
+		switch sample.Value.Kind() {
+		case metrics.KindUint64:
+			metric.Value = float64(sample.Value.Uint64())
+		case metrics.KindFloat64:
+			metric.Value = sample.Value.Float64()
+		case metrics.KindFloat64Histogram:
+			metric.Histogram = summarize(sample.Value.Float64Histogram())
+		default:
+			// Metrics this Go version does not know how to read
+			continue
+		}
+		result = append(result, metric)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// summarize computes the quantiles of a histogram. Infinite bucket bounds are replaced
+// by the finite bound of the bucket, since JSON cannot encode infinities.
+func summarize(h *metrics.Float64Histogram) *Histogram {
+	summary := &Histogram{}
+	for _, count := range h.Counts {
+		summary.Count += count
+	}
+	if summary.Count == 0 {
+		return summary
+	}
+	quantile := func(q float64) float64 {
+		target := uint64(math.Ceil(q * float64(summary.Count)))
+		var seen uint64
+		for i, count := range h.Counts {
+			seen += count
+			if seen >= target {
+				if upper := h.Buckets[i+1]; !math.IsInf(upper, 0) {
+					return upper
+				}
+				if lower := h.Buckets[i]; !math.IsInf(lower, 0) {
+					return lower
+				}
+				return 0
+			}
+		}
+		return 0
+	}
+	summary.P50, summary.P90, summary.P99 = quantile(0.5), quantile(0.9), quantile(0.99)
+	return summary
+}
+
+// errorCode returns the status code answered for an error
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrUnauthenticated):
+		return "401"
+	case errors.Is(err, ErrInvalidRequest):
+		return "400"
+	case errors.Is(err, ErrCPUProfileActive):
+		return "409"
+	default:
+		return "500"
+	}
+}
+
+// bearer extracts the token of an Authorization header
+func bearer(header string) string {
+	token, _ := strings.CutPrefix(header, "Bearer ")
+	return strings.TrimSpace(token)
+}
+
+// decode parses a request; an empty body asks for the runtime metrics
+func decode(data []byte) (Request, error) {
+	req := Request{Kind: KindMetrics}
+	if len(data) == 0 {
+		return req, nil
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		return req, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+	return req, nil
+}
+
+// MicroHandler adapts the handler to a NATS micro endpoint. Requests are answered
+// concurrently, so a CPU profile does not hold up other requests.
+func (h *Handler) MicroHandler() micro.HandlerFunc {
+	return func(r micro.Request) {
+		go func() {
+			data, err := h.handle(r.Data(), r.Headers().Get(AuthorizationHeader))
+			if err != nil {
+				r.Error(errorCode(err), err.Error(), nil)
+				return
+			}
+			// Profiles above the server's max payload cannot be sent
+			if err := r.Respond(data); err != nil {
+				r.Error("500", fmt.Sprintf("failed to send %d byte response: %v", len(data), err), nil)
+			}
+		}()
+	}
+}
+
+// Serve answers profiling requests on a subject with a plain subscription, for
+// processes without a micro service. Errors are answered with the micro error headers.
+func (h *Handler) Serve(nc *nats.Conn, subject string) (*nats.Subscription, error) {
+	sub, err := nc.Subscribe(subject, func(msg *nats.Msg) {
+		go func() {
+			token := ""
+			if msg.Header != nil {
+				token = msg.Header.Get(AuthorizationHeader)
+			}
+			reply := nats.NewMsg(msg.Reply)
+			data, err := h.handle(msg.Data, token)
+			if err == nil && int64(len(data)) > nc.MaxPayload() {
+				err = fmt.Errorf("%d byte response exceeds the server's max payload", len(data))
+			}
+			if err != nil {
+				reply.Header.Set(micro.ErrorCodeHeader, errorCode(err))
+				reply.Header.Set(micro.ErrorHeader, err.Error())
+			} else {
+				reply.Data = data
+			}
+			msg.RespondMsg(reply)
+		}()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to %s: %w", subject, err)
+	}
+	return sub, nil
+}
+
+// handle decodes and answers a request
+func (h *Handler) handle(data []byte, authorization string) ([]byte, error) {
+	req, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), MaxCPUDuration)
+	defer cancel()
+	return h.Profile(ctx, bearer(authorization), req)
+}
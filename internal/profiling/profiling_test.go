@@ -0,0 +1,126 @@
+package profiling
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testToken = "s3cret"
+
+func testHandler(t *testing.T) *Handler {
+	sum := sha256.Sum256([]byte(testToken))
+	h, err := NewHandler(hex.EncodeToString(sum[:]))
+	require.NoError(t, err)
+	return h
+}
+
+// gzipMagic starts every pprof protobuf
+var gzipMagic = []byte{0x1f, 0x8b}
+
+func TestHandlerProfile(t *testing.T) {
+	_, err := NewHandler("not-a-digest")
+	assert.Error(t, err)
+
+	h := testHandler(t)
+	ctx := context.Background()
+
+	_, err = h.Profile(ctx, "", Request{Kind: KindHeap})
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+	_, err = h.Profile(ctx, "wrong", Request{Kind: KindHeap})
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+
+	for _, kind := range []string{KindHeap, KindAllocs, KindGoroutine, KindBlock, KindMutex} {
+		data, err := h.Profile(ctx, testToken, Request{Kind: kind})
+		require.NoError(t, err, kind)
+		assert.True(t, bytes.HasPrefix(data, gzipMagic), kind)
+	}
+
+	_, err = h.Profile(ctx, testToken, Request{Kind: "trace"})
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+	_, err = h.Profile(ctx, testToken, Request{Kind: KindCPU, Seconds: 3600})
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+
+	// Only one CPU profile runs at a time; a cancelled request ends sampling early
+	cpuCtx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	var cpu []byte
+	var cpuErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		cpu, cpuErr = h.Profile(cpuCtx, testToken, Request{Kind: KindCPU, Seconds: 60})
+	}()
+	require.Eventually(t, func() bool {
+		if h.cpu.TryLock() {
+			h.cpu.Unlock()
+			return false
+		}
+		return true
+	}, time.Second, time.Millisecond)
+	_, err = h.Profile(ctx, testToken, Request{Kind: KindCPU, Seconds: 1})
+	assert.ErrorIs(t, err, ErrCPUProfileActive)
+	cancel()
+	wg.Wait()
+	require.NoError(t, cpuErr)
+	assert.True(t, bytes.HasPrefix(cpu, gzipMagic))
+}
+
+func TestReadMetrics(t *testing.T) {
+	samples := ReadMetrics()
+	require.NotEmpty(t, samples)
+
+	byName := make(map[string]Metric)
+	for _, m := range samples {
+		byName[m.Name] = m
+	}
+	goroutines, ok := byName["/sched/goroutines:goroutines"]
+	require.True(t, ok)
+	assert.Greater(t, goroutines.Value, 0.0)
+	pauses, ok := byName["/sched/pauses/total/gc:seconds"]
+	require.True(t, ok)
+	assert.NotNil(t, pauses.Histogram)
+}
+
+// TestServe tests profiling over NATS with error codes in the micro headers
+func TestServe(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+	}
+	defer nc.Close()
+
+	subject := "profiling-test." + nats.NewInbox()
+	sub, err := testHandler(t).Serve(nc, subject)
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = Fetch(ctx, nc, subject, "wrong", Request{Kind: KindHeap})
+	var profErr *Error
+	require.ErrorAs(t, err, &profErr)
+	assert.Equal(t, "401", profErr.Code)
+
+	_, err = Fetch(ctx, nc, subject, testToken, Request{Kind: "trace"})
+	require.ErrorAs(t, err, &profErr)
+	assert.Equal(t, "400", profErr.Code)
+
+	heap, err := Fetch(ctx, nc, subject, testToken, Request{Kind: KindHeap})
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(heap, gzipMagic))
+
+	samples, err := FetchMetrics(ctx, nc, subject, testToken)
+	require.NoError(t, err)
+	assert.NotEmpty(t, samples)
+}
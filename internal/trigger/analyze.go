@@ -0,0 +1,496 @@
+package trigger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/parser"
+)
+
+// Kinds of analysis findings
+const (
+	FindingAlwaysFalse = "always-false" // The criteria can never match
+	FindingOverlap     = "overlap"      // Two triggers can match the same event
+)
+
+// Finding is a problem detected by trigger analysis
+type Finding struct {
+	Kind     string   `json:"kind"`
+	Triggers []string `json:"triggers"`
+	Message  string   `json:"message"`
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s [%s]: %s", f.Kind, strings.Join(f.Triggers, ", "), f.Message)
+}
+
+// AnalyzeTriggers reports enabled triggers whose criteria are provably always false
+// and pairs of enabled triggers that can match the same event: their namespaces and
+// event types overlap and their criteria do not exclude each other.
+func AnalyzeTriggers(triggers []*Trigger) []Finding {
+	var enabled []*Trigger
+	for _, t := range triggers {
+		if t.Enabled {
+			enabled = append(enabled, t)
+		}
+	}
+	sort.Slice(enabled, func(i, j int) bool { return enabled[i].ID < enabled[j].ID })
+
+	analyses := make([]criteriaAnalysis, len(enabled))
+	var findings []Finding
+	for i, t := range enabled {
+		if t.Dialect == DialectCESQL {
+			// CESQL criteria are not analyzed, so they overlap nothing
+			continue
+		}
+		analyses[i] = analyzeCriteria(t.Criteria, t.Vars)
+		if analyses[i].contradiction != "" {
+			findings = append(findings, Finding{
+				Kind:     FindingAlwaysFalse,
+				Triggers: []string{t.ID},
+				Message:  fmt.Sprintf("criteria can never match: %s", analyses[i].contradiction),
+			})
+		}
+	}
+
+	for i := range enabled {
+		for j := i + 1; j < len(enabled); j++ {
+			if overlapping(enabled[i], enabled[j], analyses[i], analyses[j]) {
+				findings = append(findings, Finding{
+					Kind:     FindingOverlap,
+					Triggers: []string{enabled[i].ID, enabled[j].ID},
+					Message:  "triggers match the same events in overlapping namespaces",
+				})
+			}
+		}
+	}
+	return findings
+}
+
+// CheckTrigger analyzes a trigger against the other triggers of the store and returns
+// the findings that involve it
+func CheckTrigger(trigger *Trigger, others []*Trigger) []Finding {
+	triggers := []*Trigger{trigger}
+	for _, other := range others {
+		if other.ID != trigger.ID {
+			triggers = append(triggers, other)
+		}
+	}
+
+	var findings []Finding
+	for _, f := range AnalyzeTriggers(triggers) {
+		for _, id := range f.Triggers {
+			if id == trigger.ID {
+				findings = append(findings, f)
+				break
+			}
+		}
+	}
+	return findings
+}
+
+// overlapping reports whether two triggers can match the same event
+func overlapping(a, b *Trigger, ca, cb criteriaAnalysis) bool {
+	if ca.contradiction != "" || cb.contradiction != "" || !ca.exact || !cb.exact {
+		return false
+	}
+	if !eventTypesOverlap(a.EventType, b.EventType) || !namespacesOverlap(a.Namespaces, b.Namespaces) {
+		return false
+	}
+
+	// Both criteria are conjunctions of literal comparisons: they overlap unless combining them contradicts
+	merged := criteriaAnalysis{constraints: make(map[string]*pathConstraint)}
+	for _, c := range []criteriaAnalysis{ca, cb} {
+		for path, constraint := range c.constraints {
+			for _, cond := range constraint.conds {
+				if merged.add(path, cond.op, cond.value) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// eventTypesOverlap reports whether two trigger event types can match the same event
+func eventTypesOverlap(a, b string) bool {
+	if a == "" || b == "" || a == b {
+		return true
+	}
+	return eventTypeMatches(a, b) || eventTypeMatches(b, a)
+}
+
+// namespacesOverlap reports whether two trigger namespace lists can match the same namespace.
+// Two wildcard patterns are conservatively assumed to overlap.
+func namespacesOverlap(a, b []string) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return true
+	}
+	for _, pa := range a {
+		for _, pb := range b {
+			if strings.Contains(pa, "*") && strings.Contains(pb, "*") {
+				return true
+			}
+			if namespaceCovers(pa, pb) || namespaceCovers(pb, pa) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// namespaceCovers reports whether a pattern applies to a literal namespace or one of its ancestors
+func namespaceCovers(pattern, namespace string) bool {
+	if strings.Contains(namespace, "*") {
+		return false
+	}
+	compiled := compileNamespacePattern(pattern)
+	for _, level := range namespaceHierarchy(namespace) {
+		if compiled.match(level) {
+			return true
+		}
+	}
+	return false
+}
+
+// criteriaAnalysis is what static analysis learned about a criteria expression
+type criteriaAnalysis struct {
+	// constraints are the literal comparisons the expression requires, by path
+	constraints map[string]*pathConstraint
+	// exact is set when the expression is fully described by constraints
+	exact bool
+	// contradiction describes why the expression can never be true, empty if unknown
+	contradiction string
+	// vars are the trigger's vars, substituted for their references
+	vars map[string]interface{}
+}
+
+// analyzeCriteria parses a criteria expression and collects its constraints.
+// References to the trigger's vars are analyzed as the literals they hold.
+// Expressions that do not parse are left to validation and reported as not exact.
+func analyzeCriteria(criteria string, vars map[string]interface{}) criteriaAnalysis {
+	a := criteriaAnalysis{constraints: make(map[string]*pathConstraint), exact: true, vars: vars}
+	if strings.TrimSpace(criteria) == "" {
+		return a
+	}
+
+	tree, err := parser.Parse(criteria)
+	if err != nil {
+		a.exact = false
+		return a
+	}
+	a.visit(tree.Node)
+	return a
+}
+
+// visit collects the constraints of a node that must be true
+func (a *criteriaAnalysis) visit(node ast.Node) {
+	if a.contradiction != "" {
+		return
+	}
+
+	switch n := node.(type) {
+	case *ast.BoolNode:
+		if !n.Value {
+			a.contradiction = "criteria is the literal false"
+		}
+		return
+
+	case *ast.BinaryNode:
+		switch n.Operator {
+		case "&&", "and":
+			a.visit(n.Left)
+			a.visit(n.Right)
+			return
+		case "||", "or":
+			// Only provable when both branches are
+			left, right := a.analyzeNode(n.Left), a.analyzeNode(n.Right)
+			if left.contradiction != "" && right.contradiction != "" {
+				a.contradiction = left.contradiction + " and " + right.contradiction
+			}
+			a.exact = false
+			return
+		case "==", "!=", "<", "<=", ">", ">=":
+			path, value, op, ok := a.comparison(n)
+			if ok {
+				if a.add(path, op, value) {
+					a.contradiction = a.constraints[path].describe(path)
+				}
+				return
+			}
+		}
+
+	case *ast.UnaryNode:
+		if n.Operator == "!" || n.Operator == "not" {
+			if value, ok := a.variable(n.Node); ok {
+				if value == true {
+					a.contradiction = fmt.Sprintf("%s is true", formatNode(n.Node))
+				}
+				if value == true || value == false {
+					return
+				}
+			} else if path, ok := memberPath(n.Node); ok && !isVarPath(path) {
+				if a.add(path, "==", false) {
+					a.contradiction = a.constraints[path].describe(path)
+				}
+				return
+			}
+		}
+
+	case *ast.MemberNode, *ast.IdentifierNode:
+		if value, ok := a.variable(n); ok {
+			if value == false {
+				a.contradiction = fmt.Sprintf("%s is false", formatNode(n))
+			}
+			if value == true || value == false {
+				return
+			}
+		} else if path, ok := memberPath(n); ok && !isVarPath(path) {
+			if a.add(path, "==", true) {
+				a.contradiction = a.constraints[path].describe(path)
+			}
+			return
+		}
+	}
+
+	a.exact = false
+}
+
+// analyzeNode analyzes a sub-expression on its own
+func (a *criteriaAnalysis) analyzeNode(node ast.Node) criteriaAnalysis {
+	sub := criteriaAnalysis{constraints: make(map[string]*pathConstraint), exact: true, vars: a.vars}
+	sub.visit(node)
+	return sub
+}
+
+// add records a constraint and reports whether it contradicts the ones before it
+func (a *criteriaAnalysis) add(path, op string, value interface{}) bool {
+	constraint, ok := a.constraints[path]
+	if !ok {
+		constraint = &pathConstraint{}
+		a.constraints[path] = constraint
+	}
+	constraint.conds = append(constraint.conds, condition{op: op, value: value})
+	return !constraint.satisfiable()
+}
+
+// comparison extracts "path op literal" from a comparison, flipping "literal op path"
+func (a *criteriaAnalysis) comparison(n *ast.BinaryNode) (string, interface{}, string, bool) {
+	if path, ok := memberPath(n.Left); ok && !isVarPath(path) {
+		if value, ok := a.literal(n.Right); ok {
+			return path, value, n.Operator, true
+		}
+	}
+	if path, ok := memberPath(n.Right); ok && !isVarPath(path) {
+		if value, ok := a.literal(n.Left); ok {
+			flipped := map[string]string{"<": ">", "<=": ">=", ">": "<", ">=": "<="}[n.Operator]
+			if flipped == "" {
+				flipped = n.Operator
+			}
+			return path, value, flipped, true
+		}
+	}
+	return "", nil, "", false
+}
+
+// memberPath returns the dotted path of a member access like event.data.after.status
+func memberPath(node ast.Node) (string, bool) {
+	switch n := node.(type) {
+	case *ast.IdentifierNode:
+		return n.Value, true
+	case *ast.MemberNode:
+		property, ok := n.Property.(*ast.StringNode)
+		if !ok || n.Method {
+			return "", false
+		}
+		parent, ok := memberPath(n.Node)
+		if !ok {
+			return "", false
+		}
+		return parent + "." + property.Value, true
+	}
+	return "", false
+}
+
+// isVarPath reports whether a path refers to the trigger's vars
+func isVarPath(path string) bool {
+	return path == "vars" || strings.HasPrefix(path, "vars.")
+}
+
+// variable returns the scalar value of a reference to the trigger's vars.
+// Numbers are returned as float64; missing vars are nil, as they are at runtime.
+func (a *criteriaAnalysis) variable(node ast.Node) (interface{}, bool) {
+	path, ok := memberPath(node)
+	if !ok || !isVarPath(path) {
+		return nil, false
+	}
+
+	var value interface{} = a.vars
+	for _, key := range strings.Split(path, ".")[1:] {
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value = fields[key]
+	}
+
+	switch v := value.(type) {
+	case nil, string, bool, float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	}
+	return nil, false
+}
+
+// literal returns the value of a literal node or of a reference to the trigger's vars
+func (a *criteriaAnalysis) literal(node ast.Node) (interface{}, bool) {
+	if value, ok := a.variable(node); ok {
+		return value, true
+	}
+	return literal(node)
+}
+
+// formatNode renders a member access for a finding
+func formatNode(node ast.Node) string {
+	path, _ := memberPath(node)
+	return path
+}
+
+// literal returns the value of a literal node; numbers are returned as float64
+func literal(node ast.Node) (interface{}, bool) {
+	switch n := node.(type) {
+	case *ast.StringNode:
+		return n.Value, true
+	case *ast.IntegerNode:
+		return float64(n.Value), true
+	case *ast.FloatNode:
+		return n.Value, true
+	case *ast.BoolNode:
+		return n.Value, true
+	case *ast.NilNode:
+		return nil, true
+	}
+	return nil, false
+}
+
+// condition is a single comparison against a literal
+type condition struct {
+	op    string
+	value interface{}
+}
+
+// pathConstraint is the set of comparisons an expression requires of one path
+type pathConstraint struct {
+	conds []condition
+}
+
+// satisfiable reports whether some value fulfils every condition
+func (c *pathConstraint) satisfiable() bool {
+	var equal []interface{}
+	var notEqual []interface{}
+	lower, upper := bound{}, bound{}
+
+	for _, cond := range c.conds {
+		switch cond.op {
+		case "==":
+			equal = append(equal, cond.value)
+		case "!=":
+			notEqual = append(notEqual, cond.value)
+		default:
+			n, ok := cond.value.(float64)
+			if !ok {
+				continue
+			}
+			switch cond.op {
+			case ">":
+				lower = lower.tighten(n, false, true)
+			case ">=":
+				lower = lower.tighten(n, true, true)
+			case "<":
+				upper = upper.tighten(n, false, false)
+			case "<=":
+				upper = upper.tighten(n, true, false)
+			}
+		}
+	}
+
+	if lower.set && upper.set && (lower.value > upper.value ||
+		(lower.value == upper.value && !(lower.inclusive && upper.inclusive))) {
+		return false
+	}
+
+	for i, v := range equal {
+		if i > 0 && v != equal[0] {
+			return false
+		}
+		for _, ne := range notEqual {
+			if v == ne {
+				return false
+			}
+		}
+		if n, ok := v.(float64); ok && (!lower.allows(n, true) || !upper.allows(n, false)) {
+			return false
+		}
+		if _, ok := v.(float64); !ok && (lower.set || upper.set) {
+			return false
+		}
+	}
+	return true
+}
+
+// describe renders the conditions of a path for a finding
+func (c *pathConstraint) describe(path string) string {
+	parts := make([]string, len(c.conds))
+	for i, cond := range c.conds {
+		parts[i] = fmt.Sprintf("%s %s %s", path, cond.op, formatLiteral(cond.value))
+	}
+	return strings.Join(parts, " && ")
+}
+
+// formatLiteral renders a literal the way it is written in expressions
+func formatLiteral(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return fmt.Sprintf("%q", v)
+	case nil:
+		return "nil"
+	}
+	return fmt.Sprint(value)
+}
+
+// bound is one side of a numeric interval
+type bound struct {
+	set       bool
+	value     float64
+	inclusive bool
+}
+
+// tighten narrows the bound; lower bounds keep the largest value, upper bounds the smallest
+func (b bound) tighten(value float64, inclusive, lower bool) bound {
+	if !b.set || (lower && value > b.value) || (!lower && value < b.value) {
+		return bound{set: true, value: value, inclusive: inclusive}
+	}
+	if value == b.value && !inclusive {
+		b.inclusive = false
+	}
+	return b
+}
+
+// allows reports whether a value lies on the permitted side of the bound
+func (b bound) allows(value float64, lower bool) bool {
+	switch {
+	case !b.set:
+		return true
+	case lower:
+		return value > b.value || (b.inclusive && value == b.value)
+	default:
+		return value < b.value || (b.inclusive && value == b.value)
+	}
+}
@@ -0,0 +1,91 @@
+package trigger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestAnalyzeCriteriaContradictions tests detection of criteria that can never match
+func TestAnalyzeCriteriaContradictions(t *testing.T) {
+	tests := []struct {
+		criteria string
+		never    bool
+	}{
+		{`event.data.after.status == "active" && event.data.after.status == "deleted"`, true},
+		{`event.data.after.status == "active" && event.data.after.status != "active"`, true},
+		{`event.data.after.usage > 90 && event.data.after.usage < 50`, true},
+		{`event.data.after.usage >= 90 && event.data.after.usage == 80`, true},
+		{`event.data.after.usage > 90 && event.data.after.usage <= 90`, true},
+		{`90 < event.data.after.usage && event.data.after.usage < 80`, true},
+		{`false`, true},
+		{`event.data.after.enabled && !event.data.after.enabled`, true},
+		{`(event.actor.type == "a" && event.actor.type == "b") || false`, true},
+		{`event.data.after.usage >= 90 && event.data.after.usage <= 90`, false},
+		{`event.data.after.status == "active" || event.data.after.status == "deleted"`, false},
+		{`event.data.after.usage > 50 && event.data.after.usage < 90`, false},
+		{`has(event.data.after.usage) && event.data.after.usage > 1`, false},
+		{``, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.criteria, func(t *testing.T) {
+			a := analyzeCriteria(tt.criteria, nil)
+			assert.Equal(t, tt.never, a.contradiction != "", a.contradiction)
+		})
+	}
+}
+
+// TestAnalyzeCriteriaVars tests that vars are analyzed as the literals they hold
+func TestAnalyzeCriteriaVars(t *testing.T) {
+	vars := map[string]interface{}{"min": 90, "max": 50, "strict": false, "limits": map[string]interface{}{"low": 10.0}}
+
+	a := analyzeCriteria(`event.data.after.usage > vars.min && event.data.after.usage < vars.max`, vars)
+	assert.Equal(t, "event.data.after.usage > 90 && event.data.after.usage < 50", a.contradiction)
+
+	a = analyzeCriteria(`vars.strict && event.data.after.usage > 1`, vars)
+	assert.Equal(t, "vars.strict is false", a.contradiction)
+
+	a = analyzeCriteria(`!vars.strict && vars.limits.low < event.data.after.usage`, vars)
+	assert.Empty(t, a.contradiction)
+	assert.True(t, a.exact)
+	assert.Contains(t, a.constraints, "event.data.after.usage")
+	assert.NotContains(t, a.constraints, "vars.strict")
+
+	// Vars are per trigger, so equal references in two triggers do not constrain the same value
+	triggers := []*Trigger{
+		{ID: "on", EventType: "user.updated", Criteria: `vars.enabled`, Vars: map[string]interface{}{"enabled": true}, Enabled: true},
+		{ID: "off", EventType: "user.updated", Criteria: `!vars.enabled`, Vars: map[string]interface{}{"enabled": false}, Enabled: true},
+	}
+	findings := AnalyzeTriggers(triggers)
+	require.Len(t, findings, 1)
+	assert.Equal(t, FindingOverlap, findings[0].Kind)
+}
+
+// TestAnalyzeTriggers tests overlap and always-false findings across triggers
+func TestAnalyzeTriggers(t *testing.T) {
+	triggers := []*Trigger{
+		{ID: "high-usage", Namespaces: []string{"prod"}, EventType: "resource.updated", Criteria: `event.data.after.usage > 90`, Enabled: true},
+		{ID: "critical-usage", Namespaces: []string{"prod"}, EventType: "resource.updated", Criteria: `event.data.after.usage > 95`, Enabled: true},
+		{ID: "low-usage", Namespaces: []string{"prod"}, EventType: "resource.updated", Criteria: `event.data.after.usage < 10`, Enabled: true},
+		{ID: "staging", Namespaces: []string{"staging"}, EventType: "resource.updated", Criteria: `event.data.after.usage > 90`, Enabled: true},
+		{ID: "other-type", Namespaces: []string{"prod"}, EventType: "resource.deleted", Criteria: `event.data.after.usage > 90`, Enabled: true},
+		{ID: "org-wide", Namespaces: []string{"org"}, EventType: "user.created", Enabled: true},
+		{ID: "team", Namespaces: []string{"org/team"}, EventType: "user.created", Enabled: true},
+		{ID: "broken", EventType: "user.deleted", Criteria: `event.actor.type == "a" && event.actor.type == "b"`, Enabled: true},
+		{ID: "disabled", Namespaces: []string{"prod"}, EventType: "resource.updated", Enabled: false},
+	}
+
+	findings := AnalyzeTriggers(triggers)
+	assert.ElementsMatch(t, []Finding{
+		{Kind: FindingAlwaysFalse, Triggers: []string{"broken"}, Message: `criteria can never match: event.actor.type == "a" && event.actor.type == "b"`},
+		{Kind: FindingOverlap, Triggers: []string{"critical-usage", "high-usage"}, Message: "triggers match the same events in overlapping namespaces"},
+		{Kind: FindingOverlap, Triggers: []string{"org-wide", "team"}, Message: "triggers match the same events in overlapping namespaces"},
+	}, findings)
+
+	// Save-time checks only report findings involving the saved trigger
+	checked := CheckTrigger(triggers[0], triggers)
+	assert.Len(t, checked, 1)
+	assert.Equal(t, []string{"critical-usage", "high-usage"}, checked[0].Triggers)
+}
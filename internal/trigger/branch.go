@@ -0,0 +1,79 @@
+package trigger
+
+import (
+	"errors"
+	"fmt"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/expr-lang/expr/parser"
+)
+
+// ErrNoBranch is returned when no branch of a trigger applies to an event and the
+// trigger has no action to fall back to
+var ErrNoBranch = errors.New("no branch applies to the event")
+
+// Branch routes the events a trigger matched to an action when its condition holds,
+// so one trigger can take different actions, e.g. by severity
+type Branch struct {
+	// When is an expression evaluated like criteria, in the trigger's dialect.
+	// Example: event.data.after.severity == "critical"
+	When string `json:"when" yaml:"when"`
+	// Action is the action taken for the events the condition holds for
+	Action string `json:"action" yaml:"action"`
+}
+
+// SelectAction returns the trigger with the action to take for a matched event: the
+// action of the first branch whose condition holds, or the trigger's own action when
+// none does. Triggers without branches are returned as they are.
+func (t *Trigger) SelectAction(event *cloudevents.Event) (*Trigger, error) {
+	if len(t.Branches) == 0 {
+		return t, nil
+	}
+
+	// Conditions see the environment of the criteria, built once
+	var env map[string]interface{}
+	if t.Dialect != DialectCESQL {
+		var err error
+		if env, err = newCriteriaEnv(event, t.Vars); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, branch := range t.Branches {
+		var holds bool
+		var err error
+		if t.Dialect == DialectCESQL {
+			holds, err = runCESQL(event, branch.When)
+		} else {
+			holds, err = runCriteria(env, branch.When)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("branches[%d]: %w", i, err)
+		}
+		if holds {
+			selected := *t
+			selected.Action = branch.Action
+			return &selected, nil
+		}
+	}
+	if t.Action == "" {
+		return nil, ErrNoBranch
+	}
+	return t, nil
+}
+
+// validateBranches checks that the branch conditions parse in the trigger's dialect
+func (t *Trigger) validateBranches() ValidationErrors {
+	var errs ValidationErrors
+	for i, branch := range t.Branches {
+		field := fmt.Sprintf("branches[%d].when", i)
+		if t.Dialect == DialectCESQL {
+			if _, err := parseCESQL(branch.When); err != nil {
+				errs = append(errs, ValidationError{Field: field, Message: fmt.Sprintf("invalid CESQL expression: %v", err)})
+			}
+		} else if _, err := parser.Parse(branch.When); err != nil {
+			errs = append(errs, ValidationError{Field: field, Message: fmt.Sprintf("invalid expression: %v", err)})
+		}
+	}
+	return errs
+}
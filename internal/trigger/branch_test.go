@@ -0,0 +1,77 @@
+package trigger
+
+import (
+	"testing"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// alertRaised returns an alert event of the given severity
+func alertRaised(severity string) *cloudevents.Event {
+	e := cloudevents.NewEvent()
+	e.SetID("alert-" + severity)
+	e.SetSource("monitoring")
+	e.SetType("ops.alert.raised")
+	e.SetExtension("severity", severity)
+	e.SetData(cloudevents.ApplicationJSON, map[string]interface{}{"after": map[string]interface{}{"severity": severity}})
+	return &e
+}
+
+// TestBranchValidation tests the branch checks of trigger validation
+func TestBranchValidation(t *testing.T) {
+	_, err := ParseYAML([]byte("id: alerts\nbranches:\n  - when: event.data.after.severity == \"critical\"\n    action: function:page-oncall\n"))
+	assert.NoError(t, err)
+
+	_, err = ParseYAML([]byte("id: alerts\nbranches:\n  - when: event.data.after.severity ==\n    action: function:page-oncall\n"))
+	assert.ErrorContains(t, err, "branches[0].when: invalid expression")
+
+	_, err = ParseYAML([]byte("id: alerts\nbranches:\n  - when: \"true\"\n"))
+	assert.ErrorContains(t, err, "branches[0].action: required field is missing")
+
+	trig := &Trigger{ID: "alerts", Dialect: DialectCESQL, Branches: []Branch{{When: "severity =", Action: "log"}}}
+	assert.ErrorContains(t, trig.Validate(), "branches[0].when: invalid CESQL expression")
+}
+
+// TestSelectAction tests that the first branch whose condition holds picks the action
+func TestSelectAction(t *testing.T) {
+	trig := &Trigger{
+		ID:     "alerts",
+		Action: "webhook:https://chat.example.com/hooks/ops",
+		Vars:   map[string]interface{}{"paged": []interface{}{"critical", "high"}},
+		Branches: []Branch{
+			{When: `event.data.after.severity in vars.paged`, Action: "function:page-oncall"},
+			{When: `event.data.after.severity == "medium"`, Action: "function:open-ticket"},
+		},
+	}
+
+	for severity, action := range map[string]string{
+		"critical": "function:page-oncall",
+		"high":     "function:page-oncall",
+		"medium":   "function:open-ticket",
+		"low":      "webhook:https://chat.example.com/hooks/ops",
+	} {
+		selected, err := trig.SelectAction(alertRaised(severity))
+		require.NoError(t, err)
+		assert.Equal(t, action, selected.Action, severity)
+	}
+	assert.Equal(t, "webhook:https://chat.example.com/hooks/ops", trig.Action, "the trigger is not modified")
+
+	// Without an action to fall back to, unmatched events take no action
+	trig.Action = ""
+	_, err := trig.SelectAction(alertRaised("low"))
+	assert.ErrorIs(t, err, ErrNoBranch)
+
+	// Conditions are evaluated in the trigger's dialect
+	cesql := &Trigger{ID: "alerts", Dialect: DialectCESQL, Branches: []Branch{{When: "severity = 'critical'", Action: "function:page-oncall"}}}
+	selected, err := cesql.SelectAction(alertRaised("critical"))
+	require.NoError(t, err)
+	assert.Equal(t, "function:page-oncall", selected.Action)
+
+	// Triggers without branches are returned as they are
+	plain := &Trigger{ID: "plain", Action: "log"}
+	selected, err = plain.SelectAction(alertRaised("low"))
+	require.NoError(t, err)
+	assert.Same(t, plain, selected)
+}
@@ -0,0 +1,99 @@
+package trigger
+
+import (
+	"fmt"
+	"sync"
+
+	cesql "github.com/cloudevents/sdk-go/sql/v2"
+	cesqlparser "github.com/cloudevents/sdk-go/sql/v2/parser"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// Criteria dialects
+const (
+	// DialectExpr evaluates criteria with the expr language against the event environment
+	DialectExpr = "expr"
+	// DialectCESQL evaluates criteria with the CloudEvents SQL expression language
+	// against the event's context attributes and extensions
+	DialectCESQL = "cesql"
+)
+
+// cesqlCache holds parsed CESQL expressions keyed by their source, since parsing is
+// far more expensive than evaluating them
+var cesqlCache sync.Map
+
+// parseCESQL returns the parsed form of a CESQL expression, using the cache when possible
+func parseCESQL(expression string) (parsed cesql.Expression, err error) {
+	if cached, ok := cesqlCache.Load(expression); ok {
+		return cached.(cesql.Expression), nil
+	}
+	// The SDK's parser panics on some incomplete expressions, e.g. "type ="
+	defer func() {
+		if r := recover(); r != nil {
+			parsed, err = nil, fmt.Errorf("incomplete expression: %v", r)
+		}
+	}()
+	parsed, err = cesqlparser.Parse(expression)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := cesqlCache.LoadOrStore(expression, parsed)
+	return actual.(cesql.Expression), nil
+}
+
+// runCESQL evaluates a boolean CESQL expression against an event. As for CESQL
+// filters, an expression whose evaluation fails, e.g. because it references an
+// extension the event does not have, is false.
+func runCESQL(event *cloudevents.Event, expression string) (bool, error) {
+	parsed, err := parseCESQL(expression)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse CESQL expression: %w", err)
+	}
+	output, err := parsed.Evaluate(*event)
+	if err != nil {
+		return false, nil
+	}
+	result, ok := output.(bool)
+	if !ok {
+		return false, fmt.Errorf("CESQL expression did not return a boolean")
+	}
+	return result, nil
+}
+
+// matchCESQL evaluates the CESQL criteria and except of a trigger
+func matchCESQL(t *Trigger, event *cloudevents.Event) (bool, error) {
+	if t.Criteria != "" {
+		matches, err := runCESQL(event, t.Criteria)
+		if err != nil || !matches {
+			return false, err
+		}
+	}
+	if t.Except == "" {
+		return true, nil
+	}
+	excepted, err := runCESQL(event, t.Except)
+	if err != nil {
+		return false, fmt.Errorf("except: %w", err)
+	}
+	return !excepted, nil
+}
+
+// validateDialect checks the dialect and, for CESQL, that criteria and except parse
+func (t *Trigger) validateDialect() ValidationErrors {
+	var errs ValidationErrors
+	switch t.Dialect {
+	case "", DialectExpr:
+	case DialectCESQL:
+		for _, field := range []struct{ name, expression string }{{"criteria", t.Criteria}, {"except", t.Except}} {
+			if field.expression == "" {
+				continue
+			}
+			if _, err := parseCESQL(field.expression); err != nil {
+				errs = append(errs, ValidationError{Field: field.name, Message: fmt.Sprintf("invalid CESQL expression: %v", err)})
+			}
+		}
+	default:
+		errs = append(errs, ValidationError{Field: "dialect", Message: fmt.Sprintf("must be %s or %s", DialectExpr, DialectCESQL)})
+	}
+	return errs
+}
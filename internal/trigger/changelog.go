@@ -0,0 +1,282 @@
+package trigger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// DefaultChangelogStream is the JetStream stream trigger mutations are recorded in
+const DefaultChangelogStream = "TRIGGER_CHANGELOG"
+
+// DefaultChangelogSubject is the subject prefix of changelog records; the records of a
+// trigger are published to <prefix>.<namespace>.<id>
+const DefaultChangelogSubject = "triggers.changelog"
+
+// Changelog operations
+const (
+	ChangeCreate = "create"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// ErrNoChangelog is returned for history requests to a store without a changelog
+var ErrNoChangelog = errors.New("trigger store has no changelog")
+
+// ErrRevisionNotFound is returned for a changelog revision that does not exist or
+// belongs to another trigger
+var ErrRevisionNotFound = errors.New("trigger revision not found")
+
+// ChangeRecord is one mutation of a trigger in the changelog
+type ChangeRecord struct {
+	// Revision is the sequence of the record in the changelog stream, set when read
+	Revision  uint64    `json:"revision,omitempty"`
+	Time      time.Time `json:"time"`
+	Actor     string    `json:"actor,omitempty"`
+	Operation string    `json:"operation"`
+	Namespace string    `json:"namespace"`
+	ID        string    `json:"id"`
+	// Before and After are the YAML definitions around the change; Before is empty
+	// for creations and After for deletions
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+	// RestoredFrom is the revision a restore brought the trigger back to
+	RestoredFrom uint64 `json:"restored_from,omitempty"`
+}
+
+// Trigger decodes the definition the change left the trigger with, nil for deletions
+func (r *ChangeRecord) Trigger() (*Trigger, error) {
+	if r.After == "" {
+		return nil, nil
+	}
+	t, err := ParseYAML([]byte(r.After))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode revision %d: %w", r.Revision, err)
+	}
+	return t, nil
+}
+
+type actorContextKey struct{}
+
+// WithActor returns a context naming who makes the trigger changes done with it, for
+// the changelog
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorContextKey{}, actor)
+}
+
+// ActorFromContext returns the actor set by WithActor, or an empty string
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorContextKey{}).(string)
+	return actor
+}
+
+// Changelog is an append-only record of trigger mutations in a JetStream stream.
+// The stream denies deleting and purging messages, so records cannot be rewritten.
+type Changelog struct {
+	js      nats.JetStreamContext
+	stream  string
+	subject string
+}
+
+// NewChangelog opens the changelog stream, creating it if needed. An empty stream or
+// subject uses DefaultChangelogStream and DefaultChangelogSubject.
+func NewChangelog(nc *nats.Conn, stream, subject string) (*Changelog, error) {
+	if stream == "" {
+		stream = DefaultChangelogStream
+	}
+	if subject == "" {
+		subject = DefaultChangelogSubject
+	}
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get JetStream context: %w", err)
+	}
+
+	_, err = js.StreamInfo(stream)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = js.AddStream(&nats.StreamConfig{
+			Name:        stream,
+			Description: "Append-only changelog of trigger mutations",
+			Subjects:    []string{subject + ".>"},
+			Storage:     nats.FileStorage,
+			DenyDelete:  true,
+			DenyPurge:   true,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get changelog stream: %w", err)
+	}
+	return &Changelog{js: js, stream: stream, subject: subject}, nil
+}
+
+// triggerSubject returns the subject of the records of a trigger
+func (c *Changelog) triggerSubject(namespace, id string) string {
+	return c.subject + "." + namespace + "." + id
+}
+
+// Append records a change and returns its revision
+func (c *Changelog) Append(record ChangeRecord) (uint64, error) {
+	record.Revision = 0
+	data, err := json.Marshal(record)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal change record: %w", err)
+	}
+	ack, err := c.js.Publish(c.triggerSubject(record.Namespace, record.ID), data)
+	if err != nil {
+		return 0, fmt.Errorf("failed to append change record: %w", err)
+	}
+	return ack.Sequence, nil
+}
+
+// History returns the changes of a trigger, oldest first
+func (c *Changelog) History(ctx context.Context, namespace, id string) ([]ChangeRecord, error) {
+	subject := c.triggerSubject(namespace, id)
+	if _, err := c.js.GetLastMsg(c.stream, subject); errors.Is(err, nats.ErrMsgNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read changelog: %w", err)
+	}
+
+	sub, err := c.js.SubscribeSync(subject, nats.BindStream(c.stream), nats.OrderedConsumer(), nats.DeliverAll())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read changelog: %w", err)
+	}
+	defer sub.Unsubscribe()
+
+	var records []ChangeRecord
+	for {
+		msg, err := sub.NextMsgWithContext(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read changelog: %w", err)
+		}
+		meta, err := msg.Metadata()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read changelog: %w", err)
+		}
+		record, err := decodeChangeRecord(msg.Data, meta.Sequence.Stream)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+		if meta.NumPending == 0 {
+			return records, nil
+		}
+	}
+}
+
+// Revision returns one change of a trigger
+func (c *Changelog) Revision(namespace, id string, revision uint64) (ChangeRecord, error) {
+	msg, err := c.js.GetMsg(c.stream, revision)
+	if errors.Is(err, nats.ErrMsgNotFound) {
+		return ChangeRecord{}, fmt.Errorf("%w: %s revision %d", ErrRevisionNotFound, id, revision)
+	}
+	if err != nil {
+		return ChangeRecord{}, fmt.Errorf("failed to read changelog: %w", err)
+	}
+	if msg.Subject != c.triggerSubject(namespace, id) {
+		return ChangeRecord{}, fmt.Errorf("%w: %s revision %d", ErrRevisionNotFound, id, revision)
+	}
+	return decodeChangeRecord(msg.Data, revision)
+}
+
+func decodeChangeRecord(data []byte, revision uint64) (ChangeRecord, error) {
+	var record ChangeRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return ChangeRecord{}, fmt.Errorf("failed to unmarshal change record %d: %w", revision, err)
+	}
+	record.Revision = revision
+	return record, nil
+}
+
+// triggerYAML returns the YAML definition of a trigger for a change record
+func triggerYAML(t *Trigger) (string, error) {
+	if t == nil {
+		return "", nil
+	}
+	data, err := t.ToYAML()
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal trigger: %w", err)
+	}
+	return string(data), nil
+}
+
+// SetChangelog makes the store record its mutations in a changelog, nil disables it.
+// Records are appended after the change is stored and failures are only logged: the
+// KV bucket remains the source of truth.
+func (s *NATSStore) SetChangelog(changelog *Changelog) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changelog = changelog
+}
+
+// recordChange appends a mutation to the changelog, if the store has one
+func (s *NATSStore) recordChange(ctx context.Context, operation, namespace, name string, before, after *Trigger, restoredFrom uint64) {
+	s.mu.RLock()
+	changelog := s.changelog
+	s.mu.RUnlock()
+	if changelog == nil {
+		return
+	}
+
+	record := ChangeRecord{
+		Time:         time.Now().UTC(),
+		Actor:        ActorFromContext(ctx),
+		Operation:    operation,
+		Namespace:    namespace,
+		ID:           name,
+		RestoredFrom: restoredFrom,
+	}
+	var err error
+	if record.Before, err = triggerYAML(before); err == nil {
+		record.After, err = triggerYAML(after)
+	}
+	if err == nil {
+		_, err = changelog.Append(record)
+	}
+	if err != nil {
+		log.Printf("Error recording %s of trigger %s.%s in the changelog: %v", operation, namespace, name, err)
+	}
+}
+
+// History returns the changelog records of a trigger, oldest first
+func (s *NATSStore) History(ctx context.Context, namespace, name string) ([]ChangeRecord, error) {
+	s.mu.RLock()
+	changelog := s.changelog
+	s.mu.RUnlock()
+	if changelog == nil {
+		return nil, ErrNoChangelog
+	}
+	return changelog.History(ctx, namespace, name)
+}
+
+// RestoreTrigger saves a trigger as a changelog revision left it. Restoring the
+// revision of a deletion deletes the trigger.
+func (s *NATSStore) RestoreTrigger(ctx context.Context, namespace, name string, revision uint64) (*Trigger, error) {
+	s.mu.RLock()
+	changelog := s.changelog
+	s.mu.RUnlock()
+	if changelog == nil {
+		return nil, ErrNoChangelog
+	}
+
+	record, err := changelog.Revision(namespace, name, revision)
+	if err != nil {
+		return nil, err
+	}
+	t, err := record.Trigger()
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, s.deleteTrigger(ctx, namespace, name, revision)
+	}
+	if err := s.saveTrigger(ctx, namespace, name, t, revision); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
@@ -0,0 +1,85 @@
+package trigger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestChangelog tests recording trigger mutations and restoring a revision
+func TestChangelog(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	if _, err := js.AccountInfo(); err != nil {
+		t.Skip("JetStream not available, skipping integration test")
+	}
+
+	suffix := uuid.NewString()[:8]
+	bucket := "changelog-test-" + suffix
+	stream := "CHANGELOG_TEST_" + suffix
+	defer js.DeleteKeyValue(bucket)
+	defer js.DeleteStream(stream)
+
+	store, err := NewNATSStore(nc, bucket)
+	require.NoError(t, err)
+	store.SetLifecycleSubject("")
+	changelog, err := NewChangelog(nc, stream, "changelog-test-"+suffix)
+	require.NoError(t, err)
+	store.SetChangelog(changelog)
+
+	ctx := WithActor(context.Background(), "alice")
+	trig := &Trigger{ID: "big-orders", Enabled: true, Criteria: "event.data.total > 100", Action: "notify"}
+	require.NoError(t, store.SaveTrigger(ctx, "default", trig.ID, trig))
+	updated := *trig
+	updated.Criteria = "event.data.total > 500"
+	require.NoError(t, store.SaveTrigger(WithActor(context.Background(), "bob"), "default", trig.ID, &updated))
+	require.NoError(t, store.DeleteTrigger(ctx, "default", trig.ID))
+
+	history, err := store.History(ctx, "default", trig.ID)
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	assert.Equal(t, ChangeCreate, history[0].Operation)
+	assert.Equal(t, "alice", history[0].Actor)
+	assert.Empty(t, history[0].Before)
+	assert.Contains(t, history[0].After, "event.data.total > 100")
+	assert.Equal(t, ChangeUpdate, history[1].Operation)
+	assert.Equal(t, "bob", history[1].Actor)
+	assert.Equal(t, history[0].After, history[1].Before)
+	assert.Equal(t, ChangeDelete, history[2].Operation)
+	assert.Empty(t, history[2].After)
+
+	// Restoring the first revision brings the deleted trigger back as created
+	restored, err := store.RestoreTrigger(ctx, "default", trig.ID, history[0].Revision)
+	require.NoError(t, err)
+	assert.Equal(t, "event.data.total > 100", restored.Criteria)
+	stored, err := store.getStored("default." + trig.ID)
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	assert.Equal(t, "event.data.total > 100", stored.Criteria)
+
+	history, err = store.History(ctx, "default", trig.ID)
+	require.NoError(t, err)
+	require.Len(t, history, 4)
+	assert.Equal(t, ChangeCreate, history[3].Operation)
+	assert.Equal(t, history[0].Revision, history[3].RestoredFrom)
+
+	// Revisions of other triggers are not found
+	_, err = store.RestoreTrigger(ctx, "default", "other", history[0].Revision)
+	assert.ErrorIs(t, err, ErrRevisionNotFound)
+	other, err := store.History(ctx, "default", "other")
+	require.NoError(t, err)
+	assert.Empty(t, other)
+
+	// Records cannot be removed from the changelog
+	assert.Error(t, js.DeleteMsg(stream, history[0].Revision))
+}
@@ -0,0 +1,91 @@
+package trigger
+
+import (
+	"fmt"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/parser"
+)
+
+// Concurrency scopes
+const (
+	// ConcurrencyScopeGlobal limits the executions of the trigger's action together
+	ConcurrencyScopeGlobal = "global"
+	// ConcurrencyScopeObject limits the executions of the trigger's action per object
+	ConcurrencyScopeObject = "object"
+)
+
+// DefaultConcurrencyQueue is how many matches wait for a free slot by default
+const DefaultConcurrencyQueue = 100
+
+// Concurrency limits how many executions of a trigger's action run at once, e.g. a
+// remediation function that must run one at a time per object. Matches beyond the
+// limit wait for a free slot in order.
+type Concurrency struct {
+	// Max is the number of executions running at once
+	Max int `json:"max" yaml:"max"`
+	// Scope is global (default) or object, limiting the executions for each object
+	Scope string `json:"scope,omitempty" yaml:"scope,omitempty"`
+	// Key is an expression evaluated like criteria whose result identifies the object
+	// of an event in object scope (default: event.object_id), e.g. event.data.after.id
+	Key string `json:"key,omitempty" yaml:"key,omitempty"`
+	// Queue is how many matches wait for a free slot before further matches are
+	// skipped (default: DefaultConcurrencyQueue, negative skips every excess match)
+	Queue int `json:"queue,omitempty" yaml:"queue,omitempty"`
+}
+
+// QueueLimit returns how many matches may wait for a free slot
+func (c *Concurrency) QueueLimit() int {
+	switch {
+	case c.Queue < 0:
+		return 0
+	case c.Queue == 0:
+		return DefaultConcurrencyQueue
+	}
+	return c.Queue
+}
+
+// validate checks the concurrency parameters beyond what the schema can express
+func (c *Concurrency) validate() ValidationErrors {
+	var errs ValidationErrors
+	if c.Max < 1 {
+		errs = append(errs, ValidationError{Field: "concurrency.max", Message: "must be at least 1"})
+	}
+	if c.Scope != "" && c.Scope != ConcurrencyScopeGlobal && c.Scope != ConcurrencyScopeObject {
+		errs = append(errs, ValidationError{Field: "concurrency.scope", Message: fmt.Sprintf("must be %s or %s", ConcurrencyScopeGlobal, ConcurrencyScopeObject)})
+	}
+	if c.Key != "" {
+		if c.Scope != ConcurrencyScopeObject {
+			errs = append(errs, ValidationError{Field: "concurrency.key", Message: "only applies to object scope"})
+		} else if _, err := parser.Parse(c.Key); err != nil {
+			errs = append(errs, ValidationError{Field: "concurrency.key", Message: fmt.Sprintf("invalid expression: %v", err)})
+		}
+	}
+	return errs
+}
+
+// ConcurrencyKey returns the key of the slots an event's execution of the trigger's
+// action takes: the trigger ID and, in object scope, a hash of the object
+func (t *Trigger) ConcurrencyKey(event *cloudevents.Event) (string, error) {
+	if t.Concurrency == nil || t.Concurrency.Scope != ConcurrencyScopeObject {
+		return t.ID, nil
+	}
+	env, err := newExprEnv(event, t.Vars)
+	if err != nil {
+		return "", err
+	}
+	key := t.Concurrency.Key
+	if key == "" {
+		key = "event.object_id"
+	}
+	program, err := expr.Compile(key, exprOptions(env)...)
+	if err != nil {
+		return "", fmt.Errorf("failed to compile concurrency key of trigger %s: %w", t.ID, err)
+	}
+	object, err := expr.Run(program, env)
+	if err != nil {
+		return "", fmt.Errorf("failed to evaluate concurrency key of trigger %s: %w", t.ID, err)
+	}
+	return fmt.Sprintf("%s.%016x", t.ID, hashKey(fmt.Sprint(object))), nil
+}
@@ -0,0 +1,53 @@
+package trigger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConcurrencyValidation tests the concurrency checks of trigger validation
+func TestConcurrencyValidation(t *testing.T) {
+	_, err := ParseYAML([]byte("id: remediate\nconcurrency:\n  max: 1\n  scope: object\n  key: event.actor.id\n"))
+	assert.NoError(t, err)
+
+	_, err = ParseYAML([]byte("id: remediate\nconcurrency:\n  scope: object\n"))
+	assert.ErrorContains(t, err, "concurrency.max")
+
+	_, err = ParseYAML([]byte("id: remediate\nconcurrency:\n  max: 0\n  scope: cluster\n  key: event.actor.id\n"))
+	var errs ValidationErrors
+	require.ErrorAs(t, err, &errs)
+	assert.Len(t, errs, 3)
+
+	trig := &Trigger{ID: "remediate", Concurrency: &Concurrency{Max: 1, Scope: ConcurrencyScopeObject, Key: "event.actor.id =="}}
+	assert.ErrorContains(t, trig.Validate(), "concurrency.key: invalid expression")
+}
+
+// TestConcurrencyKey tests that object scope keys the slots by the event's object
+func TestConcurrencyKey(t *testing.T) {
+	at := time.Now()
+	trig := &Trigger{ID: "remediate", Concurrency: &Concurrency{Max: 1}}
+	key, err := trig.ConcurrencyKey(loginFailed("1", "alice", at))
+	require.NoError(t, err)
+	assert.Equal(t, "remediate", key)
+
+	trig.Concurrency = &Concurrency{Max: 1, Scope: ConcurrencyScopeObject, Key: "event.actor.id"}
+	alice, err := trig.ConcurrencyKey(loginFailed("1", "alice", at))
+	require.NoError(t, err)
+	again, err := trig.ConcurrencyKey(loginFailed("2", "alice", at))
+	require.NoError(t, err)
+	bob, err := trig.ConcurrencyKey(loginFailed("3", "bob", at))
+	require.NoError(t, err)
+	assert.Equal(t, alice, again)
+	assert.NotEqual(t, alice, bob)
+
+	// Without a key, every event is its own object
+	trig.Concurrency.Key = ""
+	first, err := trig.ConcurrencyKey(loginFailed("1", "alice", at))
+	require.NoError(t, err)
+	second, err := trig.ConcurrencyKey(loginFailed("2", "alice", at))
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second)
+}
@@ -0,0 +1,236 @@
+package trigger
+
+import (
+	"fmt"
+	"sort"
+
+	mevent "github.com/julianshen/mycelium/internal/event"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/parser"
+)
+
+// Condition coverage statuses
+const (
+	CoverageCovered     = "covered"     // The condition was both true and false
+	CoverageNeverTrue   = "never-true"  // Dead: the condition never held, so its branch never mattered
+	CoverageNeverFalse  = "never-false" // The condition always held and never filtered an event
+	CoverageUnevaluated = "unevaluated" // Short-circuiting or errors kept the condition from being evaluated
+)
+
+// ConditionCoverage counts the outcomes of one sub-expression of a trigger's criteria
+type ConditionCoverage struct {
+	// Condition is the sub-expression, rendered from the parsed criteria
+	Condition string `json:"condition"`
+	// Depth is the nesting level of the sub-expression, 0 for the whole criteria
+	Depth int `json:"depth"`
+	True  int `json:"true"`
+	False int `json:"false"`
+	// Skipped counts the events for which the condition was short-circuited
+	Skipped int `json:"skipped"`
+	Errors  int `json:"errors"`
+}
+
+// Status summarizes the outcomes of the condition
+func (c ConditionCoverage) Status() string {
+	switch {
+	case c.True > 0 && c.False > 0:
+		return CoverageCovered
+	case c.True > 0:
+		return CoverageNeverFalse
+	case c.False > 0:
+		return CoverageNeverTrue
+	}
+	return CoverageUnevaluated
+}
+
+// CriteriaCoverage is the sub-expression truth coverage of a trigger's criteria over
+// a corpus of events
+type CriteriaCoverage struct {
+	TriggerID string `json:"trigger_id"`
+	Criteria  string `json:"criteria"`
+	// Evaluated is the number of events the criteria were evaluated against: those of
+	// the trigger's event type and namespaces
+	Evaluated  int                 `json:"evaluated"`
+	Matched    int                 `json:"matched"`
+	Errors     int                 `json:"errors"`
+	Conditions []ConditionCoverage `json:"conditions"`
+}
+
+// Percent returns the share of condition outcomes, true and false, that were observed
+func (c CriteriaCoverage) Percent() float64 {
+	if len(c.Conditions) == 0 {
+		return 0
+	}
+	observed := 0
+	for _, cond := range c.Conditions {
+		if cond.True > 0 {
+			observed++
+		}
+		if cond.False > 0 {
+			observed++
+		}
+	}
+	return 100 * float64(observed) / float64(2*len(c.Conditions))
+}
+
+// Uncovered returns the conditions that were not both true and false
+func (c CriteriaCoverage) Uncovered() []ConditionCoverage {
+	var uncovered []ConditionCoverage
+	for _, cond := range c.Conditions {
+		if cond.Status() != CoverageCovered {
+			uncovered = append(uncovered, cond)
+		}
+	}
+	return uncovered
+}
+
+// MeasureCriteriaCoverage evaluates the criteria of each enabled trigger against the
+// events it applies to and records which sub-expressions were true, false or
+// short-circuited. Sub-expressions are the operands of &&, || and !, down to the
+// comparisons and calls they combine, evaluated in order with expr's short-circuit
+// semantics. Triggers without criteria or in the cesql dialect are omitted. The result
+// is sorted by trigger ID.
+func MeasureCriteriaCoverage(triggers []*Trigger, events []*cloudevents.Event) ([]CriteriaCoverage, error) {
+	var coverage []CriteriaCoverage
+	for _, t := range triggers {
+		if !t.Enabled || t.Criteria == "" || t.Dialect == DialectCESQL {
+			continue
+		}
+		tree, err := parser.Parse(t.Criteria)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse criteria of trigger %s: %w", t.ID, err)
+		}
+
+		c := CriteriaCoverage{TriggerID: t.ID, Criteria: t.Criteria}
+		root := newCoverageNode(tree.Node, 0, &c.Conditions)
+		for _, event := range events {
+			if t.IgnoreReplays && mevent.IsReplay(event) {
+				continue
+			}
+			if !eventTypeMatches(t.EventType, event.Type()) || !isNamespaceMatch(t, extractNamespaceFromType(event.Type())) {
+				continue
+			}
+			env, err := newExprEnv(event, t.Vars)
+			if err != nil {
+				return nil, err
+			}
+			c.Evaluated++
+			matched, ok := root.evaluate(env, c.Conditions)
+			switch {
+			case !ok:
+				c.Errors++
+			case matched:
+				c.Matched++
+			}
+		}
+		coverage = append(coverage, c)
+	}
+	sort.Slice(coverage, func(i, j int) bool { return coverage[i].TriggerID < coverage[j].TriggerID })
+	return coverage, nil
+}
+
+// coverageNode is a sub-expression of criteria and the index of its coverage counters
+type coverageNode struct {
+	node     ast.Node
+	operator string
+	operands []*coverageNode
+	index    int
+}
+
+// newCoverageNode registers a sub-expression and its boolean operands, depth first
+// in source order. Negations of plain conditions are kept as one condition.
+func newCoverageNode(node ast.Node, depth int, conditions *[]ConditionCoverage) *coverageNode {
+	n := &coverageNode{node: node, index: len(*conditions)}
+	*conditions = append(*conditions, ConditionCoverage{Condition: node.String(), Depth: depth})
+
+	switch b := node.(type) {
+	case *ast.BinaryNode:
+		switch b.Operator {
+		case "&&", "and":
+			n.operator = "&&"
+		case "||", "or":
+			n.operator = "||"
+		}
+		if n.operator != "" {
+			n.operands = []*coverageNode{
+				newCoverageNode(b.Left, depth+1, conditions),
+				newCoverageNode(b.Right, depth+1, conditions),
+			}
+		}
+	case *ast.UnaryNode:
+		if (b.Operator == "!" || b.Operator == "not") && isBooleanOperator(b.Node) {
+			n.operator = "!"
+			n.operands = []*coverageNode{newCoverageNode(b.Node, depth+1, conditions)}
+		}
+	}
+	return n
+}
+
+// isBooleanOperator reports whether a node combines conditions with &&, || or !
+func isBooleanOperator(node ast.Node) bool {
+	switch n := node.(type) {
+	case *ast.BinaryNode:
+		switch n.Operator {
+		case "&&", "and", "||", "or":
+			return true
+		}
+	case *ast.UnaryNode:
+		return n.Operator == "!" || n.Operator == "not"
+	}
+	return false
+}
+
+// evaluate evaluates the sub-expression against an environment and records the
+// outcome. ok is false when the sub-expression failed or did not return a boolean.
+func (n *coverageNode) evaluate(env map[string]interface{}, conditions []ConditionCoverage) (result bool, ok bool) {
+	defer func() {
+		switch {
+		case !ok:
+			conditions[n.index].Errors++
+		case result:
+			conditions[n.index].True++
+		default:
+			conditions[n.index].False++
+		}
+	}()
+
+	switch n.operator {
+	case "&&", "||":
+		left, ok := n.operands[0].evaluate(env, conditions)
+		if !ok {
+			n.operands[1].skip(conditions)
+			return false, false
+		}
+		// && stops at false and || at true
+		if left == (n.operator == "||") {
+			n.operands[1].skip(conditions)
+			return left, true
+		}
+		return n.operands[1].evaluate(env, conditions)
+	case "!":
+		operand, ok := n.operands[0].evaluate(env, conditions)
+		return !operand, ok
+	}
+
+	program, err := expr.Compile(n.node.String(), exprOptions(env)...)
+	if err != nil {
+		return false, false
+	}
+	output, err := expr.Run(program, env)
+	if err != nil {
+		return false, false
+	}
+	result, ok = output.(bool)
+	return result, ok
+}
+
+// skip records that a sub-expression and its operands were short-circuited
+func (n *coverageNode) skip(conditions []ConditionCoverage) {
+	conditions[n.index].Skipped++
+	for _, operand := range n.operands {
+		operand.skip(conditions)
+	}
+}
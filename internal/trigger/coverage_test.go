@@ -0,0 +1,86 @@
+package trigger
+
+import (
+	"fmt"
+	"testing"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMeasureCriteriaCoverage tests sub-expression truth coverage over a corpus of events
+func TestMeasureCriteriaCoverage(t *testing.T) {
+	newEvent := func(eventType string, after map[string]interface{}) *cloudevents.Event {
+		event := cloudevents.NewEvent()
+		event.SetID(fmt.Sprintf("event-%d", len(after)))
+		event.SetSource("test")
+		event.SetType(eventType)
+		require.NoError(t, event.SetData(cloudevents.ApplicationJSON, map[string]interface{}{"after": after}))
+		return &event
+	}
+	events := []*cloudevents.Event{
+		newEvent("prod.order.created", map[string]interface{}{"total": 500, "region": "eu"}),
+		newEvent("prod.order.created", map[string]interface{}{"total": 5000, "region": "us"}),
+		newEvent("prod.order.created", map[string]interface{}{"total": 50, "region": "us"}),
+		// Other event types are not evaluated
+		newEvent("prod.user.created", map[string]interface{}{"total": 5000, "region": "eu"}),
+	}
+
+	triggers := []*Trigger{
+		{
+			ID:        "large-orders",
+			Enabled:   true,
+			EventType: "prod.order.created",
+			Criteria:  `event.data.after.total > vars.limit && (event.data.after.region == "eu" || event.data.after.vip)`,
+			Vars:      map[string]interface{}{"limit": 100},
+		},
+		{ID: "no-criteria", Enabled: true, EventType: "prod.order.created"},
+		{ID: "disabled", Criteria: "true"},
+	}
+
+	coverage, err := MeasureCriteriaCoverage(triggers, events)
+	require.NoError(t, err)
+	require.Len(t, coverage, 1)
+	c := coverage[0]
+	assert.Equal(t, "large-orders", c.TriggerID)
+	assert.Equal(t, 3, c.Evaluated)
+	assert.Equal(t, 1, c.Matched)
+	// The second order has no vip field, which fails the comparison
+	assert.Equal(t, 1, c.Errors)
+
+	conditions := make(map[string]ConditionCoverage)
+	for _, cond := range c.Conditions {
+		conditions[cond.Condition] = cond
+	}
+	require.Len(t, conditions, 5)
+
+	total := conditions["event.data.after.total > vars.limit"]
+	assert.Equal(t, 1, total.Depth)
+	assert.Equal(t, 2, total.True)
+	assert.Equal(t, 1, total.False)
+	assert.Equal(t, CoverageCovered, total.Status())
+
+	region := conditions[`event.data.after.region == "eu"`]
+	assert.Equal(t, 2, region.Depth)
+	assert.Equal(t, 1, region.True)
+	assert.Equal(t, 1, region.False)
+	assert.Equal(t, 1, region.Skipped)
+
+	vip := conditions["event.data.after.vip"]
+	assert.Equal(t, 0, vip.True+vip.False)
+	assert.Equal(t, 1, vip.Errors)
+	assert.Equal(t, 2, vip.Skipped)
+	assert.Equal(t, CoverageUnevaluated, vip.Status())
+
+	assert.Len(t, c.Uncovered(), 2)
+	assert.InDelta(t, 70, c.Percent(), 0.01)
+}
+
+// TestCriteriaCoverageStatus tests the classification of condition outcomes
+func TestCriteriaCoverageStatus(t *testing.T) {
+	assert.Equal(t, CoverageCovered, ConditionCoverage{True: 1, False: 2}.Status())
+	assert.Equal(t, CoverageNeverTrue, ConditionCoverage{False: 3}.Status())
+	assert.Equal(t, CoverageNeverFalse, ConditionCoverage{True: 3, Skipped: 1}.Status())
+	assert.Equal(t, CoverageUnevaluated, ConditionCoverage{Skipped: 3, Errors: 1}.Status())
+}
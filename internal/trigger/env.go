@@ -0,0 +1,224 @@
+package trigger
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	mevent "github.com/julianshen/mycelium/internal/event"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/expr-lang/expr"
+	"github.com/nats-io/nats.go"
+)
+
+// DefaultEnvironmentSubject is the request subject that answers with the criteria expression environment
+const DefaultEnvironmentSubject = "admin.triggers.env"
+
+// exprFunction is a custom function available to criteria expressions
+type exprFunction struct {
+	name        string
+	signature   string
+	description string
+	fn          func(args ...any) (any, error)
+}
+
+// exprFunctions are the custom functions registered with every criteria expression
+var exprFunctions = []exprFunction{
+	{
+		name:        "has",
+		signature:   "has(obj, path string) bool",
+		description: `Reports whether every key along a dot-separated path exists, e.g. has(event.data.after, "spec.replicas")`,
+		fn:          has,
+	},
+}
+
+// envFieldDocs describes the fields of the expression environment.
+// The field list itself is generated from newExprEnv, so fields missing here are still reported.
+var envFieldDocs = map[string]string{
+	"event":                    "The event being matched",
+	"event.event_id":           "CloudEvent ID",
+	"event.event_type":         "CloudEvent type, prefixed with the namespace",
+	"event.event_version":      "CloudEvents spec version",
+	"event.namespace":          "First segment of the event type",
+	"event.object_type":        "Type of the changed object (currently always empty)",
+	"event.object_id":          "ID of the changed object (currently the event ID)",
+	"event.timestamp":          "CloudEvent time",
+	"event.actor":              "Who caused the event",
+	"event.actor.type":         "actortype extension",
+	"event.actor.id":           "actorid extension",
+	"event.context":            "Request context of the event",
+	"event.context.request_id": "requestid extension",
+	"event.context.trace_id":   "traceid extension",
+	"event.data":               "Change payload; only before and after are exposed",
+	"event.data.before":        "Object state before the change (arbitrary JSON, absent if not sent)",
+	"event.data.after":         "Object state after the change (arbitrary JSON, absent if not sent)",
+	"event.replay":             "Whether the event was republished by a replay",
+	"vars":                     "The trigger's vars, e.g. vars.threshold",
+}
+
+// newExprEnv builds the criteria expression environment for an event and a trigger's vars
+func newExprEnv(event *cloudevents.Event, vars map[string]interface{}) (map[string]interface{}, error) {
+	// Extract extensions
+	actorType, actorID, contextRequestID, contextTraceID := extractExtensions(event)
+
+	// Extract data from Data
+	data, err := extractData(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract data: %w", err)
+	}
+
+	// Only include 'before' and 'after' if present
+	dataMap := map[string]interface{}{}
+	if before, ok := data["before"]; ok {
+		dataMap["before"] = before
+	}
+	if after, ok := data["after"]; ok {
+		dataMap["after"] = after
+	}
+
+	// Create a map representation of the event that matches JSON field names
+	eventMap := map[string]interface{}{
+		"event_id":      event.ID(),
+		"event_type":    event.Type(),
+		"event_version": event.SpecVersion(),
+		"namespace":     extractNamespaceFromType(event.Type()),
+		"object_type":   "", // Not present in CloudEvent, unless you want to add as extension
+		"object_id":     objectID(event),
+		"timestamp":     event.Time(),
+		"actor": map[string]interface{}{
+			"type": actorType,
+			"id":   actorID,
+		},
+		"context": map[string]interface{}{
+			"request_id": contextRequestID,
+			"trace_id":   contextTraceID,
+		},
+		"data":   dataMap,
+		"replay": mevent.IsReplay(event),
+		// NATS metadata can be extracted from the NATS extension if needed
+	}
+
+	if vars == nil {
+		vars = map[string]interface{}{}
+	}
+
+	return map[string]interface{}{
+		"event": eventMap,
+		"vars":  vars,
+	}, nil
+}
+
+// exprOptions returns the compile options for criteria expressions over env
+func exprOptions(env map[string]interface{}) []expr.Option {
+	options := []expr.Option{expr.Env(env)}
+	for _, f := range exprFunctions {
+		options = append(options, expr.Function(f.name, f.fn))
+	}
+	return options
+}
+
+// EnvField describes a variable of the criteria expression environment
+type EnvField struct {
+	Path        string `json:"path"`
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+}
+
+// EnvFunction describes a custom function available to criteria expressions
+type EnvFunction struct {
+	Name        string `json:"name"`
+	Signature   string `json:"signature"`
+	Description string `json:"description"`
+}
+
+// Environment describes the criteria expression environment
+type Environment struct {
+	Fields    []EnvField    `json:"fields"`
+	Functions []EnvFunction `json:"functions"`
+}
+
+// ExprEnvironment returns the structure of the criteria expression environment.
+// It is generated from the environment the matcher actually builds, so it always
+// reflects the fields and functions criteria can use.
+func ExprEnvironment() Environment {
+	sample := cloudevents.NewEvent()
+	sample.SetData(cloudevents.ApplicationJSON, map[string]interface{}{
+		"before": map[string]interface{}{},
+		"after":  map[string]interface{}{},
+	})
+	env, _ := newExprEnv(&sample, nil)
+
+	var environment Environment
+	collectEnvFields("", env, &environment.Fields)
+	sort.Slice(environment.Fields, func(i, j int) bool {
+		return environment.Fields[i].Path < environment.Fields[j].Path
+	})
+
+	for _, f := range exprFunctions {
+		environment.Functions = append(environment.Functions, EnvFunction{
+			Name:        f.name,
+			Signature:   f.signature,
+			Description: f.description,
+		})
+	}
+	return environment
+}
+
+// collectEnvFields appends the fields of a (nested) environment map.
+// The before and after payloads are arbitrary JSON, so their contents are not walked.
+func collectEnvFields(prefix string, values map[string]interface{}, fields *[]EnvField) {
+	for key, value := range values {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		*fields = append(*fields, EnvField{
+			Path:        path,
+			Type:        envType(value),
+			Description: envFieldDocs[path],
+		})
+
+		if nested, ok := value.(map[string]interface{}); ok && path != "event.data.before" && path != "event.data.after" {
+			collectEnvFields(path, nested, fields)
+		}
+	}
+}
+
+// envType returns the expression type name of an environment value
+func envType(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case int, int64, float64:
+		return "number"
+	case time.Time:
+		return "time"
+	case map[string]interface{}:
+		return "map"
+	case []interface{}:
+		return "array"
+	default:
+		return "any"
+	}
+}
+
+// ServeEnvironment answers requests on subject with the JSON-encoded expression environment,
+// so editors and UIs can offer autocomplete that matches the running matcher
+func ServeEnvironment(nc *nats.Conn, subject string) (*nats.Subscription, error) {
+	data, err := json.Marshal(ExprEnvironment())
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal environment: %w", err)
+	}
+
+	sub, err := nc.Subscribe(subject, func(msg *nats.Msg) {
+		msg.Respond(data)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to %s: %w", subject, err)
+	}
+	return sub, nil
+}
@@ -0,0 +1,347 @@
+package trigger
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultFileStorePollInterval is how often a watching file store checks its directory for changes
+const DefaultFileStorePollInterval = 2 * time.Second
+
+// FileStore is a TriggerStore backed by a directory of YAML trigger definitions, one
+// trigger per .yaml or .yml file, for deployments without JetStream. Watch picks up
+// files that are added, changed or removed by polling the directory, so triggers can
+// be managed by editing files or with configuration management.
+type FileStore struct {
+	dir          string
+	pollInterval time.Duration
+	index        *namespaceIndex
+	// files maps trigger IDs to the file that defines them
+	files map[string]string
+	// fingerprint identifies the directory contents the index was built from
+	fingerprint string
+	mu          sync.RWMutex
+	stopWatch   context.CancelFunc
+}
+
+// NewFileStore creates a trigger store on a directory, creating it if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("trigger directory cannot be empty")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create trigger directory: %w", err)
+	}
+	return &FileStore{
+		dir:          dir,
+		pollInterval: DefaultFileStorePollInterval,
+		index:        newNamespaceIndex(),
+		files:        make(map[string]string),
+	}, nil
+}
+
+// SetPollInterval changes how often Watch checks the directory for changes
+func (s *FileStore) SetPollInterval(interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pollInterval = interval
+}
+
+// isTriggerFile reports whether a file name holds a trigger definition
+func isTriggerFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// scan returns the trigger files of the directory and a fingerprint of their names,
+// sizes and modification times
+func (s *FileStore) scan() ([]string, string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read trigger directory: %w", err)
+	}
+
+	var files []string
+	var fingerprint strings.Builder
+	for _, entry := range entries {
+		if entry.IsDir() || !isTriggerFile(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, entry.Name())
+		fmt.Fprintf(&fingerprint, "%s:%d:%d\n", entry.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return files, fingerprint.String(), nil
+}
+
+// reload rebuilds the index from the directory. A file that does not hold a valid
+// trigger fails the whole reload, so a half-edited file never drops its trigger.
+func (s *FileStore) reload(ctx context.Context) error {
+	files, fingerprint, err := s.scan()
+	if err != nil {
+		return err
+	}
+
+	triggers, byID, err := readTriggerFiles(ctx, s.dir, files)
+	if err != nil {
+		return err
+	}
+	index := newNamespaceIndex()
+	for _, trigger := range triggers {
+		index.addTrigger(trigger)
+	}
+
+	s.mu.Lock()
+	s.index = index
+	s.files = byID
+	s.fingerprint = fingerprint
+	s.mu.Unlock()
+	return nil
+}
+
+// readTriggerFiles parses trigger files of a directory and maps the IDs of their
+// triggers to their paths, rejecting IDs defined twice
+func readTriggerFiles(ctx context.Context, dir string, files []string) ([]*Trigger, map[string]string, error) {
+	triggers := make([]*Trigger, 0, len(files))
+	byID := make(map[string]string)
+	for _, name := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+
+		path := filepath.Join(dir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		trigger, err := ParseYAML(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid trigger in %s: %w", name, err)
+		}
+		if other, ok := byID[trigger.ID]; ok {
+			return nil, nil, fmt.Errorf("trigger %s is defined in both %s and %s", trigger.ID, filepath.Base(other), name)
+		}
+		byID[trigger.ID] = path
+		triggers = append(triggers, trigger)
+	}
+	return triggers, byID, nil
+}
+
+// LoadManifests reads the triggers of a directory of YAML manifests laid out as for
+// a FileStore. Unlike NewFileStore, it fails when the directory does not exist.
+func LoadManifests(ctx context.Context, dir string) ([]*Trigger, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	files, _, err := (&FileStore{dir: dir}).scan()
+	if err != nil {
+		return nil, err
+	}
+	triggers, _, err := readTriggerFiles(ctx, dir, files)
+	return triggers, err
+}
+
+// LoadAll loads all triggers from the directory
+func (s *FileStore) LoadAll(ctx context.Context) error {
+	return s.reload(ctx)
+}
+
+// Watch reloads the triggers whenever the directory changes until ctx is cancelled or
+// the store is closed. Invalid definitions are logged and the previous triggers kept.
+func (s *FileStore) Watch(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s.mu.Lock()
+	if s.stopWatch != nil {
+		s.stopWatch()
+	}
+	s.stopWatch = cancel
+	interval := s.pollInterval
+	s.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				_, fingerprint, err := s.scan()
+				if err != nil {
+					log.Printf("Error scanning trigger directory: %v", err)
+					continue
+				}
+				s.mu.RLock()
+				changed := fingerprint != s.fingerprint
+				s.mu.RUnlock()
+				if !changed {
+					continue
+				}
+				if err := s.reload(ctx); err != nil && ctx.Err() == nil {
+					log.Printf("Error reloading triggers, keeping the previous ones: %v", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// GetTriggers returns the triggers that apply to a namespace
+func (s *FileStore) GetTriggers(ctx context.Context, namespace string) ([]*Trigger, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.index.getTriggers(namespace), nil
+}
+
+// GetTriggersForEvent returns the triggers of a namespace that apply to an event type
+func (s *FileStore) GetTriggersForEvent(ctx context.Context, namespace, eventType string) ([]*Trigger, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.index.getTriggersForEvent(namespace, eventType), nil
+}
+
+// GetAllTriggers returns all triggers from all namespaces
+func (s *FileStore) GetAllTriggers(ctx context.Context) ([]*Trigger, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	triggers := make([]*Trigger, 0, len(s.index.triggers))
+	for _, trigger := range s.index.triggers {
+		triggers = append(triggers, trigger)
+	}
+	return triggers, nil
+}
+
+// ListTriggers returns a page of triggers ordered by ID along with the total trigger count
+func (s *FileStore) ListTriggers(ctx context.Context, opts ListOptions) ([]*Trigger, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	page, total := s.index.page(opts)
+	return page, total, nil
+}
+
+// ForEachTrigger calls fn for every trigger ordered by ID until fn returns false.
+// The store lock is not held while fn runs.
+func (s *FileStore) ForEachTrigger(ctx context.Context, fn func(*Trigger) bool) error {
+	s.mu.RLock()
+	triggers, _ := s.index.page(ListOptions{})
+	s.mu.RUnlock()
+
+	for _, trigger := range triggers {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !fn(trigger) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// path returns the file of a trigger: the file already defining it, or <namespace>.<name>.yaml
+func (s *FileStore) path(namespace, name string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if path, ok := s.files[name]; ok {
+		return path
+	}
+	return filepath.Join(s.dir, fmt.Sprintf("%s.%s.yaml", namespace, name))
+}
+
+// SaveTrigger writes a trigger to its file and reloads the directory
+func (s *FileStore) SaveTrigger(ctx context.Context, namespace, name string, trigger *Trigger) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := trigger.Validate(); err != nil {
+		return fmt.Errorf("invalid trigger: %w", err)
+	}
+
+	// Overlaps and never-matching criteria are legal but usually mistakes
+	others, _ := s.GetAllTriggers(ctx)
+	for _, finding := range CheckTrigger(trigger, others) {
+		log.Printf("Warning: trigger %s: %s", trigger.ID, finding)
+	}
+
+	data, err := trigger.ToYAML()
+	if err != nil {
+		return fmt.Errorf("failed to marshal trigger: %w", err)
+	}
+
+	// Write atomically so a concurrent reload never reads a partial file
+	path := s.path(namespace, name)
+	tmp, err := os.CreateTemp(s.dir, ".trigger-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to save trigger: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save trigger: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save trigger: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to save trigger: %w", err)
+	}
+
+	return s.reload(ctx)
+}
+
+// DeleteTrigger removes a trigger's file and reloads the directory
+func (s *FileStore) DeleteTrigger(ctx context.Context, namespace, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := os.Remove(s.path(namespace, name)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete trigger: %w", err)
+	}
+	return s.reload(ctx)
+}
+
+// Close stops the store's watch
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopWatch != nil {
+		s.stopWatch()
+		s.stopWatch = nil
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package trigger
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestFileStore tests loading, saving and deleting file-based triggers
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "deploy.yaml"), []byte("id: deploy\nnamespaces: [ops]\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a trigger"), 0644))
+
+	store, err := NewFileStore(dir)
+	require.NoError(t, err)
+	defer store.Close()
+
+	ctx := context.Background()
+	require.NoError(t, store.LoadAll(ctx))
+	triggers, err := store.GetTriggers(ctx, "ops")
+	require.NoError(t, err)
+	require.Len(t, triggers, 1)
+	assert.Equal(t, "deploy", triggers[0].ID)
+
+	// New triggers get a file of their own, existing ones are rewritten in place
+	require.NoError(t, store.SaveTrigger(ctx, "default", "alert", &Trigger{ID: "alert", Namespaces: []string{"ops"}}))
+	assert.FileExists(t, filepath.Join(dir, "default.alert.yaml"))
+	require.NoError(t, store.SaveTrigger(ctx, "default", "deploy", &Trigger{ID: "deploy", Namespaces: []string{"ops"}, EventType: "deployed"}))
+	_, err = os.Stat(filepath.Join(dir, "default.deploy.yaml"))
+	assert.True(t, os.IsNotExist(err))
+
+	page, total, err := store.ListTriggers(ctx, ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, total)
+	require.Len(t, page, 2)
+	assert.Equal(t, "alert", page[0].ID)
+	assert.Equal(t, "deployed", page[1].EventType)
+
+	require.NoError(t, store.DeleteTrigger(ctx, "default", "deploy"))
+	assert.NoFileExists(t, filepath.Join(dir, "deploy.yaml"))
+	all, err := store.GetAllTriggers(ctx)
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	assert.Equal(t, "alert", all[0].ID)
+
+	// Duplicate IDs are rejected
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "copy.yaml"), []byte("id: alert\n"), 0644))
+	assert.ErrorContains(t, store.LoadAll(ctx), "defined in both")
+}
+
+// TestFileStoreWatch tests that watching picks up changed files and keeps the
+// previous triggers while a file is invalid
+func TestFileStoreWatch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "deploy.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("id: deploy\n"), 0644))
+
+	store, err := NewFileStore(dir)
+	require.NoError(t, err)
+	defer store.Close()
+	store.SetPollInterval(10 * time.Millisecond)
+
+	ctx := context.Background()
+	require.NoError(t, store.LoadAll(ctx))
+	require.NoError(t, store.Watch(ctx))
+
+	eventTypes := func() []string {
+		all, _ := store.GetAllTriggers(ctx)
+		var types []string
+		for _, t := range all {
+			types = append(types, t.EventType)
+		}
+		return types
+	}
+
+	require.NoError(t, os.WriteFile(path, []byte("id: deploy\nevent_type: deployed\n"), 0644))
+	assert.Eventually(t, func() bool {
+		types := eventTypes()
+		return len(types) == 1 && types[0] == "deployed"
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, os.WriteFile(path, []byte("id: [\n"), 0644))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, []string{"deployed"}, eventTypes())
+
+	require.NoError(t, os.Remove(path))
+	assert.Eventually(t, func() bool { return len(eventTypes()) == 0 }, time.Second, 10*time.Millisecond)
+}
+
+// TestLoadManifests tests reading a manifest directory without creating it
+func TestLoadManifests(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "deploy.yaml"), []byte("id: deploy\nnamespaces: [ops]\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "alert.yml"), []byte("id: alert\n"), 0644))
+
+	triggers, err := LoadManifests(context.Background(), dir)
+	require.NoError(t, err)
+	require.Len(t, triggers, 2)
+	assert.Equal(t, "alert", triggers[0].ID)
+	assert.Equal(t, "deploy", triggers[1].ID)
+
+	missing := filepath.Join(dir, "missing")
+	_, err = LoadManifests(context.Background(), missing)
+	assert.Error(t, err)
+	assert.NoDirExists(t, missing)
+}
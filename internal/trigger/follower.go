@@ -0,0 +1,115 @@
+package trigger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+// ErrReadOnlyStore is returned when a write is attempted on a read-only store
+var ErrReadOnlyStore = errors.New("trigger store is read-only")
+
+// NewReadOnlyNATSStore creates a follower trigger store.
+// The store binds to an existing bucket without creating it and maintains its index
+// purely from the KV watch, so it only needs read access to the trigger bucket.
+// SaveTrigger and DeleteTrigger return ErrReadOnlyStore.
+func NewReadOnlyNATSStore(nc *nats.Conn, bucketName string) (*NATSStore, error) {
+	if bucketName == "" {
+		return nil, fmt.Errorf("bucket name cannot be empty")
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get JetStream context: %w", err)
+	}
+
+	kv, err := js.KeyValue(bucketName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get KV bucket: %w", err)
+	}
+
+	return &NATSStore{
+		nc:       nc,
+		kv:       kv,
+		index:    newNamespaceIndex(),
+		readOnly: true,
+	}, nil
+}
+
+// ReadOnly reports whether the store rejects writes
+func (s *NATSStore) ReadOnly() bool {
+	return s.readOnly
+}
+
+// follow builds a fresh index from the initial values of the KV watch and then
+// keeps applying updates in the background until ctx is cancelled or the store is closed.
+func (s *NATSStore) follow(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	watcher, err := s.kv.WatchAll(nats.Context(ctx))
+	if err != nil {
+		cancel()
+		return fmt.Errorf("failed to watch trigger bucket: %w", err)
+	}
+
+	// The watcher delivers every current value followed by a nil marker
+	index := newNamespaceIndex()
+	s.mu.RLock()
+	index.filter = s.filter
+	s.mu.RUnlock()
+	for caughtUp := false; !caughtUp; {
+		select {
+		case <-ctx.Done():
+			watcher.Stop()
+			cancel()
+			return ctx.Err()
+		case update, ok := <-watcher.Updates():
+			if !ok {
+				err := ctx.Err()
+				cancel()
+				if err != nil {
+					return err
+				}
+				return fmt.Errorf("trigger bucket watch closed before catching up")
+			}
+			if update == nil {
+				caughtUp = true
+				continue
+			}
+			applyUpdate(index, update)
+		}
+	}
+
+	s.mu.Lock()
+	if s.stopWatch != nil {
+		s.stopWatch()
+	}
+	s.index = index
+	s.following = true
+	s.stopWatch = cancel
+	s.mu.Unlock()
+
+	go func() {
+		defer watcher.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case update, ok := <-watcher.Updates():
+				if !ok {
+					return
+				}
+				if update == nil {
+					continue
+				}
+
+				s.mu.Lock()
+				applyUpdate(s.index, update)
+				s.mu.Unlock()
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package trigger
+
+import (
+	"fmt"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+)
+
+// DefaultLifecycleSubject is the administrative subject trigger lifecycle events are published to
+const DefaultLifecycleSubject = "admin.triggers"
+
+// Trigger lifecycle event types
+const (
+	EventTypeTriggerCreated = "trigger.created"
+	EventTypeTriggerUpdated = "trigger.updated"
+	EventTypeTriggerDeleted = "trigger.deleted"
+)
+
+// LifecycleEventData is the payload of a trigger lifecycle CloudEvent
+type LifecycleEventData struct {
+	Namespace string   `json:"namespace"`
+	Name      string   `json:"name"`
+	Trigger   *Trigger `json:"trigger,omitempty"`
+}
+
+// newLifecycleEvent builds a CloudEvent describing a trigger configuration change
+func newLifecycleEvent(bucket, eventType, namespace, name string, trigger *Trigger) (*cloudevents.Event, error) {
+	ce := cloudevents.NewEvent()
+	ce.SetID(uuid.NewString())
+	ce.SetSource(fmt.Sprintf("mycelium/triggers/%s", bucket))
+	ce.SetType(eventType)
+	ce.SetSubject(fmt.Sprintf("%s.%s", namespace, name))
+	ce.SetTime(time.Now())
+	if err := ce.SetData(cloudevents.ApplicationJSON, LifecycleEventData{
+		Namespace: namespace,
+		Name:      name,
+		Trigger:   trigger,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to set lifecycle event data: %w", err)
+	}
+	return &ce, nil
+}
+
+// publishLifecycleEvent publishes a trigger lifecycle event to the store's lifecycle subject.
+// Publishing is best effort: the KV bucket remains the source of truth.
+func (s *NATSStore) publishLifecycleEvent(eventType, namespace, name string, trigger *Trigger) error {
+	s.mu.RLock()
+	subject := s.lifecycleSubject
+	s.mu.RUnlock()
+
+	if subject == "" {
+		return nil
+	}
+
+	ce, err := newLifecycleEvent(s.kv.Bucket(), eventType, namespace, name, trigger)
+	if err != nil {
+		return err
+	}
+
+	data, err := ce.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("failed to marshal lifecycle event: %w", err)
+	}
+
+	if err := s.nc.Publish(subject, data); err != nil {
+		return fmt.Errorf("failed to publish lifecycle event: %w", err)
+	}
+	return nil
+}
+
+// SetLifecycleSubject changes the subject trigger lifecycle events are published to.
+// An empty subject disables lifecycle events.
+func (s *NATSStore) SetLifecycleSubject(subject string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lifecycleSubject = subject
+}
@@ -1,10 +1,13 @@
 package trigger
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"regexp"
 	"strings"
+	"sync"
+
+	mevent "github.com/julianshen/mycelium/internal/event"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"github.com/expr-lang/expr"
@@ -12,31 +15,130 @@ import (
 
 var (
 	ErrTriggerNotFound = errors.New("no matching trigger found")
+	// ErrUnresolvedClaimCheck is returned when criteria are evaluated against an event
+	// whose data was offloaded and not resolved, see event.ClaimCheck
+	ErrUnresolvedClaimCheck = errors.New("event data is claim-checked and was not resolved")
 )
 
-// isNamespaceMatch checks if the event's namespace matches any of the trigger's namespace patterns
+// NamespaceSeparator separates the levels of hierarchical namespaces, e.g. "payments/checkout/prod"
+const NamespaceSeparator = "/"
+
+// namespacePattern is a precompiled namespace glob. Patterns are matched level by level:
+// within a level "*" matches any sequence of characters except the separator, so
+// "payments/*/prod" matches "payments/checkout/prod" but not "payments/checkout/eu/prod".
+type namespacePattern struct {
+	matchAll bool
+	levels   [][]string
+}
+
+// patternCache holds compiled namespace patterns keyed by their source
+var patternCache sync.Map
+
+// compileNamespacePattern returns the compiled form of a namespace pattern, using the cache when possible
+func compileNamespacePattern(pattern string) *namespacePattern {
+	if cached, ok := patternCache.Load(pattern); ok {
+		return cached.(*namespacePattern)
+	}
+
+	compiled := &namespacePattern{}
+	if strings.Trim(pattern, "*") == "" && pattern != "" {
+		compiled.matchAll = true
+	}
+	for _, level := range strings.Split(pattern, NamespaceSeparator) {
+		compiled.levels = append(compiled.levels, strings.Split(level, "*"))
+	}
+
+	actual, _ := patternCache.LoadOrStore(pattern, compiled)
+	return actual.(*namespacePattern)
+}
+
+// match reports whether the namespace matches the pattern
+func (p *namespacePattern) match(namespace string) bool {
+	if p.matchAll {
+		return true
+	}
+
+	// Flat patterns and namespaces skip splitting
+	if len(p.levels) == 1 {
+		return !strings.Contains(namespace, NamespaceSeparator) && matchGlob(p.levels[0], namespace)
+	}
+
+	levels := strings.Split(namespace, NamespaceSeparator)
+	if len(levels) != len(p.levels) {
+		return false
+	}
+	for i, parts := range p.levels {
+		if !matchGlob(parts, levels[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchGlob reports whether s matches a glob split at its "*" wildcards
+func matchGlob(parts []string, s string) bool {
+	// No wildcard: exact match
+	if len(parts) == 1 {
+		return s == parts[0]
+	}
+
+	first, last := parts[0], parts[len(parts)-1]
+	if len(s) < len(first)+len(last) ||
+		!strings.HasPrefix(s, first) ||
+		!strings.HasSuffix(s, last) {
+		return false
+	}
+
+	// Middle parts must appear in order between the prefix and suffix
+	rest := s[len(first) : len(s)-len(last)]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(part):]
+	}
+	return true
+}
+
+// namespaceHierarchy returns a namespace followed by its ancestors, e.g.
+// "payments/checkout/prod", "payments/checkout" and "payments"
+func namespaceHierarchy(namespace string) []string {
+	hierarchy := []string{namespace}
+	for {
+		i := strings.LastIndex(namespace, NamespaceSeparator)
+		if i < 0 {
+			return hierarchy
+		}
+		namespace = namespace[:i]
+		hierarchy = append(hierarchy, namespace)
+	}
+}
+
+// isNamespaceMatch checks if the event's namespace or one of its ancestors matches any of
+// the trigger's namespace patterns, so triggers defined at a parent level are inherited
 func isNamespaceMatch(trigger *Trigger, eventNamespace string) bool {
 	// If Namespaces is empty, match all namespaces (default behavior)
 	if len(trigger.Namespaces) == 0 {
 		return true
 	}
 
-	// Check each namespace pattern
-	for _, pattern := range trigger.Namespaces {
-		// Convert pattern to regex-like string
-		pattern = strings.ReplaceAll(pattern, "*", ".*")
-		// Add start and end anchors
-		pattern = "^" + pattern + "$"
-
-		// Check if the namespace matches the pattern
-		matched, err := regexp.MatchString(pattern, eventNamespace)
-		if err == nil && matched {
-			return true
+	// Check each namespace pattern against every level
+	for _, namespace := range namespaceHierarchy(eventNamespace) {
+		for _, pattern := range trigger.Namespaces {
+			if compileNamespacePattern(pattern).match(namespace) {
+				return true
+			}
 		}
 	}
 	return false
 }
 
+// EventNamespace returns the namespace of an event type, its first dot-separated segment
+func EventNamespace(eventType string) string {
+	return extractNamespaceFromType(eventType)
+}
+
 // extractNamespaceFromType extracts namespace from event type in format "$namespace.object.{command|event}"
 func extractNamespaceFromType(eventType string) string {
 	parts := strings.Split(eventType, ".")
@@ -46,6 +148,28 @@ func extractNamespaceFromType(eventType string) string {
 	return ""
 }
 
+// eventTypeKeys returns the forms a trigger's event type may take for an event type:
+// the full type and the type without its namespace, e.g. "prod.user.updated" and "user.updated"
+func eventTypeKeys(eventType string) []string {
+	if _, local, ok := strings.Cut(eventType, "."); ok && local != "" {
+		return []string{eventType, local}
+	}
+	return []string{eventType}
+}
+
+// eventTypeMatches reports whether a trigger's event type applies to an event type
+func eventTypeMatches(triggerType, eventType string) bool {
+	if triggerType == "" {
+		return true
+	}
+	for _, key := range eventTypeKeys(eventType) {
+		if triggerType == key {
+			return true
+		}
+	}
+	return false
+}
+
 // MatchTrigger returns true if the event satisfies the trigger's criteria.
 // It supports:
 // Expression-based matching using the expr library (preferred)
@@ -54,21 +178,54 @@ func extractNamespaceFromType(eventType string) string {
 // The expression must evaluate to a boolean value.
 // Example: event.event_type == "user.created" && event.data.after.role == "admin"
 //
+// A trigger with an except expression does not match events for which it is true,
+// even when they satisfy the criteria. It is only evaluated for matching events.
+//
+// Triggers in the cesql dialect are evaluated with CloudEvents SQL instead, e.g.
+// type = 'user.created' AND source LIKE '/auth/%'.
+//
 // See the event system specification for more details on the expression language.
 func MatchTrigger(trigger *Trigger, event *cloudevents.Event) (bool, error) {
 	if trigger == nil || !trigger.Enabled {
 		return false, nil
 	}
+	if trigger.IgnoreReplays && mevent.IsReplay(event) {
+		return false, nil
+	}
 
 	// If criteria is empty, match based on event type and namespace
 	if trigger.Criteria == "" {
-		return (trigger.EventType == "" || trigger.EventType == event.Type()) &&
+		matches := eventTypeMatches(trigger.EventType, event.Type()) &&
 			isNamespaceMatch(trigger, extractNamespaceFromType(event.Type())) &&
-			(trigger.ObjectType == "" || trigger.ObjectType == event.Type()), nil
+			(trigger.ObjectType == "" || trigger.ObjectType == event.Type())
+		if !matches || trigger.Except == "" {
+			return matches, nil
+		}
+	}
+
+	if trigger.Dialect == DialectCESQL {
+		return matchCESQL(trigger, event)
 	}
 
-	// If the trigger has a criteria expression, evaluate it
-	return evaluateTriggerCriteria(event, trigger.Criteria)
+	// Criteria and except see the same environment, built once
+	env, err := newCriteriaEnv(event, trigger.Vars)
+	if err != nil {
+		return false, err
+	}
+	if trigger.Criteria != "" {
+		matches, err := runCriteria(env, trigger.Criteria)
+		if err != nil || !matches {
+			return false, err
+		}
+	}
+	if trigger.Except == "" {
+		return true, nil
+	}
+	excepted, err := runCriteria(env, trigger.Except)
+	if err != nil {
+		return false, fmt.Errorf("except: %w", err)
+	}
+	return !excepted, nil
 }
 
 // has(obj, "a.b.c") returns true if all keys exist down the path
@@ -107,13 +264,23 @@ func has(args ...any) (any, error) {
 
 // Extract extensions
 func extractExtensions(event *cloudevents.Event) (string, string, string, string) {
-	actorType, _ := event.Extensions()["actor_type"].(string)
-	actorID, _ := event.Extensions()["actor_id"].(string)
-	contextRequestID, _ := event.Extensions()["context_request_id"].(string)
-	contextTraceID, _ := event.Extensions()["context_trace_id"].(string)
+	actorType := extensionString(event, mevent.ExtActorType, mevent.LegacyExtActorType)
+	actorID := extensionString(event, mevent.ExtActorID, mevent.LegacyExtActorID)
+	contextRequestID := extensionString(event, mevent.ExtRequestID, mevent.LegacyExtRequestID)
+	contextTraceID := extensionString(event, mevent.ExtTraceID, mevent.LegacyExtTraceID)
 	return actorType, actorID, contextRequestID, contextTraceID
 }
 
+// extensionString returns the first string extension found under the given names
+func extensionString(event *cloudevents.Event, names ...string) string {
+	for _, name := range names {
+		if value, ok := event.Extensions()[name].(string); ok {
+			return value
+		}
+	}
+	return ""
+}
+
 // Extract data from Data
 func extractData(event *cloudevents.Event) (map[string]interface{}, error) {
 	var data map[string]interface{}
@@ -123,8 +290,8 @@ func extractData(event *cloudevents.Event) (map[string]interface{}, error) {
 	return data, nil
 }
 
-// EvaluateTriggerCriteria safely evaluates a criteria string against the given event
-func evaluateTriggerCriteria(event *cloudevents.Event, criteria string) (bool, error) {
+// EvaluateTriggerCriteria safely evaluates a criteria string against the given event and trigger vars
+func evaluateTriggerCriteria(event *cloudevents.Event, criteria string, vars map[string]interface{}) (bool, error) {
 	// If criteria is empty, match based on event type and namespace
 	if criteria == "" {
 		// For empty criteria, we'll just return true since we don't have trigger information here
@@ -132,57 +299,26 @@ func evaluateTriggerCriteria(event *cloudevents.Event, criteria string) (bool, e
 		return true, nil
 	}
 
-	// Extract extensions
-	actorType, actorID, contextRequestID, contextTraceID := extractExtensions(event)
-
-	// Extract data from Data
-	data, err := extractData(event)
+	env, err := newCriteriaEnv(event, vars)
 	if err != nil {
-		return false, fmt.Errorf("failed to extract data: %w", err)
-	}
-
-	// Only include 'before' and 'after' if present
-	dataMap := map[string]interface{}{}
-	if before, ok := data["before"]; ok {
-		dataMap["before"] = before
-	}
-	if after, ok := data["after"]; ok {
-		dataMap["after"] = after
-	}
-
-	// Create a map representation of the event that matches JSON field names
-	eventMap := map[string]interface{}{
-		"event_id":      event.ID(),
-		"event_type":    event.Type(),
-		"event_version": event.SpecVersion(),
-		"namespace":     extractNamespaceFromType(event.Type()),
-		"object_type":   "", // Not present in CloudEvent, unless you want to add as extension
-		"object_id":     event.ID(),
-		"timestamp":     event.Time(),
-		"actor": map[string]interface{}{
-			"type": actorType,
-			"id":   actorID,
-		},
-		"context": map[string]interface{}{
-			"request_id": contextRequestID,
-			"trace_id":   contextTraceID,
-		},
-		"data": dataMap,
-		// NATS metadata can be extracted from the NATS extension if needed
-	}
-
-	// Create environment with event as the root variable
-	env := map[string]interface{}{
-		"event": eventMap,
+		return false, err
 	}
+	return runCriteria(env, criteria)
+}
 
-	// Compile the expression with custom functions
-	options := []expr.Option{
-		expr.Env(env),
-		expr.Function("has", has),
+// newCriteriaEnv builds the environment criteria are evaluated in, with event and
+// vars as the root variables
+func newCriteriaEnv(event *cloudevents.Event, vars map[string]interface{}) (map[string]interface{}, error) {
+	// Criteria must not silently see empty data in place of an offloaded payload
+	if mevent.IsClaimChecked(event) {
+		return nil, fmt.Errorf("event %s: %w", event.ID(), ErrUnresolvedClaimCheck)
 	}
+	return newExprEnv(event, vars)
+}
 
-	program, err := expr.Compile(criteria, options...)
+// runCriteria compiles and runs a boolean expression in a criteria environment
+func runCriteria(env map[string]interface{}, criteria string) (bool, error) {
+	program, err := expr.Compile(criteria, exprOptions(env)...)
 	if err != nil {
 		return false, fmt.Errorf("failed to compile criteria: %w", err)
 	}
@@ -204,12 +340,16 @@ func evaluateTriggerCriteria(event *cloudevents.Event, criteria string) (bool, e
 
 // FindMatchingTriggers finds all triggers that match the given event.
 // Returns an empty slice if no matching triggers are found.
-func FindMatchingTriggers(store TriggerStore, event *cloudevents.Event) ([]*Trigger, error) {
+func FindMatchingTriggers(ctx context.Context, store TriggerStore, event *cloudevents.Event) ([]*Trigger, error) {
 	// Get namespace from event type instead of source
 	namespace := extractNamespaceFromType(event.Type())
 
-	// Get all potential triggers for the namespace (including wildcard matches)
-	triggers := store.GetTriggers(namespace)
+	// Get all potential triggers for the namespace (including wildcard matches),
+	// skipping triggers bound to other event types before evaluating any criteria
+	triggers, err := store.GetTriggersForEvent(ctx, namespace, event.Type())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get triggers: %w", err)
+	}
 	if len(triggers) == 0 {
 		return nil, nil
 	}
@@ -0,0 +1,426 @@
+package trigger
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	mevent "github.com/julianshen/mycelium/internal/event"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestNamespacePatternMatch tests wildcard namespace pattern semantics
+func TestNamespacePatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern   string
+		namespace string
+		want      bool
+	}{
+		{"*", "anything", true},
+		{"**", "", true},
+		{"prod", "prod", true},
+		{"prod", "production", false},
+		{"prod.*", "prod.api", true},
+		{"prod.*", "prodXapi", false},
+		{"*.service", "api.service", true},
+		{"*.service", "service", false},
+		{"prod.*.service", "prod.api.service", true},
+		{"prod.*.service", "prod.service", false},
+		{"a*b*c", "aXbYc", true},
+		{"a*b*c", "acb", false},
+		{"ab*ba", "aba", false},
+		{"payments/*/prod", "payments/checkout/prod", true},
+		{"payments/*/prod", "payments/checkout/staging", false},
+		{"payments/*/prod", "payments/checkout/eu/prod", false},
+		{"payments/*", "payments", false},
+		{"pay*/checkout", "payments/checkout", true},
+		{"prod*", "prod/api", false},
+		{"*", "payments/checkout/prod", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%s", tt.pattern, tt.namespace), func(t *testing.T) {
+			assert.Equal(t, tt.want, compileNamespacePattern(tt.pattern).match(tt.namespace))
+		})
+	}
+}
+
+// TestFindMatchingTriggers tests namespace index lookup combined with criteria evaluation
+func TestFindMatchingTriggers(t *testing.T) {
+	store := newTestStore(
+		&Trigger{ID: "all", Enabled: true},
+		&Trigger{ID: "prod-only", Namespaces: []string{"prod"}, Enabled: true},
+		&Trigger{ID: "staging-wildcard", Namespaces: []string{"staging*"}, Enabled: true},
+		&Trigger{ID: "disabled", Enabled: false},
+		&Trigger{ID: "criteria", Enabled: true, Criteria: `event.data.after.usage > 90`},
+	)
+
+	event := cloudevents.NewEvent()
+	event.SetID("event-1")
+	event.SetSource("test")
+	event.SetType("prod.resource.updated")
+	require.NoError(t, event.SetData(cloudevents.ApplicationJSON, map[string]interface{}{
+		"after": map[string]interface{}{"usage": 95},
+	}))
+
+	matched, err := FindMatchingTriggers(context.Background(), store, &event)
+	require.NoError(t, err)
+
+	var ids []string
+	for _, m := range matched {
+		ids = append(ids, m.ID)
+	}
+	assert.ElementsMatch(t, []string{"all", "prod-only", "criteria"}, ids)
+}
+
+// TestFindMatchingTriggersInheritsParentNamespaces tests that triggers defined at parent levels apply to child namespaces
+func TestFindMatchingTriggersInheritsParentNamespaces(t *testing.T) {
+	store := newTestStore(
+		&Trigger{ID: "org", Namespaces: []string{"payments"}, Enabled: true},
+		&Trigger{ID: "project", Namespaces: []string{"payments/checkout"}, Enabled: true},
+		&Trigger{ID: "any-prod", Namespaces: []string{"payments/*/prod"}, Enabled: true},
+		&Trigger{ID: "sibling", Namespaces: []string{"payments/refunds"}, Enabled: true},
+		&Trigger{ID: "child", Namespaces: []string{"payments/checkout/prod/eu"}, Enabled: true},
+	)
+
+	tests := []struct {
+		namespace string
+		want      []string
+	}{
+		{"payments/checkout/prod", []string{"org", "project", "any-prod"}},
+		{"payments/checkout/staging", []string{"org", "project"}},
+		{"payments", []string{"org"}},
+		{"billing", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.namespace, func(t *testing.T) {
+			event := cloudevents.NewEvent()
+			event.SetID("event-1")
+			event.SetSource("test")
+			event.SetType(tt.namespace + ".order.created")
+
+			matched, err := FindMatchingTriggers(context.Background(), store, &event)
+			require.NoError(t, err)
+
+			var ids []string
+			for _, m := range matched {
+				ids = append(ids, m.ID)
+			}
+			assert.ElementsMatch(t, tt.want, ids)
+		})
+	}
+}
+
+// newWildcardHeavyStore builds a store dominated by wildcard namespace patterns
+func newWildcardHeavyStore(n int) *NATSStore {
+	var triggers []*Trigger
+	for i := 0; i < n; i++ {
+		triggers = append(triggers,
+			&Trigger{ID: fmt.Sprintf("prefix-%d", i), Namespaces: []string{fmt.Sprintf("team%d.*", i)}, Enabled: true},
+			&Trigger{ID: fmt.Sprintf("suffix-%d", i), Namespaces: []string{fmt.Sprintf("*.svc%d", i)}, Enabled: true},
+			&Trigger{ID: fmt.Sprintf("global-%d", i), Namespaces: []string{"*"}, Enabled: true},
+		)
+	}
+	return newTestStore(triggers...)
+}
+
+// BenchmarkGetTriggersWildcardHeavy benchmarks namespace index lookups with hundreds of wildcard triggers
+func BenchmarkGetTriggersWildcardHeavy(b *testing.B) {
+	store := newWildcardHeavyStore(300)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		store.GetTriggers(context.Background(), "team42.api")
+	}
+}
+
+// BenchmarkFindMatchingTriggers benchmarks full event matching against a wildcard-heavy store
+func BenchmarkFindMatchingTriggers(b *testing.B) {
+	store := newWildcardHeavyStore(300)
+
+	event := cloudevents.NewEvent()
+	event.SetID("bench")
+	event.SetSource("bench")
+	event.SetType("team42.resource.updated")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := FindMatchingTriggers(context.Background(), store, &event); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// TestCriteriaReadsActorExtensions tests that spec-compliant actor extensions reach criteria
+func TestCriteriaReadsActorExtensions(t *testing.T) {
+	event := cloudevents.NewEvent()
+	event.SetID("event-1")
+	event.SetSource("test")
+	event.SetType("prod.user.updated")
+	event.SetExtension("actortype", "admin")
+	event.SetExtension("traceid", "trace-1")
+
+	matched, err := MatchTrigger(&Trigger{
+		ID:       "admin-changes",
+		Enabled:  true,
+		Criteria: `event.actor.type == "admin" && event.context.trace_id == "trace-1"`,
+	}, &event)
+	require.NoError(t, err)
+	assert.True(t, matched)
+}
+
+// TestCriteriaVars tests that triggers sharing a criteria template match with their own vars
+func TestCriteriaVars(t *testing.T) {
+	template := `event.data.after.usage > vars.threshold && event.data.after.region in vars.regions`
+	warning, err := ParseYAML([]byte(`id: usage-warning
+enabled: true
+criteria: "` + template + `"
+vars:
+  threshold: 80
+  regions: [eu, us]
+`))
+	require.NoError(t, err)
+	critical := &Trigger{
+		ID:       "usage-critical",
+		Enabled:  true,
+		Criteria: template,
+		Vars:     map[string]interface{}{"threshold": 95.5, "regions": []interface{}{"eu"}},
+	}
+
+	event := cloudevents.NewEvent()
+	event.SetID("event-1")
+	event.SetSource("test")
+	event.SetType("prod.resource.updated")
+	require.NoError(t, event.SetData(cloudevents.ApplicationJSON, map[string]interface{}{
+		"after": map[string]interface{}{"usage": 90, "region": "eu"},
+	}))
+
+	matched, err := MatchTrigger(warning, &event)
+	require.NoError(t, err)
+	assert.True(t, matched)
+
+	matched, err = MatchTrigger(critical, &event)
+	require.NoError(t, err)
+	assert.False(t, matched)
+}
+
+// TestExceptSuppressesMatches tests that except expressions suppress matching triggers
+func TestExceptSuppressesMatches(t *testing.T) {
+	newEvent := func(maintenance string) *cloudevents.Event {
+		event := cloudevents.NewEvent()
+		event.SetID("event-" + maintenance)
+		event.SetSource("test")
+		event.SetType("prod.host.down")
+		require.NoError(t, event.SetData(cloudevents.ApplicationJSON, map[string]interface{}{
+			"after": map[string]interface{}{"labels": map[string]interface{}{"maintenance": maintenance}},
+		}))
+		return &event
+	}
+
+	alert, err := ParseYAML([]byte(`id: host-down
+enabled: true
+event_type: host.down
+except: event.data.after.labels.maintenance == vars.flag
+vars:
+  flag: "true"
+`))
+	require.NoError(t, err)
+	matched, err := MatchTrigger(alert, newEvent("false"))
+	require.NoError(t, err)
+	assert.True(t, matched)
+	matched, err = MatchTrigger(alert, newEvent("true"))
+	require.NoError(t, err)
+	assert.False(t, matched)
+
+	// Except is only evaluated for events satisfying the criteria
+	alert.Criteria = `event.data.after.labels.maintenance == "false"`
+	alert.Except = "event.data.missing.field > 1"
+	matched, err = MatchTrigger(alert, newEvent("true"))
+	require.NoError(t, err)
+	assert.False(t, matched)
+	_, err = MatchTrigger(alert, newEvent("false"))
+	assert.ErrorContains(t, err, "except")
+
+	_, err = ParseYAML([]byte("id: host-down\nexcept: event.data ==\n"))
+	assert.ErrorContains(t, err, "except: invalid expression")
+}
+
+// TestCESQLCriteria tests triggers whose criteria are CloudEvents SQL expressions
+func TestCESQLCriteria(t *testing.T) {
+	newEvent := func(source, region string) *cloudevents.Event {
+		event := cloudevents.NewEvent()
+		event.SetID("event-" + source)
+		event.SetSource(source)
+		event.SetType("prod.host.down")
+		if region != "" {
+			event.SetExtension("region", region)
+		}
+		return &event
+	}
+
+	alert, err := ParseYAML([]byte(`id: host-down
+enabled: true
+dialect: cesql
+criteria: type LIKE '%.host.down' AND source LIKE '/datacenter/%'
+except: region = 'lab'
+`))
+	require.NoError(t, err)
+
+	matched, err := MatchTrigger(alert, newEvent("/datacenter/fra", "eu"))
+	require.NoError(t, err)
+	assert.True(t, matched)
+	matched, err = MatchTrigger(alert, newEvent("/cloud/fra", "eu"))
+	require.NoError(t, err)
+	assert.False(t, matched)
+	matched, err = MatchTrigger(alert, newEvent("/datacenter/fra", "lab"))
+	require.NoError(t, err)
+	assert.False(t, matched, "except suppresses the match")
+
+	// Referencing a missing extension fails the expression, which is false
+	matched, err = MatchTrigger(alert, newEvent("/datacenter/fra", ""))
+	require.NoError(t, err)
+	assert.True(t, matched)
+	alert.Criteria = "region = 'eu'"
+	matched, err = MatchTrigger(alert, newEvent("/datacenter/fra", ""))
+	require.NoError(t, err)
+	assert.False(t, matched)
+	alert.Criteria = "EXISTS region AND region = 'eu'"
+	matched, err = MatchTrigger(alert, newEvent("/datacenter/fra", "eu"))
+	require.NoError(t, err)
+	assert.True(t, matched)
+
+	_, err = ParseYAML([]byte("id: host-down\ndialect: cesql\ncriteria: type = \n"))
+	assert.ErrorContains(t, err, "criteria: invalid CESQL expression")
+	_, err = ParseYAML([]byte("id: host-down\ndialect: sql\n"))
+	assert.ErrorContains(t, err, "dialect: must be expr or cesql")
+}
+
+// TestIgnoreReplays tests that triggers can opt out of replayed events
+func TestIgnoreReplays(t *testing.T) {
+	event := cloudevents.NewEvent()
+	event.SetID("event-1")
+	event.SetSource("test")
+	event.SetType("prod.user.updated")
+	event.SetExtension(mevent.ExtReplay, true)
+
+	// Replayed events arrive as JSON, where the extension is read back as a string
+	data, err := event.MarshalJSON()
+	require.NoError(t, err)
+	replayed := cloudevents.NewEvent()
+	require.NoError(t, replayed.UnmarshalJSON(data))
+
+	live := &Trigger{ID: "live-only", Enabled: true, IgnoreReplays: true}
+	matched, err := MatchTrigger(live, &replayed)
+	require.NoError(t, err)
+	assert.False(t, matched)
+
+	all := &Trigger{ID: "all", Enabled: true, Criteria: "event.replay"}
+	matched, err = MatchTrigger(all, &replayed)
+	require.NoError(t, err)
+	assert.True(t, matched)
+
+	replayed.SetExtension(mevent.ExtReplay, nil)
+	matched, err = MatchTrigger(live, &replayed)
+	require.NoError(t, err)
+	assert.True(t, matched)
+}
+
+// TestCriteriaRejectUnresolvedClaimCheck tests that criteria are not evaluated against
+// events whose data was offloaded and not resolved
+func TestCriteriaRejectUnresolvedClaimCheck(t *testing.T) {
+	event := cloudevents.NewEvent()
+	event.SetID("event-1")
+	event.SetSource("test")
+	event.SetType("prod.blob.uploaded")
+	event.SetExtension(mevent.ExtClaimCheck, "event-payloads/abc")
+
+	matched, err := MatchTrigger(&Trigger{ID: "large", Enabled: true, Criteria: "event.data.size > 10"}, &event)
+	assert.ErrorIs(t, err, ErrUnresolvedClaimCheck)
+	assert.False(t, matched)
+
+	// Triggers without criteria do not read the data
+	matched, err = MatchTrigger(&Trigger{ID: "all", Enabled: true}, &event)
+	require.NoError(t, err)
+	assert.True(t, matched)
+}
+
+// TestExprEnvironment tests that the generated environment matches the documented fields
+func TestExprEnvironment(t *testing.T) {
+	env := ExprEnvironment()
+
+	paths := map[string]string{}
+	for _, f := range env.Fields {
+		paths[f.Path] = f.Type
+		assert.NotEmpty(t, f.Description, "field %s is undocumented", f.Path)
+	}
+	for path := range envFieldDocs {
+		assert.Contains(t, paths, path, "documented field %s is not in the environment", path)
+	}
+	assert.Equal(t, "string", paths["event.actor.type"])
+	assert.Equal(t, "time", paths["event.timestamp"])
+
+	require.Len(t, env.Functions, 1)
+	assert.Equal(t, "has", env.Functions[0].Name)
+}
+
+// TestFindMatchingTriggersFiltersEventType tests that criteria only run for triggers of the event's type
+func TestFindMatchingTriggersFiltersEventType(t *testing.T) {
+	store := newTestStore(
+		&Trigger{ID: "untyped", Enabled: true, Criteria: "true"},
+		&Trigger{ID: "local-type", EventType: "user.updated", Enabled: true, Criteria: "true"},
+		&Trigger{ID: "full-type", EventType: "prod.user.updated", Enabled: true, Criteria: "true"},
+		// Would fail to compile if it were evaluated
+		&Trigger{ID: "other-type", EventType: "user.deleted", Enabled: true, Criteria: "event.missing +"},
+	)
+
+	event := cloudevents.NewEvent()
+	event.SetID("event-1")
+	event.SetSource("test")
+	event.SetType("prod.user.updated")
+
+	matched, err := FindMatchingTriggers(context.Background(), store, &event)
+	require.NoError(t, err)
+
+	var ids []string
+	for _, m := range matched {
+		ids = append(ids, m.ID)
+	}
+	assert.ElementsMatch(t, []string{"untyped", "local-type", "full-type"}, ids)
+
+	// Removing a trigger drops it from the event type index
+	store.index.removeTrigger("local-type")
+	triggers, err := store.GetTriggersForEvent(context.Background(), "prod", "prod.user.updated")
+	require.NoError(t, err)
+	assert.Len(t, triggers, 2)
+	assert.NotContains(t, store.index.eventTypes, "user.updated")
+}
+
+// BenchmarkFindMatchingTriggersTypeSpecific benchmarks matching when most triggers target other event types
+func BenchmarkFindMatchingTriggersTypeSpecific(b *testing.B) {
+	var triggers []*Trigger
+	for i := 0; i < 1000; i++ {
+		triggers = append(triggers, &Trigger{
+			ID:        fmt.Sprintf("typed-%d", i),
+			EventType: fmt.Sprintf("object%d.updated", i),
+			Enabled:   true,
+			Criteria:  `event.actor.type == "admin"`,
+		})
+	}
+	store := newTestStore(triggers...)
+
+	event := cloudevents.NewEvent()
+	event.SetID("bench")
+	event.SetSource("bench")
+	event.SetType("prod.object42.updated")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := FindMatchingTriggers(context.Background(), store, &event); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package trigger
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// MemoryStore is a TriggerStore kept in memory, for tests and embedded pipelines.
+// Saved triggers are indexed immediately; there is nothing to load or watch.
+type MemoryStore struct {
+	index *namespaceIndex
+	// keys maps <namespace>.<name> to the ID of the trigger saved under it
+	keys map[string]string
+	mu   sync.RWMutex
+}
+
+// NewMemoryStore creates an empty in-memory trigger store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		index: newNamespaceIndex(),
+		keys:  make(map[string]string),
+	}
+}
+
+// LoadAll does nothing: the store always holds all of its triggers
+func (s *MemoryStore) LoadAll(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// Watch does nothing: saved triggers apply immediately
+func (s *MemoryStore) Watch(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// GetTriggers returns the triggers that apply to a namespace
+func (s *MemoryStore) GetTriggers(ctx context.Context, namespace string) ([]*Trigger, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.index.getTriggers(namespace), nil
+}
+
+// GetTriggersForEvent returns the triggers of a namespace that apply to an event type
+func (s *MemoryStore) GetTriggersForEvent(ctx context.Context, namespace, eventType string) ([]*Trigger, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.index.getTriggersForEvent(namespace, eventType), nil
+}
+
+// GetAllTriggers returns all triggers from all namespaces
+func (s *MemoryStore) GetAllTriggers(ctx context.Context) ([]*Trigger, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	triggers := make([]*Trigger, 0, len(s.index.triggers))
+	for _, trigger := range s.index.triggers {
+		triggers = append(triggers, trigger)
+	}
+	return triggers, nil
+}
+
+// ListTriggers returns a page of triggers ordered by ID along with the total trigger count
+func (s *MemoryStore) ListTriggers(ctx context.Context, opts ListOptions) ([]*Trigger, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	page, total := s.index.page(opts)
+	return page, total, nil
+}
+
+// ForEachTrigger calls fn for every trigger ordered by ID until fn returns false.
+// The store lock is not held while fn runs.
+func (s *MemoryStore) ForEachTrigger(ctx context.Context, fn func(*Trigger) bool) error {
+	s.mu.RLock()
+	triggers, _ := s.index.page(ListOptions{})
+	s.mu.RUnlock()
+
+	for _, trigger := range triggers {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !fn(trigger) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// SaveTrigger validates a trigger and indexes it, replacing the trigger previously
+// saved under the namespace and name
+func (s *MemoryStore) SaveTrigger(ctx context.Context, namespace, name string, trigger *Trigger) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := trigger.Validate(); err != nil {
+		return fmt.Errorf("invalid trigger: %w", err)
+	}
+
+	// Overlaps and never-matching criteria are legal but usually mistakes
+	others, _ := s.GetAllTriggers(ctx)
+	for _, finding := range CheckTrigger(trigger, others) {
+		log.Printf("Warning: trigger %s: %s", trigger.ID, finding)
+	}
+
+	key := fmt.Sprintf("%s.%s", namespace, name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if previous, ok := s.keys[key]; ok {
+		s.index.removeTrigger(previous)
+	}
+	s.index.removeTrigger(trigger.ID)
+	s.index.addTrigger(trigger)
+	s.keys[key] = trigger.ID
+	return nil
+}
+
+// DeleteTrigger removes the trigger saved under a namespace and name
+func (s *MemoryStore) DeleteTrigger(ctx context.Context, namespace, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("%s.%s", namespace, name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.keys[key]; ok {
+		s.index.removeTrigger(id)
+		delete(s.keys, key)
+	}
+	return nil
+}
+
+// Close does nothing: the store holds no resources
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,40 @@
+package trigger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMemoryStore tests saving, replacing and deleting triggers in memory
+func TestMemoryStore(t *testing.T) {
+	var store TriggerStore = NewMemoryStore()
+	defer store.Close()
+
+	ctx := context.Background()
+	require.NoError(t, store.SaveTrigger(ctx, "default", "alert", &Trigger{ID: "alert", Namespaces: []string{"ops"}}))
+	require.NoError(t, store.SaveTrigger(ctx, "default", "deploy", &Trigger{ID: "deploy", Namespaces: []string{"ops"}}))
+	triggers, err := store.GetTriggers(ctx, "ops")
+	require.NoError(t, err)
+	assert.Len(t, triggers, 2)
+
+	// Saving under the same key replaces the trigger and its index entries
+	require.NoError(t, store.SaveTrigger(ctx, "default", "deploy", &Trigger{ID: "deploy", Namespaces: []string{"prod"}, EventType: "deployed"}))
+	triggers, err = store.GetTriggersForEvent(ctx, "prod", "deployed")
+	require.NoError(t, err)
+	require.Len(t, triggers, 1)
+	assert.Equal(t, "deploy", triggers[0].ID)
+	triggers, err = store.GetTriggers(ctx, "ops")
+	require.NoError(t, err)
+	assert.Len(t, triggers, 1)
+
+	require.NoError(t, store.DeleteTrigger(ctx, "default", "alert"))
+	page, total, err := store.ListTriggers(ctx, ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, "deploy", page[0].ID)
+
+	assert.Error(t, store.SaveTrigger(ctx, "default", "invalid", &Trigger{}))
+}
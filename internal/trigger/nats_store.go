@@ -3,18 +3,39 @@ package trigger
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"slices"
+	"sort"
 	"sync"
 	"strings"
 
 	"github.com/nats-io/nats.go"
 )
 
+// NATSStore is a TriggerStore backed by a NATS KV bucket.
+// The store does not own its connection: Close stops the store's watch but leaves
+// the connection open, so it can be shared with other components. Callers close it.
 type NATSStore struct {
 	nc    *nats.Conn
 	kv    nats.KeyValue
 	index *namespaceIndex
 	mu    sync.RWMutex
+	// lifecycleSubject is where trigger.created/updated/deleted events are published, empty disables them
+	lifecycleSubject string
+	// readOnly stores follow the KV watch and reject writes
+	readOnly bool
+	// following is set once the store has started following the KV watch
+	following bool
+	// stopWatch cancels the KV watch started by LoadAll or Watch
+	stopWatch context.CancelFunc
+	// filter restricts the index to the triggers it accepts, nil indexes every trigger
+	filter func(*Trigger) bool
+	// changelog records mutations of the store, nil disables it
+	changelog *Changelog
+	// policies looks up the policies enforced on saved triggers, nil disables them
+	policies PolicySource
 }
 
 // namespaceIndex maintains an index of triggers by namespace pattern
@@ -23,20 +44,66 @@ type namespaceIndex struct {
 	exactMatches map[string][]string
 	// pattern matches: pattern -> []triggerID
 	patternMatches map[string][]string
+	// compiled wildcard patterns, excluding "*" which is always matched
+	patterns map[string]*namespacePattern
+	// typed triggers: event type -> []triggerID; triggers without an event type are not listed
+	eventTypes map[string][]string
 	// all triggers by ID
 	triggers map[string]*Trigger
+	// ids lists the IDs of all triggers in ascending order, for paging
+	ids []string
+	// filter skips triggers it rejects, nil indexes every trigger
+	filter func(*Trigger) bool
+	// candidates caches the results of getTriggersForEvent by namespace and event type,
+	// cleared whenever a trigger is added or removed. Lookups run under the store's read
+	// lock, so the cache has its own.
+	candidates   map[candidateKey][]*Trigger
+	candidatesMu sync.Mutex
 }
 
+// candidateKey identifies the shape of an event for the candidate cache
+type candidateKey struct {
+	namespace string
+	eventType string
+}
+
+// maxCandidateEntries bounds the candidate cache; it is cleared when full, since the
+// workload is dominated by a handful of event types that repopulate it quickly
+const maxCandidateEntries = 4096
+
 func newNamespaceIndex() *namespaceIndex {
 	return &namespaceIndex{
 		exactMatches:   make(map[string][]string),
 		patternMatches: make(map[string][]string),
+		patterns:       make(map[string]*namespacePattern),
+		eventTypes:     make(map[string][]string),
 		triggers:       make(map[string]*Trigger),
+		candidates:     make(map[candidateKey][]*Trigger),
+	}
+}
+
+// invalidateCandidates clears the candidate cache after the index changed
+func (idx *namespaceIndex) invalidateCandidates() {
+	idx.candidatesMu.Lock()
+	defer idx.candidatesMu.Unlock()
+	if len(idx.candidates) > 0 {
+		idx.candidates = make(map[candidateKey][]*Trigger)
 	}
 }
 
 func (idx *namespaceIndex) addTrigger(trigger *Trigger) {
+	if idx.filter != nil && !idx.filter(trigger) {
+		return
+	}
+	idx.invalidateCandidates()
 	idx.triggers[trigger.ID] = trigger
+	if i := sort.SearchStrings(idx.ids, trigger.ID); i == len(idx.ids) || idx.ids[i] != trigger.ID {
+		idx.ids = slices.Insert(idx.ids, i, trigger.ID)
+	}
+
+	if trigger.EventType != "" {
+		idx.eventTypes[trigger.EventType] = append(idx.eventTypes[trigger.EventType], trigger.ID)
+	}
 
 	// If no namespaces specified, add to pattern matches with "*"
 	if len(trigger.Namespaces) == 0 {
@@ -48,6 +115,9 @@ func (idx *namespaceIndex) addTrigger(trigger *Trigger) {
 	for _, pattern := range trigger.Namespaces {
 		if strings.Contains(pattern, "*") {
 			idx.patternMatches[pattern] = append(idx.patternMatches[pattern], trigger.ID)
+			if pattern != "*" {
+				idx.patterns[pattern] = compileNamespacePattern(pattern)
+			}
 		} else {
 			idx.exactMatches[pattern] = append(idx.exactMatches[pattern], trigger.ID)
 		}
@@ -56,12 +126,24 @@ func (idx *namespaceIndex) addTrigger(trigger *Trigger) {
 
 func (idx *namespaceIndex) removeTrigger(triggerID string) {
 	// Check if trigger exists
-	if _, exists := idx.triggers[triggerID]; !exists {
+	trigger, exists := idx.triggers[triggerID]
+	if !exists {
 		return
 	}
 
 	// Remove from triggers map
 	delete(idx.triggers, triggerID)
+	idx.invalidateCandidates()
+	if i := sort.SearchStrings(idx.ids, triggerID); i < len(idx.ids) && idx.ids[i] == triggerID {
+		idx.ids = slices.Delete(idx.ids, i, i+1)
+	}
+
+	// Remove from event type index
+	if ids := removeID(idx.eventTypes[trigger.EventType], triggerID); len(ids) == 0 {
+		delete(idx.eventTypes, trigger.EventType)
+	} else {
+		idx.eventTypes[trigger.EventType] = ids
+	}
 
 	// Remove from exact matches
 	for namespace, ids := range idx.exactMatches {
@@ -88,25 +170,86 @@ func (idx *namespaceIndex) removeTrigger(triggerID string) {
 		}
 		if len(newIds) == 0 {
 			delete(idx.patternMatches, pattern)
+			delete(idx.patterns, pattern)
 		} else {
 			idx.patternMatches[pattern] = newIds
 		}
 	}
 }
 
+// removeID returns ids without triggerID
+func removeID(ids []string, triggerID string) []string {
+	newIds := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id != triggerID {
+			newIds = append(newIds, id)
+		}
+	}
+	return newIds
+}
+
+// getTriggersForEvent returns the triggers of a namespace whose event type matches
+// eventType or is empty, so criteria are only evaluated for candidate triggers.
+// Results are cached per namespace and event type; the returned slice is shared and
+// must not be modified.
+func (idx *namespaceIndex) getTriggersForEvent(namespace, eventType string) []*Trigger {
+	key := candidateKey{namespace: namespace, eventType: eventType}
+	idx.candidatesMu.Lock()
+	cached, ok := idx.candidates[key]
+	idx.candidatesMu.Unlock()
+	if ok {
+		return cached
+	}
+
+	triggers := idx.findTriggersForEvent(namespace, eventType)
+	// Limit the capacity so appends by callers copy instead of writing to the cache
+	triggers = triggers[:len(triggers):len(triggers)]
+
+	idx.candidatesMu.Lock()
+	defer idx.candidatesMu.Unlock()
+	if len(idx.candidates) >= maxCandidateEntries {
+		idx.candidates = make(map[candidateKey][]*Trigger)
+	}
+	idx.candidates[key] = triggers
+	return triggers
+}
+
+// findTriggersForEvent walks the index for the candidate triggers of an event
+func (idx *namespaceIndex) findTriggersForEvent(namespace, eventType string) []*Trigger {
+	candidates := idx.getTriggers(namespace)
+
+	typed := make(map[string]bool)
+	for _, key := range eventTypeKeys(eventType) {
+		for _, id := range idx.eventTypes[key] {
+			typed[id] = true
+		}
+	}
+
+	triggers := candidates[:0]
+	for _, trigger := range candidates {
+		if trigger.EventType == "" || typed[trigger.ID] {
+			triggers = append(triggers, trigger)
+		}
+	}
+	return triggers
+}
+
 func (idx *namespaceIndex) getTriggers(namespace string) []*Trigger {
 	var triggerIDs []string
 
-	// Get exact matches
-	if ids, exists := idx.exactMatches[namespace]; exists {
-		triggerIDs = append(triggerIDs, ids...)
-	}
+	// Get pattern matches, short-circuiting the common "*" pattern
+	triggerIDs = append(triggerIDs, idx.patternMatches["*"]...)
 
-	// Get pattern matches
-	for pattern, ids := range idx.patternMatches {
-		if pattern == "*" || isNamespaceMatch(&Trigger{Namespaces: []string{pattern}}, namespace) {
+	// Get exact and pattern matches of the namespace and its ancestors, which are inherited
+	for _, level := range namespaceHierarchy(namespace) {
+		if ids, exists := idx.exactMatches[level]; exists {
 			triggerIDs = append(triggerIDs, ids...)
 		}
+		for pattern, compiled := range idx.patterns {
+			if compiled.match(level) {
+				triggerIDs = append(triggerIDs, idx.patternMatches[pattern]...)
+			}
+		}
 	}
 
 	// Convert IDs to triggers
@@ -148,15 +291,22 @@ func NewNATSStore(nc *nats.Conn, bucketName string) (*NATSStore, error) {
 	}
 
 	return &NATSStore{
-		nc:    nc,
-		kv:    kv,
-		index: newNamespaceIndex(),
+		nc:               nc,
+		kv:               kv,
+		index:            newNamespaceIndex(),
+		lifecycleSubject: DefaultLifecycleSubject,
 	}, nil
 }
 
 func (s *NATSStore) LoadAll(ctx context.Context) error {
-	keys, err := s.kv.Keys()
-	if err != nil {
+	if s.readOnly {
+		return s.follow(ctx)
+	}
+
+	keys, err := s.kv.Keys(nats.Context(ctx))
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		keys = nil
+	} else if err != nil {
 		return fmt.Errorf("failed to list keys: %w", err)
 	}
 
@@ -165,8 +315,13 @@ func (s *NATSStore) LoadAll(ctx context.Context) error {
 
 	// Create new index
 	s.index = newNamespaceIndex()
+	s.index.filter = s.filter
 
 	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		entry, err := s.kv.Get(key)
 		if err != nil {
 			return fmt.Errorf("failed to get key %s: %w", key, err)
@@ -183,52 +338,87 @@ func (s *NATSStore) LoadAll(ctx context.Context) error {
 	return nil
 }
 
-func (s *NATSStore) Watch(ctx context.Context) {
-	watcher, err := s.kv.WatchAll()
-	if err != nil {
+// Watch keeps the index current with changes to the trigger bucket. It returns once
+// the watch has caught up with the bucket's current values, or with an error if the
+// watch cannot be started or ctx is cancelled first. Updates are then applied in the
+// background until ctx is cancelled or the store is closed. Calling Watch on a store
+// that is already following the bucket, e.g. a read-only store after LoadAll, is a no-op.
+func (s *NATSStore) Watch(ctx context.Context) error {
+	s.mu.RLock()
+	following := s.following
+	s.mu.RUnlock()
+	if following {
+		return nil
+	}
+	return s.follow(ctx)
+}
+
+// SetFilter restricts the index to the triggers filter accepts, e.g. those relevant to
+// the partitions of a Partitioner, and rebuilds it. Filters that depend on changing
+// state are applied again by calling SetFilter after the state changed. A nil filter
+// indexes every trigger. A store following the bucket restarts its watch, bound to ctx
+// like the one started by Watch.
+func (s *NATSStore) SetFilter(ctx context.Context, filter func(*Trigger) bool) error {
+	s.mu.Lock()
+	s.filter = filter
+	following := s.following
+	s.mu.Unlock()
+
+	if following || s.readOnly {
+		return s.follow(ctx)
+	}
+	return s.LoadAll(ctx)
+}
+
+// applyUpdate applies a KV watch update to the index
+func applyUpdate(idx *namespaceIndex, update nats.KeyValueEntry) {
+	if update.Operation() != nats.KeyValuePut {
+		// Handle deletion; keys are <namespace>.<id>
+		_, id, _ := strings.Cut(update.Key(), ".")
+		idx.removeTrigger(id)
 		return
 	}
 
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case update := <-watcher.Updates():
-				if update == nil {
-					continue
-				}
-
-				s.mu.Lock()
-				if update.Operation() == nats.KeyValueDelete {
-					// Handle deletion
-					key := update.Key()
-					s.index.removeTrigger(key)
-				} else {
-					// Handle create/update
-					var trigger Trigger
-					if err := json.Unmarshal(update.Value(), &trigger); err != nil {
-						continue
-					}
-
-					// Remove existing trigger if it exists
-					s.index.removeTrigger(trigger.ID)
-					// Add updated trigger
-					s.index.addTrigger(&trigger)
-				}
-				s.mu.Unlock()
-			}
-		}
-	}()
+	// Handle create/update
+	var trigger Trigger
+	if err := json.Unmarshal(update.Value(), &trigger); err != nil {
+		log.Printf("Error decoding trigger %s: %v", update.Key(), err)
+		return
+	}
+
+	// Replace the existing trigger if it exists
+	idx.removeTrigger(trigger.ID)
+	idx.addTrigger(&trigger)
+}
+
+// GetTriggers returns the triggers that apply to a namespace
+func (s *NATSStore) GetTriggers(ctx context.Context, namespace string) ([]*Trigger, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.index.getTriggers(namespace), nil
 }
 
-func (s *NATSStore) GetTriggers(namespace string) []*Trigger {
+// GetTriggersForEvent returns the triggers of a namespace that apply to an event type
+func (s *NATSStore) GetTriggersForEvent(ctx context.Context, namespace, eventType string) ([]*Trigger, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.index.getTriggers(namespace)
+	return s.index.getTriggersForEvent(namespace, eventType), nil
 }
 
-func (s *NATSStore) GetAllTriggers() []*Trigger {
+// GetAllTriggers returns all triggers from all namespaces
+func (s *NATSStore) GetAllTriggers(ctx context.Context) ([]*Trigger, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -236,33 +426,244 @@ func (s *NATSStore) GetAllTriggers() []*Trigger {
 	for _, trigger := range s.index.triggers {
 		allTriggers = append(allTriggers, trigger)
 	}
-	return allTriggers
+	return allTriggers, nil
+}
+
+// after returns the position of the first trigger ID that sorts after id
+func (idx *namespaceIndex) after(id string) int {
+	return sort.Search(len(idx.ids), func(i int) bool { return idx.ids[i] > id })
+}
+
+// ListTriggers returns a page of triggers ordered by ID along with the total trigger count
+func (s *NATSStore) ListTriggers(ctx context.Context, opts ListOptions) ([]*Trigger, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	page, total := s.index.page(opts)
+	return page, total, nil
+}
+
+// page returns a page of triggers ordered by ID along with the total trigger count
+func (idx *namespaceIndex) page(opts ListOptions) ([]*Trigger, int) {
+	total := len(idx.ids)
+
+	start := max(opts.Offset, 0)
+	if opts.After != "" {
+		start += idx.after(opts.After)
+	}
+	if start >= total {
+		return []*Trigger{}, total
+	}
+	end := total
+	if opts.Limit > 0 && start+opts.Limit < total {
+		end = start + opts.Limit
+	}
+
+	page := make([]*Trigger, 0, end-start)
+	for _, id := range idx.ids[start:end] {
+		page = append(page, idx.triggers[id])
+	}
+	return page, total
+}
+
+// ForEachTrigger calls fn for every trigger ordered by ID until fn returns false.
+// The store lock is not held while fn runs; iteration resumes after the last ID seen,
+// so triggers removed concurrently are skipped.
+// Iteration stops with ctx's error when ctx is cancelled.
+func (s *NATSStore) ForEachTrigger(ctx context.Context, fn func(*Trigger) bool) error {
+	var last *Trigger
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		s.mu.RLock()
+		i := 0
+		if last != nil {
+			i = s.index.after(last.ID)
+		}
+		var trigger *Trigger
+		if i < len(s.index.ids) {
+			trigger = s.index.triggers[s.index.ids[i]]
+		}
+		s.mu.RUnlock()
+
+		if trigger == nil || !fn(trigger) {
+			return nil
+		}
+		last = trigger
+	}
 }
 
 func (s *NATSStore) SaveTrigger(ctx context.Context, namespace, name string, trigger *Trigger) error {
+	return s.saveTrigger(ctx, namespace, name, trigger, 0)
+}
+
+// saveTrigger saves a trigger; restoredFrom is the changelog revision it restores, if any
+func (s *NATSStore) saveTrigger(ctx context.Context, namespace, name string, trigger *Trigger, restoredFrom uint64) error {
+	if s.readOnly {
+		return ErrReadOnlyStore
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := trigger.Validate(); err != nil {
+		return fmt.Errorf("invalid trigger: %w", err)
+	}
+
+	// Overlaps and never-matching criteria are legal but usually mistakes
+	s.mu.RLock()
+	others := make([]*Trigger, 0, len(s.index.triggers))
+	for _, t := range s.index.triggers {
+		others = append(others, t)
+	}
+	s.mu.RUnlock()
+	for _, finding := range CheckTrigger(trigger, others) {
+		log.Printf("Warning: trigger %s: %s", trigger.ID, finding)
+	}
+
 	key := fmt.Sprintf("%s.%s", namespace, name)
-	data, err := json.Marshal(trigger)
+	previous, operation, err := s.writeTrigger(ctx, namespace, key, trigger)
 	if err != nil {
-		return fmt.Errorf("failed to marshal trigger: %w", err)
+		return err
 	}
+	eventType := EventTypeTriggerUpdated
+	if operation == ChangeCreate {
+		eventType = EventTypeTriggerCreated
+	}
+	s.recordChange(ctx, operation, namespace, name, previous, trigger, restoredFrom)
 
-	if _, err := s.kv.Put(key, data); err != nil {
-		return fmt.Errorf("failed to save trigger: %w", err)
+	if err := s.publishLifecycleEvent(eventType, namespace, name, trigger); err != nil {
+		log.Printf("Error publishing %s event for %s: %v", eventType, key, err)
 	}
 
 	return nil
 }
 
 func (s *NATSStore) DeleteTrigger(ctx context.Context, namespace, name string) error {
+	return s.deleteTrigger(ctx, namespace, name, 0)
+}
+
+// deleteTrigger deletes a trigger; restoredFrom is the changelog revision it restores, if any
+func (s *NATSStore) deleteTrigger(ctx context.Context, namespace, name string, restoredFrom uint64) error {
+	if s.readOnly {
+		return ErrReadOnlyStore
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	key := fmt.Sprintf("%s.%s", namespace, name)
+
+	// Capture the trigger being deleted so the lifecycle event can describe it
+	previous, revision, err := s.getStoredRevision(key)
+	if revision == 0 {
+		// Nothing is stored, so there is no deletion to record or announce
+		return err
+	}
+
 	if err := s.kv.Delete(key); err != nil {
 		return fmt.Errorf("failed to delete trigger: %w", err)
 	}
+	if previous == nil {
+		// The entry could not be decoded and was never a trigger
+		return nil
+	}
+	s.recordChange(ctx, ChangeDelete, namespace, name, previous, nil, restoredFrom)
+
+	if err := s.publishLifecycleEvent(EventTypeTriggerDeleted, namespace, name, previous); err != nil {
+		log.Printf("Error publishing %s event for %s: %v", EventTypeTriggerDeleted, key, err)
+	}
 
 	return nil
 }
 
+// errTriggerConflict is returned when another writer saved a trigger first
+var errTriggerConflict = errors.New("trigger saved concurrently")
+
+// maxSaveRetries bounds the compare-and-swap attempts of a trigger save
+const maxSaveRetries = 5
+
+// writeTrigger stores a trigger with a create, or an update of the revision it
+// replaces, so concurrent saves cannot both report a creation or silently overwrite
+// each other. It returns the replaced trigger and whether the save created it.
+func (s *NATSStore) writeTrigger(ctx context.Context, namespace, key string, trigger *Trigger) (*Trigger, string, error) {
+	for attempt := 0; attempt < maxSaveRetries; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, "", err
+		}
+		previous, revision, err := s.getStoredRevision(key)
+		if err != nil && revision == 0 {
+			return nil, "", err
+		}
+		if err != nil {
+			log.Printf("Warning: previous definition of trigger %s unavailable: %v", key, err)
+		}
+
+		operation := ChangeUpdate
+		if revision == 0 {
+			operation = ChangeCreate
+		}
+		if err := s.enforcePolicy(ctx, namespace, trigger, operation == ChangeCreate); err != nil {
+			return nil, "", err
+		}
+		data, err := json.Marshal(trigger)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to marshal trigger: %w", err)
+		}
+
+		if operation == ChangeCreate {
+			_, err = s.kv.Create(key, data)
+		} else {
+			_, err = s.kv.Update(key, data, revision)
+		}
+		var apiErr *nats.APIError
+		if errors.Is(err, nats.ErrKeyExists) || errors.As(err, &apiErr) && apiErr.ErrorCode == nats.JSErrCodeStreamWrongLastSequence {
+			continue
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to save trigger: %w", err)
+		}
+		return previous, operation, nil
+	}
+	return nil, "", fmt.Errorf("failed to save trigger %s: %w", key, errTriggerConflict)
+}
+
+// getStored returns the trigger stored under a key, nil if there is none
+func (s *NATSStore) getStored(key string) (*Trigger, error) {
+	t, _, err := s.getStoredRevision(key)
+	return t, err
+}
+
+// getStoredRevision returns the trigger stored under a key and its revision, nil and 0
+// if there is none. The revision is also returned when the trigger cannot be decoded.
+func (s *NATSStore) getStoredRevision(key string) (*Trigger, uint64, error) {
+	entry, err := s.kv.Get(key)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get trigger: %w", err)
+	}
+	var t Trigger
+	if err := json.Unmarshal(entry.Value(), &t); err != nil {
+		return nil, entry.Revision(), fmt.Errorf("failed to unmarshal trigger: %w", err)
+	}
+	return &t, entry.Revision(), nil
+}
+
+// Close stops the store's watch. The connection is not closed, it belongs to the caller.
 func (s *NATSStore) Close() error {
-	s.nc.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopWatch != nil {
+		s.stopWatch()
+		s.stopWatch = nil
+	}
+	s.following = false
 	return nil
 }
@@ -0,0 +1,304 @@
+package trigger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestStore creates a NATSStore backed only by its in-memory index
+func newTestStore(triggers ...*Trigger) *NATSStore {
+	store := &NATSStore{index: newNamespaceIndex()}
+	for _, t := range triggers {
+		store.index.addTrigger(t)
+	}
+	return store
+}
+
+// TestListTriggersPagination tests paginated listing ordered by ID
+func TestListTriggersPagination(t *testing.T) {
+	var triggers []*Trigger
+	for i := 4; i >= 0; i-- {
+		triggers = append(triggers, &Trigger{ID: fmt.Sprintf("trigger-%d", i)})
+	}
+	store := newTestStore(triggers...)
+
+	ctx := context.Background()
+	page, total, err := store.ListTriggers(ctx, ListOptions{Offset: 0, Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 5, total)
+	require.Len(t, page, 2)
+	assert.Equal(t, "trigger-0", page[0].ID)
+	assert.Equal(t, "trigger-1", page[1].ID)
+
+	page, _, _ = store.ListTriggers(ctx, ListOptions{Offset: 4, Limit: 2})
+	require.Len(t, page, 1)
+	assert.Equal(t, "trigger-4", page[0].ID)
+
+	page, _, _ = store.ListTriggers(ctx, ListOptions{Offset: 10, Limit: 2})
+	assert.Empty(t, page)
+
+	page, _, _ = store.ListTriggers(ctx, ListOptions{})
+	assert.Len(t, page, 5)
+
+	// A cursor resumes after the last ID of the previous page
+	page, total, _ = store.ListTriggers(ctx, ListOptions{After: "trigger-1", Limit: 2})
+	assert.Equal(t, 5, total)
+	require.Len(t, page, 2)
+	assert.Equal(t, "trigger-2", page[0].ID)
+	assert.Equal(t, "trigger-3", page[1].ID)
+	page, _, _ = store.ListTriggers(ctx, ListOptions{After: "trigger-10"})
+	require.Len(t, page, 3)
+	assert.Equal(t, "trigger-2", page[0].ID)
+
+	// The order follows triggers being replaced and removed
+	applyUpdate(store.index, kvEntry{key: "default.trigger-2", value: []byte(`{"id":"trigger-2"}`), op: nats.KeyValuePut})
+	applyUpdate(store.index, kvEntry{key: "default.trigger-3", op: nats.KeyValueDelete})
+	store.index.addTrigger(&Trigger{ID: "trigger-25"})
+	page, total, _ = store.ListTriggers(ctx, ListOptions{After: "trigger-1"})
+	assert.Equal(t, 5, total)
+	var ids []string
+	for _, trigger := range page {
+		ids = append(ids, trigger.ID)
+	}
+	assert.Equal(t, []string{"trigger-2", "trigger-25", "trigger-4"}, ids)
+}
+
+// TestForEachTrigger tests streaming iteration and early termination
+func TestForEachTrigger(t *testing.T) {
+	store := newTestStore(&Trigger{ID: "b"}, &Trigger{ID: "a"}, &Trigger{ID: "c"})
+
+	var ids []string
+	err := store.ForEachTrigger(context.Background(), func(t *Trigger) bool {
+		ids = append(ids, t.ID)
+		return len(ids) < 2
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, ids)
+
+	// Triggers removed while iterating are skipped
+	ids = nil
+	err = store.ForEachTrigger(context.Background(), func(t *Trigger) bool {
+		ids = append(ids, t.ID)
+		store.index.removeTrigger("b")
+		return true
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "c"}, ids)
+
+	// A cancelled context stops the iteration
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ids = nil
+	err = store.ForEachTrigger(ctx, func(t *Trigger) bool {
+		ids = append(ids, t.ID)
+		return true
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, ids)
+}
+
+// TestNewLifecycleEvent tests the structure of trigger lifecycle events
+func TestNewLifecycleEvent(t *testing.T) {
+	trig := &Trigger{ID: "config-update", Name: "Config Update"}
+
+	ce, err := newLifecycleEvent("triggers", EventTypeTriggerCreated, "default", "config-update", trig)
+	require.NoError(t, err)
+	assert.NotEmpty(t, ce.ID())
+	assert.Equal(t, "mycelium/triggers/triggers", ce.Source())
+	assert.Equal(t, EventTypeTriggerCreated, ce.Type())
+	assert.Equal(t, "default.config-update", ce.Subject())
+
+	var data LifecycleEventData
+	require.NoError(t, ce.DataAs(&data))
+	assert.Equal(t, "default", data.Namespace)
+	assert.Equal(t, "config-update", data.Name)
+	require.NotNil(t, data.Trigger)
+	assert.Equal(t, "Config Update", data.Trigger.Name)
+}
+
+// TestReadOnlyStoreRejectsWrites tests that follower stores never write to the bucket
+func TestReadOnlyStoreRejectsWrites(t *testing.T) {
+	store := newTestStore(&Trigger{ID: "a"})
+	store.readOnly = true
+
+	err := store.SaveTrigger(context.Background(), "default", "b", &Trigger{ID: "b"})
+	assert.ErrorIs(t, err, ErrReadOnlyStore)
+
+	err = store.DeleteTrigger(context.Background(), "default", "a")
+	assert.ErrorIs(t, err, ErrReadOnlyStore)
+
+	triggers, err := store.GetAllTriggers(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, triggers, 1)
+}
+
+// TestConcurrentSaves tests that concurrent saves of a new trigger report a single creation
+func TestConcurrentSaves(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	if _, err := js.AccountInfo(); err != nil {
+		t.Skip("JetStream not available, skipping integration test")
+	}
+
+	bucket := "save-test-" + uuid.NewString()[:8]
+	defer js.DeleteKeyValue(bucket)
+	store, err := NewNATSStore(nc, bucket)
+	require.NoError(t, err)
+
+	// Every writer wins an attempt at the latest, so writers never exceed the retries
+	const writers = 4
+	var wg sync.WaitGroup
+	operations := make([]string, writers)
+	errs := make([]error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			trig := &Trigger{ID: "orders", Enabled: true, Criteria: fmt.Sprintf("event.data.total > %d", i), Action: "notify"}
+			_, operations[i], errs[i] = store.writeTrigger(context.Background(), "default", "default.orders", trig)
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for i := 0; i < writers; i++ {
+		require.NoError(t, errs[i])
+		if operations[i] == ChangeCreate {
+			created++
+		}
+	}
+	assert.Equal(t, 1, created)
+
+	entry, err := store.kv.Get("default.orders")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(writers), entry.Revision())
+}
+
+// TestDeleteMissingTrigger tests that only deletions of a stored trigger are announced
+func TestDeleteMissingTrigger(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	if _, err := js.AccountInfo(); err != nil {
+		t.Skip("JetStream not available, skipping integration test")
+	}
+
+	id := uuid.NewString()[:8]
+	bucket := "delete-test-" + id
+	defer js.DeleteKeyValue(bucket)
+	store, err := NewNATSStore(nc, bucket)
+	require.NoError(t, err)
+	subject := "delete-test." + id
+	store.SetLifecycleSubject(subject)
+	events, err := nc.SubscribeSync(subject)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, store.DeleteTrigger(ctx, "default", "orders"))
+	require.NoError(t, store.SaveTrigger(ctx, "default", "orders", &Trigger{ID: "orders", Enabled: true, Action: "notify"}))
+	require.NoError(t, store.DeleteTrigger(ctx, "default", "orders"))
+	require.NoError(t, store.DeleteTrigger(ctx, "default", "orders"))
+	require.NoError(t, nc.Flush())
+
+	var types []string
+	for {
+		msg, err := events.NextMsg(200 * time.Millisecond)
+		if err != nil {
+			break
+		}
+		var e struct {
+			Type string `json:"type"`
+		}
+		require.NoError(t, json.Unmarshal(msg.Data, &e))
+		types = append(types, e.Type)
+	}
+	assert.Equal(t, []string{EventTypeTriggerCreated, EventTypeTriggerDeleted}, types)
+}
+
+// kvEntry is a KV watch update for applyUpdate
+type kvEntry struct {
+	key   string
+	value []byte
+	op    nats.KeyValueOp
+}
+
+func (e kvEntry) Bucket() string             { return "triggers" }
+func (e kvEntry) Key() string                { return e.key }
+func (e kvEntry) Value() []byte              { return e.value }
+func (e kvEntry) Revision() uint64           { return 1 }
+func (e kvEntry) Created() time.Time         { return time.Time{} }
+func (e kvEntry) Delta() uint64              { return 0 }
+func (e kvEntry) Operation() nats.KeyValueOp { return e.op }
+
+// TestApplyUpdateDelete tests that watched deletions remove the trigger named by the key
+func TestApplyUpdateDelete(t *testing.T) {
+	store := newTestStore()
+	applyUpdate(store.index, kvEntry{key: "default.a", value: []byte(`{"id":"a","event_type":"x"}`), op: nats.KeyValuePut})
+	applyUpdate(store.index, kvEntry{key: "default.b", value: []byte(`{"id":"b","event_type":"x"}`), op: nats.KeyValuePut})
+
+	applyUpdate(store.index, kvEntry{key: "default.a", op: nats.KeyValueDelete})
+	triggers, err := store.GetAllTriggers(context.Background())
+	require.NoError(t, err)
+	require.Len(t, triggers, 1)
+	assert.Equal(t, "b", triggers[0].ID)
+
+	applyUpdate(store.index, kvEntry{key: "default.b", op: nats.KeyValuePurge})
+	triggers, _ = store.GetAllTriggers(context.Background())
+	assert.Empty(t, triggers)
+}
+
+// TestGetTriggersForEventCache tests that cached candidate sets follow changes to the index
+func TestGetTriggersForEventCache(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore()
+	applyUpdate(store.index, kvEntry{key: "prod.a", value: []byte(`{"id":"a","namespaces":["prod"],"event_type":"prod.order.created"}`), op: nats.KeyValuePut})
+
+	ids := func(eventType string) []string {
+		triggers, err := store.GetTriggersForEvent(ctx, "prod", eventType)
+		require.NoError(t, err)
+		var ids []string
+		for _, trigger := range triggers {
+			ids = append(ids, trigger.ID)
+		}
+		return ids
+	}
+	assert.Equal(t, []string{"a"}, ids("prod.order.created"))
+	assert.Empty(t, ids("prod.order.deleted"))
+	assert.Len(t, store.index.candidates, 2)
+
+	// Appending to a result must not write to the cache
+	cached, _ := store.GetTriggersForEvent(ctx, "prod", "prod.order.created")
+	_ = append(cached, &Trigger{ID: "appended"})
+	assert.Equal(t, []string{"a"}, ids("prod.order.created"))
+
+	applyUpdate(store.index, kvEntry{key: "prod.b", value: []byte(`{"id":"b","namespaces":["*"]}`), op: nats.KeyValuePut})
+	assert.ElementsMatch(t, []string{"a", "b"}, ids("prod.order.created"))
+	assert.Equal(t, []string{"b"}, ids("prod.order.deleted"))
+
+	applyUpdate(store.index, kvEntry{key: "prod.a", value: []byte(`{"id":"a","namespaces":["prod"],"event_type":"prod.order.deleted"}`), op: nats.KeyValuePut})
+	assert.Equal(t, []string{"b"}, ids("prod.order.created"))
+	assert.ElementsMatch(t, []string{"a", "b"}, ids("prod.order.deleted"))
+
+	applyUpdate(store.index, kvEntry{key: "prod.b", op: nats.KeyValueDelete})
+	assert.Empty(t, ids("prod.order.created"))
+}
@@ -0,0 +1,324 @@
+package trigger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/nats-io/nats.go"
+)
+
+// Partition keys events can be partitioned by
+const (
+	// PartitionByNamespace keeps all events of a root namespace on one instance, so
+	// instances only index the triggers of their namespaces
+	PartitionByNamespace = "namespace"
+	// PartitionByObjectID spreads events by object ID; every instance indexes every trigger
+	PartitionByObjectID = "object_id"
+)
+
+// Partitioning defaults
+const (
+	DefaultPartitionBucket    = "triggerd-partitions"
+	DefaultPartitionCount     = 64
+	DefaultPartitionHeartbeat = 5 * time.Second
+	// memberTTLHeartbeats is how many heartbeats a member may miss before it leaves the group
+	memberTTLHeartbeats = 3
+)
+
+// PartitionConfig configures a Partitioner
+type PartitionConfig struct {
+	// Bucket is the KV bucket members register in (default: DefaultPartitionBucket)
+	Bucket string
+	// Key is PartitionByNamespace (default) or PartitionByObjectID
+	Key string
+	// Partitions is the number of partitions; every member of a group must use the same
+	// (default: DefaultPartitionCount)
+	Partitions int
+	// Heartbeat is how often membership is refreshed (default: DefaultPartitionHeartbeat).
+	// Members that miss memberTTLHeartbeats heartbeats leave the group.
+	Heartbeat time.Duration
+	// InstanceID identifies this member and must be unique within the group
+	InstanceID string
+}
+
+// memberInfo is the value a member registers under its instance ID
+type memberInfo struct {
+	InstanceID string    `json:"instance_id"`
+	Key        string    `json:"key"`
+	Partitions int       `json:"partitions"`
+	Joined     time.Time `json:"joined"`
+}
+
+// Partitioner splits event evaluation across a group of triggerd instances. Members
+// register in a KV bucket and every partition is owned by one member, chosen by
+// rendezvous hashing so membership changes only move the partitions of the members
+// that joined or left. Every member receives every event and evaluates only those of
+// its partitions.
+type Partitioner struct {
+	cfg      PartitionConfig
+	kv       nats.KeyValue
+	joined   time.Time
+	members  []string
+	owned    map[int]bool
+	assigned bool
+	onChange func()
+	mu       sync.RWMutex
+	stop     context.CancelFunc
+}
+
+// NewPartitioner creates a partitioner, creating the membership bucket if needed
+func NewPartitioner(nc *nats.Conn, cfg PartitionConfig) (*Partitioner, error) {
+	if cfg.InstanceID == "" {
+		return nil, fmt.Errorf("instance ID cannot be empty")
+	}
+	if cfg.Bucket == "" {
+		cfg.Bucket = DefaultPartitionBucket
+	}
+	if cfg.Key == "" {
+		cfg.Key = PartitionByNamespace
+	}
+	if cfg.Key != PartitionByNamespace && cfg.Key != PartitionByObjectID {
+		return nil, fmt.Errorf("unknown partition key %q", cfg.Key)
+	}
+	if cfg.Partitions <= 0 {
+		cfg.Partitions = DefaultPartitionCount
+	}
+	if cfg.Heartbeat <= 0 {
+		cfg.Heartbeat = DefaultPartitionHeartbeat
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get JetStream context: %w", err)
+	}
+
+	// Members that stop refreshing their key age out of the bucket
+	kv, err := js.CreateKeyValue(&nats.KeyValueConfig{
+		Bucket:      cfg.Bucket,
+		Description: "Members of a partitioned triggerd group",
+		TTL:         memberTTLHeartbeats * cfg.Heartbeat,
+	})
+	if err != nil {
+		kv, err = js.KeyValue(cfg.Bucket)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get/create KV bucket: %w", err)
+		}
+	}
+
+	return &Partitioner{cfg: cfg, kv: kv, owned: make(map[int]bool)}, nil
+}
+
+// OnChange sets a function called once the member knows its partitions and after they changed
+func (p *Partitioner) OnChange(fn func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onChange = fn
+}
+
+// Start joins the group and returns once the member knows its partitions. Membership
+// is refreshed in the background until ctx is cancelled or the partitioner is closed.
+func (p *Partitioner) Start(ctx context.Context) error {
+	p.joined = time.Now().UTC()
+	if err := p.refresh(ctx); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	p.mu.Lock()
+	p.stop = cancel
+	p.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(p.cfg.Heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := p.refresh(ctx); err != nil && ctx.Err() == nil {
+					log.Printf("Error refreshing partition membership: %v", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// refresh registers the member, reads the group and reassigns the partitions
+func (p *Partitioner) refresh(ctx context.Context) error {
+	data, err := json.Marshal(memberInfo{
+		InstanceID: p.cfg.InstanceID,
+		Key:        p.cfg.Key,
+		Partitions: p.cfg.Partitions,
+		Joined:     p.joined,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal membership: %w", err)
+	}
+	if _, err := p.kv.Put(p.cfg.InstanceID, data); err != nil {
+		return fmt.Errorf("failed to register member: %w", err)
+	}
+
+	members, err := p.kv.Keys(nats.Context(ctx))
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		members = nil
+	} else if err != nil {
+		return fmt.Errorf("failed to list members: %w", err)
+	}
+	p.assign(members)
+	return nil
+}
+
+// assign computes the partitions of the member for a group, calling the change
+// function when they changed
+func (p *Partitioner) assign(members []string) {
+	sort.Strings(members)
+	owned := make(map[int]bool)
+	for partition := 0; partition < p.cfg.Partitions; partition++ {
+		if partitionOwner(members, partition) == p.cfg.InstanceID {
+			owned[partition] = true
+		}
+	}
+
+	p.mu.Lock()
+	changed := !p.assigned || len(owned) != len(p.owned)
+	for partition := range owned {
+		changed = changed || !p.owned[partition]
+	}
+	p.members = members
+	p.owned = owned
+	p.assigned = true
+	onChange := p.onChange
+	p.mu.Unlock()
+
+	if changed {
+		log.Printf("Partitions rebalanced: owning %d of %d with %d members", len(owned), p.cfg.Partitions, len(members))
+		if onChange != nil {
+			onChange()
+		}
+	}
+}
+
+// partitionOwner returns the member with the highest hash for a partition
+func partitionOwner(members []string, partition int) string {
+	var owner string
+	var best uint64
+	for _, member := range members {
+		if score := hashKey(member + "/" + strconv.Itoa(partition)); owner == "" || score > best {
+			owner, best = member, score
+		}
+	}
+	return owner
+}
+
+// hashKey returns the 64-bit FNV-1a hash of a key, finalized with the splitmix64 mixer
+// since FNV's high bits barely change for keys differing in their last bytes
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// PartitionOf returns the partition of a partition key
+func PartitionOf(key string, partitions int) int {
+	return int(hashKey(key) % uint64(partitions))
+}
+
+// rootNamespace returns the first level of a namespace, which partitions namespaces so
+// that triggers inherited from parent namespaces live on the same member
+func rootNamespace(namespace string) string {
+	root, _, _ := strings.Cut(namespace, NamespaceSeparator)
+	return root
+}
+
+// objectID returns the object ID of an event, as criteria see it in event.object_id
+func objectID(event *cloudevents.Event) string {
+	return event.ID()
+}
+
+// eventPartitionKey returns the value an event is partitioned by
+func (p *Partitioner) eventPartitionKey(event *cloudevents.Event) string {
+	if p.cfg.Key == PartitionByObjectID {
+		return objectID(event)
+	}
+	return rootNamespace(EventNamespace(event.Type()))
+}
+
+// Owns reports whether the member evaluates an event
+func (p *Partitioner) Owns(event *cloudevents.Event) bool {
+	partition := PartitionOf(p.eventPartitionKey(event), p.cfg.Partitions)
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.owned[partition]
+}
+
+// Relevant reports whether a trigger can match events of the member's partitions.
+// With namespace partitioning, triggers without namespaces or with a wildcard in the
+// root level of a namespace pattern apply to every partition.
+func (p *Partitioner) Relevant(trigger *Trigger) bool {
+	if p.cfg.Key != PartitionByNamespace || len(trigger.Namespaces) == 0 {
+		return true
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, pattern := range trigger.Namespaces {
+		root := rootNamespace(pattern)
+		if strings.Contains(root, "*") || p.owned[PartitionOf(root, p.cfg.Partitions)] {
+			return true
+		}
+	}
+	return false
+}
+
+// Members returns the instance IDs of the group
+func (p *Partitioner) Members() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]string(nil), p.members...)
+}
+
+// Partitions returns the partitions the member owns, in ascending order
+func (p *Partitioner) Partitions() []int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	partitions := make([]int, 0, len(p.owned))
+	for partition := range p.owned {
+		partitions = append(partitions, partition)
+	}
+	sort.Ints(partitions)
+	return partitions
+}
+
+// Close stops refreshing membership and leaves the group, so the other members take
+// over the partitions without waiting for the membership to expire
+func (p *Partitioner) Close() error {
+	p.mu.Lock()
+	if p.stop != nil {
+		p.stop()
+		p.stop = nil
+	}
+	p.mu.Unlock()
+
+	if err := p.kv.Delete(p.cfg.InstanceID); err != nil && !errors.Is(err, nats.ErrKeyNotFound) {
+		return fmt.Errorf("failed to leave partition group: %w", err)
+	}
+	return nil
+}
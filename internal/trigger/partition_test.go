@@ -0,0 +1,144 @@
+package trigger
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestPartitioner creates a partitioner assigned for a fixed group, without NATS
+func newTestPartitioner(key, instanceID string, members ...string) *Partitioner {
+	p := &Partitioner{cfg: PartitionConfig{Key: key, Partitions: 16, InstanceID: instanceID}}
+	p.assign(members)
+	return p
+}
+
+// TestPartitionAssignment tests that every partition has one owner and that a
+// membership change only moves the partitions of the member that left
+func TestPartitionAssignment(t *testing.T) {
+	members := []string{"a", "b", "c"}
+	owners := make(map[int]string)
+	for _, member := range members {
+		for _, partition := range newTestPartitioner(PartitionByNamespace, member, members...).Partitions() {
+			require.Empty(t, owners[partition], "partition %d has two owners", partition)
+			owners[partition] = member
+		}
+	}
+	assert.Len(t, owners, 16)
+
+	for _, member := range []string{"a", "b"} {
+		for _, partition := range newTestPartitioner(PartitionByNamespace, member, "a", "b").Partitions() {
+			if owners[partition] != "c" {
+				assert.Equal(t, member, owners[partition], "partition %d moved although its owner stayed", partition)
+			}
+		}
+	}
+}
+
+// TestPartitionFiltering tests which events and triggers a member handles
+func TestPartitionFiltering(t *testing.T) {
+	event := func(eventType, id string) *cloudevents.Event {
+		e := cloudevents.NewEvent()
+		e.SetID(id)
+		e.SetSource("test")
+		e.SetType(eventType)
+		return &e
+	}
+
+	a := newTestPartitioner(PartitionByNamespace, "a", "a", "b")
+	b := newTestPartitioner(PartitionByNamespace, "b", "a", "b")
+
+	// Find a root namespace of each member
+	var mine, theirs string
+	for i := 0; mine == "" || theirs == ""; i++ {
+		namespace := fmt.Sprintf("tenant%d", i)
+		if a.Owns(event(namespace+".user.created", "1")) {
+			mine = namespace
+		} else {
+			theirs = namespace
+		}
+	}
+
+	// Child namespaces belong to the partition of their root, like the triggers they inherit
+	assert.True(t, a.Owns(event(mine+"/eu.user.created", "2")))
+	assert.False(t, b.Owns(event(mine+"/eu.user.created", "2")))
+	assert.True(t, b.Owns(event(theirs+".user.created", "3")))
+
+	assert.True(t, a.Relevant(&Trigger{ID: "global"}))
+	assert.True(t, a.Relevant(&Trigger{ID: "wildcard", Namespaces: []string{"tenant*/prod"}}))
+	assert.True(t, a.Relevant(&Trigger{ID: "mine", Namespaces: []string{mine + "/*"}}))
+	assert.False(t, a.Relevant(&Trigger{ID: "theirs", Namespaces: []string{theirs}}))
+	assert.True(t, a.Relevant(&Trigger{ID: "both", Namespaces: []string{theirs, mine}}))
+
+	// Object partitioning spreads a namespace over members and keeps every trigger
+	objects := newTestPartitioner(PartitionByObjectID, "a", "a", "b")
+	assert.True(t, objects.Relevant(&Trigger{ID: "theirs", Namespaces: []string{theirs}}))
+	owned := 0
+	for i := 0; i < 100; i++ {
+		if objects.Owns(event(mine+".user.created", fmt.Sprintf("object-%d", i))) {
+			owned++
+		}
+	}
+	assert.True(t, owned > 0 && owned < 100, "objects of a namespace are spread over members")
+
+	// A filtered index skips the triggers of other partitions
+	store := &NATSStore{index: newNamespaceIndex()}
+	store.index.filter = a.Relevant
+	store.index.addTrigger(&Trigger{ID: "mine", Namespaces: []string{mine}})
+	store.index.addTrigger(&Trigger{ID: "theirs", Namespaces: []string{theirs}})
+	all, err := store.GetAllTriggers(context.Background())
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	assert.Equal(t, "mine", all[0].ID)
+}
+
+// TestPartitionerMembership tests members joining and leaving a group through NATS
+func TestPartitionerMembership(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	if _, err := js.AccountInfo(); err != nil {
+		t.Skip("JetStream not available, skipping integration test")
+	}
+
+	bucket := "partition-test-" + uuid.NewString()[:8]
+	defer js.DeleteKeyValue(bucket)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	start := func(id string) *Partitioner {
+		p, err := NewPartitioner(nc, PartitionConfig{Bucket: bucket, Partitions: 8, Heartbeat: 100 * time.Millisecond, InstanceID: id})
+		require.NoError(t, err)
+		require.NoError(t, p.Start(ctx))
+		return p
+	}
+
+	a := start("a")
+	changed := make(chan struct{}, 10)
+	a.OnChange(func() { changed <- struct{}{} })
+	assert.Len(t, a.Partitions(), 8, "a single member owns every partition")
+
+	b := start("b")
+	assert.Equal(t, []string{"a", "b"}, b.Members())
+	select {
+	case <-changed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("first member did not rebalance after a member joined")
+	}
+	assert.Equal(t, 8, len(a.Partitions())+len(b.Partitions()))
+
+	require.NoError(t, b.Close())
+	assert.Eventually(t, func() bool { return len(a.Partitions()) == 8 }, 2*time.Second, 50*time.Millisecond)
+}
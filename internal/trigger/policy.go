@@ -0,0 +1,199 @@
+package trigger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrPolicyViolation is returned when a trigger breaks the policy of the namespace it
+// is saved in; it wraps the ValidationErrors naming the violations
+var ErrPolicyViolation = errors.New("trigger violates namespace policy")
+
+// Policy holds the guardrails of a namespace, enforced when triggers are saved in it
+type Policy struct {
+	// DefaultTimeout is set as the action timeout of triggers saved without one, e.g. 30s
+	DefaultTimeout string `json:"default_timeout,omitempty" yaml:"default_timeout,omitempty"`
+	// RequiredLabels are the labels every trigger must set to a non-empty value
+	RequiredLabels []string `json:"required_labels,omitempty" yaml:"required_labels,omitempty"`
+	// AllowedActionTypes restricts actions to these types, see ActionType; empty allows all
+	AllowedActionTypes []string `json:"allowed_action_types,omitempty" yaml:"allowed_action_types,omitempty"`
+	// MaxTriggers is the most triggers the namespace may hold, 0 is unlimited
+	MaxTriggers int `json:"max_triggers,omitempty" yaml:"max_triggers,omitempty"`
+}
+
+// PolicySource looks up the policy of a namespace, nil when it has none
+type PolicySource interface {
+	TriggerPolicy(ctx context.Context, namespace string) (*Policy, error)
+}
+
+// LoadPolicy reads a namespace policy from a YAML file
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy: %w", err)
+	}
+	return ParsePolicy(data)
+}
+
+// ParsePolicy decodes a YAML namespace policy and checks its settings
+func ParsePolicy(data []byte) (*Policy, error) {
+	var p Policy
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %w", err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Validate checks the settings of a policy
+func (p *Policy) Validate() error {
+	if p.DefaultTimeout != "" {
+		if d, err := time.ParseDuration(p.DefaultTimeout); err != nil || d <= 0 {
+			return fmt.Errorf("invalid policy: default_timeout %q must be a positive duration", p.DefaultTimeout)
+		}
+	}
+	for _, label := range p.RequiredLabels {
+		if label == "" {
+			return fmt.Errorf("invalid policy: required label names must not be empty")
+		}
+	}
+	for _, actionType := range p.AllowedActionTypes {
+		if actionType == "" {
+			return fmt.Errorf("invalid policy: allowed action types must not be empty")
+		}
+	}
+	if p.MaxTriggers < 0 {
+		return fmt.Errorf("invalid policy: max_triggers must not be negative")
+	}
+	return nil
+}
+
+// Unrestricted reports whether the policy sets nothing
+func (p *Policy) Unrestricted() bool {
+	return p == nil || p.DefaultTimeout == "" && len(p.RequiredLabels) == 0 && len(p.AllowedActionTypes) == 0 && p.MaxTriggers == 0
+}
+
+// ActionType returns the type of an action: the part before the colon of prefixed
+// actions such as function:<name> and webhook:<url>, the action itself otherwise
+func ActionType(action string) string {
+	actionType, _, _ := strings.Cut(action, ":")
+	return actionType
+}
+
+// Apply sets the policy's defaults on a trigger and checks it against the policy.
+// Violations are returned as ValidationErrors wrapped in ErrPolicyViolation.
+func (p *Policy) Apply(t *Trigger) error {
+	if p == nil {
+		return nil
+	}
+	if t.Timeout == "" {
+		t.Timeout = p.DefaultTimeout
+	}
+
+	var errs ValidationErrors
+	for _, label := range p.RequiredLabels {
+		if t.Labels[label] == "" {
+			errs = append(errs, ValidationError{Field: "labels." + label, Message: "required by the namespace policy"})
+		}
+	}
+	if len(p.AllowedActionTypes) > 0 {
+		// Every action a branch may take is held to the policy like the trigger's own
+		type field struct{ name, action string }
+		var actions []field
+		if t.Action != "" || len(t.Branches) == 0 {
+			actions = append(actions, field{"action", t.Action})
+		}
+		for i, branch := range t.Branches {
+			actions = append(actions, field{fmt.Sprintf("branches[%d].action", i), branch.Action})
+		}
+
+		types := append([]string(nil), p.AllowedActionTypes...)
+		sort.Strings(types)
+		for _, f := range actions {
+			actionType := ActionType(f.action)
+			allowed := false
+			for _, a := range p.AllowedActionTypes {
+				allowed = allowed || a == actionType
+			}
+			if !allowed {
+				errs = append(errs, ValidationError{Field: f.name, Message: fmt.Sprintf("action type %q is not allowed, allowed types: %s", actionType, strings.Join(types, ", "))})
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrPolicyViolation, errs)
+	}
+	return nil
+}
+
+// SetPolicies makes the store enforce the policies of namespaces on saved triggers,
+// nil disables them. The defaults of a policy are set on the triggers passed to
+// SaveTrigger. MaxTriggers is checked before a trigger is created, so concurrent
+// creations may exceed it.
+func (s *NATSStore) SetPolicies(policies PolicySource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policies = policies
+}
+
+// enforcePolicy applies the policy of a namespace to a trigger about to be saved;
+// created is set when the trigger does not exist yet
+func (s *NATSStore) enforcePolicy(ctx context.Context, namespace string, t *Trigger, created bool) error {
+	s.mu.RLock()
+	policies := s.policies
+	s.mu.RUnlock()
+	if policies == nil {
+		return nil
+	}
+
+	policy, err := policies.TriggerPolicy(ctx, namespace)
+	if err != nil {
+		return fmt.Errorf("failed to get policy of namespace %s: %w", namespace, err)
+	}
+	if err := policy.Apply(t); err != nil {
+		return err
+	}
+	if policy == nil || policy.MaxTriggers == 0 || !created {
+		return nil
+	}
+	count, err := s.countTriggers(ctx, namespace)
+	if err != nil {
+		return err
+	}
+	if count >= policy.MaxTriggers {
+		return fmt.Errorf("%w: namespace %s already holds the maximum of %d triggers", ErrPolicyViolation, namespace, policy.MaxTriggers)
+	}
+	return nil
+}
+
+// countTriggers returns the number of triggers stored in a namespace
+func (s *NATSStore) countTriggers(ctx context.Context, namespace string) (int, error) {
+	watcher, err := s.kv.Watch(namespace+".*", nats.MetaOnly(), nats.IgnoreDeletes(), nats.Context(ctx))
+	if err != nil {
+		return 0, fmt.Errorf("failed to count triggers of namespace %s: %w", namespace, err)
+	}
+	defer watcher.Stop()
+
+	count := 0
+	for entry := range watcher.Updates() {
+		// A nil entry marks the end of the stored keys
+		if entry == nil {
+			return count, nil
+		}
+		count++
+	}
+	return 0, fmt.Errorf("failed to count triggers of namespace %s: %w", namespace, ctx.Err())
+}
@@ -0,0 +1,75 @@
+package trigger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := ParsePolicy([]byte(`
+default_timeout: 30s
+required_labels: [team]
+allowed_action_types: [function, webhook]
+max_triggers: 10
+`))
+	require.NoError(t, err)
+	assert.Equal(t, "30s", policy.DefaultTimeout)
+	assert.Equal(t, []string{"team"}, policy.RequiredLabels)
+	assert.Equal(t, 10, policy.MaxTriggers)
+	assert.False(t, policy.Unrestricted())
+	assert.True(t, (*Policy)(nil).Unrestricted())
+
+	for _, invalid := range []string{
+		"default_timeout: soon",
+		"default_timeout: -1s",
+		"max_triggers: -1",
+		"required_labels: ['']",
+		"max_trigers: 10",
+	} {
+		_, err := ParsePolicy([]byte(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestPolicyApply(t *testing.T) {
+	assert.Equal(t, "function", ActionType("function:resize"))
+	assert.Equal(t, "webhook", ActionType("webhook:https://example.com/hook"))
+	assert.Equal(t, "notify", ActionType("notify"))
+
+	policy := &Policy{
+		DefaultTimeout:     "30s",
+		RequiredLabels:     []string{"team", "owner"},
+		AllowedActionTypes: []string{"function"},
+	}
+
+	// Defaults fill in unset fields only
+	trig := &Trigger{ID: "resize", Action: "function:resize", Labels: map[string]string{"team": "media", "owner": "ana"}}
+	require.NoError(t, policy.Apply(trig))
+	assert.Equal(t, "30s", trig.Timeout)
+	trig.Timeout = "5s"
+	require.NoError(t, policy.Apply(trig))
+	assert.Equal(t, "5s", trig.Timeout)
+
+	err := policy.Apply(&Trigger{ID: "alert", Action: "notify", Labels: map[string]string{"team": "ops"}})
+	require.ErrorIs(t, err, ErrPolicyViolation)
+	var errs ValidationErrors
+	require.ErrorAs(t, err, &errs)
+	require.Len(t, errs, 2)
+	assert.Equal(t, "labels.owner", errs[0].Field)
+	assert.Equal(t, "action", errs[1].Field)
+
+	// The actions of branches are held to the policy, and a trigger taking only
+	// branch actions needs no action of its own
+	labels := map[string]string{"team": "ops", "owner": "ana"}
+	branches := []Branch{{When: "true", Action: "function:page"}, {When: "false", Action: "notify"}}
+	err = policy.Apply(&Trigger{ID: "alert", Labels: labels, Branches: branches})
+	require.ErrorAs(t, err, &errs)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "branches[1].action", errs[0].Field)
+	assert.NoError(t, policy.Apply(&Trigger{ID: "alert", Labels: labels, Branches: branches[:1]}))
+
+	var none *Policy
+	assert.NoError(t, none.Apply(&Trigger{ID: "any", Action: "notify"}))
+}
@@ -0,0 +1,211 @@
+package trigger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Index snapshot defaults
+const (
+	DefaultSnapshotBucket   = "trigger-index-snapshots"
+	DefaultSnapshotInterval = time.Minute
+	// DefaultSnapshotMaxAge is the age after which a snapshot is too stale to start from
+	DefaultSnapshotMaxAge = 24 * time.Hour
+)
+
+// SnapshotConfig configures the index snapshots of a NATSStore
+type SnapshotConfig struct {
+	// Bucket is the KV bucket snapshots are kept in (default: DefaultSnapshotBucket)
+	Bucket string
+	// Key names the snapshot, e.g. the trigger bucket, so instances indexing the same
+	// triggers share it (required)
+	Key string
+	// MaxAge is the age after which a snapshot is ignored (default: DefaultSnapshotMaxAge)
+	MaxAge time.Duration
+}
+
+// indexSnapshot is the persisted form of a store's index
+type indexSnapshot struct {
+	Time     time.Time  `json:"time"`
+	Triggers []*Trigger `json:"triggers"`
+}
+
+// IndexSnapshots persists the trigger index of a NATSStore, so a restarting store
+// matches events from the snapshot while it reloads the bucket
+type IndexSnapshots struct {
+	kv  nats.KeyValue
+	cfg SnapshotConfig
+	mu  sync.Mutex
+	// digest and saved are the hash and time of the last snapshot saved, to skip
+	// unchanged ones until they approach MaxAge
+	digest [sha256.Size]byte
+	saved  time.Time
+}
+
+// NewIndexSnapshots binds to the snapshot bucket, creating it if needed
+func NewIndexSnapshots(nc *nats.Conn, cfg SnapshotConfig) (*IndexSnapshots, error) {
+	if cfg.Key == "" {
+		return nil, fmt.Errorf("snapshot key cannot be empty")
+	}
+	if cfg.Bucket == "" {
+		cfg.Bucket = DefaultSnapshotBucket
+	}
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = DefaultSnapshotMaxAge
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get JetStream context: %w", err)
+	}
+	kv, err := js.KeyValue(cfg.Bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket:      cfg.Bucket,
+			Description: "Trigger index snapshots for warm starts",
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get snapshot bucket: %w", err)
+	}
+	return &IndexSnapshots{kv: kv, cfg: cfg}, nil
+}
+
+// load reads the snapshot, nil if there is none or it is older than MaxAge
+func (sn *IndexSnapshots) load() (*indexSnapshot, error) {
+	entry, err := sn.kv.Get(sn.cfg.Key)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get index snapshot: %w", err)
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(entry.Value()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress index snapshot: %w", err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress index snapshot: %w", err)
+	}
+	var snapshot indexSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal index snapshot: %w", err)
+	}
+	if time.Since(snapshot.Time) > sn.cfg.MaxAge {
+		return nil, nil
+	}
+	return &snapshot, nil
+}
+
+// save stores the triggers of an index unless they are unchanged since the last save
+func (sn *IndexSnapshots) save(triggers []*Trigger) error {
+	sort.Slice(triggers, func(i, j int) bool { return triggers[i].ID < triggers[j].ID })
+	data, err := json.Marshal(triggers)
+	if err != nil {
+		return fmt.Errorf("failed to marshal index snapshot: %w", err)
+	}
+	digest := sha256.Sum256(data)
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+	if digest == sn.digest && time.Since(sn.saved) < sn.cfg.MaxAge/2 {
+		return nil
+	}
+
+	data, err = json.Marshal(indexSnapshot{Time: time.Now().UTC(), Triggers: triggers})
+	if err != nil {
+		return fmt.Errorf("failed to marshal index snapshot: %w", err)
+	}
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(data); err != nil {
+		return fmt.Errorf("failed to compress index snapshot: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to compress index snapshot: %w", err)
+	}
+	if _, err := sn.kv.Put(sn.cfg.Key, compressed.Bytes()); err != nil {
+		return fmt.Errorf("failed to save index snapshot: %w", err)
+	}
+	sn.digest, sn.saved = digest, time.Now()
+	return nil
+}
+
+// WarmStart restores the index from the last snapshot and reports whether there was
+// one. The store then matches events right away; call Watch to reconcile the index
+// with the bucket in the background, which replaces the restored index once it has
+// caught up. Until then, triggers changed since the snapshot match as they were.
+func (s *NATSStore) WarmStart(ctx context.Context, snapshots *IndexSnapshots) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	snapshot, err := snapshots.load()
+	if err != nil || snapshot == nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.following {
+		// The bucket is already indexed
+		return false, nil
+	}
+	index := newNamespaceIndex()
+	index.filter = s.filter
+	for _, trigger := range snapshot.Triggers {
+		index.addTrigger(trigger)
+	}
+	s.index = index
+	log.Printf("Restored %d triggers from the index snapshot of %s", len(index.triggers), snapshot.Time.Format(time.RFC3339))
+	return true, nil
+}
+
+// SaveSnapshot saves the current index to the snapshot bucket. Stores that have not
+// caught up with the bucket yet are not saved, so a warm-started index is not persisted
+// again before it was reconciled.
+func (s *NATSStore) SaveSnapshot(snapshots *IndexSnapshots) error {
+	s.mu.RLock()
+	if !s.following {
+		s.mu.RUnlock()
+		return nil
+	}
+	triggers := make([]*Trigger, 0, len(s.index.triggers))
+	for _, trigger := range s.index.triggers {
+		triggers = append(triggers, trigger)
+	}
+	s.mu.RUnlock()
+	return snapshots.save(triggers)
+}
+
+// RunSnapshots saves the index at an interval until ctx is cancelled
+func (s *NATSStore) RunSnapshots(ctx context.Context, snapshots *IndexSnapshots, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultSnapshotInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.SaveSnapshot(snapshots); err != nil {
+				log.Printf("Error saving trigger index snapshot: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package trigger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestIndexSnapshotWarmStart tests restoring the index from a snapshot and reconciling
+// it with changes made to the bucket after the snapshot
+func TestIndexSnapshotWarmStart(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	if _, err := js.AccountInfo(); err != nil {
+		t.Skip("JetStream not available, skipping integration test")
+	}
+
+	suffix := uuid.NewString()[:8]
+	bucket := "snapshot-test-" + suffix
+	snapshotBucket := "snapshot-test-index-" + suffix
+	defer js.DeleteKeyValue(bucket)
+	defer js.DeleteKeyValue(snapshotBucket)
+
+	ctx := context.Background()
+	store, err := NewNATSStore(nc, bucket)
+	require.NoError(t, err)
+	store.SetLifecycleSubject("")
+	for _, id := range []string{"kept", "deleted"} {
+		trig := &Trigger{ID: id, Enabled: true, Namespaces: []string{"default"}, Criteria: "true", Action: "log"}
+		require.NoError(t, store.SaveTrigger(ctx, "default", id, trig))
+	}
+
+	snapshots, err := NewIndexSnapshots(nc, SnapshotConfig{Bucket: snapshotBucket, Key: bucket})
+	require.NoError(t, err)
+
+	// Stores that are not following the bucket are not saved
+	require.NoError(t, store.SaveSnapshot(snapshots))
+	restarted, err := NewNATSStore(nc, bucket)
+	require.NoError(t, err)
+	restored, err := restarted.WarmStart(ctx, snapshots)
+	require.NoError(t, err)
+	assert.False(t, restored)
+	restarted.Close()
+
+	require.NoError(t, store.Watch(ctx))
+	require.NoError(t, store.SaveSnapshot(snapshots))
+	require.NoError(t, store.DeleteTrigger(ctx, "default", "deleted"))
+	store.Close()
+
+	// A restarted store matches with the snapshot before loading the bucket
+	restarted, err = NewNATSStore(nc, bucket)
+	require.NoError(t, err)
+	defer restarted.Close()
+	restored, err = restarted.WarmStart(ctx, snapshots)
+	require.NoError(t, err)
+	require.True(t, restored)
+	triggers, err := restarted.GetTriggers(ctx, "default")
+	require.NoError(t, err)
+	assert.Len(t, triggers, 2)
+
+	// Reconciling drops the trigger deleted after the snapshot
+	require.NoError(t, restarted.Watch(ctx))
+	triggers, err = restarted.GetTriggers(ctx, "default")
+	require.NoError(t, err)
+	require.Len(t, triggers, 1)
+	assert.Equal(t, "kept", triggers[0].ID)
+}
@@ -0,0 +1,339 @@
+package trigger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultTemplateBucket is the KV bucket trigger templates are stored in
+const DefaultTemplateBucket = "trigger-templates"
+
+// NamespaceParam is the parameter every template has, set to the namespace a
+// template is instantiated for
+const NamespaceParam = "namespace"
+
+// ErrTemplateNotFound is returned when a template does not exist
+var ErrTemplateNotFound = errors.New("template not found")
+
+var (
+	// placeholderPattern matches {{param}} placeholders, allowing spaces inside the braces
+	placeholderPattern = regexp.MustCompile(`\{\{\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*\}\}`)
+	paramNamePattern   = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	templateIDPattern  = regexp.MustCompile(`^[-_=.a-zA-Z0-9]+$`)
+)
+
+// TemplateParam is a parameter of a trigger template
+type TemplateParam struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	// Default is used when the parameter is not set; parameters without a default are required
+	Default *string `json:"default,omitempty" yaml:"default,omitempty"`
+}
+
+// TriggerTemplate defines a standard policy once, to be stamped out as concrete
+// triggers. The string fields and vars of its trigger may contain {{param}}
+// placeholders, which are replaced by the values given at instantiation.
+type TriggerTemplate struct {
+	ID          string          `json:"id" yaml:"id"`
+	Name        string          `json:"name,omitempty" yaml:"name,omitempty"`
+	Description string          `json:"description,omitempty" yaml:"description,omitempty"`
+	Params      []TemplateParam `json:"params,omitempty" yaml:"params,omitempty"`
+	Trigger     Trigger         `json:"trigger" yaml:"trigger"`
+}
+
+// ParseTemplateYAML decodes a YAML template definition and validates it
+func ParseTemplateYAML(data []byte) (*TriggerTemplate, error) {
+	var tmpl TriggerTemplate
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&tmpl); err != nil {
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+	if err := tmpl.Validate(); err != nil {
+		return nil, err
+	}
+	return &tmpl, nil
+}
+
+// Validate checks the template's ID and parameters and that every placeholder
+// refers to a declared parameter
+func (t *TriggerTemplate) Validate() error {
+	if !templateIDPattern.MatchString(t.ID) {
+		return fmt.Errorf("invalid template id %q", t.ID)
+	}
+
+	declared := map[string]bool{NamespaceParam: true}
+	for _, param := range t.Params {
+		if !paramNamePattern.MatchString(param.Name) {
+			return fmt.Errorf("invalid parameter name %q", param.Name)
+		}
+		if declared[param.Name] {
+			return fmt.Errorf("parameter %s is declared twice", param.Name)
+		}
+		declared[param.Name] = true
+	}
+
+	var undeclared []string
+	for _, name := range t.placeholders() {
+		if !declared[name] {
+			undeclared = append(undeclared, name)
+		}
+	}
+	if len(undeclared) > 0 {
+		return fmt.Errorf("placeholders refer to undeclared parameters: %s", strings.Join(undeclared, ", "))
+	}
+	return nil
+}
+
+// placeholders returns the sorted names of the parameters the template's trigger refers to
+func (t *TriggerTemplate) placeholders() []string {
+	seen := make(map[string]bool)
+	t.Trigger.clone().forEachString(func(s string) string {
+		for _, match := range placeholderPattern.FindAllStringSubmatch(s, -1) {
+			seen[match[1]] = true
+		}
+		return s
+	})
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Instantiate stamps out a trigger for a namespace. Parameters not set fall back
+// to their defaults; unknown or missing parameters are errors. A non-empty
+// namespace replaces the template's namespaces, and a template trigger without an
+// ID or name is given <template-id>-<namespace> and the template's name. The
+// trigger is validated against the trigger schema.
+func (t *TriggerTemplate) Instantiate(params map[string]string, namespace string) (*Trigger, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{NamespaceParam: namespace}
+	declared := make(map[string]bool)
+	var missing []string
+	for _, param := range t.Params {
+		declared[param.Name] = true
+		if value, ok := params[param.Name]; ok {
+			values[param.Name] = value
+		} else if param.Default != nil {
+			values[param.Name] = *param.Default
+		} else {
+			missing = append(missing, param.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("template %s requires parameters: %s", t.ID, strings.Join(missing, ", "))
+	}
+	for name := range params {
+		if !declared[name] {
+			return nil, fmt.Errorf("template %s has no parameter %s", t.ID, name)
+		}
+	}
+
+	trigger := t.Trigger.clone()
+	if namespace != "" {
+		trigger.Namespaces = []string{namespace}
+	}
+	if trigger.Name == "" {
+		trigger.Name = t.Name
+	}
+	if trigger.ID == "" {
+		trigger.ID = t.ID
+		if namespace != "" {
+			trigger.ID += "-" + strings.ReplaceAll(namespace, NamespaceSeparator, "-")
+		}
+	}
+
+	var unresolved []string
+	trigger.forEachString(func(s string) string {
+		return placeholderPattern.ReplaceAllStringFunc(s, func(placeholder string) string {
+			name := placeholderPattern.FindStringSubmatch(placeholder)[1]
+			if name == NamespaceParam && namespace == "" {
+				unresolved = append(unresolved, name)
+			}
+			return values[name]
+		})
+	})
+	if len(unresolved) > 0 {
+		return nil, fmt.Errorf("template %s refers to {{namespace}}, instantiate it for a namespace", t.ID)
+	}
+
+	// A var that is a single placeholder takes the type of its value, e.g. 90 is a number
+	for name, value := range t.Trigger.Vars {
+		s, ok := value.(string)
+		if !ok {
+			continue
+		}
+		if match := placeholderPattern.FindStringSubmatchIndex(s); match != nil && match[0] == 0 && match[1] == len(s) {
+			var typed interface{}
+			if err := yaml.Unmarshal([]byte(trigger.Vars[name].(string)), &typed); err == nil && typed != nil {
+				trigger.Vars[name] = typed
+			}
+		}
+	}
+
+	if err := trigger.Validate(); err != nil {
+		return nil, fmt.Errorf("template %s produces an invalid trigger: %w", t.ID, err)
+	}
+	return trigger, nil
+}
+
+// clone returns a copy of the trigger that shares no slices or maps with it
+func (t *Trigger) clone() *Trigger {
+	c := *t
+	c.Namespaces = append([]string(nil), t.Namespaces...)
+	c.Branches = append([]Branch(nil), t.Branches...)
+	if t.Vars != nil {
+		c.Vars = make(map[string]interface{}, len(t.Vars))
+		for name, value := range t.Vars {
+			c.Vars[name] = value
+		}
+	}
+	if t.Labels != nil {
+		c.Labels = make(map[string]string, len(t.Labels))
+		for name, value := range t.Labels {
+			c.Labels[name] = value
+		}
+	}
+	return &c
+}
+
+// forEachString replaces every string field, namespace, branch, string var and label
+// value of the trigger with the result of fn
+func (t *Trigger) forEachString(fn func(string) string) {
+	for _, field := range []*string{&t.ID, &t.Name, &t.ObjectType, &t.EventType, &t.Criteria, &t.Except, &t.Description, &t.Action} {
+		*field = fn(*field)
+	}
+	for i := range t.Namespaces {
+		t.Namespaces[i] = fn(t.Namespaces[i])
+	}
+	for i := range t.Branches {
+		t.Branches[i].When = fn(t.Branches[i].When)
+		t.Branches[i].Action = fn(t.Branches[i].Action)
+	}
+	for name, value := range t.Vars {
+		if s, ok := value.(string); ok {
+			t.Vars[name] = fn(s)
+		}
+	}
+	for name, value := range t.Labels {
+		t.Labels[name] = fn(value)
+	}
+}
+
+// TemplateStore stores trigger templates in a NATS KV bucket, keyed by template ID
+type TemplateStore struct {
+	kv nats.KeyValue
+}
+
+// NewTemplateStore creates a template store, creating the bucket if needed
+func NewTemplateStore(nc *nats.Conn, bucket string) (*TemplateStore, error) {
+	if bucket == "" {
+		bucket = DefaultTemplateBucket
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get JetStream context: %w", err)
+	}
+	kv, err := js.KeyValue(bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket:      bucket,
+			Description: "Trigger templates",
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get template bucket: %w", err)
+	}
+	return &TemplateStore{kv: kv}, nil
+}
+
+// SaveTemplate validates and stores a template, replacing any with the same ID
+func (s *TemplateStore) SaveTemplate(ctx context.Context, tmpl *TriggerTemplate) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := tmpl.Validate(); err != nil {
+		return fmt.Errorf("invalid template: %w", err)
+	}
+	data, err := json.Marshal(tmpl)
+	if err != nil {
+		return fmt.Errorf("failed to marshal template: %w", err)
+	}
+	if _, err := s.kv.Put(tmpl.ID, data); err != nil {
+		return fmt.Errorf("failed to save template: %w", err)
+	}
+	return nil
+}
+
+// GetTemplate returns a template, or ErrTemplateNotFound
+func (s *TemplateStore) GetTemplate(ctx context.Context, id string) (*TriggerTemplate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	entry, err := s.kv.Get(id)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get template: %w", err)
+	}
+	var tmpl TriggerTemplate
+	if err := json.Unmarshal(entry.Value(), &tmpl); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal template %s: %w", id, err)
+	}
+	return &tmpl, nil
+}
+
+// ListTemplates returns every template ordered by ID
+func (s *TemplateStore) ListTemplates(ctx context.Context) ([]*TriggerTemplate, error) {
+	keys, err := s.kv.Keys(nats.Context(ctx))
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", err)
+	}
+	sort.Strings(keys)
+
+	templates := make([]*TriggerTemplate, 0, len(keys))
+	for _, key := range keys {
+		tmpl, err := s.GetTemplate(ctx, key)
+		if errors.Is(err, ErrTemplateNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, tmpl)
+	}
+	return templates, nil
+}
+
+// DeleteTemplate removes a template. Triggers instantiated from it are kept.
+func (s *TemplateStore) DeleteTemplate(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := s.kv.Get(id); errors.Is(err, nats.ErrKeyNotFound) {
+		return fmt.Errorf("%w: %s", ErrTemplateNotFound, id)
+	}
+	if err := s.kv.Delete(id); err != nil {
+		return fmt.Errorf("failed to delete template: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package trigger
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const resourceAlertTemplate = `
+id: resource-alert
+name: Resource usage alert
+params:
+  - name: threshold
+    default: 90
+  - name: action
+    description: Action notified of high usage
+trigger:
+  name: High usage in {{namespace}}
+  object_type: Resource
+  event_type: resource.updated
+  criteria: event.data.after.usage > vars.threshold
+  vars:
+    threshold: "{{ threshold }}"
+    label: "above {{threshold}}%"
+  enabled: true
+  action: "{{action}}"
+`
+
+// TestTemplateInstantiate tests stamping out triggers from a template
+func TestTemplateInstantiate(t *testing.T) {
+	tmpl, err := ParseTemplateYAML([]byte(resourceAlertTemplate))
+	require.NoError(t, err)
+
+	trigger, err := tmpl.Instantiate(map[string]string{"action": "pager"}, "payments/prod")
+	require.NoError(t, err)
+	assert.Equal(t, "resource-alert-payments-prod", trigger.ID)
+	assert.Equal(t, "High usage in payments/prod", trigger.Name)
+	assert.Equal(t, []string{"payments/prod"}, trigger.Namespaces)
+	assert.Equal(t, "pager", trigger.Action)
+	assert.Equal(t, 90, trigger.Vars["threshold"], "single placeholders keep the type of their value")
+	assert.Equal(t, "above 90%", trigger.Vars["label"])
+
+	trigger, err = tmpl.Instantiate(map[string]string{"action": "pager", "threshold": "75.5"}, "staging")
+	require.NoError(t, err)
+	assert.Equal(t, 75.5, trigger.Vars["threshold"])
+	assert.Equal(t, "{{ threshold }}", tmpl.Trigger.Vars["threshold"], "the template is not modified")
+
+	_, err = tmpl.Instantiate(nil, "prod")
+	assert.ErrorContains(t, err, "requires parameters: action")
+	_, err = tmpl.Instantiate(map[string]string{"action": "pager", "treshold": "1"}, "prod")
+	assert.ErrorContains(t, err, "has no parameter treshold")
+	_, err = tmpl.Instantiate(map[string]string{"action": "pager"}, "")
+	assert.ErrorContains(t, err, "instantiate it for a namespace")
+	_, err = tmpl.Instantiate(map[string]string{"action": "pager"}, "bad ns")
+	var errs ValidationErrors
+	assert.True(t, errors.As(err, &errs), "instances are validated against the trigger schema")
+
+	_, err = ParseTemplateYAML([]byte("id: t\ntrigger:\n  criteria: vars.x > {{limit}}\n"))
+	assert.ErrorContains(t, err, "undeclared parameters: limit")
+	_, err = ParseTemplateYAML([]byte("id: t\ntrigger:\n  critera: true\n"))
+	assert.ErrorContains(t, err, "critera")
+}
+
+// TestTemplateStore tests storing templates in NATS
+func TestTemplateStore(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	if _, err := js.AccountInfo(); err != nil {
+		t.Skip("JetStream not available, skipping integration test")
+	}
+
+	bucket := "template-test-" + uuid.NewString()[:8]
+	defer js.DeleteKeyValue(bucket)
+	store, err := NewTemplateStore(nc, bucket)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	tmpl, err := ParseTemplateYAML([]byte(resourceAlertTemplate))
+	require.NoError(t, err)
+	require.NoError(t, store.SaveTemplate(ctx, tmpl))
+	require.NoError(t, store.SaveTemplate(ctx, &TriggerTemplate{ID: "audit", Trigger: Trigger{Action: "audit"}}))
+	assert.Error(t, store.SaveTemplate(ctx, &TriggerTemplate{ID: "bad", Trigger: Trigger{Action: "{{missing}}"}}))
+
+	got, err := store.GetTemplate(ctx, "resource-alert")
+	require.NoError(t, err)
+	assert.Equal(t, tmpl, got)
+
+	templates, err := store.ListTemplates(ctx)
+	require.NoError(t, err)
+	require.Len(t, templates, 2)
+	assert.Equal(t, "audit", templates[0].ID)
+
+	require.NoError(t, store.DeleteTemplate(ctx, "audit"))
+	_, err = store.GetTemplate(ctx, "audit")
+	assert.ErrorIs(t, err, ErrTemplateNotFound)
+	assert.ErrorIs(t, store.DeleteTemplate(ctx, "audit"), ErrTemplateNotFound)
+}
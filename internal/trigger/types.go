@@ -2,6 +2,8 @@ package trigger
 
 import (
 	"context"
+	"fmt"
+	"time"
 	
 	"gopkg.in/yaml.v3"
 )
@@ -16,9 +18,38 @@ type Trigger struct {
 	// It uses the expr language (https://github.com/expr-lang/expr) and must evaluate to a boolean.
 	// Example: event.event_type == "user.created" && event.payload.after.role == "admin"
 	Criteria    string `json:"criteria" yaml:"criteria"`
+	// Dialect is the language of the criteria and except: expr (default) or cesql, the
+	// CloudEvents SQL expression language, so filters written for other CloudEvents
+	// systems can be reused. Example: type LIKE 'com.acme.%' AND EXISTS tenant
+	Dialect     string `json:"dialect,omitempty" yaml:"dialect,omitempty"`
 	Description string `json:"description,omitempty" yaml:"description,omitempty"`
 	Enabled     bool   `json:"enabled" yaml:"enabled"`
 	Action      string `json:"action" yaml:"action"`
+	// Vars are constants available to the criteria as vars.<name>, so triggers sharing
+	// a criteria template can each set their own thresholds.
+	// Example: event.data.after.usage > vars.threshold
+	Vars map[string]interface{} `json:"vars,omitempty" yaml:"vars,omitempty"`
+	// Except is an expression evaluated like criteria that suppresses the trigger for
+	// the events it is true for, even when they satisfy the criteria.
+	// Example: event.data.after.labels.maintenance == "true"
+	Except string `json:"except,omitempty" yaml:"except,omitempty"`
+	// IgnoreReplays stops the trigger from matching events republished by a replay
+	IgnoreReplays bool `json:"ignore_replays,omitempty" yaml:"ignore_replays,omitempty"`
+	// Window makes the trigger fire only when enough matching events occur within a
+	// sliding window, e.g. 5 failed logins of the same user in 10 minutes
+	Window *Window `json:"window,omitempty" yaml:"window,omitempty"`
+	// Labels are metadata of the trigger, e.g. the owning team; namespace policies can
+	// require them
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	// Timeout bounds the execution of the trigger's action, e.g. 30s; empty leaves it
+	// to the executor's own timeout
+	Timeout string `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	// Concurrency limits how many executions of the action run at once, globally or
+	// per object
+	Concurrency *Concurrency `json:"concurrency,omitempty" yaml:"concurrency,omitempty"`
+	// Branches pick the action per matched event: the action of the first branch whose
+	// condition holds is taken, and Action when none does
+	Branches []Branch `json:"branches,omitempty" yaml:"branches,omitempty"`
 }
 
 // ToYAML marshals the trigger to YAML
@@ -31,19 +62,52 @@ func (t *Trigger) FromYAML(data []byte) error {
 	return yaml.Unmarshal(data, t)
 }
 
-// TriggerStore defines the interface for a trigger store
+// ActionTimeout returns the timeout of the trigger's action, 0 when it has none
+func (t *Trigger) ActionTimeout() (time.Duration, error) {
+	if t.Timeout == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(t.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout %q: %w", t.Timeout, err)
+	}
+	return d, nil
+}
+
+// ListOptions controls paginated trigger listing
+type ListOptions struct {
+	After  string // Cursor: only triggers whose ID sorts after it are listed, e.g. the last ID of the previous page
+	Offset int    // Number of triggers to skip
+	Limit  int    // Maximum number of triggers to return, 0 means no limit
+}
+
+// TriggerStore defines the interface for a trigger store.
+// Every call takes a context and returns its error once the context is done.
+// Stores do not own the connection they are created with: Close releases the
+// store's own resources, such as its watch, and leaves the connection open.
 type TriggerStore interface {
 	// LoadAll loads all triggers from the store
 	LoadAll(ctx context.Context) error
 
-	// Watch starts watching for changes to triggers
-	Watch(ctx context.Context)
+	// Watch starts watching for changes to triggers. It returns once the watch is
+	// established, or with an error if it cannot be started or ctx is cancelled first;
+	// changes are applied in the background until ctx is cancelled or the store is closed.
+	Watch(ctx context.Context) error
 
 	// GetTriggers returns all triggers for a namespace
-	GetTriggers(namespace string) []*Trigger
+	GetTriggers(ctx context.Context, namespace string) ([]*Trigger, error)
+
+	// GetTriggersForEvent returns the triggers for a namespace whose event type matches or is empty
+	GetTriggersForEvent(ctx context.Context, namespace, eventType string) ([]*Trigger, error)
 
 	// GetAllTriggers returns all triggers from all namespaces
-	GetAllTriggers() []*Trigger
+	GetAllTriggers(ctx context.Context) ([]*Trigger, error)
+
+	// ListTriggers returns a page of triggers ordered by ID along with the total trigger count
+	ListTriggers(ctx context.Context, opts ListOptions) ([]*Trigger, int, error)
+
+	// ForEachTrigger calls fn for every trigger ordered by ID until fn returns false
+	ForEachTrigger(ctx context.Context, fn func(*Trigger) bool) error
 
 	// SaveTrigger saves a trigger to the store
 	SaveTrigger(ctx context.Context, namespace, name string, trigger *Trigger) error
@@ -51,6 +115,6 @@ type TriggerStore interface {
 	// DeleteTrigger deletes a trigger from the store
 	DeleteTrigger(ctx context.Context, namespace, name string) error
 
-	// Close closes the store
+	// Close stops the store's background work; it does not close the connection
 	Close() error
 }
@@ -0,0 +1,297 @@
+package trigger
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/expr-lang/expr/parser"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed trigger.schema.json
+var schemaJSON []byte
+
+// triggerSchema is the parsed trigger definition schema
+var triggerSchema = mustParseSchema(schemaJSON)
+
+// Schema returns the JSON Schema for trigger definitions
+func Schema() []byte {
+	return schemaJSON
+}
+
+// schema is the subset of JSON Schema used to describe trigger definitions
+type schema struct {
+	Type                 string             `json:"type"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Items                *schema            `json:"items"`
+	MinLength            *int               `json:"minLength"`
+	Pattern              string             `json:"pattern"`
+
+	pattern *regexp.Regexp
+}
+
+func mustParseSchema(data []byte) *schema {
+	var s schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		panic(fmt.Sprintf("invalid trigger schema: %v", err))
+	}
+	s.compile()
+	return &s
+}
+
+// compile precompiles the patterns of the schema and its subschemas
+func (s *schema) compile() {
+	if s.Pattern != "" {
+		s.pattern = regexp.MustCompile(s.Pattern)
+	}
+	for _, prop := range s.Properties {
+		prop.compile()
+	}
+	if s.Items != nil {
+		s.Items.compile()
+	}
+}
+
+// ValidationError describes a single schema violation in a trigger definition
+type ValidationError struct {
+	Line    int    // Line in the YAML source, 0 when unknown
+	Column  int    // Column in the YAML source, 0 when unknown
+	Field   string // Path of the offending field, e.g. namespaces[1]
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	location := e.Field
+	if location == "" {
+		location = "trigger"
+	}
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", e.Line, location, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", location, e.Message)
+}
+
+// ValidationErrors is the list of schema violations found in a trigger definition
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// ValidateYAML validates a YAML trigger definition against the trigger schema.
+// The returned ValidationErrors carry the line of each violation.
+func ValidateYAML(data []byte) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse YAML: %w", err)
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return ValidationErrors{{Message: "empty trigger definition"}}
+	}
+
+	var errs ValidationErrors
+	triggerSchema.validate(doc.Content[0], "", &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// ParseYAML validates a YAML trigger definition and decodes it
+func ParseYAML(data []byte) (*Trigger, error) {
+	if err := ValidateYAML(data); err != nil {
+		return nil, err
+	}
+
+	var t Trigger
+	if err := t.FromYAML(data); err != nil {
+		return nil, fmt.Errorf("failed to decode trigger: %w", err)
+	}
+	if errs := t.validateFields(); len(errs) > 0 {
+		return nil, errs
+	}
+	return &t, nil
+}
+
+// Validate checks the trigger against the trigger schema
+func (t *Trigger) Validate() error {
+	data, err := t.ToYAML()
+	if err != nil {
+		return fmt.Errorf("failed to marshal trigger: %w", err)
+	}
+
+	err = ValidateYAML(data)
+	if errs, ok := err.(ValidationErrors); ok {
+		// Line numbers refer to the generated YAML, not to anything the caller wrote
+		for i := range errs {
+			errs[i].Line, errs[i].Column = 0, 0
+		}
+		return errs
+	}
+	if err != nil {
+		return err
+	}
+	if errs := t.validateFields(); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateFields checks the fields of a trigger beyond what the schema can express
+func (t *Trigger) validateFields() ValidationErrors {
+	errs := t.validateDialect()
+	if t.Except != "" && t.Dialect != DialectCESQL {
+		if _, err := parser.Parse(t.Except); err != nil {
+			errs = append(errs, ValidationError{Field: "except", Message: fmt.Sprintf("invalid expression: %v", err)})
+		}
+	}
+	errs = append(errs, t.validateBranches()...)
+	if t.Window != nil {
+		errs = append(errs, t.Window.validate()...)
+	}
+	if t.Concurrency != nil {
+		errs = append(errs, t.Concurrency.validate()...)
+	}
+	if d, err := t.ActionTimeout(); err != nil {
+		errs = append(errs, ValidationError{Field: "timeout", Message: err.Error()})
+	} else if t.Timeout != "" && d <= 0 {
+		errs = append(errs, ValidationError{Field: "timeout", Message: "must be positive"})
+	}
+	return errs
+}
+
+// validate checks a YAML node against the schema, appending violations to errs
+func (s *schema) validate(node *yaml.Node, path string, errs *ValidationErrors) {
+	fail := func(n *yaml.Node, field, format string, args ...interface{}) {
+		*errs = append(*errs, ValidationError{
+			Line:    n.Line,
+			Column:  n.Column,
+			Field:   field,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	if actual := yamlType(node); s.Type != "" && actual != s.Type {
+		fail(node, path, "expected %s, got %s", s.Type, actual)
+		return
+	}
+
+	switch s.Type {
+	case "object":
+		present := make(map[string]bool)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			present[key.Value] = true
+
+			prop, known := s.Properties[key.Value]
+			if !known {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					fail(key, joinPath(path, key.Value), "unknown field%s", s.suggest(key.Value))
+				}
+				continue
+			}
+			prop.validate(value, joinPath(path, key.Value), errs)
+		}
+		for _, name := range s.Required {
+			if !present[name] {
+				fail(node, joinPath(path, name), "required field is missing")
+			}
+		}
+
+	case "array":
+		if s.Items != nil {
+			for i, item := range node.Content {
+				s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+
+	case "string":
+		if s.MinLength != nil && len(node.Value) < *s.MinLength {
+			fail(node, path, "must not be empty")
+		} else if s.pattern != nil && !s.pattern.MatchString(node.Value) {
+			fail(node, path, "%q does not match pattern %s", node.Value, s.Pattern)
+		}
+	}
+}
+
+// suggest returns a hint naming the closest known property to an unknown field
+func (s *schema) suggest(field string) string {
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	best, bestDistance := "", 3
+	for _, name := range names {
+		if d := editDistance(field, name); d < bestDistance {
+			best, bestDistance = name, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %q?)", best)
+}
+
+// yamlType maps a YAML node to the JSON Schema type name it represents
+func yamlType(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "array"
+	case yaml.AliasNode:
+		return yamlType(node.Alias)
+	case yaml.ScalarNode:
+		switch node.Tag {
+		case "!!str":
+			return "string"
+		case "!!bool":
+			return "boolean"
+		case "!!int", "!!float":
+			return "number"
+		case "!!null":
+			return "null"
+		}
+	}
+	return "unknown"
+}
+
+func joinPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
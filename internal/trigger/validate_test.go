@@ -0,0 +1,54 @@
+package trigger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestValidateYAML tests schema validation errors and their line numbers
+func TestValidateYAML(t *testing.T) {
+	valid := []byte(`id: config-update
+name: Config Update Notification
+namespaces: ["default", "prod.*"]
+criteria: event.data.after.critical == true
+enabled: true
+action: notify
+`)
+	require.NoError(t, ValidateYAML(valid))
+
+	invalid := []byte(`name: Typo
+critera: event.data.after.critical == true
+namespaces: ["prod", "bad ns"]
+enabled: "yes"
+`)
+	err := ValidateYAML(invalid)
+	require.Error(t, err)
+
+	errs, ok := err.(ValidationErrors)
+	require.True(t, ok)
+	require.Len(t, errs, 4)
+
+	assert.Equal(t, 2, errs[0].Line)
+	assert.Equal(t, "critera", errs[0].Field)
+	assert.Contains(t, errs[0].Message, `did you mean "criteria"?`)
+
+	assert.Equal(t, 3, errs[1].Line)
+	assert.Equal(t, "namespaces[1]", errs[1].Field)
+
+	assert.Equal(t, 4, errs[2].Line)
+	assert.Equal(t, "enabled", errs[2].Field)
+
+	assert.Equal(t, "id", errs[3].Field)
+	assert.Contains(t, errs[3].Message, "required")
+}
+
+// TestTriggerValidate tests validation of triggers built in code
+func TestTriggerValidate(t *testing.T) {
+	assert.NoError(t, (&Trigger{ID: "ok", Namespaces: []string{"*"}}).Validate())
+
+	err := (&Trigger{ID: ""}).Validate()
+	require.Error(t, err)
+	assert.Equal(t, "id: must not be empty", err.Error())
+}
@@ -0,0 +1,279 @@
+package trigger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/parser"
+	"github.com/nats-io/nats.go"
+)
+
+// DefaultWindowBucket is the KV bucket aggregation windows are kept in
+const DefaultWindowBucket = "trigger-windows"
+
+// MaxWindow is the longest aggregation window. Window state expires from the bucket
+// this long after its last event, so longer windows would lose events.
+const MaxWindow = 24 * time.Hour
+
+// maxWindowRetries bounds the compare-and-swap attempts of an observation
+const maxWindowRetries = 10
+
+// Window makes a trigger an aggregation: it fires only when Count matching events
+// occur within a sliding window, then starts counting again
+type Window struct {
+	// Count is the number of matching events that fires the trigger
+	Count int `json:"count" yaml:"count"`
+	// Within is the length of the window, e.g. 10m
+	Within string `json:"within" yaml:"within"`
+	// GroupBy is an expression evaluated like criteria whose result keeps a window per
+	// value, e.g. event.actor.id; empty counts all matching events together
+	GroupBy string `json:"group_by,omitempty" yaml:"group_by,omitempty"`
+}
+
+// Duration returns the length of the window
+func (w *Window) Duration() (time.Duration, error) {
+	d, err := time.ParseDuration(w.Within)
+	if err != nil {
+		return 0, fmt.Errorf("invalid window duration %q: %w", w.Within, err)
+	}
+	return d, nil
+}
+
+// validate checks the window parameters beyond what the schema can express
+func (w *Window) validate() ValidationErrors {
+	var errs ValidationErrors
+	if w.Count < 1 {
+		errs = append(errs, ValidationError{Field: "window.count", Message: "must be at least 1"})
+	}
+	if d, err := w.Duration(); err != nil {
+		errs = append(errs, ValidationError{Field: "window.within", Message: err.Error()})
+	} else if d <= 0 || d > MaxWindow {
+		errs = append(errs, ValidationError{Field: "window.within", Message: fmt.Sprintf("must be positive and at most %s", MaxWindow)})
+	}
+	if w.GroupBy != "" {
+		if _, err := parser.Parse(w.GroupBy); err != nil {
+			errs = append(errs, ValidationError{Field: "window.group_by", Message: fmt.Sprintf("invalid expression: %v", err)})
+		}
+	}
+	return errs
+}
+
+// windowState is the events counted in a window
+type windowState struct {
+	Events []windowEvent `json:"events"`
+}
+
+type windowEvent struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+}
+
+// observe adds an event to the window and reports whether it completes the window,
+// in which case the window is emptied. Events older than the window, relative to the
+// newest event, are dropped; a redelivered event is not counted twice.
+func (s *windowState) observe(w *Window, within time.Duration, id string, at time.Time) bool {
+	for _, e := range s.Events {
+		if e.ID == id {
+			return false
+		}
+	}
+
+	newest := at
+	for _, e := range s.Events {
+		if e.Time.After(newest) {
+			newest = e.Time
+		}
+	}
+	cutoff := newest.Add(-within)
+	kept := s.Events[:0]
+	for _, e := range s.Events {
+		if e.Time.After(cutoff) {
+			kept = append(kept, e)
+		}
+	}
+	s.Events = kept
+	if at.After(cutoff) {
+		s.Events = append(s.Events, windowEvent{ID: id, Time: at})
+	}
+	sort.Slice(s.Events, func(i, j int) bool { return s.Events[i].Time.Before(s.Events[j].Time) })
+
+	if len(s.Events) >= w.Count {
+		s.Events = nil
+		return true
+	}
+	return false
+}
+
+// Aggregator keeps the windows of aggregation triggers and decides when they fire.
+// Windows live in a KV bucket shared by every daemon, or in memory without JetStream.
+type Aggregator struct {
+	kv  nats.KeyValue
+	now func() time.Time
+
+	mu sync.Mutex
+	// local holds the windows of in-memory aggregators
+	local map[string]*localWindow
+}
+
+// localWindow is an in-memory window and when it was last updated, for expiry
+type localWindow struct {
+	state   windowState
+	updated time.Time
+}
+
+// NewAggregator creates an aggregator keeping windows in a KV bucket, creating it if
+// needed with a TTL of MaxWindow so idle windows expire
+func NewAggregator(nc *nats.Conn, bucket string) (*Aggregator, error) {
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get JetStream context: %w", err)
+	}
+	kv, err := js.KeyValue(bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket:      bucket,
+			Description: "Sliding windows of aggregation triggers",
+			TTL:         MaxWindow,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get window bucket: %w", err)
+	}
+	return &Aggregator{kv: kv, now: time.Now}, nil
+}
+
+// NewLocalAggregator creates an aggregator keeping windows in memory, for daemons
+// without JetStream. Windows are not shared between daemons and are lost on restart.
+func NewLocalAggregator() *Aggregator {
+	return &Aggregator{now: time.Now, local: make(map[string]*localWindow)}
+}
+
+// Observe counts a matching event in the trigger's window and reports whether the
+// trigger fires. Triggers without a window always fire.
+func (a *Aggregator) Observe(ctx context.Context, t *Trigger, event *cloudevents.Event) (bool, error) {
+	if t.Window == nil {
+		return true, nil
+	}
+	within, err := t.Window.Duration()
+	if err != nil {
+		return false, err
+	}
+	key, err := windowKey(t, event)
+	if err != nil {
+		return false, err
+	}
+	at := event.Time()
+	if at.IsZero() {
+		at = a.now()
+	}
+
+	if a.kv == nil {
+		return a.observeLocal(key, t.Window, within, event.ID(), at), nil
+	}
+	// Observations of this daemon take turns, so only other daemons cause conflicts
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for attempt := 0; attempt < maxWindowRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return false, ctx.Err()
+			case <-time.After(time.Duration(rand.Int63n(int64(attempt) * int64(5*time.Millisecond)))):
+			}
+		}
+		fired, err := a.observeKV(key, t.Window, within, event.ID(), at)
+		if !errors.Is(err, errWindowConflict) {
+			return fired, err
+		}
+	}
+	return false, fmt.Errorf("failed to update window of trigger %s: too many concurrent updates", t.ID)
+}
+
+// errWindowConflict is returned when another daemon updated a window first
+var errWindowConflict = errors.New("window updated concurrently")
+
+// observeKV applies an observation to a window in the bucket with compare-and-swap
+func (a *Aggregator) observeKV(key string, w *Window, within time.Duration, id string, at time.Time) (bool, error) {
+	var state windowState
+	var revision uint64
+	entry, err := a.kv.Get(key)
+	switch {
+	case errors.Is(err, nats.ErrKeyNotFound):
+	case err != nil:
+		return false, fmt.Errorf("failed to get window: %w", err)
+	default:
+		revision = entry.Revision()
+		if err := json.Unmarshal(entry.Value(), &state); err != nil {
+			return false, fmt.Errorf("failed to unmarshal window: %w", err)
+		}
+	}
+
+	fired := state.observe(w, within, id, at)
+	data, err := json.Marshal(state)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal window: %w", err)
+	}
+	if revision == 0 {
+		_, err = a.kv.Create(key, data)
+	} else {
+		_, err = a.kv.Update(key, data, revision)
+	}
+	var apiErr *nats.APIError
+	if errors.Is(err, nats.ErrKeyExists) || errors.As(err, &apiErr) && apiErr.ErrorCode == nats.JSErrCodeStreamWrongLastSequence {
+		return false, errWindowConflict
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to update window: %w", err)
+	}
+	return fired, nil
+}
+
+// observeLocal applies an observation to an in-memory window, expiring idle windows
+// as the bucket would
+func (a *Aggregator) observeLocal(key string, w *Window, within time.Duration, id string, at time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	for k, lw := range a.local {
+		if now.Sub(lw.updated) > MaxWindow {
+			delete(a.local, k)
+		}
+	}
+	lw, ok := a.local[key]
+	if !ok {
+		lw = &localWindow{}
+		a.local[key] = lw
+	}
+	lw.updated = now
+	return lw.state.observe(w, within, id, at)
+}
+
+// windowKey returns the key of the window an event counts in: the trigger ID and,
+// for grouped windows, a hash of the group
+func windowKey(t *Trigger, event *cloudevents.Event) (string, error) {
+	if t.Window.GroupBy == "" {
+		return t.ID, nil
+	}
+	env, err := newExprEnv(event, t.Vars)
+	if err != nil {
+		return "", err
+	}
+	program, err := expr.Compile(t.Window.GroupBy, exprOptions(env)...)
+	if err != nil {
+		return "", fmt.Errorf("failed to compile window group of trigger %s: %w", t.ID, err)
+	}
+	group, err := expr.Run(program, env)
+	if err != nil {
+		return "", fmt.Errorf("failed to evaluate window group of trigger %s: %w", t.ID, err)
+	}
+	return fmt.Sprintf("%s.%016x", t.ID, hashKey(fmt.Sprint(group))), nil
+}
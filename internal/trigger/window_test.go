@@ -0,0 +1,139 @@
+package trigger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loginFailed returns a failed login event of a user at a time
+func loginFailed(id, user string, at time.Time) *cloudevents.Event {
+	e := cloudevents.NewEvent()
+	e.SetID(id)
+	e.SetSource("test")
+	e.SetType("auth.login.failed")
+	e.SetTime(at)
+	e.SetExtension("actorid", user)
+	return &e
+}
+
+// TestWindowValidation tests the window checks of trigger validation
+func TestWindowValidation(t *testing.T) {
+	_, err := ParseYAML([]byte("id: brute-force\nwindow:\n  count: 5\n  within: 10m\n  group_by: event.actor.id\n"))
+	assert.NoError(t, err)
+
+	_, err = ParseYAML([]byte("id: brute-force\nwindow:\n  count: 5\n"))
+	assert.ErrorContains(t, err, "window.within: required field is missing")
+
+	_, err = ParseYAML([]byte("id: brute-force\nwindow:\n  count: 5\n  within: ten minutes\n"))
+	assert.ErrorContains(t, err, "window.within")
+
+	_, err = ParseYAML([]byte("id: brute-force\nwindow:\n  count: 0\n  within: 48h\n  group_by: event.actor.id ==\n"))
+	var errs ValidationErrors
+	require.ErrorAs(t, err, &errs)
+	assert.Len(t, errs, 3)
+
+	trig := &Trigger{ID: "brute-force", Window: &Window{Count: 5, Within: "10m"}}
+	assert.NoError(t, trig.Validate())
+	trig.Window.Within = "25h"
+	assert.ErrorContains(t, trig.Validate(), "at most 24h")
+}
+
+// TestLocalAggregator tests sliding windows grouped by an expression
+func TestLocalAggregator(t *testing.T) {
+	trig := &Trigger{ID: "brute-force", Window: &Window{Count: 3, Within: "10m", GroupBy: "event.actor.id"}}
+	a := NewLocalAggregator()
+	ctx := context.Background()
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	observe := func(id, user string, offset time.Duration) bool {
+		fired, err := a.Observe(ctx, trig, loginFailed(id, user, start.Add(offset)))
+		require.NoError(t, err)
+		return fired
+	}
+
+	assert.False(t, observe("1", "alice", 0))
+	assert.False(t, observe("2", "alice", time.Minute))
+	assert.False(t, observe("2", "alice", time.Minute), "a redelivered event counts once")
+	assert.False(t, observe("3", "bob", 2*time.Minute), "other groups have their own window")
+	assert.True(t, observe("4", "alice", 3*time.Minute))
+
+	// The window starts over after firing
+	assert.False(t, observe("5", "alice", 4*time.Minute))
+	assert.False(t, observe("6", "alice", 5*time.Minute))
+
+	// Events older than the window slide out
+	assert.False(t, observe("7", "alice", 14*time.Minute+30*time.Second))
+	assert.False(t, observe("8", "alice", 16*time.Minute))
+	assert.True(t, observe("9", "alice", 17*time.Minute))
+
+	// Triggers without a window always fire
+	fired, err := a.Observe(ctx, &Trigger{ID: "plain"}, loginFailed("10", "alice", start))
+	require.NoError(t, err)
+	assert.True(t, fired)
+}
+
+// TestAggregatorKV tests windows shared by daemons through a KV bucket
+func TestAggregatorKV(t *testing.T) {
+	// Skip if NATS is not available
+	nc, err := nats.Connect("nats://localhost:4222")
+	if err != nil {
+		t.Skip("NATS server not available, skipping integration test")
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	if _, err := js.AccountInfo(); err != nil {
+		t.Skip("JetStream not available, skipping integration test")
+	}
+
+	bucket := "window-test-" + uuid.NewString()[:8]
+	defer js.DeleteKeyValue(bucket)
+
+	a, err := NewAggregator(nc, bucket)
+	require.NoError(t, err)
+	b, err := NewAggregator(nc, bucket)
+	require.NoError(t, err)
+
+	trig := &Trigger{ID: "brute-force", Window: &Window{Count: 2, Within: "10m", GroupBy: "event.actor.id"}}
+	ctx := context.Background()
+	now := time.Now()
+
+	fired, err := a.Observe(ctx, trig, loginFailed("1", "alice", now))
+	require.NoError(t, err)
+	assert.False(t, fired)
+	fired, err = b.Observe(ctx, trig, loginFailed("2", "bob", now))
+	require.NoError(t, err)
+	assert.False(t, fired)
+	fired, err = b.Observe(ctx, trig, loginFailed("3", "alice", now.Add(time.Second)))
+	require.NoError(t, err)
+	assert.True(t, fired, "the second daemon sees the window of the first")
+
+	// Concurrent observations of one window are all counted
+	trig = &Trigger{ID: "burst", Window: &Window{Count: 20, Within: "1m"}}
+	results := make(chan bool, 20)
+	for i := 0; i < 20; i++ {
+		agg := a
+		if i%2 == 1 {
+			agg = b
+		}
+		go func(i int, agg *Aggregator) {
+			fired, err := agg.Observe(ctx, trig, loginFailed(uuid.NewString(), "alice", now))
+			assert.NoError(t, err)
+			results <- fired
+		}(i, agg)
+	}
+	fires := 0
+	for i := 0; i < 20; i++ {
+		if <-results {
+			fires++
+		}
+	}
+	assert.Equal(t, 1, fires)
+}
@@ -0,0 +1,187 @@
+package function
+
+import (
+	"context"
+	"time"
+
+	mevent "github.com/julianshen/mycelium/internal/event"
+	"github.com/julianshen/mycelium/internal/function"
+
+	ce "github.com/cloudevents/sdk-go/v2"
+	"github.com/nats-io/nats.go"
+)
+
+// ClientConfig configures a Client
+type ClientConfig struct {
+	NATSURL  string
+	Registry Registry
+	Timeout  time.Duration
+	// OfflineBuffer enables store-and-forward delivery while NATS is unreachable (optional)
+	OfflineBuffer *OfflineBufferConfig
+	// Conn reuses an existing connection instead of dialing NATSURL (optional).
+	// The client does not close a shared connection.
+	Conn *nats.Conn
+	// ResultSubject is the subject prefix SubscribeResults listens on (default: DefaultResultSubject)
+	ResultSubject string
+	// Clusters lists the NATS URLs of further clusters that invocations fail over to
+	// when the primary cluster is unreachable or has no runtime listening (optional)
+	Clusters []string
+	// HealthCheckInterval is how often clusters are probed
+	HealthCheckInterval time.Duration
+	// StickyRouting keeps invoking a function on the cluster that last served it
+	// for as long as that cluster stays healthy
+	StickyRouting bool
+	// ClaimCheck offloads event data above a size threshold to a JetStream object
+	// store and resolves claim-checked results (optional)
+	ClaimCheck *ClaimCheckConfig
+	// Group is the runtime group invocations are sent to
+	Group string
+	// GossipRouting sends invocations on the primary cluster to a runtime instance that
+	// announced having the function loaded
+	GossipRouting bool
+}
+
+// OfflineBufferConfig configures the client's store-and-forward buffer
+type OfflineBufferConfig struct {
+	Path       string        // Path of the bolt database file
+	MaxEntries int           // Maximum number of buffered messages
+	MaxAge     time.Duration // Buffered messages older than this are discarded
+	// MaxAttempts discards an invocation no runtime answered after this many flushes
+	MaxAttempts int
+	// RetryInterval is how long a flush that found no runtime waits before it retries
+	RetryInterval time.Duration
+}
+
+// ClaimCheckConfig configures the offloading of large event data to an object store
+type ClaimCheckConfig struct {
+	// Bucket is the object store payloads are kept in (default: DefaultClaimCheckBucket)
+	Bucket string
+	// Threshold is the data size in bytes above which payloads are offloaded
+	Threshold int
+	// TTL is how long offloaded payloads are kept; consumers must resolve events within it
+	TTL time.Duration
+}
+
+// Client invokes functions through NATS
+type Client struct {
+	client *function.Client
+}
+
+// NewClient creates a function client
+func NewClient(cfg ClientConfig) (*Client, error) {
+	converted := function.ClientConfig{
+		NATSURL:             cfg.NATSURL,
+		Registry:            toInternalRegistry(cfg.Registry),
+		Timeout:             cfg.Timeout,
+		Conn:                cfg.Conn,
+		ResultSubject:       cfg.ResultSubject,
+		Clusters:            cfg.Clusters,
+		HealthCheckInterval: cfg.HealthCheckInterval,
+		StickyRouting:       cfg.StickyRouting,
+		Group:               cfg.Group,
+		GossipRouting:       cfg.GossipRouting,
+	}
+	if cfg.OfflineBuffer != nil {
+		offline := function.OfflineBufferConfig(*cfg.OfflineBuffer)
+		converted.OfflineBuffer = &offline
+	}
+	if cfg.ClaimCheck != nil {
+		claims := mevent.ClaimCheckConfig(*cfg.ClaimCheck)
+		converted.ClaimCheck = &claims
+	}
+
+	client, err := function.NewClient(converted)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{client: client}, nil
+}
+
+// InvokeFunction invokes a function with an event and returns the events it produced.
+// The time left until the context's deadline is the function's time budget. A function
+// returning a partial result yields its events together with a *PartialResultError.
+// name may be a name@version reference, see InvokeFunctionVersion.
+func (c *Client) InvokeFunction(ctx context.Context, name string, event *ce.Event) ([]*ce.Event, error) {
+	events, err := c.client.InvokeFunction(ctx, name, event)
+	return events, publicError(err)
+}
+
+// InvokeFunctionVersion invokes a version of a function retained by the registry. An
+// empty or "latest" version invokes the current version like InvokeFunction.
+func (c *Client) InvokeFunctionVersion(ctx context.Context, name, version string, event *ce.Event) ([]*ce.Event, error) {
+	events, err := c.client.InvokeFunctionVersion(ctx, name, version, event)
+	return events, publicError(err)
+}
+
+// InvokeFunctionAsync invokes a function without waiting for its output, which
+// SubscribeResults receives
+func (c *Client) InvokeFunctionAsync(ctx context.Context, name string, event *ce.Event) error {
+	return c.client.InvokeFunctionAsync(ctx, name, event)
+}
+
+// PublishEvent publishes a CloudEvent to the given subject
+func (c *Client) PublishEvent(ctx context.Context, subject string, event *ce.Event) error {
+	return c.client.PublishEvent(ctx, subject, event)
+}
+
+// SubscribeResults subscribes to the output events of a function, or of every function for "*"
+func (c *Client) SubscribeResults(functionName string) (*ResultSubscription, error) {
+	sub, err := c.client.SubscribeResults(functionName)
+	if err != nil {
+		return nil, err
+	}
+	return &ResultSubscription{sub: sub}, nil
+}
+
+// FlushOffline delivers buffered events and invocations in the order they were queued
+// and returns how many were delivered
+func (c *Client) FlushOffline() (int, error) {
+	return c.client.FlushOffline()
+}
+
+// PendingOffline returns the number of messages waiting in the offline buffer
+func (c *Client) PendingOffline() int {
+	return c.client.PendingOffline()
+}
+
+// Close closes the client
+func (c *Client) Close() {
+	c.client.Close()
+}
+
+// ResultSubscription delivers the output events of a function
+type ResultSubscription struct {
+	sub *function.ResultSubscription
+}
+
+// Events returns the channel result events are delivered on. It is closed by Unsubscribe.
+func (s *ResultSubscription) Events() <-chan *ce.Event {
+	return s.sub.Events()
+}
+
+// Unsubscribe stops the subscription and closes the events channel
+func (s *ResultSubscription) Unsubscribe() error {
+	return s.sub.Unsubscribe()
+}
+
+// InvalidEventError is returned when the runtime rejects an event that is not a valid
+// CloudEvent, listing its violations
+type InvalidEventError struct {
+	EventID    string      `json:"event_id,omitempty"`
+	Violations []Violation `json:"violations"`
+}
+
+func (e *InvalidEventError) Error() string {
+	return toInternalInvalidEvent(e).Error()
+}
+
+// Violation is an attribute of an event that breaks the CloudEvents spec
+type Violation struct {
+	Attribute string `json:"attribute"`
+	Rule      string `json:"rule"`
+	Message   string `json:"message"`
+}
+
+func (v Violation) String() string {
+	return mevent.Violation(v).String()
+}
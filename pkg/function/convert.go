@@ -0,0 +1,106 @@
+package function
+
+import (
+	"errors"
+	"maps"
+	"slices"
+
+	mevent "github.com/julianshen/mycelium/internal/event"
+	"github.com/julianshen/mycelium/internal/function"
+)
+
+// toInternalMeta converts metadata to the implementation's type
+func toInternalMeta(meta FunctionMeta) function.FunctionMeta {
+	return function.FunctionMeta{
+		Name:          meta.Name,
+		Type:          meta.Type,
+		Version:       meta.Version,
+		Config:        maps.Clone(meta.Config),
+		EventTypes:    slices.Clone(meta.EventTypes),
+		EventSources:  slices.Clone(meta.EventSources),
+		Emits:         slices.Clone(meta.Emits),
+		Digest:        meta.Digest,
+		SchemaVersion: meta.SchemaVersion,
+	}.WithUnknownFields(meta.unknown)
+}
+
+// fromInternalMeta converts metadata of the implementation
+func fromInternalMeta(meta function.FunctionMeta) FunctionMeta {
+	return FunctionMeta{
+		Name:          meta.Name,
+		Type:          meta.Type,
+		Version:       meta.Version,
+		Config:        maps.Clone(meta.Config),
+		EventTypes:    slices.Clone(meta.EventTypes),
+		EventSources:  slices.Clone(meta.EventSources),
+		Emits:         slices.Clone(meta.Emits),
+		Digest:        meta.Digest,
+		SchemaVersion: meta.SchemaVersion,
+		unknown:       meta.UnknownFields(),
+	}
+}
+
+// fromInternalMetas converts a list of metadata of the implementation
+func fromInternalMetas(metas []function.FunctionMeta) []FunctionMeta {
+	if metas == nil {
+		return nil
+	}
+	converted := make([]FunctionMeta, len(metas))
+	for i, meta := range metas {
+		converted[i] = fromInternalMeta(meta)
+	}
+	return converted
+}
+
+// toInternalInvalidEvent converts an invalid event error to the implementation's type
+func toInternalInvalidEvent(e *InvalidEventError) *mevent.InvalidEventError {
+	converted := &mevent.InvalidEventError{EventID: e.EventID}
+	for _, v := range e.Violations {
+		converted.Violations = append(converted.Violations, mevent.Violation(v))
+	}
+	return converted
+}
+
+// publicError converts the error types of the implementation. Errors wrapping them
+// keep their message and chain, and also unwrap to the converted errors.
+func publicError(err error) error {
+	var public error
+	var partial *function.PartialResultError
+	var invalid *mevent.InvalidEventError
+	var deployment *function.DeploymentError
+	switch {
+	case errors.As(err, &partial):
+		if error(partial) == err {
+			return &PartialResultError{Reason: partial.Reason}
+		}
+		public = &PartialResultError{Reason: partial.Reason}
+	case errors.As(err, &invalid):
+		converted := &InvalidEventError{EventID: invalid.EventID}
+		for _, v := range invalid.Violations {
+			converted.Violations = append(converted.Violations, Violation(v))
+		}
+		public = converted
+	case errors.As(err, &deployment):
+		if error(deployment) == err {
+			return (*DeploymentError)(deployment)
+		}
+		public = (*DeploymentError)(deployment)
+	default:
+		return err
+	}
+	return &convertedError{err: err, public: public}
+}
+
+// convertedError is an implementation error that also unwraps to its public counterpart
+type convertedError struct {
+	err    error
+	public error
+}
+
+func (e *convertedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *convertedError) Unwrap() []error {
+	return []error{e.public, e.err}
+}
@@ -0,0 +1,231 @@
+// Package function is the public API of Mycelium functions: the Function interface
+// plugins implement, the plugin ABI they are served over, function registries, and
+// the client that invokes functions through NATS.
+//
+// The identifiers of this package follow semantic versioning: they are not removed
+// or changed incompatibly within a major version. Its types are defined here and
+// converted to and from the implementation in
+// github.com/julianshen/mycelium/internal/function, which may change in any release.
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	mevent "github.com/julianshen/mycelium/internal/event"
+	"github.com/julianshen/mycelium/internal/function"
+
+	ce "github.com/cloudevents/sdk-go/v2"
+	"github.com/hashicorp/go-plugin"
+)
+
+// Function is implemented by every function
+type Function interface {
+	// Execute processes the incoming event and returns zero or more events
+	Execute(ctx context.Context, event *ce.Event) ([]*ce.Event, error)
+}
+
+// Plugin is a loaded function plugin
+type Plugin interface {
+	// Name returns the name of the plugin
+	Name() string
+	// Version returns the version of the plugin
+	Version() string
+	// Type returns the type of the plugin
+	Type() string
+	// Function returns the function implementation
+	Function() Function
+}
+
+// FunctionMeta is the metadata of a function
+type FunctionMeta struct {
+	Name    string            `json:"name"`
+	Type    string            `json:"type"`
+	Version string            `json:"version"`
+	Config  map[string]string `json:"config,omitempty"`
+	// EventTypes and EventSources restrict the events the function accepts ("*" wildcards allowed, empty accepts all)
+	EventTypes   []string `json:"eventTypes,omitempty"`
+	EventSources []string `json:"eventSources,omitempty"`
+	// Emits declares the event types the function returns, for event flow graphs
+	Emits []string `json:"emits,omitempty"`
+	// Digest is the SHA-256 of the function binary, set by registries that store binaries by content
+	Digest string `json:"digest,omitempty"`
+	// SchemaVersion is the serialization format version the metadata was stored in, set
+	// by registries when reading (see MetaSchemaVersion). Writes always use the current one.
+	SchemaVersion int `json:"schemaVersion,omitempty"`
+	// unknown holds stored fields this release does not know, written back unchanged
+	unknown map[string]json.RawMessage
+}
+
+// StateStore is the state functions keep between invocations
+type StateStore interface {
+	// Get returns the value and revision stored under key
+	Get(ctx context.Context, key string) ([]byte, uint64, error)
+	// Put stores value under key and returns the new revision
+	Put(ctx context.Context, key string, value []byte) (uint64, error)
+	// Delete removes key
+	Delete(ctx context.Context, key string) error
+	// CompareAndSwap stores value only if key is at the expected revision.
+	// A revision of 0 means the key must not exist yet.
+	CompareAndSwap(ctx context.Context, key string, value []byte, revision uint64) (uint64, error)
+}
+
+// Errors of StateStore
+var (
+	ErrStateKeyNotFound = function.ErrStateKeyNotFound
+	ErrStateConflict    = function.ErrStateConflict
+	ErrStateUnavailable = function.ErrStateUnavailable
+)
+
+// PartialResultError is returned by functions that stop before finishing, together
+// with the events produced so far, see PartialResult. Clients return it with the
+// events of a partial result.
+type PartialResultError struct {
+	Reason string
+}
+
+func (e *PartialResultError) Error() string {
+	return (&function.PartialResultError{Reason: e.Reason}).Error()
+}
+
+// PartialReason returns the reason of the partial result, which is how the runtime
+// recognizes it
+func (e *PartialResultError) PartialReason() string {
+	return e.Reason
+}
+
+// Function types
+const (
+	TypeScript  = function.TypeScript
+	TypeBuiltin = function.TypeBuiltin
+)
+
+// Plugin ABI versions and features, see PluginABIFeatures
+const (
+	PluginABIv1           = function.PluginABIv1
+	PluginABIv2           = function.PluginABIv2
+	MinPluginABIVersion   = function.MinPluginABIVersion
+	PluginABIVersion      = function.PluginABIVersion
+	FeatureMultipleEvents = function.FeatureMultipleEvents
+)
+
+// MetaSchemaVersion is the version of the FunctionMeta serialization format registries write
+const MetaSchemaVersion = function.MetaSchemaVersion
+
+// Default buckets and subjects
+const (
+	DefaultFunctionBucket   = function.DefaultFunctionBucket
+	DefaultBinaryBucket     = function.DefaultBinaryBucket
+	DefaultResultSubject    = function.DefaultResultSubject
+	DefaultFlagBucket       = function.DefaultFlagBucket
+	DefaultClaimCheckBucket = mevent.DefaultClaimCheckBucket
+)
+
+// LatestVersion refers to the registry's current version of a function
+const LatestVersion = function.LatestVersion
+
+// ErrVersionNotFound is returned when no retained revision of a function has the requested version
+var ErrVersionNotFound = function.ErrVersionNotFound
+
+// ErrUnsupportedMetaVersion is returned when writing back metadata of a newer schema version
+var ErrUnsupportedMetaVersion = function.ErrUnsupportedMetaVersion
+
+// ErrFunctionTimeout is returned when an invocation runs past the function's timeout
+var ErrFunctionTimeout = function.ErrFunctionTimeout
+
+// PluginHandshake is the handshake plugins are served with
+var PluginHandshake = function.PluginHandshake
+
+// PluginSecret is the handshake value of the deployment a plugin is built for, set at
+// build time with -ldflags "-X github.com/julianshen/mycelium/pkg/function.PluginSecret=<value>",
+// which functionctl build does from MYCELIUM_PLUGIN_SECRET. Plugins built with a value
+// only run under runtimes configured with the same RuntimeServiceConfig.PluginSecret.
+// The value is embedded in the binary, so it keeps deployments apart but protects nothing.
+var PluginSecret string
+
+// NewPluginSecret generates a random plugin handshake value for a deployment
+func NewPluginSecret() (string, error) {
+	return function.NewPluginSecret()
+}
+
+// PluginABIFeatures returns the features an ABI version guarantees
+func PluginABIFeatures(version int) []string {
+	return function.PluginABIFeatures(version)
+}
+
+// PluginSets returns the plugin sets of every ABI version the runtime implements
+func PluginSets(impl Function) map[int]plugin.PluginSet {
+	return function.PluginSets(impl)
+}
+
+// Serve serves a function as a plugin over every ABI version the runtime implements,
+// with the handshake of PluginSecret. It is called from the main function of a plugin
+// binary and does not return.
+func Serve(impl Function) {
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig:  function.PluginHandshakeWithSecret(PluginSecret),
+		VersionedPlugins: PluginSets(impl),
+		GRPCServer:       plugin.DefaultGRPCServer,
+	})
+}
+
+// StateFromContext returns the state store of the invoked function. Plugin functions
+// reach it through the runtime while Execute runs; ErrStateUnavailable is returned when
+// the runtime has no state bucket.
+func StateFromContext(ctx context.Context) (StateStore, error) {
+	state, err := function.StateFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// Remaining returns the time left until the invocation's deadline; ok is false
+// without a deadline
+func Remaining(ctx context.Context) (time.Duration, bool) {
+	return function.Remaining(ctx)
+}
+
+// DeadlineNear reports whether less than margin is left until the invocation's deadline
+func DeadlineNear(ctx context.Context, margin time.Duration) bool {
+	return function.DeadlineNear(ctx, margin)
+}
+
+// Heartbeat reports the progress of a long-running invocation to the runtime's watchdog,
+// also from plugin functions
+func Heartbeat(ctx context.Context, progress string) {
+	function.Heartbeat(ctx, progress)
+}
+
+// FlagEnabled reports whether a feature flag of the invoked function is set to a true
+// value. Plugin functions read the flags of the invocation from the runtime.
+func FlagEnabled(ctx context.Context, name string) bool {
+	return function.FlagEnabled(ctx, name)
+}
+
+// FlagValue returns the value of a feature flag of the invoked function, or fallback
+// when it is not set
+func FlagValue(ctx context.Context, name, fallback string) string {
+	return function.FlagValue(ctx, name, fallback)
+}
+
+// FlagKey returns the key a function's flag is stored under in the flag bucket
+func FlagKey(functionName, flag string) string {
+	return function.FlagKey(functionName, flag)
+}
+
+// PartialResult is returned by functions with the events they produced before stopping early
+func PartialResult(reason string) error {
+	return &PartialResultError{Reason: reason}
+}
+
+// FunctionRef returns the reference invoking a version of a function, name@version
+func FunctionRef(name, version string) string {
+	return function.FunctionRef(name, version)
+}
+
+// ParseFunctionRef splits a function reference into the function name and version
+func ParseFunctionRef(ref string) (name, version string) {
+	return function.ParseFunctionRef(ref)
+}
@@ -0,0 +1,93 @@
+package function_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	mfunction "github.com/julianshen/mycelium/internal/function"
+	"github.com/julianshen/mycelium/pkg/function"
+
+	ce "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echo is a function implemented outside the runtime
+type echo struct{}
+
+func (echo) Execute(ctx context.Context, event *ce.Event) ([]*ce.Event, error) {
+	return []*ce.Event{event}, nil
+}
+
+// TestPublicAPI tests implementing, serving and storing functions through the public package
+func TestPublicAPI(t *testing.T) {
+	var fn function.Function = echo{}
+
+	sets := function.PluginSets(fn)
+	for version := function.MinPluginABIVersion; version <= function.PluginABIVersion; version++ {
+		assert.Contains(t, sets, version)
+	}
+	assert.Contains(t, function.PluginABIFeatures(function.PluginABIv2), function.FeatureMultipleEvents)
+
+	registry, err := function.NewFileRegistry(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, registry.StoreFunction(function.FunctionMeta{Name: "echo", Type: "go", Version: "1.0.0"}, []byte("binary")))
+	meta, binary, err := registry.GetFunction("echo")
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.0", meta.Version)
+	assert.Equal(t, []byte("binary"), binary)
+}
+
+// TestTypesMatchImplementation tests that the public types keep every field of the
+// implementation's types, so conversions do not drop any
+func TestTypesMatchImplementation(t *testing.T) {
+	for _, types := range [][2]any{
+		{function.FunctionMeta{}, mfunction.FunctionMeta{}},
+		{function.FunctionDeployment{}, mfunction.FunctionDeployment{}},
+		{function.DeploymentError{}, mfunction.DeploymentError{}},
+		{function.OfflineBufferConfig{}, mfunction.OfflineBufferConfig{}},
+		{function.PartialResultError{}, mfunction.PartialResultError{}},
+	} {
+		public, internal := reflect.TypeOf(types[0]), reflect.TypeOf(types[1])
+		require.Equal(t, internal.NumField(), public.NumField(), public.Name())
+		for i := range internal.NumField() {
+			assert.Equal(t, internal.Field(i).Name, public.Field(i).Name, public.Name())
+			assert.Equal(t, internal.Field(i).Tag, public.Field(i).Tag, public.Name())
+		}
+	}
+}
+
+// TestRegistryKeepsUnknownFields tests that metadata stored by a newer release keeps
+// the fields this release does not know when written back through the public registry
+func TestRegistryKeepsUnknownFields(t *testing.T) {
+	dir := t.TempDir()
+	registry, err := function.NewFileRegistry(dir)
+	require.NoError(t, err)
+	require.NoError(t, registry.StoreFunction(function.FunctionMeta{Name: "echo", Type: "go", Version: "1.0.0"}, []byte("binary")))
+
+	path := filepath.Join(dir, "echo.json")
+	var stored map[string]any
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &stored))
+	stored["owner"] = "team-a"
+	data, err = json.Marshal(stored)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	meta, binary, err := registry.GetFunction("echo")
+	require.NoError(t, err)
+	meta.Version = "1.1.0"
+	require.NoError(t, registry.StoreFunction(meta, binary))
+
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	stored = nil
+	require.NoError(t, json.Unmarshal(data, &stored))
+	assert.Equal(t, "1.1.0", stored["version"])
+	assert.Equal(t, "team-a", stored["owner"])
+}
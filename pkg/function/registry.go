@@ -0,0 +1,184 @@
+package function
+
+import (
+	"github.com/julianshen/mycelium/internal/function"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Registry stores and retrieves function metadata and binaries
+type Registry interface {
+	// StoreFunction stores a function's metadata and binary
+	StoreFunction(meta FunctionMeta, binary []byte) error
+	// GetFunction retrieves a function's metadata and binary
+	GetFunction(name string) (FunctionMeta, []byte, error)
+	// ListFunctions returns a list of all available functions
+	ListFunctions() ([]FunctionMeta, error)
+	// DeleteFunction removes a function
+	DeleteFunction(name string) error
+	// DeployFunctions stores a set of functions all-or-nothing
+	DeployFunctions(deployments []FunctionDeployment) error
+}
+
+// VersionedRegistry is implemented by registries that keep previous versions of functions
+type VersionedRegistry interface {
+	// FunctionVersions returns the retained versions of a function, the current one
+	// first and the others newest first
+	FunctionVersions(name string) ([]FunctionMeta, error)
+	// GetFunctionVersion retrieves a retained version of a function
+	GetFunctionVersion(name, version string) (FunctionMeta, []byte, error)
+}
+
+// FunctionDeployment is a function stored by Registry.DeployFunctions
+type FunctionDeployment struct {
+	Meta   FunctionMeta
+	Binary []byte
+}
+
+// DeploymentError reports which function made a deployment fail and whether rolling back succeeded
+type DeploymentError struct {
+	Function    string
+	Err         error
+	RollbackErr error
+}
+
+func (e *DeploymentError) Error() string {
+	return (*function.DeploymentError)(e).Error()
+}
+
+func (e *DeploymentError) Unwrap() error {
+	return e.Err
+}
+
+// NewRegistry creates a registry storing metadata and binaries in the default NATS buckets
+func NewRegistry(nc *nats.Conn) (Registry, error) {
+	return newRegistry(function.NewNATSRegistry(nc))
+}
+
+// NewRegistryWithBuckets creates a NATS registry scoped to a metadata KV bucket and
+// binary object store, e.g. those provisioned for a namespace
+func NewRegistryWithBuckets(nc *nats.Conn, functionBucket, binaryBucket string) (Registry, error) {
+	return newRegistry(function.NewNATSRegistryWithBuckets(nc, functionBucket, binaryBucket))
+}
+
+// NewFileRegistry creates a registry storing functions in a directory
+func NewFileRegistry(dir string) (Registry, error) {
+	return newRegistry(function.NewFileRegistry(dir))
+}
+
+// OpenRegistry returns the registry for a transport mode: the NATS registry when the
+// server offers JetStream, and a file registry in dir on a bare NATS server
+func OpenRegistry(nc *nats.Conn, mode, dir string) (Registry, error) {
+	return newRegistry(function.OpenRegistry(nc, mode, dir))
+}
+
+// newRegistry wraps a registry created by the implementation. Registries keeping
+// previous versions also implement VersionedRegistry.
+func newRegistry[R function.Registry](r R, err error) (Registry, error) {
+	if err != nil {
+		return nil, err
+	}
+	if versioned, ok := function.Registry(r).(function.VersionedRegistry); ok {
+		return &versionedRegistry{registry: registry{registry: r}, versioned: versioned}, nil
+	}
+	return &registry{registry: r}, nil
+}
+
+// registry is a Registry of the implementation, converting metadata at the boundary
+type registry struct {
+	registry function.Registry
+}
+
+func (r *registry) StoreFunction(meta FunctionMeta, binary []byte) error {
+	return r.registry.StoreFunction(toInternalMeta(meta), binary)
+}
+
+func (r *registry) GetFunction(name string) (FunctionMeta, []byte, error) {
+	meta, binary, err := r.registry.GetFunction(name)
+	return fromInternalMeta(meta), binary, err
+}
+
+func (r *registry) ListFunctions() ([]FunctionMeta, error) {
+	metas, err := r.registry.ListFunctions()
+	return fromInternalMetas(metas), err
+}
+
+func (r *registry) DeleteFunction(name string) error {
+	return r.registry.DeleteFunction(name)
+}
+
+func (r *registry) DeployFunctions(deployments []FunctionDeployment) error {
+	converted := make([]function.FunctionDeployment, len(deployments))
+	for i, d := range deployments {
+		converted[i] = function.FunctionDeployment{Meta: toInternalMeta(d.Meta), Binary: d.Binary}
+	}
+	return publicError(r.registry.DeployFunctions(converted))
+}
+
+// versionedRegistry is a registry of the implementation keeping previous versions
+type versionedRegistry struct {
+	registry
+	versioned function.VersionedRegistry
+}
+
+func (r *versionedRegistry) FunctionVersions(name string) ([]FunctionMeta, error) {
+	metas, err := r.versioned.FunctionVersions(name)
+	return fromInternalMetas(metas), err
+}
+
+func (r *versionedRegistry) GetFunctionVersion(name, version string) (FunctionMeta, []byte, error) {
+	meta, binary, err := r.versioned.GetFunctionVersion(name, version)
+	return fromInternalMeta(meta), binary, err
+}
+
+// toInternalRegistry returns the implementation's view of a registry: the registry it
+// wraps, or an adapter converting the metadata of a registry implemented elsewhere
+func toInternalRegistry(r Registry) function.Registry {
+	switch wrapped := r.(type) {
+	case nil:
+		return nil
+	case *registry:
+		return wrapped.registry
+	case *versionedRegistry:
+		return wrapped.registry.registry
+	}
+	return &registryAdapter{registry: r}
+}
+
+// registryAdapter is a Registry implemented outside this module, seen by the implementation
+type registryAdapter struct {
+	registry Registry
+}
+
+func (r *registryAdapter) StoreFunction(meta function.FunctionMeta, binary []byte) error {
+	return r.registry.StoreFunction(fromInternalMeta(meta), binary)
+}
+
+func (r *registryAdapter) GetFunction(name string) (function.FunctionMeta, []byte, error) {
+	meta, binary, err := r.registry.GetFunction(name)
+	return toInternalMeta(meta), binary, err
+}
+
+func (r *registryAdapter) ListFunctions() ([]function.FunctionMeta, error) {
+	metas, err := r.registry.ListFunctions()
+	if metas == nil {
+		return nil, err
+	}
+	converted := make([]function.FunctionMeta, len(metas))
+	for i, meta := range metas {
+		converted[i] = toInternalMeta(meta)
+	}
+	return converted, err
+}
+
+func (r *registryAdapter) DeleteFunction(name string) error {
+	return r.registry.DeleteFunction(name)
+}
+
+func (r *registryAdapter) DeployFunctions(deployments []function.FunctionDeployment) error {
+	converted := make([]FunctionDeployment, len(deployments))
+	for i, d := range deployments {
+		converted[i] = FunctionDeployment{Meta: fromInternalMeta(d.Meta), Binary: d.Binary}
+	}
+	return r.registry.DeployFunctions(converted)
+}
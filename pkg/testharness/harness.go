@@ -0,0 +1,463 @@
+// Package testharness runs the Mycelium pipeline in-process, so integration tests of
+// triggers and functions run in CI in milliseconds without external servers. A
+// harness wires an embedded NATS server with JetStream, an in-memory trigger store,
+// an in-memory function registry, a function runtime serving Go functions, and the
+// trigger daemon's event pipeline: events published to the harness are matched
+// against its triggers and their actions run as they would under triggerd.
+//
+//	h := testharness.New(t, testharness.Config{
+//		Functions: map[string]function.Function{"notify": &Notify{}},
+//		Triggers:  []*trigger.Trigger{{ID: "on-create", EventType: "user.created", Criteria: "true", Enabled: true, Action: "function:notify"}},
+//	})
+//	require.NoError(t, h.Publish(ctx, testharness.NewEvent("prod.user.created", user)))
+//	results, err := h.WaitResults(ctx, 1)
+package testharness
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/action"
+	"github.com/julianshen/mycelium/internal/event"
+	mfunction "github.com/julianshen/mycelium/internal/function"
+	mtrigger "github.com/julianshen/mycelium/internal/trigger"
+	"github.com/julianshen/mycelium/pkg/function"
+	"github.com/julianshen/mycelium/pkg/trigger"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// Harness defaults
+const (
+	// DefaultStream is the stream the pipeline consumes events from
+	DefaultStream = "HARNESS_EVENTS"
+	// DefaultSubjectPrefix is the prefix events are published under, as <prefix>.<type>
+	DefaultSubjectPrefix = "events"
+	// DefaultStartTimeout bounds waiting for the embedded server to accept connections
+	DefaultStartTimeout = 5 * time.Second
+)
+
+// harnessBuiltin is the builtin implementation the runtime serves the harness's functions with
+const harnessBuiltin = "testharness"
+
+// Result is the outcome of an action run by the pipeline
+type Result struct {
+	TriggerID  string `json:"trigger_id"`
+	EventID    string `json:"event_id"`
+	Action     string `json:"action"`
+	Status     string `json:"status"`
+	Output     string `json:"output,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// Action result statuses
+const (
+	StatusSucceeded = action.StatusSucceeded
+	StatusFailed    = action.StatusFailed
+	StatusSkipped   = action.StatusSkipped
+)
+
+// ActionFunc runs the actions of matched triggers that are neither function nor
+// webhook actions and returns their output
+type ActionFunc func(ctx context.Context, t *trigger.Trigger, event *cloudevents.Event) (string, error)
+
+// Config configures a harness. The zero value runs an empty pipeline.
+type Config struct {
+	// Functions are registered in the registry and served by the runtime by name, so
+	// triggers reach them with "function:<name>" actions
+	Functions map[string]function.Function
+	// Triggers are saved in the store before events are consumed
+	Triggers []*trigger.Trigger
+	// Actions runs the other actions (default: they are logged like by action.LogExecutor)
+	Actions ActionFunc
+	// Secrets are the secrets actions resolve by name, e.g. webhook tokens
+	Secrets map[string]string
+}
+
+// Harness is an in-process Mycelium pipeline
+type Harness struct {
+	// URL is the client URL of the embedded NATS server
+	URL string
+	// Conn is connected to the embedded server
+	Conn *nats.Conn
+	// Client invokes functions on the runtime
+	Client *function.Client
+
+	// triggers is the store events are matched against
+	triggers mtrigger.TriggerStore
+	// registry holds the metadata of the functions the runtime serves
+	registry mfunction.Registry
+	runtime  *mfunction.RuntimeService
+
+	server    *server.Server
+	storeDir  string
+	watcher   *event.Watcher
+	resources *action.Resources
+	cancel    context.CancelFunc
+	functions map[string]function.Function
+	results   []Result
+	// changed is closed and replaced whenever a result is recorded
+	changed chan struct{}
+	mu      sync.Mutex
+}
+
+// New starts a harness for a test and closes it when the test ends. The test fails
+// if the harness cannot be started.
+func New(tb testing.TB, cfg Config) *Harness {
+	tb.Helper()
+	h, err := Start(cfg)
+	if err != nil {
+		tb.Fatalf("failed to start test harness: %v", err)
+	}
+	tb.Cleanup(h.Close)
+	return h
+}
+
+// Start starts a harness, e.g. in TestMain; the caller closes it
+func Start(cfg Config) (*Harness, error) {
+	h := &Harness{
+		functions: make(map[string]function.Function),
+		changed:   make(chan struct{}),
+	}
+	if err := h.start(cfg); err != nil {
+		h.Close()
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *Harness) start(cfg Config) error {
+	var err error
+	h.storeDir, err = os.MkdirTemp("", "mycelium-testharness-")
+	if err != nil {
+		return fmt.Errorf("failed to create JetStream directory: %w", err)
+	}
+	h.server, err = server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  h.storeDir,
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create NATS server: %w", err)
+	}
+	go h.server.Start()
+	if !h.server.ReadyForConnections(DefaultStartTimeout) {
+		return fmt.Errorf("NATS server not ready after %s", DefaultStartTimeout)
+	}
+	h.URL = h.server.ClientURL()
+
+	h.Conn, err = nats.Connect(h.URL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	js, err := h.Conn.JetStream()
+	if err != nil {
+		return fmt.Errorf("failed to get JetStream context: %w", err)
+	}
+	_, err = js.AddStream(&nats.StreamConfig{
+		Name:     DefaultStream,
+		Subjects: []string{DefaultSubjectPrefix + ".>"},
+		Storage:  nats.MemoryStorage,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create event stream: %w", err)
+	}
+
+	// Functions are served as builtins resolved from the harness, so functions added
+	// later are served too
+	h.registry = mfunction.NewMemoryRegistry(mfunction.MemoryRegistryConfig{})
+	for name, fn := range cfg.Functions {
+		if err := h.AddFunction(name, fn); err != nil {
+			return err
+		}
+	}
+	h.runtime, err = mfunction.NewRuntimeService(mfunction.RuntimeServiceConfig{
+		Conn:          h.Conn,
+		Registry:      h.registry,
+		Metrics:       &mfunction.SimpleMetricsCollector{},
+		Logger:        &mfunction.SimpleLogger{},
+		StreamResults: true,
+		Builtins: map[string]func(meta mfunction.FunctionMeta) (mfunction.Function, error){
+			harnessBuiltin: h.function,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create function runtime: %w", err)
+	}
+	if err := h.runtime.Start(); err != nil {
+		return fmt.Errorf("failed to start function runtime: %w", err)
+	}
+	h.Client, err = function.NewClient(function.ClientConfig{Conn: h.Conn})
+	if err != nil {
+		return fmt.Errorf("failed to create function client: %w", err)
+	}
+
+	h.triggers = mtrigger.NewMemoryStore()
+	for _, t := range cfg.Triggers {
+		if err := h.AddTrigger(t); err != nil {
+			return err
+		}
+	}
+
+	return h.startPipeline(cfg)
+}
+
+// startPipeline consumes the event stream like triggerd: events are matched against
+// the triggers and the actions of matches run through the guard
+func (h *Harness) startPipeline(cfg Config) error {
+	var other action.Executor = action.LogExecutor{}
+	if cfg.Actions != nil {
+		other = action.ExecutorFunc(func(ctx context.Context, t *mtrigger.Trigger, e *cloudevents.Event) (string, error) {
+			public, err := convertTrigger[trigger.Trigger](t)
+			if err != nil {
+				return "", err
+			}
+			return cfg.Actions(ctx, public, e)
+		})
+	}
+	executor := action.Router{
+		Function: action.NewFunctionExecutor(h.Client, action.FunctionExecutorConfig{}),
+		Webhook:  action.NewWebhookExecutor(action.WebhookExecutorConfig{}),
+		Default:  other,
+	}
+	aggregator := mtrigger.NewLocalAggregator()
+	guard := &action.Guard{Concurrency: action.NewConcurrencyLimiter()}
+
+	var ctx context.Context
+	ctx, h.cancel = context.WithCancel(context.Background())
+	h.resources = action.NewResources(h.Conn)
+	ec := &action.ExecutionContext{Secrets: action.StaticSecrets(cfg.Secrets), Resources: h.resources}
+	if err := action.Initialize(ctx, executor, ec); err != nil {
+		return fmt.Errorf("failed to initialize action executors: %w", err)
+	}
+	runAction := func(t *mtrigger.Trigger, e *cloudevents.Event) {
+		h.record(guard.Run(ctx, executor, t, e))
+	}
+	handler := func(e *cloudevents.Event) error {
+		matched, err := mtrigger.FindMatchingTriggers(ctx, h.triggers, e)
+		if err != nil {
+			return err
+		}
+		for _, t := range matched {
+			fire, err := aggregator.Observe(ctx, t, e)
+			if err != nil {
+				log.Printf("Error counting event %s in the window of trigger %s: %v", e.ID(), t.Name, err)
+				continue
+			}
+			if !fire {
+				continue
+			}
+			if t.Concurrency != nil {
+				go runAction(t, e)
+				continue
+			}
+			runAction(t, e)
+		}
+		return nil
+	}
+
+	var err error
+	h.watcher, err = event.NewWatcher(event.WatcherConfig{
+		URL:           h.URL,
+		StreamName:    DefaultStream,
+		Subject:       DefaultSubjectPrefix + ".>",
+		DurableName:   "testharness",
+		AckWait:       30 * time.Second,
+		MaxDeliveries: 1,
+	}, handler)
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %w", err)
+	}
+	if err := h.watcher.Start(ctx); err != nil {
+		return fmt.Errorf("failed to start watcher: %w", err)
+	}
+	return nil
+}
+
+// function resolves the Go function served for a function's metadata
+func (h *Harness) function(meta mfunction.FunctionMeta) (mfunction.Function, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fn, ok := h.functions[meta.Name]
+	if !ok {
+		return nil, fmt.Errorf("function %s not found", meta.Name)
+	}
+	return fn, nil
+}
+
+// AddFunction registers a Go function served by the runtime under a name. The
+// runtime keeps functions it has loaded, so a name is not served by another function
+// after it was invoked.
+func (h *Harness) AddFunction(name string, fn function.Function) error {
+	h.mu.Lock()
+	h.functions[name] = fn
+	h.mu.Unlock()
+
+	meta := mfunction.FunctionMeta{
+		Name:    name,
+		Version: "test",
+		Type:    mfunction.TypeBuiltin,
+		Config:  map[string]string{mfunction.ConfigBuiltin: harnessBuiltin},
+	}
+	if err := h.registry.StoreFunction(meta, nil); err != nil {
+		return fmt.Errorf("failed to register function %s: %w", name, err)
+	}
+	return nil
+}
+
+// AddTrigger saves a trigger under its ID; events published afterwards are matched against it
+func (h *Harness) AddTrigger(t *trigger.Trigger) error {
+	converted, err := convertTrigger[mtrigger.Trigger](t)
+	if err != nil {
+		return fmt.Errorf("failed to convert trigger %s: %w", t.ID, err)
+	}
+	if err := h.triggers.SaveTrigger(context.Background(), "default", t.ID, converted); err != nil {
+		return fmt.Errorf("failed to save trigger %s: %w", t.ID, err)
+	}
+	return nil
+}
+
+// convertTrigger converts between the public and the pipeline's trigger type through
+// the trigger definition format they share
+func convertTrigger[To any](t any) (*To, error) {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	var converted To
+	if err := json.Unmarshal(data, &converted); err != nil {
+		return nil, err
+	}
+	return &converted, nil
+}
+
+// Publish publishes an event to the pipeline under <DefaultSubjectPrefix>.<type>
+func (h *Harness) Publish(ctx context.Context, e *cloudevents.Event) error {
+	data, err := e.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	js, err := h.Conn.JetStream()
+	if err != nil {
+		return fmt.Errorf("failed to get JetStream context: %w", err)
+	}
+	if _, err := js.Publish(DefaultSubjectPrefix+"."+e.Type(), data, nats.Context(ctx)); err != nil {
+		return fmt.Errorf("failed to publish event: %w", err)
+	}
+	return nil
+}
+
+// Invoke invokes a function on the runtime directly, bypassing the triggers
+func (h *Harness) Invoke(ctx context.Context, name string, e *cloudevents.Event) ([]*cloudevents.Event, error) {
+	return h.Client.InvokeFunction(ctx, name, e)
+}
+
+// record records the result of an action and wakes up waiters
+func (h *Harness) record(result action.Result) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.results = append(h.results, Result{
+		TriggerID:  result.TriggerID,
+		EventID:    result.EventID,
+		Action:     result.Action,
+		Status:     result.Status,
+		Output:     result.Output,
+		Error:      result.Error,
+		DurationMs: result.DurationMs,
+	})
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// Results returns the results of the actions run so far, in the order they completed
+func (h *Harness) Results() []Result {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Result(nil), h.results...)
+}
+
+// WaitResults waits until at least n actions ran and returns their results. It
+// returns the results so far with ctx's error when ctx is done first.
+func (h *Harness) WaitResults(ctx context.Context, n int) ([]Result, error) {
+	for {
+		h.mu.Lock()
+		results := append([]Result(nil), h.results...)
+		changed := h.changed
+		h.mu.Unlock()
+		if len(results) >= n {
+			return results, nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return results, fmt.Errorf("%d of %d results: %w", len(results), n, ctx.Err())
+		}
+	}
+}
+
+// Close stops the pipeline, the runtime and the embedded server and removes its data
+func (h *Harness) Close() {
+	if h.watcher != nil {
+		h.watcher.Stop()
+	}
+	if h.cancel != nil {
+		h.cancel()
+	}
+	if h.resources != nil {
+		h.resources.Close()
+	}
+	if h.Client != nil {
+		h.Client.Close()
+	}
+	if h.runtime != nil {
+		if err := h.runtime.Stop(); err != nil && !errors.Is(err, nats.ErrConnectionClosed) {
+			log.Printf("Error stopping function runtime: %v", err)
+		}
+	}
+	if h.triggers != nil {
+		h.triggers.Close()
+	}
+	if h.Conn != nil {
+		h.Conn.Close()
+	}
+	if h.server != nil {
+		h.server.Shutdown()
+		h.server.WaitForShutdown()
+	}
+	if h.storeDir != "" {
+		os.RemoveAll(h.storeDir)
+	}
+}
+
+// NewEvent builds an event of a type carrying after as data.after, like the change
+// events Mycelium consumes. The namespace is the first token of the type, e.g. prod
+// of prod.user.created.
+func NewEvent(eventType string, after interface{}) *cloudevents.Event {
+	e := cloudevents.NewEvent()
+	e.SetID(uuid.NewString())
+	e.SetSource("mycelium/testharness")
+	e.SetType(eventType)
+	e.SetTime(time.Now())
+	if after != nil {
+		// Only values that cannot be marshaled fail, which is a bug in the test
+		if err := e.SetData(cloudevents.ApplicationJSON, map[string]interface{}{"after": after}); err != nil {
+			panic(fmt.Sprintf("testharness: invalid event data: %v", err))
+		}
+	}
+	return &e
+}
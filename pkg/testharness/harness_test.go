@@ -0,0 +1,126 @@
+package testharness_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julianshen/mycelium/pkg/function"
+	"github.com/julianshen/mycelium/pkg/testharness"
+	"github.com/julianshen/mycelium/pkg/trigger"
+
+	ce "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// greet answers user events with a greeting event
+type greet struct{}
+
+func (greet) Execute(ctx context.Context, event *ce.Event) ([]*ce.Event, error) {
+	var data struct {
+		After struct {
+			Name string `json:"name"`
+		} `json:"after"`
+	}
+	if err := event.DataAs(&data); err != nil {
+		return nil, err
+	}
+	greeting := ce.NewEvent()
+	greeting.SetID(event.ID() + "-greeting")
+	greeting.SetSource("greet")
+	greeting.SetType("prod.user.greeted")
+	if err := greeting.SetData(ce.ApplicationJSON, map[string]string{"greeting": "Hello " + data.After.Name}); err != nil {
+		return nil, err
+	}
+	return []*ce.Event{&greeting}, nil
+}
+
+// TestPipeline tests matching published events and running function and other
+// actions without external servers
+func TestPipeline(t *testing.T) {
+	var notified []string
+	h := testharness.New(t, testharness.Config{
+		Functions: map[string]function.Function{"greet": greet{}},
+		Triggers: []*trigger.Trigger{
+			{ID: "greet-admins", Namespaces: []string{"prod"}, EventType: "user.created", Criteria: `event.data.after.role == "admin"`, Enabled: true, Action: "function:greet"},
+		},
+		Actions: func(ctx context.Context, t *trigger.Trigger, event *ce.Event) (string, error) {
+			notified = append(notified, event.ID())
+			return "notified", nil
+		},
+	})
+	require.NoError(t, h.AddTrigger(&trigger.Trigger{ID: "notify", Namespaces: []string{"prod"}, EventType: "user.deleted", Criteria: "true", Enabled: true, Action: "notify"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	greetings, err := h.Client.SubscribeResults("greet")
+	require.NoError(t, err)
+	defer greetings.Unsubscribe()
+
+	require.NoError(t, h.Publish(ctx, testharness.NewEvent("prod.user.created", map[string]string{"name": "Bob", "role": "user"})))
+	admin := testharness.NewEvent("prod.user.created", map[string]string{"name": "Alice", "role": "admin"})
+	require.NoError(t, h.Publish(ctx, admin))
+	deleted := testharness.NewEvent("prod.user.deleted", map[string]string{"name": "Bob"})
+	require.NoError(t, h.Publish(ctx, deleted))
+
+	results, err := h.WaitResults(ctx, 2)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "greet-admins", results[0].TriggerID)
+	assert.Equal(t, admin.ID(), results[0].EventID)
+	assert.Equal(t, testharness.StatusSucceeded, results[0].Status)
+	assert.Equal(t, "notify", results[1].TriggerID)
+	assert.Equal(t, testharness.StatusSucceeded, results[1].Status)
+	assert.Equal(t, []string{deleted.ID()}, notified)
+
+	select {
+	case greeting := <-greetings.Events():
+		assert.True(t, strings.Contains(string(greeting.Data()), "Hello Alice"))
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the greeting")
+	}
+
+	// Functions are also invoked directly
+	events, err := h.Invoke(ctx, "greet", admin)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "prod.user.greeted", events[0].Type())
+}
+
+// partial stops early with the events it produced
+type partial struct{}
+
+func (partial) Execute(ctx context.Context, event *ce.Event) ([]*ce.Event, error) {
+	return []*ce.Event{event}, function.PartialResult("budget exhausted")
+}
+
+// TestPartialResult tests that the runtime serves partial results of public functions
+// and the client returns them as the public error
+func TestPartialResult(t *testing.T) {
+	h := testharness.New(t, testharness.Config{
+		Functions: map[string]function.Function{"partial": partial{}},
+		Triggers: []*trigger.Trigger{
+			{ID: "partial", Namespaces: []string{"prod"}, EventType: "user.created", Criteria: "true", Enabled: true, Action: "function:partial"},
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	created := testharness.NewEvent("prod.user.created", map[string]string{"name": "Alice"})
+	events, err := h.Invoke(ctx, "partial", created)
+	var partialErr *function.PartialResultError
+	require.True(t, errors.As(err, &partialErr), "unexpected error: %v", err)
+	assert.Equal(t, "budget exhausted", partialErr.Reason)
+	assert.Len(t, events, 1)
+
+	require.NoError(t, h.Publish(ctx, created))
+	results, err := h.WaitResults(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, testharness.StatusSucceeded, results[0].Status)
+	assert.Contains(t, results[0].Output, "partial: budget exhausted")
+}
@@ -0,0 +1,173 @@
+package trigger
+
+import (
+	"errors"
+	"maps"
+	"slices"
+
+	"github.com/julianshen/mycelium/internal/trigger"
+)
+
+// toInternal converts a trigger to the implementation's type
+func toInternal(t *Trigger) *trigger.Trigger {
+	if t == nil {
+		return nil
+	}
+	converted := &trigger.Trigger{
+		ID:            t.ID,
+		Name:          t.Name,
+		Namespaces:    slices.Clone(t.Namespaces),
+		ObjectType:    t.ObjectType,
+		EventType:     t.EventType,
+		Criteria:      t.Criteria,
+		Dialect:       t.Dialect,
+		Description:   t.Description,
+		Enabled:       t.Enabled,
+		Action:        t.Action,
+		Vars:          maps.Clone(t.Vars),
+		Except:        t.Except,
+		IgnoreReplays: t.IgnoreReplays,
+		Labels:        maps.Clone(t.Labels),
+		Timeout:       t.Timeout,
+	}
+	if t.Window != nil {
+		window := trigger.Window(*t.Window)
+		converted.Window = &window
+	}
+	if t.Concurrency != nil {
+		concurrency := trigger.Concurrency(*t.Concurrency)
+		converted.Concurrency = &concurrency
+	}
+	for _, branch := range t.Branches {
+		converted.Branches = append(converted.Branches, trigger.Branch(branch))
+	}
+	return converted
+}
+
+// fromInternal converts a trigger of the implementation
+func fromInternal(t *trigger.Trigger) *Trigger {
+	if t == nil {
+		return nil
+	}
+	converted := &Trigger{
+		ID:            t.ID,
+		Name:          t.Name,
+		Namespaces:    slices.Clone(t.Namespaces),
+		ObjectType:    t.ObjectType,
+		EventType:     t.EventType,
+		Criteria:      t.Criteria,
+		Dialect:       t.Dialect,
+		Description:   t.Description,
+		Enabled:       t.Enabled,
+		Action:        t.Action,
+		Vars:          maps.Clone(t.Vars),
+		Except:        t.Except,
+		IgnoreReplays: t.IgnoreReplays,
+		Labels:        maps.Clone(t.Labels),
+		Timeout:       t.Timeout,
+	}
+	if t.Window != nil {
+		window := Window(*t.Window)
+		converted.Window = &window
+	}
+	if t.Concurrency != nil {
+		concurrency := Concurrency(*t.Concurrency)
+		converted.Concurrency = &concurrency
+	}
+	for _, branch := range t.Branches {
+		converted.Branches = append(converted.Branches, Branch(branch))
+	}
+	return converted
+}
+
+// toInternalAll converts a list of triggers to the implementation's type
+func toInternalAll(triggers []*Trigger) []*trigger.Trigger {
+	if triggers == nil {
+		return nil
+	}
+	converted := make([]*trigger.Trigger, len(triggers))
+	for i, t := range triggers {
+		converted[i] = toInternal(t)
+	}
+	return converted
+}
+
+// fromInternalAll converts a list of triggers of the implementation
+func fromInternalAll(triggers []*trigger.Trigger) []*Trigger {
+	if triggers == nil {
+		return nil
+	}
+	converted := make([]*Trigger, len(triggers))
+	for i, t := range triggers {
+		converted[i] = fromInternal(t)
+	}
+	return converted
+}
+
+// toInternalTemplate converts a template to the implementation's type
+func toInternalTemplate(tmpl *TriggerTemplate) *trigger.TriggerTemplate {
+	converted := &trigger.TriggerTemplate{
+		ID:          tmpl.ID,
+		Name:        tmpl.Name,
+		Description: tmpl.Description,
+		Trigger:     *toInternal(&tmpl.Trigger),
+	}
+	for _, param := range tmpl.Params {
+		converted.Params = append(converted.Params, trigger.TemplateParam(param))
+	}
+	return converted
+}
+
+// fromInternalTemplate converts a template of the implementation
+func fromInternalTemplate(tmpl *trigger.TriggerTemplate) *TriggerTemplate {
+	converted := &TriggerTemplate{
+		ID:          tmpl.ID,
+		Name:        tmpl.Name,
+		Description: tmpl.Description,
+		Trigger:     *fromInternal(&tmpl.Trigger),
+	}
+	for _, param := range tmpl.Params {
+		converted.Params = append(converted.Params, TemplateParam(param))
+	}
+	return converted
+}
+
+// toInternalErrors converts validation errors to the implementation's type
+func toInternalErrors(errs ValidationErrors) trigger.ValidationErrors {
+	converted := make(trigger.ValidationErrors, len(errs))
+	for i, err := range errs {
+		converted[i] = trigger.ValidationError(err)
+	}
+	return converted
+}
+
+// publicError converts the validation errors of the implementation. Errors wrapping
+// them keep their message and chain, and also unwrap to the converted errors.
+func publicError(err error) error {
+	var errs trigger.ValidationErrors
+	if !errors.As(err, &errs) {
+		return err
+	}
+	converted := make(ValidationErrors, len(errs))
+	for i, err := range errs {
+		converted[i] = ValidationError(err)
+	}
+	if _, ok := err.(trigger.ValidationErrors); ok {
+		return converted
+	}
+	return &convertedError{err: err, public: converted}
+}
+
+// convertedError is an implementation error that also unwraps to its public counterpart
+type convertedError struct {
+	err    error
+	public error
+}
+
+func (e *convertedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *convertedError) Unwrap() []error {
+	return []error{e.public, e.err}
+}
@@ -0,0 +1,193 @@
+package trigger
+
+import (
+	"context"
+
+	"github.com/julianshen/mycelium/internal/trigger"
+
+	"github.com/nats-io/nats.go"
+)
+
+// ListOptions controls paginated trigger listing
+type ListOptions struct {
+	After  string // Cursor: only triggers whose ID sorts after it are listed, e.g. the last ID of the previous page
+	Offset int    // Number of triggers to skip
+	Limit  int    // Maximum number of triggers to return, 0 means no limit
+}
+
+// TriggerStore stores triggers and indexes them for matching.
+// Every call takes a context and returns its error once the context is done.
+// Stores do not own the connection they are created with: Close releases the
+// store's own resources, such as its watch, and leaves the connection open.
+type TriggerStore interface {
+	// LoadAll loads all triggers from the store
+	LoadAll(ctx context.Context) error
+
+	// Watch starts watching for changes to triggers. It returns once the watch is
+	// established; changes are applied in the background until ctx is cancelled or
+	// the store is closed.
+	Watch(ctx context.Context) error
+
+	// GetTriggers returns all triggers for a namespace
+	GetTriggers(ctx context.Context, namespace string) ([]*Trigger, error)
+
+	// GetTriggersForEvent returns the triggers for a namespace whose event type matches or is empty
+	GetTriggersForEvent(ctx context.Context, namespace, eventType string) ([]*Trigger, error)
+
+	// GetAllTriggers returns all triggers from all namespaces
+	GetAllTriggers(ctx context.Context) ([]*Trigger, error)
+
+	// ListTriggers returns a page of triggers ordered by ID along with the total trigger count
+	ListTriggers(ctx context.Context, opts ListOptions) ([]*Trigger, int, error)
+
+	// ForEachTrigger calls fn for every trigger ordered by ID until fn returns false
+	ForEachTrigger(ctx context.Context, fn func(*Trigger) bool) error
+
+	// SaveTrigger saves a trigger to the store
+	SaveTrigger(ctx context.Context, namespace, name string, trigger *Trigger) error
+
+	// DeleteTrigger deletes a trigger from the store
+	DeleteTrigger(ctx context.Context, namespace, name string) error
+
+	// Close stops the store's background work; it does not close the connection
+	Close() error
+}
+
+// NewNATSStore creates a trigger store backed by a NATS KV bucket
+func NewNATSStore(nc *nats.Conn, bucketName string) (TriggerStore, error) {
+	return newStore(trigger.NewNATSStore(nc, bucketName))
+}
+
+// NewReadOnlyNATSStore creates a trigger store following a NATS KV bucket without writing to it
+func NewReadOnlyNATSStore(nc *nats.Conn, bucketName string) (TriggerStore, error) {
+	return newStore(trigger.NewReadOnlyNATSStore(nc, bucketName))
+}
+
+// NewFileStore creates a trigger store backed by YAML files in a directory
+func NewFileStore(dir string) (TriggerStore, error) {
+	return newStore(trigger.NewFileStore(dir))
+}
+
+// NewMemoryStore creates a trigger store kept in memory, e.g. for tests
+func NewMemoryStore() TriggerStore {
+	return &store{store: trigger.NewMemoryStore()}
+}
+
+// newStore wraps a store created by the implementation
+func newStore[S trigger.TriggerStore](s S, err error) (TriggerStore, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &store{store: s}, nil
+}
+
+// store is a TriggerStore of the implementation, converting triggers at the boundary
+type store struct {
+	store trigger.TriggerStore
+}
+
+func (s *store) LoadAll(ctx context.Context) error {
+	return s.store.LoadAll(ctx)
+}
+
+func (s *store) Watch(ctx context.Context) error {
+	return s.store.Watch(ctx)
+}
+
+func (s *store) GetTriggers(ctx context.Context, namespace string) ([]*Trigger, error) {
+	triggers, err := s.store.GetTriggers(ctx, namespace)
+	return fromInternalAll(triggers), err
+}
+
+func (s *store) GetTriggersForEvent(ctx context.Context, namespace, eventType string) ([]*Trigger, error) {
+	triggers, err := s.store.GetTriggersForEvent(ctx, namespace, eventType)
+	return fromInternalAll(triggers), err
+}
+
+func (s *store) GetAllTriggers(ctx context.Context) ([]*Trigger, error) {
+	triggers, err := s.store.GetAllTriggers(ctx)
+	return fromInternalAll(triggers), err
+}
+
+func (s *store) ListTriggers(ctx context.Context, opts ListOptions) ([]*Trigger, int, error) {
+	triggers, total, err := s.store.ListTriggers(ctx, trigger.ListOptions(opts))
+	return fromInternalAll(triggers), total, err
+}
+
+func (s *store) ForEachTrigger(ctx context.Context, fn func(*Trigger) bool) error {
+	return s.store.ForEachTrigger(ctx, func(t *trigger.Trigger) bool {
+		return fn(fromInternal(t))
+	})
+}
+
+func (s *store) SaveTrigger(ctx context.Context, namespace, name string, t *Trigger) error {
+	return publicError(s.store.SaveTrigger(ctx, namespace, name, toInternal(t)))
+}
+
+func (s *store) DeleteTrigger(ctx context.Context, namespace, name string) error {
+	return s.store.DeleteTrigger(ctx, namespace, name)
+}
+
+func (s *store) Close() error {
+	return s.store.Close()
+}
+
+// toInternalStore returns the implementation's view of a store: the store it wraps,
+// or an adapter converting the triggers of a store implemented elsewhere
+func toInternalStore(s TriggerStore) trigger.TriggerStore {
+	if wrapped, ok := s.(*store); ok {
+		return wrapped.store
+	}
+	return &storeAdapter{store: s}
+}
+
+// storeAdapter is a TriggerStore implemented outside this module, seen by the implementation
+type storeAdapter struct {
+	store TriggerStore
+}
+
+func (s *storeAdapter) LoadAll(ctx context.Context) error {
+	return s.store.LoadAll(ctx)
+}
+
+func (s *storeAdapter) Watch(ctx context.Context) error {
+	return s.store.Watch(ctx)
+}
+
+func (s *storeAdapter) GetTriggers(ctx context.Context, namespace string) ([]*trigger.Trigger, error) {
+	triggers, err := s.store.GetTriggers(ctx, namespace)
+	return toInternalAll(triggers), err
+}
+
+func (s *storeAdapter) GetTriggersForEvent(ctx context.Context, namespace, eventType string) ([]*trigger.Trigger, error) {
+	triggers, err := s.store.GetTriggersForEvent(ctx, namespace, eventType)
+	return toInternalAll(triggers), err
+}
+
+func (s *storeAdapter) GetAllTriggers(ctx context.Context) ([]*trigger.Trigger, error) {
+	triggers, err := s.store.GetAllTriggers(ctx)
+	return toInternalAll(triggers), err
+}
+
+func (s *storeAdapter) ListTriggers(ctx context.Context, opts trigger.ListOptions) ([]*trigger.Trigger, int, error) {
+	triggers, total, err := s.store.ListTriggers(ctx, ListOptions(opts))
+	return toInternalAll(triggers), total, err
+}
+
+func (s *storeAdapter) ForEachTrigger(ctx context.Context, fn func(*trigger.Trigger) bool) error {
+	return s.store.ForEachTrigger(ctx, func(t *Trigger) bool {
+		return fn(toInternal(t))
+	})
+}
+
+func (s *storeAdapter) SaveTrigger(ctx context.Context, namespace, name string, t *trigger.Trigger) error {
+	return s.store.SaveTrigger(ctx, namespace, name, fromInternal(t))
+}
+
+func (s *storeAdapter) DeleteTrigger(ctx context.Context, namespace, name string) error {
+	return s.store.DeleteTrigger(ctx, namespace, name)
+}
+
+func (s *storeAdapter) Close() error {
+	return s.store.Close()
+}
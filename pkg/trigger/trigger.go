@@ -0,0 +1,299 @@
+// Package trigger is the public API of Mycelium triggers: trigger definitions and
+// templates, their validation, the stores that hold them, and matching events
+// against them.
+//
+// The identifiers of this package follow semantic versioning: they are not removed
+// or changed incompatibly within a major version. Its types are defined here and
+// converted to and from the implementation in
+// github.com/julianshen/mycelium/internal/trigger, which may change in any release.
+package trigger
+
+import (
+	"context"
+	"time"
+
+	"github.com/julianshen/mycelium/internal/trigger"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/nats-io/nats.go"
+	"gopkg.in/yaml.v3"
+)
+
+// Trigger is a trigger definition. Its JSON and YAML form is the trigger schema
+// returned by Schema.
+type Trigger struct {
+	ID         string   `json:"id" yaml:"id"`
+	Name       string   `json:"name" yaml:"name"`
+	Namespaces []string `json:"namespaces,omitempty" yaml:"namespaces,omitempty"` // List of namespace patterns to match, "*" means all namespaces
+	ObjectType string   `json:"object_type" yaml:"object_type"`
+	EventType  string   `json:"event_type" yaml:"event_type"`
+	// Criteria is an expression evaluated against the event that must be true for
+	// the trigger to match, in the expr language unless Dialect says otherwise
+	Criteria string `json:"criteria" yaml:"criteria"`
+	// Dialect is the language of the criteria and except: expr (default) or cesql
+	Dialect     string `json:"dialect,omitempty" yaml:"dialect,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Enabled     bool   `json:"enabled" yaml:"enabled"`
+	Action      string `json:"action" yaml:"action"`
+	// Vars are constants available to the criteria as vars.<name>
+	Vars map[string]interface{} `json:"vars,omitempty" yaml:"vars,omitempty"`
+	// Except is an expression evaluated like criteria that suppresses the trigger for
+	// the events it is true for
+	Except string `json:"except,omitempty" yaml:"except,omitempty"`
+	// IgnoreReplays stops the trigger from matching events republished by a replay
+	IgnoreReplays bool `json:"ignore_replays,omitempty" yaml:"ignore_replays,omitempty"`
+	// Window makes the trigger fire only when enough matching events occur within a
+	// sliding window
+	Window *Window `json:"window,omitempty" yaml:"window,omitempty"`
+	// Labels are metadata of the trigger, e.g. the owning team
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	// Timeout bounds the execution of the trigger's action, e.g. 30s
+	Timeout string `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	// Concurrency limits how many executions of the action run at once
+	Concurrency *Concurrency `json:"concurrency,omitempty" yaml:"concurrency,omitempty"`
+	// Branches pick the action per matched event, falling back to Action
+	Branches []Branch `json:"branches,omitempty" yaml:"branches,omitempty"`
+}
+
+// Window makes a trigger fire only when enough matching events occur within a sliding window
+type Window struct {
+	// Count is the number of matching events that fires the trigger
+	Count int `json:"count" yaml:"count"`
+	// Within is the length of the window, e.g. 10m
+	Within string `json:"within" yaml:"within"`
+	// GroupBy is an expression whose result keeps a window per value, e.g. event.actor.id
+	GroupBy string `json:"group_by,omitempty" yaml:"group_by,omitempty"`
+}
+
+// Concurrency limits how many executions of a trigger's action run at once
+type Concurrency struct {
+	// Max is the number of executions running at once
+	Max int `json:"max" yaml:"max"`
+	// Scope is global (default) or object, limiting the executions for each object
+	Scope string `json:"scope,omitempty" yaml:"scope,omitempty"`
+	// Key is an expression whose result identifies the object of an event in object scope
+	Key string `json:"key,omitempty" yaml:"key,omitempty"`
+	// Queue is how many matches wait for a free slot before further matches are skipped
+	Queue int `json:"queue,omitempty" yaml:"queue,omitempty"`
+}
+
+// Branch takes an action for the matched events its condition holds for
+type Branch struct {
+	// When is an expression evaluated like criteria, in the trigger's dialect
+	When string `json:"when" yaml:"when"`
+	// Action is the action taken for the events the condition holds for
+	Action string `json:"action" yaml:"action"`
+}
+
+// ToYAML marshals the trigger to YAML
+func (t *Trigger) ToYAML() ([]byte, error) {
+	return yaml.Marshal(t)
+}
+
+// FromYAML unmarshals the trigger from YAML
+func (t *Trigger) FromYAML(data []byte) error {
+	return yaml.Unmarshal(data, t)
+}
+
+// Validate checks the trigger against the trigger schema
+func (t *Trigger) Validate() error {
+	return publicError(toInternal(t).Validate())
+}
+
+// ActionTimeout returns the timeout of the trigger's action, 0 when it has none
+func (t *Trigger) ActionTimeout() (time.Duration, error) {
+	return toInternal(t).ActionTimeout()
+}
+
+// ValidationError describes a single schema violation in a trigger definition
+type ValidationError struct {
+	Line    int    // Line in the YAML source, 0 when unknown
+	Column  int    // Column in the YAML source, 0 when unknown
+	Field   string // Path of the offending field, e.g. namespaces[1]
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return trigger.ValidationError(e).Error()
+}
+
+// ValidationErrors is the list of schema violations found in a trigger definition
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	return toInternalErrors(e).Error()
+}
+
+// LifecycleEventData is the payload of a trigger lifecycle CloudEvent
+type LifecycleEventData struct {
+	Namespace string   `json:"namespace"`
+	Name      string   `json:"name"`
+	Trigger   *Trigger `json:"trigger,omitempty"`
+}
+
+// TemplateParam is a parameter of a trigger template
+type TemplateParam struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	// Default is used when the parameter is not set; parameters without a default are required
+	Default *string `json:"default,omitempty" yaml:"default,omitempty"`
+}
+
+// TriggerTemplate defines a standard policy once, to be stamped out as concrete
+// triggers. The string fields and vars of its trigger may contain {{param}}
+// placeholders, which are replaced by the values given at instantiation.
+type TriggerTemplate struct {
+	ID          string          `json:"id" yaml:"id"`
+	Name        string          `json:"name,omitempty" yaml:"name,omitempty"`
+	Description string          `json:"description,omitempty" yaml:"description,omitempty"`
+	Params      []TemplateParam `json:"params,omitempty" yaml:"params,omitempty"`
+	Trigger     Trigger         `json:"trigger" yaml:"trigger"`
+}
+
+// Validate checks the template's ID and parameters and that every placeholder
+// refers to a declared parameter
+func (t *TriggerTemplate) Validate() error {
+	return publicError(toInternalTemplate(t).Validate())
+}
+
+// Instantiate stamps out a trigger for a namespace from the given parameter values
+func (t *TriggerTemplate) Instantiate(params map[string]string, namespace string) (*Trigger, error) {
+	instance, err := toInternalTemplate(t).Instantiate(params, namespace)
+	return fromInternal(instance), publicError(err)
+}
+
+// TemplateStore stores trigger templates in a NATS KV bucket
+type TemplateStore struct {
+	store *trigger.TemplateStore
+}
+
+// SaveTemplate validates and stores a template, replacing any with the same ID
+func (s *TemplateStore) SaveTemplate(ctx context.Context, tmpl *TriggerTemplate) error {
+	return publicError(s.store.SaveTemplate(ctx, toInternalTemplate(tmpl)))
+}
+
+// GetTemplate returns a template, or ErrTemplateNotFound
+func (s *TemplateStore) GetTemplate(ctx context.Context, id string) (*TriggerTemplate, error) {
+	tmpl, err := s.store.GetTemplate(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return fromInternalTemplate(tmpl), nil
+}
+
+// ListTemplates returns every template ordered by ID
+func (s *TemplateStore) ListTemplates(ctx context.Context) ([]*TriggerTemplate, error) {
+	templates, err := s.store.ListTemplates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	public := make([]*TriggerTemplate, len(templates))
+	for i, tmpl := range templates {
+		public[i] = fromInternalTemplate(tmpl)
+	}
+	return public, nil
+}
+
+// DeleteTemplate removes a template. Triggers instantiated from it are kept.
+func (s *TemplateStore) DeleteTemplate(ctx context.Context, id string) error {
+	return s.store.DeleteTemplate(ctx, id)
+}
+
+// Aggregator keeps the windows of aggregation triggers and decides when they fire
+type Aggregator struct {
+	aggregator *trigger.Aggregator
+}
+
+// Observe counts a matching event in the trigger's window and reports whether the
+// trigger fires. Triggers without a window always fire.
+func (a *Aggregator) Observe(ctx context.Context, t *Trigger, event *cloudevents.Event) (bool, error) {
+	return a.aggregator.Observe(ctx, toInternal(t), event)
+}
+
+// Trigger lifecycle events
+const (
+	DefaultLifecycleSubject = trigger.DefaultLifecycleSubject
+	EventTypeTriggerCreated = trigger.EventTypeTriggerCreated
+	EventTypeTriggerUpdated = trigger.EventTypeTriggerUpdated
+	EventTypeTriggerDeleted = trigger.EventTypeTriggerDeleted
+)
+
+// NamespaceSeparator separates the levels of hierarchical namespaces
+const NamespaceSeparator = trigger.NamespaceSeparator
+
+// DefaultTemplateBucket is the KV bucket trigger templates are stored in
+const DefaultTemplateBucket = trigger.DefaultTemplateBucket
+
+// DefaultWindowBucket is the KV bucket aggregation windows are kept in
+const DefaultWindowBucket = trigger.DefaultWindowBucket
+
+var (
+	// ErrReadOnlyStore is returned when a read-only store is written to
+	ErrReadOnlyStore = trigger.ErrReadOnlyStore
+	// ErrTemplateNotFound is returned when a template does not exist
+	ErrTemplateNotFound = trigger.ErrTemplateNotFound
+)
+
+// NewTemplateStore creates a template store, creating the bucket if needed
+func NewTemplateStore(nc *nats.Conn, bucket string) (*TemplateStore, error) {
+	store, err := trigger.NewTemplateStore(nc, bucket)
+	if err != nil {
+		return nil, err
+	}
+	return &TemplateStore{store: store}, nil
+}
+
+// Schema returns the JSON Schema for trigger definitions
+func Schema() []byte {
+	return trigger.Schema()
+}
+
+// ValidateYAML validates a YAML trigger definition against the trigger schema
+func ValidateYAML(data []byte) error {
+	return publicError(trigger.ValidateYAML(data))
+}
+
+// ParseYAML validates a YAML trigger definition and decodes it
+func ParseYAML(data []byte) (*Trigger, error) {
+	t, err := trigger.ParseYAML(data)
+	return fromInternal(t), publicError(err)
+}
+
+// ParseTemplateYAML decodes a YAML template definition and validates it
+func ParseTemplateYAML(data []byte) (*TriggerTemplate, error) {
+	tmpl, err := trigger.ParseTemplateYAML(data)
+	if err != nil {
+		return nil, publicError(err)
+	}
+	return fromInternalTemplate(tmpl), nil
+}
+
+// EventNamespace returns the namespace of an event type, its first token
+func EventNamespace(eventType string) string {
+	return trigger.EventNamespace(eventType)
+}
+
+// MatchTrigger reports whether a trigger matches an event
+func MatchTrigger(t *Trigger, event *cloudevents.Event) (bool, error) {
+	return trigger.MatchTrigger(toInternal(t), event)
+}
+
+// FindMatchingTriggers returns the triggers of a store matching an event
+func FindMatchingTriggers(ctx context.Context, store TriggerStore, event *cloudevents.Event) ([]*Trigger, error) {
+	matched, err := trigger.FindMatchingTriggers(ctx, toInternalStore(store), event)
+	return fromInternalAll(matched), err
+}
+
+// NewAggregator creates an aggregator keeping windows in a KV bucket, creating it if needed
+func NewAggregator(nc *nats.Conn, bucket string) (*Aggregator, error) {
+	aggregator, err := trigger.NewAggregator(nc, bucket)
+	if err != nil {
+		return nil, err
+	}
+	return &Aggregator{aggregator: aggregator}, nil
+}
+
+// NewLocalAggregator creates an aggregator keeping windows in memory
+func NewLocalAggregator() *Aggregator {
+	return &Aggregator{aggregator: trigger.NewLocalAggregator()}
+}
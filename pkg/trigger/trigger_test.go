@@ -0,0 +1,108 @@
+package trigger_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	mtrigger "github.com/julianshen/mycelium/internal/trigger"
+	"github.com/julianshen/mycelium/pkg/trigger"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPublicAPI tests defining, storing and matching triggers through the public package
+func TestPublicAPI(t *testing.T) {
+	parsed, err := trigger.ParseYAML([]byte("id: created\nnamespaces: [prod]\nevent_type: user.created\ncriteria: 'true'\nenabled: true\naction: notify\n"))
+	require.NoError(t, err)
+
+	var store trigger.TriggerStore
+	store, err = trigger.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	defer store.Close()
+
+	ctx := context.Background()
+	require.NoError(t, store.SaveTrigger(ctx, "default", parsed.ID, parsed))
+	require.NoError(t, store.LoadAll(ctx))
+
+	event := cloudevents.NewEvent()
+	event.SetID("1")
+	event.SetSource("test")
+	event.SetType("prod.user.created")
+	matched, err := trigger.FindMatchingTriggers(ctx, store, &event)
+	require.NoError(t, err)
+	require.Len(t, matched, 1)
+	assert.Equal(t, "created", matched[0].ID)
+
+	var errs trigger.ValidationErrors
+	assert.ErrorAs(t, trigger.ValidateYAML([]byte("critera: true\n")), &errs)
+}
+
+// TestTypesMatchImplementation tests that the public types keep every field of the
+// implementation's types, so conversions do not drop any
+func TestTypesMatchImplementation(t *testing.T) {
+	for _, types := range [][2]any{
+		{trigger.Trigger{}, mtrigger.Trigger{}},
+		{trigger.Window{}, mtrigger.Window{}},
+		{trigger.Concurrency{}, mtrigger.Concurrency{}},
+		{trigger.Branch{}, mtrigger.Branch{}},
+		{trigger.ValidationError{}, mtrigger.ValidationError{}},
+		{trigger.LifecycleEventData{}, mtrigger.LifecycleEventData{}},
+		{trigger.TemplateParam{}, mtrigger.TemplateParam{}},
+		{trigger.TriggerTemplate{}, mtrigger.TriggerTemplate{}},
+		{trigger.ListOptions{}, mtrigger.ListOptions{}},
+	} {
+		public, internal := reflect.TypeOf(types[0]), reflect.TypeOf(types[1])
+		require.Equal(t, internal.NumField(), public.NumField(), public.Name())
+		for i := range internal.NumField() {
+			assert.Equal(t, internal.Field(i).Name, public.Field(i).Name, public.Name())
+			assert.Equal(t, internal.Field(i).Tag, public.Field(i).Tag, public.Name())
+		}
+	}
+}
+
+// TestStoreRoundTrip tests that every field of a trigger survives being stored and
+// read back through the public package
+func TestStoreRoundTrip(t *testing.T) {
+	saved := &trigger.Trigger{
+		ID:            "orders",
+		Name:          "Large orders",
+		Namespaces:    []string{"prod", "staging"},
+		ObjectType:    "order",
+		EventType:     "order.created",
+		Criteria:      "event.data.after.total > 100",
+		Description:   "Notifies sales of large orders",
+		Enabled:       true,
+		Action:        "function:notify",
+		Vars:          map[string]interface{}{"channel": "sales"},
+		Window:        &trigger.Window{Count: 5, Within: "1m", GroupBy: "event.data.after.customer"},
+		Concurrency:   &trigger.Concurrency{Max: 2, Scope: "object", Key: "event.data.after.customer", Queue: 10},
+		IgnoreReplays: true,
+		Labels:        map[string]string{"team": "sales"},
+		Timeout:       "30s",
+		Branches:      []trigger.Branch{{When: "event.data.after.total > 1000", Action: "function:escalate"}},
+	}
+	require.NoError(t, saved.Validate())
+
+	store := trigger.NewMemoryStore()
+	defer store.Close()
+	ctx := context.Background()
+	require.NoError(t, store.SaveTrigger(ctx, "default", saved.ID, saved))
+
+	loaded, err := store.GetAllTriggers(ctx)
+	require.NoError(t, err)
+	require.Len(t, loaded, 1)
+	assert.Equal(t, saved, loaded[0])
+	timeout, err := loaded[0].ActionTimeout()
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, timeout)
+
+	// Stored triggers are copies
+	loaded[0].Vars["channel"] = "support"
+	loaded, err = store.GetAllTriggers(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "sales", loaded[0].Vars["channel"])
+}